@@ -1,10 +1,32 @@
 package v1
 
+import "time"
+
 type CreateHostRequest struct {
 	// [REQUIRED]
 	HostInstance *HostInstance `json:"host_instance"`
+	// Features the host must have, see the HostFeature constants. The request fails if the
+	// instance manager can't provide them.
+	RequireFeatures []string `json:"require_features,omitempty"`
 }
 
+// Features of the hosts devices may require.
+const (
+	HostFeatureNestedVirtualization = "nested_virtualization"
+	// Followed by the accelerator type, i.e: "gpu:nvidia-tesla-t4".
+	HostFeatureGPUPrefix = "gpu:"
+	// Followed by the CPU platform, i.e: "cpu_platform:Intel Cascade Lake".
+	HostFeatureCPUPlatformPrefix = "cpu_platform:"
+	// The devices' ADB ports accept deflate compressed connections, for constrained links.
+	HostFeatureADBCompression = "adb_compression"
+)
+
+// CPU architectures of the hosts.
+const (
+	HostArchX86_64 = "x86_64"
+	HostArchARM64  = "arm64"
+)
+
 type Zone struct {
 	Name string `json:"name"`
 }
@@ -18,6 +40,25 @@ type HostInstance struct {
 	GCP *GCPInstance `json:"gcp,omitempty"`
 	// Docker specific properties.
 	Docker *DockerInstance `json:"docker,omitempty"`
+	// [Output Only] Capacity of the host, absent if the instance manager doesn't report it.
+	Capacity *HostCapacity `json:"capacity,omitempty"`
+	// [Output Only] Features of the host, see the HostFeature constants. Absent if the instance
+	// manager doesn't report them.
+	Features []string `json:"features,omitempty"`
+	// [Output Only] CPU architecture of the host, see the HostArch constants. Absent if the
+	// instance manager doesn't report it.
+	Arch string `json:"arch,omitempty"`
+	// [Output Only] Address of the host in an overlay network, like the Tailscale or WireGuard
+	// mesh of the users. Absent if the host isn't in one.
+	OverlayAddress string `json:"overlay_address,omitempty"`
+}
+
+type HostCapacity struct {
+	// Maximum number of Cuttlefish instances the host is able to run.
+	MaxInstances int64 `json:"max_instances"`
+	// Free disk space in MB available to new instances, zero if the instance manager doesn't
+	// report it.
+	FreeDiskMB int64 `json:"free_disk_mb,omitempty"`
 }
 
 type DockerInstance struct {
@@ -35,6 +76,8 @@ type GCPInstance struct {
 	MinCPUPlatform string `json:"min_cpu_platform"`
 	// List of accelerator configurations.
 	AcceleratorConfigs []*AcceleratorConfig `json:"accelerator_configs,omitempty"`
+	// [Output Only] Zone the VM instance runs in, i.e: "us-central1-a".
+	Zone string `json:"zone,omitempty"`
 }
 
 type AcceleratorConfig struct {
@@ -80,7 +123,120 @@ type ListHostsResponse struct {
 	NextPageToken string `json:"nextPageToken,omitempty"`
 }
 
+type CreateShareLinkRequest struct {
+	// [REQUIRED] Time in seconds until the link expires.
+	TTLSeconds int64 `json:"ttl_seconds"`
+}
+
+// A time-limited link granting access to a device's display to anyone holding it, the access
+// token is part of the url.
+type ShareLink struct {
+	URL string `json:"url"`
+	// [Output Only] Expiration time in RFC 3339 format.
+	ExpireTime string `json:"expire_time"`
+}
+
+// Replaces a partition of a running device with an image previously uploaded to the host, the device
+// reboots afterwards.
+type FlashPartitionRequest struct {
+	// [REQUIRED] Name of the partition, i.e: "system".
+	Partition string `json:"partition"`
+	// [REQUIRED] User artifacts directory in the host containing the image.
+	UserArtifactsDir string `json:"user_artifacts_dir"`
+	// [REQUIRED] Name of the image file within the directory.
+	ImageName string `json:"image_name"`
+}
+
+// Updates a running device to another build of its target with an OTA package the host fetches
+// from the build server, the device reboots into the new build.
+type ApplyOTARequest struct {
+	// [REQUIRED] Identifier of the build to update to.
+	BuildID string `json:"build_id"`
+	// [REQUIRED] Build target of the device, the package is fetched for it.
+	Target string `json:"target"`
+	// Applies the full OTA package instead of the incremental one from the device's current build.
+	Full bool `json:"full,omitempty"`
+}
+
+// Stages of an OTA update, reported in the progress of its operation.
+const (
+	OTAStageFetching  = "fetching"
+	OTAStageApplying  = "applying"
+	OTAStageRebooting = "rebooting"
+)
+
+type OTAProgress struct {
+	// One of the OTA stages.
+	Stage string `json:"stage"`
+	// Completion of the stage, from 0 to 100. Zero if the stage doesn't report it.
+	Percent int `json:"percent,omitempty"`
+}
+
 // To be separated in to new file if the config needs to contain intormation other than instance manager
 type Config struct {
 	InstanceManagerType string `json:"instance_manager_type"`
 }
+
+// Versions of the service and of its API. Clients speaking an API version between
+// `MinClientAPIVersion` and `APIVersion` are served.
+type ServerInfoResponse struct {
+	Version string `json:"version"`
+	// Empty if the service wasn't built from a git checkout.
+	Revision string `json:"revision,omitempty"`
+	// Latest API version the service speaks.
+	APIVersion int `json:"api_version"`
+	// Oldest API version the service still serves clients of.
+	MinClientAPIVersion int `json:"min_client_api_version"`
+}
+
+// The user authenticated by the account manager.
+type WhoAmIResponse struct {
+	Username string `json:"username"`
+	// Empty if the account manager doesn't know it.
+	Email string `json:"email,omitempty"`
+	// Type of the account manager that authenticated the user.
+	AccountManagerType string `json:"account_manager_type"`
+	// Whether the user authorized access to the Build API.
+	BuildAPIAuthorized bool `json:"build_api_authorized"`
+	// Expiry of the Build API access token, nil if not authorized or if it doesn't expire.
+	BuildAPITokenExpiry *time.Time `json:"build_api_token_expiry,omitempty"`
+}
+
+// Upload directory the user created in a host orchestrator through the service, which tracks the
+// directories' owners.
+type UserUpload struct {
+	Zone string `json:"zone"`
+	Host string `json:"host"`
+	// Name of the directory in the host orchestrator.
+	Name       string    `json:"name"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+type ListUserUploadsResponse struct {
+	Items []*UserUpload `json:"items"`
+}
+
+// Device creation or artifacts fetch the user started in a host through the service, which tracks
+// the operations' owners until they are done.
+type UserOperation struct {
+	Zone string `json:"zone"`
+	Host string `json:"host"`
+	// Name of the operation in the host orchestrator.
+	Name       string    `json:"name"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+type CancelUserOperationsResponse struct {
+	// Device creations cancelled by deleting the devices they created once done.
+	Cancelled []*UserOperation `json:"cancelled"`
+	// Operations failing to be cancelled, they may still be running or have left devices behind.
+	Failed []*UserOperation `json:"failed"`
+}
+
+// Android CI build the user's creates use when not given one. Empty if the user has no defaults.
+type BuildDefaults struct {
+	// Only one of `Branch` and `BuildID` is set.
+	Branch  string `json:"branch,omitempty"`
+	BuildID string `json:"build_id,omitempty"`
+	Target  string `json:"target,omitempty"`
+}
@@ -1,5 +1,7 @@
 package v1
 
+import "time"
+
 type Error struct {
 	Code     int    `json:"code"`
 	ErrorMsg string `json:"error"`
@@ -29,4 +31,10 @@ type InfraConfig struct {
 
 type IceServer struct {
 	URLs []string `json:"urls"`
+	// Credentials of TURN servers, empty for STUN servers.
+	Username   string `json:"username,omitempty"`
+	Credential string `json:"credential,omitempty"`
+	// When the credentials expire, nil if they don't. Clients refresh the credentials, by fetching
+	// the infra config again, before this time.
+	CredentialExpireTime *time.Time `json:"credential_expire_time,omitempty"`
 }
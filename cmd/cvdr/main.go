@@ -71,6 +71,9 @@ func loadInitialConfig() (*cli.Config, error) {
 	if err := cli.LoadConfig(srcs, config); err != nil {
 		return nil, err
 	}
+	if dir, ok := os.LookupEnv(cli.StateDirEnvVar); ok && dir != "" {
+		config.ApplyStateDir(cli.ExpandPath(dir))
+	}
 	return config, nil
 }
 
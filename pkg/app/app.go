@@ -15,17 +15,21 @@
 package app
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
@@ -36,6 +40,7 @@ import (
 	"github.com/google/cloud-android-orchestration/pkg/app/instances"
 	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
 	"github.com/google/cloud-android-orchestration/pkg/app/session"
+	"github.com/google/cloud-android-orchestration/pkg/buildinfo"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
@@ -59,6 +64,7 @@ type App struct {
 	connectorStaticFilesPath string
 	corsAllowedOrigins       []string
 	infraConfig              apiv1.InfraConfig
+	turnConfig               *config.TURNConfig
 	config                   *config.Config
 }
 
@@ -72,7 +78,7 @@ func NewApp(
 	corsAllowedOrigins []string,
 	webRTCConfig config.WebRTCConfig,
 	config *config.Config) *App {
-	return &App{im, am, oc, es, dbs, webStaticFilesPath, corsAllowedOrigins, buildInfraCfg(webRTCConfig.STUNServers), config}
+	return &App{im, am, oc, es, dbs, webStaticFilesPath, corsAllowedOrigins, buildInfraCfg(webRTCConfig.STUNServers), webRTCConfig.TURN, config}
 }
 
 func (c *App) AddCorsHeaderIfNeeded(w http.ResponseWriter, r *http.Request) {
@@ -108,10 +114,15 @@ func (c *App) Handler() http.Handler {
 	router.Handle("/v1/zones/{zone}/hosts/{host}", c.Authenticate(c.deleteHost)).Methods("DELETE")
 
 	// Infra route
-	router.HandleFunc("/v1/zones/{zone}/hosts/{host}/infra_config", func(w http.ResponseWriter, r *http.Request) {
-		// TODO(b/220891296): Make this configurable
-		replyJSON(w, c.InfraConfig(), http.StatusOK)
-	}).Methods("GET")
+	if c.turnConfig == nil {
+		router.HandleFunc("/v1/zones/{zone}/hosts/{host}/infra_config", func(w http.ResponseWriter, r *http.Request) {
+			// TODO(b/220891296): Make this configurable
+			replyJSON(w, c.InfraConfig(), http.StatusOK)
+		}).Methods("GET")
+	} else {
+		// TURN credentials are only issued to authenticated users.
+		router.Handle("/v1/zones/{zone}/hosts/{host}/infra_config", c.Authenticate(c.infraConfigWithTURN)).Methods("GET")
+	}
 
 	// Host Orchestrator Proxy Routes
 	router.Handle("/v1/zones/{zone}/hosts/{host}/{hostPath:.*}", c.Authenticate(c.ForwardToHost))
@@ -122,6 +133,20 @@ func (c *App) Handler() http.Handler {
 	router.Handle("/deauth", c.Authenticate(c.DeAuthHandler)).Methods("GET")
 	router.Handle("/deauth", c.Authenticate(c.RescindAuthorizationHandler)).Methods("POST")
 	router.Handle("/v1/config", c.Authenticate(c.ConfigHandler)).Methods("GET")
+	// Unauthenticated, clients check their compatibility before logging in.
+	router.Handle("/v1/info", HTTPHandler(c.ServerInfoHandler)).Methods("GET")
+	router.Handle("/v1/zones/{zone}/info", HTTPHandler(c.ServerInfoHandler)).Methods("GET")
+	// Also under the zones, where the clients' root endpoint is when they target a zone.
+	router.Handle("/v1/whoami", c.Authenticate(c.WhoAmIHandler)).Methods("GET")
+	router.Handle("/v1/zones/{zone}/whoami", c.Authenticate(c.WhoAmIHandler)).Methods("GET")
+	router.Handle("/v1/defaults", c.Authenticate(c.getBuildDefaults)).Methods("GET")
+	router.Handle("/v1/defaults", c.Authenticate(c.setBuildDefaults)).Methods("POST")
+	router.Handle("/v1/zones/{zone}/defaults", c.Authenticate(c.getBuildDefaults)).Methods("GET")
+	router.Handle("/v1/zones/{zone}/defaults", c.Authenticate(c.setBuildDefaults)).Methods("POST")
+	router.Handle("/v1/uploads", c.Authenticate(c.listUploads)).Methods("GET")
+	router.Handle("/v1/zones/{zone}/uploads", c.Authenticate(c.listUploads)).Methods("GET")
+	router.Handle("/v1/operations/:cancel", c.Authenticate(c.cancelOperations)).Methods("POST")
+	router.Handle("/v1/zones/{zone}/operations/:cancel", c.Authenticate(c.cancelOperations)).Methods("POST")
 	router.Handle("/", c.Authenticate(indexHandler))
 
 	if c.config.AccountManager.Type == accounts.UsernameOnlyAMType {
@@ -147,6 +172,14 @@ func (a *App) InfraConfig() apiv1.InfraConfig {
 	return a.infraConfig
 }
 
+// Adds the TURN servers to the infra config, with fresh credentials on every request so that
+// clients refresh them by fetching the config again.
+func (a *App) infraConfigWithTURN(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	cfg := a.InfraConfig()
+	cfg.IceServers = append(append([]apiv1.IceServer{}, cfg.IceServers...), turnICEServer(a.turnConfig, user.Username(), time.Now()))
+	return replyJSON(w, cfg, http.StatusOK)
+}
+
 const (
 	headerNameCOInjectBuildAPICreds = "X-Cutf-Cloud-Orchestrator-Inject-BuildAPI-Creds"
 	headerNameHOBuildAPICreds       = "X-Cutf-Host-Orchestrator-BuildAPI-Creds"
@@ -170,10 +203,131 @@ func (a *App) ForwardToHost(w http.ResponseWriter, r *http.Request, user account
 		}
 	}
 	r.URL.Path = hostPath
-	hostClient.GetReverseProxy().ServeHTTP(w, r)
+	proxy := hostClient.GetReverseProxy()
+	a.trackOwnership(proxy, r, user)
+	proxy.ServeHTTP(w, r)
 	return nil
 }
 
+var (
+	hostUploadPathRegex        = regexp.MustCompile(`^/userartifacts/([^/]+)$`)
+	hostOperationPathRegex     = regexp.MustCompile(`^/operations/([^/]+)$`)
+	hostWaitOperationPathRegex = regexp.MustCompile(`^/operations/([^/]+)/:wait$`)
+)
+
+// Records the owners of the upload directories created and the device creations and fetches started
+// through the proxy, so users can find the directories they left behind and cancel their operations.
+// Deleted directories and operations found done are forgotten.
+func (a *App) trackOwnership(proxy *httputil.ReverseProxy, r *http.Request, user accounts.User) {
+	if a.databaseService == nil {
+		return
+	}
+	zone, host := getZone(r), getHost(r)
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/userartifacts":
+		proxy.ModifyResponse = func(res *http.Response) error {
+			if res.StatusCode < 200 || res.StatusCode > 299 {
+				return nil
+			}
+			body, err := readProxiedReply(res)
+			if err != nil {
+				return err
+			}
+			dir := struct {
+				Name string `json:"name"`
+			}{}
+			if err := json.Unmarshal(body, &dir); err != nil || dir.Name == "" {
+				log.Printf("Failed to decode the upload directory created in host %q: %v", host, err)
+				return nil
+			}
+			u := database.Upload{Zone: zone, Host: host, Name: dir.Name, CreateTime: time.Now()}
+			if err := a.databaseService.StoreUpload(user.Username(), u); err != nil {
+				log.Printf("Failed to store upload directory: %v", err)
+			}
+			return nil
+		}
+	case r.Method == http.MethodDelete && hostUploadPathRegex.MatchString(r.URL.Path):
+		name := hostUploadPathRegex.FindStringSubmatch(r.URL.Path)[1]
+		proxy.ModifyResponse = func(res *http.Response) error {
+			if (res.StatusCode >= 200 && res.StatusCode <= 299) || res.StatusCode == http.StatusNotFound {
+				if err := a.databaseService.DeleteUpload(user.Username(), zone, host, name); err != nil {
+					log.Printf("Failed to delete upload directory from database: %v", err)
+				}
+			}
+			return nil
+		}
+	case r.Method == http.MethodPost && hostWaitOperationPathRegex.MatchString(r.URL.Path):
+		name := hostWaitOperationPathRegex.FindStringSubmatch(r.URL.Path)[1]
+		proxy.ModifyResponse = func(res *http.Response) error {
+			// The operation's result is only returned once it's done.
+			if (res.StatusCode >= 200 && res.StatusCode <= 299) || res.StatusCode == http.StatusNotFound {
+				a.forgetOperation(user, zone, host, name)
+			}
+			return nil
+		}
+	case r.Method == http.MethodGet && hostOperationPathRegex.MatchString(r.URL.Path):
+		name := hostOperationPathRegex.FindStringSubmatch(r.URL.Path)[1]
+		proxy.ModifyResponse = func(res *http.Response) error {
+			if res.StatusCode == http.StatusNotFound {
+				a.forgetOperation(user, zone, host, name)
+			}
+			if res.StatusCode < 200 || res.StatusCode > 299 {
+				return nil
+			}
+			body, err := readProxiedReply(res)
+			if err != nil {
+				return err
+			}
+			op := struct {
+				Done bool `json:"done"`
+			}{}
+			if json.Unmarshal(body, &op) == nil && op.Done {
+				a.forgetOperation(user, zone, host, name)
+			}
+			return nil
+		}
+	case r.Method == http.MethodPost && (r.URL.Path == "/cvds" || r.URL.Path == "/artifacts"):
+		proxy.ModifyResponse = func(res *http.Response) error {
+			if res.StatusCode < 200 || res.StatusCode > 299 {
+				return nil
+			}
+			body, err := readProxiedReply(res)
+			if err != nil {
+				return err
+			}
+			op := struct {
+				Name string `json:"name"`
+				Done bool   `json:"done"`
+			}{}
+			if err := json.Unmarshal(body, &op); err != nil || op.Name == "" || op.Done {
+				return nil
+			}
+			o := database.Operation{Zone: zone, Host: host, Name: op.Name, CreateTime: time.Now()}
+			if err := a.databaseService.StoreOperation(user.Username(), o); err != nil {
+				log.Printf("Failed to store operation: %v", err)
+			}
+			return nil
+		}
+	}
+}
+
+func (a *App) forgetOperation(user accounts.User, zone, host, name string) {
+	if err := a.databaseService.DeleteOperation(user.Username(), zone, host, name); err != nil {
+		log.Printf("Failed to delete operation from database: %v", err)
+	}
+}
+
+// Reads the body of the reply of a host, leaving it in place to be forwarded to the client.
+func readProxiedReply(res *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
 func (a *App) injectBuildAPICredsIntoRequest(r *http.Request, user accounts.User) error {
 	tk, err := a.fetchUserCredentials(user)
 	if err != nil {
@@ -427,6 +581,168 @@ func (a *App) ConfigHandler(w http.ResponseWriter, r *http.Request, user account
 	return nil
 }
 
+// Replies with the user's default build sources, empty if the user has none.
+func (a *App) getBuildDefaults(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	b, err := a.databaseService.FetchBuildDefaults(user.Username())
+	if err != nil {
+		return fmt.Errorf("failed to fetch build defaults: %w", err)
+	}
+	res := apiv1.BuildDefaults{}
+	if b != nil {
+		if err := json.Unmarshal(b, &res); err != nil {
+			return fmt.Errorf("failed to decode build defaults: %w", err)
+		}
+	}
+	replyJSON(w, res, http.StatusOK)
+	return nil
+}
+
+func (a *App) setBuildDefaults(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	var msg apiv1.BuildDefaults
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		return apperr.NewBadRequestError("Malformed JSON in request", err)
+	}
+	if msg.Branch != "" && msg.BuildID != "" {
+		return apperr.NewBadRequestError("Only one of branch and build_id can be set", nil)
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := a.databaseService.StoreBuildDefaults(user.Username(), b); err != nil {
+		return fmt.Errorf("failed to store build defaults: %w", err)
+	}
+	replyJSON(w, msg, http.StatusOK)
+	return nil
+}
+
+// Replies with the upload directories the user created, only those in the zone if given one.
+func (a *App) listUploads(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	uploads, err := a.databaseService.ListUploads(user.Username())
+	if err != nil {
+		return fmt.Errorf("failed to list uploads: %w", err)
+	}
+	zone := getZone(r)
+	res := apiv1.ListUserUploadsResponse{Items: []*apiv1.UserUpload{}}
+	for _, u := range uploads {
+		if zone != "" && u.Zone != zone {
+			continue
+		}
+		res.Items = append(res.Items, &apiv1.UserUpload{Zone: u.Zone, Host: u.Host, Name: u.Name, CreateTime: u.CreateTime})
+	}
+	replyJSON(w, res, http.StatusOK)
+	return nil
+}
+
+// Cancels the in-progress host operations the user started, only those in the zone if given one.
+// Operations that end without devices to delete are forgotten without being reported, those failing
+// to be cancelled are kept to be retried.
+func (a *App) cancelOperations(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	ops, err := a.databaseService.ListOperations(user.Username())
+	if err != nil {
+		return fmt.Errorf("failed to list operations: %w", err)
+	}
+	zone := getZone(r)
+	res := apiv1.CancelUserOperationsResponse{Cancelled: []*apiv1.UserOperation{}, Failed: []*apiv1.UserOperation{}}
+	for _, op := range ops {
+		if zone != "" && op.Zone != zone {
+			continue
+		}
+		uo := &apiv1.UserOperation{Zone: op.Zone, Host: op.Host, Name: op.Name, CreateTime: op.CreateTime}
+		cancelled, err := a.cancelHostOperation(op)
+		if err != nil {
+			log.Printf("Failed to cancel operation %q of host %q: %v", op.Name, op.Host, err)
+			res.Failed = append(res.Failed, uo)
+			continue
+		}
+		a.forgetOperation(user, op.Zone, op.Host, op.Name)
+		if cancelled {
+			res.Cancelled = append(res.Cancelled, uo)
+		}
+	}
+	replyJSON(w, res, http.StatusOK)
+	return nil
+}
+
+// Host orchestrators can't stop their operations, device creations are cancelled by waiting for
+// them and deleting the devices they created instead. Fetches only leave artifacts in the host's
+// cache, they are waited for and left alone. Returns false if the operation created no devices or
+// the host no longer has it.
+func (a *App) cancelHostOperation(op database.Operation) (bool, error) {
+	hostClient, err := a.instanceManager.GetHostClient(op.Zone, op.Host)
+	if err != nil {
+		return false, err
+	}
+	created := struct {
+		CVDs []struct {
+			Group string `json:"group"`
+			Name  string `json:"name"`
+		} `json:"cvds"`
+	}{}
+	status, err := hostClient.Post("/operations/"+op.Name+"/:wait", "", struct{}{}, &instances.HostResponse{Result: &created, Error: &apiv1.Error{}})
+	switch {
+	case status < 0:
+		return false, err
+	case status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout:
+		return false, fmt.Errorf("operation still in progress after waiting for it")
+	case status < 200 || status > 299:
+		// The operation failed or the host no longer has it, there is nothing to delete.
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	for _, cvd := range created.CVDs {
+		path := "/cvds/" + url.PathEscape(cvd.Group) + "/" + url.PathEscape(cvd.Name)
+		status, err := hostClient.Delete(path, "", nil)
+		if err != nil {
+			return false, err
+		}
+		if (status < 200 || status > 299) && status != http.StatusNotFound {
+			return false, fmt.Errorf("host replied with status %d deleting device %q", status, cvd.Group+"/"+cvd.Name)
+		}
+	}
+	return len(created.CVDs) > 0, nil
+}
+
+// Reports the user as authenticated by the account manager, to help debugging authentication issues.
+const (
+	// Incremented on changes of the API clients need to know about.
+	apiVersion = 1
+	// Raised when the service stops serving clients of older API versions.
+	minClientAPIVersion = 1
+)
+
+func (a *App) ServerInfoHandler(w http.ResponseWriter, r *http.Request) error {
+	info := buildinfo.Get()
+	res := apiv1.ServerInfoResponse{
+		Version:             info.Version,
+		Revision:            info.Revision,
+		APIVersion:          apiVersion,
+		MinClientAPIVersion: minClientAPIVersion,
+	}
+	return replyJSON(w, res, http.StatusOK)
+}
+
+func (a *App) WhoAmIHandler(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	res := apiv1.WhoAmIResponse{
+		Username:           user.Username(),
+		Email:              user.Email(),
+		AccountManagerType: string(a.config.AccountManager.Type),
+	}
+	tk, err := a.fetchUserCredentials(user)
+	if err != nil {
+		return err
+	}
+	if tk != nil {
+		res.BuildAPIAuthorized = true
+		if !tk.Expiry.IsZero() {
+			res.BuildAPITokenExpiry = &tk.Expiry
+		}
+	}
+	replyJSON(w, res, http.StatusOK)
+	return nil
+}
+
 func (a *App) setOrUpdateSession(w http.ResponseWriter, s *session.Session) error {
 	if s.Key == "" {
 		s.Key = randomHexString()
@@ -16,6 +16,9 @@ package app
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -107,6 +110,10 @@ func (hc *testHostClient) Post(path, query string, bodyJSON any, res *instances.
 	return 200, nil
 }
 
+func (hc *testHostClient) Delete(path, query string, res *instances.HostResponse) (int, error) {
+	return 200, nil
+}
+
 func (hc *testHostClient) GetReverseProxy() *httputil.ReverseProxy {
 	return httputil.NewSingleHostReverseProxy(hc.url)
 }
@@ -244,6 +251,43 @@ func TestInfraConfigRequest(t *testing.T) {
 	}
 }
 
+func TestInfraConfigRequestWithTURN(t *testing.T) {
+	turn := &config.TURNConfig{URLs: []string{"turn:turn.com:3478"}, SharedSecret: "secret", CredentialTTLMinutes: 60}
+	webRTCConfig := config.WebRTCConfig{STUNServers: []string{"foo.com:12345"}, TURN: turn}
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, "", nil, webRTCConfig, &config.Config{})
+	ts := httptest.NewServer(controller.Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/v1/zones/foo/hosts/bar/infra_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got apiv1.InfraConfig
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.IceServers) != 2 {
+		t.Fatalf("expected the STUN and TURN servers, got: %+v", got.IceServers)
+	}
+	s := got.IceServers[1]
+	if s.CredentialExpireTime == nil || time.Until(*s.CredentialExpireTime) > time.Hour {
+		t.Fatalf("expected credentials expiring within the hour, got: %v", s.CredentialExpireTime)
+	}
+	wantUsername := fmt.Sprintf("%d:%s", s.CredentialExpireTime.Unix(), testUsername)
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(wantUsername))
+	want := apiv1.IceServer{
+		URLs:                 turn.URLs,
+		Username:             wantUsername,
+		Credential:           base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		CredentialExpireTime: s.CredentialExpireTime,
+	}
+	if diff := cmp.Diff(want, s); diff != "" {
+		t.Errorf("TURN server mismatch (-expected +got):\n%s", diff)
+	}
+}
+
 func TestHostForwarderRequest(t *testing.T) {
 	const headerContentType = "Content-Type"
 	respContentType := "app/ct"
@@ -514,6 +558,110 @@ func TestGetConfigSucceeds(t *testing.T) {
 	}
 }
 
+func TestServerInfo(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	ts := httptest.NewServer(controller.Handler())
+	defer ts.Close()
+
+	for _, path := range []string{"/v1/info", "/v1/zones/us-central1-a/info"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code <<%d>>, want: %d", res.StatusCode, http.StatusOK)
+		}
+		var got apiv1.ServerInfoResponse
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Version == "" || got.APIVersion != apiVersion || got.MinClientAPIVersion != minClientAPIVersion {
+			t.Errorf("%s: unexpected server info: %+v", path, got)
+		}
+	}
+}
+
+func TestWhoAmI(t *testing.T) {
+	dbs := database.NewInMemoryDBService()
+	cfg := &config.Config{AccountManager: accounts.Config{Type: accounts.UsernameOnlyAMType}}
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, dbs, "", nil, config.WebRTCConfig{}, cfg)
+	ts := httptest.NewServer(controller.Handler())
+	defer ts.Close()
+
+	for _, path := range []string{"/v1/whoami", "/v1/zones/us-central1-a/whoami"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code <<%d>>, want: %d", res.StatusCode, http.StatusOK)
+		}
+		var got apiv1.WhoAmIResponse
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := apiv1.WhoAmIResponse{Username: testUsername, AccountManagerType: string(accounts.UsernameOnlyAMType)}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", path, diff)
+		}
+	}
+}
+
+func TestBuildDefaults(t *testing.T) {
+	dbs := database.NewInMemoryDBService()
+	cfg := &config.Config{AccountManager: accounts.Config{Type: accounts.UsernameOnlyAMType}}
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, dbs, "", nil, config.WebRTCConfig{}, cfg)
+	ts := httptest.NewServer(controller.Handler())
+	defer ts.Close()
+	defaults := apiv1.BuildDefaults{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"}
+	body, _ := json.Marshal(defaults)
+
+	res, err := http.Post(ts.URL+"/v1/defaults", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", res.StatusCode, http.StatusOK)
+	}
+	res, err = http.Get(ts.URL + "/v1/zones/us-central1-a/defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var got apiv1.BuildDefaults
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(defaults, got); diff != "" {
+		t.Errorf("defaults mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetBuildDefaultsBranchAndBuildID(t *testing.T) {
+	dbs := database.NewInMemoryDBService()
+	cfg := &config.Config{AccountManager: accounts.Config{Type: accounts.UsernameOnlyAMType}}
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, dbs, "", nil, config.WebRTCConfig{}, cfg)
+	ts := httptest.NewServer(controller.Handler())
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/v1/defaults", "application/json",
+		strings.NewReader(`{"branch": "aosp-main", "build_id": "123", "target": "t"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status code <<%d>>, want: %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
 func assertIsAppError(t *testing.T, err error) {
 	var appErr *apperr.AppError
 	if !errors.As(err, &appErr) {
@@ -525,3 +673,154 @@ func makeRequest(w http.ResponseWriter, r *http.Request, controller *App) {
 	router := controller.Handler()
 	router.ServeHTTP(w, r)
 }
+
+func TestHostForwarderTracksUploads(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /userartifacts":
+			w.Write([]byte(`{"name": "dir1"}`))
+		case "DELETE /userartifacts/dir1":
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	hostURL, _ := url.Parse(ts.URL)
+	dbs := database.NewInMemoryDBService()
+	controller := NewApp(&testInstanceManager{
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return &testHostClient{hostURL}
+		},
+	}, &testAccountManager{}, nil, nil, dbs, "", nil, config.WebRTCConfig{}, &config.Config{})
+	listUploads := func() []*apiv1.UserUpload {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:1080/v1/zones/us-central1-a/uploads", nil)
+		makeRequest(w, req, controller)
+		res := apiv1.ListUserUploadsResponse{}
+		if err := json.NewDecoder(w.Result().Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Items
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:1080/v1/zones/us-central1-a/hosts/foo/userartifacts", nil)
+	makeRequest(w, req, controller)
+
+	if body, _ := io.ReadAll(w.Result().Body); !strings.Contains(string(body), "dir1") {
+		t.Errorf("expected the host's reply to be forwarded, got: %q", body)
+	}
+	got := listUploads()
+	if len(got) != 1 || got[0].Host != "foo" || got[0].Name != "dir1" || got[0].Zone != "us-central1-a" {
+		t.Fatalf("unexpected uploads: %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodDelete, "http://localhost:1080/v1/zones/us-central1-a/hosts/foo/userartifacts/dir1", nil)
+	makeRequest(w, req, controller)
+
+	if got := listUploads(); len(got) != 0 {
+		t.Errorf("expected no uploads after delete, got: %+v", got)
+	}
+}
+
+func TestCancelOperationsDeletesTheDevicesOfTheUsersCreations(t *testing.T) {
+	deleted := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /cvds":
+			w.Write([]byte(`{"name": "op1", "done": false}`))
+		case "POST /cvds/cvd/1/:stop":
+			w.Write([]byte(`{"name": "op2", "done": false}`))
+		case "POST /artifacts":
+			w.Write([]byte(`{"name": "op3", "done": false}`))
+		case "POST /operations/op1/:wait":
+			w.Write([]byte(`{"cvds": [{"group": "cvd", "name": "1"}, {"group": "cvd", "name": "2"}]}`))
+		case "POST /operations/op3/:wait":
+			w.Write([]byte(`{}`))
+		case "DELETE /cvds/cvd/1", "DELETE /cvds/cvd/2":
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/cvds/"))
+			w.Write([]byte(`{"name": "op4", "done": false}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	hostURL, _ := url.Parse(ts.URL)
+	controller := NewApp(&testInstanceManager{
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return instances.NewNetHostClient(hostURL, false)
+		},
+	}, &testAccountManager{}, nil, nil, database.NewInMemoryDBService(), "", nil, config.WebRTCConfig{}, &config.Config{})
+	for _, path := range []string{"/cvds", "/cvds/cvd/1/:stop", "/artifacts"} {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:1080/v1/zones/us-central1-a/hosts/foo"+path, nil)
+		makeRequest(httptest.NewRecorder(), req, controller)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:1080/v1/zones/us-central1-a/operations/:cancel", nil)
+	makeRequest(w, req, controller)
+
+	res := apiv1.CancelUserOperationsResponse{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Cancelled) != 1 || res.Cancelled[0].Name != "op1" || res.Cancelled[0].Host != "foo" || len(res.Failed) != 0 {
+		t.Errorf("unexpected response: %+v", res)
+	}
+	if diff := cmp.Diff([]string{"cvd/1", "cvd/2"}, deleted); diff != "" {
+		t.Errorf("deleted devices mismatch (-want +got):\n%s", diff)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "http://localhost:1080/v1/operations/:cancel", nil)
+	makeRequest(w, req, controller)
+
+	res = apiv1.CancelUserOperationsResponse{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Cancelled) != 0 || len(res.Failed) != 0 {
+		t.Errorf("expected the operations to be forgotten, got: %+v", res)
+	}
+}
+
+func TestCancelOperationsKeepsTheOperationsStillInProgress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /cvds":
+			w.Write([]byte(`{"name": "op1", "done": false}`))
+		case "POST /operations/op1/:wait":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"code": 503, "error": "timeout"}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	hostURL, _ := url.Parse(ts.URL)
+	dbs := database.NewInMemoryDBService()
+	controller := NewApp(&testInstanceManager{
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return instances.NewNetHostClient(hostURL, false)
+		},
+	}, &testAccountManager{}, nil, nil, dbs, "", nil, config.WebRTCConfig{}, &config.Config{})
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:1080/v1/zones/us-central1-a/hosts/foo/cvds", nil)
+	makeRequest(httptest.NewRecorder(), req, controller)
+
+	w := httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "http://localhost:1080/v1/operations/:cancel", nil)
+	makeRequest(w, req, controller)
+
+	res := apiv1.CancelUserOperationsResponse{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Cancelled) != 0 || len(res.Failed) != 1 || res.Failed[0].Name != "op1" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+	if ops, _ := dbs.ListOperations(testUsername); len(ops) != 1 {
+		t.Errorf("expected the operation to still be tracked, got: %+v", ops)
+	}
+}
@@ -26,8 +26,19 @@ import (
 	toml "github.com/pelletier/go-toml"
 )
 
+// TURN servers issuing time-limited credentials with a secret they share with the service, i.e:
+// coturn's `use-auth-secret`.
+type TURNConfig struct {
+	URLs         []string
+	SharedSecret string
+	// Lifetime of the issued credentials, defaults to 24 hours.
+	CredentialTTLMinutes int
+}
+
 type WebRTCConfig struct {
 	STUNServers []string
+	// Optional, only STUN servers are offered to clients without it.
+	TURN *TURNConfig
 }
 
 type Config struct {
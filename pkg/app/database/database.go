@@ -15,9 +15,27 @@
 package database
 
 import (
+	"time"
+
 	"github.com/google/cloud-android-orchestration/pkg/app/session"
 )
 
+// Upload directory created by a user in a host orchestrator.
+type Upload struct {
+	Zone       string
+	Host       string
+	Name       string
+	CreateTime time.Time
+}
+
+// Host orchestrator operation started by a user.
+type Operation struct {
+	Zone       string
+	Host       string
+	Name       string
+	CreateTime time.Time
+}
+
 type Service interface {
 	// Credentials are usually stored encrypted hence the []byte type.
 	// If no credentials are available for the given user Fetch returns nil, nil.
@@ -25,6 +43,22 @@ type Service interface {
 	// Store new credentials or overwrite existing ones for the given user.
 	StoreBuildAPICredentials(username string, credentials []byte) error
 	DeleteBuildAPICredentials(username string) error
+	// The user's default build sources, JSON-serialized. Returns nil, nil if the user has none.
+	FetchBuildDefaults(username string) ([]byte, error)
+	// Store new default build sources or overwrite existing ones for the given user.
+	StoreBuildDefaults(username string, defaults []byte) error
+	// Records the user as the owner of the upload directory.
+	StoreUpload(username string, u Upload) error
+	// Returns the upload directories owned by the user, in no particular order.
+	ListUploads(username string) ([]Upload, error)
+	// Forgets the user's upload directory. Won't return error if it isn't recorded.
+	DeleteUpload(username, zone, host, name string) error
+	// Records the user as the owner of the host operation.
+	StoreOperation(username string, op Operation) error
+	// Returns the host operations owned by the user, in no particular order.
+	ListOperations(username string) ([]Operation, error)
+	// Forgets the user's host operation. Won't return error if it isn't recorded.
+	DeleteOperation(username, zone, host, name string) error
 	// Create or update a user session.
 	CreateOrUpdateSession(s session.Session) error
 	// Fetch a session. Returns nil, nil if the session doesn't exist.
@@ -26,13 +26,19 @@ type SpannerConfig struct {
 
 // Simple in memory database to use for testing or local development.
 type InMemoryDBService struct {
-	credentials map[string][]byte
-	session     session.Session
+	credentials   map[string][]byte
+	buildDefaults map[string][]byte
+	uploads       map[string][]Upload
+	operations    map[string][]Operation
+	session       session.Session
 }
 
 func NewInMemoryDBService() *InMemoryDBService {
 	return &InMemoryDBService{
-		credentials: make(map[string][]byte),
+		credentials:   make(map[string][]byte),
+		buildDefaults: make(map[string][]byte),
+		uploads:       make(map[string][]Upload),
+		operations:    make(map[string][]Operation),
 	}
 }
 
@@ -50,6 +56,57 @@ func (dbs *InMemoryDBService) DeleteBuildAPICredentials(username string) error {
 	return nil
 }
 
+func (dbs *InMemoryDBService) FetchBuildDefaults(username string) ([]byte, error) {
+	return dbs.buildDefaults[username], nil
+}
+
+func (dbs *InMemoryDBService) StoreBuildDefaults(username string, defaults []byte) error {
+	dbs.buildDefaults[username] = defaults
+	return nil
+}
+
+func (dbs *InMemoryDBService) StoreUpload(username string, u Upload) error {
+	dbs.DeleteUpload(username, u.Zone, u.Host, u.Name)
+	dbs.uploads[username] = append(dbs.uploads[username], u)
+	return nil
+}
+
+func (dbs *InMemoryDBService) ListUploads(username string) ([]Upload, error) {
+	return append([]Upload{}, dbs.uploads[username]...), nil
+}
+
+func (dbs *InMemoryDBService) DeleteUpload(username, zone, host, name string) error {
+	kept := []Upload{}
+	for _, u := range dbs.uploads[username] {
+		if u.Zone != zone || u.Host != host || u.Name != name {
+			kept = append(kept, u)
+		}
+	}
+	dbs.uploads[username] = kept
+	return nil
+}
+
+func (dbs *InMemoryDBService) StoreOperation(username string, op Operation) error {
+	dbs.DeleteOperation(username, op.Zone, op.Host, op.Name)
+	dbs.operations[username] = append(dbs.operations[username], op)
+	return nil
+}
+
+func (dbs *InMemoryDBService) ListOperations(username string) ([]Operation, error) {
+	return append([]Operation{}, dbs.operations[username]...), nil
+}
+
+func (dbs *InMemoryDBService) DeleteOperation(username, zone, host, name string) error {
+	kept := []Operation{}
+	for _, op := range dbs.operations[username] {
+		if op.Zone != zone || op.Host != host || op.Name != name {
+			kept = append(kept, op)
+		}
+	}
+	dbs.operations[username] = kept
+	return nil
+}
+
 func (dbs *InMemoryDBService) CreateOrUpdateSession(s session.Session) error {
 	dbs.session = s
 	return nil
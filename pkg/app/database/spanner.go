@@ -33,11 +33,26 @@ const (
 	usernameColumn    = "username"
 	credentialsColumn = "credentials"
 
+	buildDefaultsTable  = "BuildDefaults"
+	buildDefaultsColumn = "defaults"
+
 	sessionsTable            = "Sessions"
 	sessionKeyColumn         = "session_key"
 	sessionOAuth2StateColumn = "oauth2_state"
 	sessionAccessColumn      = "accessed_at"
 
+	uploadsTable        = "Uploads"
+	uploadZoneColumn    = "zone"
+	uploadHostColumn    = "host"
+	uploadNameColumn    = "name"
+	uploadCreatedColumn = "created_at"
+
+	operationsTable        = "Operations"
+	operationZoneColumn    = "zone"
+	operationHostColumn    = "host"
+	operationNameColumn    = "name"
+	operationCreatedColumn = "created_at"
+
 	sessionStateValidityHours = 48
 )
 
@@ -47,11 +62,31 @@ const (
 //	  username string primary key
 //	  credentials byte array # wide enough to store an encrypted JSON-serialized oauth2.Token object
 //	}
+//	table BuildDefaults {
+//	  username string primary key
+//	  defaults byte array # wide enough to store a JSON-serialized apiv1.BuildDefaults object
+//	}
 //	table Sessions {
 //	  session_key string primary key
 //	  oauth2_state string
 //	  accessed_at timestamp
 //	}
+//	table Uploads {
+//	  username string
+//	  zone string
+//	  host string
+//	  name string
+//	  created_at timestamp
+//	  primary key (username, zone, host, name)
+//	}
+//	table Operations {
+//	  username string
+//	  zone string
+//	  host string
+//	  name string
+//	  created_at timestamp
+//	  primary key (username, zone, host, name)
+//	}
 type SpannerDBService struct {
 	db string
 }
@@ -114,6 +149,156 @@ func (dbs *SpannerDBService) DeleteBuildAPICredentials(username string) error {
 	return err
 }
 
+func (dbs *SpannerDBService) FetchBuildDefaults(username string) ([]byte, error) {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create db client: %w", err)
+	}
+	defer client.Close()
+
+	row, err := client.Single().ReadRow(ctx, buildDefaultsTable, spanner.Key{username}, []string{buildDefaultsColumn})
+	if err != nil {
+		if spanner.ErrCode(err) == codes.NotFound {
+			// Not found is not an error
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error querying database: %w", err)
+	}
+
+	var defaults []byte
+	err = row.Column(0, &defaults)
+	return defaults, err
+}
+
+func (dbs *SpannerDBService) StoreBuildDefaults(username string, defaults []byte) error {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	columns := []string{usernameColumn, buildDefaultsColumn}
+	mutations := []*spanner.Mutation{
+		spanner.InsertOrUpdate(buildDefaultsTable, columns, []interface{}{username, defaults}),
+	}
+	_, err = client.Apply(ctx, mutations)
+	return err
+}
+
+func (dbs *SpannerDBService) StoreUpload(username string, u Upload) error {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	columns := []string{usernameColumn, uploadZoneColumn, uploadHostColumn, uploadNameColumn, uploadCreatedColumn}
+	mutation := spanner.InsertOrUpdate(uploadsTable, columns, []interface{}{username, u.Zone, u.Host, u.Name, u.CreateTime})
+	_, err = client.Apply(ctx, []*spanner.Mutation{mutation})
+	return err
+}
+
+func (dbs *SpannerDBService) ListUploads(username string) ([]Upload, error) {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create db client: %w", err)
+	}
+	defer client.Close()
+
+	columns := []string{uploadZoneColumn, uploadHostColumn, uploadNameColumn, uploadCreatedColumn}
+	result := []Upload{}
+	iter := client.Single().Read(ctx, uploadsTable, spanner.Key{username}.AsPrefix(), columns)
+	err = iter.Do(func(row *spanner.Row) error {
+		u := Upload{}
+		if err := row.Columns(&u.Zone, &u.Host, &u.Name, &u.CreateTime); err != nil {
+			return err
+		}
+		result = append(result, u)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve uploads: %w", err)
+	}
+	return result, nil
+}
+
+func (dbs *SpannerDBService) DeleteUpload(username, zone, host, name string) error {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	mutation := spanner.Delete(uploadsTable, spanner.KeySetFromKeys(spanner.Key{username, zone, host, name}))
+	_, err = client.Apply(ctx, []*spanner.Mutation{mutation})
+	if spanner.ErrCode(err) == codes.NotFound {
+		// Not an error if not found
+		return nil
+	}
+	return err
+}
+
+func (dbs *SpannerDBService) StoreOperation(username string, op Operation) error {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	columns := []string{usernameColumn, operationZoneColumn, operationHostColumn, operationNameColumn, operationCreatedColumn}
+	mutation := spanner.InsertOrUpdate(operationsTable, columns, []interface{}{username, op.Zone, op.Host, op.Name, op.CreateTime})
+	_, err = client.Apply(ctx, []*spanner.Mutation{mutation})
+	return err
+}
+
+func (dbs *SpannerDBService) ListOperations(username string) ([]Operation, error) {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create db client: %w", err)
+	}
+	defer client.Close()
+
+	columns := []string{operationZoneColumn, operationHostColumn, operationNameColumn, operationCreatedColumn}
+	result := []Operation{}
+	iter := client.Single().Read(ctx, operationsTable, spanner.Key{username}.AsPrefix(), columns)
+	err = iter.Do(func(row *spanner.Row) error {
+		op := Operation{}
+		if err := row.Columns(&op.Zone, &op.Host, &op.Name, &op.CreateTime); err != nil {
+			return err
+		}
+		result = append(result, op)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve operations: %w", err)
+	}
+	return result, nil
+}
+
+func (dbs *SpannerDBService) DeleteOperation(username, zone, host, name string) error {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	mutation := spanner.Delete(operationsTable, spanner.KeySetFromKeys(spanner.Key{username, zone, host, name}))
+	_, err = client.Apply(ctx, []*spanner.Mutation{mutation})
+	if spanner.ErrCode(err) == codes.NotFound {
+		// Not an error if not found
+		return nil
+	}
+	return err
+}
+
 func (dbs *SpannerDBService) CreateOrUpdateSession(s session.Session) error {
 	ctx := context.TODO()
 	client, err := spanner.NewClient(ctx, dbs.db)
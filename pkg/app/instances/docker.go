@@ -68,10 +68,13 @@ func (m *DockerInstanceManager) ListZones() (*apiv1.ListZonesResponse, error) {
 	}, nil
 }
 
-func (m *DockerInstanceManager) CreateHost(zone string, _ *apiv1.CreateHostRequest, user accounts.User) (*apiv1.Operation, error) {
+func (m *DockerInstanceManager) CreateHost(zone string, req *apiv1.CreateHostRequest, user accounts.User) (*apiv1.Operation, error) {
 	if zone != "local" {
 		return nil, errors.NewBadRequestError("Invalid zone. It should be 'local'.", nil)
 	}
+	if len(req.RequireFeatures) > 0 {
+		return nil, errors.NewBadRequestError("Docker hosts have the features of the machine running them, they can't be required.", nil)
+	}
 	ctx := context.TODO()
 	config := &container.Config{
 		AttachStdin: true,
@@ -21,6 +21,7 @@ import (
 	"net/url"
 	"path"
 	"regexp"
+	"strings"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
@@ -105,6 +106,9 @@ func (m *GCEInstanceManager) CreateHost(zone string, req *apiv1.CreateHostReques
 	if err := validateRequest(req); err != nil {
 		return nil, err
 	}
+	if err := applyRequiredFeatures(req); err != nil {
+		return nil, err
+	}
 	payload := &compute.Instance{
 		Name: m.InstanceNameGenerator.NewName(),
 		// This is required in the format: "zones/zone/machineTypes/machine-type".
@@ -273,6 +277,87 @@ func validateRequest(r *apiv1.CreateHostRequest) error {
 	return nil
 }
 
+// Hosts always have nested virtualization, a required CPU platform becomes the minimum and required
+// GPUs must be among the requested accelerators.
+func applyRequiredFeatures(r *apiv1.CreateHostRequest) error {
+	gcp := r.HostInstance.GCP
+	for _, f := range r.RequireFeatures {
+		switch {
+		case f == apiv1.HostFeatureNestedVirtualization:
+		case strings.HasPrefix(f, apiv1.HostFeatureCPUPlatformPrefix):
+			platform := strings.TrimPrefix(f, apiv1.HostFeatureCPUPlatformPrefix)
+			if gcp.MinCPUPlatform == "" {
+				gcp.MinCPUPlatform = platform
+			} else if gcp.MinCPUPlatform != platform {
+				msg := fmt.Sprintf("required feature %q conflicts with the minimum CPU platform %q", f, gcp.MinCPUPlatform)
+				return errors.NewBadRequestError(msg, nil)
+			}
+		case strings.HasPrefix(f, apiv1.HostFeatureGPUPrefix):
+			gpu := strings.TrimPrefix(f, apiv1.HostFeatureGPUPrefix)
+			found := false
+			for _, c := range gcp.AcceleratorConfigs {
+				if path.Base(c.AcceleratorType) == gpu && c.AcceleratorCount > 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				msg := fmt.Sprintf("required feature %q needs an accelerator of type %q", f, gpu)
+				return errors.NewBadRequestError(msg, nil)
+			}
+		default:
+			return errors.NewBadRequestError(fmt.Sprintf("unknown host feature %q", f), nil)
+		}
+	}
+	return nil
+}
+
+func hostFeatures(in *compute.Instance) []string {
+	var result []string
+	if in.AdvancedMachineFeatures != nil && in.AdvancedMachineFeatures.EnableNestedVirtualization {
+		result = append(result, apiv1.HostFeatureNestedVirtualization)
+	}
+	if in.CpuPlatform != "" {
+		result = append(result, apiv1.HostFeatureCPUPlatformPrefix+in.CpuPlatform)
+	}
+	for _, c := range in.GuestAccelerators {
+		if c.AcceleratorCount > 0 {
+			result = append(result, apiv1.HostFeatureGPUPrefix+path.Base(c.AcceleratorType))
+		}
+	}
+	return result
+}
+
+// Machine type families of Arm CPUs.
+var arm64MachineFamilies = []string{"t2a", "c4a"}
+
+// Returns the host architecture from the machine type, i.e: "t2a-standard-4" results in "arm64".
+func hostArch(machineType string) string {
+	family, _, _ := strings.Cut(path.Base(machineType), "-")
+	for _, f := range arm64MachineFamilies {
+		if family == f {
+			return apiv1.HostArchARM64
+		}
+	}
+	return apiv1.HostArchX86_64
+}
+
+// Metadata key of the host's overlay network address, set by whatever joins the host to the
+// overlay, i.e: a startup script running `tailscale up`.
+const overlayAddressMetadataKey = "cf-overlay-address"
+
+func overlayAddress(in *compute.Instance) string {
+	if in.Metadata == nil {
+		return ""
+	}
+	for _, item := range in.Metadata.Items {
+		if item.Key == overlayAddressMetadataKey && item.Value != nil {
+			return *item.Value
+		}
+	}
+	return ""
+}
+
 func buildDefaultNetworkName(projectID string) string {
 	return fmt.Sprintf("projects/%s/global/networks/default", projectID)
 }
@@ -286,13 +371,21 @@ func BuildHostInstance(in *compute.Instance) (*apiv1.HostInstance, error) {
 	if disksLen > 1 {
 		log.Printf("invalid host instance %q: has %d (more than one) disks", in.SelfLink, disksLen)
 	}
+	zone := ""
+	if in.Zone != "" {
+		zone = path.Base(in.Zone)
+	}
 	return &apiv1.HostInstance{
 		Name:           in.Name,
 		BootDiskSizeGB: in.Disks[0].DiskSizeGb,
 		GCP: &apiv1.GCPInstance{
 			MachineType:    path.Base(in.MachineType),
 			MinCPUPlatform: in.MinCpuPlatform,
+			Zone:           zone,
 		},
+		Features:       hostFeatures(in),
+		Arch:           hostArch(in.MachineType),
+		OverlayAddress: overlayAddress(in),
 	}, nil
 }
 
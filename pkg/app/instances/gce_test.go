@@ -630,6 +630,7 @@ func TestBuildHostInstance(t *testing.T) {
 		Name:           "foo",
 		MachineType:    "zones/us-central1-a/machineTypes/n1-standard-1",
 		MinCpuPlatform: "Intel Haswell",
+		Zone:           "https://www.googleapis.com/compute/v1/projects/google.com:test-project/zones/us-central1-a",
 	}
 
 	got, err := BuildHostInstance(input)
@@ -640,7 +641,9 @@ func TestBuildHostInstance(t *testing.T) {
 		GCP: &apiv1.GCPInstance{
 			MachineType:    "n1-standard-1",
 			MinCPUPlatform: "Intel Haswell",
+			Zone:           "us-central1-a",
 		},
+		Arch: "x86_64",
 	}
 	if diff := cmp.Diff(&want, got); diff != "" {
 		t.Errorf("instance mismatch (-want +got):\n%s", diff)
@@ -650,6 +653,94 @@ func TestBuildHostInstance(t *testing.T) {
 	}
 }
 
+func TestBuildHostInstanceFeatures(t *testing.T) {
+	input := &compute.Instance{
+		Disks:                   []*compute.AttachedDisk{{DiskSizeGb: 10}},
+		Name:                    "foo",
+		MachineType:             "zones/us-central1-a/machineTypes/n1-standard-4",
+		CpuPlatform:             "Intel Cascade Lake",
+		AdvancedMachineFeatures: &compute.AdvancedMachineFeatures{EnableNestedVirtualization: true},
+		GuestAccelerators: []*compute.AcceleratorConfig{
+			{AcceleratorCount: 1, AcceleratorType: "projects/p/zones/us-central1-a/acceleratorTypes/nvidia-tesla-t4"},
+		},
+	}
+
+	got, err := BuildHostInstance(input)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"nested_virtualization", "cpu_platform:Intel Cascade Lake", "gpu:nvidia-tesla-t4"}
+	if diff := cmp.Diff(want, got.Features); diff != "" {
+		t.Errorf("features mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHostArch(t *testing.T) {
+	tests := map[string]string{
+		"zones/us-central1-a/machineTypes/n1-standard-4": "x86_64",
+		"t2a-standard-4": "arm64",
+		"c4a-highcpu-8":  "arm64",
+		"c4-standard-8":  "x86_64",
+	}
+	for machineType, want := range tests {
+		if got := hostArch(machineType); got != want {
+			t.Errorf("hostArch(%q) = %q, want %q", machineType, got, want)
+		}
+	}
+}
+
+func TestBuildHostInstanceOverlayAddress(t *testing.T) {
+	addr := "100.64.0.7"
+	input := &compute.Instance{
+		Disks:       []*compute.AttachedDisk{{DiskSizeGb: 10}},
+		Name:        "foo",
+		MachineType: "n1-standard-4",
+		Metadata: &compute.Metadata{
+			Items: []*compute.MetadataItems{{Key: "cf-overlay-address", Value: &addr}},
+		},
+	}
+
+	got, err := BuildHostInstance(input)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OverlayAddress != addr {
+		t.Errorf("expected overlay address %q, got: %q", addr, got.OverlayAddress)
+	}
+}
+
+func TestApplyRequiredFeatures(t *testing.T) {
+	newRequest := func(features ...string) *apiv1.CreateHostRequest {
+		return &apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{
+					MachineType: "n1-standard-4",
+					AcceleratorConfigs: []*apiv1.AcceleratorConfig{
+						{AcceleratorCount: 1, AcceleratorType: "nvidia-tesla-t4"},
+					},
+				},
+			},
+			RequireFeatures: features,
+		}
+	}
+
+	req := newRequest("nested_virtualization", "gpu:nvidia-tesla-t4", "cpu_platform:Intel Cascade Lake")
+	if err := applyRequiredFeatures(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.HostInstance.GCP.MinCPUPlatform; got != "Intel Cascade Lake" {
+		t.Errorf("expected the required CPU platform to become the minimum, got: %q", got)
+	}
+
+	for _, f := range []string{"gpu:nvidia-tesla-v100", "tpu"} {
+		if err := applyRequiredFeatures(newRequest(f)); err == nil {
+			t.Errorf("expected %q to be rejected", f)
+		}
+	}
+}
+
 func TestBuildHostInstanceNoDisk(t *testing.T) {
 	input := &compute.Instance{
 		Disks:          []*compute.AttachedDisk{},
@@ -93,6 +93,25 @@ func (c *NetHostClient) Post(path, query string, bodyJSON any, out *HostResponse
 	return res.StatusCode, err
 }
 
+func (c *NetHostClient) Delete(path, query string, out *HostResponse) (int, error) {
+	url := *c.url // Shallow copy
+	url.Path = path
+	url.RawQuery = query
+	req, err := http.NewRequest(http.MethodDelete, url.String(), nil)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create request: %w", err)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("failed to connect to device host: %w", err)
+	}
+	defer res.Body.Close()
+	if out != nil {
+		err = parseReply(res, out.Result, out.Error)
+	}
+	return res.StatusCode, err
+}
+
 func (c *NetHostClient) GetReverseProxy() *httputil.ReverseProxy {
 	devProxy := httputil.NewSingleHostReverseProxy(c.url)
 	if c.client != http.DefaultClient {
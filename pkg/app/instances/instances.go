@@ -39,10 +39,11 @@ type Manager interface {
 }
 
 type HostClient interface {
-	// Get and Post requests return the HTTP status code or an error.
+	// Get, Post and Delete requests return the HTTP status code or an error.
 	// The response body is parsed into the res output parameter if provided.
 	Get(URLPath, URLQuery string, res *HostResponse) (int, error)
 	Post(URLPath, URLQuery string, bodyJSON any, res *HostResponse) (int, error)
+	Delete(URLPath, URLQuery string, res *HostResponse) (int, error)
 	GetReverseProxy() *httputil.ReverseProxy
 }
 
@@ -0,0 +1,48 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/config"
+)
+
+const defaultTURNCredentialTTL = 24 * time.Hour
+
+// Issues time-limited credentials for the TURN servers following the TURN REST API, as implemented
+// by coturn: the username is the expiry timestamp and the user joined by a colon, the credential
+// the base64 encoded HMAC-SHA1 of the username keyed by the shared secret.
+func turnICEServer(cfg *config.TURNConfig, user string, now time.Time) apiv1.IceServer {
+	ttl := defaultTURNCredentialTTL
+	if cfg.CredentialTTLMinutes > 0 {
+		ttl = time.Duration(cfg.CredentialTTLMinutes) * time.Minute
+	}
+	expire := now.Add(ttl).Truncate(time.Second).UTC()
+	username := fmt.Sprintf("%d:%s", expire.Unix(), user)
+	mac := hmac.New(sha1.New, []byte(cfg.SharedSecret))
+	mac.Write([]byte(username))
+	return apiv1.IceServer{
+		URLs:                 cfg.URLs,
+		Username:             username,
+		Credential:           base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		CredentialExpireTime: &expire,
+	}
+}
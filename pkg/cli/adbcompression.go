@@ -0,0 +1,145 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const compressFlag = "compress"
+
+// Algorithm the compressed ADB connections use.
+const deflateCompression = "deflate"
+
+// Hosts with the apiv1.HostFeatureADBCompression feature accept compressed ADB connections opened
+// with the preamble, answering it before the compressed stream starts. Any other answer means the
+// host doesn't compress the connection.
+const (
+	adbCompressionPreamble = "CVD-COMPRESS " + deflateCompression + "\n"
+	adbCompressionAccepted = "OK\n"
+)
+
+const adbCompressionTimeout = 10 * time.Second
+
+var errCompressionRejected = errors.New("host rejected the compressed connection")
+
+// Opens a compressed stream over the connection, which must not have been used before.
+func negotiateCompression(conn net.Conn) (*compressedConn, error) {
+	conn.SetDeadline(time.Now().Add(adbCompressionTimeout))
+	if _, err := io.WriteString(conn, adbCompressionPreamble); err != nil {
+		return nil, fmt.Errorf("failed sending the compression preamble: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	answer, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the host's compression answer: %w", err)
+	}
+	if answer != adbCompressionAccepted {
+		return nil, fmt.Errorf("%w: %q", errCompressionRejected, strings.TrimSpace(answer))
+	}
+	conn.SetDeadline(time.Time{})
+	return newCompressedConn(&bufferedConn{Conn: conn, r: br}), nil
+}
+
+// Compresses the data written to the connection and decompresses the data read from it, counting
+// the bytes before and after compression.
+type compressedConn struct {
+	net.Conn
+	r io.ReadCloser
+	// Writes are flushed right away, ADB waits for the answers to what it sends.
+	wMtx sync.Mutex
+	w    *flate.Writer
+
+	raw  atomic.Int64
+	wire atomic.Int64
+}
+
+func newCompressedConn(conn net.Conn) *compressedConn {
+	c := &compressedConn{Conn: conn}
+	c.r = flate.NewReader(&countingReader{R: conn, N: &c.wire})
+	// Only fails with invalid compression levels.
+	c.w, _ = flate.NewWriter(&countingWriter{W: conn, N: &c.wire}, flate.DefaultCompression)
+	return c
+}
+
+func (c *compressedConn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.raw.Add(int64(n))
+	return n, err
+}
+
+func (c *compressedConn) Write(b []byte) (int, error) {
+	c.wMtx.Lock()
+	defer c.wMtx.Unlock()
+	n, err := c.w.Write(b)
+	c.raw.Add(int64(n))
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+func (c *compressedConn) Close() error {
+	c.wMtx.Lock()
+	c.w.Close()
+	c.wMtx.Unlock()
+	c.r.Close()
+	return c.Conn.Close()
+}
+
+// Returns the bytes forwarded in both directions, and how many of them went through the connection.
+func (c *compressedConn) Counts() (raw, wire int64) {
+	return c.raw.Load(), c.wire.Load()
+}
+
+// Returns how many times smaller the forwarded data was in the connection, zero if nothing was
+// forwarded yet.
+func compressionRatio(raw, wire int64) float64 {
+	if wire == 0 {
+		return 0
+	}
+	return float64(raw) / float64(wire)
+}
+
+type countingReader struct {
+	R io.Reader
+	N *atomic.Int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.R.Read(b)
+	r.N.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	W io.Writer
+	N *atomic.Int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.W.Write(b)
+	w.N.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Serves the host side of a compressed connection, echoing what it receives.
+func serveCompressedEcho(t *testing.T, conn net.Conn, answer string) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	preamble, err := br.ReadString('\n')
+	if err != nil {
+		t.Errorf("failed reading preamble: %v", err)
+		return
+	}
+	if preamble != adbCompressionPreamble {
+		t.Errorf("unexpected preamble: %q", preamble)
+	}
+	if _, err := io.WriteString(conn, answer); err != nil || answer != adbCompressionAccepted {
+		return
+	}
+	r := flate.NewReader(br)
+	w, _ := flate.NewWriter(conn, flate.DefaultCompression)
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+			w.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestCompressedConnRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	go serveCompressedEcho(t, server, adbCompressionAccepted)
+	msg := strings.Repeat("shell:getprop ro.product.name;", 100)
+
+	conn, err := negotiateCompression(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != msg {
+		t.Errorf("expected the message echoed, got: %q", got)
+	}
+	raw, wire := conn.Counts()
+	if raw != int64(2*len(msg)) {
+		t.Errorf("expected %d raw bytes, got: %d", 2*len(msg), raw)
+	}
+	if ratio := compressionRatio(raw, wire); ratio <= 1 {
+		t.Errorf("expected the data compressed, got a ratio of %f", ratio)
+	}
+}
+
+func TestNegotiateCompressionRejected(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go serveCompressedEcho(t, server, "UNSUPPORTED\n")
+
+	_, err := negotiateCompression(client)
+
+	if !errors.Is(err, errCompressionRejected) {
+		t.Errorf("expected rejection error, got: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	adbConnectTimeoutFlag = "adb_connect_timeout"
+	adbConnectRetriesFlag = "adb_connect_retries"
+)
+
+const (
+	defaultADBConnectTimeout = 10 * time.Second
+	defaultADBConnectRetries = 3
+	// Time between failed attempts, gives slow booting devices the chance to accept connections.
+	adbConnectRetryDelay = 2 * time.Second
+)
+
+// How the ADB server is asked to connect to the device. The zero value leaves the defaults to the
+// agent.
+type ADBConnectOpts struct {
+	// Gives up on an attempt after this long.
+	Timeout time.Duration
+	// Attempts made after the first one fails.
+	Retries int
+}
+
+func (o ADBConnectOpts) Validate() error {
+	if o.Timeout <= 0 {
+		return fmt.Errorf("invalid --%s flag value: %s", adbConnectTimeoutFlag, o.Timeout)
+	}
+	if o.Retries < 0 {
+		return fmt.Errorf("invalid --%s flag value: %d", adbConnectRetriesFlag, o.Retries)
+	}
+	return nil
+}
+
+type ADBConnectResult struct {
+	Connected bool `json:"connected"`
+	Attempts  int  `json:"attempts"`
+	// Error of the last attempt, empty if ADB connected.
+	Error string `json:"error,omitempty"`
+}
+
+// Asks the ADB server to connect to the port, retrying failed attempts.
+func connectADB(proxy ADBServerProxy, port int, opts ADBConnectOpts, sleep func(time.Duration)) *ADBConnectResult {
+	result := &ADBConnectResult{}
+	for {
+		result.Attempts++
+		err := proxy.Connect(port, opts.Timeout)
+		if err == nil {
+			result.Connected = true
+			result.Error = ""
+			return result
+		}
+		result.Error = err.Error()
+		if result.Attempts > opts.Retries {
+			return result
+		}
+		sleep(adbConnectRetryDelay)
+	}
+}
+
+func addADBConnectFlags(c *cobra.Command, opts *ADBConnectOpts) {
+	c.Flags().DurationVar(&opts.Timeout, adbConnectTimeoutFlag, defaultADBConnectTimeout,
+		"Fails an attempt of ADB to connect to the device after this long")
+	c.Flags().IntVar(&opts.Retries, adbConnectRetriesFlag, defaultADBConnectRetries,
+		"Attempts of ADB to connect to the device after the first one fails")
+}
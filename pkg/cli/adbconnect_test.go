@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Fails the first `failures` connects.
+type flakyADBServerProxy struct {
+	fakeADBServerProxy
+	failures int
+	timeouts []time.Duration
+}
+
+func (p *flakyADBServerProxy) Connect(port int, timeout time.Duration) error {
+	p.timeouts = append(p.timeouts, timeout)
+	if len(p.timeouts) <= p.failures {
+		return errors.New("failed to connect to 127.0.0.1:6520")
+	}
+	return nil
+}
+
+func TestConnectADB(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		want     *ADBConnectResult
+	}{
+		{name: "first attempt", want: &ADBConnectResult{Connected: true, Attempts: 1}},
+		{name: "after retries", failures: 2, want: &ADBConnectResult{Connected: true, Attempts: 3}},
+		{
+			name:     "out of retries",
+			failures: 5,
+			want:     &ADBConnectResult{Attempts: 3, Error: "failed to connect to 127.0.0.1:6520"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy := &flakyADBServerProxy{failures: tc.failures}
+			slept := time.Duration(0)
+			opts := ADBConnectOpts{Timeout: 5 * time.Second, Retries: 2}
+
+			got := connectADB(proxy, 6520, opts, func(d time.Duration) { slept += d })
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("result mismatch (-want +got):\n%s", diff)
+			}
+			if want := time.Duration(got.Attempts-1) * adbConnectRetryDelay; slept != want {
+				t.Errorf("expected to sleep %v, got: %v", want, slept)
+			}
+			for _, timeout := range proxy.timeouts {
+				if timeout != opts.Timeout {
+					t.Errorf("expected timeout %v, got: %v", opts.Timeout, timeout)
+				}
+			}
+		})
+	}
+}
+
+func TestADBConnectOptsValidate(t *testing.T) {
+	if err := (ADBConnectOpts{Timeout: time.Second}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (ADBConnectOpts{}).Validate(); err == nil {
+		t.Error("expected error for zero timeout")
+	}
+	if err := (ADBConnectOpts{Timeout: time.Second, Retries: -1}).Validate(); err == nil {
+		t.Error("expected error for negative retries")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const adbProxyFlag = "adb_proxy"
+
+// Proxies the ADB connections are tunnelled through, when they don't go through the service.
+type ADBProxyConfig struct {
+	// URL of the proxy, as http://host[:port] or https://host[:port]. Defaults to the service's
+	// `Proxy`.
+	URL string `json:"url,omitempty"`
+	// [OPTIONAL] Credentials sent to the proxy, credentials in the URL take precedence.
+	BasicAuthn *ProxyBasicAuthnConfig `json:"basic_authn,omitempty"`
+}
+
+type ProxyBasicAuthnConfig struct {
+	Username string `json:"username,omitempty"`
+	// File holding the password, trailing whitespace is ignored.
+	PasswordFile string `json:"password_file,omitempty"`
+}
+
+func (c *ProxyBasicAuthnConfig) userinfo() (*url.Userinfo, error) {
+	if c == nil {
+		return nil, nil
+	}
+	b, err := os.ReadFile(ExpandPath(c.PasswordFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the proxy password: %w", err)
+	}
+	return url.UserPassword(c.Username, strings.TrimRight(string(b), " \t\r\n")), nil
+}
+
+// The proxy dialing timeout, the tunnel stays open for as long as the connection.
+const httpConnectTimeout = 30 * time.Second
+
+// Dials through an HTTP proxy, tunnelling the connections with CONNECT requests.
+type httpConnectDialer struct {
+	ProxyURL *url.URL
+	// Sent to the proxy in the Proxy-Authorization header if not nil.
+	User *url.Userinfo
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("network %q can't be tunnelled through an http proxy", network)
+	}
+	proxyAddr := d.ProxyURL.Host
+	if d.ProxyURL.Port() == "" {
+		port := "80"
+		if d.ProxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.ProxyURL.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: httpConnectTimeout}
+	var conn net.Conn
+	var err error
+	if d.ProxyURL.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", proxyAddr, &tls.Config{ServerName: d.ProxyURL.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", proxyAddr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial proxy %s: %w", d.ProxyURL.Redacted(), err)
+	}
+	tunnel, err := d.connect(conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+func (d *httpConnectDialer) connect(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.User != nil {
+		password, _ := d.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(d.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	conn.SetDeadline(time.Now().Add(httpConnectTimeout))
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed sending the connect request to the proxy: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the proxy response: %w", err)
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusProxyAuthRequired:
+		return nil, fmt.Errorf("proxy %s requires authentication, see the adb proxy configuration", d.ProxyURL.Redacted())
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("proxy %s refused the tunnel to %s: %s", d.ProxyURL.Redacted(), addr, res.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// Reads the bytes the proxy sent after its response, which were buffered while reading it.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Accepts a single CONNECT request, replying with `status`. Tunnels that are established greet
+// the client and then echo what they receive.
+func startFakeHTTPProxy(t *testing.T, status int) (string, <-chan *http.Request) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	reqs := make(chan *http.Request, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		reqs <- req
+		res := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+		if err := res.Write(conn); err != nil || status != http.StatusOK {
+			return
+		}
+		conn.Write([]byte("hello"))
+		io.Copy(conn, br)
+	}()
+	return "http://" + l.Addr().String(), reqs
+}
+
+func TestHTTPConnectDialer(t *testing.T) {
+	proxyURL, reqs := startFakeHTTPProxy(t, http.StatusOK)
+	u, _ := url.Parse(proxyURL)
+	dialer := &httpConnectDialer{ProxyURL: u, User: url.UserPassword("user", "secret")}
+
+	conn, err := dialer.Dial("tcp", "10.0.0.2:6520")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := <-reqs
+	if req.Method != http.MethodConnect || req.Host != "10.0.0.2:6520" {
+		t.Errorf("expected a CONNECT request to 10.0.0.2:6520, got: %s %s", req.Method, req.Host)
+	}
+	if got := req.Header.Get("Proxy-Authorization"); got != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("unexpected proxy authorization: %q", got)
+	}
+	if _, err := conn.Write([]byte(" adb")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len("hello adb"))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello adb" {
+		t.Errorf("expected %q through the tunnel, got: %q", "hello adb", string(b))
+	}
+}
+
+func TestHTTPConnectDialerAuthRequired(t *testing.T) {
+	proxyURL, _ := startFakeHTTPProxy(t, http.StatusProxyAuthRequired)
+	u, _ := url.Parse(proxyURL)
+	dialer := &httpConnectDialer{ProxyURL: u}
+
+	_, err := dialer.Dial("tcp", "10.0.0.2:6520")
+
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestProxyDialerConfiguredCredentials(t *testing.T) {
+	password := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(password, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dialer, err := proxyDialer("http://proxy:3128", &ProxyBasicAuthnConfig{Username: "user", PasswordFile: password}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := dialer.(*httpConnectDialer)
+	if !ok {
+		t.Fatalf("expected an http connect dialer, got: %T", dialer)
+	}
+	if got, _ := d.User.Password(); d.User.Username() != "user" || got != "secret" {
+		t.Errorf("unexpected proxy credentials: %s", d.User)
+	}
+}
@@ -16,11 +16,17 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ADBServerProxy interface {
-	Connect(port int) error
+	// Fails if the ADB server doesn't connect to the port within the timeout, zero waits
+	// indefinitely.
+	Connect(port int, timeout time.Duration) error
 	ConnectWithLocalFileSystem(path string) error
 	Disconnect(port int) error
 	DisconnectWithLocalFileSystem(path string) error
@@ -35,8 +41,28 @@ func (p *ADBServerProxyImpl) connect(adbSerial string) error {
 	return p.sendMsg(msg)
 }
 
-func (p *ADBServerProxyImpl) Connect(port int) error {
-	return p.connect(fmt.Sprintf("127.0.0.1:%d", port))
+func (p *ADBServerProxyImpl) Connect(port int, timeout time.Duration) error {
+	adbSerial := fmt.Sprintf("127.0.0.1:%d", port)
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", ADBServerPort), timeout)
+	if err != nil {
+		return fmt.Errorf("unable to contact ADB server: %w", err)
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := adbRequest(conn, "host:connect:"+adbSerial); err != nil {
+		return err
+	}
+	// The server replies "connected to ..." or "failed to connect to ..." even when it fails.
+	msg, err := readADBString(conn)
+	if err != nil {
+		return fmt.Errorf("error reading ADB server reply: %w", err)
+	}
+	if !strings.HasPrefix(msg, "connected to") && !strings.HasPrefix(msg, "already connected to") {
+		return fmt.Errorf("ADB failed to connect to %s: %s", adbSerial, msg)
+	}
+	return nil
 }
 
 func (p *ADBServerProxyImpl) ConnectWithLocalFileSystem(path string) error {
@@ -73,3 +99,73 @@ func (*ADBServerProxyImpl) sendMsg(msg string) error {
 	}
 	return nil
 }
+
+// Opens a stream to the TCP port of the device with the given serial, through the ADB server.
+func dialADBDevicePort(adbSerial string, port int) (net.Conn, error) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", ADBServerPort))
+	if err != nil {
+		return nil, fmt.Errorf("unable to contact ADB server: %w", err)
+	}
+	for _, req := range []string{"host:transport:" + adbSerial, fmt.Sprintf("tcp:%d", port)} {
+		if err := adbRequest(conn, req); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Runs the shell command in the device with the given serial through the ADB server, returning
+// its output. Fails if the command doesn't finish within the timeout, zero waits indefinitely.
+func runADBShell(adbSerial, cmd string, timeout time.Duration) ([]byte, error) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", ADBServerPort))
+	if err != nil {
+		return nil, fmt.Errorf("unable to contact ADB server: %w", err)
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	for _, req := range []string{"host:transport:" + adbSerial, "shell:" + cmd} {
+		if err := adbRequest(conn, req); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(conn)
+}
+
+// Sends the request and reads the ADB server's status, the failure message follows a FAIL status.
+func adbRequest(conn net.Conn, req string) error {
+	if _, err := fmt.Fprintf(conn, "%.4x%s", len(req), req); err != nil {
+		return fmt.Errorf("error sending message to ADB server: %w", err)
+	}
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return fmt.Errorf("error reading ADB server reply: %w", err)
+	}
+	if string(status) == "OKAY" {
+		return nil
+	}
+	msg, err := readADBString(conn)
+	if err != nil {
+		return fmt.Errorf("ADB server request %q failed", req)
+	}
+	return fmt.Errorf("ADB server request %q failed: %s", req, msg)
+}
+
+// Reads a string prefixed by its length in hex, as the ADB server sends them.
+func readADBString(r io.Reader) (string, error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseInt(string(length), 16, 32)
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v3"
+)
+
+// The desired set of devices of a fleet. Specs are JSON files, like environment specifications, or
+// YAML files with the same fields.
+//
+// Devices are matched by host and main build: the host orchestrator doesn't keep names or
+// labels, they only identify the entries in the plan and aren't applied to the devices.
+type FleetSpec struct {
+	Devices []*DeviceSpec `json:"devices"`
+}
+
+type DeviceSpec struct {
+	Name string `json:"name"`
+	// [REQUIRED]
+	Host  string               `json:"host"`
+	Build hoapi.AndroidCIBuild `json:"build"`
+	// Defaults to 1.
+	NumInstances int               `json:"num_instances,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+func (s *DeviceSpec) instances() int {
+	if s.NumInstances <= 0 {
+		return 1
+	}
+	return s.NumInstances
+}
+
+// Whether the device was created from the build in the spec.
+func (s *DeviceSpec) matches(cvd *hoapi.CVD) bool {
+	if cvd.BuildSource == nil || cvd.BuildSource.AndroidCIBuildSource == nil {
+		return false
+	}
+	b := cvd.BuildSource.AndroidCIBuildSource.MainBuild
+	if b == nil || b.Target != s.Build.Target {
+		return false
+	}
+	if s.Build.BuildID != "" {
+		return b.BuildID == s.Build.BuildID
+	}
+	return b.Branch == s.Build.Branch
+}
+
+func loadFleetSpec(name string) (*FleetSpec, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading spec file: %w", err)
+	}
+	if ext := strings.ToLower(filepath.Ext(name)); ext == ".yaml" || ext == ".yml" {
+		// Converted to JSON to decode with the same field names and checks.
+		var v any
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("invalid spec file %q: %w", name, err)
+		}
+		if b, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("invalid spec file %q: %w", name, err)
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	spec := &FleetSpec{}
+	if err := decoder.Decode(spec); err != nil {
+		return nil, fmt.Errorf("invalid spec file %q: %w", name, err)
+	}
+	for i, d := range spec.Devices {
+		if d.Host == "" {
+			return nil, fmt.Errorf("invalid spec file %q: missing host of device %d", name, i)
+		}
+		if d.Build.Target == "" || (d.Build.Branch == "" && d.Build.BuildID == "") {
+			return nil, fmt.Errorf("invalid spec file %q: device %d needs a build target and either a branch or a build id", name, i)
+		}
+	}
+	return spec, nil
+}
+
+type applyCreate struct {
+	Spec *DeviceSpec
+	// Number of instances missing.
+	NumInstances int
+}
+
+type applyKeep struct {
+	Spec *DeviceSpec
+	CVDs []*hoapi.CVD
+}
+
+type applyDelete struct {
+	Host string
+	CVD  *hoapi.CVD
+}
+
+type ApplyPlan struct {
+	Create []applyCreate
+	Keep   []applyKeep
+	Delete []applyDelete
+}
+
+func (p *ApplyPlan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Delete) == 0
+}
+
+// Reconciles the spec with the devices running in each host of the spec, hosts not in it are left
+// alone. Devices not claimed by any spec entry are only deleted if `prune` is true.
+func planApply(spec *FleetSpec, running map[string][]*hoapi.CVD, prune bool) *ApplyPlan {
+	plan := &ApplyPlan{}
+	claimed := make(map[*hoapi.CVD]bool)
+	for _, d := range spec.Devices {
+		matching := []*hoapi.CVD{}
+		for _, cvd := range running[d.Host] {
+			if len(matching) < d.instances() && !claimed[cvd] && d.matches(cvd) {
+				matching = append(matching, cvd)
+				claimed[cvd] = true
+			}
+		}
+		if len(matching) > 0 {
+			plan.Keep = append(plan.Keep, applyKeep{Spec: d, CVDs: matching})
+		}
+		if missing := d.instances() - len(matching); missing > 0 {
+			plan.Create = append(plan.Create, applyCreate{Spec: d, NumInstances: missing})
+		}
+	}
+	if prune {
+		hosts := []string{}
+		for h := range running {
+			hosts = append(hosts, h)
+		}
+		sort.Strings(hosts)
+		for _, h := range hosts {
+			for _, cvd := range running[h] {
+				if !claimed[cvd] {
+					plan.Delete = append(plan.Delete, applyDelete{Host: h, CVD: cvd})
+				}
+			}
+		}
+	}
+	return plan
+}
+
+func listSpecHostsCVDs(service client.Service, spec *FleetSpec) (map[string][]*hoapi.CVD, error) {
+	result := make(map[string][]*hoapi.CVD)
+	for _, d := range spec.Devices {
+		if _, ok := result[d.Host]; ok {
+			continue
+		}
+		cvds, err := service.HostService(d.Host).ListCVDs()
+		if err != nil {
+			return nil, fmt.Errorf("failed listing devices in host %q: %w", d.Host, err)
+		}
+		result[d.Host] = cvds
+	}
+	return result, nil
+}
+
+func writeApplyPlan(w io.Writer, plan *ApplyPlan) {
+	if plan.Empty() {
+		fmt.Fprintln(w, "No changes, the devices match the spec")
+		return
+	}
+	for _, e := range plan.Create {
+		fmt.Fprintf(w, "  + create %d instance(s) of %s in %s (%s)\n",
+			e.NumInstances, specDisplayName(e.Spec), e.Spec.Host, androidCIBuildRef(e.Spec.Build))
+	}
+	for _, e := range plan.Keep {
+		for _, cvd := range e.CVDs {
+			fmt.Fprintf(w, "  = keep %s/%s as %s\n", e.Spec.Host, cvd.Name, specDisplayName(e.Spec))
+		}
+	}
+	for _, e := range plan.Delete {
+		fmt.Fprintf(w, "  - delete %s/%s\n", e.Host, e.CVD.Name)
+	}
+}
+
+func specDisplayName(s *DeviceSpec) string {
+	name := s.Name
+	if name == "" {
+		name = "<unnamed>"
+	}
+	if len(s.Labels) == 0 {
+		return name
+	}
+	keys := []string{}
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	name += " ["
+	for i, k := range keys {
+		if i > 0 {
+			name += ", "
+		}
+		name += k + "=" + s.Labels[k]
+	}
+	return name + "]"
+}
+
+// Deletes first to free the hosts' capacity for the devices created after.
+func executeApplyPlan(service client.Service, plan *ApplyPlan, credentialsSource string, store CredentialStore, statePrinter *statePrinter) error {
+	var merr error
+	for _, e := range plan.Delete {
+		state := fmt.Sprintf("Deleting %s/%s", e.Host, e.CVD.Name)
+		statePrinter.Print(state)
+		err := service.HostService(e.Host).DeleteCVD(e.CVD.ID())
+		statePrinter.PrintDone(state, err)
+		if err != nil {
+			err := fmt.Errorf("failed deleting %s/%s: %w", e.Host, e.CVD.Name, err)
+			merr = multierror.Append(merr, &hostError{Host: e.Host, Err: err})
+		}
+	}
+	for _, e := range plan.Create {
+		opts := CreateCVDOpts{
+			Host:                      e.Spec.Host,
+			MainBuild:                 e.Spec.Build,
+			NumInstances:              e.NumInstances,
+			BuildAPICredentialsSource: credentialsSource,
+			CredentialStore:           store,
+		}
+		if _, err := createCVD(service, opts, statePrinter); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed creating %s: %w", specDisplayName(e.Spec), err))
+		}
+	}
+	return merr
+}
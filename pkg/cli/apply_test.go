@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+func ciCVD(name, branch, target string) *hoapi.CVD {
+	return &hoapi.CVD{
+		Name: name,
+		BuildSource: &hoapi.BuildSource{
+			AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{
+				MainBuild: &hoapi.AndroidCIBuild{Branch: branch, Target: target},
+			},
+		},
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	phone := hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"}
+	spec := &FleetSpec{
+		Devices: []*DeviceSpec{
+			{Name: "phones", Host: "foo", Build: phone, NumInstances: 2},
+			{Name: "tv", Host: "foo", Build: hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_tv-userdebug"}},
+		},
+	}
+	running := map[string][]*hoapi.CVD{
+		"foo": {
+			ciCVD("cvd-1", "aosp-main", "aosp_cf_x86_64_phone-userdebug"),
+			ciCVD("cvd-2", "aosp-main", "aosp_cf_x86_64_wear-userdebug"),
+		},
+	}
+
+	plan := planApply(spec, running, true)
+
+	out := &bytes.Buffer{}
+	writeApplyPlan(out, plan)
+	exp := "  + create 1 instance(s) of phones in foo (@ab/aosp-main/aosp_cf_x86_64_phone-userdebug)\n" +
+		"  + create 1 instance(s) of tv in foo (@ab/aosp-main/aosp_cf_x86_64_tv-userdebug)\n" +
+		"  = keep foo/cvd-1 as phones\n" +
+		"  - delete foo/cvd-2\n"
+	if diff := cmp.Diff(exp, out.String()); diff != "" {
+		t.Errorf("plan mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPlanApplyWithoutPruneKeepsUnclaimed(t *testing.T) {
+	spec := &FleetSpec{}
+	running := map[string][]*hoapi.CVD{"foo": {ciCVD("cvd-1", "aosp-main", "aosp_cf_x86_64_phone-userdebug")}}
+
+	plan := planApply(spec, running, false)
+
+	if !plan.Empty() {
+		t.Errorf("expected empty plan, got: %+v", plan)
+	}
+}
+
+func TestLoadFleetSpecMissingHost(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "devices.json")
+	content := `{"devices": [{"name": "phone", "build": {"branch": "aosp-main", "target": "aosp_cf_x86_64_phone-userdebug"}}]}`
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadFleetSpec(name); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLoadFleetSpecYAML(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "devices.yaml")
+	content := `devices:
+  - name: phone
+    host: foo
+    build:
+      branch: aosp-main
+      target: aosp_cf_x86_64_phone-userdebug
+    num_instances: 2
+    labels:
+      team: frameworks
+`
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := loadFleetSpec(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &FleetSpec{Devices: []*DeviceSpec{{
+		Name:         "phone",
+		Host:         "foo",
+		Build:        hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		NumInstances: 2,
+		Labels:       map[string]string{"team": "frameworks"},
+	}}}
+	if diff := cmp.Diff(exp, spec); diff != "" {
+		t.Errorf("spec mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadFleetSpecYAMLUnknownField(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "devices.yml")
+	content := "devices:\n  - host: foo\n    instances: 2\n"
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadFleetSpec(name); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+// Artifacts needed to create a device from a build, as glob patterns.
+var requiredBuildArtifacts = []string{
+	CVDHostPackageName,
+	"*-img-*.zip",
+}
+
+type BuildValidation struct {
+	BuildID string `json:"build_id"`
+	Target  string `json:"target"`
+	Exists  bool   `json:"exists"`
+	// Required artifacts missing from the build.
+	MissingArtifacts []string               `json:"missing_artifacts"`
+	Artifacts        []client.BuildArtifact `json:"artifacts"`
+}
+
+func (v *BuildValidation) Valid() bool {
+	return v.Exists && len(v.MissingArtifacts) == 0
+}
+
+// Checks the build exists in the build server and it has the artifacts needed to create a device.
+// The latest green build of the branch is used if the build doesn't have an id.
+func validateBuild(api client.BuildAPI, build hoapi.AndroidCIBuild) (*BuildValidation, error) {
+	if build.Target == "" {
+		return nil, errors.New("missing build target")
+	}
+	result := &BuildValidation{BuildID: build.BuildID, Target: build.Target}
+	if result.BuildID == "" {
+		if build.Branch == "" {
+			return nil, errors.New("missing build id or branch")
+		}
+		latest, err := api.LatestGreenBuild(build.Branch, build.Target)
+		if errors.Is(err, client.ErrBuildNotFound) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed getting latest build of branch %q: %w", build.Branch, err)
+		}
+		result.BuildID = latest.BuildID
+	}
+	artifacts, err := api.ListArtifacts(result.BuildID, build.Target)
+	if errors.Is(err, client.ErrBuildNotFound) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed listing build artifacts: %w", err)
+	}
+	result.Exists = true
+	result.Artifacts = artifacts
+	result.MissingArtifacts = missingArtifacts(artifacts, requiredBuildArtifacts)
+	return result, nil
+}
+
+// Pins the build to the latest green build of its branch, failing if that build is older than
+// `maxAge`. Builds with an id are used as given, the age is only checked when resolving a branch.
+func resolveBuildWithMaxAge(api client.BuildAPI, build hoapi.AndroidCIBuild, maxAge time.Duration, now time.Time) (hoapi.AndroidCIBuild, error) {
+	if build.BuildID != "" {
+		return build, nil
+	}
+	latest, err := api.LatestGreenBuild(build.Branch, build.Target)
+	if err != nil {
+		return build, fmt.Errorf("failed getting latest build of branch %q: %w", build.Branch, err)
+	}
+	if latest.CreationTime.IsZero() {
+		return build, fmt.Errorf("unknown age of build %s, the build server didn't report its creation time", latest.BuildID)
+	}
+	if age := now.Sub(latest.CreationTime); age > maxAge {
+		return build, fmt.Errorf("latest green build %s of branch %q is %s old, more than --%s=%s: "+
+			"pass --%s=%s or a larger --%s to use it anyway",
+			latest.BuildID, build.Branch, formatBuildAge(age), maxBuildAgeFlag, formatBuildAge(maxAge),
+			buildIDFlag, latest.BuildID, maxBuildAgeFlag)
+	}
+	build.BuildID = latest.BuildID
+	return build, nil
+}
+
+// Pins the build to the latest successful presubmit build of the change's current patchset, among
+// the builds of the build's branch and target.
+func resolveGerritChangeBuild(gerrit client.GerritAPI, api client.BuildAPI, changeID string, build hoapi.AndroidCIBuild) (hoapi.AndroidCIBuild, error) {
+	change, err := gerrit.GetChange(changeID)
+	if errors.Is(err, client.ErrChangeNotFound) {
+		return build, fmt.Errorf("gerrit change %q not found", changeID)
+	}
+	if err != nil {
+		return build, fmt.Errorf("failed getting gerrit change %q: %w", changeID, err)
+	}
+	latest, err := api.LatestChangeBuild(change.Number, change.CurrentPatchset, build.Branch, build.Target)
+	if errors.Is(err, client.ErrBuildNotFound) {
+		return build, fmt.Errorf("no successful %s build of patchset %d of change %d found in branch %q, "+
+			"its presubmit may not have finished or may have run in another branch, choose it with --%s",
+			build.Target, change.CurrentPatchset, change.Number, build.Branch, branchFlag)
+	}
+	if err != nil {
+		return build, fmt.Errorf("failed getting the builds of change %d: %w", change.Number, err)
+	}
+	build.BuildID = latest.BuildID
+	return build, nil
+}
+
+// Accepts a number of days, i.e: "7d", besides the time.ParseDuration format.
+func parseBuildAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid build age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid build age %q", s)
+	}
+	return d, nil
+}
+
+// Formats ages in days and hours, i.e: "9d4h".
+func formatBuildAge(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int((d % (24 * time.Hour)) / time.Hour)
+	switch {
+	case days == 0:
+		return fmt.Sprintf("%dh", hours)
+	case hours == 0:
+		return fmt.Sprintf("%dd", days)
+	default:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	}
+}
+
+func missingArtifacts(artifacts []client.BuildArtifact, required []string) []string {
+	result := []string{}
+	for _, pattern := range required {
+		found := false
+		for _, a := range artifacts {
+			if ok, _ := path.Match(pattern, a.Name); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, pattern)
+		}
+	}
+	return result
+}
+
+func writeBuildValidation(w io.Writer, v *BuildValidation) {
+	answer := "no"
+	if v.Valid() {
+		answer = "yes"
+	}
+	id := v.BuildID
+	if id == "" {
+		id = "<none>"
+	}
+	fmt.Fprintf(w, "Build %s for %s is valid: %s\n", id, v.Target, answer)
+	if !v.Exists {
+		fmt.Fprintln(w, "  Build not found")
+		return
+	}
+	if len(v.MissingArtifacts) > 0 {
+		fmt.Fprintf(w, "  Missing artifacts: %s\n", strings.Join(v.MissingArtifacts, ", "))
+	}
+	fmt.Fprintln(w, "  Artifacts:")
+	for _, a := range v.Artifacts {
+		fmt.Fprintf(w, "    %s (%s bytes)\n", a.Name, a.Size)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+	"github.com/google/go-cmp/cmp"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+type fakeBuildAPI struct {
+	latest     string
+	latestTime time.Time
+	artifacts  map[string][]client.BuildArtifact
+	// By "change/patchset".
+	changeBuilds map[string]string
+	// By branch.
+	targets map[string][]string
+	// Number of ListTargets calls.
+	targetListings int
+}
+
+func (a *fakeBuildAPI) LatestGreenBuild(branch, target string) (*client.Build, error) {
+	if a.latest == "" {
+		return nil, client.ErrBuildNotFound
+	}
+	return &client.Build{BuildID: a.latest, CreationTime: a.latestTime}, nil
+}
+
+func (a *fakeBuildAPI) ListArtifacts(buildID, target string) ([]client.BuildArtifact, error) {
+	artifacts, ok := a.artifacts[buildID]
+	if !ok {
+		return nil, client.ErrBuildNotFound
+	}
+	return artifacts, nil
+}
+
+func (a *fakeBuildAPI) LatestChangeBuild(change, patchset int, branch, target string) (*client.Build, error) {
+	id, ok := a.changeBuilds[fmt.Sprintf("%d/%d", change, patchset)]
+	if !ok {
+		return nil, client.ErrBuildNotFound
+	}
+	return &client.Build{BuildID: id}, nil
+}
+
+func (a *fakeBuildAPI) ListTargets(branch string) ([]string, error) {
+	a.targetListings++
+	targets, ok := a.targets[branch]
+	if !ok {
+		return nil, client.ErrBranchNotFound
+	}
+	return append([]string{}, targets...), nil
+}
+
+func TestValidateBuild(t *testing.T) {
+	api := &fakeBuildAPI{
+		latest: "123",
+		artifacts: map[string][]client.BuildArtifact{
+			"123": {{Name: CVDHostPackageName}, {Name: "aosp_cf_x86_64_phone-img-123.zip"}},
+			"456": {{Name: CVDHostPackageName}},
+		},
+	}
+	tests := []struct {
+		build      hoapi.AndroidCIBuild
+		expValid   bool
+		expMissing []string
+	}{
+		{hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "foo"}, true, []string{}},
+		{hoapi.AndroidCIBuild{BuildID: "456", Target: "foo"}, false, []string{"*-img-*.zip"}},
+		{hoapi.AndroidCIBuild{BuildID: "789", Target: "foo"}, false, nil},
+	}
+	for _, tc := range tests {
+		got, err := validateBuild(api, tc.build)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Valid() != tc.expValid {
+			t.Errorf("build %+v: expected valid: %t, got: %t", tc.build, tc.expValid, got.Valid())
+		}
+		if diff := cmp.Diff(tc.expMissing, got.MissingArtifacts); diff != "" {
+			t.Errorf("build %+v: missing artifacts mismatch (-want +got):\n%s", tc.build, diff)
+		}
+	}
+}
+
+func TestResolveBuildWithMaxAge(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	api := &fakeBuildAPI{latest: "123", latestTime: now.Add(-9*24*time.Hour - 4*time.Hour)}
+	build := hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "foo"}
+
+	got, err := resolveBuildWithMaxAge(api, build, 10*24*time.Hour, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BuildID != "123" {
+		t.Errorf("expected build 123, got %q", got.BuildID)
+	}
+	if _, err := resolveBuildWithMaxAge(api, build, 7*24*time.Hour, now); err == nil {
+		t.Error("expected error")
+	}
+}
+
+type fakeGerritAPI struct {
+	changes map[string]*client.GerritChange
+}
+
+func (g *fakeGerritAPI) GetChange(id string) (*client.GerritChange, error) {
+	change, ok := g.changes[id]
+	if !ok {
+		return nil, client.ErrChangeNotFound
+	}
+	return change, nil
+}
+
+func TestResolveGerritChangeBuild(t *testing.T) {
+	gerrit := &fakeGerritAPI{changes: map[string]*client.GerritChange{
+		"12345": {Number: 12345, Branch: "main", CurrentPatchset: 3},
+		"67890": {Number: 67890, Branch: "main", CurrentPatchset: 1},
+	}}
+	api := &fakeBuildAPI{changeBuilds: map[string]string{"12345/3": "P456", "12345/2": "P123"}}
+	build := hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "foo"}
+
+	got, err := resolveGerritChangeBuild(gerrit, api, "12345", build)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(hoapi.AndroidCIBuild{Branch: "aosp-main", BuildID: "P456", Target: "foo"}, got); diff != "" {
+		t.Errorf("build mismatch (-want +got):\n%s", diff)
+	}
+	// Without builds.
+	if _, err := resolveGerritChangeBuild(gerrit, api, "67890", build); err == nil {
+		t.Error("expected error for a change without builds")
+	}
+	if _, err := resolveGerritChangeBuild(gerrit, api, "11111", build); err == nil {
+		t.Error("expected error for an unknown change")
+	}
+}
+
+func TestParseBuildAge(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp time.Duration
+	}{
+		{"7d", 7 * 24 * time.Hour},
+		{"36h", 36 * time.Hour},
+	}
+	for _, tc := range tests {
+		got, err := parseBuildAge(tc.in)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.exp {
+			t.Errorf("parseBuildAge(%q) = %v, expected %v", tc.in, got, tc.exp)
+		}
+	}
+	if _, err := parseBuildAge("-1d"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+type DefaultsFlags struct {
+	*CVDRemoteFlags
+	Format string
+}
+
+type SetDefaultsFlags struct {
+	*CVDRemoteFlags
+	apiv1.BuildDefaults
+}
+
+func validateBuildDefaults(d *apiv1.BuildDefaults) error {
+	if d.Branch != "" && d.BuildID != "" {
+		return errors.New("only one of branch and build id can be set")
+	}
+	return nil
+}
+
+// Returns nil if the configuration sets no defaults.
+func (c *BuildDefaultsConfig) asBuildDefaults() (*apiv1.BuildDefaults, error) {
+	if c == nil || *c == (BuildDefaultsConfig{}) {
+		return nil, nil
+	}
+	d := &apiv1.BuildDefaults{Branch: c.Branch, BuildID: c.BuildID, Target: c.Target}
+	if err := validateBuildDefaults(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Returns the defaults stored for the user in the service, nil if none were set or the service
+// doesn't store them.
+func serviceBuildDefaults(service client.Service) (*apiv1.BuildDefaults, error) {
+	d, err := service.GetDefaults()
+	var apiErr *client.ApiCallError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if *d == (apiv1.BuildDefaults{}) {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// Replaces the build source with the defaults, the target is kept if `keepTarget` is set. Returns
+// whether the target was replaced.
+func applyBuildDefaults(build *hoapi.AndroidCIBuild, d *apiv1.BuildDefaults, keepTarget bool) bool {
+	if d.BuildID != "" {
+		build.BuildID = d.BuildID
+		build.Branch = ""
+	} else if d.Branch != "" {
+		build.Branch = d.Branch
+	}
+	if d.Target == "" || keepTarget {
+		return false
+	}
+	build.Target = d.Target
+	return true
+}
+
+func writeBuildDefaults(w io.Writer, d *apiv1.BuildDefaults) {
+	if *d == (apiv1.BuildDefaults{}) {
+		fmt.Fprintln(w, "No defaults set, the built-in ones are used")
+		return
+	}
+	if d.Branch != "" {
+		fmt.Fprintf(w, "Branch: %s\n", d.Branch)
+	}
+	if d.BuildID != "" {
+		fmt.Fprintf(w, "Build ID: %s\n", d.BuildID)
+	}
+	if d.Target != "" {
+		fmt.Fprintf(w, "Target: %s\n", d.Target)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"net/http"
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyBuildDefaults(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaults      apiv1.BuildDefaults
+		keepTarget    bool
+		exp           hoapi.AndroidCIBuild
+		targetChanged bool
+	}{
+		{
+			name:          "branch and target",
+			defaults:      apiv1.BuildDefaults{Branch: "git_main", Target: "foo-userdebug"},
+			exp:           hoapi.AndroidCIBuild{Branch: "git_main", Target: "foo-userdebug"},
+			targetChanged: true,
+		},
+		{
+			name:     "build id replaces branch",
+			defaults: apiv1.BuildDefaults{BuildID: "1234"},
+			exp:      hoapi.AndroidCIBuild{BuildID: "1234", Target: "aosp_cf_x86_64_phone-userdebug"},
+		},
+		{
+			name:       "target kept",
+			defaults:   apiv1.BuildDefaults{Branch: "git_main", Target: "foo-userdebug"},
+			keepTarget: true,
+			exp:        hoapi.AndroidCIBuild{Branch: "git_main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"}
+
+			changed := applyBuildDefaults(&build, &tc.defaults, tc.keepTarget)
+
+			if diff := cmp.Diff(tc.exp, build); diff != "" {
+				t.Errorf("build mismatch (-want +got):\n%s", diff)
+			}
+			if changed != tc.targetChanged {
+				t.Errorf("expected target changed %t, got: %t", tc.targetChanged, changed)
+			}
+		})
+	}
+}
+
+func TestBuildDefaultsConfigBranchAndBuildID(t *testing.T) {
+	config := &BuildDefaultsConfig{Branch: "git_main", BuildID: "1234"}
+
+	_, err := config.asBuildDefaults()
+
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+type noDefaultsService struct {
+	fakeService
+}
+
+func (noDefaultsService) GetDefaults() (*apiv1.BuildDefaults, error) {
+	return nil, &client.ApiCallError{Code: http.StatusNotFound}
+}
+
+func TestServiceBuildDefaultsNotStored(t *testing.T) {
+	d, err := serviceBuildDefaults(&noDefaultsService{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != nil {
+		t.Errorf("expected no defaults, got: %+v", d)
+	}
+}
@@ -24,12 +24,18 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
 	"os/user"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	client "github.com/google/cloud-android-orchestration/pkg/client"
 	wclient "github.com/google/cloud-android-orchestration/pkg/webrtcclient"
 
@@ -38,6 +44,8 @@ import (
 	"github.com/spf13/cobra"
 	"golang.org/x/net/proxy"
 	"golang.org/x/term"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
 )
 
 // Groups streams for standard IO.
@@ -63,19 +71,38 @@ type CommandOptions struct {
 	ServiceBuilder client.ServiceBuilder
 	CommandRunner  CommandRunner
 	ADBServerProxy ADBServerProxy
+	// [OPTIONAL] Defaults to client.NewBuildAPI.
+	BuildAPIBuilder BuildAPIBuilder
+	// [OPTIONAL] Defaults to client.NewGerritAPI.
+	GerritAPIBuilder GerritAPIBuilder
 }
 
+type BuildAPIBuilder func(rootEndpoint, proxyURL string, credentials client.BuildAPICredentials, dumpOut io.Writer) (client.BuildAPI, error)
+
+type GerritAPIBuilder func(rootEndpoint, proxyURL string, dumpOut io.Writer) (client.GerritAPI, error)
+
 type CVDRemoteCommand struct {
-	command *cobra.Command
-	options *CommandOptions
+	command       *cobra.Command
+	options       *CommandOptions
+	jsonErrors    *bool
+	correlationID string
 }
 
 const (
-	hostFlag       = "host"
-	serviceURLFlag = "service_url"
-	zoneFlag       = "zone"
-	proxyFlag      = "proxy"
-	verboseFlag    = "verbose"
+	hostFlag        = "host"
+	allServicesFlag = "all_services"
+	allFlag         = "all"
+	serviceURLFlag  = "service_url"
+	zoneFlag        = "zone"
+	proxyFlag       = "proxy"
+	verboseFlag     = "verbose"
+)
+
+const (
+	formatFlag = "format"
+
+	textOutputFormat = "text"
+	jsonOutputFormat = "json"
 )
 
 const (
@@ -96,9 +123,12 @@ const (
 	buildIDFlag               = "build_id"
 	buildTargetFlag           = "build_target"
 	localImageFlag            = "local_image"
+	detectBuildTopFlag        = "detect_build_top"
 	kernelBranchFlag          = "kernel_branch"
 	kernelBuildIDFlag         = "kernel_build_id"
 	kernelBuildTargetFlag     = "kernel_build_target"
+	kernelURLFlag             = "kernel_url"
+	initramfsURLFlag          = "initramfs_url"
 	bootloaderBranchFlag      = "bootloader_branch"
 	bootloaderBuildIDFlag     = "bootloader_build_id"
 	bootloaderBuildTargetFlag = "bootloader_build_target"
@@ -112,6 +142,52 @@ const (
 	localCVDHostPkgSrcFlag    = "local_cvd_host_pkg_src"
 	localImagesSrcsFlag       = "local_images_srcs"
 	localImagesZipSrcFlag     = "local_images_zip_src"
+	localVendorBootSrcFlag    = "local_vendor_boot_src"
+	noBootAnimationFlag       = "no_boot_animation"
+	localSuperImageSrcFlag    = "local_super_image_src"
+	requireFeatureFlag        = "require_feature"
+	gpuModeFlag               = "gpu_mode"
+	buildAPIURLFlag           = "build_api_url"
+	incrementalFlag           = "incremental"
+	uploadWorkersFlag         = "upload_workers"
+	fetchConcurrencyFlag      = "fetch_concurrency"
+	fetchRetriesFlag          = "fetch_retries"
+	artifactStorageFlag       = "artifact_storage"
+	priorityFlag              = "priority"
+	preemptibleFlag           = "preemptible"
+	verifyHostTarContentsFlag = "verify_hosttar_contents"
+	ttlFlag                   = "ttl"
+	userdataSizeFlag          = "userdata_size"
+	sharedAgentFlag           = "shared_agent"
+	toBuildFlag               = "to_build"
+	fullOTAFlag               = "full"
+	jumpHostFlag              = "jump_host"
+	selinuxFlag               = "selinux"
+	propFlag                  = "prop"
+	serialFlag                = "serial"
+	partitionFlag             = "partition"
+	maxBuildAgeFlag           = "max_build_age"
+	metadataFlag              = "metadata"
+	imageFlag                 = "image"
+	specFileFlag              = "file"
+	pruneFlag                 = "prune"
+	dryRunFlag                = "dry_run"
+	autoApproveFlag           = "auto_approve"
+	stateDirFlag              = "state_dir"
+	displayFlag               = "display"
+	configOverlayFlag         = "config_overlay"
+	instanceBuildFlag         = "instance_build"
+	listFlag                  = "list"
+	removeFlag                = "remove"
+	configOverlayWinsFlag     = "config_overlay_wins"
+	uploadTimeoutFlag         = "upload_timeout"
+	fetchTimeoutFlag          = "fetch_timeout"
+	createTimeoutFlag         = "create_timeout"
+	bootTimeoutFlag           = "boot_timeout"
+	failoverFlag              = "failover"
+	gerritChangeFlag          = "gerrit_change"
+	refreshFlag               = "refresh"
+	archFlag                  = "arch"
 )
 
 const (
@@ -123,10 +199,22 @@ const (
 
 const (
 	iceConfigFlag = "ice_config"
+
+	clipboardSyncFlag      = "clipboard_sync"
+	recordFlag             = "record"
+	recordDurationFlag     = "record_duration"
+	clipboardDirectionFlag = "clipboard_direction"
+	heartbeatIntervalFlag  = "heartbeat_interval"
+	idleTimeoutFlag        = "idle_timeout"
+	exportOutputFlag       = "output"
 )
 
 const (
-	iceConfigFlagDesc = "Path to file containing the ICE configuration to be used in the underlaying WebRTC connection"
+	iceConfigFlagDesc         = "Path to file containing the ICE configuration to be used in the underlaying WebRTC connection"
+	credentialsSourceFlagDesc = "Source for the Build API OAuth2 credentials, one of: \"" + NoneCredentialsSource + "\", \"" +
+		InjectedCredentialsSource + "\" or \"" + ADCCredentialsSource + "\" for the Application Default Credentials"
+	clientCredentialsSourceFlagDesc = "Source for the OAuth2 credentials of the Build API calls made by cvdr, either \"" +
+		NoneCredentialsSource + "\" or \"" + ADCCredentialsSource + "\" for the Application Default Credentials"
 )
 
 type AsArgs interface {
@@ -163,24 +251,99 @@ type CreateCVDFlags struct {
 	*CVDRemoteFlags
 	*CreateCVDOpts
 	*CreateHostOpts
+	// Rejects the latest green build of the branch if older, no limit if zero.
+	MaxBuildAge time.Duration
+	// Creates from the latest presubmit build of the Gerrit change, by number or Change-Id.
+	GerritChange string
+	// Prints a trace of the steps attempted and the requests made if the create fails.
+	Explain bool
+	// With --host=auto, retries on the next least loaded host if the host runs out of resources.
+	Failover bool
+	// Device CPU architecture, the host's if empty.
+	Arch string
+	// How the progress is printed, one of `progressModes`.
+	Progress string
+	// JSON file with the instance configuration merged into every instance.
+	ConfigOverlayFile string
+}
+
+type OperationLogsFlags struct {
+	*CVDRemoteFlags
+	Host string
 }
 
 type ListCVDsFlags struct {
 	*CVDRemoteFlags
 	Host string
+	// List the CVDs of every service in the configuration.
+	AllServices bool
+	// Writes the devices as a tree under their host.
+	Tree bool
+	// Writes the devices as a table with these columns, see `listOutputFields`.
+	OutputFields []string
 }
 
 type DeleteCVDFlags struct {
 	*CVDRemoteFlags
 	Host string
+	// Only reports what depends on the device and would be cleaned up.
+	DryRun bool
+}
+
+type ExtendCVDFlags struct {
+	*CVDRemoteFlags
+	Host string
+	// The device expires this long after the extension.
+	TTL time.Duration
+}
+
+type DiffCVDsFlags struct {
+	*CVDRemoteFlags
+	Host   string
+	Format string
+}
+
+type ApplyFlags struct {
+	*CVDRemoteFlags
+	SpecFile                  string
+	Prune                     bool
+	AutoApprove               bool
+	BuildAPICredentialsSource string
+}
+
+type ShareCVDFlags struct {
+	*CVDRemoteFlags
+	Host string
+	TTL  time.Duration
+}
+
+type FlashCVDFlags struct {
+	*CVDRemoteFlags
+	Host      string
+	Partition string
+	Image     string
+}
+
+type ValidateBuildFlags struct {
+	*CVDRemoteFlags
+	Build                     hoapi.AndroidCIBuild
+	BuildAPIURL               string
+	BuildAPICredentialsSource string
+	Format                    string
 }
 
 type subCommandOpts struct {
 	ServiceBuilder serviceBuilder
-	RootFlags      *CVDRemoteFlags
-	InitialConfig  Config
-	CommandRunner  CommandRunner
-	ADBServerProxy ADBServerProxy
+	// Builds the services of configured profiles other than the default one.
+	ProfileServiceBuilder func(profile *Service) serviceBuilder
+	RootFlags             *CVDRemoteFlags
+	InitialConfig         Config
+	CommandRunner         CommandRunner
+	ADBServerProxy        ADBServerProxy
+	BuildAPIBuilder       BuildAPIBuilder
+	GerritAPIBuilder      GerritAPIBuilder
+	// Identifies the requests of this invocation in the service logs.
+	CorrelationID string
 }
 
 type ConnectFlags struct {
@@ -188,8 +351,22 @@ type ConnectFlags struct {
 	host             string
 	skipConfirmation bool
 	// Path to file containing the ICE configuration to be used in the underlaying WebRTC connection.
-	ice_config   string
-	connectAgent string
+	ice_config    string
+	connectAgent  string
+	clipboardSync ClipboardSyncOpts
+	recording     RecordingOpts
+	heartbeat     HeartbeatOpts
+	idleTimeout   time.Duration
+	// Connects the devices of each host through a single agent.
+	sharedAgent bool
+	jumpHosts   []string
+	network     string
+	// Tunnels the ADB connections of the proxy agent through this proxy instead of `--proxy`.
+	adbProxy string
+	// Compresses the ADB connections of the proxy agent if the host supports it.
+	compress    bool
+	healthProbe HealthProbeOpts
+	adbConnect  ADBConnectOpts
 }
 
 func (f *ConnectFlags) AsArgs() []string {
@@ -200,6 +377,48 @@ func (f *ConnectFlags) AsArgs() []string {
 	if f.ice_config != "" {
 		args = append(args, "--"+iceConfigFlag, f.ice_config)
 	}
+	if f.clipboardSync.Enabled {
+		args = append(args, "--"+clipboardSyncFlag)
+		if f.clipboardSync.Direction != "" {
+			args = append(args, "--"+clipboardDirectionFlag, f.clipboardSync.Direction)
+		}
+	}
+	if f.recording.Path != "" {
+		args = append(args, "--"+recordFlag, f.recording.Path)
+	}
+	if f.recording.SessionPath != "" {
+		args = append(args, "--"+recordSessionFlag, f.recording.SessionPath)
+	}
+	if f.recording.Enabled() && f.recording.Duration > 0 {
+		args = append(args, "--"+recordDurationFlag, f.recording.Duration.String())
+	}
+	if f.heartbeat.Interval > 0 {
+		args = append(args, "--"+heartbeatIntervalFlag, f.heartbeat.Interval.String())
+	}
+	if f.idleTimeout > 0 {
+		args = append(args, "--"+idleTimeoutFlag, f.idleTimeout.String())
+	}
+	for _, h := range f.jumpHosts {
+		args = append(args, "--"+jumpHostFlag, h)
+	}
+	if f.network != "" {
+		args = append(args, "--"+networkFlag, f.network)
+	}
+	if f.adbProxy != "" {
+		args = append(args, "--"+adbProxyFlag, f.adbProxy)
+	}
+	if f.compress {
+		args = append(args, "--"+compressFlag)
+	}
+	if f.healthProbe.Probe != "" {
+		args = append(args, "--"+healthProbeFlag, f.healthProbe.Probe,
+			"--"+healthProbeTimeoutFlag, f.healthProbe.Timeout.String(),
+			"--"+healthProbeIntervalFlag, f.healthProbe.Interval.String())
+	}
+	if f.adbConnect != (ADBConnectOpts{}) {
+		args = append(args, "--"+adbConnectTimeoutFlag, f.adbConnect.Timeout.String(),
+			"--"+adbConnectRetriesFlag, strconv.Itoa(f.adbConnect.Retries))
+	}
 	return args
 }
 
@@ -248,6 +467,20 @@ func WriteListCVDsOutput(w io.Writer, hosts []*RemoteHost) {
 	}
 }
 
+// Tells apart listing no hosts from listing hosts without devices, both print no devices.
+func writeEmptyListNotice(w io.Writer, hosts []*RemoteHost) {
+	if len(hosts) == 0 {
+		fmt.Fprintln(w, "No hosts exist, create one with `cvdr host create`.")
+		return
+	}
+	for _, h := range hosts {
+		if len(h.CVDs) > 0 {
+			return
+		}
+	}
+	fmt.Fprintf(w, "No devices exist in the %d hosts, create one with `cvdr create --host=HOST`.\n", len(hosts))
+}
+
 func hostOutput(h *RemoteHost) string {
 	return fmt.Sprintf("%s (%s)",
 		h.Name,
@@ -255,13 +488,38 @@ func hostOutput(h *RemoteHost) string {
 }
 
 func cvdOutput(c *RemoteCVD) []string {
-	return []string{
+	result := []string{
 		c.ID,
 		"Status: " + c.Status,
 		"ADB: " + adbStateStr(c),
 		"Displays: " + fmt.Sprintf("%v", c.Displays),
 		"Logs: " + client.BuildCVDLogsURL(c.ServiceRootEndpoint, c.Host, c.Name),
 	}
+	if c.Build != "" {
+		result = append(result, "Build: "+c.Build)
+	}
+	if c.SerialNumber != "" {
+		result = append(result, "Serial: "+c.SerialNumber)
+	}
+	if c.Preemptible {
+		result = append(result, "Preemptible: may be reclaimed by the fleet")
+	}
+	if !c.ExpireTime.IsZero() {
+		result = append(result, fmt.Sprintf("Expires: %s (%s)", c.ExpireTime.Format(time.RFC3339), cvdExpiryStr(c, time.Now())))
+	}
+	if len(c.Metadata) > 0 {
+		keys := []string{}
+		for k := range c.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := []string{}
+		for _, k := range keys {
+			pairs = append(pairs, k+"="+c.Metadata[k])
+		}
+		result = append(result, "Metadata: "+strings.Join(pairs, ", "))
+	}
+	return result
 }
 
 func adbStateStr(c *RemoteCVD) string {
@@ -386,12 +644,63 @@ func NewCVDRemoteCommand(o *CommandOptions) *CVDRemoteCommand {
 	// Do not show a `help` command, users have always the `-h` and `--help` flags for help purpose.
 	rootCmd.SetHelpCommand(&cobra.Command{Hidden: true})
 	rootCmd.PersistentFlags().BoolVarP(&flags.Verbose, verboseFlag, "v", false, "Be verbose.")
+	jsonErrors := false
+	rootCmd.PersistentFlags().BoolVar(&jsonErrors, jsonErrorsFlag, false,
+		"Write errors to stderr as JSON objects, an array of them for the failures of batch operations.")
+	stateDir := ""
+	rootCmd.PersistentFlags().StringVar(&stateDir, stateDirFlag, "",
+		fmt.Sprintf("Directory to keep the connections, upload cache and credentials in, overrides $%s and the configured locations."+
+			" Defaults to $XDG_STATE_HOME/cvdr", StateDirEnvVar))
+	correlationID := newCorrelationID()
 	subCmdOpts := &subCommandOpts{
-		ServiceBuilder: buildServiceBuilder(o.ServiceBuilder, o.InitialConfig.DefaultService().Authn),
-		RootFlags:      flags,
-		InitialConfig:  o.InitialConfig,
-		CommandRunner:  o.CommandRunner,
-		ADBServerProxy: o.ADBServerProxy,
+		RootFlags:        flags,
+		InitialConfig:    o.InitialConfig,
+		CommandRunner:    o.CommandRunner,
+		ADBServerProxy:   o.ADBServerProxy,
+		BuildAPIBuilder:  o.BuildAPIBuilder,
+		GerritAPIBuilder: o.GerritAPIBuilder,
+		CorrelationID:    correlationID,
+	}
+	// The state directory may change once the flags are parsed, the credential store is located
+	// when building the service.
+	config := &subCmdOpts.InitialConfig
+	subCmdOpts.ServiceBuilder = buildServiceBuilder(o.ServiceBuilder, o.InitialConfig.DefaultService(), config, correlationID)
+	subCmdOpts.ProfileServiceBuilder = func(profile *Service) serviceBuilder {
+		return buildServiceBuilder(o.ServiceBuilder, profile, config, correlationID)
+	}
+	if subCmdOpts.BuildAPIBuilder == nil {
+		subCmdOpts.BuildAPIBuilder = client.NewBuildAPI
+	}
+	if subCmdOpts.GerritAPIBuilder == nil {
+		subCmdOpts.GerritAPIBuilder = client.NewGerritAPI
+	}
+	rootCmd.PersistentPreRunE = func(c *cobra.Command, args []string) error {
+		if c.Flags().Changed(stateDirFlag) {
+			config.ApplyStateDir(ExpandPath(stateDir))
+			// Connection agents run in child processes, which load the configuration again.
+			if err := os.Setenv(StateDirEnvVar, config.StateDir); err != nil {
+				return err
+			}
+		}
+		// The default location is created as needed, like each of the configured ones.
+		if config.StateDir != "" {
+			if err := validateStateDir(config.StateDirExpanded()); err != nil {
+				return err
+			}
+		}
+		if err := EnsureConnDirsExist(config.ConnectionControlDirExpanded()); err != nil {
+			return err
+		}
+		if !c.Flags().Changed(serviceURLFlag) {
+			return nil
+		}
+		if err := validateServiceURL(flags.ServiceURL); err != nil {
+			return fmt.Errorf("invalid --%s flag value: %w", serviceURLFlag, err)
+		}
+		if configured := o.InitialConfig.DefaultService().ServiceURL; configured != "" && configured != flags.ServiceURL {
+			c.PrintErrf("Warning: using %s instead of the configured service %s\n", flags.ServiceURL, configured)
+		}
+		return nil
 	}
 	cvdGroup := &cobra.Group{
 		ID:    "cvd",
@@ -407,6 +716,12 @@ func NewCVDRemoteCommand(o *CommandOptions) *CVDRemoteCommand {
 		rootCmd.AddCommand(cmd)
 	}
 	rootCmd.AddCommand(hostCommand(subCmdOpts))
+	rootCmd.AddCommand(operationCommand(subCmdOpts))
+	rootCmd.AddCommand(whoAmICommand(subCmdOpts))
+	rootCmd.AddCommand(defaultsCommand(subCmdOpts))
+	rootCmd.AddCommand(uploadsCommand(subCmdOpts))
+	rootCmd.AddCommand(logoutCommand(subCmdOpts))
+	rootCmd.AddCommand(versionCommand(subCmdOpts))
 	getConfigCommand := &cobra.Command{
 		Use:    "get_config",
 		Short:  "Get a specific configuration value.",
@@ -416,20 +731,135 @@ func NewCVDRemoteCommand(o *CommandOptions) *CVDRemoteCommand {
 		},
 	}
 	rootCmd.AddCommand(getConfigCommand)
-	return &CVDRemoteCommand{rootCmd, o}
+	return &CVDRemoteCommand{
+		command:       rootCmd,
+		options:       o,
+		jsonErrors:    &jsonErrors,
+		correlationID: correlationID,
+	}
 }
 
 func (c *CVDRemoteCommand) Execute() error {
-	err := EnsureConnDirsExist(c.options.InitialConfig.ConnectionControlDirExpanded())
-	if err == nil {
-		err = c.command.Execute()
-	}
+	cmd, err := c.command.ExecuteC()
 	if err != nil {
-		c.command.PrintErrln(err)
+		if *c.jsonErrors {
+			if jErr := writeJSONErrors(c.command.ErrOrStderr(), err, cmd.CommandPath(), c.correlationID); jErr != nil {
+				c.command.PrintErrln(err)
+			}
+		} else {
+			c.command.PrintErrln(err)
+		}
 	}
 	return err
 }
 
+func whoAmICommand(opts *subCommandOpts) *cobra.Command {
+	flags := &WhoAmIFlags{CVDRemoteFlags: opts.RootFlags}
+	whoami := &cobra.Command{
+		Use:   "whoami",
+		Short: "Prints the user the service authenticates the requests as and the credentials used",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runWhoAmICommand(c, flags, opts)
+		},
+	}
+	whoami.Flags().StringVar(&flags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	return whoami
+}
+
+func defaultsCommand(opts *subCommandOpts) *cobra.Command {
+	flags := &DefaultsFlags{CVDRemoteFlags: opts.RootFlags}
+	defaults := &cobra.Command{
+		Use:   "defaults",
+		Short: "Work with your default build, used by create when no build is given",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runGetDefaultsCommand(c, flags, opts)
+		},
+	}
+	defaults.Flags().StringVar(&flags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	setFlags := &SetDefaultsFlags{CVDRemoteFlags: opts.RootFlags}
+	set := &cobra.Command{
+		Use:   "set",
+		Short: "Stores your default build in the service, unset values are cleared",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runSetDefaultsCommand(c, setFlags, opts)
+		},
+	}
+	set.Flags().StringVar(&setFlags.Branch, branchFlag, "", "The branch name")
+	set.Flags().StringVar(&setFlags.BuildID, buildIDFlag, "", "Android build identifier")
+	set.Flags().StringVar(&setFlags.Target, buildTargetFlag, "", "Android build target")
+	set.MarkFlagsMutuallyExclusive(branchFlag, buildIDFlag)
+	defaults.AddCommand(set)
+	return defaults
+}
+
+func runGetDefaultsCommand(c *cobra.Command, flags *DefaultsFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return fmt.Errorf("failed to build service instance: %w", err)
+	}
+	res, err := service.GetDefaults()
+	if err != nil {
+		return fmt.Errorf("failed to get your defaults: %w", err)
+	}
+	if flags.Format == jsonOutputFormat {
+		encoder := json.NewEncoder(c.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(res)
+	}
+	writeBuildDefaults(c.OutOrStdout(), res)
+	return nil
+}
+
+func runSetDefaultsCommand(c *cobra.Command, flags *SetDefaultsFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return fmt.Errorf("failed to build service instance: %w", err)
+	}
+	if err := service.SetDefaults(&flags.BuildDefaults); err != nil {
+		return fmt.Errorf("failed to set your defaults: %w", err)
+	}
+	return nil
+}
+
+func runWhoAmICommand(c *cobra.Command, flags *WhoAmIFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return fmt.Errorf("failed to build service instance: %w", err)
+	}
+	res, err := service.WhoAmI()
+	if err != nil {
+		return fmt.Errorf("failed to get the authenticated user: %w", err)
+	}
+	authn := opts.InitialConfig.DefaultService().Authn
+	result := &WhoAmI{WhoAmIResponse: *res, ClientAuthn: clientAuthnMode(authn)}
+	if result.ClientAuthn == oidcTokenAuthnMode {
+		token, err := loadOIDCToken(authn.OIDCToken.TokenFile, &opts.InitialConfig, c)
+		if err != nil {
+			return err
+		}
+		// Tokens aren't required to be JWTs, the expiry is just not reported for others.
+		if result.OIDCTokenExpiry, err = oidcTokenExpiry(token); err != nil && flags.Verbose {
+			c.PrintErrf("Unknown OIDC token expiry: %v\n", err)
+		}
+	}
+	if flags.Format == jsonOutputFormat {
+		encoder := json.NewEncoder(c.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	writeWhoAmI(c.OutOrStdout(), result, time.Now())
+	return nil
+}
+
 func hostCommand(opts *subCommandOpts) *cobra.Command {
 	acceleratorFlagValues := []string{}
 	createFlags := &CreateHostFlags{CVDRemoteFlags: opts.RootFlags, CreateHostOpts: &CreateHostOpts{}}
@@ -449,13 +879,16 @@ func hostCommand(opts *subCommandOpts) *cobra.Command {
 		opts.InitialConfig.DefaultService().Host.GCP.MachineType, gcpMachineTypeFlagDesc)
 	create.Flags().StringVar(&createFlags.GCP.MinCPUPlatform, gcpMinCPUPlatformFlag,
 		opts.InitialConfig.DefaultService().Host.GCP.MinCPUPlatform, gcpMinCPUPlatformFlagDesc)
+	addRequireFeatureFlag(create, &createFlags.RequireFeatures)
+	listWatchOpts := &WatchOpts{}
 	list := &cobra.Command{
 		Use:   "list",
 		Short: "Lists hosts.",
-		RunE: func(c *cobra.Command, args []string) error {
+		RunE: withWatch(listWatchOpts, func(c *cobra.Command, args []string) error {
 			return runListHostCommand(c, opts.RootFlags, opts)
-		},
+		}),
 	}
+	addWatchFlags(list, listWatchOpts)
 	del := &cobra.Command{
 		Use:   "delete <foo> <bar> <baz>",
 		Short: "Delete hosts.",
@@ -473,6 +906,96 @@ func hostCommand(opts *subCommandOpts) *cobra.Command {
 	return host
 }
 
+func operationCommand(opts *subCommandOpts) *cobra.Command {
+	logsFlags := &OperationLogsFlags{CVDRemoteFlags: opts.RootFlags}
+	logs := &cobra.Command{
+		Use:   "logs --host=HOST <name>",
+		Short: "Prints the host orchestrator's logs of an operation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runOperationLogsCommand(c, args[0], logsFlags, opts)
+		},
+	}
+	logs.Flags().StringVar(&logsFlags.Host, hostFlag, "", "Specifies the host")
+	logs.MarkFlagRequired(hostFlag)
+	operation := &cobra.Command{
+		Use:   "operation",
+		Short: "Work with host operations",
+	}
+	operation.AddCommand(logs)
+	return operation
+}
+
+func runCVDLogsCommand(c *cobra.Command, args []string, flags *CVDLogsFlags, opts *subCommandOpts) error {
+	if !flags.Merge && len(args) != 1 {
+		return fmt.Errorf("expected a single device, use --%s for several: %v", mergeFlag, args)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return fmt.Errorf("failed to build service instance: %w", err)
+	}
+	srv := service.HostService(flags.Host)
+	names := args
+	if len(names) == 0 {
+		cvds, err := srv.ListCVDs()
+		if err != nil {
+			return fmt.Errorf("failed to list the devices of host %q: %w", flags.Host, err)
+		}
+		for _, cvd := range cvds {
+			names = append(names, cvd.Name)
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("host %q has no devices", flags.Host)
+		}
+	}
+	logs := []namedLog{}
+	defer func() {
+		for _, l := range logs {
+			l.R.Close()
+		}
+	}()
+	for _, name := range names {
+		r, err := srv.GetCVDLogs(name, flags.File)
+		if errors.Is(err, client.ErrLogNotFound) {
+			return fmt.Errorf("device %q has no %s", name, flags.File)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get the logs of %q: %w", name, err)
+		}
+		logs = append(logs, namedLog{Name: name, R: r})
+	}
+	if !flags.Merge {
+		if _, err := io.Copy(c.OutOrStdout(), logs[0].R); err != nil {
+			return fmt.Errorf("failed reading the logs of %q: %w", names[0], err)
+		}
+		return nil
+	}
+	color := false
+	if f, ok := c.OutOrStdout().(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		color = true
+	}
+	return mergeLogs(c.OutOrStdout(), logs, color)
+}
+
+func runOperationLogsCommand(c *cobra.Command, name string, flags *OperationLogsFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return fmt.Errorf("failed to build service instance: %w", err)
+	}
+	logs, err := service.HostService(flags.Host).GetOperationLogs(name)
+	if errors.Is(err, client.ErrOperationNotFound) {
+		return fmt.Errorf("operation %q not found in host %q, it may have been garbage collected", name, flags.Host)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the logs of operation %q: %w", name, err)
+	}
+	defer logs.Close()
+	if _, err := io.Copy(c.OutOrStdout(), logs); err != nil {
+		return fmt.Errorf("failed reading the logs of operation %q: %w", name, err)
+	}
+	return nil
+}
+
 func cvdCommands(opts *subCommandOpts) []*cobra.Command {
 	// Create command
 	createFlags := &CreateCVDFlags{
@@ -487,18 +1010,48 @@ func cvdCommands(opts *subCommandOpts) []*cobra.Command {
 			return runCreateCVDCommand(c, args, createFlags, opts)
 		},
 	}
-	create.Flags().StringVar(&createFlags.Host, hostFlag, "", "Specifies the host")
+	create.Flags().StringVar(&createFlags.Host, hostFlag, "",
+		"Specifies the host. Use \""+autoHostValue+"\" to select the least-loaded host with enough capacity")
 	// Main build flags.
 	create.Flags().StringVar(&createFlags.MainBuild.Branch, branchFlag, "aosp-main", "The branch name")
 	create.Flags().StringVar(&createFlags.MainBuild.BuildID, buildIDFlag, "", "Android build identifier")
 	create.Flags().StringVar(&createFlags.MainBuild.Target, buildTargetFlag, "aosp_cf_x86_64_phone-trunk_staging-userdebug",
 		"Android build target")
 	create.MarkFlagsMutuallyExclusive(branchFlag, buildIDFlag)
+	create.Flags().StringSliceVar(&createFlags.Variants, variantFlag, nil,
+		"Variants of the build target fetched along with it, i.e: userdebug. The devices run the first one, the others are fetched for later creates."+
+			" Repeat the flag or separate with commas for multiple variants")
+	create.Flags().StringVar(&createFlags.Arch, archFlag, "",
+		fmt.Sprintf("Device CPU architecture, %s or %s. Selects the architecture's default build target unless --%s is given. Defaults to the host's",
+			apiv1.HostArchX86_64, apiv1.HostArchARM64, buildTargetFlag))
+	create.Flags().Var(&buildAgeFlagValue{&createFlags.MaxBuildAge}, maxBuildAgeFlag,
+		"Fails if the latest green build of the branch is older than this, i.e: 7d or 36h. No limit if empty")
+	create.MarkFlagsMutuallyExclusive(maxBuildAgeFlag, buildIDFlag)
+	create.Flags().StringVar(&createFlags.GerritChange, gerritChangeFlag, "",
+		"Creates from the latest successful presubmit build of the Gerrit change's current patchset, in --"+
+			branchFlag+". By change number or Change-Id")
+	create.MarkFlagsMutuallyExclusive(gerritChangeFlag, buildIDFlag)
+	create.MarkFlagsMutuallyExclusive(gerritChangeFlag, maxBuildAgeFlag)
+	create.Flags().BoolVar(&createFlags.Explain, explainFlag, false,
+		"On failure, prints the steps attempted with their timings and the requests made, credentials redacted")
+	create.Flags().StringVar(&createFlags.Progress, progressFlag, progressBar,
+		"How the progress is printed to stderr, one of: "+strings.Join(progressModes, ", ")+
+			". plain prints single lines without ANSI escapes, json newline delimited events with the phase, percent and message")
+	create.Flags().BoolVar(&createFlags.Failover, failoverFlag, false,
+		fmt.Sprintf("With --%s=%s, retries the create on up to %d hosts if they run out of resources, the least loaded first",
+			hostFlag, autoHostValue, maxFailoverHosts))
 	// Kernel build flags
 	create.Flags().StringVar(&createFlags.KernelBuild.Branch, kernelBranchFlag, "", "Kernel branch name")
 	create.Flags().StringVar(&createFlags.KernelBuild.BuildID, kernelBuildIDFlag, "", "Kernel build identifier")
 	create.Flags().StringVar(&createFlags.KernelBuild.Target, kernelBuildTargetFlag, "", "Kernel build target")
 	create.MarkFlagsMutuallyExclusive(kernelBranchFlag, kernelBuildIDFlag)
+	create.Flags().StringVar(&createFlags.KernelURL, kernelURLFlag, "",
+		"URL of a kernel image replacing the build's, downloaded by the host so it must be reachable from it")
+	create.Flags().StringVar(&createFlags.InitramfsURL, initramfsURLFlag, "",
+		"URL of an initramfs replacing the build's, downloaded by the host so it must be reachable from it")
+	for _, f := range []string{kernelBranchFlag, kernelBuildIDFlag, kernelBuildTargetFlag} {
+		create.MarkFlagsMutuallyExclusive(kernelURLFlag, f)
+	}
 	// Bootloader build flags
 	create.Flags().StringVar(&createFlags.BootloaderBuild.Branch, bootloaderBranchFlag, "", "Bootloader branch name")
 	create.Flags().StringVar(&createFlags.BootloaderBuild.BuildID, bootloaderBuildIDFlag, "", "Bootloader build identifier")
@@ -510,8 +1063,8 @@ func cvdCommands(opts *subCommandOpts) []*cobra.Command {
 	create.Flags().StringVar(&createFlags.SystemImgBuild.Target, systemImgBuildTargetFlag, "", "System image build target")
 	create.MarkFlagsMutuallyExclusive(systemImgBranchFlag, systemImgBuildIDFlag)
 	remoteBuildFlags := []string{
-		branchFlag, buildIDFlag, buildTargetFlag,
-		kernelBranchFlag, kernelBuildIDFlag, kernelBuildTargetFlag,
+		branchFlag, buildIDFlag, buildTargetFlag, maxBuildAgeFlag, variantFlag,
+		kernelBranchFlag, kernelBuildIDFlag, kernelBuildTargetFlag, kernelURLFlag, initramfsURLFlag,
 		bootloaderBranchFlag, bootloaderBuildIDFlag, bootloaderBuildTargetFlag,
 		systemImgBranchFlag, systemImgBuildIDFlag, systemImgBuildTargetFlag,
 	}
@@ -521,23 +1074,93 @@ func cvdCommands(opts *subCommandOpts) []*cobra.Command {
 	for _, remote := range remoteBuildFlags {
 		create.MarkFlagsMutuallyExclusive(localImageFlag, remote)
 	}
+	create.Flags().BoolVar(&createFlags.DetectBuildTop, detectBuildTopFlag, true,
+		fmt.Sprintf("With --%s, detect the build from the current directory if %s or %s aren't set",
+			localImageFlag, AndroidBuildTopVarName, AndroidProductOutVarName))
 	create.Flags().IntVar(&createFlags.NumInstances, numInstancesFlag, 1,
 		"Creates multiple instances with the same artifacts. Only relevant if given a single build source")
+	create.Flags().Var(&instanceBuildFlagValue{&createFlags.InstanceBuilds}, instanceBuildFlag,
+		"Adds instances of the Android CI build, i.e: aosp-main/aosp_cf_x86_64_phone-trunk_staging-userdebug:2 for two instances."+
+			" Repeat the flag to mix builds in a single create")
 	create.Flags().BoolVar(&createFlags.AutoConnect, autoConnectFlag, true,
 		"Automatically connect through ADB after device is created.")
 	create.Flags().StringVar(
 		&createFlags.BuildAPICredentialsSource,
 		credentialsSourceFlag,
 		"none",
-		"Source for the Build API OAuth2 credentials")
+		credentialsSourceFlagDesc)
 	// Local artifact sources
 	create.Flags().StringVar(&createFlags.LocalBootloaderSrc, localBootloaderSrcFlag, "", "Local bootloader source")
 	create.Flags().StringVar(&createFlags.LocalCVDHostPkgSrc, localCVDHostPkgSrcFlag, "", "Local cvd host package source")
 	create.Flags().StringSliceVar(&createFlags.LocalImagesSrcs, localImagesSrcsFlag, []string{}, "Comma-separated list of local images sources")
 	create.Flags().StringVar(&createFlags.LocalImagesZipSrc, localImagesZipSrcFlag, "",
 		"Local *-img-*.zip source containing the images and bootloader files")
+	// Phase timeouts
+	create.Flags().DurationVar(&createFlags.Timeouts.Upload, uploadTimeoutFlag, 0,
+		"Timeout for uploading local artifacts, i.e: 30m. No timeout if zero")
+	create.Flags().DurationVar(&createFlags.Timeouts.Fetch, fetchTimeoutFlag, 0,
+		"Timeout for fetching the build artifacts in the host. No timeout if zero")
+	create.Flags().DurationVar(&createFlags.Timeouts.Create, createTimeoutFlag, 0,
+		"Timeout for requesting the device creation. No timeout if zero")
+	create.Flags().DurationVar(&createFlags.Timeouts.Boot, bootTimeoutFlag, 0,
+		"Timeout for waiting for the device to boot. No timeout if zero")
+	create.Flags().StringVar(&createFlags.LocalVendorBootSrc, localVendorBootSrcFlag, "",
+		"Local vendor_boot.img source, it can be combined with any other build source")
+	create.Flags().StringVar(&createFlags.LocalSuperImageSrc, localSuperImageSrcFlag, "",
+		fmt.Sprintf("Local super.img source replacing the build's, with a custom layout of the dynamic partitions. Requires --%s or local sources",
+			localImageFlag))
+	create.Flags().BoolVar(&createFlags.Incremental, incrementalFlag, false,
+		"Upload only the local files that changed since the last successful create in the same host, unless devices of the host may still use that upload")
+	create.Flags().IntVar(&createFlags.UploadWorkers, uploadWorkersFlag, 0,
+		"Number of parallel chunk uploads. Tuned to the machine's cores and the throughput of the first chunk if zero")
+	create.Flags().BoolVar(&createFlags.VerifyHostPackageContents, verifyHostTarContentsFlag, false,
+		"Read the whole local host package before uploading it, checking it isn't truncated and has the entries needed to launch devices")
+	create.Flags().IntVar(&createFlags.FetchConcurrency, fetchConcurrencyFlag, 0,
+		"Number of artifacts the host downloads in parallel from the build server, the host may clamp it. The host's default if zero")
+	create.Flags().Var(&bandwidthFlagValue{&createFlags.FetchBandwidthLimit}, fetchBandwidthLimitFlag,
+		"Bytes per second the host downloads from the build server at most, i.e: 50MiB/s. Hosts not supporting it ignore it. Unlimited if not given")
+	create.Flags().IntVar(&createFlags.FetchRetries, fetchRetriesFlag, 0,
+		"Times the host retries a failed artifact download from the build server before failing the create, independent of cvdr's retries of its requests. Hosts not supporting it ignore it. The host's default if zero")
+	create.Flags().StringVar(&createFlags.ArtifactStorage, artifactStorageFlag, "",
+		"Absolute directory of the host the fetched artifacts are stored in, i.e: /mnt/fast. The host's default if empty")
+	create.Flags().Var(&displayFlagValue{&createFlags.Displays}, displayFlag,
+		"Adds a display with the given resolution and DPI, i.e: 1080x2400@420. Repeat the flag to add multiple displays."+
+			" Uses the defaults of the device type if not given")
+	create.Flags().Var(&sizeFlagValue{&createFlags.UserdataSizeMB}, userdataSizeFlag,
+		fmt.Sprintf("Size of the userdata disk, i.e: 16G. At least %dMB, uses the device's default if empty", minUserdataSizeMB))
+	create.Flags().StringVar(&createFlags.ConfigOverlayFile, configOverlayFlag, "",
+		"JSON file with a Cuttlefish instance configuration merged into every instance, for properties without flags")
+	create.Flags().BoolVar(&createFlags.ConfigOverlayWins, configOverlayWinsFlag, false,
+		fmt.Sprintf("Properties set in both the --%s file and flags take the file's value instead of the flags'", configOverlayFlag))
+	create.Flags().StringToStringVar(&createFlags.Metadata, metadataFlag, nil,
+		"Metadata forwarded to the host orchestrator, i.e: test_run=1234. Repeat the flag or separate with commas for multiple entries")
+	create.Flags().IntVar(&createFlags.Priority, priorityFlag, 0,
+		"Priority of the create in shared fleets, higher priorities are scheduled first. Uses the fleet's default if zero")
+	create.Flags().BoolVar(&createFlags.Preemptible, preemptibleFlag, false,
+		"Creates preemptible devices, cheaper but the fleet may reclaim them")
+	create.Flags().DurationVar(&createFlags.TTL, ttlFlag, 0,
+		fmt.Sprintf("The host deletes the devices this long after creating them, at least %s. They don't expire if zero", minCVDTTL))
+	create.Flags().BoolVar(&createFlags.NoBootAnimation, noBootAnimationFlag, false,
+		"Boots without the boot animation, which is faster")
+	create.Flags().StringVar(&createFlags.SELinuxMode, selinuxFlag, "",
+		"SELinux mode the device boots with, one of: "+strings.Join(selinuxModes, ", ")+
+			". Uses the build's default if empty. Permissive is meant for debugging policies only")
+	create.Flags().Var(&bootPropertyFlagValue{&createFlags.BootProperties}, propFlag,
+		"Property the device boots with, as KEY=VALUE, i.e: ro.boot.foo=bar. Only "+bootPropertyPrefix+"* properties can be set."+
+			" Repeat the flag for multiple properties")
+	create.Flags().StringVar(&createFlags.SerialNumber, serialFlag, "",
+		"Serial number the device reports, its ro.serialno, for harnesses keying off it. Must be unique in the host."+
+			" Only for creates of a single device")
+	create.Flags().StringVar(&createFlags.GPUMode, gpuModeFlag, "",
+		"Gpu mode of the device, one of: "+strings.Join(gpuModes, ", ")+". Uses the device's default if empty."+
+			" gfxstream is the fastest but requires a gpu in the host, guest_swiftshader works everywhere but it's the slowest")
+	// Instance builds replace the main build, it can't be resolved or follow the host's arch.
+	for _, f := range []string{branchFlag, buildIDFlag, buildTargetFlag, numInstancesFlag, gerritChangeFlag, maxBuildAgeFlag, archFlag, variantFlag} {
+		create.MarkFlagsMutuallyExclusive(instanceBuildFlag, f)
+	}
 	create.MarkFlagsMutuallyExclusive(localImagesZipSrcFlag, localBootloaderSrcFlag)
 	create.MarkFlagsMutuallyExclusive(localImagesZipSrcFlag, localImagesSrcsFlag)
+	create.MarkFlagsMutuallyExclusive(localImagesZipSrcFlag, localSuperImageSrcFlag)
 	localSrcsFlag := []string{localBootloaderSrcFlag, localCVDHostPkgSrcFlag, localImagesSrcsFlag, localImagesZipSrcFlag}
 	for _, local := range localSrcsFlag {
 		create.MarkFlagsMutuallyExclusive(local, localImageFlag)
@@ -570,16 +1193,45 @@ func cvdCommands(opts *subCommandOpts) []*cobra.Command {
 		create.Flags().StringVar(f.ValueRef, name, f.Default, f.Desc)
 		create.MarkFlagsMutuallyExclusive(hostFlag, name)
 	}
+	addRequireFeatureFlag(create, &createFlags.CreateCVDOpts.RequireFeatures)
 	// List command
 	listFlags := &ListCVDsFlags{CVDRemoteFlags: opts.RootFlags}
+	listWatchOpts := &WatchOpts{}
 	list := &cobra.Command{
 		Use:   "list",
 		Short: "List CVDs",
-		RunE: func(c *cobra.Command, args []string) error {
+		Args:  cobra.NoArgs,
+		RunE: withWatch(listWatchOpts, func(c *cobra.Command, args []string) error {
 			return runListCVDsCommand(c, listFlags, opts)
-		},
+		}),
 	}
+	addWatchFlags(list, listWatchOpts)
 	list.Flags().StringVar(&listFlags.Host, hostFlag, "", "Specifies the host")
+	list.Flags().BoolVar(&listFlags.AllServices, allServicesFlag, false,
+		"List the CVDs of every service in the configuration")
+	list.Flags().BoolVar(&listFlags.Tree, treeFlag, false,
+		"Print the devices as a tree under their hosts, along with the hosts' zone and utilization")
+	list.Flags().StringSliceVar(&listFlags.OutputFields, outputFieldsFlag, nil,
+		"Print the devices as a table with these columns, in order, any of: "+strings.Join(listOutputFieldNames, ", ")+
+			". Without a value prints "+strings.Join(defaultOutputFields, ","))
+	list.Flags().Lookup(outputFieldsFlag).NoOptDefVal = strings.Join(defaultOutputFields, ",")
+	list.MarkFlagsMutuallyExclusive(hostFlag, allServicesFlag)
+	list.MarkFlagsMutuallyExclusive(treeFlag, outputFieldsFlag)
+	// Ps command
+	psFlags := &PsFlags{CVDRemoteFlags: opts.RootFlags}
+	psWatchOpts := &WatchOpts{}
+	ps := &cobra.Command{
+		Use:   "ps [-a]",
+		Short: "List running CVDs",
+		Args:  cobra.NoArgs,
+		RunE: withWatch(psWatchOpts, func(c *cobra.Command, args []string) error {
+			return runPsCommand(c, psFlags, opts)
+		}),
+	}
+	addWatchFlags(ps, psWatchOpts)
+	ps.Flags().StringVar(&psFlags.Host, hostFlag, "", "Specifies the host")
+	ps.Flags().BoolVarP(&psFlags.All, allFlag, "a", false, "List the CVDs in any status, not only the running ones")
+	ps.Flags().StringVar(&psFlags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
 	// Pull command
 	pull := &cobra.Command{
 		Use:   "pull [HOST]",
@@ -599,7 +1251,228 @@ func cvdCommands(opts *subCommandOpts) []*cobra.Command {
 	}
 	del.Flags().StringVar(&delFlags.Host, hostFlag, "", "Specifies the host")
 	del.MarkFlagRequired(hostFlag)
-	return []*cobra.Command{create, list, pull, del}
+	del.Flags().BoolVar(&delFlags.DryRun, dryRunFlag, false, "Only report what depends on the cvd and would be cleaned up")
+	extendFlags := &ExtendCVDFlags{CVDRemoteFlags: opts.RootFlags}
+	extend := &cobra.Command{
+		Use:   "extend --host=HOST --ttl=DURATION <id>",
+		Short: "Postpones the deletion of a cvd instance created with --ttl",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runExtendCVDCommand(c, args, extendFlags, opts)
+		},
+	}
+	extend.Flags().StringVar(&extendFlags.Host, hostFlag, "", "Specifies the host")
+	extend.MarkFlagRequired(hostFlag)
+	extend.Flags().DurationVar(&extendFlags.TTL, ttlFlag, 0, "Time from now until the host deletes the device")
+	extend.MarkFlagRequired(ttlFlag)
+	// Diff command
+	diffFlags := &DiffCVDsFlags{CVDRemoteFlags: opts.RootFlags}
+	diff := &cobra.Command{
+		Use:   "diff [--host=HOST] <[HOST/]DEVICE|SPEC.json> <[HOST/]DEVICE|SPEC.json>",
+		Short: "Compares the build sources and displays of two CVDs, the hosts don't report their instance settings",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runDiffCVDsCommand(c, args, diffFlags, opts)
+		},
+	}
+	diff.Flags().StringVar(&diffFlags.Host, hostFlag, "", "Host of the devices not given as HOST/DEVICE")
+	diff.Flags().StringVar(&diffFlags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	// Apply command
+	applyFlags := &ApplyFlags{CVDRemoteFlags: opts.RootFlags}
+	apply := &cobra.Command{
+		Use:   "apply -f SPEC.json|SPEC.yaml",
+		Short: "Creates and, with --prune, deletes CVDs to match the devices in the spec file",
+		RunE: func(c *cobra.Command, args []string) error {
+			return runApplyCommand(c, applyFlags, opts)
+		},
+	}
+	apply.Flags().StringVarP(&applyFlags.SpecFile, specFileFlag, "f", "", "JSON or YAML file with the desired devices. Names and labels only identify the entries of the plan, they aren't applied to the devices")
+	apply.MarkFlagRequired(specFileFlag)
+	apply.Flags().BoolVar(&applyFlags.Prune, pruneFlag, false,
+		"Delete the devices in the spec's hosts that aren't in the spec")
+	apply.Flags().BoolVar(&applyFlags.AutoApprove, autoApproveFlag, false,
+		"Apply the plan without asking for confirmation")
+	apply.Flags().StringVar(&applyFlags.BuildAPICredentialsSource, credentialsSourceFlag, "none",
+		credentialsSourceFlagDesc)
+	// Up command
+	upFlags := &UpFlags{CVDRemoteFlags: opts.RootFlags}
+	up := &cobra.Command{
+		Use:   "up --host=HOST BRANCH_OR_BUILD_ID/TARGET[:COUNT]",
+		Short: "Creates CVDs, waits for them to boot and connects to them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runUpCommand(c, args[0], upFlags, opts)
+		},
+	}
+	up.Flags().StringVar(&upFlags.Host, hostFlag, "", fmt.Sprintf("Specifies the host, %q selects the least loaded one", autoHostValue))
+	up.MarkFlagRequired(hostFlag)
+	up.Flags().StringVar(&upFlags.BuildAPICredentialsSource, credentialsSourceFlag, "none",
+		credentialsSourceFlagDesc)
+	up.Flags().BoolVar(&upFlags.KeepOnFailure, keepOnFailureFlag, false,
+		"Keep the devices if connecting to them fails, they are deleted otherwise")
+	up.Flags().BoolVar(&upFlags.Detach, detachFlag, false, "Return once the devices booted, without connecting to them")
+	// Share commands
+	shareFlags := &ShareCVDFlags{CVDRemoteFlags: opts.RootFlags}
+	share := &cobra.Command{
+		Use:   "share [--host=HOST] <name>",
+		Short: "Prints a time-limited link to the CVD's display, the server must support share-token issuance",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runShareCVDCommand(c, args[0], shareFlags, opts)
+		},
+	}
+	share.Flags().StringVar(&shareFlags.Host, hostFlag, "", "Specifies the host")
+	share.MarkFlagRequired(hostFlag)
+	share.Flags().DurationVar(&shareFlags.TTL, ttlFlag, time.Hour, "Time until the link expires")
+	unshare := &cobra.Command{
+		Use:   "unshare [--host=HOST] <name>",
+		Short: "Revokes the links to the CVD's display",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runUnshareCVDCommand(c, args[0], shareFlags, opts)
+		},
+	}
+	unshare.Flags().StringVar(&shareFlags.Host, hostFlag, "", "Specifies the host")
+	unshare.MarkFlagRequired(hostFlag)
+	// SSH command
+	sshJumpHosts := []string{}
+	sshCmd := &cobra.Command{
+		Use:   "ssh <host> [-- command]",
+		Short: "Opens an SSH session to a host, or runs a command in it",
+		Args: func(c *cobra.Command, args []string) error {
+			if dash := c.ArgsLenAtDash(); dash == 0 || dash > 1 || (dash < 0 && len(args) != 1) {
+				return fmt.Errorf("expected a host name, optionally followed by -- and a command")
+			}
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			return runSSHCommand(c, args[0], args[1:], sshJumpHosts, opts.RootFlags, opts)
+		},
+	}
+	addJumpHostFlag(sshCmd, &sshJumpHosts)
+	// Flash command
+	flashFlags := &FlashCVDFlags{CVDRemoteFlags: opts.RootFlags}
+	flash := &cobra.Command{
+		Use:   "flash [--host=HOST] <name> --partition=PARTITION --image=IMAGE",
+		Short: "Replaces a partition of a running CVD with a local image and reboots it, the server must support partition flashing",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runFlashCVDCommand(c, args[0], flashFlags, opts)
+		},
+	}
+	flash.Flags().StringVar(&flashFlags.Host, hostFlag, "", "Specifies the host")
+	flash.MarkFlagRequired(hostFlag)
+	flash.Flags().StringVar(&flashFlags.Partition, partitionFlag, "", "Name of the partition, i.e: system")
+	flash.MarkFlagRequired(partitionFlag)
+	flash.Flags().StringVar(&flashFlags.Image, imageFlag, "", "Path of the partition image, i.e: out/system.img")
+	flash.MarkFlagRequired(imageFlag)
+	// OTA command
+	otaFlags := &OTAFlags{CVDRemoteFlags: opts.RootFlags}
+	ota := &cobra.Command{
+		Use:   "ota [--host=HOST] <name> --to_build=BUILD_ID [--full]",
+		Short: "Updates a device to another build of its target with an OTA package",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runOTACommand(c, args[0], otaFlags, opts)
+		},
+	}
+	ota.Flags().StringVar(&otaFlags.Host, hostFlag, "", "Specifies the host")
+	ota.MarkFlagRequired(hostFlag)
+	ota.Flags().StringVar(&otaFlags.ToBuild, toBuildFlag, "", "Android build identifier to update to")
+	ota.MarkFlagRequired(toBuildFlag)
+	ota.Flags().BoolVar(&otaFlags.Full, fullOTAFlag, false,
+		"Applies the full OTA package instead of the incremental one from the device's current build")
+	ota.Flags().StringVar(&otaFlags.BuildAPICredentialsSource, credentialsSourceFlag, ADCCredentialsSource,
+		clientCredentialsSourceFlagDesc)
+	// GC command
+	gcFlags := &GCFlags{CVDRemoteFlags: opts.RootFlags}
+	gc := &cobra.Command{
+		Use:   "gc --host=HOST",
+		Short: "Deletes the upload directories of a host not used by any of its CVDs",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runGCCommand(c, gcFlags, opts)
+		},
+	}
+	gc.Flags().StringVar(&gcFlags.Host, hostFlag, "", "Specifies the host")
+	gc.MarkFlagRequired(hostFlag)
+	gc.Flags().BoolVar(&gcFlags.DryRun, dryRunFlag, false, "Only list the directories to delete and the space to reclaim")
+	pruneFlags := &PruneFlags{CVDRemoteFlags: opts.RootFlags}
+	prune := &cobra.Command{
+		Use:   "prune [--host=HOST] [--older_than=DURATION]",
+		Short: "Deletes the failed and stopped CVDs left behind by creates, only lists them unless --dry_run=false",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runPruneCommand(c, pruneFlags, opts)
+		},
+	}
+	prune.Flags().StringVar(&pruneFlags.Host, hostFlag, "", "Only prunes the CVDs of this host")
+	prune.Flags().DurationVar(&pruneFlags.OlderThan, olderThanFlag, 0,
+		"Only prunes the CVDs created at least this long ago, i.e: 24h. Zero prunes them regardless of their age")
+	prune.Flags().BoolVar(&pruneFlags.DryRun, dryRunFlag, true, "Only list the CVDs to delete")
+	prune.Flags().BoolVarP(&pruneFlags.Yes, "yes", "y", false, "Don't ask for confirmation before deleting")
+	// Validate build command
+	validateFlags := &ValidateBuildFlags{CVDRemoteFlags: opts.RootFlags}
+	validate := &cobra.Command{
+		Use:   "validate_build",
+		Short: "Checks a build exists and has the artifacts required to create a CVD",
+		RunE: func(c *cobra.Command, args []string) error {
+			return runValidateBuildCommand(c, validateFlags, opts)
+		},
+	}
+	validate.Flags().StringVar(&validateFlags.Build.Branch, branchFlag, "aosp-main",
+		"The branch name, its latest green build is validated if no build id is given")
+	validate.Flags().StringVar(&validateFlags.Build.BuildID, buildIDFlag, "", "Android build identifier")
+	validate.Flags().StringVar(&validateFlags.Build.Target, buildTargetFlag, "aosp_cf_x86_64_phone-trunk_staging-userdebug",
+		"Android build target")
+	validate.Flags().StringVar(&validateFlags.BuildAPIURL, buildAPIURLFlag, client.DefaultBuildAPIRootEndpoint,
+		"Root endpoint of the Android Build API")
+	validate.Flags().StringVar(&validateFlags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	validate.Flags().StringVar(&validateFlags.BuildAPICredentialsSource, credentialsSourceFlag, ADCCredentialsSource,
+		clientCredentialsSourceFlagDesc)
+	targetsFlags := &ListTargetsFlags{CVDRemoteFlags: opts.RootFlags}
+	listTargets := &cobra.Command{
+		Use:   "list_targets --branch=BRANCH",
+		Short: "Lists the build targets of a branch, the values --build_target accepts",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runListTargetsCommand(c, targetsFlags, opts)
+		},
+	}
+	listTargets.Flags().StringVar(&targetsFlags.Branch, branchFlag, "aosp-main", "The branch name")
+	listTargets.Flags().StringVar(&targetsFlags.BuildAPIURL, buildAPIURLFlag, client.DefaultBuildAPIRootEndpoint,
+		"Root endpoint of the Android Build API")
+	listTargets.Flags().StringVar(&targetsFlags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	listTargets.Flags().StringVar(&targetsFlags.BuildAPICredentialsSource, credentialsSourceFlag, ADCCredentialsSource,
+		clientCredentialsSourceFlagDesc)
+	listTargets.Flags().BoolVar(&targetsFlags.Refresh, refreshFlag, false,
+		fmt.Sprintf("Lists the targets from the build server, even if listed in the last %s", targetCacheTTL))
+	logsFlags := &CVDLogsFlags{CVDRemoteFlags: opts.RootFlags}
+	logs := &cobra.Command{
+		Use:   "logs --host=HOST [--merge] <name>...",
+		Short: "Prints a log file of a device, or of several devices of a host interleaved by timestamp",
+		RunE: func(c *cobra.Command, args []string) error {
+			return runCVDLogsCommand(c, args, logsFlags, opts)
+		},
+	}
+	logs.Flags().StringVar(&logsFlags.Host, hostFlag, "", "Specifies the host")
+	logs.MarkFlagRequired(hostFlag)
+	logs.Flags().StringVar(&logsFlags.File, logFileFlag, defaultLogFile, "Log file to print, i.e: kernel.log or logcat")
+	logs.Flags().BoolVar(&logsFlags.Merge, mergeFlag, false,
+		"Merges the logs of the given devices, or of all the devices of the host if none is given, prefixing each line with its device")
+	statsFlags := &StatsFlags{CVDRemoteFlags: opts.RootFlags}
+	statsWatchOpts := &WatchOpts{}
+	stats := &cobra.Command{
+		Use:   "stats",
+		Short: "Summarizes the fleet: hosts, CVDs by status and utilization",
+		Args:  cobra.NoArgs,
+		RunE: withWatch(statsWatchOpts, func(c *cobra.Command, args []string) error {
+			return runStatsCommand(c, statsFlags, opts)
+		}),
+	}
+	addWatchFlags(stats, statsWatchOpts)
+	stats.Flags().StringVar(&statsFlags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	return []*cobra.Command{create, list, ps, pull, del, diff, apply, up, share, unshare, flash, ota, sshCmd, gc, prune, validate, listTargets, logs, stats, extend}
 }
 
 func connectionCommands(opts *subCommandOpts) []*cobra.Command {
@@ -616,6 +1489,22 @@ func connectionCommands(opts *subCommandOpts) []*cobra.Command {
 		"Don't ask for confirmation for closing multiple connections.")
 	connect.Flags().StringVar(&connFlags.ice_config, iceConfigFlag, "", iceConfigFlagDesc)
 	connect.Flags().StringVar(&connFlags.connectAgent, "connect_agent", ConnectionWebRTCAgentCommandName, "Connect agent type")
+	addClipboardSyncFlags(connect, &connFlags.clipboardSync)
+	addRecordingFlags(connect, &connFlags.recording)
+	addHeartbeatFlags(connect, &connFlags.heartbeat, defaultHeartbeatInterval)
+	addIdleTimeoutFlag(connect, &connFlags.idleTimeout)
+	connect.Flags().BoolVar(&connFlags.sharedAgent, sharedAgentFlag, false,
+		"Serves the connections to the devices of each host from a single agent instead of one per device")
+	addJumpHostFlag(connect, &connFlags.jumpHosts)
+	defaultADBProxy := ""
+	if cfg := opts.InitialConfig.DefaultService().ADBProxy; cfg != nil {
+		defaultADBProxy = cfg.URL
+	}
+	addADBProxyFlag(connect, &connFlags.adbProxy, defaultADBProxy)
+	addCompressFlag(connect, &connFlags.compress)
+	addNetworkFlag(connect, &connFlags.network, opts.InitialConfig.PreferredNetwork())
+	addHealthProbeFlags(connect, &connFlags.healthProbe)
+	addADBConnectFlags(connect, &connFlags.adbConnect)
 	disconnect := &cobra.Command{
 		Use:   fmt.Sprintf("%s <foo> <bar> <baz>", DisconnectCommandName),
 		Short: "Disconnect (ADB) from CVD",
@@ -635,6 +1524,14 @@ func connectionCommands(opts *subCommandOpts) []*cobra.Command {
 	}
 	webrtcAgent.Flags().StringVar(&connFlags.host, hostFlag, "", "Specifies the host")
 	webrtcAgent.Flags().StringVar(&connFlags.ice_config, iceConfigFlag, "", iceConfigFlagDesc)
+	addClipboardSyncFlags(webrtcAgent, &connFlags.clipboardSync)
+	addRecordingFlags(webrtcAgent, &connFlags.recording)
+	// Disabled unless requested by the command starting the agent.
+	addHeartbeatFlags(webrtcAgent, &connFlags.heartbeat, 0)
+	addIdleTimeoutFlag(webrtcAgent, &connFlags.idleTimeout)
+	addNetworkFlag(webrtcAgent, &connFlags.network, publicNetwork)
+	addHealthProbeFlags(webrtcAgent, &connFlags.healthProbe)
+	addADBConnectFlags(webrtcAgent, &connFlags.adbConnect)
 	webrtcAgent.MarkPersistentFlagRequired(hostFlag)
 	proxyAgent := &cobra.Command{
 		Hidden: true,
@@ -645,40 +1542,313 @@ func connectionCommands(opts *subCommandOpts) []*cobra.Command {
 	}
 	proxyAgent.Flags().StringVar(&connFlags.host, hostFlag, "", "Specifies the host")
 	proxyAgent.MarkPersistentFlagRequired(hostFlag)
-	return []*cobra.Command{connect, disconnect, webrtcAgent, proxyAgent}
-}
-
-func runCreateHostCommand(c *cobra.Command, flags *CreateHostFlags, opts *subCommandOpts) error {
-	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
-	if err != nil {
-		return fmt.Errorf("failed to build service instance: %w", err)
+	addJumpHostFlag(proxyAgent, &connFlags.jumpHosts)
+	addADBProxyFlag(proxyAgent, &connFlags.adbProxy, "")
+	addCompressFlag(proxyAgent, &connFlags.compress)
+	attachFlags := &AttachFlags{CVDRemoteFlags: opts.RootFlags}
+	attach := &cobra.Command{
+		Use:   "attach [--host=HOST] <name>",
+		Short: "Attaches the terminal to a device's serial console, connecting to the device if needed",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runAttachCommand(&command{c, &attachFlags.Verbose}, args[0], attachFlags, opts)
+		},
 	}
-	ins, err := createHost(service, *flags.CreateHostOpts)
-	if err != nil {
-		return fmt.Errorf("failed to create host: %w", err)
+	attach.Flags().StringVar(&attachFlags.Host, hostFlag, "", "Specifies the host")
+	attach.MarkFlagRequired(hostFlag)
+	statsFlags := &ConnStatsFlags{CVDRemoteFlags: opts.RootFlags}
+	connStats := &cobra.Command{
+		Use:   "conn_stats [--host=HOST] <name>",
+		Short: "Prints the round trip time, jitter, packet loss and bitrates of a device's connection",
+		Args:  cobra.ExactArgs(1),
+		RunE: withWatch(&statsFlags.Watch, func(c *cobra.Command, args []string) error {
+			return runConnStatsCommand(&command{c, &statsFlags.Verbose}, args[0], statsFlags, opts)
+		}),
+	}
+	connStats.Flags().StringVar(&statsFlags.Host, hostFlag, "", "Specifies the host")
+	connStats.Flags().StringVar(&statsFlags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	addWatchFlags(connStats, &statsFlags.Watch)
+	forwardFlags := &ForwardFlags{CVDRemoteFlags: opts.RootFlags}
+	forward := &cobra.Command{
+		Use:   "forward [--host=HOST] <name> LOCAL_PORT:DEVICE_PORT",
+		Short: "Forwards a local port to a port of a connected device, over its ADB connection",
+		RunE: func(c *cobra.Command, args []string) error {
+			return runForwardCommand(&command{c, &forwardFlags.Verbose}, args, forwardFlags, opts)
+		},
 	}
-	c.Printf("%s\n", ins.Name)
-	return nil
-}
-
-func runListHostCommand(c *cobra.Command, flags *CVDRemoteFlags, opts *subCommandOpts) error {
-	apiClient, err := opts.ServiceBuilder(flags, c)
-	if err != nil {
-		return err
+	forward.Flags().StringVar(&forwardFlags.Host, hostFlag, "", "Specifies the host")
+	forward.Flags().BoolVar(&forwardFlags.List, listFlag, false, "Lists the forwards of the connected devices")
+	forward.Flags().IntVar(&forwardFlags.Remove, removeFlag, 0, "Removes the forward of the given local port")
+	forward.MarkFlagsMutuallyExclusive(listFlag, removeFlag)
+	listConnsFlags := &ListConnectionsFlags{CVDRemoteFlags: opts.RootFlags}
+	listConnsWatchOpts := &WatchOpts{}
+	listConns := &cobra.Command{
+		Use:   "connections [--host=HOST]",
+		Short: "Lists the connections to CVDs with their ADB state, mode, activity and statistics",
+		Args:  cobra.NoArgs,
+		RunE: withWatch(listConnsWatchOpts, func(c *cobra.Command, args []string) error {
+			return runListConnectionsCommand(&command{c, &listConnsFlags.Verbose}, listConnsFlags, opts)
+		}),
+	}
+	addWatchFlags(listConns, listConnsWatchOpts)
+	listConns.Flags().StringVar(&listConnsFlags.Host, hostFlag, "", "Only lists the connections to CVDs in this host")
+	listConns.Flags().StringVar(&listConnsFlags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	exportOutput := ""
+	export := &cobra.Command{
+		Use:   "export [-o FILE]",
+		Short: "Exports references to the connected CVDs, to be restored with import",
+		RunE: func(c *cobra.Command, args []string) error {
+			return runExportCommand(&command{c, &connFlags.Verbose}, exportOutput, opts)
+		},
 	}
-	hosts, err := apiClient.ListHosts()
-	if err != nil {
-		return fmt.Errorf("error listing hosts: %w", err)
+	export.Flags().StringVarP(&exportOutput, exportOutputFlag, "o", "", "Write to this file instead of the standard output")
+	replayFlags := &ReplayFlags{CVDRemoteFlags: opts.RootFlags}
+	replay := &cobra.Command{
+		Use:   "replay [--host=HOST] <name> <SESSION_FILE>",
+		Short: "Replays the input of a session recorded with connect --" + recordSessionFlag + " against a connected device",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runReplayCommand(&command{c, &replayFlags.Verbose}, args, replayFlags, opts)
+		},
 	}
-	for _, ins := range hosts.Items {
-		c.Printf("%s\n", ins.Name)
+	replay.Flags().StringVar(&replayFlags.Host, hostFlag, "", "Specifies the host")
+	replay.Flags().Float64Var(&replayFlags.Speed, speedFlag, 1, "Replays the input this many times faster than it was recorded, i.e: 2")
+	replay.Flags().StringVar(&replayFlags.ExtractVideo, extractVideoFlag, "",
+		"Writes the session's display to the given file instead of replaying it, as IVF (.ivf) for VP8 streams or raw H.264 (.h264) otherwise. Takes only the session file")
+	importFlags := &ConnectFlags{CVDRemoteFlags: opts.RootFlags, connectAgent: ConnectionWebRTCAgentCommandName}
+	importCmd := &cobra.Command{
+		Use:   "import <FILE>",
+		Short: "Connects to the CVDs exported with export, reporting the ones that no longer exist",
+		RunE: func(c *cobra.Command, args []string) error {
+			return runImportCommand(importFlags, &command{c, &importFlags.Verbose}, args, opts)
+		},
 	}
-	return nil
+	importCmd.Flags().StringVar(&importFlags.ice_config, iceConfigFlag, "", iceConfigFlagDesc)
+	addHeartbeatFlags(importCmd, &importFlags.heartbeat, defaultHeartbeatInterval)
+	return []*cobra.Command{connect, disconnect, webrtcAgent, proxyAgent, attach, connStats, listConns, forward, replay, export, importCmd}
 }
 
-func runDeleteHostsCommand(c *cobra.Command, args []string, flags *CVDRemoteFlags, opts *subCommandOpts) error {
-	service, err := opts.ServiceBuilder(flags, c)
-	if err != nil {
+func addClipboardSyncFlags(c *cobra.Command, opts *ClipboardSyncOpts) {
+	c.Flags().BoolVar(&opts.Enabled, clipboardSyncFlag, false,
+		"Synchronize the clipboard between the local machine and the device")
+	c.Flags().StringVar(&opts.Direction, clipboardDirectionFlag, BothClipboardDirections,
+		fmt.Sprintf("Direction of the clipboard sync, one of %q, %q or %q",
+			BothClipboardDirections, ToDeviceClipboardDirection, FromDeviceClipboardDirection))
+}
+
+func addRecordingFlags(c *cobra.Command, opts *RecordingOpts) {
+	c.Flags().StringVar(&opts.Path, recordFlag, "",
+		"Records the device's display to the given file until interrupted, as IVF (.ivf) for VP8 streams or raw H.264 (.h264) otherwise. Other extensions, like .mp4, are rejected")
+	c.Flags().StringVar(&opts.SessionPath, recordSessionFlag, "",
+		"Records the device's display and the input sent to it over ADB to the given file until interrupted, to be replayed with replay")
+	c.Flags().DurationVar(&opts.Duration, recordDurationFlag, 0,
+		"Stops recording after this long, i.e: 5m. Requires --"+recordFlag+" or --"+recordSessionFlag)
+	c.MarkFlagsMutuallyExclusive(recordFlag, recordSessionFlag)
+}
+
+// The flag overrides the jump hosts in the SSH configuration.
+func addJumpHostFlag(c *cobra.Command, hosts *[]string) {
+	c.Flags().StringSliceVar(hosts, jumpHostFlag, nil,
+		"Bastion to go through, as [user@]host[:port]. Repeat the flag or separate with commas to chain them, in order")
+}
+
+func addADBProxyFlag(c *cobra.Command, proxy *string, defaultProxy string) {
+	c.Flags().StringVar(proxy, adbProxyFlag, defaultProxy,
+		"Proxy the ADB connections are tunnelled through, as http://[user:password@]host[:port], https:// or socks5://. Defaults to --"+proxyFlag)
+}
+
+func addCompressFlag(c *cobra.Command, compress *bool) {
+	c.Flags().BoolVar(compress, compressFlag, false,
+		fmt.Sprintf("Compresses the ADB data of --connect_agent=%s connections, connecting uncompressed if the host doesn't support it",
+			ConnectionProxyAgentCommandName))
+}
+
+func addIdleTimeoutFlag(c *cobra.Command, timeout *time.Duration) {
+	c.Flags().DurationVar(timeout, idleTimeoutFlag, 0,
+		"Closes the connection after this long without ADB or console traffic, i.e: 30m. Zero disables it")
+}
+
+func addNetworkFlag(c *cobra.Command, network *string, defaultNetwork string) {
+	c.Flags().StringVar(network, networkFlag, defaultNetwork,
+		fmt.Sprintf("Network to reach the hosts through: %s, through the service, or %s, at the hosts' overlay address falling back to %s",
+			publicNetwork, overlayNetwork, publicNetwork))
+}
+
+func addHeartbeatFlags(c *cobra.Command, opts *HeartbeatOpts, defaultInterval time.Duration) {
+	c.Flags().DurationVar(&opts.Interval, heartbeatIntervalFlag, defaultInterval,
+		"Time between checks that the connection is alive, reconnecting if it isn't. Zero disables them")
+}
+
+// Implements pflag.Value for the repeatable --prop flag.
+type bootPropertyFlagValue struct {
+	props *[]BootProperty
+}
+
+func (v *bootPropertyFlagValue) String() string {
+	if v.props == nil {
+		return ""
+	}
+	strs := []string{}
+	for _, p := range *v.props {
+		strs = append(strs, p.String())
+	}
+	return strings.Join(strs, ",")
+}
+
+func (v *bootPropertyFlagValue) Set(s string) error {
+	p, err := ParseBootProperty(s)
+	if err != nil {
+		return err
+	}
+	*v.props = append(*v.props, p)
+	return nil
+}
+
+func (v *bootPropertyFlagValue) Type() string {
+	return "property"
+}
+
+// Implements pflag.Value for the repeatable --display flag.
+type instanceBuildFlagValue struct {
+	builds *[]InstanceBuild
+}
+
+func (v *instanceBuildFlagValue) String() string {
+	if v.builds == nil {
+		return ""
+	}
+	strs := []string{}
+	for _, b := range *v.builds {
+		strs = append(strs, b.String())
+	}
+	return strings.Join(strs, ",")
+}
+
+func (v *instanceBuildFlagValue) Set(s string) error {
+	b, err := ParseInstanceBuild(s)
+	if err != nil {
+		return err
+	}
+	*v.builds = append(*v.builds, b)
+	return nil
+}
+
+func (v *instanceBuildFlagValue) Type() string {
+	return "instance_build"
+}
+
+type displayFlagValue struct {
+	displays *[]DisplayConfig
+}
+
+func (v *displayFlagValue) String() string {
+	if v.displays == nil {
+		return ""
+	}
+	strs := []string{}
+	for _, d := range *v.displays {
+		strs = append(strs, d.String())
+	}
+	return strings.Join(strs, ",")
+}
+
+func (v *displayFlagValue) Set(s string) error {
+	d, err := ParseDisplayConfig(s)
+	if err != nil {
+		return err
+	}
+	*v.displays = append(*v.displays, d)
+	return nil
+}
+
+func (v *displayFlagValue) Type() string {
+	return "display"
+}
+
+// Implements pflag.Value for build age flags, see parseBuildAge.
+type buildAgeFlagValue struct {
+	v *time.Duration
+}
+
+func (f *buildAgeFlagValue) String() string {
+	if f.v == nil || *f.v == 0 {
+		return ""
+	}
+	return formatBuildAge(*f.v)
+}
+
+func (f *buildAgeFlagValue) Set(s string) error {
+	d, err := parseBuildAge(s)
+	if err != nil {
+		return err
+	}
+	*f.v = d
+	return nil
+}
+
+func (f *buildAgeFlagValue) Type() string {
+	return "age"
+}
+
+// Implements pflag.Value for flags accepting human-readable sizes, stored in MB.
+type sizeFlagValue struct {
+	sizeMB *int64
+}
+
+func (v *sizeFlagValue) String() string {
+	if v.sizeMB == nil || *v.sizeMB == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dM", *v.sizeMB)
+}
+
+func (v *sizeFlagValue) Set(s string) error {
+	size, err := ParseSizeMB(s)
+	if err != nil {
+		return err
+	}
+	*v.sizeMB = size
+	return nil
+}
+
+func (v *sizeFlagValue) Type() string {
+	return "size"
+}
+
+func runCreateHostCommand(c *cobra.Command, flags *CreateHostFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return fmt.Errorf("failed to build service instance: %w", err)
+	}
+	if err := validateHostFeatures(flags.RequireFeatures); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", requireFeatureFlag, err)
+	}
+	ins, err := createHost(service, *flags.CreateHostOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create host: %w", err)
+	}
+	c.Printf("%s\n", ins.Name)
+	return nil
+}
+
+func runListHostCommand(c *cobra.Command, flags *CVDRemoteFlags, opts *subCommandOpts) error {
+	apiClient, err := opts.ServiceBuilder(flags, c)
+	if err != nil {
+		return err
+	}
+	hosts, err := apiClient.ListHosts()
+	if err != nil {
+		return fmt.Errorf("error listing hosts: %w", err)
+	}
+	for _, ins := range hosts.Items {
+		c.Printf("%s\n", ins.Name)
+	}
+	return nil
+}
+
+func runDeleteHostsCommand(c *cobra.Command, args []string, flags *CVDRemoteFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags, c)
+	if err != nil {
 		return err
 	}
 	hosts := args
@@ -714,6 +1884,7 @@ func disconnectDevicesByHost(host string, opts *subCommandOpts) error {
 
 const (
 	createHostStateMsg    = "Creating Host"
+	selectHostStateMsg    = "Selecting least-loaded host"
 	connectCVDStateMsgFmt = "Connecting to %s"
 )
 
@@ -731,42 +1902,276 @@ func runCreateCVDCommand(c *cobra.Command, args []string, flags *CreateCVDFlags,
 		}
 		flags.CreateCVDOpts.EnvConfig = envConfig
 	}
+	if flags.ConfigOverlayFile != "" {
+		overlay, err := readConfigOverlay(flags.ConfigOverlayFile)
+		if err != nil {
+			return fmt.Errorf("invalid --%s flag value: %w", configOverlayFlag, err)
+		}
+		flags.CreateCVDOpts.ConfigOverlay = overlay
+	} else if flags.ConfigOverlayWins {
+		return fmt.Errorf("--%s requires --%s", configOverlayWinsFlag, configOverlayFlag)
+	}
 	if flags.NumInstances <= 0 {
 		return fmt.Errorf("invalid --num_instances flag value: %d", flags.NumInstances)
 	}
-	statePrinter := newStatePrinter(c.ErrOrStderr(), flags.Verbose)
-	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err := validateProgressMode(flags.Progress); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", progressFlag, err)
+	}
+	if err := validateHostFeatures(flags.CreateCVDOpts.RequireFeatures); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", requireFeatureFlag, err)
+	}
+	if flags.Failover && flags.CreateCVDOpts.Host != autoHostValue {
+		return fmt.Errorf("--%s requires --%s=%s", failoverFlag, hostFlag, autoHostValue)
+	}
+	if flags.CreateCVDOpts.TTL != 0 {
+		if err := validateCVDTTL(flags.CreateCVDOpts.TTL); err != nil {
+			return fmt.Errorf("invalid --%s flag value: %w", ttlFlag, err)
+		}
+	}
+	if err := validateSELinuxMode(flags.SELinuxMode); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", selinuxFlag, err)
+	}
+	if flags.SELinuxMode == permissiveSELinuxMode {
+		c.PrintErrln("Warning: permissive devices don't enforce the SELinux policy, use them for debugging only")
+	}
+	if err := validateBootProperties(flags.BootProperties, flags.SELinuxMode); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", propFlag, err)
+	}
+	for _, p := range reservedBootPropertiesIn(flags.BootProperties) {
+		c.PrintErrf("Warning: the device's boot depends on %s, overriding it may keep the device from booting\n", p)
+	}
+	if err := validateSerialNumber(flags.SerialNumber, flags.BootProperties); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", serialFlag, err)
+	}
+	store, err := openCredentialStore(&opts.InitialConfig, c)
 	if err != nil {
-		return fmt.Errorf("failed to build service instance: %w", err)
+		return err
+	}
+	flags.CreateCVDOpts.CredentialStore = store
+	isCIBuild := flags.CreateCVDOpts.EnvConfig == nil && !flags.LocalImage && flags.CreateCVDLocalOpts.empty()
+	targetChanged := c.Flags().Changed(buildTargetFlag)
+	var service client.Service
+	buildChanged := targetChanged || c.Flags().Changed(branchFlag) || c.Flags().Changed(buildIDFlag)
+	if isCIBuild && !buildChanged && len(flags.InstanceBuilds) == 0 {
+		defaults, err := opts.InitialConfig.BuildDefaults.asBuildDefaults()
+		if err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+		if defaults == nil {
+			if service, err = opts.ServiceBuilder(flags.CVDRemoteFlags, c); err != nil {
+				return fmt.Errorf("failed to build service instance: %w", err)
+			}
+			// The built-in defaults still work, failing to get the user's isn't fatal.
+			if defaults, err = serviceBuildDefaults(service); err != nil {
+				c.PrintErrf("Warning: failed getting your default build, using the built-in one: %v\n", err)
+			}
+		}
+		if defaults != nil && applyBuildDefaults(&flags.MainBuild, defaults, flags.Arch != "") {
+			targetChanged = true
+		}
+	}
+	if flags.Arch != "" {
+		if err := validateArch(flags.Arch); err != nil {
+			return fmt.Errorf("invalid --%s flag value: %w", archFlag, err)
+		}
+		if !isCIBuild {
+			return fmt.Errorf("--%s is only supported with Android CI builds", archFlag)
+		}
+		if !targetChanged {
+			flags.MainBuild.Target = defaultBuildTargets[flags.Arch]
+		} else if arch := deviceArchFromTarget(flags.MainBuild.Target); arch != flags.Arch {
+			return fmt.Errorf("build target %q is for %s devices, not %s", flags.MainBuild.Target, arch, flags.Arch)
+		}
+	}
+	if flags.GerritChange != "" {
+		if flags.CreateCVDOpts.EnvConfig != nil {
+			return fmt.Errorf("--%s can't be used with an environment specification", gerritChangeFlag)
+		}
+		var dumpOut io.Writer = io.Discard
+		if flags.Verbose {
+			dumpOut = c.ErrOrStderr()
+		}
+		gerritURL := opts.InitialConfig.GerritURL
+		if gerritURL == "" {
+			gerritURL = client.DefaultGerritURL
+		}
+		gerrit, err := opts.GerritAPIBuilder(gerritURL, flags.Proxy, dumpOut)
+		if err != nil {
+			return fmt.Errorf("failed to build the gerrit api client: %w", err)
+		}
+		api, err := newBuildAPI(c, opts, client.DefaultBuildAPIRootEndpoint, flags.BuildAPICredentialsSource, flags.Proxy, dumpOut)
+		if err != nil {
+			return err
+		}
+		build, err := resolveGerritChangeBuild(gerrit, api, flags.GerritChange, flags.MainBuild)
+		if err != nil {
+			return err
+		}
+		flags.MainBuild = build
+	}
+	if flags.MaxBuildAge > 0 {
+		if flags.CreateCVDOpts.EnvConfig != nil {
+			return fmt.Errorf("--%s can't be used with an environment specification", maxBuildAgeFlag)
+		}
+		var dumpOut io.Writer = io.Discard
+		if flags.Verbose {
+			dumpOut = c.ErrOrStderr()
+		}
+		api, err := newBuildAPI(c, opts, client.DefaultBuildAPIRootEndpoint, flags.BuildAPICredentialsSource, flags.Proxy, dumpOut)
+		if err != nil {
+			return err
+		}
+		build, err := resolveBuildWithMaxAge(api, flags.MainBuild, flags.MaxBuildAge, time.Now())
+		if err != nil {
+			return err
+		}
+		flags.MainBuild = build
+	}
+	if len(flags.Variants) > 0 {
+		if !isCIBuild {
+			return fmt.Errorf("--%s requires an Android CI build", variantFlag)
+		}
+		if err := validateCreateVariants(c, flags, opts); err != nil {
+			return fmt.Errorf("invalid --%s flag value: %w", variantFlag, err)
+		}
+		flags.MainBuild.Target = targetWithVariant(flags.MainBuild.Target, flags.Variants[0])
+	}
+	flags.CreateCVDOpts.UploadCacheDir = opts.InitialConfig.UploadCacheDirExpanded()
+	flags.CreateCVDOpts.BuildAPIMirrors = opts.InitialConfig.BuildAPIMirrors
+	displays, err := displayDefaults(opts.InitialConfig.DisplayDefaults)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	flags.CreateCVDOpts.DisplayDefaults = displays
+	statePrinter := newStatePrinterWithMode(c.ErrOrStderr(), flags.Verbose, flags.Progress)
+	if service == nil {
+		if service, err = opts.ServiceBuilder(flags.CVDRemoteFlags, c); err != nil {
+			return fmt.Errorf("failed to build service instance: %w", err)
+		}
+	}
+	var trace *explainTrace
+	explained := func(err error) error {
+		if trace != nil {
+			trace.Write(c.ErrOrStderr(), opts.CorrelationID, err)
+		}
+		return err
+	}
+	if flags.Explain {
+		trace = newExplainTrace()
+		service = &explainedService{service, trace}
+	}
+	// Hosts to retry the create on if the selected one runs out of resources.
+	var failoverHosts []string
+	if flags.CreateCVDOpts.Host == autoHostValue {
+		statePrinter.Print(selectHostStateMsg)
+		hosts, err := rankHostsByLoad(service, flags.CreateCVDOpts.instancesNum(), flags.CreateCVDOpts.RequireFeatures)
+		statePrinter.PrintDone(selectHostStateMsg, err)
+		if err != nil {
+			return explained(fmt.Errorf("failed to select host: %w", err))
+		}
+		flags.CreateCVDOpts.Host = hosts[0]
+		if flags.Failover {
+			failoverHosts = hosts[1:]
+			if len(failoverHosts) > maxFailoverHosts-1 {
+				failoverHosts = failoverHosts[:maxFailoverHosts-1]
+			}
+		}
 	}
 	if flags.CreateCVDOpts.Host == "" {
 		statePrinter.Print(createHostStateMsg)
-		ins, err := createHost(service, *flags.CreateHostOpts)
+		hostOpts := *flags.CreateHostOpts
+		hostOpts.RequireFeatures = flags.CreateCVDOpts.RequireFeatures
+		ins, err := createHost(service, hostOpts)
 		statePrinter.PrintDone(createHostStateMsg, err)
 		if err != nil {
-			return fmt.Errorf("failed to create host: %w", err)
+			return explained(fmt.Errorf("failed to create host: %w", err))
 		}
 		flags.CreateCVDOpts.Host = ins.Name
 	}
-	cvds, err := createCVD(service, *flags.CreateCVDOpts, statePrinter)
+	hooks := opts.InitialConfig.Hooks
+	numInstances := flags.NumInstances
+	// Builds resolved from a change or by age are of the given target, it can't follow the host's arch.
+	followHostArch := flags.Arch == "" && !targetChanged && flags.GerritChange == "" && flags.MaxBuildAge == 0 &&
+		len(flags.InstanceBuilds) == 0
+	createOnHost := func() ([]*RemoteCVD, error) {
+		flags.NumInstances = numInstances
+		if isCIBuild {
+			host, err := findHost(service, flags.CreateCVDOpts.Host)
+			switch {
+			case err != nil && flags.Arch != "":
+				return nil, fmt.Errorf("failed checking the host's arch: %w", err)
+			case err == nil:
+				if target, ok := defaultBuildTargets[host.Arch]; ok && followHostArch {
+					flags.MainBuild.Target = target
+				}
+				for _, t := range flags.CreateCVDOpts.instanceTargets() {
+					if err := checkHostArch(host, t); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		if hostCfg := opts.InitialConfig.DefaultService().Host; hostCfg != nil && len(hostCfg.DefaultNumInstances) > 0 &&
+			!c.Flags().Changed(numInstancesFlag) && len(flags.InstanceBuilds) == 0 {
+			host, err := findHost(service, flags.CreateCVDOpts.Host)
+			if err != nil {
+				return nil, err
+			}
+			n, err := defaultNumInstances(host, hostCfg.DefaultNumInstances)
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				flags.NumInstances = n
+			}
+		}
+		if hooks != nil && hooks.PreCreate != "" {
+			env := preCreateHookEnv(service.RootURI(), flags.CreateCVDOpts.Host)
+			if err := runHook(preCreateHook, hooks.PreCreate, env, c.ErrOrStderr()); err != nil {
+				return nil, err
+			}
+		}
+		if trace != nil {
+			return createCVDExplained(service, *flags.CreateCVDOpts, statePrinter, trace)
+		}
+		return createCVD(service, *flags.CreateCVDOpts, statePrinter)
+	}
+	var failures error
+	cvds, err := createOnHost()
+	for err != nil && len(failoverHosts) > 0 && isHostResourceExhausted(err) {
+		failures = multierror.Append(failures, &failoverError{Host: flags.CreateCVDOpts.Host, Err: err})
+		c.PrintErrf("Host %s ran out of resources, retrying on %s\n", flags.CreateCVDOpts.Host, failoverHosts[0])
+		flags.CreateCVDOpts.Host, failoverHosts = failoverHosts[0], failoverHosts[1:]
+		cvds, err = createOnHost()
+	}
+	if err != nil && failures != nil {
+		err = multierror.Append(failures, &failoverError{Host: flags.CreateCVDOpts.Host, Err: err})
+	}
 	if err != nil {
 		var apiErr *client.ApiCallError
 		if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
 			c.PrintErrf("Authorization required, please visit %s/auth\n", flags.ServiceURL)
 		}
-		return err
+		return explained(err)
 	}
 	var merr error
 	if flags.CreateCVDOpts.AutoConnect {
 		for _, cvd := range cvds {
 			statePrinter.Print(fmt.Sprintf(connectCVDStateMsgFmt, cvd.WebRTCDeviceID))
-			cvd.ConnStatus, err = ConnectDevice(flags.CreateCVDOpts.Host, cvd.WebRTCDeviceID, "", ConnectionWebRTCAgentCommandName, &command{c, &flags.Verbose}, opts)
+			cvd.ConnStatus, err = ConnectDevice(flags.CreateCVDOpts.Host, cvd.WebRTCDeviceID, "", ConnectionWebRTCAgentCommandName, ConnOpts{Heartbeat: HeartbeatOpts{Interval: defaultHeartbeatInterval}, Network: opts.InitialConfig.PreferredNetwork()}, &command{c, &flags.Verbose}, opts)
 			statePrinter.PrintDone(fmt.Sprintf(connectCVDStateMsgFmt, cvd.WebRTCDeviceID), err)
 			if err != nil {
 				merr = multierror.Append(merr, fmt.Errorf("failed to connect to device: %w", err))
 			}
 		}
 	}
+	if hooks != nil && hooks.PostCreate != "" {
+		var hooksErr error
+		cvds, hooksErr = runPostCreateHooks(service, opts.InitialConfig.ConnectionControlDirExpanded(), hooks, cvds, c.ErrOrStderr())
+		if hooksErr != nil {
+			merr = multierror.Append(merr, hooksErr)
+		}
+	}
 	hosts := []*RemoteHost{
 		{
 			ServiceRootEndpoint: service.RootURI(),
@@ -778,7 +2183,38 @@ func runCreateCVDCommand(c *cobra.Command, args []string, flags *CreateCVDFlags,
 	return merr
 }
 
-func runListCVDsCommand(c *cobra.Command, flags *ListCVDsFlags, opts *subCommandOpts) error {
+// Returns the devices that were not deleted after their hook failed along with the hook errors.
+func runPostCreateHooks(service client.Service, controlDir string, hooks *HooksConfig, cvds []*RemoteCVD, out io.Writer) ([]*RemoteCVD, error) {
+	var merr error
+	result := []*RemoteCVD{}
+	for _, cvd := range cvds {
+		err := runHook(postCreateHook, hooks.PostCreate, postCreateHookEnv(cvd), out)
+		if err == nil {
+			result = append(result, cvd)
+			continue
+		}
+		merr = multierror.Append(merr, fmt.Errorf("device %q: %w", cvd.WebRTCDeviceID, err))
+		if !hooks.DeleteOnPostCreateFailure {
+			result = append(result, cvd)
+			continue
+		}
+		if cvd.ConnStatus != nil {
+			if err := DisconnectCVD(controlDir, cvd.RemoteCVDLocator, *cvd.ConnStatus); err != nil {
+				merr = multierror.Append(merr, err)
+			}
+		}
+		if err := service.HostService(cvd.Host).DeleteCVD(cvd.ID); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to delete device %q after its post create hook failed: %w", cvd.WebRTCDeviceID, err))
+			result = append(result, cvd)
+		}
+	}
+	return result, merr
+}
+
+func runPsCommand(c *cobra.Command, flags *PsFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
 	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
 	if err != nil {
 		return err
@@ -787,42 +2223,167 @@ func runListCVDsCommand(c *cobra.Command, flags *ListCVDsFlags, opts *subCommand
 	if flags.Host != "" {
 		hosts, err = listCVDsSingleHost(service, opts.InitialConfig.ConnectionControlDirExpanded(), flags.Host)
 	} else {
-		hosts, err = listCVDs(service, opts.InitialConfig.ConnectionControlDirExpanded())
+		hosts, err = listCVDs(service, opts.InitialConfig.ConnectionControlDirExpanded(), c.ErrOrStderr())
+		if err == nil {
+			writeEmptyListNotice(c.ErrOrStderr(), hosts)
+		}
+	}
+	if !flags.All {
+		hosts = runningCVDs(hosts)
+	}
+	if flags.Format == jsonOutputFormat {
+		if jerr := writePsJSON(c.OutOrStdout(), hosts); jerr != nil {
+			return jerr
+		}
+		return err
 	}
 	WriteListCVDsOutput(c.OutOrStdout(), hosts)
 	return err
 }
 
-func runPullCommand(c *cobra.Command, args []string, flags *CVDRemoteFlags, opts *subCommandOpts) error {
-	service, err := opts.ServiceBuilder(flags, c)
-	if err != nil {
-		return err
+func runListCVDsCommand(c *cobra.Command, flags *ListCVDsFlags, opts *subCommandOpts) error {
+	if c.Flags().Changed(outputFieldsFlag) {
+		if err := validateOutputFields(flags.OutputFields); err != nil {
+			return fmt.Errorf("invalid --%s flag value: %w", outputFieldsFlag, err)
+		}
 	}
-	host := ""
-	switch l := len(args); l {
-	case 0:
-		sel, err := promptSingleHostNameSelection(&command{c, &flags.Verbose}, service)
-		if err != nil {
+	if flags.AllServices {
+		hosts, err := listCVDsAllServices(c, flags, opts)
+		if flags.Tree {
+			// The hosts may be in different zones.
+			WriteListCVDsTree(c.OutOrStdout(), hosts, "")
 			return err
 		}
-		if sel == "" {
-			c.PrintErrln("No hosts")
-			return nil
+		if len(flags.OutputFields) > 0 {
+			writeListCVDsTable(c.OutOrStdout(), hosts, flags.OutputFields)
+			return err
 		}
-		host = sel
-	case 1:
-		host = args[0]
-	default /* len(args) > 1 */ :
-		return errors.New("invalid number of args")
+		WriteListCVDsOutput(c.OutOrStdout(), hosts)
+		return err
 	}
-	f, err := os.CreateTemp("", "cvdrPull")
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
 	if err != nil {
 		return err
 	}
-	if err := service.HostService(host).DownloadRuntimeArtifacts(f); err != nil {
+	var hosts []*RemoteHost
+	if flags.Host != "" {
+		hosts, err = listCVDsSingleHost(service, opts.InitialConfig.ConnectionControlDirExpanded(), flags.Host)
+	} else {
+		hosts, err = listCVDs(service, opts.InitialConfig.ConnectionControlDirExpanded(), c.ErrOrStderr())
+		if err == nil {
+			writeEmptyListNotice(c.ErrOrStderr(), hosts)
+		}
+	}
+	if flags.Tree {
+		WriteListCVDsTree(c.OutOrStdout(), hosts, flags.Zone)
 		return err
 	}
-	if err := f.Close(); err != nil {
+	if len(flags.OutputFields) > 0 {
+		writeListCVDsTable(c.OutOrStdout(), hosts, flags.OutputFields)
+		return err
+	}
+	WriteListCVDsOutput(c.OutOrStdout(), hosts)
+	return err
+}
+
+func runStatsCommand(c *cobra.Command, flags *StatsFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	// Hosts failing to list their devices count as empty, their errors are returned after the stats.
+	hosts, err := listCVDs(service, opts.InitialConfig.ConnectionControlDirExpanded(), c.ErrOrStderr())
+	stats := computeFleetStats(hosts)
+	if flags.Format == jsonOutputFormat {
+		if jerr := writeFleetStatsJSON(c.OutOrStdout(), stats); jerr != nil {
+			return jerr
+		}
+		return err
+	}
+	writeFleetStats(c.OutOrStdout(), stats)
+	return err
+}
+
+// Lists the CVDs of every configured service concurrently. A failure listing the CVDs of one
+// service doesn't prevent listing the others.
+func listCVDsAllServices(c *cobra.Command, flags *ListCVDsFlags, opts *subCommandOpts) ([]*RemoteHost, error) {
+	names := []string{}
+	for name := range opts.InitialConfig.Services {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no services configured")
+	}
+	sort.Strings(names)
+	type listResult struct {
+		Hosts []*RemoteHost
+		Error error
+	}
+	results := make([]listResult, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, profile *Service) {
+			defer wg.Done()
+			remoteFlags := &CVDRemoteFlags{
+				ServiceURL: profile.ServiceURL,
+				Zone:       profile.Zone,
+				Proxy:      profile.Proxy,
+				Verbose:    flags.Verbose,
+			}
+			service, err := opts.ProfileServiceBuilder(profile)(remoteFlags, c)
+			if err != nil {
+				results[i].Error = err
+				return
+			}
+			results[i].Hosts, results[i].Error = listCVDs(service, opts.InitialConfig.ConnectionControlDirExpanded(), c.ErrOrStderr())
+		}(i, opts.InitialConfig.Services[name])
+	}
+	wg.Wait()
+	var result []*RemoteHost
+	var merr error
+	for i, r := range results {
+		result = append(result, r.Hosts...)
+		if r.Error != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed listing cvds of service %q: %w", names[i], r.Error))
+		}
+	}
+	return result, merr
+}
+
+func runPullCommand(c *cobra.Command, args []string, flags *CVDRemoteFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags, c)
+	if err != nil {
+		return err
+	}
+	host := ""
+	switch l := len(args); l {
+	case 0:
+		sel, err := promptSingleHostNameSelection(&command{c, &flags.Verbose}, service)
+		if err != nil {
+			return err
+		}
+		if sel == "" {
+			c.PrintErrln("No hosts")
+			return nil
+		}
+		host = sel
+	case 1:
+		host = args[0]
+	default /* len(args) > 1 */ :
+		return errors.New("invalid number of args")
+	}
+	f, err := os.CreateTemp("", "cvdrPull")
+	if err != nil {
+		return err
+	}
+	if err := service.HostService(host).DownloadRuntimeArtifacts(f); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
 		return err
 	}
 	c.Println("See logs: " + f.Name())
@@ -840,9 +2401,713 @@ func runDeleteCVDCommand(c *cobra.Command, args []string, flags *DeleteCVDFlags,
 	if len(args) > 1 {
 		return errors.New("deleting multiple instances is not supported yet")
 	}
+	controlDir := opts.InitialConfig.ConnectionControlDirExpanded()
+	statuses, err := listCVDConnectionsByHost(controlDir, flags.Host)
+	if err != nil {
+		// The connections that could be listed are still cleaned up.
+		c.PrintErrf("Warning: some connections can't be checked: %v\n", err)
+	}
+	deps, err := findCVDDependencies(service, statuses, flags.Host, args[0])
+	if flags.DryRun {
+		if err != nil {
+			return err
+		}
+		writeCVDDependencies(c.OutOrStdout(), deps)
+		return nil
+	}
+	// The host may still delete devices the lookup misses, like whole groups given their id.
+	if err != nil {
+		c.PrintErrf("Warning: deleting %s without cleaning up after it: %v\n", args[0], err)
+	} else if err := cleanUpCVDDependencies(service, controlDir, deps, statuses); err != nil {
+		c.PrintErrf("Warning: failed to clean up after %s/%s: %v\n", flags.Host, deps.CVD.Name, err)
+	}
 	return service.HostService(flags.Host).DeleteCVD(args[0])
 }
 
+func runExtendCVDCommand(c *cobra.Command, args []string, flags *ExtendCVDFlags, opts *subCommandOpts) error {
+	if err := validateCVDTTL(flags.TTL); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", ttlFlag, err)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	cvd, err := service.HostService(flags.Host).ExtendCVD(args[0], flags.TTL)
+	var apiErr *client.ApiCallError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return fmt.Errorf("device %q doesn't exist or host %q doesn't enforce TTLs: %w", args[0], flags.Host, err)
+	}
+	if err != nil {
+		return err
+	}
+	if cvd.ExpireTime == "" {
+		c.Printf("%s/%s extended\n", flags.Host, args[0])
+		return nil
+	}
+	c.Printf("%s/%s expires at %s\n", flags.Host, args[0], cvd.ExpireTime)
+	return nil
+}
+
+func runApplyCommand(c *cobra.Command, flags *ApplyFlags, opts *subCommandOpts) error {
+	spec, err := loadFleetSpec(flags.SpecFile)
+	if err != nil {
+		return err
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	running, err := listSpecHostsCVDs(service, spec)
+	if err != nil {
+		return err
+	}
+	plan := planApply(spec, running, flags.Prune)
+	writeApplyPlan(c.OutOrStdout(), plan)
+	if plan.Empty() {
+		return nil
+	}
+	if !flags.AutoApprove {
+		c.PrintErrf("Apply these changes? [y/N]: ")
+		answer := ""
+		// An empty answer is a no.
+		fmt.Fscanln(c.InOrStdin(), &answer)
+		if answer != "y" && answer != "Y" {
+			return errors.New("apply cancelled")
+		}
+	}
+	statePrinter := newStatePrinter(c.ErrOrStderr(), flags.Verbose)
+	store, err := openCredentialStore(&opts.InitialConfig, c)
+	if err != nil {
+		return err
+	}
+	return executeApplyPlan(service, plan, flags.BuildAPICredentialsSource, store, statePrinter)
+}
+
+func runUpCommand(c *cobra.Command, spec string, flags *UpFlags, opts *subCommandOpts) error {
+	build, err := ParseInstanceBuild(spec)
+	if err != nil {
+		return err
+	}
+	displays, err := displayDefaults(opts.InitialConfig.DisplayDefaults)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	host := flags.Host
+	if host == autoHostValue {
+		if host, err = selectLeastLoadedHost(service, build.Count, nil); err != nil {
+			return fmt.Errorf("failed to select host: %w", err)
+		}
+	}
+	store, err := openCredentialStore(&opts.InitialConfig, c)
+	if err != nil {
+		return err
+	}
+	createOpts := CreateCVDOpts{
+		Host:                      host,
+		MainBuild:                 build.Build,
+		NumInstances:              build.Count,
+		BuildAPICredentialsSource: flags.BuildAPICredentialsSource,
+		CredentialStore:           store,
+		DisplayDefaults:           displays,
+		UploadCacheDir:            opts.InitialConfig.UploadCacheDirExpanded(),
+		BuildAPIMirrors:           opts.InitialConfig.BuildAPIMirrors,
+	}
+	statePrinter := newStatePrinter(c.ErrOrStderr(), flags.Verbose)
+	connect := func(cvd *RemoteCVD) error {
+		msg := fmt.Sprintf(connectCVDStateMsgFmt, cvd.WebRTCDeviceID)
+		statePrinter.Print(msg)
+		connOpts := ConnOpts{Heartbeat: HeartbeatOpts{Interval: defaultHeartbeatInterval}, Network: opts.InitialConfig.PreferredNetwork()}
+		status, err := ConnectDevice(host, cvd.WebRTCDeviceID, "", ConnectionWebRTCAgentCommandName, connOpts, &command{c, &flags.Verbose}, opts)
+		statePrinter.PrintDone(msg, err)
+		cvd.ConnStatus = status
+		return err
+	}
+	cvds, err := upCVDs(service, createOpts, connect, flags, opts.InitialConfig.ConnectionControlDirExpanded(), statePrinter)
+	if err != nil {
+		return err
+	}
+	WriteListCVDsOutput(c.OutOrStdout(), []*RemoteHost{{ServiceRootEndpoint: service.RootURI(), Name: host, CVDs: cvds}})
+	if !flags.Detach {
+		fmt.Fprintf(c.OutOrStdout(), "Open the display at %s\n", client.BuilHostIndexURL(service.RootURI(), host))
+	}
+	return nil
+}
+
+func runShareCVDCommand(c *cobra.Command, name string, flags *ShareCVDFlags, opts *subCommandOpts) error {
+	if flags.TTL < time.Second {
+		return fmt.Errorf("invalid --%s flag value: %v", ttlFlag, flags.TTL)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	cvd, err := getCVD(service, flags.Host, name)
+	if err != nil {
+		return err
+	}
+	link, err := service.CreateShareLink(flags.Host, cvd.Name, flags.TTL)
+	if err != nil {
+		return fmt.Errorf("failed creating share link: %w", err)
+	}
+	c.Printf("%s\n", link)
+	return nil
+}
+
+func runUnshareCVDCommand(c *cobra.Command, name string, flags *ShareCVDFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	cvd, err := getCVD(service, flags.Host, name)
+	if err != nil {
+		return err
+	}
+	if err := service.RevokeShareLinks(flags.Host, cvd.Name); err != nil {
+		return fmt.Errorf("failed revoking share links: %w", err)
+	}
+	return nil
+}
+
+func runSSHCommand(c *cobra.Command, hostName string, cmd, jumpHosts []string, flags *CVDRemoteFlags, opts *subCommandOpts) error {
+	cfg := SSHConfig{}
+	if opts.InitialConfig.SSH != nil {
+		cfg = *opts.InitialConfig.SSH
+	}
+	if len(jumpHosts) > 0 {
+		cfg.JumpHosts = jumpHosts
+	}
+	if err := validateJumpHosts(cfg.JumpHosts); err != nil {
+		return err
+	}
+	service, err := opts.ServiceBuilder(flags, c)
+	if err != nil {
+		return err
+	}
+	host, err := findHost(service, hostName)
+	if err != nil {
+		return err
+	}
+	cmdLine, err := sshCommandLine(host, &cfg, cmd)
+	if err != nil {
+		return err
+	}
+	sshCmd := exec.Command(cmdLine[0], cmdLine[1:]...)
+	sshCmd.Stdin = os.Stdin
+	sshCmd.Stdout = c.OutOrStdout()
+	sshCmd.Stderr = c.ErrOrStderr()
+	if err := sshCmd.Run(); err != nil {
+		return fmt.Errorf("ssh session to %q failed: %w", hostName, err)
+	}
+	return nil
+}
+
+func runGCCommand(c *cobra.Command, flags *GCFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	srv := service.HostService(flags.Host)
+	uploads, err := srv.ListUploads()
+	if err != nil {
+		return fmt.Errorf("failed to list upload directories of %q: %w", flags.Host, err)
+	}
+	cvds, err := srv.ListCVDs()
+	if err != nil {
+		return fmt.Errorf("failed to list devices of %q: %w", flags.Host, err)
+	}
+	if unknown := cvdsWithoutBuildSource(cvds); len(unknown) > 0 {
+		if !flags.DryRun {
+			return fmt.Errorf("refusing to delete upload directories of %q, its devices %s don't report their build source and may use any of them",
+				flags.Host, strings.Join(unknown, ", "))
+		}
+		c.PrintErrf("Warning: devices %s don't report their build source, gc would refuse to delete anything\n",
+			strings.Join(unknown, ", "))
+	}
+	// Keep the last upload so following incremental creates don't need to upload everything again.
+	keep := []string{}
+	cache := &uploadCache{Dir: opts.InitialConfig.UploadCacheDirExpanded()}
+	if entry, err := cache.Get(service.RootURI(), flags.Host); err != nil {
+		c.PrintErrf("Warning: failed to read the upload cache, the last upload won't be kept: %v\n", err)
+	} else if entry != nil {
+		keep = append(keep, entry.UploadDir)
+	}
+	return collectUploads(srv, orphanedUploads(uploads, cvds, keep), flags.DryRun, c.OutOrStdout())
+}
+
+func runPruneCommand(c *cobra.Command, flags *PruneFlags, opts *subCommandOpts) error {
+	if flags.OlderThan < 0 {
+		return fmt.Errorf("invalid --%s flag value: %v", olderThanFlag, flags.OlderThan)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	hosts := []string{flags.Host}
+	if flags.Host == "" {
+		res, err := service.ListHosts()
+		if err != nil {
+			return fmt.Errorf("failed to list hosts: %w", err)
+		}
+		hosts = []string{}
+		for _, h := range res.Items {
+			hosts = append(hosts, h.Name)
+		}
+	}
+	cvds := make(map[string][]*client.CVDDetails)
+	for _, h := range hosts {
+		list, err := service.HostService(h).ListCVDDetails()
+		if err != nil {
+			// Unreachable hosts don't stop the others from being pruned.
+			c.PrintErrf("Warning: failed to list the devices of %q: %v\n", h, err)
+			continue
+		}
+		cvds[h] = list
+	}
+	candidates, unknownAge := pruneCandidates(hosts, cvds, flags.OlderThan, time.Now())
+	if unknownAge > 0 {
+		c.PrintErrf("Skipped %d devices whose host doesn't report when they were created\n", unknownAge)
+	}
+	if len(candidates) == 0 {
+		c.PrintErrln("No devices to prune")
+		return nil
+	}
+	if flags.DryRun {
+		pruneCVDs(service, candidates, true, c.OutOrStdout())
+		c.PrintErrf("Run with --%s=false to delete them\n", dryRunFlag)
+		return nil
+	}
+	if !flags.Yes {
+		for _, cand := range candidates {
+			c.PrintErrln(cand)
+		}
+		c.PrintErrf("Delete these %d devices? [y/N]: ", len(candidates))
+		answer := ""
+		// An empty answer is a no.
+		fmt.Fscanln(c.InOrStdin(), &answer)
+		if answer != "y" && answer != "Y" {
+			return errors.New("prune cancelled")
+		}
+	}
+	return pruneCVDs(service, candidates, false, c.OutOrStdout())
+}
+
+func runFlashCVDCommand(c *cobra.Command, name string, flags *FlashCVDFlags, opts *subCommandOpts) error {
+	if err := validatePartition(flags.Partition); err != nil {
+		return err
+	}
+	if err := validatePartitionImage(flags.Image); err != nil {
+		return err
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	cvd, err := getCVD(service, flags.Host, name)
+	if err != nil {
+		return err
+	}
+	if err := validateFlashableCVD(cvd); err != nil {
+		return err
+	}
+	statePrinter := newStatePrinter(c.ErrOrStderr(), flags.Verbose)
+	state := fmt.Sprintf("Flashing %s onto the %s partition of %s/%s", filepath.Base(flags.Image), flags.Partition, flags.Host, cvd.Name)
+	statePrinter.Print(state)
+	err = service.FlashPartition(flags.Host, cvd.Name, flags.Partition, flags.Image)
+	statePrinter.PrintDone(state, err)
+	if err != nil {
+		return fmt.Errorf("failed flashing %s: %w", flags.Partition, err)
+	}
+	return nil
+}
+
+func runOTACommand(c *cobra.Command, name string, flags *OTAFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	cvd, err := getCVD(service, flags.Host, name)
+	if err != nil {
+		return err
+	}
+	current, err := validateOTACVD(cvd, flags.ToBuild, flags.Full)
+	if err != nil {
+		return err
+	}
+	var dumpOut io.Writer = io.Discard
+	if flags.Verbose {
+		dumpOut = c.ErrOrStderr()
+	}
+	api, err := newBuildAPI(c, opts, client.DefaultBuildAPIRootEndpoint, flags.BuildAPICredentialsSource, flags.Proxy, dumpOut)
+	if err != nil {
+		return err
+	}
+	if err := validateOTAArtifacts(api, current, flags.ToBuild, flags.Full); err != nil {
+		return fmt.Errorf("build %s isn't OTA compatible with device %q: %w", flags.ToBuild, cvd.Name, err)
+	}
+	statePrinter := newStatePrinter(c.ErrOrStderr(), flags.Verbose)
+	progress := newOTAProgressPrinter(statePrinter, fmt.Sprintf("Requesting the update of %s/%s to build %s", flags.Host, cvd.Name, flags.ToBuild))
+	otaOpts := client.ApplyOTAOptions{Target: current.Target, Full: flags.Full, OnProgress: progress.OnProgress}
+	err = service.ApplyOTAWithOptions(flags.Host, cvd.Name, flags.ToBuild, otaOpts)
+	progress.Done(err)
+	if err != nil {
+		return fmt.Errorf("failed updating %s to build %s: %w", cvd.Name, flags.ToBuild, err)
+	}
+	c.Printf("%s/%s is running build %s\n", flags.Host, cvd.Name, flags.ToBuild)
+	return nil
+}
+
+func runAttachCommand(c *command, name string, flags *AttachFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c.Command)
+	if err != nil {
+		return err
+	}
+	cvd, err := getCVD(service, flags.Host, name)
+	if err != nil {
+		return err
+	}
+	controlDir := opts.InitialConfig.ConnectionControlDirExpanded()
+	statuses, err := listCVDConnectionsByHost(controlDir, flags.Host)
+	if err != nil {
+		// The device may be connected by an agent that couldn't be reached, a second connection is
+		// harmless.
+		c.PrintErrln(err)
+	}
+	var status *ConnStatus
+	for l, s := range statuses {
+		if l.WebRTCDeviceID == cvd.WebRTCDeviceID {
+			s := s
+			status = &s
+			break
+		}
+	}
+	if status == nil {
+		c.PrintErrf("Connecting to %s/%s\n", flags.Host, cvd.WebRTCDeviceID)
+		connOpts := ConnOpts{Heartbeat: HeartbeatOpts{Interval: defaultHeartbeatInterval}, Network: opts.InitialConfig.PreferredNetwork()}
+		status, err = ConnectDevice(flags.Host, cvd.WebRTCDeviceID, "", ConnectionWebRTCAgentCommandName, connOpts, c, opts)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s/%s: %w", flags.Host, cvd.WebRTCDeviceID, err)
+		}
+	}
+	if status.Console == "" {
+		return fmt.Errorf("the connection to %s/%s doesn't serve the console, reconnect with the %s agent",
+			flags.Host, cvd.WebRTCDeviceID, ConnectionWebRTCAgentCommandName)
+	}
+	c.PrintErrf("Attached to the console of %s/%s, press Ctrl-] to detach\n", flags.Host, cvd.WebRTCDeviceID)
+	err = attachConsole(status.Console, os.Stdin, c.OutOrStdout())
+	// The terminal was in raw mode, start a new line.
+	c.PrintErrln()
+	if err != nil {
+		return fmt.Errorf("console of %s/%s detached: %w", flags.Host, cvd.WebRTCDeviceID, err)
+	}
+	c.PrintErrf("Detached from %s/%s\n", flags.Host, cvd.WebRTCDeviceID)
+	return nil
+}
+
+func runConnStatsCommand(c *command, name string, flags *ConnStatsFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	controlDir := opts.InitialConfig.ConnectionControlDirExpanded()
+	cvd, status, err := findCVDConnection(controlDir, flags.Host, name)
+	if err != nil {
+		return err
+	}
+	if flags.Format == jsonOutputFormat {
+		encoder := json.NewEncoder(c.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status.Stats)
+	}
+	writeConnStats(c.OutOrStdout(), cvd, status.Stats)
+	return nil
+}
+
+func runListConnectionsCommand(c *command, flags *ListConnectionsFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	controlDir := opts.InitialConfig.ConnectionControlDirExpanded()
+	var statuses map[RemoteCVDLocator]ConnStatus
+	var err error
+	if flags.Host != "" {
+		statuses, err = listCVDConnectionsByHost(controlDir, flags.Host)
+	} else {
+		statuses, err = listCVDConnections(controlDir)
+	}
+	if err != nil {
+		// List the connections that could be reached.
+		c.PrintErrf("Warning: failed listing some connections: %v\n", err)
+	}
+	listing := newConnListing(statuses, time.Now())
+	if flags.Format == jsonOutputFormat {
+		return writeConnListingJSON(c.OutOrStdout(), listing)
+	}
+	writeConnListing(c.OutOrStdout(), listing)
+	return nil
+}
+
+// Finds the connection to the device by its webrtc device id or name, only in the host if given.
+func findCVDConnection(controlDir, host, name string) (RemoteCVDLocator, *ConnStatus, error) {
+	var statuses map[RemoteCVDLocator]ConnStatus
+	var err error
+	if host != "" {
+		statuses, err = listCVDConnectionsByHost(controlDir, host)
+	} else {
+		statuses, err = listCVDConnections(controlDir)
+	}
+	var cvd RemoteCVDLocator
+	var status *ConnStatus
+	for l, s := range statuses {
+		if l.WebRTCDeviceID == name || (l.Name != "" && l.Name == name) {
+			if status != nil {
+				return RemoteCVDLocator{}, nil, fmt.Errorf("connections to %q in several hosts, use --%s to choose one", name, hostFlag)
+			}
+			s := s
+			cvd, status = l, &s
+		}
+	}
+	if status == nil {
+		if err != nil {
+			return RemoteCVDLocator{}, nil, fmt.Errorf("no connection to %q found: %w", name, err)
+		}
+		return RemoteCVDLocator{}, nil, fmt.Errorf("no connection to %q found", name)
+	}
+	return cvd, status, nil
+}
+
+func runForwardCommand(c *command, args []string, flags *ForwardFlags, opts *subCommandOpts) error {
+	controlDir := opts.InitialConfig.ConnectionControlDirExpanded()
+	if flags.List {
+		if len(args) > 0 {
+			return fmt.Errorf("--%s takes no arguments, received: %v", listFlag, args)
+		}
+		var statuses map[RemoteCVDLocator]ConnStatus
+		var err error
+		if flags.Host != "" {
+			statuses, err = listCVDConnectionsByHost(controlDir, flags.Host)
+		} else {
+			statuses, err = listCVDConnections(controlDir)
+		}
+		if err != nil {
+			c.PrintErrf("Warning: failed listing some connections: %v\n", err)
+		}
+		writePortForwards(c.OutOrStdout(), statuses)
+		return nil
+	}
+	if len(args) == 0 {
+		return errors.New("missing device name")
+	}
+	cvd, status, err := findCVDConnection(controlDir, flags.Host, args[0])
+	if err != nil {
+		return fmt.Errorf("%w, connect to the device first", err)
+	}
+	if flags.Remove != 0 {
+		if len(args) != 1 {
+			return fmt.Errorf("--%s takes only the device name, received: %v", removeFlag, args)
+		}
+		if err := RemovePortForward(controlDir, cvd, *status, flags.Remove); err != nil {
+			return fmt.Errorf("failed removing the forward of %s/%s: %w", cvd.Host, cvd.WebRTCDeviceID, err)
+		}
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("expected the device name and LOCAL_PORT:DEVICE_PORT, received: %v", args)
+	}
+	f, err := ParsePortForward(args[1])
+	if err != nil {
+		return err
+	}
+	if err := AddPortForward(controlDir, cvd, *status, f); err != nil {
+		return fmt.Errorf("failed forwarding to %s/%s: %w", cvd.Host, cvd.WebRTCDeviceID, err)
+	}
+	c.Printf("%s/%s: 127.0.0.1:%d -> %d\n", cvd.Host, cvd.WebRTCDeviceID, f.LocalPort, f.DevicePort)
+	return nil
+}
+
+func runReplayCommand(c *command, args []string, flags *ReplayFlags, opts *subCommandOpts) error {
+	if flags.ExtractVideo != "" {
+		if len(args) != 1 {
+			return fmt.Errorf("--%s takes only the session file, received: %v", extractVideoFlag, args)
+		}
+		if err := validateRecordingPath(flags.ExtractVideo); err != nil {
+			return fmt.Errorf("invalid --%s flag value: %w", extractVideoFlag, err)
+		}
+		return extractSessionVideoFile(c, args[0], flags.ExtractVideo)
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("expected the device name and the session file, received: %v", args)
+	}
+	if flags.Speed <= 0 {
+		return fmt.Errorf("invalid --%s flag value: %v", speedFlag, flags.Speed)
+	}
+	cvd, status, err := findCVDConnection(opts.InitialConfig.ConnectionControlDirExpanded(), flags.Host, args[0])
+	if err != nil {
+		return fmt.Errorf("%w, connect to the device first", err)
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return fmt.Errorf("failed opening the session: %w", err)
+	}
+	defer f.Close()
+	session, err := newSessionReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid session %q: %w", args[1], err)
+	}
+	c.PrintErrf("Replaying the session recorded on %s/%s at %s\n",
+		session.Header.Host, session.Header.WebRTCDeviceID, session.Header.Started.Format(time.RFC3339))
+	serial := fmt.Sprintf("127.0.0.1:%d", status.ADB.Port)
+	n, err := replaySession(session, flags.Speed, func(cmd string) error {
+		c.PrintVerboseln(cmd)
+		out, err := runADBShell(serial, cmd, 0)
+		if len(out) > 0 {
+			// The input command only prints its usage on failure.
+			c.PrintErr(string(out))
+		}
+		return err
+	}, time.Sleep)
+	if err != nil {
+		return fmt.Errorf("replay on %s/%s failed after %d input events: %w", cvd.Host, cvd.WebRTCDeviceID, n, err)
+	}
+	c.Printf("Replayed %d input events on %s/%s\n", n, cvd.Host, cvd.WebRTCDeviceID)
+	return nil
+}
+
+func extractSessionVideoFile(c *command, sessionPath, outPath string) error {
+	f, err := os.Open(sessionPath)
+	if err != nil {
+		return fmt.Errorf("failed opening the session: %w", err)
+	}
+	defer f.Close()
+	session, err := newSessionReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid session %q: %w", sessionPath, err)
+	}
+	n, err := extractSessionVideo(session, outPath)
+	if err != nil {
+		return fmt.Errorf("failed extracting the display of %q: %w", sessionPath, err)
+	}
+	c.Printf("Wrote %d video packets to %s\n", n, outPath)
+	return nil
+}
+
+func runDiffCVDsCommand(c *cobra.Command, args []string, flags *DiffCVDsFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
+	}
+	left, err := loadCVDConfig(service, flags.Host, args[0])
+	if err != nil {
+		return err
+	}
+	right, err := loadCVDConfig(service, flags.Host, args[1])
+	if err != nil {
+		return err
+	}
+	diffs, err := diffCVDConfigs(left, right)
+	if err != nil {
+		return fmt.Errorf("failed comparing devices: %w", err)
+	}
+	result := &ConfigDiff{Left: args[0], Right: args[1], Differences: diffs}
+	if flags.Format == jsonOutputFormat {
+		encoder := json.NewEncoder(c.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	writeConfigDiff(c.OutOrStdout(), result)
+	c.PrintErrln("Instance settings aren't compared, the hosts don't report them")
+	return nil
+}
+
+// Validates the variants against the targets of the branch. Builds given by id aren't looked up,
+// the host fails fetching the variants they lack.
+func validateCreateVariants(c *cobra.Command, flags *CreateCVDFlags, opts *subCommandOpts) error {
+	if flags.MainBuild.BuildID != "" {
+		return validateVariantsUnique(flags.Variants)
+	}
+	var dumpOut io.Writer = io.Discard
+	if flags.Verbose {
+		dumpOut = c.ErrOrStderr()
+	}
+	api, err := newBuildAPI(c, opts, client.DefaultBuildAPIRootEndpoint, flags.BuildAPICredentialsSource, flags.Proxy, dumpOut)
+	if err != nil {
+		return err
+	}
+	cache := &targetCache{Dir: opts.InitialConfig.TargetCacheDir(), TTL: targetCacheTTL}
+	targets, err := listBuildTargets(api, client.DefaultBuildAPIRootEndpoint, cache, flags.MainBuild.Branch, false, time.Now())
+	if err != nil {
+		return err
+	}
+	return validateVariants(flags.MainBuild.Target, flags.Variants, targets)
+}
+
+func runListTargetsCommand(c *cobra.Command, flags *ListTargetsFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	if flags.Branch == "" {
+		return fmt.Errorf("missing --%s", branchFlag)
+	}
+	var dumpOut io.Writer = io.Discard
+	if flags.Verbose {
+		dumpOut = c.ErrOrStderr()
+	}
+	api, err := newBuildAPI(c, opts, flags.BuildAPIURL, flags.BuildAPICredentialsSource, flags.Proxy, dumpOut)
+	if err != nil {
+		return err
+	}
+	cache := &targetCache{Dir: opts.InitialConfig.TargetCacheDir(), TTL: targetCacheTTL}
+	targets, err := listBuildTargets(api, flags.BuildAPIURL, cache, flags.Branch, flags.Refresh, time.Now())
+	if err != nil {
+		return err
+	}
+	if flags.Format == jsonOutputFormat {
+		encoder := json.NewEncoder(c.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(targets)
+	}
+	for _, t := range targets {
+		c.Println(t)
+	}
+	return nil
+}
+
+func runValidateBuildCommand(c *cobra.Command, flags *ValidateBuildFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	var dumpOut io.Writer = io.Discard
+	if flags.Verbose {
+		dumpOut = c.ErrOrStderr()
+	}
+	api, err := newBuildAPI(c, opts, flags.BuildAPIURL, flags.BuildAPICredentialsSource, flags.Proxy, dumpOut)
+	if err != nil {
+		return err
+	}
+	result, err := validateBuild(api, flags.Build)
+	if err != nil {
+		return err
+	}
+	if flags.Format == jsonOutputFormat {
+		encoder := json.NewEncoder(c.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		writeBuildValidation(c.OutOrStdout(), result)
+	}
+	if !result.Valid() {
+		return errors.New("invalid build")
+	}
+	return nil
+}
+
 // Returns empty string if there was no host.
 func promptSingleHostNameSelection(c *command, service client.Service) (string, error) {
 	sel, err := promptHostNameSelection(c, service, Single)
@@ -869,7 +3134,66 @@ func promptHostNameSelection(c *command, service client.Service, selOpt Selectio
 
 // Starts a connection agent process and waits for it to report the connection was
 // successfully created or an error occurred.
-func ConnectDevice(host, device, ice_config, agent string, c *command, opts *subCommandOpts) (*ConnStatus, error) {
+func ConnectDevice(host, device, ice_config, agent string, connOpts ConnOpts, c *command, opts *subCommandOpts) (*ConnStatus, error) {
+	flags := &ConnectFlags{
+		CVDRemoteFlags: opts.RootFlags,
+		host:           host,
+		ice_config:     ice_config,
+		clipboardSync:  connOpts.ClipboardSync,
+		recording:      connOpts.Recording,
+		heartbeat:      connOpts.Heartbeat,
+		idleTimeout:    connOpts.IdleTimeout,
+		jumpHosts:      connOpts.JumpHosts,
+		network:        connOpts.Network,
+		healthProbe:    connOpts.HealthProbe,
+		adbConnect:     connOpts.ADBConnect,
+	}
+	output, err := startAgent(buildAgentCmdArgs(flags, device, agent), c, opts)
+	if err != nil {
+		return nil, err
+	}
+	var status ConnStatus
+	if err := json.Unmarshal(output, &status); err != nil {
+		return nil, fmt.Errorf("failed to decode agent output(%s): %w", string(output), err)
+	}
+	if p := status.HealthProbe; p != nil && !p.Passed {
+		return nil, fmt.Errorf("health probe %q failed after %d attempts in %v: %s", p.Probe, p.Attempts, p.Duration.Round(time.Second), p.Error)
+	}
+	if a := status.ADBConnect; a != nil && !a.Connected {
+		c.PrintErrf("Warning: ADB failed to connect to device %q after %d attempts: %s\n", device, a.Attempts, a.Error)
+	}
+
+	return &status, nil
+}
+
+// Like ConnectDevice, but all devices are connected by a single shared agent. Returns the
+// statuses by device id, devices that failed to connect are missing.
+func ConnectHostDevices(host string, devices []string, ice_config, agent string, connOpts ConnOpts, c *command, opts *subCommandOpts) (map[string]ConnStatus, error) {
+	flags := &ConnectFlags{
+		CVDRemoteFlags: opts.RootFlags,
+		host:           host,
+		ice_config:     ice_config,
+		clipboardSync:  connOpts.ClipboardSync,
+		heartbeat:      connOpts.Heartbeat,
+		idleTimeout:    connOpts.IdleTimeout,
+		network:        connOpts.Network,
+		adbConnect:     connOpts.ADBConnect,
+	}
+	cmdArgs := append([]string{agent}, devices...)
+	output, err := startAgent(append(cmdArgs, flags.AsArgs()...), c, opts)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]ConnStatus)
+	if err := json.Unmarshal(output, &statuses); err != nil {
+		return nil, fmt.Errorf("failed to decode agent output(%s): %w", string(output), err)
+	}
+	return statuses, nil
+}
+
+// Starts the connection agent in the background, returns the output it wrote before moving to the
+// background.
+func startAgent(cmdArgs []string, c *command, opts *subCommandOpts) ([]byte, error) {
 	// Clean old logs files as we are about to create new ones.
 	go func() {
 		minAge := opts.InitialConfig.LogFilesDeleteThreshold()
@@ -881,13 +3205,6 @@ func ConnectDevice(host, device, ice_config, agent string, c *command, opts *sub
 		}
 	}()
 
-	flags := &ConnectFlags{
-		CVDRemoteFlags: opts.RootFlags,
-		host:           host,
-		ice_config:     ice_config,
-	}
-	cmdArgs := buildAgentCmdArgs(flags, device, agent)
-
 	output, err := opts.CommandRunner.StartBgCommand(cmdArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to start connection agent: %w", err)
@@ -900,22 +3217,93 @@ func ConnectDevice(host, device, ice_config, agent string, c *command, opts *sub
 		// could write messages and warnings there without failing.
 		return nil, fmt.Errorf("no response from agent")
 	}
-
-	var status ConnStatus
-	if err := json.Unmarshal(output, &status); err != nil {
-		return nil, fmt.Errorf("failed to decode agent output(%s): %w", string(output), err)
-	}
-
-	return &status, nil
+	return output, nil
 }
 
 func runConnectCommand(flags *ConnectFlags, c *command, args []string, opts *subCommandOpts) error {
 	if _, err := verifyICEConfigFlag(flags.ice_config); err != nil {
 		return err
 	}
+	if err := flags.clipboardSync.Validate(); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", clipboardDirectionFlag, err)
+	}
+	if !flags.recording.Enabled() && flags.recording.Duration != 0 {
+		return fmt.Errorf("--%s requires --%s or --%s", recordDurationFlag, recordFlag, recordSessionFlag)
+	}
+	if flags.recording.Enabled() {
+		if len(args) > 1 {
+			return fmt.Errorf("recording is only supported when connecting to a single device")
+		}
+		if flags.recording.SessionPath != "" && flags.connectAgent != ConnectionWebRTCAgentCommandName {
+			return fmt.Errorf("--%s requires --connect_agent=%s", recordSessionFlag, ConnectionWebRTCAgentCommandName)
+		}
+		// The connection agent may run from a different directory.
+		for name, path := range map[string]*string{recordFlag: &flags.recording.Path, recordSessionFlag: &flags.recording.SessionPath} {
+			if *path == "" {
+				continue
+			}
+			if name == recordFlag {
+				if err := validateRecordingPath(*path); err != nil {
+					return fmt.Errorf("invalid --%s flag value: %w", name, err)
+				}
+			}
+			abs, err := filepath.Abs(*path)
+			if err != nil {
+				return fmt.Errorf("invalid --%s flag value: %w", name, err)
+			}
+			*path = abs
+		}
+	}
 	if len(args) > 0 && flags.host == "" {
 		return fmt.Errorf("missing host for devices: %v", args)
 	}
+	if flags.idleTimeout < 0 {
+		return fmt.Errorf("invalid --%s flag value: %s", idleTimeoutFlag, flags.idleTimeout)
+	}
+	if err := validateNetwork(flags.network); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", networkFlag, err)
+	}
+	if err := flags.healthProbe.Validate(); err != nil {
+		return err
+	}
+	if err := flags.adbConnect.Validate(); err != nil {
+		return err
+	}
+	if flags.healthProbe.Probe != "" {
+		if flags.connectAgent != ConnectionWebRTCAgentCommandName {
+			return fmt.Errorf("--%s requires --connect_agent=%s", healthProbeFlag, ConnectionWebRTCAgentCommandName)
+		}
+		if flags.sharedAgent {
+			return fmt.Errorf("--%s can't be used with --%s", healthProbeFlag, sharedAgentFlag)
+		}
+	}
+	if flags.connectAgent == ConnectionProxyAgentCommandName {
+		if flags.network == overlayNetwork && c.Flags().Changed(networkFlag) {
+			return fmt.Errorf("--%s=%s is only supported by --connect_agent=%s", networkFlag, overlayNetwork, ConnectionWebRTCAgentCommandName)
+		}
+		// The proxy agent always goes through the service, ignoring the configured preference.
+		flags.network = ""
+		// Heartbeats reconnect webrtc connections, the proxy agent doesn't support them.
+		flags.heartbeat = HeartbeatOpts{}
+		if flags.idleTimeout > 0 {
+			return fmt.Errorf("--%s is only supported by --connect_agent=%s", idleTimeoutFlag, ConnectionWebRTCAgentCommandName)
+		}
+		if err := validateJumpHosts(flags.jumpHosts); err != nil {
+			return err
+		}
+	} else if len(flags.jumpHosts) > 0 {
+		return fmt.Errorf("--%s requires --connect_agent=%s, webrtc connections don't go through SSH",
+			jumpHostFlag, ConnectionProxyAgentCommandName)
+	} else if c.Flags().Changed(adbProxyFlag) {
+		return fmt.Errorf("--%s requires --connect_agent=%s, webrtc connections reach the devices through the service",
+			adbProxyFlag, ConnectionProxyAgentCommandName)
+	} else if flags.compress {
+		return fmt.Errorf("--%s requires --connect_agent=%s, webrtc connections are forwarded by the devices as is",
+			compressFlag, ConnectionProxyAgentCommandName)
+	} else {
+		// Only the proxy agent dials the devices' ADB port.
+		flags.adbProxy = ""
+	}
 	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c.Command)
 	if err != nil {
 		return err
@@ -932,7 +3320,7 @@ func runConnectCommand(flags *ConnectFlags, c *command, args []string, opts *sub
 	if len(cvds) == 0 {
 		var hosts []*RemoteHost
 		if flags.host == "" {
-			hosts, err = listCVDs(service, opts.InitialConfig.ConnectionControlDirExpanded())
+			hosts, err = listCVDs(service, opts.InitialConfig.ConnectionControlDirExpanded(), c.ErrOrStderr())
 		} else {
 			hosts, err = listCVDsSingleHost(
 				service, opts.InitialConfig.ConnectionControlDirExpanded(), flags.host)
@@ -959,6 +3347,22 @@ func runConnectCommand(flags *ConnectFlags, c *command, args []string, opts *sub
 			cvds[idx] = e.RemoteCVDLocator
 		}
 	}
+	if flags.recording.Enabled() && len(cvds) > 1 {
+		return fmt.Errorf("recording is only supported when connecting to a single device")
+	}
+	connOpts := ConnOpts{
+		ClipboardSync: flags.clipboardSync,
+		Recording:     flags.recording,
+		Heartbeat:     flags.heartbeat,
+		IdleTimeout:   flags.idleTimeout,
+		JumpHosts:     flags.jumpHosts,
+		Network:       flags.network,
+		HealthProbe:   flags.healthProbe,
+		ADBConnect:    flags.adbConnect,
+	}
+	if flags.sharedAgent {
+		return connectSharedAgent(c, cvds, flags, connOpts, opts)
+	}
 
 	var merr error
 	connChs := make([]chan ConnStatus, len(cvds))
@@ -973,7 +3377,7 @@ func runConnectCommand(flags *ConnectFlags, c *command, args []string, opts *sub
 		go func(connCh chan ConnStatus, errCh chan error, cvd RemoteCVDLocator) {
 			defer close(connCh)
 			defer close(errCh)
-			status, err := ConnectDevice(cvd.Host, cvd.WebRTCDeviceID, flags.ice_config, flags.connectAgent, c, opts)
+			status, err := ConnectDevice(cvd.Host, cvd.WebRTCDeviceID, flags.ice_config, flags.connectAgent, connOpts, c, opts)
 			if err != nil {
 				errCh <- fmt.Errorf("failed to connect to %q on %q: %w", cvd.WebRTCDeviceID, cvd.Host, err)
 			} else {
@@ -986,6 +3390,11 @@ func runConnectCommand(flags *ConnectFlags, c *command, args []string, opts *sub
 		select {
 		case status := <-connChs[i]:
 			printConnection(c, cvd, status)
+			if flags.recording.Enabled() {
+				if err := waitForRecording(c, opts.InitialConfig.ConnectionControlDirExpanded(), cvd, status, flags.recording); err != nil {
+					merr = multierror.Append(merr, err)
+				}
+			}
 		case err := <-errChs[i]:
 			merr = multierror.Append(merr, err)
 		}
@@ -993,6 +3402,78 @@ func runConnectCommand(flags *ConnectFlags, c *command, args []string, opts *sub
 	return merr
 }
 
+// Connects to the devices of each host through a single agent, hosts are connected in parallel.
+func connectSharedAgent(c *command, cvds []RemoteCVDLocator, flags *ConnectFlags, connOpts ConnOpts, opts *subCommandOpts) error {
+	hosts := []string{}
+	devices := make(map[string][]string)
+	for _, cvd := range cvds {
+		if _, ok := devices[cvd.Host]; !ok {
+			hosts = append(hosts, cvd.Host)
+		}
+		devices[cvd.Host] = append(devices[cvd.Host], cvd.WebRTCDeviceID)
+	}
+	type hostResult struct {
+		statuses map[string]ConnStatus
+		err      error
+	}
+	results := make([]hostResult, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			statuses, err := ConnectHostDevices(host, devices[host], flags.ice_config, flags.connectAgent, connOpts, c, opts)
+			results[i] = hostResult{statuses, err}
+		}(i, host)
+	}
+	wg.Wait()
+	var merr error
+	byHost := make(map[string]hostResult)
+	for i, host := range hosts {
+		if err := results[i].err; err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to connect to devices on %q: %w", host, err))
+		}
+		byHost[host] = results[i]
+	}
+	for _, cvd := range cvds {
+		r := byHost[cvd.Host]
+		if r.err != nil {
+			continue
+		}
+		status, ok := r.statuses[cvd.WebRTCDeviceID]
+		if !ok {
+			merr = multierror.Append(merr, fmt.Errorf("failed to connect to %q on %q", cvd.WebRTCDeviceID, cvd.Host))
+			continue
+		}
+		printConnection(c, cvd, status)
+	}
+	return merr
+}
+
+// Blocks until interrupted or the recording duration elapses, then stops the recording leaving the
+// connection in place.
+func waitForRecording(c *command, controlDir string, cvd RemoteCVDLocator, status ConnStatus, recOpts RecordingOpts) error {
+	if status.Recording == "" {
+		return fmt.Errorf("not recording %s/%s: an existing connection can't start recording, disconnect first",
+			cvd.Host, cvd.WebRTCDeviceID)
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	var timeoutCh <-chan time.Time
+	if recOpts.Duration > 0 {
+		timeoutCh = time.After(recOpts.Duration)
+		c.PrintErrf("Recording to %s for %v, press Ctrl-C to stop earlier\n", status.Recording, recOpts.Duration)
+	} else {
+		c.PrintErrf("Recording to %s, press Ctrl-C to stop\n", status.Recording)
+	}
+	select {
+	case <-sigCh:
+	case <-timeoutCh:
+	}
+	return StopRecording(controlDir, cvd, status)
+}
+
 func verifyICEConfigFlag(v string) (*wclient.ICEConfig, error) {
 	if v == "" {
 		return nil, nil
@@ -1009,6 +3490,27 @@ func printConnection(c *command, cvd RemoteCVDLocator, status ConnStatus) {
 	if status.ADB.Port > 0 {
 		state = fmt.Sprintf("127.0.0.1:%d", status.ADB.Port)
 	}
+	if status.ClipboardSync {
+		state += " (clipboard sync)"
+	}
+	if status.Recording != "" {
+		state += " (recording)"
+	}
+	if len(status.JumpPath) > 0 {
+		state += " (via " + strings.Join(status.JumpPath, ", ") + ")"
+	}
+	if status.Proxy != "" {
+		state += " (proxy " + status.Proxy + ")"
+	}
+	if status.Compression != "" {
+		state += " (compressed)"
+	}
+	if status.HealthProbe != nil && status.HealthProbe.Passed {
+		state += " (" + status.HealthProbe.Probe + " probe passed)"
+	}
+	if a := status.ADBConnect; a != nil && !a.Connected {
+		state += " (ADB not connected)"
+	}
 	c.Printf("%s/%s: %s\n", cvd.Host, cvd.WebRTCDeviceID, state)
 }
 
@@ -1018,28 +3520,43 @@ func buildAgentCmdArgs(flags *ConnectFlags, device string, connectAgent string)
 }
 
 // Letting the process be a proxy server for establishing the connection.
-func forwardProxy(socketPath, proxyAddr, adbAddr string, adbServerProxy ADBServerProxy) error {
-	// Make connection towards [host_ip_address]:[cuttlefish_instance_adb_port]
-	var dialer proxy.Dialer
-	if proxyAddr != "" {
-		proxyUrl, err := url.Parse(proxyAddr)
-		if err != nil {
-			return fmt.Errorf("failed to parse proxy URL: %w", err)
-		}
-		if proxyUrl.Scheme != "socks5" {
-			return fmt.Errorf("scheme of proxy URL is not socks5. actual: %s", proxyUrl.Scheme)
+// Returns the dialer reaching the host through the socks5 proxy or the jump hosts, if any.
+// The credentials in `authn` are sent to http proxies, unless the proxy URL has its own.
+func proxyDialer(proxyAddr string, authn *ProxyBasicAuthnConfig, jumpHosts []string) (proxy.Dialer, error) {
+	if len(jumpHosts) > 0 {
+		if proxyAddr != "" {
+			return nil, fmt.Errorf("a proxy can't be used with jump hosts")
 		}
-		dialer, err = proxy.SOCKS5("tcp", proxyUrl.Host, nil, nil)
-		if err != nil {
-			return fmt.Errorf("failed to create proxy dialer: %w", err)
+		return &sshJumpDialer{JumpHosts: jumpHosts}, nil
+	}
+	if proxyAddr == "" {
+		return proxy.Direct, nil
+	}
+	proxyUrl, err := url.Parse(proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+	}
+	switch proxyUrl.Scheme {
+	case "socks5":
+	case "http", "https":
+		user := proxyUrl.User
+		if user == nil {
+			if user, err = authn.userinfo(); err != nil {
+				return nil, err
+			}
 		}
-	} else {
-		dialer = proxy.Direct
+		return &httpConnectDialer{ProxyURL: proxyUrl, User: user}, nil
+	default:
+		return nil, fmt.Errorf("scheme of proxy URL is not socks5, http or https. actual: %s", proxyUrl.Scheme)
 	}
-	remoteConn, err := dialer.Dial("tcp", adbAddr)
+	dialer, err := proxy.SOCKS5("tcp", proxyUrl.Host, nil, nil)
 	if err != nil {
-		return fmt.Errorf("failed to dial remote port: %w", err)
+		return nil, fmt.Errorf("failed to create proxy dialer: %w", err)
 	}
+	return dialer, nil
+}
+
+func forwardProxy(socketPath string, remoteConn net.Conn, adbServerProxy ADBServerProxy) error {
 	defer remoteConn.Close()
 
 	// Create a file socket to establish ADB connection
@@ -1145,8 +3662,68 @@ func runConnectionProxyAgentCommand(flags *ConnectFlags, c *command, args []stri
 	}
 	adbAddress := net.JoinHostPort(host.Docker.IPAddress, port)
 	socketPath := GetProxySocketPath(controlDir, flags.host, device)
+	jumpHosts := flags.jumpHosts
+	if len(jumpHosts) == 0 && opts.InitialConfig.SSH != nil {
+		jumpHosts = opts.InitialConfig.SSH.JumpHosts
+	}
+	if err := validateJumpHosts(jumpHosts); err != nil {
+		return err
+	}
+	proxyAddr := flags.adbProxy
+	if proxyAddr == "" {
+		proxyAddr = flags.Proxy
+	}
+	var proxyAuthn *ProxyBasicAuthnConfig
+	if cfg := opts.InitialConfig.DefaultService().ADBProxy; cfg != nil {
+		proxyAuthn = cfg.BasicAuthn
+	}
+	dialer, err := proxyDialer(proxyAddr, proxyAuthn, jumpHosts)
+	if err != nil {
+		return err
+	}
+	// Make connection towards [host_ip_address]:[cuttlefish_instance_adb_port]
+	remoteConn, err := dialer.Dial("tcp", adbAddress)
+	if err != nil {
+		return fmt.Errorf("failed to dial remote port: %w", err)
+	}
+	var compressed *compressedConn
+	if flags.compress {
+		if !contains(host.Features, apiv1.HostFeatureADBCompression) {
+			c.PrintErrf("Warning: host %q doesn't support compressed connections, connecting uncompressed\n", flags.host)
+		} else if compressed, err = negotiateCompression(remoteConn); err != nil {
+			c.PrintErrf("Warning: %v, connecting uncompressed\n", err)
+			// The host may have read the preamble as ADB data.
+			remoteConn.Close()
+			if remoteConn, err = dialer.Dial("tcp", adbAddress); err != nil {
+				return fmt.Errorf("failed to dial remote port: %w", err)
+			}
+		} else {
+			remoteConn = compressed
+		}
+	}
+	status := ConnStatus{ADB: ForwarderState{State: StateAsStr(FwdConnected)}, JumpPath: jumpHosts}
+	if compressed != nil {
+		status.Compression = deflateCompression
+	}
+	if u, err := url.Parse(proxyAddr); err == nil && proxyAddr != "" {
+		status.Proxy = u.Redacted()
+	}
+	if output, err := json.Marshal(status); err != nil {
+		c.PrintErrf("Failed to encode connection status: %v\n", err)
+	} else {
+		c.Println(string(output))
+	}
+	// Signal the caller the connection is established, errors are still written to stderr.
+	if cout, ok := c.OutOrStdout().(io.Closer); ok {
+		cout.Close()
+	}
 
-	return forwardProxy(socketPath, flags.Proxy, adbAddress, opts.ADBServerProxy)
+	err = forwardProxy(socketPath, remoteConn, opts.ADBServerProxy)
+	if compressed != nil {
+		raw, wire := compressed.Counts()
+		c.PrintErrf("Compressed %d bytes into %d, a %.1fx ratio\n", raw, wire, compressionRatio(raw, wire))
+	}
+	return err
 }
 
 // Handler for the webrtc agent command. This is not meant to be called by the
@@ -1162,9 +3739,6 @@ func runConnectionWebrtcAgentCommand(flags *ConnectFlags, c *command, args []str
 	if err != nil {
 		return err
 	}
-	if len(args) > 1 {
-		return fmt.Errorf("connection agent only supports a single device, received: %v", args)
-	}
 	if len(args) == 0 {
 		return fmt.Errorf("missing device")
 	}
@@ -1173,6 +3747,15 @@ func runConnectionWebrtcAgentCommand(flags *ConnectFlags, c *command, args []str
 	if err != nil {
 		return err
 	}
+	if flags.network == overlayNetwork {
+		service = newOverlayService(service, opts.InitialConfig.Network, c.PrintErrf)
+	}
+	if len(args) > 1 {
+		if flags.recording.Enabled() {
+			return fmt.Errorf("recording is only supported when connecting to a single device")
+		}
+		return runSharedAgent(flags, c, args, service, localICEConfig, opts)
+	}
 
 	devSpec := RemoteCVDLocator{
 		ServiceRootEndpoint: service.RootURI(),
@@ -1181,7 +3764,8 @@ func runConnectionWebrtcAgentCommand(flags *ConnectFlags, c *command, args []str
 	}
 
 	controlDir := opts.InitialConfig.ConnectionControlDirExpanded()
-	ret, err := FindOrConnect(controlDir, devSpec, service, localICEConfig)
+	connOpts := ConnOpts{ClipboardSync: flags.clipboardSync, Recording: flags.recording, Heartbeat: flags.heartbeat, IdleTimeout: flags.idleTimeout}
+	ret, err := FindOrConnect(controlDir, devSpec, service, localICEConfig, connOpts)
 	if err != nil {
 		return err
 	}
@@ -1190,6 +3774,29 @@ func runConnectionWebrtcAgentCommand(flags *ConnectFlags, c *command, args []str
 		c.PrintErrln(ret.Error)
 	}
 
+	// Ask ADB server to connect even if the connection to the device already exists.
+	adbConnect := connectADB(opts.ADBServerProxy, ret.Status.ADB.Port, flags.adbConnect, time.Sleep)
+	if !adbConnect.Connected {
+		c.PrintErrf("Failed to connect ADB to device %q after %d attempts: %s\n", device, adbConnect.Attempts, adbConnect.Error)
+	}
+	if ret.Controller != nil {
+		ret.Controller.adbConnect = adbConnect
+		ret.Status = ret.Controller.Status()
+	} else {
+		ret.Status.ADBConnect = adbConnect
+	}
+
+	// Existing connections were probed by the agent that created them.
+	probeFailed := false
+	if ret.Controller != nil && flags.healthProbe.Probe != "" {
+		// Validated by the connect command.
+		probe, _ := parseHealthProbe(flags.healthProbe.Probe)
+		serial := fmt.Sprintf("127.0.0.1:%d", ret.Status.ADB.Port)
+		ret.Controller.healthProbe = runHealthProbe(flags.healthProbe, probe, serial, time.Now, time.Sleep)
+		ret.Status = ret.Controller.Status()
+		probeFailed = !ret.Controller.healthProbe.Passed
+	}
+
 	// The agent's only output is the port
 	output, err := json.Marshal(ret.Status)
 	if err != nil {
@@ -1198,15 +3805,18 @@ func runConnectionWebrtcAgentCommand(flags *ConnectFlags, c *command, args []str
 		c.Println(string(output))
 	}
 
-	// Ask ADB server to connect even if the connection to the device already exists.
-	if err := opts.ADBServerProxy.Connect(ret.Status.ADB.Port); err != nil {
-		c.PrintErrf("Failed to connect ADB to device %q: %v\n", device, err)
-	}
-
 	if ret.Controller == nil {
 		// A connection already exists, this process is done.
 		return nil
 	}
+	if probeFailed {
+		// The caller reports the failure, an unusable connection isn't kept.
+		ret.Controller.Stop()
+		if err := opts.ADBServerProxy.Disconnect(ret.Status.ADB.Port); err != nil {
+			c.PrintErrf("Failed to disconnect ADB: %v\n", err)
+		}
+		return nil
+	}
 
 	// Signal the caller that the agent is moving to the background by closing
 	// the command's standard IO channels.
@@ -1232,6 +3842,144 @@ func runConnectionWebrtcAgentCommand(flags *ConnectFlags, c *command, args []str
 	return nil
 }
 
+// Connects to several devices of the same host, the output is the status of each connected device by
+// device id.
+func runSharedAgent(flags *ConnectFlags, c *command, devices []string, service client.Service, localICEConfig *wclient.ICEConfig, opts *subCommandOpts) error {
+	controlDir := opts.InitialConfig.ConnectionControlDirExpanded()
+	connOpts := ConnOpts{ClipboardSync: flags.clipboardSync, Heartbeat: flags.heartbeat, IdleTimeout: flags.idleTimeout}
+	existing, err := listCVDConnectionsByHost(controlDir, flags.host)
+	if err != nil {
+		// Some connections may have been listed, the others will be created again.
+		c.PrintErrln(err)
+	}
+	result := make(map[string]ConnStatus)
+	missing := []RemoteCVDLocator{}
+	for _, d := range devices {
+		cvd := RemoteCVDLocator{ServiceRootEndpoint: service.RootURI(), Host: flags.host, WebRTCDeviceID: d}
+		if s, ok := existing[cvd]; ok {
+			result[d] = s
+		} else {
+			missing = append(missing, cvd)
+		}
+	}
+	var mux *MuxConnController
+	if len(missing) > 0 {
+		mux, err = NewMuxConnController(controlDir, service, missing, localICEConfig, connOpts)
+		if err != nil {
+			c.PrintErrln(err)
+		}
+		if mux != nil {
+			for _, r := range mux.Statuses() {
+				result[r.CVD.WebRTCDeviceID] = r.Status
+			}
+		}
+	}
+	if len(result) == 0 {
+		// No output tells the caller no connection was established.
+		return nil
+	}
+	// Ask ADB server to connect even if the connections to the devices already exist.
+	for d, s := range result {
+		s.ADBConnect = connectADB(opts.ADBServerProxy, s.ADB.Port, flags.adbConnect, time.Sleep)
+		if !s.ADBConnect.Connected {
+			c.PrintErrf("Failed to connect ADB to device %q after %d attempts: %s\n", d, s.ADBConnect.Attempts, s.ADBConnect.Error)
+		}
+		if mux != nil {
+			// Devices connected by other agents aren't controlled by this one.
+			if tc, err := mux.controller(d); err == nil {
+				tc.adbConnect = s.ADBConnect
+			}
+		}
+		result[d] = s
+	}
+	output, err := json.Marshal(result)
+	if err != nil {
+		c.PrintErrf("Failed to encode connection statuses: %v\n", err)
+	} else {
+		c.Println(string(output))
+	}
+	if mux == nil {
+		// All connections already exist, this process is done.
+		return nil
+	}
+	// Signal the caller that the agent is moving to the background by closing
+	// the command's standard IO channels.
+	if cin, ok := c.InOrStdin().(io.Closer); ok {
+		cin.Close()
+	}
+	if cout, ok := c.OutOrStdout().(io.Closer); ok {
+		cout.Close()
+	}
+	if cerr, ok := c.ErrOrStderr().(io.Closer); ok {
+		cerr.Close()
+	}
+	mux.OnDeviceStopped = func(tc *ConnController) {
+		if err := opts.ADBServerProxy.Disconnect(tc.ADBPort()); err != nil {
+			tc.logger.Printf("Failed to disconnect ADB: %v\n", err)
+		}
+	}
+	mux.Run()
+	return nil
+}
+
+func runExportCommand(c *command, outPath string, opts *subCommandOpts) error {
+	statuses, err := listCVDConnections(opts.InitialConfig.ConnectionControlDirExpanded())
+	if err != nil {
+		// Export the connections that could be listed.
+		c.PrintErrf("Warning: some connections can't be exported: %v\n", err)
+	}
+	state := newConnState(statuses)
+	if outPath == "" {
+		return writeConnState(c.OutOrStdout(), state)
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %q: %w", outPath, err)
+	}
+	if err := writeConnState(f, state); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %q: %w", outPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %q: %w", outPath, err)
+	}
+	c.PrintErrf("Exported %d connection(s) to %s\n", len(state.Connections), outPath)
+	return nil
+}
+
+func runImportCommand(flags *ConnectFlags, c *command, args []string, opts *subCommandOpts) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single connection state file, received: %v", args)
+	}
+	state, err := readConnState(args[0])
+	if err != nil {
+		return err
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c.Command)
+	if err != nil {
+		return err
+	}
+	valid, stale := validateConnState(service, state)
+	for _, s := range stale {
+		c.PrintErrf("Stale reference %s\n", s)
+	}
+	var merr error
+	for _, conn := range valid {
+		connOpts := ConnOpts{
+			ClipboardSync: ClipboardSyncOpts{Enabled: conn.ClipboardSync, Direction: BothClipboardDirections},
+			Heartbeat:     flags.heartbeat,
+		}
+		// Devices already connected keep their existing connection.
+		status, err := ConnectDevice(conn.CVD.Host, conn.CVD.WebRTCDeviceID, flags.ice_config, flags.connectAgent, connOpts, c, opts)
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to connect to %q on %q: %w", conn.CVD.WebRTCDeviceID, conn.CVD.Host, err))
+			continue
+		}
+		printConnection(c, conn.CVD, *status)
+	}
+	return merr
+}
+
 func runDisconnectCommand(flags *ConnectFlags, c *command, args []string, opts *subCommandOpts) error {
 	if len(args) > 0 && flags.host == "" {
 		return fmt.Errorf("missing host for devices: %v", args)
@@ -1337,8 +4085,17 @@ type serviceBuilder func(flags *CVDRemoteFlags, c *cobra.Command) (client.Servic
 
 const chunkSizeBytes = 16 * 1024 * 1024
 
-func buildServiceBuilder(builder client.ServiceBuilder, authnConfig *AuthnConfig) serviceBuilder {
+func buildServiceBuilder(builder client.ServiceBuilder, profile *Service, config *Config, correlationID string) serviceBuilder {
+	authnConfig := profile.Authn
 	return func(flags *CVDRemoteFlags, c *cobra.Command) (client.Service, error) {
+		if err := validateServiceURL(flags.ServiceURL); err != nil {
+			return nil, fmt.Errorf("invalid service url: %w", err)
+		}
+		if profile.SignalingPath != "" {
+			if err := client.ValidateSignalingPath(profile.SignalingPath); err != nil {
+				return nil, fmt.Errorf("invalid configuration: %w", err)
+			}
+		}
 		proxyURL := flags.Proxy
 		var dumpOut io.Writer = io.Discard
 		if flags.Verbose {
@@ -1350,6 +4107,8 @@ func buildServiceBuilder(builder client.ServiceBuilder, authnConfig *AuthnConfig
 			DumpOut:        dumpOut,
 			ErrOut:         c.ErrOrStderr(),
 			ChunkSizeBytes: chunkSizeBytes,
+			CorrelationID:  correlationID,
+			SignalingPath:  profile.SignalingPath,
 		}
 		if authnConfig != nil {
 			if authnConfig.OIDCToken != nil && authnConfig.HTTPBasicAuthn != nil {
@@ -1357,11 +4116,10 @@ func buildServiceBuilder(builder client.ServiceBuilder, authnConfig *AuthnConfig
 			}
 			opts.Authn = &client.AuthnOpts{}
 			if authnConfig.OIDCToken != nil {
-				content, err := os.ReadFile(authnConfig.OIDCToken.TokenFile)
+				value, err := loadOIDCToken(authnConfig.OIDCToken.TokenFile, config, c)
 				if err != nil {
-					return nil, fmt.Errorf("failed loading oidc token: %w", err)
+					return nil, err
 				}
-				value := strings.TrimSuffix(string(content), "\n")
 				opts.Authn.OIDCToken = &client.OIDCToken{
 					Value: value,
 				}
@@ -1384,6 +4142,95 @@ func buildServiceBuilder(builder client.ServiceBuilder, authnConfig *AuthnConfig
 	}
 }
 
+func loadOIDCToken(tokenFile string, config *Config, c *cobra.Command) (string, error) {
+	store, err := openCredentialStore(config, c)
+	if err != nil {
+		return "", err
+	}
+	if store == nil {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed loading oidc token: %w", err)
+		}
+		return strings.TrimSuffix(string(content), "\n"), nil
+	}
+	return loadOIDCTokenFromStore(store, tokenFile)
+}
+
+// Builds a client to the Build API for the calls cvdr makes itself, authorized with the
+// credentials of the source. The injected credentials are only available to the hosts.
+func newBuildAPI(c *cobra.Command, opts *subCommandOpts, rootEndpoint, credentialsSource, proxyURL string, dumpOut io.Writer) (client.BuildAPI, error) {
+	if credentialsSource == InjectedCredentialsSource {
+		return nil, fmt.Errorf("the %q credentials source only authorizes the Build API calls of the hosts,"+
+			" use %q for the lookups cvdr makes", InjectedCredentialsSource, ADCCredentialsSource)
+	}
+	store, err := openCredentialStore(&opts.InitialConfig, c)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := credentialsFactoryFromSource(credentialsSource, store)
+	if err != nil {
+		return nil, err
+	}
+	api, err := opts.BuildAPIBuilder(rootEndpoint, proxyURL, client.BuildAPICredentials(cf), dumpOut)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the build api client: %w", err)
+	}
+	return api, nil
+}
+
+// Returns nil if no credential store is configured.
+func openCredentialStore(config *Config, c *cobra.Command) (CredentialStore, error) {
+	if config.CredentialStore == nil {
+		return nil, nil
+	}
+	store, err := NewCredentialStore(config.CredentialStore, config.CredentialStoreFilePath(), credentialStorePassphrase(c))
+	if err != nil {
+		return nil, fmt.Errorf("failed opening credential store: %w", err)
+	}
+	if _, ok := store.(*encryptedFileCredentialStore); ok && config.CredentialStore.Backend != FileCredentialStoreBackend {
+		c.PrintErrf("Warning: the os keyring isn't available, credentials are kept in the encrypted file %s."+
+			" Set the %q backend to silence this\n", config.CredentialStoreFilePath(), FileCredentialStoreBackend)
+	}
+	return store, nil
+}
+
+const envVarCredentialStorePassphrase = "CVDR_CREDENTIAL_STORE_PASSPHRASE"
+
+// Reads the passphrase from the environment, prompting for it if running on a terminal.
+func credentialStorePassphrase(c *cobra.Command) PassphraseSource {
+	return func() (string, error) {
+		if v, ok := os.LookupEnv(envVarCredentialStorePassphrase); ok {
+			return v, nil
+		}
+		in, ok := c.InOrStdin().(*os.File)
+		if !ok || !term.IsTerminal(int(in.Fd())) {
+			return "", fmt.Errorf("not running on a terminal, set the passphrase with %s", envVarCredentialStorePassphrase)
+		}
+		c.PrintErr("Credential store passphrase: ")
+		b, err := term.ReadPassword(int(in.Fd()))
+		c.PrintErrln()
+		if err != nil {
+			return "", fmt.Errorf("failed reading passphrase: %w", err)
+		}
+		return string(b), nil
+	}
+}
+
+func validateServiceURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q, expected http or https", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", v)
+	}
+	return nil
+}
+
 func buildServiceRootEndpoint(serviceURL, zone string) string {
 	const version = "v1"
 	return client.BuildRootEndpoint(serviceURL, version, zone)
@@ -1398,21 +4245,44 @@ type statePrinter struct {
 
 	// If true, visual features like colors and animations won't be displayed.
 	visualsOn bool
+	// Number of detail lines printed below the last progress message.
+	progressLines int
+	// One of `progressModes`.
+	mode string
+	// When the progress was last printed in plain mode, zero if not printed for the current step.
+	lastPlainProgress time.Time
 }
 
 func newStatePrinter(out io.Writer, verbose bool) *statePrinter {
+	return newStatePrinterWithMode(out, verbose, progressBar)
+}
+
+// Visuals are only displayed in bar mode.
+func newStatePrinterWithMode(out io.Writer, verbose bool, mode string) *statePrinter {
 	visualsOn := false
-	if f, ok := out.(*os.File); ok && term.IsTerminal(int(f.Fd())) && !verbose {
+	if f, ok := out.(*os.File); ok && term.IsTerminal(int(f.Fd())) && !verbose && mode == progressBar {
 		visualsOn = true
 	}
-	return &statePrinter{Out: out, visualsOn: visualsOn}
+	return &statePrinter{Out: out, visualsOn: visualsOn, mode: mode}
 }
 
 func (p *statePrinter) Print(msg string) {
+	if p.mode == progressJSON {
+		p.printJSON(progressEvent{Event: progressEventStarted, Message: msg})
+		return
+	}
 	p.print(msg, statePrinterState{Done: false})
 }
 
 func (p *statePrinter) PrintDone(msg string, err error) {
+	if p.mode == progressJSON {
+		e := progressEvent{Event: progressEventDone, Message: msg}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		p.printJSON(e)
+		return
+	}
 	p.print(msg, statePrinterState{Done: true, DoneErr: err})
 }
 
@@ -1422,11 +4292,49 @@ type statePrinterState struct {
 	DoneErr error
 }
 
+// Prints the message, with the progress in place of the done status, followed by a line per
+// detail. It replaces the previously printed state and it's replaced by the next, the progress is
+// only printed with visuals on. In plain mode it's printed as a single line instead, every
+// `plainProgressInterval`.
+func (p *statePrinter) PrintProgress(msg, progress string, details []string) {
+	switch p.mode {
+	case progressJSON:
+		p.printJSON(progressEvent{Event: progressEventProgress, Message: strings.TrimSpace(msg + " " + progress)})
+		return
+	case progressPlain:
+		if progress != "" && time.Since(p.lastPlainProgress) >= plainProgressInterval {
+			p.lastPlainProgress = time.Now()
+			fmt.Fprintln(p.Out, toFixedLength(msg, 50, '.')+strings.Repeat(".", 3)+" "+progress)
+		}
+		return
+	}
+	if !p.visualsOn {
+		return
+	}
+	result := p.clearProgress() + "\r\033[K" + toFixedLength(msg, 50, '.') + strings.Repeat(".", 3) + " " + progress
+	for _, d := range details {
+		result += "\n\r\033[K" + d
+	}
+	p.progressLines = len(details)
+	fmt.Fprint(p.Out, result)
+}
+
+// Moves the cursor back to the line of the progress message, clearing the details below it.
+func (p *statePrinter) clearProgress() string {
+	if p.progressLines == 0 {
+		return ""
+	}
+	result := fmt.Sprintf("\033[%dA\r\033[J", p.progressLines)
+	p.progressLines = 0
+	return result
+}
+
 func (p *statePrinter) print(msg string, state statePrinterState) {
+	p.lastPlainProgress = time.Time{}
 	prefix := ""
 	if p.visualsOn {
 		// Use cursor movement characters for an interactive experience when visuals are on.
-		prefix = "\r\033[K"
+		prefix = p.clearProgress() + "\r\033[K"
 	}
 	result := prefix + toFixedLength(msg, 50, '.') + strings.Repeat(".", 3) + " "
 	if state.Done {
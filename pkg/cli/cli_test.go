@@ -17,12 +17,14 @@ package cli
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	"github.com/google/cloud-android-orchestration/pkg/client"
@@ -79,7 +81,7 @@ func (*fakeCommandRunner) StartBgCommand(...string) ([]byte, error) {
 
 type fakeADBServerProxy struct{}
 
-func (*fakeADBServerProxy) Connect(int) error {
+func (*fakeADBServerProxy) Connect(int, time.Duration) error {
 	return nil
 }
 
@@ -113,6 +115,50 @@ func (fakeService) DeleteHosts(name []string) error {
 
 const serviceURL = "http://waldo.com"
 
+func (fakeService) CreateShareLink(host, name string, ttl time.Duration) (string, error) {
+	return serviceURL + "/share/" + host + "/" + name, nil
+}
+
+func (fakeService) RevokeShareLinks(host, name string) error {
+	return nil
+}
+
+func (fakeService) FlashPartition(host, name, partition, imagePath string) error {
+	return nil
+}
+
+func (fakeService) ApplyOTA(host, name, otaBuildID string) error {
+	return nil
+}
+
+func (fakeService) ApplyOTAWithOptions(host, name, otaBuildID string, opts client.ApplyOTAOptions) error {
+	return nil
+}
+
+func (fakeService) GetDefaults() (*apiv1.BuildDefaults, error) {
+	return &apiv1.BuildDefaults{}, nil
+}
+
+func (fakeService) SetDefaults(defaults *apiv1.BuildDefaults) error {
+	return nil
+}
+
+func (fakeService) ListUserUploads() ([]*apiv1.UserUpload, error) {
+	return []*apiv1.UserUpload{}, nil
+}
+
+func (fakeService) GetServerInfo() (*apiv1.ServerInfoResponse, error) {
+	return &apiv1.ServerInfoResponse{Version: "1.0.0", APIVersion: 1, MinClientAPIVersion: 1}, nil
+}
+
+func (fakeService) CancelUserOperations() (*apiv1.CancelUserOperationsResponse, error) {
+	return &apiv1.CancelUserOperationsResponse{}, nil
+}
+
+func (fakeService) WhoAmI() (*apiv1.WhoAmIResponse, error) {
+	return &apiv1.WhoAmIResponse{Username: "johndoe"}, nil
+}
+
 func (fakeService) RootURI() string {
 	return serviceURL + "/v1"
 }
@@ -138,10 +184,38 @@ func (fakeHostService) FetchArtifacts(req *hoapi.FetchArtifactsRequest, creds st
 	return &hoapi.FetchArtifactsResponse{AndroidCIBundle: &hoapi.AndroidCIBundle{}}, nil
 }
 
+func (s fakeHostService) FetchArtifactsWithOptions(req *hoapi.FetchArtifactsRequest, creds string, opts client.FetchArtifactsOptions) (*hoapi.FetchArtifactsResponse, error) {
+	return s.FetchArtifacts(req, creds)
+}
+
+func (fakeHostService) FetchArtifactsOp(req *hoapi.FetchArtifactsRequest, creds string, opts client.FetchArtifactsOptions) (*hoapi.Operation, error) {
+	return &hoapi.Operation{Name: "op"}, nil
+}
+
+func (fakeHostService) GetFetchArtifactsProgress(name string) (*client.FetchArtifactsProgress, error) {
+	return &client.FetchArtifactsProgress{Done: true}, nil
+}
+
+func (fakeHostService) WaitForFetchArtifactsOp(name string) (*client.FetchArtifactsResult, error) {
+	return &client.FetchArtifactsResult{FetchArtifactsResponse: hoapi.FetchArtifactsResponse{AndroidCIBundle: &hoapi.AndroidCIBundle{}}}, nil
+}
+
 func (fakeHostService) CreateCVD(req *hoapi.CreateCVDRequest, creds string) (*hoapi.CreateCVDResponse, error) {
 	return &hoapi.CreateCVDResponse{CVDs: []*hoapi.CVD{{Name: "cvd-1"}}}, nil
 }
 
+func (fakeHostService) CreateCVDOp(req *hoapi.CreateCVDRequest, creds string) (*hoapi.Operation, error) {
+	return &hoapi.Operation{Name: "op"}, nil
+}
+
+func (s fakeHostService) CreateCVDOpWithOptions(req *hoapi.CreateCVDRequest, creds string, opts client.CreateCVDOptions) (*hoapi.Operation, error) {
+	return s.CreateCVDOp(req, creds)
+}
+
+func (fakeHostService) WaitForCreateCVDOp(name string) (*hoapi.CreateCVDResponse, error) {
+	return &hoapi.CreateCVDResponse{CVDs: []*hoapi.CVD{{Name: "cvd-1"}}}, nil
+}
+
 func (fakeHostService) DeleteCVD(id string) error {
 	return nil
 }
@@ -150,10 +224,26 @@ func (fakeHostService) ListCVDs() ([]*hoapi.CVD, error) {
 	return []*hoapi.CVD{{Name: "cvd-1"}}, nil
 }
 
+func (fakeHostService) ListCVDDetails() ([]*client.CVDDetails, error) {
+	return []*client.CVDDetails{{CVD: hoapi.CVD{Name: "cvd-1"}}}, nil
+}
+
+func (fakeHostService) ExtendCVD(id string, ttl time.Duration) (*client.CVDDetails, error) {
+	return &client.CVDDetails{CVD: hoapi.CVD{Name: id}, ExpireTime: "2024-05-02T22:00:00Z"}, nil
+}
+
 func (fakeHostService) CreateUploadDir() (string, error) {
 	return "", nil
 }
 
+func (fakeHostService) ListUploads() ([]*client.UploadDir, error) {
+	return []*client.UploadDir{}, nil
+}
+
+func (fakeHostService) DeleteUpload(dir string) error {
+	return nil
+}
+
 func (fakeHostService) UploadFile(uploadDir string, name string) error {
 	return nil
 }
@@ -170,6 +260,14 @@ func (fakeHostService) DownloadRuntimeArtifacts(dst io.Writer) error {
 
 func (fakeHostService) WaitForOperation(string, any) error { return nil }
 
+func (fakeHostService) GetCVDLogs(string, string) (io.ReadCloser, error) {
+	return nil, client.ErrLogNotFound
+}
+
+func (fakeHostService) GetOperationLogs(string) (io.ReadCloser, error) {
+	return nil, client.ErrOperationNotFound
+}
+
 func TestCommandSucceeds(t *testing.T) {
 	tests := []struct {
 		Name   string
@@ -212,6 +310,26 @@ func TestCommandSucceeds(t *testing.T) {
 			Args:   []string{"list", "--host=bar"},
 			ExpOut: expectedOutput(serviceURL, "bar", hoapi.CVD{Name: "cvd-1"}, 0),
 		},
+		{
+			Name:   "list with --output_fields",
+			Args:   []string{"list", "--output_fields=host,name,status"},
+			ExpOut: "HOST  NAME   STATUS\nfoo   cvd-1  -\nbar   cvd-1  -\n",
+		},
+		{
+			Name:   "share",
+			Args:   []string{"share", "--host=bar", "cvd-1"},
+			ExpOut: serviceURL + "/share/bar/cvd-1\n",
+		},
+		{
+			Name:   "extend",
+			Args:   []string{"extend", "--host=bar", "--ttl=1h", "cvd-1"},
+			ExpOut: "bar/cvd-1 expires at 2024-05-02T22:00:00Z\n",
+		},
+		{
+			Name:   "unshare",
+			Args:   []string{"unshare", "--host=bar", "cvd-1"},
+			ExpOut: "",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
@@ -240,6 +358,93 @@ func TestCommandSucceeds(t *testing.T) {
 	}
 }
 
+func TestServiceURLFlagOverridesConfiguredService(t *testing.T) {
+	errOut := &bytes.Buffer{}
+	io, _, _ := newTestIOStreams()
+	io.ErrOut = errOut
+	var rootEndpoint string
+	opts := &CommandOptions{
+		IOStreams: io,
+		Args:      []string{"host", "list", "--service_url=https://foo.com"},
+		InitialConfig: Config{
+			ConnectionControlDir: t.TempDir(),
+			SystemDefaultService: "bar",
+			Services:             map[string]*Service{"bar": {ServiceURL: "https://bar.com", Host: &HostConfig{}}},
+		},
+		ServiceBuilder: func(opts *client.ServiceOptions) (client.Service, error) {
+			rootEndpoint = opts.RootEndpoint
+			return &fakeService{}, nil
+		},
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+
+	err := NewCVDRemoteCommand(opts).Execute()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("https://foo.com/v1", rootEndpoint); diff != "" {
+		t.Errorf("root endpoint mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(errOut.String(), "instead of the configured service https://bar.com") {
+		t.Errorf("expected a warning about the configured service, got: %q", errOut.String())
+	}
+}
+
+func TestServiceURLFlagInvalid(t *testing.T) {
+	io, _, _ := newTestIOStreams()
+	opts := &CommandOptions{
+		IOStreams:      io,
+		Args:           []string{"host", "list", "--service_url=foo.com"},
+		InitialConfig:  Config{ConnectionControlDir: t.TempDir()},
+		ServiceBuilder: func(*client.ServiceOptions) (client.Service, error) { return &fakeService{}, nil },
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+
+	err := NewCVDRemoteCommand(opts).Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "--service_url") {
+		t.Errorf("expected an invalid --service_url error, got: %v", err)
+	}
+}
+
+func TestListAllServicesReportsFailingServices(t *testing.T) {
+	io, _, out := newTestIOStreams()
+	opts := &CommandOptions{
+		IOStreams: io,
+		Args:      []string{"list", "--all_services", "--service_url=" + serviceURL},
+		InitialConfig: Config{
+			ConnectionControlDir: t.TempDir(),
+			Services: map[string]*Service{
+				"bad":  {ServiceURL: "http://bad.com"},
+				"good": {ServiceURL: serviceURL},
+			},
+		},
+		ServiceBuilder: func(opts *client.ServiceOptions) (client.Service, error) {
+			if strings.HasPrefix(opts.RootEndpoint, "http://bad.com") {
+				return nil, errors.New("unreachable")
+			}
+			return &fakeService{}, nil
+		},
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+
+	err := NewCVDRemoteCommand(opts).Execute()
+
+	if err == nil || !strings.Contains(err.Error(), `"bad"`) {
+		t.Errorf("expected error for service \"bad\", got: %v", err)
+	}
+	b, _ := ioutil.ReadAll(out)
+	exp := expectedOutput(serviceURL, "foo", hoapi.CVD{Name: "cvd-1"}, 0) +
+		expectedOutput(serviceURL, "bar", hoapi.CVD{Name: "cvd-1"}, 0)
+	if diff := cmp.Diff(exp, string(b)); diff != "" {
+		t.Errorf("standard output mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestBuildAgentCmdline(t *testing.T) {
 	/*****************************************************************
 	If this test fails you most likely need to fix an AsArgs function!
@@ -309,3 +514,32 @@ func expectedOutput(serviceURL, host string, cvd hoapi.CVD, port int) string {
 	b, _ := ioutil.ReadAll(out)
 	return string(b)
 }
+
+func TestNewRemoteCVDBuildSource(t *testing.T) {
+	build := &hoapi.AndroidCIBuild{Branch: "aosp-main", BuildID: "12345", Target: "aosp_cf_x86_64_phone-userdebug"}
+	cvd := NewRemoteCVD(serviceURL, "foo", &hoapi.CVD{
+		Name:        "cvd-1",
+		BuildSource: &hoapi.BuildSource{AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{MainBuild: build}},
+	})
+
+	if diff := cmp.Diff(build, cvd.MainBuild); diff != "" {
+		t.Errorf("main build mismatch (-want +got):\n%s", diff)
+	}
+	if exp := "Build: 12345/aosp_cf_x86_64_phone-userdebug (aosp-main)"; !contains(cvdOutput(cvd), exp) {
+		t.Errorf("expected %q in the output, got: %v", exp, cvdOutput(cvd))
+	}
+	if user := NewRemoteCVD(serviceURL, "foo", &hoapi.CVD{Name: "cvd-1", BuildSource: &hoapi.BuildSource{}}); user.MainBuild != nil || user.Build != "" {
+		t.Errorf("expected no build for user builds, got: %+v", user.MainBuild)
+	}
+}
+
+func TestCVDOutputMetadata(t *testing.T) {
+	cvd := NewRemoteCVD(serviceURL, "foo", &hoapi.CVD{Name: "cvd-1"})
+	cvd.Metadata = map[string]string{"test_run": "1234", "cost_center": "eng"}
+
+	lines := cvdOutput(cvd)
+
+	if got := lines[len(lines)-1]; got != "Metadata: cost_center=eng, test_run=1234" {
+		t.Errorf("unexpected metadata line: %q", got)
+	}
+}
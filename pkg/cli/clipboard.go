@@ -0,0 +1,242 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	BothClipboardDirections      = "both"
+	ToDeviceClipboardDirection   = "to_device"
+	FromDeviceClipboardDirection = "from_device"
+)
+
+// Clipboard contents larger than this are not synchronized.
+const maxClipboardSize = 64 * 1024
+
+const clipboardPollInterval = time.Second
+
+type ClipboardSyncOpts struct {
+	Enabled bool
+	// One of "both", "to_device" or "from_device".
+	Direction string
+}
+
+func (o *ClipboardSyncOpts) Validate() error {
+	switch o.Direction {
+	case "", BothClipboardDirections, ToDeviceClipboardDirection, FromDeviceClipboardDirection:
+		return nil
+	default:
+		return fmt.Errorf("invalid clipboard direction %q, expected one of %q, %q or %q", o.Direction,
+			BothClipboardDirections, ToDeviceClipboardDirection, FromDeviceClipboardDirection)
+	}
+}
+
+func (o *ClipboardSyncOpts) toDevice() bool {
+	return o.Direction != FromDeviceClipboardDirection
+}
+
+func (o *ClipboardSyncOpts) fromDevice() bool {
+	return o.Direction != ToDeviceClipboardDirection
+}
+
+// Access to the local machine's clipboard.
+type Clipboard interface {
+	Read() (string, error)
+	Write(string) error
+}
+
+// Clipboard backed by the tools available in the system: pbcopy/pbpaste on macOS and
+// wl-copy/wl-paste or xclip on Linux.
+type systemClipboard struct {
+	readCmd  []string
+	writeCmd []string
+}
+
+func newSystemClipboard() (*systemClipboard, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return &systemClipboard{readCmd: []string{"pbpaste"}, writeCmd: []string{"pbcopy"}}, nil
+	case "linux":
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			if _, err := exec.LookPath("wl-paste"); err == nil {
+				return &systemClipboard{readCmd: []string{"wl-paste", "--no-newline"}, writeCmd: []string{"wl-copy"}}, nil
+			}
+		}
+		if _, err := exec.LookPath("xclip"); err != nil {
+			return nil, fmt.Errorf("no clipboard tool found, install xclip or wl-clipboard")
+		}
+		return &systemClipboard{
+			readCmd:  []string{"xclip", "-selection", "clipboard", "-o"},
+			writeCmd: []string{"xclip", "-selection", "clipboard", "-i"},
+		}, nil
+	default:
+		return nil, fmt.Errorf("clipboard sync not supported on %s", runtime.GOOS)
+	}
+}
+
+func (c *systemClipboard) Read() (string, error) {
+	out, err := exec.Command(c.readCmd[0], c.readCmd[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed reading clipboard: %w", err)
+	}
+	return string(out), nil
+}
+
+func (c *systemClipboard) Write(v string) error {
+	cmd := exec.Command(c.writeCmd[0], c.writeCmd[1:]...)
+	cmd.Stdin = strings.NewReader(v)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed writing clipboard: %w: %s", err, string(out))
+	}
+	return nil
+}
+
+// Synchronizes the local clipboard with the device's over a webrtc data channel. Each message in
+// the channel carries the entire clipboard contents as text.
+type ClipboardSyncer struct {
+	opts      ClipboardSyncOpts
+	clipboard Clipboard
+	logger    *log.Logger
+	// Sends the clipboard contents to the device.
+	send func(string) error
+
+	mtx sync.Mutex
+	// The last contents seen on either side, to avoid echoing updates back.
+	last   string
+	active bool
+	stopCh chan struct{}
+}
+
+func NewClipboardSyncer(opts ClipboardSyncOpts, clipboard Clipboard, logger *log.Logger) *ClipboardSyncer {
+	return &ClipboardSyncer{
+		opts:      opts,
+		clipboard: clipboard,
+		logger:    logger,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+func (s *ClipboardSyncer) OnDataChannel(dc *webrtc.DataChannel) {
+	s.send = dc.SendText
+	dc.OnOpen(func() {
+		s.logger.Printf("Clipboard data channel changed state: %v\n", dc.ReadyState())
+		if current, err := s.clipboard.Read(); err == nil {
+			s.mtx.Lock()
+			s.last = current
+			s.mtx.Unlock()
+		}
+		s.setActive(true)
+		if s.opts.toDevice() {
+			go s.pollLoop()
+		}
+	})
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		s.onDeviceClipboard(string(msg.Data))
+	})
+	dc.OnClose(func() {
+		s.logger.Printf("Clipboard data channel changed state: %v\n", dc.ReadyState())
+		s.Stop()
+	})
+}
+
+func (s *ClipboardSyncer) Active() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.active
+}
+
+func (s *ClipboardSyncer) Stop() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.active {
+		return
+	}
+	s.active = false
+	close(s.stopCh)
+}
+
+func (s *ClipboardSyncer) setActive(v bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.active = v
+}
+
+func (s *ClipboardSyncer) onDeviceClipboard(v string) {
+	if !s.opts.fromDevice() {
+		return
+	}
+	if len(v) > maxClipboardSize {
+		s.logger.Printf("Ignoring device clipboard of %d bytes, exceeds %d bytes limit", len(v), maxClipboardSize)
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if v == s.last {
+		return
+	}
+	if err := s.clipboard.Write(v); err != nil {
+		s.logger.Printf("Error updating local clipboard: %v", err)
+		return
+	}
+	s.last = v
+}
+
+func (s *ClipboardSyncer) pollLoop() {
+	ticker := time.NewTicker(clipboardPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.syncLocalClipboard()
+		}
+	}
+}
+
+// Sends the local clipboard contents to the device if they changed since last seen.
+func (s *ClipboardSyncer) syncLocalClipboard() {
+	v, err := s.clipboard.Read()
+	if err != nil {
+		s.logger.Printf("Error reading local clipboard: %v", err)
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if v == s.last {
+		return
+	}
+	// Update the last seen value regardless of the outcome to avoid retrying, or logging, on
+	// every poll.
+	s.last = v
+	if len(v) > maxClipboardSize {
+		s.logger.Printf("Ignoring local clipboard of %d bytes, exceeds %d bytes limit", len(v), maxClipboardSize)
+		return
+	}
+	if err := s.send(v); err != nil {
+		s.logger.Printf("Error sending clipboard to device: %v", err)
+	}
+}
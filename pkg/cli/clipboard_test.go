@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeClipboard struct {
+	value string
+}
+
+func (c *fakeClipboard) Read() (string, error) { return c.value, nil }
+
+func (c *fakeClipboard) Write(v string) error {
+	c.value = v
+	return nil
+}
+
+func newTestClipboardSyncer(direction string, clipboard Clipboard, sent *[]string) *ClipboardSyncer {
+	s := NewClipboardSyncer(ClipboardSyncOpts{Enabled: true, Direction: direction}, clipboard, log.New(io.Discard, "", 0))
+	s.send = func(v string) error {
+		*sent = append(*sent, v)
+		return nil
+	}
+	return s
+}
+
+func TestClipboardSyncerSendsLocalChanges(t *testing.T) {
+	clipboard := &fakeClipboard{}
+	sent := []string{}
+	s := newTestClipboardSyncer(BothClipboardDirections, clipboard, &sent)
+
+	clipboard.value = "foo"
+	s.syncLocalClipboard()
+	s.syncLocalClipboard()
+	clipboard.value = strings.Repeat("x", maxClipboardSize+1)
+	s.syncLocalClipboard()
+
+	if diff := cmp.Diff([]string{"foo"}, sent); diff != "" {
+		t.Errorf("sent mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestClipboardSyncerDeviceChanges(t *testing.T) {
+	tests := []struct {
+		direction string
+		exp       string
+	}{
+		{BothClipboardDirections, "foo"},
+		{FromDeviceClipboardDirection, "foo"},
+		{ToDeviceClipboardDirection, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.direction, func(t *testing.T) {
+			clipboard := &fakeClipboard{}
+			sent := []string{}
+			s := newTestClipboardSyncer(tc.direction, clipboard, &sent)
+
+			s.onDeviceClipboard("foo")
+			// Must not be echoed back to the device.
+			s.syncLocalClipboard()
+
+			if diff := cmp.Diff(tc.exp, clipboard.value); diff != "" {
+				t.Errorf("clipboard mismatch (-want +got):\n%s", diff)
+			}
+			if len(sent) != 0 {
+				t.Errorf("expected nothing sent, got: %v", sent)
+			}
+		})
+	}
+}
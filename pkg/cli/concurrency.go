@@ -0,0 +1,129 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+)
+
+// Unless given explicitly, the number of parallel chunk uploads is derived from the local machine
+// and the throughput of the first chunk, uploaded alone: more cores can keep more chunks in flight,
+// while a slow link only gets more contention from extra workers. Fast links never go below the
+// fixed number of workers used before tuning it, see client.DefaultUploadOptions.
+const (
+	uploadWorkersPerCPU      = 4
+	minUploadWorkers         = 32
+	minSlowLinkUploadWorkers = 8
+	maxUploadWorkers         = 64
+	// Chunks uploaded slower than this are taken as a constrained link.
+	slowLinkBytesPerSec = 4 * 1024 * 1024
+)
+
+func autoUploadWorkers(numCPU int, bytesPerSec float64) int {
+	workers := numCPU * uploadWorkersPerCPU
+	min := minUploadWorkers
+	if bytesPerSec < slowLinkBytesPerSec {
+		workers /= 2
+		min = minSlowLinkUploadWorkers
+	}
+	if workers < min {
+		return min
+	}
+	if workers > maxUploadWorkers {
+		return maxUploadWorkers
+	}
+	return workers
+}
+
+// Returns the upload options to use with the host, `workers` takes precedence over auto-tuning if
+// positive. Uploads failing to probe the link fail anyway, those of a single chunk keep the
+// default.
+func uploadOptions(workers int) client.UploadOptions {
+	opts := client.DefaultUploadOptions()
+	if workers > 0 {
+		opts.NumWorkers = workers
+		return opts
+	}
+	numCPU := runtime.NumCPU()
+	opts.TuneWorkers = func(bytesPerSec float64) int {
+		return autoUploadWorkers(numCPU, bytesPerSec)
+	}
+	return opts
+}
+
+// Hosts listed concurrently while the service doesn't rate limit the listing. Not tuned like the
+// uploads: listings are small requests bounded by the service's rate limits rather than the local
+// machine or the link, which adaptiveLimiter already follows.
+const maxListConcurrency = 16
+
+// Bounds the concurrency of a fan-out of requests to the service, halving it every time the
+// service rate limits a request and growing it back by one with every request that isn't.
+type adaptiveLimiter struct {
+	mtx      sync.Mutex
+	cond     *sync.Cond
+	max      int
+	limit    int
+	inFlight int
+}
+
+func newAdaptiveLimiter(max int) *adaptiveLimiter {
+	l := &adaptiveLimiter{max: max, limit: max}
+	l.cond = sync.NewCond(&l.mtx)
+	return l
+}
+
+// Blocks until the request can be sent.
+func (l *adaptiveLimiter) Acquire() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// Releases the request acquired before, `throttled` tells whether the service rate limited it
+// until it gave up.
+func (l *adaptiveLimiter) Release(throttled bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.inFlight--
+	if !throttled && l.limit < l.max {
+		l.limit++
+	}
+	l.cond.Broadcast()
+}
+
+// A client.ThrottleHook for the requests acquired before. Every rate limited response halves the
+// limit and frees the request's slot while it waits, its retry is sent once it acquires one again.
+func (l *adaptiveLimiter) Throttled() func() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.inFlight--
+	if l.limit > 1 {
+		l.limit /= 2
+	}
+	l.cond.Broadcast()
+	return l.Acquire
+}
+
+func (l *adaptiveLimiter) Limit() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.limit
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+)
+
+func TestAutoUploadWorkers(t *testing.T) {
+	const fast, slow = 100 * 1024 * 1024, 1024 * 1024
+	tests := []struct {
+		numCPU      int
+		bytesPerSec float64
+		exp         int
+	}{
+		{numCPU: 1, bytesPerSec: fast, exp: minUploadWorkers},
+		{numCPU: 8, bytesPerSec: fast, exp: 32},
+		{numCPU: 12, bytesPerSec: fast, exp: 48},
+		{numCPU: 64, bytesPerSec: fast, exp: maxUploadWorkers},
+		{numCPU: 1, bytesPerSec: slow, exp: minSlowLinkUploadWorkers},
+		{numCPU: 8, bytesPerSec: slow, exp: 16},
+		{numCPU: 16, bytesPerSec: slow, exp: 32},
+		{numCPU: 64, bytesPerSec: slow, exp: maxUploadWorkers},
+	}
+	for _, tc := range tests {
+		if got := autoUploadWorkers(tc.numCPU, tc.bytesPerSec); got != tc.exp {
+			t.Errorf("autoUploadWorkers(%d, %v) = %d, expected %d", tc.numCPU, tc.bytesPerSec, got, tc.exp)
+		}
+	}
+}
+
+func TestUploadOptionsExplicitWorkersSkipsProbe(t *testing.T) {
+	opts := uploadOptions(3)
+
+	if opts.NumWorkers != 3 || opts.TuneWorkers != nil {
+		t.Errorf("expected 3 workers without probing, got %d", opts.NumWorkers)
+	}
+}
+
+func TestUploadOptionsProbeKeepsTheDefaultWorkers(t *testing.T) {
+	opts := uploadOptions(0)
+
+	if opts.TuneWorkers == nil {
+		t.Fatal("expected the workers to be tuned")
+	}
+	if exp := client.DefaultUploadOptions().NumWorkers; opts.NumWorkers != exp {
+		t.Errorf("expected %d workers for single chunk uploads, got %d", exp, opts.NumWorkers)
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := newAdaptiveLimiter(8)
+
+	l.Acquire()
+	for i := 0; i < 4; i++ {
+		resume := l.Throttled()
+		resume()
+	}
+	l.Release(true)
+	if got := l.Limit(); got != 1 {
+		t.Errorf("expected the limit to drop to 1, got: %d", got)
+	}
+	for i := 0; i < 10; i++ {
+		l.Acquire()
+		l.Release(false)
+	}
+	if got := l.Limit(); got != 8 {
+		t.Errorf("expected the limit to grow back to 8, got: %d", got)
+	}
+}
+
+func TestAdaptiveLimiterBoundsInFlight(t *testing.T) {
+	l := newAdaptiveLimiter(2)
+	l.Acquire()
+	l.Acquire()
+	acquired := make(chan struct{})
+
+	go func() {
+		l.Acquire()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("expected acquire to block beyond the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.Release(false)
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for acquire")
+	}
+}
+
+func TestAdaptiveLimiterThrottledRetryWaitsForSlot(t *testing.T) {
+	l := newAdaptiveLimiter(2)
+	l.Acquire()
+	l.Acquire()
+	resumed := make(chan struct{})
+
+	resume := l.Throttled()
+	go func() {
+		resume()
+		close(resumed)
+	}()
+
+	select {
+	case <-resumed:
+		t.Fatal("expected the retry to wait for the other request with the halved limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.Release(false)
+	select {
+	case <-resumed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the retry")
+	}
+}
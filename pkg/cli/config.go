@@ -33,6 +33,8 @@ type GCPHostConfig struct {
 
 type HostConfig struct {
 	GCP GCPHostConfig `json:"gcp,omitempty"`
+	// [OPTIONAL] Number of instances `cvdr create` creates by default in hosts of each machine type.
+	DefaultNumInstances map[string]int `json:"default_num_instances,omitempty"`
 }
 
 type AuthnConfig struct {
@@ -52,14 +54,90 @@ type HTTPBasicAuthnConfig struct {
 	UsernameSrc UsernameSrcType `json:"username_src,omitempty"`
 }
 
+type CredentialStoreConfig struct {
+	// One of "auto", "keyring", "file", "env" or a backend registered with
+	// RegisterCredentialStoreBackend. The "auto" backend uses the OS keyring if available and falls
+	// back to the encrypted file otherwise, with a warning. The OS keyring is only supported on
+	// macOS and Linux.
+	Backend string `json:"backend,omitempty"`
+	// [OPTIONAL] Path to the encrypted file used by the "file" backend.
+	FilePath string `json:"file_path,omitempty"`
+	// [OPTIONAL] Settings of custom backends, like the address of a Vault server.
+	Options map[string]string `json:"options,omitempty"`
+}
+
 type Config struct {
 	// Default service, service to be used in case none other was selected.
 	SystemDefaultService string `json:"system_default_service,omitempty"`
 	// [OPTIONAL] If set, it overrides the `SystemDefaultService` parameter.
-	UserDefaultService   string              `json:"user_default_service,omitempty"`
-	Services             map[string]*Service `json:"services,omitempty"`
-	ConnectionControlDir string              `json:"connection_control_dir,omitempty"`
-	KeepLogFilesDays     int                 `json:"keep_log_files_days,omitempty"`
+	UserDefaultService string              `json:"user_default_service,omitempty"`
+	Services           map[string]*Service `json:"services,omitempty"`
+	// [OPTIONAL] Directory where the CLI keeps its state, see `DefaultStateDir`. The connection
+	// control directory, the upload cache and the credential store file are placed in it unless
+	// configured otherwise.
+	StateDir             string `json:"state_dir,omitempty"`
+	ConnectionControlDir string `json:"connection_control_dir,omitempty"`
+	KeepLogFilesDays     int    `json:"keep_log_files_days,omitempty"`
+	// [OPTIONAL] If set, credentials are kept in this store instead of plaintext files: the OIDC
+	// token and the Build API credentials of the "adc" credentials source.
+	CredentialStore *CredentialStoreConfig `json:"credential_store,omitempty"`
+	// [OPTIONAL] Shell commands run around `cvdr create`.
+	Hooks *HooksConfig `json:"hooks,omitempty"`
+	// Where the files uploaded by previous creates are tracked, used by incremental creates.
+	UploadCacheDir string `json:"upload_cache_dir,omitempty"`
+	// [OPTIONAL] Build server mirrors keyed by zone, hosts in those zones fetch artifacts from the
+	// mirror instead of the default build server. Creates sending the host a canonical configuration,
+	// i.e: with instance properties, fetch the devices' builds from the default one with a warning.
+	BuildAPIMirrors map[string]string `json:"build_api_mirrors,omitempty"`
+	// [OPTIONAL] Gerrit instance `create --gerrit_change` looks the changes up in, defaults to
+	// AOSP's.
+	GerritURL string `json:"gerrit_url,omitempty"`
+	// [OPTIONAL] Used by `cvdr ssh` to log into the hosts.
+	SSH *SSHConfig `json:"ssh,omitempty"`
+	// [OPTIONAL] Displays of the devices created without --display, keyed by device type, i.e:
+	// `tablet = ["2560x1600@320"]`. Overrides the built-in defaults, new device types are matched
+	// against the build target.
+	DisplayDefaults map[string][]string `json:"display_defaults,omitempty"`
+	// [OPTIONAL] How the connections reach the hosts.
+	Network *NetworkConfig `json:"network,omitempty"`
+	// [OPTIONAL] Android CI build of the creates not given one in the flags. Takes precedence over
+	// the defaults stored in the service, see `cvdr defaults`.
+	BuildDefaults *BuildDefaultsConfig `json:"build_defaults,omitempty"`
+}
+
+type BuildDefaultsConfig struct {
+	// Only one of `Branch` and `BuildID` can be set.
+	Branch  string `json:"branch,omitempty"`
+	BuildID string `json:"build_id,omitempty"`
+	Target  string `json:"target,omitempty"`
+}
+
+type NetworkConfig struct {
+	// Network the connections prefer, "public" or "overlay". The "overlay" network reaches the
+	// hosts at their overlay address, i.e: in a Tailscale or WireGuard mesh, falling back to the
+	// public network for hosts without one or unreachable at it. Defaults to "public".
+	Prefer string `json:"prefer,omitempty"`
+	// [OPTIONAL] Port the host orchestrators listen on in the overlay network, defaults to 2080.
+	OverlayHostOrchestratorPort int `json:"overlay_host_orchestrator_port,omitempty"`
+}
+
+// Returns the network the connections prefer, "public" unless configured otherwise.
+func (c *Config) PreferredNetwork() string {
+	if c.Network != nil && c.Network.Prefer != "" {
+		return c.Network.Prefer
+	}
+	return publicNetwork
+}
+
+// The device details are passed to the hooks in environment variables, see hooks.go.
+type HooksConfig struct {
+	// Run before creating the devices, creation is aborted if it fails.
+	PreCreate string `json:"pre_create,omitempty"`
+	// Run after creating, and connecting, each device.
+	PostCreate string `json:"post_create,omitempty"`
+	// If true, a device is deleted when its post create hook fails. Otherwise the failure is only
+	// reported.
+	DeleteOnPostCreateFailure bool `json:"delete_on_post_create_failure,omitempty"`
 }
 
 type Service struct {
@@ -69,6 +147,12 @@ type Service struct {
 	BuildAPICredentialsSource string       `json:"build_api_credentials_source,omitempty"`
 	Host                      *HostConfig  `json:"host,omitempty"`
 	Authn                     *AuthnConfig `json:"authn,omitempty"`
+	// [OPTIONAL] Path the hosts' WebRTC signaling server is mounted at, relative to each host's
+	// endpoint in the service. For reverse proxies rewriting paths, defaults to
+	// "/polled_connections".
+	SignalingPath string `json:"signaling_path,omitempty"`
+	// [OPTIONAL] Proxy the ADB connections of `--connect_agent=proxy_agent` go through.
+	ADBProxy *ADBProxyConfig `json:"adb_proxy,omitempty"`
 }
 
 func (c *Config) DefaultService() *Service {
@@ -80,18 +164,91 @@ func (c *Config) DefaultService() *Service {
 	return &Service{Host: &HostConfig{}}
 }
 
+const StateDirEnvVar = "CVDR_STATE_DIR"
+
+// Returns "$XDG_STATE_HOME/cvdr", or "~/.local/state/cvdr" if the variable isn't set. The
+// "~/.cvdr" directory of previous versions is used instead while it exists, to not lose its state.
+func DefaultStateDir() string {
+	if legacy := ExpandPath("~/.cvdr"); dirExists(legacy) {
+		return legacy
+	}
+	if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "cvdr")
+	}
+	return ExpandPath("~/.local/state/cvdr")
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Relocates all the state to the given directory, overriding the configured locations.
+func (c *Config) ApplyStateDir(dir string) {
+	c.StateDir = dir
+	c.ConnectionControlDir = ""
+	c.UploadCacheDir = ""
+	if c.CredentialStore != nil {
+		store := *c.CredentialStore
+		store.FilePath = ""
+		c.CredentialStore = &store
+	}
+}
+
+func (c *Config) StateDirExpanded() string {
+	if c.StateDir == "" {
+		return DefaultStateDir()
+	}
+	return ExpandPath(c.StateDir)
+}
+
 func (c *Config) ConnectionControlDirExpanded() string {
+	if c.ConnectionControlDir == "" {
+		return filepath.Join(c.StateDirExpanded(), "connections")
+	}
 	return ExpandPath(c.ConnectionControlDir)
 }
 
+func (c *Config) UploadCacheDirExpanded() string {
+	if c.UploadCacheDir == "" {
+		return filepath.Join(c.StateDirExpanded(), "uploads")
+	}
+	return ExpandPath(c.UploadCacheDir)
+}
+
+// Where `cvdr list_targets` caches the targets of the branches.
+func (c *Config) TargetCacheDir() string {
+	return filepath.Join(c.StateDirExpanded(), "targets")
+}
+
+// Path of the encrypted file used by the "file" credential store backend.
+func (c *Config) CredentialStoreFilePath() string {
+	if c.CredentialStore != nil && c.CredentialStore.FilePath != "" {
+		return ExpandPath(c.CredentialStore.FilePath)
+	}
+	return filepath.Join(c.StateDirExpanded(), "credentials")
+}
+
+// Fails if the directory can't be created or written to.
+func validateStateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("invalid state directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".write-check-")
+	if err != nil {
+		return fmt.Errorf("state directory %q is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 func (c *Config) LogFilesDeleteThreshold() time.Duration {
 	return time.Duration(c.KeepLogFilesDays*24) * time.Hour
 }
 
 func BaseConfig() *Config {
 	return &Config{
-		ConnectionControlDir: "~/.cvdr/connections",
-		KeepLogFilesDays:     30, // A default is needed to not keep forever
+		KeepLogFilesDays: 30, // A default is needed to not keep forever
 	}
 }
 
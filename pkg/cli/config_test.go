@@ -17,6 +17,7 @@ package cli
 import (
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -52,17 +53,31 @@ func TestLoadFullConfig(t *testing.T) {
 	const fullConfig = `
 SystemDefaultService = "foo"
 UserDefaultService = "bar"
+CredentialStore = { Backend = "file", FilePath = "/path/to/credentials", Options = { address = "https://vault.example.com" } }
+StateDir = "/path/to/state"
+ConnectionControlDir = "/path/to/connections"
+UploadCacheDir = "/path/to/uploads"
+BuildAPIMirrors = { "us-central1-a" = "https://mirror.example.com" }
+GerritURL = "https://gerrit.example.com"
+SSH = { User = "user", IdentityFile = "/path/to/key", JumpHosts = ["bastion"] }
+DisplayDefaults = { "tablet" = ["2560x1600@320"] }
+Network = { Prefer = "overlay", OverlayHostOrchestratorPort = 2080 }
+Hooks = { PreCreate = "pre.sh", PostCreate = "post.sh", DeleteOnPostCreateFailure = true }
+BuildDefaults = { Branch = "aosp-main", Target = "aosp_cf_x86_64_phone-userdebug" }
 
 [Services."foo"]
 ServiceURL = "service_url"
 Zone = "zone"
 Proxy = "proxy"
 BuildAPICredentialsSource = "injected"
+SignalingPath = "/signaling"
+ADBProxy = { URL = "http://proxy:3128", BasicAuthn = { Username = "user", PasswordFile = "/path/to/password" } }
 Host = {
   GCP = {
     MachineType = "machine_type",
     MinCPUPlatform = "cpu_platform"
-  }
+  },
+  DefaultNumInstances = { "n1-standard-64" = 4 }
 }
 Authn = {
   OIDCToken = {
@@ -113,7 +128,6 @@ ServiceURL = "bar.com"
 	ucf := tempFile(t, user)
 	expected := &Config{
 		SystemDefaultService: "foo",
-		ConnectionControlDir: "~/.cvdr/connections",
 		KeepLogFilesDays:     30,
 		Services: map[string]*Service{
 			"foo": {
@@ -133,6 +147,37 @@ ServiceURL = "bar.com"
 	}
 }
 
+func TestApplyStateDir(t *testing.T) {
+	c := &Config{
+		ConnectionControlDir: "/path/to/connections",
+		UploadCacheDir:       "/path/to/uploads",
+		CredentialStore:      &CredentialStoreConfig{Backend: "file", FilePath: "/path/to/credentials"},
+	}
+	original := c.CredentialStore
+
+	c.ApplyStateDir("/state")
+
+	got := []string{c.ConnectionControlDirExpanded(), c.UploadCacheDirExpanded(), c.CredentialStoreFilePath()}
+	exp := []string{"/state/connections", "/state/uploads", "/state/credentials"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("paths mismatch (-want +got):\n%s", diff)
+	}
+	if original.FilePath != "/path/to/credentials" {
+		t.Error("expected the original credential store configuration to be left untouched")
+	}
+}
+
+func TestValidateStateDirUnderFile(t *testing.T) {
+	file := tempFile(t, "")
+
+	if err := validateStateDir(filepath.Join(file, "state")); err == nil {
+		t.Error("expected error")
+	}
+	if err := validateStateDir(t.TempDir()); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestImportAcloudConfig(t *testing.T) {
 	tests := []struct {
 		content string
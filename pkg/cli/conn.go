@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"net"
@@ -42,14 +43,74 @@ type ForwarderState struct {
 
 type ConnStatus struct {
 	ADB ForwarderState
+	// Whether the clipboard is being synchronized with the device.
+	ClipboardSync bool
+	// Path of the file the display or the session is being recorded to, empty if not recording.
+	Recording string `json:",omitempty"`
+	// Time of the last successful heartbeat, nil if heartbeats are disabled.
+	LastHeartbeat *time.Time `json:",omitempty"`
+	// Time between heartbeats, zero if they are disabled.
+	HeartbeatInterval time.Duration `json:",omitempty"`
+	// Time data was last forwarded through the connection, its creation if none was.
+	LastActivity *time.Time `json:",omitempty"`
+	// Name of the control socket of the agent shared by the connections to the devices of the
+	// host, empty if the connection has an agent of its own.
+	ControlSocket string `json:",omitempty"`
+	// Summary of the webrtc statistics of the connection, nil until sampled.
+	Stats *ConnStats `json:",omitempty"`
+	// Path of the socket serving the device's serial console, empty if the device doesn't provide it.
+	Console string `json:",omitempty"`
+	// Bastions the connection goes through, in order. Only connections tunnelled over SSH have one,
+	// webrtc connections reach the devices through the service.
+	JumpPath []string `json:",omitempty"`
+	// Proxy the connection is tunnelled through, credentials redacted. Only connections of the proxy
+	// agent use one.
+	Proxy string `json:",omitempty"`
+	// Algorithm the ADB data is compressed with, empty if it isn't. Only connections of the proxy
+	// agent are compressed.
+	Compression string `json:",omitempty"`
+	// Device ports forwarded to local ports, sorted by local port.
+	Forwards []PortForward `json:",omitempty"`
+	// Result of the probe the connection passed before being reported, nil if it wasn't probed.
+	HealthProbe *HealthProbeResult `json:",omitempty"`
+	// Attempts of the ADB server to connect to the device, nil until it's asked to.
+	ADBConnect *ADBConnectResult `json:",omitempty"`
+	// When the credentials of the connection's TURN servers expire, nil if they don't. The connection
+	// is replaced with one using fresh credentials shortly before.
+	TURNCredentialsExpireTime *time.Time `json:",omitempty"`
+	// Latest replacements of the connection for its TURN credentials to expire, oldest first.
+	TURNRotations []TURNRotation `json:",omitempty"`
+}
+
+// Options of the connection to a device besides ADB forwarding.
+type ConnOpts struct {
+	ClipboardSync ClipboardSyncOpts
+	Recording     RecordingOpts
+	Heartbeat     HeartbeatOpts
+	// Closes the connection after this long without forwarding any data, zero disables it.
+	IdleTimeout time.Duration
+	// Bastions to tunnel the connection through, only supported by the proxy agent.
+	JumpHosts []string
+	// Network the host is reached through, "public" or "overlay". Only supported by the webrtc agent.
+	Network string
+	// Only supported by the webrtc agent connecting a single device.
+	HealthProbe HealthProbeOpts
+	// Only supported by the webrtc agent, the proxy agent connects ADB once it's listening.
+	ADBConnect ADBConnectOpts
 }
 
 type StatusCmdRes struct {
 	CVD    RemoteCVDLocator
 	Status ConnStatus
+	// Set by agents shared by the connections to several devices, one entry per device. CVD and
+	// Status are empty then.
+	Multiplexed []StatusCmdRes `json:",omitempty"`
 }
 
 func ControlSocketName(_ RemoteCVDLocator, cs ConnStatus) string {
+	if cs.ControlSocket != "" {
+		return cs.ControlSocket
+	}
 	// The canonical name is too long to use as a unix socket name, use the port
 	// instead and use the canonical name to create a symlink to the socket.
 	return fmt.Sprintf("%d.sock", cs.ADB.Port)
@@ -67,21 +128,45 @@ func EnsureConnDirsExist(controlDir string) error {
 	if err := os.MkdirAll(proxyDir, 0755); err != nil {
 		return fmt.Errorf("failed to create proxy directory: %w", err)
 	}
+	if err := os.MkdirAll(consoleDir(controlDir), 0755); err != nil {
+		return fmt.Errorf("failed to create console directory: %w", err)
+	}
 	return nil
 }
 
+// Shared agents control several devices, their commands name the device.
+func deviceCmd(cmd string, cvd RemoteCVDLocator, status ConnStatus) string {
+	if status.ControlSocket == "" {
+		return cmd
+	}
+	return cmd + " " + cvd.WebRTCDeviceID
+}
+
 func DisconnectCVD(controlDir string, cvd RemoteCVDLocator, status ConnStatus) error {
 	conn, err := net.Dial("unixpacket", fmt.Sprintf("%s/%s", controlDir, ControlSocketName(cvd, status)))
 	if err != nil {
 		return fmt.Errorf("failed to connect to %s/%s's agent: %w", cvd.Host, cvd.WebRTCDeviceID, err)
 	}
-	_, err = conn.Write([]byte(stopCmd))
+	defer conn.Close()
+	_, err = conn.Write([]byte(deviceCmd(stopCmd, cvd, status)))
 	if err != nil {
 		return fmt.Errorf("failed to send stop command to %s/%s: %w", cvd.Host, cvd.WebRTCDeviceID, err)
 	}
 	return nil
 }
 
+func StopRecording(controlDir string, cvd RemoteCVDLocator, status ConnStatus) error {
+	conn, err := net.Dial("unixpacket", fmt.Sprintf("%s/%s", controlDir, ControlSocketName(cvd, status)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s/%s's agent: %w", cvd.Host, cvd.WebRTCDeviceID, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(deviceCmd(stopRecordingCmd, cvd, status))); err != nil {
+		return fmt.Errorf("failed to send stop recording command to %s/%s: %w", cvd.Host, cvd.WebRTCDeviceID, err)
+	}
+	return nil
+}
+
 // Finds all existing connection agents. Returns the list of connection agents it was able
 // to gather along with a multierror detailing the unreachable ones.
 func listCVDConnections(controlDir string) (map[RemoteCVDLocator]ConnStatus, error) {
@@ -109,6 +194,12 @@ func listCVDConnections(controlDir string) (map[RemoteCVDLocator]ConnStatus, err
 			continue
 		}
 
+		if len(res.Multiplexed) > 0 {
+			for _, r := range res.Multiplexed {
+				statuses[r.CVD] = r.Status
+			}
+			continue
+		}
 		statuses[res.CVD] = res.Status
 	}
 	return statuses, merr
@@ -132,7 +223,7 @@ type findOrConnRet struct {
 	Error      error
 }
 
-func FindOrConnect(controlDir string, cvd RemoteCVDLocator, service client.Service, localICEConfig *wclient.ICEConfig) (findOrConnRet, error) {
+func FindOrConnect(controlDir string, cvd RemoteCVDLocator, service client.Service, localICEConfig *wclient.ICEConfig, connOpts ConnOpts) (findOrConnRet, error) {
 	statuses, err := listCVDConnectionsByHost(controlDir, cvd.Host)
 	// Even with an error some connections may have been listed.
 	if s, ok := statuses[cvd]; ok {
@@ -142,7 +233,7 @@ func FindOrConnect(controlDir string, cvd RemoteCVDLocator, service client.Servi
 	// after the checks were made above but before the socket was created below.
 	// The likelihood of hitting that is very low though, and the effort required
 	// to prevent it high, so we are choosing to live with it for the time being.
-	controller, tErr := NewConnController(controlDir, service, cvd, localICEConfig)
+	controller, tErr := NewConnController(controlDir, service, cvd, localICEConfig, connOpts)
 	if tErr != nil {
 		// This error is fatal, ingore any previous ones to avoid unnecessary noise.
 		return findOrConnRet{}, fmt.Errorf("failed to create connection controller: %w", tErr)
@@ -162,6 +253,10 @@ type Forwarder struct {
 	logger        *log.Logger
 	readyCh       chan struct{}
 	readyChClosed atomic.Bool
+	activity      *connActivity
+	// Returns the writer receiving what each local connection sends to the device, nil if the
+	// data isn't tapped.
+	tap func() io.Writer
 }
 
 func NewForwarder(logger *log.Logger) (*Forwarder, error) {
@@ -177,6 +272,7 @@ func NewForwarder(logger *log.Logger) (*Forwarder, error) {
 		port:     port,
 		logger:   logger,
 		readyCh:  make(chan struct{}),
+		activity: newConnActivity(),
 	}
 
 	return f, nil
@@ -207,10 +303,15 @@ func StateAsStr(state int) string {
 }
 
 func (f *Forwarder) OnDataChannel(dc *webrtc.DataChannel) {
-	f.dc = dc
+	f.setDataChannel(dc)
 	dc.OnOpen(func() {
 		f.logger.Printf("Data channel changed state: %v\n", dc.ReadyState())
 		if set, prev := f.compareAndSwapState(FwdInitializing, FwdReady); !set {
+			if prev == FwdReady || prev == FwdConnected {
+				// A reconnection replaced the data channel, the accept loop is still running.
+				f.logger.Printf("Forwarding resumed on new data channel")
+				return
+			}
 			f.logger.Printf("Forwarding not started in unexpected state: %v", StateAsStr(prev))
 			return
 		}
@@ -220,12 +321,20 @@ func (f *Forwarder) OnDataChannel(dc *webrtc.DataChannel) {
 		}
 	})
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if f.dataChannel() != dc {
+			return
+		}
+		f.activity.Touch()
 		if err := f.Send(msg.Data); err != nil {
 			f.logger.Printf("Error writing to socket: %v", err)
 		}
 	})
 	dc.OnClose(func() {
 		f.logger.Printf("Data channel changed state: %v\n", dc.ReadyState())
+		if f.dataChannel() != dc {
+			// Replaced or detached by a reconnection.
+			return
+		}
 		f.StopForwarding(FwdFailed)
 		if f.readyChClosed.CompareAndSwap(false, true) {
 			close(f.readyCh)
@@ -242,7 +351,9 @@ func (f *Forwarder) StopForwarding(state int) {
 	}
 	f.state = state
 	// Prevent future writes to the channel too.
-	f.dc.Close()
+	if f.dc != nil {
+		f.dc.Close()
+	}
 	// f.listener is guaranteed to be non-nil at this point
 	f.listener.Close()
 	if f.conn != nil {
@@ -250,6 +361,24 @@ func (f *Forwarder) StopForwarding(state int) {
 	}
 }
 
+// Stops using the current data channel, its closing no longer stops forwarding. Data received
+// from the local connection is dropped until a new data channel is set.
+func (f *Forwarder) DetachDataChannel() {
+	f.setDataChannel(nil)
+}
+
+func (f *Forwarder) setDataChannel(dc *webrtc.DataChannel) {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+	f.dc = dc
+}
+
+func (f *Forwarder) dataChannel() *webrtc.DataChannel {
+	f.stateMtx.Lock()
+	defer f.stateMtx.Unlock()
+	return f.dc
+}
+
 func (f *Forwarder) Send(data []byte) error {
 	if f.conn == nil {
 		return fmt.Errorf("no connection yet on port %d", f.port)
@@ -336,6 +465,10 @@ func (f *Forwarder) acceptLoop() {
 
 func (f *Forwarder) recvLoop() {
 	defer f.conn.Close()
+	var tap io.Writer
+	if f.tap != nil {
+		tap = f.tap()
+	}
 	var buffer [4096]byte
 	for {
 		length, err := f.conn.Read(buffer[:])
@@ -345,7 +478,16 @@ func (f *Forwarder) recvLoop() {
 			}
 			return
 		}
-		err = f.dc.Send(buffer[:length])
+		if tap != nil {
+			tap.Write(buffer[:length])
+		}
+		dc := f.dataChannel()
+		if dc == nil {
+			f.logger.Printf("No data channel to send data from port %d, closing the connection", f.port)
+			return
+		}
+		f.activity.Touch()
+		err = dc.Send(buffer[:length])
 		if err != nil {
 			f.logger.Printf("Failed to send data to data channel from port %d: %v", f.port, err)
 			return
@@ -357,6 +499,8 @@ const (
 	versionCmd = "version"
 	statusCmd  = "status"
 	stopCmd    = "stop"
+	// Stops recording the display, keeping the connection.
+	stopRecordingCmd = "stop_recording"
 
 	controlSocketCommsVersion = 1
 )
@@ -367,15 +511,82 @@ type ConnController struct {
 	cvd          RemoteCVDLocator
 	control      *net.UnixListener
 	adbForwarder *Forwarder
-	logger       *log.Logger
+	// Nil if clipboard sync is disabled.
+	clipboardSyncer *ClipboardSyncer
+	// Nil if recording is disabled.
+	recorder *Recorder
+	// Nil if session recording is disabled.
+	session *SessionRecorder
+	// Nil if the console socket couldn't be created.
+	console *ConsoleForwarder
+	// Nil until connected.
+	stats *connStatsCollector
+	// Nil if heartbeats are disabled.
+	heartbeat *heartbeater
+	// Nil if the idle timeout is disabled.
+	idle *idleMonitor
+	// Replaces connections before their TURN credentials expire.
+	turnRotator *turnRotator
+	// Device ports forwarded over ADB.
+	forwards *portForwards
+	// Nil if the connection wasn't probed.
+	healthProbe *HealthProbeResult
+	// Nil until the ADB server is asked to connect to the device.
+	adbConnect *ADBConnectResult
+	// Called when the connection is closed for being idle, stops the controller by default.
+	onIdle         func()
+	logger         *log.Logger
+	service        client.Service
+	localICEConfig *wclient.ICEConfig
+
+	// Serializes reconnections, requested by both the heartbeats and the TURN rotator.
+	reconnectMtx sync.Mutex
+	connMtx      sync.Mutex
 	webrtcConn   *wclient.Connection
+	// Incremented on every connection attempt, identifies the events of replaced connections.
+	connGen int
 }
 
 func NewConnController(
 	controlDir string,
 	service client.Service,
 	cvd RemoteCVDLocator,
-	localICEConfig *wclient.ICEConfig) (*ConnController, error) {
+	localICEConfig *wclient.ICEConfig,
+	connOpts ConnOpts) (*ConnController, error) {
+	tc, err := connectController(controlDir, service, cvd, localICEConfig, connOpts)
+	if err != nil {
+		return nil, err
+	}
+	// Create the control socket as late as possible to reduce the chances of it
+	// being left behind if the user interrupts the command.
+	control, err := createControlSocket(controlDir, ControlSocketName(tc.cvd, tc.Status()))
+	if err != nil {
+		tc.stopTURNRotation()
+		tc.stopConsole()
+		tc.stopStats()
+		tc.stopRecording()
+		tc.adbForwarder.StopForwarding(FwdFailed)
+		tc.connection().Close()
+		return nil, fmt.Errorf("control socket creation failed for %q: %w", cvd.WebRTCDeviceID, err)
+	}
+	tc.control = control
+	if tc.heartbeat != nil {
+		go tc.heartbeat.Run()
+	}
+	if tc.idle != nil {
+		go tc.idle.Run()
+	}
+	return tc, nil
+}
+
+// Connects to the device and waits for the ADB forwarder to be ready, without creating the
+// control socket nor starting the heartbeats and the idle monitor.
+func connectController(
+	controlDir string,
+	service client.Service,
+	cvd RemoteCVDLocator,
+	localICEConfig *wclient.ICEConfig,
+	connOpts ConnOpts) (*ConnController, error) {
 	logger, err := createLogger(controlDir, cvd)
 	if err != nil {
 		return nil, err
@@ -387,34 +598,80 @@ func NewConnController(
 	}
 
 	tc := &ConnController{
-		cvd:          cvd,
-		adbForwarder: f,
-		logger:       logger,
+		cvd:            cvd,
+		adbForwarder:   f,
+		logger:         logger,
+		service:        service,
+		localICEConfig: localICEConfig,
+	}
+	tc.onIdle = tc.Stop
+	tc.forwards = newPortForwards(func(port int) (net.Conn, error) {
+		return dialADBDevicePort(fmt.Sprintf("127.0.0.1:%d", f.port), port)
+	}, logger)
+	if connOpts.ClipboardSync.Enabled {
+		clipboard, err := newSystemClipboard()
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up clipboard sync: %w", err)
+		}
+		tc.clipboardSyncer = NewClipboardSyncer(connOpts.ClipboardSync, clipboard, logger)
+	}
+	if connOpts.Recording.Path != "" {
+		tc.recorder = NewRecorder(connOpts.Recording, logger)
+	}
+	if connOpts.Recording.SessionPath != "" {
+		session, err := NewSessionRecorder(connOpts.Recording, cvd, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up session recording: %w", err)
+		}
+		tc.session = session
+		f.tap = session.ADBTap
 	}
 
+	// The console is always requested so that clients can attach to it later, without it the
+	// connection works as usual.
+	consolePath := consoleSocketPath(controlDir, f.port)
+	// The ADB port is in use by this agent, a socket named after it was left behind by a crashed one.
+	os.Remove(consolePath)
+	if console, err := NewConsoleForwarder(consolePath, logger); err != nil {
+		logger.Printf("Console of %q won't be available: %v", cvd.WebRTCDeviceID, err)
+	} else {
+		console.activity = f.activity
+		tc.console = console
+	}
 	opts := client.ConnectWebRTCOpts{
 		LocalICEConfig: localICEConfig,
+		ClipboardSync:  connOpts.ClipboardSync.Enabled,
+		Video:          tc.recorder != nil || tc.session != nil,
+		Console:        tc.console != nil,
+	}
+	if connOpts.Heartbeat.Interval > 0 {
+		tc.heartbeat = newHeartbeater(connOpts.Heartbeat.Interval, tc.checkConnection, tc.reconnect, tc.onReconnectionFailure, logger)
+	}
+	tc.turnRotator = newTURNRotator(tc.reconnect, tc.onTURNRotationFailure, logger)
+	if connOpts.IdleTimeout > 0 {
+		tc.idle = newIdleMonitor(connOpts.IdleTimeout, f.activity, func() { tc.onIdle() }, logger)
 	}
-	conn, err := service.HostService(cvd.Host).ConnectWebRTC(cvd.WebRTCDeviceID, tc, logger.Writer(), opts)
+	conn, err := service.HostService(cvd.Host).ConnectWebRTC(cvd.WebRTCDeviceID, tc.newConnObserver(), logger.Writer(), opts)
 	if err != nil {
+		tc.stopConsole()
+		tc.stopRecording()
 		return nil, fmt.Errorf("failed to connect to %q: %w", cvd.WebRTCDeviceID, err)
 	}
-	tc.webrtcConn = conn
+	tc.setConnection(conn)
+	tc.turnRotator.Schedule(conn.ICECredentialsExpireTime())
+	// Samples the current connection, surviving reconnections.
+	tc.stats = newConnStatsCollector(connStatsInterval, func() (webrtc.StatsReport, bool) {
+		if conn := tc.connection(); conn != nil && conn.Connected() {
+			return conn.Stats(), true
+		}
+		return nil, false
+	})
+	go tc.stats.Run()
 	// TODO(jemoreira): close everything except the relevant data channels.
 
 	// Wait for the ADB forwarder to be set up before connecting the ADB server.
 	<-f.readyCh
 
-	// Create the control socket as late as possible to reduce the chances of it
-	// being left behind if the user interrupts the command.
-	control, err := createControlSocket(controlDir, ControlSocketName(tc.cvd, tc.Status()))
-	if err != nil {
-		f.StopForwarding(FwdFailed)
-		tc.webrtcConn.Close()
-		return nil, fmt.Errorf("control socket creation failed for %q: %w", cvd.WebRTCDeviceID, err)
-	}
-	tc.control = control
-
 	return tc, nil
 }
 
@@ -423,25 +680,79 @@ func (tc *ConnController) OnADBDataChannel(dc *webrtc.DataChannel) {
 	tc.adbForwarder.OnDataChannel(dc)
 }
 
+func (tc *ConnController) OnClipboardDataChannel(dc *webrtc.DataChannel) {
+	tc.clipboardSyncer.OnDataChannel(dc)
+}
+
+func (tc *ConnController) OnConsoleDataChannel(dc *webrtc.DataChannel) {
+	tc.console.OnDataChannel(dc)
+}
+
+func (tc *ConnController) OnVideoTrack(track *webrtc.TrackRemote, requestKeyFrame func() error) {
+	if tc.session != nil {
+		tc.session.OnVideoTrack(track, requestKeyFrame)
+		return
+	}
+	tc.recorder.OnVideoTrack(track, requestKeyFrame)
+}
+
 func (tc *ConnController) OnError(err error) {
-	tc.adbForwarder.StopForwarding(FwdFailed)
 	tc.logger.Printf("Error on webrtc connection to %q: %v\n", tc.cvd.WebRTCDeviceID, err)
+	tc.onConnectionLost()
 }
 
 func (tc *ConnController) OnFailure() {
-	tc.adbForwarder.StopForwarding(FwdFailed)
 	tc.logger.Printf("WebRTC connection to %q set to failed state", tc.cvd.WebRTCDeviceID)
+	tc.onConnectionLost()
+}
+
+func (tc *ConnController) onConnectionLost() {
+	tc.stopClipboardSync()
+	tc.stopRecording()
+	if tc.heartbeat == nil {
+		tc.stopTURNRotation()
+		tc.stopConsole()
+		tc.stopStats()
+		tc.stopForwards()
+		tc.adbForwarder.StopForwarding(FwdFailed)
+		return
+	}
+	if tc.console != nil {
+		tc.console.DetachDataChannel()
+	}
+	// Keep the ADB port while reconnecting.
+	tc.adbForwarder.DetachDataChannel()
+	tc.heartbeat.Trigger()
 }
 
 func (tc *ConnController) OnClose() {
+	tc.stopHeartbeat()
+	tc.stopTURNRotation()
+	tc.stopIdle()
+	tc.stopClipboardSync()
+	tc.stopRecording()
+	tc.stopConsole()
+	tc.stopStats()
+	tc.stopForwards()
 	tc.adbForwarder.StopForwarding(FwdStopped)
 	tc.logger.Printf("WebRTC connection to %q closed", tc.cvd.WebRTCDeviceID)
 }
 
 func (tc *ConnController) Stop() {
+	tc.stopHeartbeat()
+	tc.stopTURNRotation()
+	tc.stopIdle()
+	tc.stopClipboardSync()
+	tc.stopRecording()
+	tc.stopConsole()
+	tc.stopStats()
+	tc.stopForwards()
 	tc.adbForwarder.StopForwarding(FwdStopped)
-	// This will cause the control loop to finish.
-	tc.control.Close()
+	// This will cause the control loop to finish. Controllers of a shared agent share its socket
+	// instead.
+	if tc.control != nil {
+		tc.control.Close()
+	}
 }
 
 func (tc *ConnController) ADBPort() int {
@@ -449,8 +760,230 @@ func (tc *ConnController) ADBPort() int {
 }
 
 func (tc *ConnController) Status() ConnStatus {
-	return ConnStatus{
-		ADB: tc.adbForwarder.State(),
+	status := ConnStatus{
+		ADB:           tc.adbForwarder.State(),
+		ClipboardSync: tc.clipboardSyncer != nil && tc.clipboardSyncer.Active(),
+	}
+	if tc.recorder != nil && tc.recorder.Active() {
+		status.Recording = tc.recorder.opts.Path
+	}
+	if tc.session != nil && tc.session.Active() {
+		status.Recording = tc.session.Path()
+	}
+	if tc.console != nil {
+		status.Console = tc.console.Path()
+	}
+	if tc.stats != nil {
+		status.Stats = tc.stats.Summary()
+	}
+	if tc.heartbeat != nil {
+		last := tc.heartbeat.Last()
+		status.LastHeartbeat = &last
+		status.HeartbeatInterval = tc.heartbeat.interval
+	}
+	if tc.adbForwarder.activity != nil {
+		last := tc.adbForwarder.activity.Last()
+		status.LastActivity = &last
+	}
+	if tc.forwards != nil {
+		if forwards := tc.forwards.List(); len(forwards) > 0 {
+			status.Forwards = forwards
+		}
+	}
+	status.HealthProbe = tc.healthProbe
+	status.ADBConnect = tc.adbConnect
+	if tc.turnRotator != nil {
+		if exp := tc.turnRotator.ExpireTime(); !exp.IsZero() {
+			status.TURNCredentialsExpireTime = &exp
+		}
+		if rotations := tc.turnRotator.Rotations(); len(rotations) > 0 {
+			status.TURNRotations = rotations
+		}
+	}
+	return status
+}
+
+func (tc *ConnController) stopForwards() {
+	if tc.forwards != nil {
+		tc.forwards.Close()
+	}
+}
+
+func (tc *ConnController) stopHeartbeat() {
+	if tc.heartbeat != nil {
+		tc.heartbeat.Stop()
+	}
+}
+
+func (tc *ConnController) stopTURNRotation() {
+	if tc.turnRotator != nil {
+		tc.turnRotator.Stop()
+	}
+}
+
+func (tc *ConnController) stopIdle() {
+	if tc.idle != nil {
+		tc.idle.Stop()
+	}
+}
+
+func (tc *ConnController) connection() *wclient.Connection {
+	tc.connMtx.Lock()
+	defer tc.connMtx.Unlock()
+	return tc.webrtcConn
+}
+
+func (tc *ConnController) setConnection(conn *wclient.Connection) {
+	tc.connMtx.Lock()
+	defer tc.connMtx.Unlock()
+	tc.webrtcConn = conn
+}
+
+// Returns the observer of a new connection, the events of any previous connection are ignored
+// from then on.
+func (tc *ConnController) newConnObserver() *connObserver {
+	tc.connMtx.Lock()
+	defer tc.connMtx.Unlock()
+	tc.connGen++
+	return &connObserver{tc: tc, gen: tc.connGen}
+}
+
+func (tc *ConnController) isCurrentConn(gen int) bool {
+	tc.connMtx.Lock()
+	defer tc.connMtx.Unlock()
+	return tc.connGen == gen
+}
+
+// Checks the webrtc connection is up and the device still exists in the host. Querying the host
+// also keeps alive idle connections to it through proxies.
+func (tc *ConnController) checkConnection() error {
+	if conn := tc.connection(); conn == nil || !conn.Connected() {
+		return fmt.Errorf("webrtc connection to %q is not connected", tc.cvd.WebRTCDeviceID)
+	}
+	cvds, err := tc.service.HostService(tc.cvd.Host).ListCVDs()
+	if err != nil {
+		return fmt.Errorf("failed to reach host %q: %w", tc.cvd.Host, err)
+	}
+	for _, cvd := range cvds {
+		if cvd.WebRTCDeviceID == tc.cvd.WebRTCDeviceID {
+			return nil
+		}
+	}
+	return fmt.Errorf("device %q no longer exists in host %q", tc.cvd.WebRTCDeviceID, tc.cvd.Host)
+}
+
+// Replaces the webrtc connection keeping the ADB port and console socket. Clipboard sync and
+// recording don't survive the reconnection, attached console clients are detached.
+func (tc *ConnController) reconnect() error {
+	tc.reconnectMtx.Lock()
+	defer tc.reconnectMtx.Unlock()
+	if s := tc.adbForwarder.State().State; s == StateAsStr(FwdStopped) || s == StateAsStr(FwdFailed) {
+		return fmt.Errorf("ADB forwarding to %q already %s", tc.cvd.WebRTCDeviceID, s)
+	}
+	tc.logger.Printf("Reconnecting to %s in host %s", tc.cvd.WebRTCDeviceID, tc.cvd.Host)
+	tc.stopClipboardSync()
+	tc.stopRecording()
+	tc.adbForwarder.DetachDataChannel()
+	if tc.console != nil {
+		tc.console.DetachDataChannel()
+	}
+	old := tc.connection()
+	observer := tc.newConnObserver()
+	if old != nil {
+		old.Close()
+	}
+	opts := client.ConnectWebRTCOpts{LocalICEConfig: tc.localICEConfig, Console: tc.console != nil}
+	conn, err := tc.service.HostService(tc.cvd.Host).ConnectWebRTC(tc.cvd.WebRTCDeviceID, observer, tc.logger.Writer(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to reconnect to %q: %w", tc.cvd.WebRTCDeviceID, err)
+	}
+	tc.setConnection(conn)
+	tc.turnRotator.Schedule(conn.ICECredentialsExpireTime())
+	return nil
+}
+
+// The old connection is closed by then, heartbeats retry the reconnection.
+func (tc *ConnController) onTURNRotationFailure(err error) {
+	if tc.heartbeat != nil {
+		tc.heartbeat.Trigger()
+		return
+	}
+	tc.onReconnectionFailure(err)
+}
+
+func (tc *ConnController) onReconnectionFailure(err error) {
+	tc.logger.Printf("Giving up on connection to %q: %v", tc.cvd.WebRTCDeviceID, err)
+	tc.stopTURNRotation()
+	tc.stopConsole()
+	tc.stopStats()
+	tc.adbForwarder.StopForwarding(FwdFailed)
+}
+
+func (tc *ConnController) stopClipboardSync() {
+	if tc.clipboardSyncer != nil {
+		tc.clipboardSyncer.Stop()
+	}
+}
+
+func (tc *ConnController) stopConsole() {
+	if tc.console != nil {
+		tc.console.Stop()
+	}
+}
+
+func (tc *ConnController) stopStats() {
+	if tc.stats != nil {
+		tc.stats.Stop()
+	}
+}
+
+func (tc *ConnController) stopRecording() {
+	if tc.recorder != nil {
+		tc.recorder.Stop()
+	}
+	if tc.session != nil {
+		tc.session.Stop()
+	}
+}
+
+// Forwards the events of one of the controller's connections. Only the events of the latest
+// connection are relevant, the other ones were replaced by reconnections.
+type connObserver struct {
+	tc  *ConnController
+	gen int
+}
+
+func (o *connObserver) OnADBDataChannel(dc *webrtc.DataChannel) {
+	o.tc.OnADBDataChannel(dc)
+}
+
+func (o *connObserver) OnClipboardDataChannel(dc *webrtc.DataChannel) {
+	o.tc.OnClipboardDataChannel(dc)
+}
+
+func (o *connObserver) OnConsoleDataChannel(dc *webrtc.DataChannel) {
+	o.tc.OnConsoleDataChannel(dc)
+}
+
+func (o *connObserver) OnVideoTrack(track *webrtc.TrackRemote, requestKeyFrame func() error) {
+	o.tc.OnVideoTrack(track, requestKeyFrame)
+}
+
+func (o *connObserver) OnError(err error) {
+	if o.tc.isCurrentConn(o.gen) {
+		o.tc.OnError(err)
+	}
+}
+
+func (o *connObserver) OnFailure() {
+	if o.tc.isCurrentConn(o.gen) {
+		o.tc.OnFailure()
+	}
+}
+
+func (o *connObserver) OnClose() {
+	if o.tc.isCurrentConn(o.gen) {
+		o.tc.OnClose()
 	}
 }
 
@@ -482,7 +1015,7 @@ func (tc *ConnController) handleControlCommand(conn net.Conn) {
 		tc.logger.Printf("Error reading from control socket connection: %v", err)
 		return
 	}
-	cmd := string(buff[:n])
+	cmd, arg, _ := strings.Cut(string(buff[:n]), "=")
 	switch cmd {
 	case versionCmd:
 		_, err := conn.Write([]byte(fmt.Sprintf("%d", controlSocketCommsVersion)))
@@ -505,6 +1038,10 @@ func (tc *ConnController) handleControlCommand(conn net.Conn) {
 		}
 	case stopCmd:
 		tc.Stop()
+	case stopRecordingCmd:
+		tc.stopRecording()
+	case forwardCmd, unforwardCmd:
+		writeControlCmdRes(conn, handleForwardCmd(tc.forwards, cmd, arg), tc.logger)
 	default:
 		tc.logger.Printf("Unknown command on control socket: %q", cmd)
 	}
@@ -519,7 +1056,8 @@ func sendStatusCmd(conn net.Conn) (StatusCmdRes, error) {
 	if err != nil {
 		return msg, fmt.Errorf("failed to send status command: %w", err)
 	}
-	buff := make([]byte, 512)
+	// Large enough for the responses of shared agents too.
+	buff := make([]byte, 64*1024)
 	n, err := conn.Read(buff)
 	if err != nil {
 		return msg, fmt.Errorf("failed to read status command response: %w", err)
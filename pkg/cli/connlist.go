@@ -0,0 +1,163 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// The listing is a stable schema for tooling, independent of the status exchanged with the
+// agents. Fields are only added, incompatible changes bump the version.
+
+const connListingVersion = 1
+
+// Modes of the connections.
+const (
+	// The connection has an agent of its own.
+	dedicatedConnMode = "dedicated"
+	// The agent is shared by the connections to the devices of the host.
+	sharedConnMode = "shared"
+)
+
+// A connection is stale after missing this many heartbeats.
+const staleHeartbeats = 2
+
+type ListConnectionsFlags struct {
+	*CVDRemoteFlags
+	Host   string
+	Format string
+}
+
+type ConnListing struct {
+	Version     int                `json:"version"`
+	Connections []ListedConnection `json:"connections"`
+}
+
+type ListedConnection struct {
+	CVD  RemoteCVDLocator `json:"cvd"`
+	ADB  ForwarderState   `json:"adb"`
+	Mode string           `json:"mode"`
+	// Whether the last heartbeat is older than `staleHeartbeats` heartbeat intervals, always false
+	// if heartbeats are disabled.
+	Stale         bool       `json:"stale"`
+	ClipboardSync bool       `json:"clipboard_sync"`
+	Recording     string     `json:"recording,omitempty"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	LastActivity  *time.Time `json:"last_activity,omitempty"`
+	Console       string     `json:"console,omitempty"`
+	JumpPath      []string   `json:"jump_path,omitempty"`
+	Proxy         string     `json:"proxy,omitempty"`
+	// Device ports forwarded to local ports.
+	Forwards []PortForward `json:"forwards,omitempty"`
+	// Nil if the connection wasn't probed.
+	HealthProbe *HealthProbeResult `json:"health_probe,omitempty"`
+	// Nil if the agent didn't ask the ADB server to connect.
+	ADBConnect *ADBConnectResult `json:"adb_connect,omitempty"`
+	// Nil if the connection's TURN credentials don't expire.
+	TURNCredentialsExpireTime *time.Time     `json:"turn_credentials_expire_time,omitempty"`
+	TURNRotations             []TURNRotation `json:"turn_rotations,omitempty"`
+	// Nil until sampled.
+	Stats *ListedConnStats `json:"stats,omitempty"`
+}
+
+// See ConnStats.
+type ListedConnStats struct {
+	RTTMs         float64   `json:"rtt_ms"`
+	JitterMs      *float64  `json:"jitter_ms,omitempty"`
+	PacketLossPct *float64  `json:"packet_loss_pct,omitempty"`
+	ReceiveKbps   float64   `json:"receive_kbps"`
+	SendKbps      float64   `json:"send_kbps"`
+	Samples       int       `json:"samples"`
+	SampledAt     time.Time `json:"sampled_at"`
+}
+
+func newConnListing(statuses map[RemoteCVDLocator]ConnStatus, now time.Time) *ConnListing {
+	listing := &ConnListing{Version: connListingVersion, Connections: []ListedConnection{}}
+	for cvd, s := range statuses {
+		c := ListedConnection{
+			CVD:           cvd,
+			ADB:           s.ADB,
+			Mode:          dedicatedConnMode,
+			ClipboardSync: s.ClipboardSync,
+			Recording:     s.Recording,
+			LastHeartbeat: s.LastHeartbeat,
+			LastActivity:  s.LastActivity,
+			Console:       s.Console,
+			JumpPath:      s.JumpPath,
+			Proxy:         s.Proxy,
+			Forwards:      s.Forwards,
+			HealthProbe:   s.HealthProbe,
+			ADBConnect:    s.ADBConnect,
+		}
+		c.TURNCredentialsExpireTime = s.TURNCredentialsExpireTime
+		c.TURNRotations = s.TURNRotations
+		if s.ControlSocket != "" {
+			c.Mode = sharedConnMode
+		}
+		if s.LastHeartbeat != nil && s.HeartbeatInterval > 0 {
+			c.Stale = now.Sub(*s.LastHeartbeat) > staleHeartbeats*s.HeartbeatInterval
+		}
+		if st := s.Stats; st != nil {
+			c.Stats = &ListedConnStats{
+				RTTMs:         st.RTTMs,
+				JitterMs:      st.JitterMs,
+				PacketLossPct: st.PacketLossPct,
+				ReceiveKbps:   st.ReceiveKbps,
+				SendKbps:      st.SendKbps,
+				Samples:       st.Samples,
+				SampledAt:     st.SampledAt,
+			}
+		}
+		listing.Connections = append(listing.Connections, c)
+	}
+	sort.Slice(listing.Connections, func(i, j int) bool {
+		a, b := listing.Connections[i].CVD, listing.Connections[j].CVD
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.WebRTCDeviceID < b.WebRTCDeviceID
+	})
+	return listing
+}
+
+func writeConnListing(w io.Writer, listing *ConnListing) {
+	for _, c := range listing.Connections {
+		adb := c.ADB.State
+		if c.ADB.Port > 0 {
+			adb = fmt.Sprintf("127.0.0.1:%d", c.ADB.Port)
+		}
+		line := fmt.Sprintf("%s/%s: %s, %s", c.CVD.Host, c.CVD.WebRTCDeviceID, adb, c.Mode)
+		if c.Stale {
+			line += ", stale"
+		}
+		if c.LastActivity != nil {
+			line += ", last active " + c.LastActivity.Format(time.RFC3339)
+		}
+		if c.Stats != nil {
+			line += fmt.Sprintf(", RTT %.1f ms", c.Stats.RTTMs)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+func writeConnListingJSON(w io.Writer, listing *ConnListing) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(listing)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewConnListing(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fresh, old := now.Add(-10*time.Second), now.Add(-5*time.Minute)
+	statuses := map[RemoteCVDLocator]ConnStatus{
+		{Host: "foo", WebRTCDeviceID: "cvd-2"}: {
+			ADB:               ForwarderState{Port: 6521, State: "connected"},
+			LastHeartbeat:     &old,
+			HeartbeatInterval: 30 * time.Second,
+			ControlSocket:     "foo.sock",
+		},
+		{Host: "foo", WebRTCDeviceID: "cvd-1"}: {
+			ADB:               ForwarderState{Port: 6520, State: "connected"},
+			LastHeartbeat:     &fresh,
+			HeartbeatInterval: 30 * time.Second,
+			Stats:             &ConnStats{RTTMs: 12.5, Samples: 3, SampledAt: now},
+		},
+	}
+
+	got := newConnListing(statuses, now)
+
+	exp := &ConnListing{
+		Version: connListingVersion,
+		Connections: []ListedConnection{
+			{
+				CVD:           RemoteCVDLocator{Host: "foo", WebRTCDeviceID: "cvd-1"},
+				ADB:           ForwarderState{Port: 6520, State: "connected"},
+				Mode:          dedicatedConnMode,
+				LastHeartbeat: &fresh,
+				Stats:         &ListedConnStats{RTTMs: 12.5, Samples: 3, SampledAt: now},
+			},
+			{
+				CVD:           RemoteCVDLocator{Host: "foo", WebRTCDeviceID: "cvd-2"},
+				ADB:           ForwarderState{Port: 6521, State: "connected"},
+				Mode:          sharedConnMode,
+				Stale:         true,
+				LastHeartbeat: &old,
+			},
+		},
+	}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("listing mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteConnListingJSONEmpty(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	if err := writeConnListingJSON(out, newConnListing(nil, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "{\n  \"version\": 1,\n  \"connections\": []\n}\n"
+	if diff := cmp.Diff(exp, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
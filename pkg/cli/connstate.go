@@ -0,0 +1,128 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+)
+
+// Connections are exported as references to the devices, local details like the ADB port are
+// meaningless in a different machine.
+
+const connStateVersion = 1
+
+type ConnState struct {
+	Version     int                  `json:"version"`
+	Connections []ExportedConnection `json:"connections"`
+}
+
+type ExportedConnection struct {
+	CVD RemoteCVDLocator `json:"cvd"`
+	// Whether the clipboard was being synchronized with the device.
+	ClipboardSync bool `json:"clipboard_sync,omitempty"`
+}
+
+// A device reference that can't be restored.
+type StaleConnection struct {
+	CVD    RemoteCVDLocator
+	Reason string
+}
+
+func (s StaleConnection) String() string {
+	return fmt.Sprintf("%s/%s: %s", s.CVD.Host, s.CVD.WebRTCDeviceID, s.Reason)
+}
+
+func newConnState(statuses map[RemoteCVDLocator]ConnStatus) *ConnState {
+	state := &ConnState{Version: connStateVersion, Connections: []ExportedConnection{}}
+	for cvd, status := range statuses {
+		state.Connections = append(state.Connections, ExportedConnection{
+			CVD:           cvd,
+			ClipboardSync: status.ClipboardSync,
+		})
+	}
+	sort.Slice(state.Connections, func(i, j int) bool {
+		a, b := state.Connections[i].CVD, state.Connections[j].CVD
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.WebRTCDeviceID < b.WebRTCDeviceID
+	})
+	return state
+}
+
+func writeConnState(w io.Writer, state *ConnState) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
+
+func readConnState(path string) (*ConnState, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read connection state: %w", err)
+	}
+	state := &ConnState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("invalid connection state file %q: %w", path, err)
+	}
+	if state.Version != connStateVersion {
+		return nil, fmt.Errorf("unsupported connection state version %d, expected %d", state.Version, connStateVersion)
+	}
+	return state, nil
+}
+
+// Splits the connections into those to devices that still exist in the service and the stale ones.
+func validateConnState(service client.Service, state *ConnState) ([]ExportedConnection, []StaleConnection) {
+	valid := []ExportedConnection{}
+	stale := []StaleConnection{}
+	// Devices by host, listed once per host.
+	devices := make(map[string]map[string]struct{})
+	hostErrs := make(map[string]error)
+	for _, conn := range state.Connections {
+		cvd := conn.CVD
+		if cvd.ServiceRootEndpoint != service.RootURI() {
+			stale = append(stale, StaleConnection{cvd, fmt.Sprintf("belongs to service %s", cvd.ServiceRootEndpoint)})
+			continue
+		}
+		if _, ok := devices[cvd.Host]; !ok && hostErrs[cvd.Host] == nil {
+			cvds, err := service.HostService(cvd.Host).ListCVDs()
+			if err != nil {
+				hostErrs[cvd.Host] = err
+			} else {
+				ids := make(map[string]struct{})
+				for _, c := range cvds {
+					ids[c.WebRTCDeviceID] = struct{}{}
+				}
+				devices[cvd.Host] = ids
+			}
+		}
+		if err := hostErrs[cvd.Host]; err != nil {
+			stale = append(stale, StaleConnection{cvd, fmt.Sprintf("failed to list devices of the host: %v", err)})
+			continue
+		}
+		if _, ok := devices[cvd.Host][cvd.WebRTCDeviceID]; !ok {
+			stale = append(stale, StaleConnection{cvd, "the device no longer exists"})
+			continue
+		}
+		valid = append(valid, conn)
+	}
+	return valid, stale
+}
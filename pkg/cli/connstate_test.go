@@ -0,0 +1,128 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+type importHostService struct {
+	fakeHostService
+	err error
+}
+
+func (s importHostService) ListCVDs() ([]*hoapi.CVD, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []*hoapi.CVD{{Name: "1", WebRTCDeviceID: "cvd-1_1"}}, nil
+}
+
+func (s importHostService) ListCVDDetails() ([]*client.CVDDetails, error) {
+	cvds, err := s.ListCVDs()
+	if err != nil {
+		return nil, err
+	}
+	return []*client.CVDDetails{{CVD: *cvds[0]}}, nil
+}
+
+type importService struct {
+	fakeService
+}
+
+func (importService) HostService(host string) client.HostOrchestratorService {
+	if host == "down" {
+		return &importHostService{err: errors.New("unreachable")}
+	}
+	return &importHostService{}
+}
+
+func TestValidateConnState(t *testing.T) {
+	service := &importService{}
+	locator := func(endpoint, host, device string) RemoteCVDLocator {
+		return RemoteCVDLocator{ServiceRootEndpoint: endpoint, Host: host, WebRTCDeviceID: device}
+	}
+	root := service.RootURI()
+	state := &ConnState{
+		Version: connStateVersion,
+		Connections: []ExportedConnection{
+			{CVD: locator(root, "foo", "cvd-1_1"), ClipboardSync: true},
+			{CVD: locator(root, "foo", "cvd-2_1")},
+			{CVD: locator("http://other.com/v1", "foo", "cvd-1_1")},
+			{CVD: locator(root, "down", "cvd-1_1")},
+		},
+	}
+
+	valid, stale := validateConnState(service, state)
+
+	expValid := []ExportedConnection{{CVD: locator(root, "foo", "cvd-1_1"), ClipboardSync: true}}
+	if diff := cmp.Diff(expValid, valid); diff != "" {
+		t.Errorf("valid connections mismatch (-want +got):\n%s", diff)
+	}
+	expStale := []RemoteCVDLocator{
+		locator(root, "foo", "cvd-2_1"),
+		locator("http://other.com/v1", "foo", "cvd-1_1"),
+		locator(root, "down", "cvd-1_1"),
+	}
+	gotStale := []RemoteCVDLocator{}
+	for _, s := range stale {
+		gotStale = append(gotStale, s.CVD)
+	}
+	if diff := cmp.Diff(expStale, gotStale); diff != "" {
+		t.Errorf("stale connections mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestConnStateRoundTrip(t *testing.T) {
+	cvd := RemoteCVDLocator{ServiceRootEndpoint: "http://foo.com/v1", Host: "foo", WebRTCDeviceID: "cvd-1_1"}
+	state := newConnState(map[RemoteCVDLocator]ConnStatus{cvd: {ClipboardSync: true}})
+	buf := &bytes.Buffer{}
+	if err := writeConnState(buf, state); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readConnState(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(state, got); diff != "" {
+		t.Errorf("state mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadConnStateUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"version": 2, "connections": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readConnState(path); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Time between samples of the webrtc statistics of a connection.
+const connStatsInterval = 2 * time.Second
+
+// Number of samples summarized, a minute's worth.
+const connStatsWindow = 30
+
+type ConnStatsFlags struct {
+	*CVDRemoteFlags
+	Host   string
+	Format string
+	Watch  WatchOpts
+}
+
+// Summary of the latest samples of the webrtc statistics of a connection.
+type ConnStats struct {
+	// Average round trip time of the selected candidate pair.
+	RTTMs float64
+	// Average jitter of the media received from the device, nil if no media is received.
+	JitterMs *float64 `json:",omitempty"`
+	// Of the media packets received from the device, nil if no media is received.
+	PacketLossPct *float64 `json:",omitempty"`
+	ReceiveKbps   float64
+	SendKbps      float64
+	// Number of samples summarized.
+	Samples   int
+	SampledAt time.Time
+}
+
+type connStatsSample struct {
+	at time.Time
+	// In seconds, zero if not measured yet.
+	rtt           float64
+	bytesSent     uint64
+	bytesReceived uint64
+	// Whether the device sends media, the rest of the fields are only relevant then.
+	media           bool
+	jitter          float64
+	packetsReceived uint64
+	packetsLost     int64
+}
+
+func sampleConnStats(report webrtc.StatsReport, at time.Time) connStatsSample {
+	s := connStatsSample{at: at}
+	for _, stats := range report {
+		switch st := stats.(type) {
+		case webrtc.TransportStats:
+			s.bytesSent += st.BytesSent
+			s.bytesReceived += st.BytesReceived
+		case webrtc.ICECandidatePairStats:
+			if st.Nominated && st.State == webrtc.StatsICECandidatePairStateSucceeded && st.CurrentRoundTripTime > 0 {
+				s.rtt = st.CurrentRoundTripTime
+			}
+		case webrtc.InboundRTPStreamStats:
+			s.media = true
+			s.packetsReceived += uint64(st.PacketsReceived)
+			s.packetsLost += int64(st.PacketsLost)
+			if st.Jitter > s.jitter {
+				s.jitter = st.Jitter
+			}
+		}
+	}
+	return s
+}
+
+// Samples the statistics of a connection periodically, keeping the latest connStatsWindow samples.
+type connStatsCollector struct {
+	interval time.Duration
+	// Returns false if there is no connection to sample.
+	sample func() (webrtc.StatsReport, bool)
+
+	mtx      sync.Mutex
+	samples  []connStatsSample
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func newConnStatsCollector(interval time.Duration, sample func() (webrtc.StatsReport, bool)) *connStatsCollector {
+	return &connStatsCollector{
+		interval: interval,
+		sample:   sample,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+func (c *connStatsCollector) Run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			if report, ok := c.sample(); ok {
+				c.add(sampleConnStats(report, time.Now()))
+			}
+		}
+	}
+}
+
+func (c *connStatsCollector) Stop() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
+
+func (c *connStatsCollector) add(s connStatsSample) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if n := len(c.samples); n > 0 {
+		last := c.samples[n-1]
+		// The counters start over when the connection is replaced.
+		if s.bytesSent < last.bytesSent || s.bytesReceived < last.bytesReceived || s.packetsReceived < last.packetsReceived {
+			c.samples = nil
+		}
+	}
+	c.samples = append(c.samples, s)
+	if len(c.samples) > connStatsWindow {
+		c.samples = c.samples[len(c.samples)-connStatsWindow:]
+	}
+}
+
+// Returns nil until the first sample is taken.
+func (c *connStatsCollector) Summary() *ConnStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.samples) == 0 {
+		return nil
+	}
+	first, last := c.samples[0], c.samples[len(c.samples)-1]
+	result := &ConnStats{Samples: len(c.samples), SampledAt: last.at}
+	var rtt, jitter float64
+	measured := 0
+	for _, s := range c.samples {
+		if s.rtt > 0 {
+			rtt += s.rtt
+			measured++
+		}
+		jitter += s.jitter
+	}
+	if measured > 0 {
+		result.RTTMs = rtt / float64(measured) * 1000
+	}
+	if secs := last.at.Sub(first.at).Seconds(); secs > 0 {
+		result.ReceiveKbps = float64(last.bytesReceived-first.bytesReceived) * 8 / 1000 / secs
+		result.SendKbps = float64(last.bytesSent-first.bytesSent) * 8 / 1000 / secs
+	}
+	if last.media {
+		jitterMs := jitter / float64(len(c.samples)) * 1000
+		result.JitterMs = &jitterMs
+		lossPct := 0.0
+		lost := last.packetsLost - first.packetsLost
+		if total := float64(lost) + float64(last.packetsReceived-first.packetsReceived); lost > 0 && total > 0 {
+			lossPct = float64(lost) / total * 100
+		}
+		result.PacketLossPct = &lossPct
+	}
+	return result
+}
+
+func writeConnStats(w io.Writer, cvd RemoteCVDLocator, stats *ConnStats) {
+	fmt.Fprintf(w, "%s/%s\n", cvd.Host, cvd.WebRTCDeviceID)
+	if stats == nil {
+		fmt.Fprintln(w, "  No statistics sampled yet")
+		return
+	}
+	optional := func(v *float64, format string) string {
+		if v == nil {
+			return "n/a, no media received"
+		}
+		return fmt.Sprintf(format, *v)
+	}
+	fmt.Fprintf(w, "  RTT:         %.1f ms\n", stats.RTTMs)
+	fmt.Fprintf(w, "  Jitter:      %s\n", optional(stats.JitterMs, "%.1f ms"))
+	fmt.Fprintf(w, "  Packet loss: %s\n", optional(stats.PacketLossPct, "%.2f%%"))
+	fmt.Fprintf(w, "  Receiving:   %.1f kbps\n", stats.ReceiveKbps)
+	fmt.Fprintf(w, "  Sending:     %.1f kbps\n", stats.SendKbps)
+	fmt.Fprintf(w, "  Over the last %d samples, at %s\n", stats.Samples, stats.SampledAt.Format(time.RFC3339))
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func statsReport(rtt float64, sent, received uint64, packets uint32, lost int32) webrtc.StatsReport {
+	report := webrtc.StatsReport{
+		"iceTransport": webrtc.TransportStats{Type: webrtc.StatsTypeTransport, BytesSent: sent, BytesReceived: received},
+		"pair": webrtc.ICECandidatePairStats{
+			Type:                 webrtc.StatsTypeCandidatePair,
+			Nominated:            true,
+			State:                webrtc.StatsICECandidatePairStateSucceeded,
+			CurrentRoundTripTime: rtt,
+		},
+	}
+	if packets > 0 {
+		report["video"] = webrtc.InboundRTPStreamStats{Type: webrtc.StatsTypeInboundRTP, PacketsReceived: packets, PacketsLost: lost, Jitter: 0.004}
+	}
+	return report
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestConnStatsSummary(t *testing.T) {
+	c := newConnStatsCollector(time.Second, nil)
+	start := time.Now()
+	c.add(sampleConnStats(statsReport(0.020, 1000, 10000, 100, 0), start))
+	c.add(sampleConnStats(statsReport(0.040, 2000, 60000, 190, 10), start.Add(2*time.Second)))
+
+	stats := c.Summary()
+
+	if stats.Samples != 2 {
+		t.Errorf("expected 2 samples, got: %d", stats.Samples)
+	}
+	if !approxEqual(stats.RTTMs, 30) {
+		t.Errorf("expected 30ms RTT, got: %v", stats.RTTMs)
+	}
+	if !approxEqual(stats.ReceiveKbps, 200) || !approxEqual(stats.SendKbps, 4) {
+		t.Errorf("expected 200kbps received and 4kbps sent, got: %v and %v", stats.ReceiveKbps, stats.SendKbps)
+	}
+	if stats.JitterMs == nil || !approxEqual(*stats.JitterMs, 4) {
+		t.Errorf("expected 4ms jitter, got: %v", stats.JitterMs)
+	}
+	if stats.PacketLossPct == nil || !approxEqual(*stats.PacketLossPct, 10) {
+		t.Errorf("expected 10%% packet loss, got: %v", stats.PacketLossPct)
+	}
+}
+
+func TestConnStatsSummaryWithoutMedia(t *testing.T) {
+	c := newConnStatsCollector(time.Second, nil)
+
+	if stats := c.Summary(); stats != nil {
+		t.Errorf("expected no summary before sampling, got: %+v", stats)
+	}
+
+	c.add(sampleConnStats(statsReport(0.020, 1000, 10000, 0, 0), time.Now()))
+	stats := c.Summary()
+
+	if stats.JitterMs != nil || stats.PacketLossPct != nil {
+		t.Errorf("expected no media statistics, got: %+v", stats)
+	}
+}
+
+func TestConnStatsStartOverOnReconnection(t *testing.T) {
+	c := newConnStatsCollector(time.Second, nil)
+	start := time.Now()
+	c.add(sampleConnStats(statsReport(0.020, 5000, 50000, 0, 0), start))
+	c.add(sampleConnStats(statsReport(0.020, 6000, 60000, 0, 0), start.Add(time.Second)))
+	// A new connection's counters start from zero.
+	c.add(sampleConnStats(statsReport(0.020, 100, 1000, 0, 0), start.Add(2*time.Second)))
+
+	stats := c.Summary()
+
+	if stats.Samples != 1 {
+		t.Errorf("expected the samples of the previous connection to be dropped, got: %d samples", stats.Samples)
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"golang.org/x/term"
+)
+
+type AttachFlags struct {
+	*CVDRemoteFlags
+	Host string
+}
+
+// The connection agents expose the device's serial console in a socket of their own, which the
+// attach command connects to. Each message in the socket starts with its type, followed by the
+// console data, the terminal size as JSON or an error description.
+const (
+	consoleDataMsg   byte = 'd'
+	consoleResizeMsg byte = 'r'
+	consoleErrorMsg  byte = 'e'
+)
+
+// Ctrl-], the same as telnet's escape character.
+const consoleDetachKey byte = 0x1d
+
+// Time an attached client waits for the device to open the console data channel.
+const consoleOpenTimeout = 5 * time.Second
+
+// Large enough for a full data channel message.
+const maxConsoleMsgLen = 64 * 1024
+
+type consoleSize struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+}
+
+// Sent to the device as text messages in the console data channel, data is sent as binary.
+type consoleControlMsg struct {
+	Type string `json:"type"`
+	consoleSize
+}
+
+func consoleDir(controlDir string) string {
+	return filepath.Join(controlDir, "console")
+}
+
+// Named after the ADB port, like the control sockets.
+func consoleSocketPath(controlDir string, port int) string {
+	return filepath.Join(consoleDir(controlDir), fmt.Sprintf("%d.sock", port))
+}
+
+// Forwards the device's console to the client attached to the console socket, one at a time.
+type ConsoleForwarder struct {
+	path     string
+	listener *net.UnixListener
+	logger   *log.Logger
+	// Nil if the activity isn't tracked.
+	activity *connActivity
+
+	mtx    sync.Mutex
+	dc     *webrtc.DataChannel
+	open   bool
+	client net.Conn
+}
+
+func NewConsoleForwarder(path string, logger *log.Logger) (*ConsoleForwarder, error) {
+	listener, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create console socket: %w", err)
+	}
+	listener.SetUnlinkOnClose(true)
+	f := &ConsoleForwarder{path: path, listener: listener, logger: logger}
+	go f.acceptLoop()
+	return f, nil
+}
+
+func (f *ConsoleForwarder) OnDataChannel(dc *webrtc.DataChannel) {
+	f.mtx.Lock()
+	f.dc = dc
+	f.mtx.Unlock()
+	dc.OnOpen(func() {
+		f.logger.Printf("Console data channel changed state: %v\n", dc.ReadyState())
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		if f.dc == dc {
+			f.open = true
+		}
+	})
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if msg.IsString {
+			// The device doesn't send control messages.
+			return
+		}
+		f.activity.Touch()
+		if client := f.attached(); client != nil {
+			if _, err := client.Write(append([]byte{consoleDataMsg}, msg.Data...)); err != nil {
+				f.logger.Printf("Error writing to the attached console client: %v", err)
+			}
+		}
+	})
+	dc.OnClose(func() {
+		f.logger.Printf("Console data channel changed state: %v\n", dc.ReadyState())
+		f.mtx.Lock()
+		current := f.dc == dc
+		f.mtx.Unlock()
+		if current {
+			f.DetachDataChannel()
+		}
+	})
+}
+
+// Whether the device's console is open.
+func (f *ConsoleForwarder) Active() bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.open
+}
+
+func (f *ConsoleForwarder) Path() string {
+	return f.path
+}
+
+// Detaches the client, the socket keeps accepting clients for the next data channel.
+func (f *ConsoleForwarder) DetachDataChannel() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.dc = nil
+	f.open = false
+	if f.client != nil {
+		f.client.Write(append([]byte{consoleErrorMsg}, "connection to the device lost"...))
+		f.client.Close()
+		f.client = nil
+	}
+}
+
+func (f *ConsoleForwarder) Stop() {
+	f.DetachDataChannel()
+	f.listener.Close()
+}
+
+func (f *ConsoleForwarder) attached() net.Conn {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.client
+}
+
+func (f *ConsoleForwarder) dataChannel() *webrtc.DataChannel {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if !f.open {
+		return nil
+	}
+	return f.dc
+}
+
+func (f *ConsoleForwarder) acceptLoop() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				f.logger.Printf("Error accepting connection on console socket: %v", err)
+			}
+			return
+		}
+		go f.serve(conn)
+	}
+}
+
+func (f *ConsoleForwarder) reject(conn net.Conn, reason string) {
+	conn.Write(append([]byte{consoleErrorMsg}, reason...))
+	conn.Close()
+}
+
+func (f *ConsoleForwarder) serve(conn net.Conn) {
+	deadline := time.Now().Add(consoleOpenTimeout)
+	for f.dataChannel() == nil {
+		if time.Now().After(deadline) {
+			f.reject(conn, "the device didn't open its console")
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	f.mtx.Lock()
+	if f.client != nil {
+		f.mtx.Unlock()
+		f.reject(conn, "another client is attached to the console")
+		return
+	}
+	f.client = conn
+	f.mtx.Unlock()
+	defer func() {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		if f.client == conn {
+			f.client = nil
+		}
+		conn.Close()
+	}()
+	buff := make([]byte, maxConsoleMsgLen)
+	for {
+		n, err := conn.Read(buff)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				f.logger.Printf("Error reading from the attached console client: %v", err)
+			}
+			return
+		}
+		if n == 0 {
+			// Clients don't send empty messages, the socket was closed.
+			return
+		}
+		dc := f.dataChannel()
+		if dc == nil {
+			return
+		}
+		switch buff[0] {
+		case consoleDataMsg:
+			f.activity.Touch()
+			err = dc.Send(buff[1:n])
+		case consoleResizeMsg:
+			var size consoleSize
+			if err := json.Unmarshal(buff[1:n], &size); err != nil {
+				f.logger.Printf("Invalid console resize message: %v", err)
+				continue
+			}
+			var msg []byte
+			if msg, err = json.Marshal(consoleControlMsg{Type: "resize", consoleSize: size}); err == nil {
+				err = dc.SendText(string(msg))
+			}
+		default:
+			f.logger.Printf("Unknown console message type: %q", buff[0])
+		}
+		if err != nil {
+			f.logger.Printf("Failed to send to the console data channel: %v", err)
+			return
+		}
+	}
+}
+
+// Returns the input before the detach key and whether the key was found.
+func splitConsoleDetach(b []byte) ([]byte, bool) {
+	if i := bytes.IndexByte(b, consoleDetachKey); i >= 0 {
+		return b[:i], true
+	}
+	return b, false
+}
+
+func sendConsoleSize(conn net.Conn, fd int) error {
+	cols, rows, err := term.GetSize(fd)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(consoleSize{Rows: rows, Cols: cols})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append([]byte{consoleResizeMsg}, msg...))
+	return err
+}
+
+// Attaches the terminal to the console served in the socket until the detach key is pressed or
+// the agent closes the console. Terminals are put in raw mode so that every key reaches the
+// device, resizes are forwarded to it.
+func attachConsole(socketPath string, in *os.File, out io.Writer) error {
+	conn, err := net.Dial("unixpacket", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the console socket: %w", err)
+	}
+	defer conn.Close()
+	doneCh := make(chan error, 2)
+	fd := int(in.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to set the terminal in raw mode: %w", err)
+		}
+		defer term.Restore(fd, state)
+		if err := sendConsoleSize(conn, fd); err != nil {
+			return fmt.Errorf("failed to send the terminal size: %w", err)
+		}
+		winchCh := make(chan os.Signal, 1)
+		signal.Notify(winchCh, syscall.SIGWINCH)
+		defer signal.Stop(winchCh)
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go func() {
+			for {
+				select {
+				case <-winchCh:
+					if err := sendConsoleSize(conn, fd); err != nil {
+						doneCh <- fmt.Errorf("failed to send the terminal size: %w", err)
+						return
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		buff := make([]byte, maxConsoleMsgLen)
+		for {
+			n, err := conn.Read(buff)
+			if err != nil || n == 0 {
+				doneCh <- errors.New("console closed by the connection agent")
+				return
+			}
+			switch buff[0] {
+			case consoleDataMsg:
+				if _, err := out.Write(buff[1:n]); err != nil {
+					doneCh <- err
+					return
+				}
+			case consoleErrorMsg:
+				doneCh <- errors.New(string(buff[1:n]))
+				return
+			}
+		}
+	}()
+	go func() {
+		// Left blocked reading the input after detaching, until the process exits.
+		buff := make([]byte, 4096)
+		for {
+			n, err := in.Read(buff)
+			data, detach := splitConsoleDetach(buff[:n])
+			if len(data) > 0 {
+				if _, err := conn.Write(append([]byte{consoleDataMsg}, data...)); err != nil {
+					doneCh <- fmt.Errorf("failed to send input to the console: %w", err)
+					return
+				}
+			}
+			if detach || err != nil {
+				doneCh <- nil
+				return
+			}
+		}
+	}()
+	return <-doneCh
+}
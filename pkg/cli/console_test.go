@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitConsoleDetach(t *testing.T) {
+	tests := []struct {
+		in     string
+		data   string
+		detach bool
+	}{
+		{"ls -l\r", "ls -l\r", false},
+		{"\x1d", "", true},
+		{"ab\x1dcd", "ab", true},
+		{"", "", false},
+	}
+	for _, tc := range tests {
+		data, detach := splitConsoleDetach([]byte(tc.in))
+		if string(data) != tc.data || detach != tc.detach {
+			t.Errorf("splitConsoleDetach(%q) = %q, %t, expected %q, %t", tc.in, data, detach, tc.data, tc.detach)
+		}
+	}
+}
+
+func TestAttachConsole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.sock")
+	l, err := net.Listen("unixpacket", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(append([]byte{consoleDataMsg}, "login: "...))
+		buff := make([]byte, 100)
+		n, _ := conn.Read(buff)
+		received <- buff[:n]
+		// Keep the console open until the client detaches.
+		conn.Read(buff)
+	}()
+	in, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	defer w.Close()
+	if _, err := w.Write([]byte("root\r\x1dignored")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = attachConsole(path, in, io.Discard)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; !bytes.Equal(got, append([]byte{consoleDataMsg}, "root\r"...)) {
+		t.Errorf("expected the input up to the detach key, got: %q", got)
+	}
+}
+
+func TestAttachConsoleReportsAgentErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.sock")
+	l, err := net.Listen("unixpacket", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(append([]byte{consoleErrorMsg}, "another client is attached to the console"...))
+	}()
+	in, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	defer w.Close()
+
+	err = attachConsole(path, in, io.Discard)
+
+	if err == nil || err.Error() != "another client is attached to the console" {
+		t.Errorf("expected the agent's error, got: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+)
+
+type CreateEventKind int
+
+const (
+	CreateEventStarted CreateEventKind = iota
+	CreateEventDone
+	CreateEventWarning
+	// Progress of the step last started, sent periodically while the step runs.
+	CreateEventProgress
+)
+
+// Progress of a CVD creation, emitted at the boundaries of each step.
+type CreateEvent struct {
+	Kind CreateEventKind
+	// The create phase the step belongs to, one of: upload, fetch, create or boot. Empty for steps
+	// outside the phases, like recording an upload.
+	Phase string
+	// Human readable description of the step or the warning. Events of the create and boot phases
+	// have no description, they refine the enclosing "waiting for boot" step.
+	Msg string
+	// Set in done events of failed steps.
+	Err error
+	// Set in progress events of the fetch phase, one item per build being fetched.
+	Fetches []BuildFetchProgress
+}
+
+// A CVD creation running in the background.
+type CreateCVDStream struct {
+	// Closed once the creation finishes.
+	Events <-chan CreateEvent
+
+	done chan struct{}
+	cvds []*RemoteCVD
+	err  error
+}
+
+// Blocks until the creation finishes, the events must be consumed for it to make progress.
+func (s *CreateCVDStream) Wait() ([]*RemoteCVD, error) {
+	<-s.done
+	return s.cvds, s.err
+}
+
+func createCVDStream(service client.Service, createOpts CreateCVDOpts) *CreateCVDStream {
+	events := make(chan CreateEvent)
+	s := &CreateCVDStream{Events: events, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		defer close(events)
+		s.cvds, s.err = runCreateCVD(service, createOpts, func(e CreateEvent) { events <- e })
+	}()
+	return s
+}
+
+func createCVD(service client.Service, createOpts CreateCVDOpts, statePrinter *statePrinter) ([]*RemoteCVD, error) {
+	s := createCVDStream(service, createOpts)
+	for e := range s.Events {
+		printCreateEvent(statePrinter, e)
+	}
+	return s.Wait()
+}
+
+func printCreateEvent(p *statePrinter, e CreateEvent) {
+	if e.Msg == "" {
+		return
+	}
+	if p.mode == progressJSON {
+		p.printJSON(newProgressEvent(e))
+		return
+	}
+	switch e.Kind {
+	case CreateEventStarted:
+		p.Print(e.Msg)
+	case CreateEventDone:
+		p.PrintDone(e.Msg, e.Err)
+	case CreateEventWarning:
+		fmt.Fprintf(p.Out, "Warning: %s\n", e.Msg)
+	case CreateEventProgress:
+		lines := []string{}
+		for _, f := range e.Fetches {
+			lines = append(lines, fetchProgressLine(f))
+		}
+		p.PrintProgress(e.Msg, fetchProgressSummary(e.Fetches), lines)
+	}
+}
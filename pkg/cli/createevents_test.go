@@ -0,0 +1,65 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+func TestCreateCVDStreamEvents(t *testing.T) {
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		MainBuild:                 hoapi.AndroidCIBuild{BuildID: "123", Target: "aosp_cf_x86_64_phone-userdebug"},
+		NumInstances:              1,
+		BuildAPICredentialsSource: NoneCredentialsSource,
+	}
+
+	s := createCVDStream(&fakeService{}, opts)
+
+	got := []CreateEvent{}
+	for e := range s.Events {
+		got = append(got, e)
+	}
+	cvds, err := s.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cvds) != 1 {
+		t.Errorf("expected 1 cvd, got %d", len(cvds))
+	}
+	exp := []CreateEvent{
+		{Kind: CreateEventStarted, Phase: fetchPhase, Msg: stateMsgFetchMainBundle},
+		{
+			Kind:    CreateEventProgress,
+			Phase:   fetchPhase,
+			Msg:     stateMsgFetchMainBundle,
+			Fetches: []BuildFetchProgress{{Build: "main", Done: true}},
+		},
+		{Kind: CreateEventDone, Phase: fetchPhase, Msg: stateMsgFetchMainBundle},
+		{Kind: CreateEventStarted, Msg: stateMsgStartCVD},
+		{Kind: CreateEventStarted, Phase: createPhase},
+		{Kind: CreateEventDone, Phase: createPhase},
+		{Kind: CreateEventStarted, Phase: bootPhase},
+		{Kind: CreateEventDone, Phase: bootPhase},
+		{Kind: CreateEventDone, Msg: stateMsgStartCVD},
+	}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
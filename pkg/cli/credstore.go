@@ -0,0 +1,429 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// Returned by stores that can't be written, like the "env" backend.
+var ErrReadOnlyCredentialStore = errors.New("credential store is read only")
+
+// Stores credentials, like OAuth2 tokens, out of reach of plaintext readers. Every secret cvdr
+// uses goes through it when configured: the OIDC token and the Build API credentials.
+type CredentialStore interface {
+	// Returns ErrCredentialNotFound if there is no credential stored under the given key.
+	Get(key string) ([]byte, error)
+
+	Set(key string, value []byte) error
+
+	Delete(key string) error
+}
+
+const (
+	AutoCredentialStoreBackend    = "auto"
+	KeyringCredentialStoreBackend = "keyring"
+	FileCredentialStoreBackend    = "file"
+	EnvCredentialStoreBackend     = "env"
+)
+
+// Creates the store of a custom backend from its configuration.
+type CredentialStoreFactory func(config *CredentialStoreConfig) (CredentialStore, error)
+
+var (
+	customCredentialStoresMtx sync.Mutex
+	customCredentialStores    = map[string]CredentialStoreFactory{}
+)
+
+// Registers a custom credential store backend, e.g. one backed by Vault, selected by setting the
+// backend to `name` in the credential store configuration. Builtin backends can't be replaced.
+func RegisterCredentialStoreBackend(name string, factory CredentialStoreFactory) error {
+	switch name {
+	case "", AutoCredentialStoreBackend, KeyringCredentialStoreBackend, FileCredentialStoreBackend, EnvCredentialStoreBackend:
+		return fmt.Errorf("credential store backend name %q is reserved", name)
+	}
+	customCredentialStoresMtx.Lock()
+	defer customCredentialStoresMtx.Unlock()
+	if _, ok := customCredentialStores[name]; ok {
+		return fmt.Errorf("credential store backend %q already registered", name)
+	}
+	customCredentialStores[name] = factory
+	return nil
+}
+
+func customCredentialStore(name string) (CredentialStoreFactory, bool) {
+	customCredentialStoresMtx.Lock()
+	defer customCredentialStoresMtx.Unlock()
+	f, ok := customCredentialStores[name]
+	return f, ok
+}
+
+// Returns the passphrase protecting the encrypted file credential store.
+type PassphraseSource func() (string, error)
+
+// The "file" backend keeps the credentials encrypted in `filePath`.
+func NewCredentialStore(config *CredentialStoreConfig, filePath string, passphrase PassphraseSource) (CredentialStore, error) {
+	fileStore := func() CredentialStore {
+		return &encryptedFileCredentialStore{Path: filePath, Passphrase: passphrase}
+	}
+	switch config.Backend {
+	case "", AutoCredentialStoreBackend:
+		if keyring, err := newKeyringCredentialStore(); err == nil {
+			return keyring, nil
+		}
+		return fileStore(), nil
+	case KeyringCredentialStoreBackend:
+		return newKeyringCredentialStore()
+	case FileCredentialStoreBackend:
+		return fileStore(), nil
+	case EnvCredentialStoreBackend:
+		return &envCredentialStore{LookupEnv: os.LookupEnv}, nil
+	default:
+		if factory, ok := customCredentialStore(config.Backend); ok {
+			return factory(config)
+		}
+		return nil, fmt.Errorf("unknown credential store backend: %q", config.Backend)
+	}
+}
+
+const envVarCredentialPrefix = "CVDR_CREDENTIAL_"
+
+// Read only credential store taking the credentials from environment variables, the credential
+// under the "oidc_token" key is read from CVDR_CREDENTIAL_OIDC_TOKEN. Meant for CI environments
+// injecting the secrets.
+type envCredentialStore struct {
+	LookupEnv func(key string) (string, bool)
+}
+
+func envVarCredentialName(key string) string {
+	return envVarCredentialPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+func (s *envCredentialStore) Get(key string) ([]byte, error) {
+	value, ok := s.LookupEnv(envVarCredentialName(key))
+	if !ok || value == "" {
+		return nil, ErrCredentialNotFound
+	}
+	return []byte(value), nil
+}
+
+func (s *envCredentialStore) Set(key string, value []byte) error {
+	return fmt.Errorf("failed setting %s: %w", envVarCredentialName(key), ErrReadOnlyCredentialStore)
+}
+
+func (s *envCredentialStore) Delete(key string) error {
+	return fmt.Errorf("failed deleting %s: %w", envVarCredentialName(key), ErrReadOnlyCredentialStore)
+}
+
+const keyringServiceName = "cvdr"
+
+// Credential store backed by the OS keyring. It relies on the `security` tool on macOS and the
+// `secret-tool` tool, from the Linux Secret Service, on Linux. The Windows Credential Manager isn't
+// supported, its `cmdkey` tool can't read the secrets back.
+type keyringCredentialStore struct {
+	get func(key string) *exec.Cmd
+	set func(key string, value []byte) *exec.Cmd
+	del func(key string) *exec.Cmd
+	// Whether the lookup failed because there is no item under the key.
+	isNotFound func(exitErr *exec.ExitError) bool
+}
+
+// Exit code of the `security` tool when the item is not found, errSecItemNotFound.
+const securityItemNotFoundExitCode = 44
+
+// Quotes the argument of a command read by `security -i`.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func newKeyringCredentialStore() (*keyringCredentialStore, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := exec.LookPath("security"); err != nil {
+			return nil, fmt.Errorf("os keyring not available: %w", err)
+		}
+		return &keyringCredentialStore{
+			get: func(key string) *exec.Cmd {
+				return exec.Command("security", "find-generic-password", "-s", keyringServiceName, "-a", key, "-w")
+			},
+			set: func(key string, value []byte) *exec.Cmd {
+				// The command is read from stdin by the interactive mode to keep the secret out of
+				// the process arguments. The secret is given in hex to keep line breaks in it.
+				cmd := exec.Command("security", "-i")
+				cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+					securityQuote(keyringServiceName), securityQuote(key), hex.EncodeToString(value)))
+				return cmd
+			},
+			del: func(key string) *exec.Cmd {
+				return exec.Command("security", "delete-generic-password", "-s", keyringServiceName, "-a", key)
+			},
+			isNotFound: func(exitErr *exec.ExitError) bool {
+				return exitErr.ExitCode() == securityItemNotFoundExitCode
+			},
+		}, nil
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, fmt.Errorf("os keyring not available: %w", err)
+		}
+		return &keyringCredentialStore{
+			get: func(key string) *exec.Cmd {
+				return exec.Command("secret-tool", "lookup", "service", keyringServiceName, "key", key)
+			},
+			set: func(key string, value []byte) *exec.Cmd {
+				cmd := exec.Command("secret-tool", "store", "--label="+keyringServiceName+" "+key,
+					"service", keyringServiceName, "key", key)
+				// The secret is read from stdin to keep it out of the process arguments.
+				cmd.Stdin = bytes.NewReader(value)
+				return cmd
+			},
+			del: func(key string) *exec.Cmd {
+				return exec.Command("secret-tool", "clear", "service", keyringServiceName, "key", key)
+			},
+			// secret-tool exits with 1 silently when the item is not found, and reports any other
+			// failure in stderr.
+			isNotFound: func(exitErr *exec.ExitError) bool {
+				return exitErr.ExitCode() == 1 && len(bytes.TrimSpace(exitErr.Stderr)) == 0
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("os keyring not supported on %s, use the %q backend", runtime.GOOS, FileCredentialStoreBackend)
+	}
+}
+
+func (s *keyringCredentialStore) Get(key string) ([]byte, error) {
+	out, err := s.get(key).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && s.isNotFound(exitErr) {
+			return nil, ErrCredentialNotFound
+		}
+		return nil, fmt.Errorf("failed reading credential from os keyring: %w", err)
+	}
+	value := bytes.TrimSuffix(out, []byte("\n"))
+	if len(value) == 0 {
+		return nil, ErrCredentialNotFound
+	}
+	return value, nil
+}
+
+func (s *keyringCredentialStore) Set(key string, value []byte) error {
+	out, err := s.set(key, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed writing credential to os keyring: %w: %s", err, string(out))
+	}
+	// The interactive mode of `security` keeps a zero exit code when the command fails, both tools
+	// print nothing on success.
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("failed writing credential to os keyring: %s", msg)
+	}
+	return nil
+}
+
+func (s *keyringCredentialStore) Delete(key string) error {
+	if out, err := s.del(key).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed deleting credential from os keyring: %w: %s", err, string(out))
+	}
+	return nil
+}
+
+// Credential store backed by a file encrypted with AES-GCM. The key is derived from a passphrase
+// with scrypt.
+type encryptedFileCredentialStore struct {
+	Path       string
+	Passphrase PassphraseSource
+}
+
+type encryptedFile struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+func (s *encryptedFileCredentialStore) Get(key string) ([]byte, error) {
+	creds, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := creds[key]
+	if !ok {
+		return nil, ErrCredentialNotFound
+	}
+	return value, nil
+}
+
+func (s *encryptedFileCredentialStore) Set(key string, value []byte) error {
+	creds, err := s.load()
+	if err != nil {
+		return err
+	}
+	creds[key] = value
+	return s.save(creds)
+}
+
+func (s *encryptedFileCredentialStore) Delete(key string) error {
+	creds, err := s.load()
+	if err != nil {
+		return err
+	}
+	delete(creds, key)
+	return s.save(creds)
+}
+
+func (s *encryptedFileCredentialStore) load() (map[string][]byte, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string][]byte), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading credential store: %w", err)
+	}
+	var f encryptedFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid credential store file: %w", err)
+	}
+	aead, err := s.cipher(f.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return nil, errors.New("failed decrypting credential store: wrong passphrase or corrupted file")
+	}
+	creds := make(map[string][]byte)
+	if err := json.NewDecoder(bytes.NewReader(plaintext)).Decode(&creds); err != nil {
+		return nil, fmt.Errorf("invalid credential store content: %w", err)
+	}
+	return creds, nil
+}
+
+func (s *encryptedFileCredentialStore) save(creds map[string][]byte) error {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	f := encryptedFile{Salt: make([]byte, 16)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	aead, err := s.cipher(f.Salt)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Data = aead.Seal(nil, f.Nonce, plaintext, nil)
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("failed creating credential store directory: %w", err)
+	}
+	if err := os.WriteFile(s.Path, b, 0600); err != nil {
+		return fmt.Errorf("failed writing credential store: %w", err)
+	}
+	return nil
+}
+
+func (s *encryptedFileCredentialStore) cipher(salt []byte) (cipher.AEAD, error) {
+	passphrase, err := s.Passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting credential store passphrase: %w", err)
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+const oidcTokenCredentialKey = "oidc_token"
+
+// The Build API credentials of the "adc" credentials source are looked up in the store before the
+// application default credentials: an OAuth2 access token, i.e: minted by a CI system, or the JSON
+// of a credentials file like the ones GOOGLE_APPLICATION_CREDENTIALS points to.
+const (
+	buildAPITokenCredentialKey = "build_api_token"
+	adcCredentialKey           = "adc_credentials"
+)
+
+// Every token file has its own key, profiles using different token files don't overwrite each
+// other's tokens.
+func oidcTokenCredentialKeyOf(tokenFile string) string {
+	if abs, err := filepath.Abs(tokenFile); err == nil {
+		tokenFile = abs
+	}
+	sum := sha256.Sum256([]byte(tokenFile))
+	return oidcTokenCredentialKey + "_" + hex.EncodeToString(sum[:8])
+}
+
+// Loads the OIDC token from the credential store. A token found in the plaintext token file is
+// migrated into the store, and the plaintext file removed, the first time. Read only stores use
+// the plaintext file as is. The "env" backend also takes a token shared by every profile from
+// CVDR_CREDENTIAL_OIDC_TOKEN.
+func loadOIDCTokenFromStore(store CredentialStore, tokenFile string) (string, error) {
+	key := oidcTokenCredentialKeyOf(tokenFile)
+	keys := []string{key}
+	if _, ok := store.(*envCredentialStore); ok {
+		keys = append(keys, oidcTokenCredentialKey)
+	}
+	for _, k := range keys {
+		value, err := store.Get(k)
+		if err == nil {
+			return string(value), nil
+		}
+		if !errors.Is(err, ErrCredentialNotFound) {
+			return "", err
+		}
+	}
+	content, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed loading oidc token: %w", err)
+	}
+	value := strings.TrimSuffix(string(content), "\n")
+	if err := store.Set(key, []byte(value)); errors.Is(err, ErrReadOnlyCredentialStore) {
+		return value, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed migrating oidc token into credential store: %w", err)
+	}
+	if err := os.Remove(tokenFile); err != nil {
+		return "", fmt.Errorf("failed removing plaintext oidc token file after migration: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+)
+
+func passphrase(v string) PassphraseSource {
+	return func() (string, error) { return v, nil }
+}
+
+func TestEncryptedFileCredentialStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	store := &encryptedFileCredentialStore{Path: path, Passphrase: passphrase("foo")}
+
+	if err := store.Set("bar", []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]byte("baz"), got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := store.Get("qux"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("expected credential not found error, got: %v", err)
+	}
+	wrong := &encryptedFileCredentialStore{Path: path, Passphrase: passphrase("wrong")}
+	if _, err := wrong.Get("bar"); err == nil {
+		t.Errorf("expected error with wrong passphrase")
+	}
+}
+
+func TestLoadOIDCTokenFromStoreMigratesPlaintextFile(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("foo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := &encryptedFileCredentialStore{Path: filepath.Join(dir, "credentials"), Passphrase: passphrase("bar")}
+
+	got, err := loadOIDCTokenFromStore(store, tokenFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("foo", got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(tokenFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected plaintext token file to be removed")
+	}
+	if got, _ := loadOIDCTokenFromStore(store, tokenFile); got != "foo" {
+		t.Errorf("expected token to be read from the store, got: %q", got)
+	}
+}
+
+func TestEnvCredentialStore(t *testing.T) {
+	env := map[string]string{"CVDR_CREDENTIAL_OIDC_TOKEN": "foo"}
+	store := &envCredentialStore{LookupEnv: func(k string) (string, bool) { v, ok := env[k]; return v, ok }}
+
+	got, err := store.Get("oidc_token")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]byte("foo"), got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := store.Get("bar"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("expected credential not found error, got: %v", err)
+	}
+	if err := store.Set("bar", []byte("baz")); !errors.Is(err, ErrReadOnlyCredentialStore) {
+		t.Errorf("expected read only error, got: %v", err)
+	}
+}
+
+func TestLoadOIDCTokenFromReadOnlyStoreKeepsPlaintextFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("foo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := &envCredentialStore{LookupEnv: func(string) (string, bool) { return "", false }}
+
+	got, err := loadOIDCTokenFromStore(store, tokenFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("foo", got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(tokenFile); err != nil {
+		t.Errorf("expected plaintext token file to be kept: %v", err)
+	}
+}
+
+type mapCredentialStore map[string]string
+
+func (s mapCredentialStore) Get(key string) ([]byte, error) {
+	if v, ok := s[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, ErrCredentialNotFound
+}
+
+func (s mapCredentialStore) Set(key string, value []byte) error { s[key] = string(value); return nil }
+
+func (s mapCredentialStore) Delete(key string) error { delete(s, key); return nil }
+
+func TestCustomCredentialStoreBackend(t *testing.T) {
+	var gotCfg *CredentialStoreConfig
+	factory := func(c *CredentialStoreConfig) (CredentialStore, error) {
+		gotCfg = c
+		return mapCredentialStore{"oidc_token": "foo"}, nil
+	}
+	if err := RegisterCredentialStoreBackend("test_custom", factory); err != nil {
+		t.Fatal(err)
+	}
+	config := &CredentialStoreConfig{Backend: "test_custom", Options: map[string]string{"address": "bar"}}
+
+	store, err := NewCredentialStore(config, "", passphrase(""))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get("oidc_token"); string(got) != "foo" {
+		t.Errorf("expected the custom store, got value: %q", got)
+	}
+	if gotCfg != config {
+		t.Errorf("expected the factory to get the config")
+	}
+	if err := RegisterCredentialStoreBackend("test_custom", factory); err == nil {
+		t.Error("expected error registering a backend twice")
+	}
+	if err := RegisterCredentialStoreBackend(FileCredentialStoreBackend, factory); err == nil {
+		t.Error("expected error replacing a builtin backend")
+	}
+}
+
+func TestLoadOIDCTokenFromStoreKeysByTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	store := mapCredentialStore{}
+	files := map[string]string{filepath.Join(dir, "foo"): "foo-token", filepath.Join(dir, "bar"): "bar-token"}
+	for file, token := range files {
+		if err := os.WriteFile(file, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadOIDCTokenFromStore(store, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, token := range files {
+		if got, err := loadOIDCTokenFromStore(store, file); err != nil || got != token {
+			t.Errorf("%s: expected %q, got: %q, %v", file, token, got, err)
+		}
+	}
+}
+
+func TestLoadOIDCTokenFromEnvStoreSharedToken(t *testing.T) {
+	env := map[string]string{"CVDR_CREDENTIAL_OIDC_TOKEN": "foo"}
+	store := &envCredentialStore{LookupEnv: func(k string) (string, bool) { v, ok := env[k]; return v, ok }}
+
+	got, err := loadOIDCTokenFromStore(store, filepath.Join(t.TempDir(), "missing"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("foo", got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSecurityQuote(t *testing.T) {
+	if got, exp := securityQuote(`a "b" \c`), `"a \"b\" \\c"`; got != exp {
+		t.Errorf("expected %s, got: %s", exp, got)
+	}
+}
+
+func TestKeyringCredentialStoreOnlyNotFoundIsNotFound(t *testing.T) {
+	store := &keyringCredentialStore{
+		get: func(key string) *exec.Cmd {
+			return exec.Command("sh", "-c", "echo locked >&2; exit 1")
+		},
+		isNotFound: func(exitErr *exec.ExitError) bool {
+			return exitErr.ExitCode() == 1 && len(exitErr.Stderr) == 0
+		},
+	}
+
+	_, err := store.Get("foo")
+
+	if err == nil || errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("expected a failure other than not found, got: %v", err)
+	}
+}
+
+func TestOpenCredentialStoreWarnsOfTheFileFallback(t *testing.T) {
+	// Neither keyring tool is found.
+	t.Setenv("PATH", t.TempDir())
+	config := &Config{CredentialStore: &CredentialStoreConfig{Backend: AutoCredentialStoreBackend}}
+	errOut := &bytes.Buffer{}
+	c := &cobra.Command{}
+	c.SetErr(errOut)
+
+	store, err := openCredentialStore(config, c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*encryptedFileCredentialStore); !ok {
+		t.Fatalf("expected the encrypted file store, got: %T", store)
+	}
+	if errOut.Len() == 0 {
+		t.Error("expected a warning about the fallback")
+	}
+	config.CredentialStore.Backend = FileCredentialStoreBackend
+	errOut.Reset()
+	if _, err := openCredentialStore(config, c); err != nil {
+		t.Fatal(err)
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("unexpected warning with the file backend: %q", errOut.String())
+	}
+}
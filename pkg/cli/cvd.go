@@ -15,17 +15,29 @@
 package cli
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/cloud-android-orchestration/pkg/client"
 
 	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
 	"github.com/hashicorp/go-multierror"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 )
 
 type RemoteCVDLocator struct {
@@ -47,16 +59,35 @@ type RemoteCVD struct {
 	Status     string
 	Displays   []string
 	ConnStatus *ConnStatus
+	// Metadata the device was created with, only known for devices created by this invocation.
+	Metadata map[string]string
+	// Whether the fleet may reclaim the device, only known for devices created by this invocation.
+	// Reclaimed devices are listed with the status their host reports.
+	Preemptible bool
+	// Time the host deletes the device, zero if it doesn't expire or the host doesn't report it.
+	ExpireTime time.Time
+	// Android CI build the device was created from, i.e: "aosp-main/aosp_cf_x86_64_phone-userdebug".
+	// Known for devices created by this invocation mixing instance builds, and for any device whose
+	// host reports its build source.
+	Build string
+	// Main build the device runs as resolved by the host orchestrator, nil for devices created from
+	// user builds or by host orchestrators not reporting it.
+	MainBuild *hoapi.AndroidCIBuild
+	// Serial number the device reports, its ro.serialno. Known for devices created with one by this
+	// invocation and for any device whose host reports it.
+	SerialNumber string
 }
 
 type RemoteHost struct {
 	ServiceRootEndpoint string `json:"service_root_endpoint"`
 	Name                string `json:"host"`
 	CVDs                []*RemoteCVD
+	// Maximum number of instances the host is able to run, zero if unknown.
+	MaxInstances int `json:"-"`
 }
 
 func NewRemoteCVD(url, host string, cvd *hoapi.CVD) *RemoteCVD {
-	return &RemoteCVD{
+	result := &RemoteCVD{
 		RemoteCVDLocator: RemoteCVDLocator{
 			ServiceRootEndpoint: url,
 			Host:                host,
@@ -65,21 +96,61 @@ func NewRemoteCVD(url, host string, cvd *hoapi.CVD) *RemoteCVD {
 			WebRTCDeviceID:      cvd.WebRTCDeviceID,
 			ADBSerial:           cvd.ADBSerial,
 		},
-		Status:   cvd.Status,
-		Displays: cvd.Displays,
+		Status:    cvd.Status,
+		Displays:  cvd.Displays,
+		MainBuild: mainBuildOf(cvd),
 	}
+	if result.MainBuild != nil {
+		result.Build = buildSourceStr(*result.MainBuild)
+	}
+	return result
+}
+
+func mainBuildOf(cvd *hoapi.CVD) *hoapi.AndroidCIBuild {
+	if cvd.BuildSource == nil || cvd.BuildSource.AndroidCIBuildSource == nil {
+		return nil
+	}
+	return cvd.BuildSource.AndroidCIBuildSource.MainBuild
+}
+
+// Returns the build as "ID_OR_BRANCH/TARGET", followed by the branch if both the id and branch
+// are known, i.e: "12345/aosp_cf_x86_64_phone-userdebug (aosp-main)".
+func buildSourceStr(b hoapi.AndroidCIBuild) string {
+	result := strings.TrimPrefix(androidCIBuildRef(b), "@ab/")
+	if b.BuildID != "" && b.Branch != "" {
+		result += " (" + b.Branch + ")"
+	}
+	return result
 }
 
 const (
 	NoneCredentialsSource     = "none"
 	InjectedCredentialsSource = "injected"
+	// Google Application Default Credentials, i.e: set up by `gcloud auth application-default login`.
+	ADCCredentialsSource = "adc"
 )
 
+// OAuth2 scope of the Android Build API.
+const buildAPIScope = "https://www.googleapis.com/auth/androidbuild.internal"
+
 type CreateCVDLocalOpts struct {
 	LocalBootloaderSrc string
 	LocalCVDHostPkgSrc string
 	LocalImagesSrcs    []string
 	LocalImagesZipSrc  string
+	// Custom vendor boot image, it can be used along with any other build source.
+	LocalVendorBootSrc string
+	// Custom super image replacing the build's, with its own layout of the dynamic partitions. Only
+	// supported with local builds or local sources.
+	LocalSuperImageSrc string
+	// Upload only the files that changed since the last successful create in the same host.
+	Incremental bool
+	// Number of parallel chunk uploads. Derived from the local cores and the link to the host if
+	// zero.
+	UploadWorkers int
+	// Reads the whole host package to check it has the entries needed to launch devices, catching
+	// truncated or wrong packages before uploading them.
+	VerifyHostPackageContents bool
 }
 
 type CreateCVDOpts struct {
@@ -88,18 +159,146 @@ type CreateCVDOpts struct {
 	KernelBuild     hoapi.AndroidCIBuild
 	BootloaderBuild hoapi.AndroidCIBuild
 	SystemImgBuild  hoapi.AndroidCIBuild
-	LocalImage      bool
+	// Downloaded by the host orchestrator, replacing the kernel and initramfs of the builds. Only
+	// http and https URLs the host can reach are supported.
+	KernelURL    string
+	InitramfsURL string
+	LocalImage   bool
+	// Whether to detect the local build from the current directory if the environment variables set
+	// by `lunch` are missing. Only relevant with `LocalImage`.
+	DetectBuildTop bool
 	// Creates multiple instances. Only relevant if given a single build source.
 	NumInstances int
+	// Builds of the groups of instances, for creates mixing builds. Replace `MainBuild` and
+	// `NumInstances`, a single one is the same as setting them.
+	InstanceBuilds []InstanceBuild
 	// Structure: https://android.googlesource.com/device/google/cuttlefish/+/8bbd3b9cd815f756f332791d45c4f492b663e493/host/commands/cvd/parser/README.md
 	// Example: https://cs.android.com/android/platform/superproject/main/+/main:device/google/cuttlefish/host/cvd_test_configs/main_phone-main_watch.json;drc=b2e8f4f014abb7f9cb56c0ae199334aacb04542d
 	EnvConfig map[string]interface{}
 	// If true, perform the ADB connection automatically.
 	AutoConnect               bool
 	BuildAPICredentialsSource string
+	Timeouts                  CreatePhaseTimeouts
+	// Where the "adc" credentials source looks up the Build API credentials first, nil if none is
+	// configured.
+	CredentialStore CredentialStore
+	// The device's gpu mode, one of `gpuModes`. Uses the device's default if empty.
+	GPUMode string
+	// Displays of the device. Uses the defaults of the device type in `DisplayDefaults` if empty.
+	Displays []DisplayConfig
+	// Default displays by device type, see `displayDefaults`. No defaults are applied if nil.
+	DisplayDefaults map[string][]DisplayConfig
+	// Features of the host the device requires, see the apiv1.HostFeature constants. Hosts are
+	// created with them, selected among those having them or checked to have them.
+	RequireFeatures []string
+	// Userdata disk size of each instance, see `minUserdataSizeMB`. Uses the device's default if
+	// zero.
+	UserdataSizeMB int64
+	// Boots without the boot animation, which is faster.
+	NoBootAnimation bool
+	// SELinux mode the device boots with, see `selinuxModes`. Uses the build's default if empty.
+	SELinuxMode string
+	// ro.boot properties the device boots with, see `bootPropertyPrefix`.
+	BootProperties []BootProperty
+	// Serial number the device reports, see `serialNumberRe`. Only for creates of a single device,
+	// the launcher's default if empty.
+	SerialNumber string
+	// Raw instance canonical configuration merged into every instance, an escape hatch for the
+	// properties without options. The options setting the same properties win, unless
+	// `ConfigOverlayWins` is set.
+	ConfigOverlay     map[string]any
+	ConfigOverlayWins bool
+	// Forwarded as is to the host orchestrator, for site specific server extensions.
+	Metadata map[string]string
+	// Variants of the main build's target fetched along with it, i.e: "user", so creates of them
+	// don't wait for their fetch. The devices run `MainBuild`. Only supported with a single Android
+	// CI build.
+	Variants []string
+	// Forwarded to the schedulers of shared fleets, see client.CreateCVDOptions.
+	Priority    int
+	Preemptible bool
+	// The host deletes the devices this long after creating them, never if zero. Requires a host
+	// orchestrator enforcing TTLs, see `minCVDTTL`.
+	TTL time.Duration
+	// Build server mirrors keyed by zone, see Config.BuildAPIMirrors.
+	BuildAPIMirrors map[string]string
+	// Hint of how many artifacts the host downloads in parallel, the host's default if zero.
+	FetchConcurrency int
+	// Hint of the bytes per second the host downloads from the build server at most, unlimited if
+	// zero.
+	FetchBandwidthLimit int64
+	// How many times the host retries a failed artifact download, the host's default if zero.
+	FetchRetries int
+	// Directory of the host the fetched artifacts are stored in, the host's default if empty.
+	ArtifactStorage string
+	// Where the files uploaded to each host are tracked, required by incremental creates.
+	UploadCacheDir string
 	CreateCVDLocalOpts
 }
 
+// Returns nil if neither URL is set.
+func (o *CreateCVDOpts) urlBuildSource() *client.URLBuildSource {
+	if o.KernelURL == "" && o.InitramfsURL == "" {
+		return nil
+	}
+	return &client.URLBuildSource{KernelURL: o.KernelURL, InitramfsURL: o.InitramfsURL}
+}
+
+// Empty URLs are valid, meaning the artifact isn't replaced. Otherwise they must be absolute http
+// or https URLs, like the service URL.
+func validateArtifactURL(v string) error {
+	if v == "" {
+		return nil
+	}
+	return validateServiceURL(v)
+}
+
+// Timeouts for each of the phases of a CVD creation. A zero value means no timeout.
+type CreatePhaseTimeouts struct {
+	Upload time.Duration
+	Fetch  time.Duration
+	Create time.Duration
+	Boot   time.Duration
+}
+
+const (
+	uploadPhase = "upload"
+	fetchPhase  = "fetch"
+	createPhase = "create"
+	bootPhase   = "boot"
+)
+
+type PhaseTimeoutError struct {
+	Phase   string
+	Timeout time.Duration
+}
+
+func (e *PhaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s phase exceeded its %s timeout, use --%s_timeout to adjust it", e.Phase, e.Timeout, e.Phase)
+}
+
+// Runs `fn` with a context derived from `ctx` for the given phase, the requests `fn` sends with it
+// are canceled once the phase times out. The phase is abandoned, and a PhaseTimeoutError returned,
+// if `fn` doesn't complete before the timeout.
+func runPhase(ctx context.Context, phase string, timeout time.Duration, fn func(context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- fn(ctx) }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &PhaseTimeoutError{Phase: phase, Timeout: timeout}
+		}
+		return ctx.Err()
+	}
+}
+
 func (o *CreateCVDOpts) AdditionalInstancesNum() uint32 {
 	if o.NumInstances <= 0 {
 		return 0
@@ -107,8 +306,9 @@ func (o *CreateCVDOpts) AdditionalInstancesNum() uint32 {
 	return uint32(o.NumInstances - 1)
 }
 
-func createCVD(service client.Service, createOpts CreateCVDOpts, statePrinter *statePrinter) ([]*RemoteCVD, error) {
-	creator, err := newCVDCreator(service, createOpts, statePrinter)
+func runCreateCVD(service client.Service, createOpts CreateCVDOpts, report func(CreateEvent)) ([]*RemoteCVD, error) {
+	createOpts.normalizeInstanceBuilds()
+	creator, err := newCVDCreator(service, createOpts, report)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cvd: %w", err)
 	}
@@ -116,58 +316,357 @@ func createCVD(service client.Service, createOpts CreateCVDOpts, statePrinter *s
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cvd: %w", err)
 	}
+	// The devices are returned in the order of the instances in the canonical configuration.
+	builds := createOpts.buildPerInstance()
 	result := []*RemoteCVD{}
-	for _, cvd := range cvds {
-		result = append(result, NewRemoteCVD(service.RootURI(), createOpts.Host, cvd))
+	for i, cvd := range cvds {
+		rcvd := NewRemoteCVD(service.RootURI(), createOpts.Host, cvd)
+		rcvd.Metadata = createOpts.Metadata
+		rcvd.Preemptible = createOpts.Preemptible
+		rcvd.SerialNumber = createOpts.SerialNumber
+		if createOpts.TTL > 0 {
+			// The host counts from when it created the device, a bit earlier.
+			rcvd.ExpireTime = time.Now().Add(createOpts.TTL)
+		}
+		// The build reported by the host is resolved, i.e: has the build id, prefer it.
+		if len(builds) == len(cvds) && rcvd.MainBuild == nil {
+			rcvd.Build = strings.TrimPrefix(androidCIBuildRef(builds[i]), "@ab/")
+		}
+		result = append(result, rcvd)
 	}
 	return result, nil
 }
 
-type CredentialsFactory func() string
+type CredentialsFactory func() (string, error)
 
 type cvdCreator struct {
+	ctx                context.Context
 	service            client.Service
 	opts               CreateCVDOpts
-	statePrinter       *statePrinter
+	report             func(CreateEvent)
 	credentialsFactory CredentialsFactory
 }
 
-func newCVDCreator(service client.Service, opts CreateCVDOpts, statePrinter *statePrinter) (*cvdCreator, error) {
-	cf, err := credentialsFactoryFromSource(opts.BuildAPICredentialsSource)
+func newCVDCreator(service client.Service, opts CreateCVDOpts, report func(CreateEvent)) (*cvdCreator, error) {
+	cf, err := credentialsFactoryFromSource(opts.BuildAPICredentialsSource, opts.CredentialStore)
 	if err != nil {
+		// Only the hosts' fetches need credentials here, the service can inject its own.
+		if opts.BuildAPICredentialsSource == ADCCredentialsSource {
+			return nil, fmt.Errorf("%w, or use the %q credentials source", err, InjectedCredentialsSource)
+		}
 		return nil, err
 	}
 	return &cvdCreator{
+		ctx:                context.Background(),
 		service:            service,
 		opts:               opts,
-		statePrinter:       statePrinter,
+		report:             report,
 		credentialsFactory: cf,
 	}, nil
 }
 
+func (c *cvdCreator) started(phase, msg string) {
+	c.report(CreateEvent{Kind: CreateEventStarted, Phase: phase, Msg: msg})
+}
+
+func (c *cvdCreator) done(phase, msg string, err error) {
+	c.report(CreateEvent{Kind: CreateEventDone, Phase: phase, Msg: msg, Err: err})
+}
+
 func (c *cvdCreator) Create() ([]*hoapi.CVD, error) {
-	if c.opts.LocalImage {
-		return c.createCVDFromLocalBuild()
+	if err := c.opts.validateInstanceBuilds(); err != nil {
+		return nil, err
+	}
+	if err := c.opts.validateInstanceOverrides(); err != nil {
+		return nil, err
+	}
+	if c.opts.Incremental && !c.opts.LocalImage && c.opts.CreateCVDLocalOpts.empty() {
+		return nil, errors.New("incremental mode is only supported when creating from local files")
+	}
+	for k := range c.opts.Metadata {
+		if strings.TrimSpace(k) == "" {
+			return nil, errors.New("metadata keys can't be empty")
+		}
+	}
+	if c.opts.UploadWorkers < 0 {
+		return nil, fmt.Errorf("invalid number of upload workers: %d", c.opts.UploadWorkers)
+	}
+	if c.opts.FetchConcurrency < 0 {
+		return nil, fmt.Errorf("invalid fetch concurrency: %d", c.opts.FetchConcurrency)
+	}
+	if c.opts.FetchBandwidthLimit < 0 {
+		return nil, fmt.Errorf("invalid fetch bandwidth limit: %d", c.opts.FetchBandwidthLimit)
 	}
-	if !c.opts.CreateCVDLocalOpts.empty() {
-		return c.createCVDFromLocalSrcs()
+	if c.opts.FetchRetries < 0 {
+		return nil, fmt.Errorf("invalid fetch retries: %d", c.opts.FetchRetries)
 	}
-	return c.createCVDFromAndroidCI()
+	if err := validateArtifactStorage(c.opts.ArtifactStorage); err != nil {
+		return nil, fmt.Errorf("invalid artifact storage: %w", err)
+	}
+	if c.opts.TTL != 0 {
+		if err := validateCVDTTL(c.opts.TTL); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.opts.Variants) > 0 && (c.opts.EnvConfig != nil || len(c.opts.InstanceBuilds) > 0 || c.opts.LocalImage || !c.opts.CreateCVDLocalOpts.empty()) {
+		return nil, errors.New("build variants are only supported with a single Android CI build")
+	}
+	hasOverrides := len(c.opts.instanceOverrides()) > 0 || c.opts.ConfigOverlay != nil
+	if hasOverrides && (c.opts.LocalImage || !c.opts.CreateCVDLocalOpts.empty()) {
+		return nil, errors.New("instance properties, like the gpu mode, are only supported with Android CI builds or an environment specification")
+	}
+	if urlSrc := c.opts.urlBuildSource(); urlSrc != nil {
+		if c.opts.LocalImage || !c.opts.CreateCVDLocalOpts.empty() || c.opts.EnvConfig != nil || hasOverrides {
+			return nil, errors.New("kernel and initramfs URLs are only supported with Android CI builds, without an environment specification or instance properties")
+		}
+		if err := validateArtifactURL(urlSrc.KernelURL); err != nil {
+			return nil, fmt.Errorf("invalid kernel URL: %w", err)
+		}
+		if err := validateArtifactURL(urlSrc.InitramfsURL); err != nil {
+			return nil, fmt.Errorf("invalid initramfs URL: %w", err)
+		}
+	}
+	if c.opts.LocalVendorBootSrc != "" {
+		if c.opts.EnvConfig != nil || hasOverrides {
+			return nil, errors.New("a local vendor boot image cannot be used with an environment specification or instance properties")
+		}
+		if err := validateVendorBootImage(c.opts.LocalVendorBootSrc); err != nil {
+			return nil, fmt.Errorf("invalid local vendor boot image: %w", err)
+		}
+	}
+	if c.opts.LocalSuperImageSrc != "" {
+		if err := c.opts.validateLocalSuperImage(); err != nil {
+			return nil, err
+		}
+	}
+	if c.opts.UserdataSizeMB != 0 && c.opts.Host != "" {
+		if err := c.checkHostDiskCapacity(); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.opts.RequireFeatures) > 0 {
+		if err := c.checkHostFeatures(); err != nil {
+			return nil, err
+		}
+	}
+	if c.opts.SerialNumber != "" {
+		if err := c.checkSerialNumberAvailable(); err != nil {
+			return nil, err
+		}
+	}
+	var cvds []*hoapi.CVD
+	var err error
+	switch {
+	case c.opts.LocalImage:
+		cvds, err = c.createCVDFromLocalBuild()
+	case !c.opts.CreateCVDLocalOpts.empty():
+		cvds, err = c.createCVDFromLocalSrcs()
+	default:
+		cvds, err = c.createCVDFromAndroidCI()
+	}
+	if err != nil || c.opts.SerialNumber == "" {
+		return cvds, err
+	}
+	if err := c.verifySerialNumber(cvds); err != nil {
+		return nil, err
+	}
+	return cvds, nil
 }
 
-func (c *cvdCreator) createCVDFromLocalBuild() ([]*hoapi.CVD, error) {
-	buildTop, err := envVar(AndroidBuildTopVarName)
+func (c *cvdCreator) checkHostDiskCapacity() error {
+	hosts, err := c.service.ListHosts()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("error listing hosts: %w", err)
+	}
+	for _, host := range hosts.Items {
+		if host.Name == c.opts.Host {
+			return validateHostDiskCapacity(host, c.opts.UserdataSizeMB, c.opts.instancesNum())
+		}
+	}
+	return nil
+}
+
+func (c *cvdCreator) checkHostFeatures() error {
+	if err := validateHostFeatures(c.opts.RequireFeatures); err != nil {
+		return err
+	}
+	host, err := findHost(c.service, c.opts.Host)
+	if err != nil {
+		return err
+	}
+	return checkHostFeatures(host, c.opts.RequireFeatures)
+}
+
+// Devices of the same host can't share a serial number, harnesses wouldn't tell them apart. Only
+// creates of a single device can request one.
+func (c *cvdCreator) checkSerialNumberAvailable() error {
+	n := c.opts.instancesNum()
+	if instances, ok := c.opts.EnvConfig["instances"].([]any); ok {
+		n = len(instances)
+	}
+	if n > 1 {
+		return fmt.Errorf("a serial number can only be requested for a single device, creating %d", n)
+	}
+	cvds, err := c.service.HostService(c.opts.Host).ListCVDDetails()
+	if err != nil {
+		return fmt.Errorf("failed to list the devices of host %q: %w", c.opts.Host, err)
+	}
+	for _, cvd := range cvds {
+		if cvd.SerialNumber == c.opts.SerialNumber {
+			return fmt.Errorf("device %q of host %q already has serial number %q", cvd.Name, c.opts.Host, c.opts.SerialNumber)
+		}
+	}
+	return nil
+}
+
+// Fails if the host reports the created device with another serial number than the requested one.
+// Hosts not reporting serial numbers are trusted to apply the instance configuration.
+func (c *cvdCreator) verifySerialNumber(created []*hoapi.CVD) error {
+	cvds, err := c.service.HostService(c.opts.Host).ListCVDDetails()
+	if err != nil {
+		return fmt.Errorf("failed to list the devices of host %q: %w", c.opts.Host, err)
+	}
+	for _, cvd := range cvds {
+		for _, d := range created {
+			if cvd.ID() == d.ID() && cvd.SerialNumber != "" && cvd.SerialNumber != c.opts.SerialNumber {
+				return fmt.Errorf("host %q didn't honor serial number %q, device %q has %q, delete it with `cvdr delete`",
+					c.opts.Host, c.opts.SerialNumber, d.Name, cvd.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+// The directory is in the host, only its format is checked. Empty directories are valid, meaning
+// the host's default.
+func validateArtifactStorage(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if !path.IsAbs(dir) {
+		return fmt.Errorf("%q is not an absolute path", dir)
+	}
+	if path.Clean(dir) != dir {
+		return fmt.Errorf("%q is not a clean path, i.e: it has \"..\", \".\" or trailing slashes", dir)
 	}
-	productOut, err := envVar(AndroidProductOutVarName)
+	if strings.ContainsAny(dir, "\x00\n") {
+		return fmt.Errorf("%q has invalid characters", dir)
+	}
+	return nil
+}
+
+// Devices expiring sooner would be deleted before they are done booting.
+const minCVDTTL = 10 * time.Minute
+
+func validateCVDTTL(ttl time.Duration) error {
+	if ttl < minCVDTTL {
+		return fmt.Errorf("ttl of %s is below the %s minimum", ttl, minCVDTTL)
+	}
+	return nil
+}
+
+// Returns when the device expires relative to `now`, i.e: "in 3h12m" or "expired". Empty if it
+// doesn't expire.
+func cvdExpiryStr(c *RemoteCVD, now time.Time) string {
+	if c.ExpireTime.IsZero() {
+		return ""
+	}
+	left := c.ExpireTime.Sub(now)
+	if left <= 0 {
+		return "expired"
+	}
+	return "in " + left.Round(time.Minute).String()
+}
+
+// Picks the build server mirror of the host's zone, if any.
+func (c *cvdCreator) fetchArtifactsOptions() (client.FetchArtifactsOptions, error) {
+	opts := client.FetchArtifactsOptions{
+		FetchConcurrency:    c.opts.FetchConcurrency,
+		ArtifactStorage:     c.opts.ArtifactStorage,
+		FetchBandwidthLimit: c.opts.FetchBandwidthLimit,
+		FetchRetries:        c.opts.FetchRetries,
+	}
+	if len(c.opts.BuildAPIMirrors) == 0 {
+		return opts, nil
+	}
+	hosts, err := c.service.ListHosts()
+	if err != nil {
+		return client.FetchArtifactsOptions{}, fmt.Errorf("error listing hosts: %w", err)
+	}
+	for _, host := range hosts.Items {
+		if host.Name == c.opts.Host {
+			opts.BuildAPIBaseURL = buildAPIMirror(host, c.opts.BuildAPIMirrors)
+		}
+	}
+	return opts, nil
+}
+
+// Reports the fetches the host ran with a different concurrency than requested, i.e: clamped it.
+func (c *cvdCreator) reportFetchConcurrency(bundles []fetchBundle, fetched []*client.FetchArtifactsResult) {
+	if c.opts.FetchConcurrency == 0 {
+		return
+	}
+	for i, res := range fetched {
+		if res.FetchConcurrency != 0 && res.FetchConcurrency != c.opts.FetchConcurrency {
+			c.report(CreateEvent{
+				Kind: CreateEventWarning,
+				Msg: fmt.Sprintf("the host fetched the %s build with a concurrency of %d instead of %d",
+					bundles[i].Name, res.FetchConcurrency, c.opts.FetchConcurrency),
+			})
+		}
+	}
+}
+
+// Reports the fetches the host limited to a different bandwidth than requested. Hosts ignoring the
+// limit don't report it.
+func (c *cvdCreator) reportFetchBandwidthLimit(bundles []fetchBundle, fetched []*client.FetchArtifactsResult) {
+	if c.opts.FetchBandwidthLimit == 0 {
+		return
+	}
+	for i, res := range fetched {
+		if res.FetchBandwidthLimit != 0 && res.FetchBandwidthLimit != c.opts.FetchBandwidthLimit {
+			c.report(CreateEvent{
+				Kind: CreateEventWarning,
+				Msg: fmt.Sprintf("the host fetched the %s build limited to %s instead of %s", bundles[i].Name,
+					formatBandwidth(res.FetchBandwidthLimit), formatBandwidth(c.opts.FetchBandwidthLimit)),
+			})
+		}
+	}
+}
+
+// Reports the fetches the host had to retry downloads for, a sign of a flaky build server.
+func (c *cvdCreator) reportFetchRetries(bundles []fetchBundle, fetched []*client.FetchArtifactsResult) {
+	for i, res := range fetched {
+		if res.FetchRetries != 0 {
+			c.report(CreateEvent{
+				Kind: CreateEventWarning,
+				Msg: fmt.Sprintf("the host retried %d failed artifact downloads fetching the %s build",
+					res.FetchRetries, bundles[i].Name),
+			})
+		}
+	}
+}
+
+func (c *cvdCreator) createCVDFromLocalBuild() ([]*hoapi.CVD, error) {
+	env, err := getAndroidBuildEnv(c.opts.DetectBuildTop)
 	if err != nil {
 		return nil, err
 	}
+	msg := env.String()
+	c.started("", msg)
+	c.done("", msg, nil)
+	buildTop, productOut := env.BuildTop, env.ProductOut
 	names, err := ListLocalImageRequiredFiles(buildTop, productOut)
 	if err != nil {
 		return nil, err
 	}
+	if missing := missingCommonImages(names); len(missing) > 0 {
+		c.report(CreateEvent{
+			Kind: CreateEventWarning,
+			Msg: fmt.Sprintf("%s not listed in %s, the device may fail to boot",
+				strings.Join(missing, ", "), filepath.Join(buildTop, RequiredImagesFilename)),
+		})
+	}
 	targetArch, err := getTargetArch(buildTop)
 	if err != nil {
 		return nil, err
@@ -180,56 +679,156 @@ func (c *cvdCreator) createCVDFromLocalBuild() ([]*hoapi.CVD, error) {
 	if err := verifyCVDHostPackageTar(hostOut); err != nil {
 		return nil, err
 	}
-	names = append(names, filepath.Join(hostOut, CVDHostPackageName))
-	hostSrv := c.service.HostService(c.opts.Host)
-	uploadDir, err := hostSrv.CreateUploadDir()
-	if err != nil {
-		return nil, err
+	if c.opts.VerifyHostPackageContents {
+		if err := verifyCVDHostPackageContents(filepath.Join(hostOut, CVDHostPackageName)); err != nil {
+			return nil, err
+		}
 	}
-	if err := uploadFiles(hostSrv, uploadDir, names, c.statePrinter); err != nil {
-		return nil, err
+	names = append(names, filepath.Join(hostOut, CVDHostPackageName))
+	if c.opts.LocalSuperImageSrc != "" {
+		names = replaceLocalImage(names, c.opts.LocalSuperImageSrc)
 	}
-	req := hoapi.CreateCVDRequest{
-		CVD: &hoapi.CVD{
-			BuildSource: &hoapi.BuildSource{
-				UserBuildSource: &hoapi.UserBuildSource{
-					ArtifactsDir: uploadDir,
-				},
-			},
-		},
-		AdditionalInstancesNum: c.opts.AdditionalInstancesNum(),
+	if c.opts.LocalVendorBootSrc != "" {
+		names = replaceLocalImage(names, c.opts.LocalVendorBootSrc)
 	}
-	res, err := hostSrv.CreateCVD(&req, c.credentialsFactory())
-	if err != nil {
+	if err := verifyLocalFiles(names); err != nil {
 		return nil, err
 	}
-	return res.CVDs, nil
+	return c.createFromLocalFiles(c.service.HostService(c.opts.Host), names)
 }
 
 const (
 	stateMsgFetchMainBundle = "Fetching main bundle artifacts"
+	stateMsgFetchBundles    = "Fetching artifacts of every build"
+	stateMsgFetchVariants   = "Fetching artifacts of the other variants"
 	stateMsgStartCVD        = "Starting and waiting for boot complete"
 	stateMsgFetchAndStart   = "Fetching, starting and waiting for boot complete"
 )
 
 func (c *cvdCreator) createCVDFromAndroidCI() ([]*hoapi.CVD, error) {
+	overrides := c.opts.instanceOverrides()
+	winner := "options"
+	if c.opts.ConfigOverlayWins {
+		winner = "config overlay"
+	}
+	for _, p := range configOverlayConflicts(c.opts.ConfigOverlay, overrides) {
+		c.report(CreateEvent{
+			Kind: CreateEventWarning,
+			Msg:  fmt.Sprintf("%q is set by both the config overlay and the options, using the %s value", p, winner),
+		})
+	}
 	if c.opts.EnvConfig != nil {
-		return c.createWithCanonicalConfig()
+		if len(overrides) == 0 && c.opts.ConfigOverlay == nil {
+			return c.createWithCanonicalConfig(c.opts.EnvConfig)
+		}
+		envConfig, err := c.opts.applyInstanceConfig(c.opts.EnvConfig, overrides)
+		if err != nil {
+			return nil, err
+		}
+		return c.createWithCanonicalConfig(envConfig)
+	}
+	// Default displays would require a canonical configuration, which has no URL build source.
+	// Displays in the config overlay aren't defaults, they win.
+	defaultDisplaysOn := len(c.opts.Displays) == 0 && c.opts.urlBuildSource() == nil && !hasPath(c.opts.ConfigOverlay, "graphics.displays")
+	if len(c.opts.InstanceBuilds) > 0 {
+		// Mixed builds can only be created from a canonical configuration, with the default
+		// displays of each instance's target.
+		envConfig := envConfigFromBuilds(&c.opts)
+		if defaultDisplaysOn {
+			setInstanceDefaultDisplays(envConfig, c.opts.buildPerInstance(), c.opts.DisplayDefaults)
+		}
+		envConfig, err := c.opts.applyInstanceConfig(envConfig, overrides)
+		if err != nil {
+			return nil, err
+		}
+		return c.createWithCanonicalConfig(envConfig)
+	}
+	if defaultDisplaysOn {
+		if displays := defaultDisplays(c.opts.MainBuild.Target, c.opts.DisplayDefaults); len(displays) > 0 {
+			overrides["graphics.displays"] = displaysConfig(displays)
+		}
+	}
+	if len(overrides) > 0 || c.opts.ConfigOverlay != nil {
+		// Instance properties can only be forwarded in a canonical configuration.
+		envConfig, err := c.opts.applyInstanceConfig(envConfigFromBuilds(&c.opts), overrides)
+		if err != nil {
+			return nil, err
+		}
+		return c.createWithCanonicalConfig(envConfig)
 	}
 	return c.createWithOpts()
 }
 
-func (c *cvdCreator) createWithCanonicalConfig() ([]*hoapi.CVD, error) {
+// Fetches the bundles in the host as a single phase, the results are in the order of the bundles.
+func (c *cvdCreator) fetch(bundles []fetchBundle, msg string) ([]*client.FetchArtifactsResult, error) {
+	fetchOpts, err := c.fetchArtifactsOptions()
+	if err != nil {
+		return nil, err
+	}
+	tracker := &fetchProgressTracker{
+		progress: make([]BuildFetchProgress, len(bundles)),
+		report: func(p []BuildFetchProgress) {
+			c.report(CreateEvent{Kind: CreateEventProgress, Phase: fetchPhase, Msg: msg, Fetches: p})
+		},
+	}
+	for i, b := range bundles {
+		tracker.progress[i].Build = b.Name
+	}
+	c.started(fetchPhase, msg)
+	var fetched []*client.FetchArtifactsResult
+	err = runPhase(c.ctx, fetchPhase, c.opts.Timeouts.Fetch, func(ctx context.Context) error {
+		creds, err := c.credentialsFactory()
+		if err != nil {
+			return err
+		}
+		srv := client.HostServiceWithContext(c.service.HostService(c.opts.Host), ctx)
+		fetched, err = fetchBundles(srv, bundles, creds, fetchOpts, tracker)
+		return err
+	})
+	tracker.stop()
+	var apiErr *client.ApiCallError
+	if c.opts.ArtifactStorage != "" && errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+		err = fmt.Errorf("the host rejected the artifact storage %q: %w", c.opts.ArtifactStorage, err)
+	}
+	c.done(fetchPhase, msg, err)
+	if err != nil {
+		return nil, err
+	}
+	c.reportFetchConcurrency(bundles, fetched)
+	c.reportFetchBandwidthLimit(bundles, fetched)
+	c.reportFetchRetries(bundles, fetched)
+	return fetched, nil
+}
+
+func (c *cvdCreator) createWithCanonicalConfig(envConfig map[string]any) ([]*hoapi.CVD, error) {
+	// The host fetches the build the devices run while creating them, only the other variants are
+	// fetched beforehand.
+	if bundles := c.opts.variantBundles(); len(bundles) > 0 {
+		if _, err := c.fetch(bundles, stateMsgFetchVariants); err != nil {
+			return nil, err
+		}
+	}
+	// The host orchestrator takes no build server for the fetches of canonical configuration creates.
+	fetchOpts, err := c.fetchArtifactsOptions()
+	if err != nil {
+		return nil, err
+	}
+	if fetchOpts.BuildAPIBaseURL != "" {
+		c.report(CreateEvent{
+			Kind: CreateEventWarning,
+			Msg:  fmt.Sprintf("the host fetches the build from the default build server, not the mirror %s", fetchOpts.BuildAPIBaseURL),
+		})
+	}
 	createReq := &hoapi.CreateCVDRequest{
-		EnvConfig: c.opts.EnvConfig,
+		EnvConfig: envConfig,
 	}
-	c.statePrinter.Print(stateMsgFetchAndStart)
-	res, err := c.service.HostService(c.opts.Host).CreateCVD(createReq, c.credentialsFactory())
-	c.statePrinter.PrintDone(stateMsgFetchAndStart, err)
+	c.started("", stateMsgFetchAndStart)
+	cvds, err := c.createAndWaitForBoot(c.service.HostService(c.opts.Host), createReq)
+	c.done("", stateMsgFetchAndStart, err)
 	if err != nil {
 		return nil, err
 	}
-	return res.CVDs, nil
+	return cvds, nil
 }
 
 func (c *cvdCreator) createWithOpts() ([]*hoapi.CVD, error) {
@@ -244,47 +843,143 @@ func (c *cvdCreator) createWithOpts() ([]*hoapi.CVD, error) {
 	if c.opts.SystemImgBuild != (hoapi.AndroidCIBuild{}) {
 		systemImageBuild = &c.opts.SystemImgBuild
 	}
-	fetchReq := &hoapi.FetchArtifactsRequest{
-		AndroidCIBundle: &hoapi.AndroidCIBundle{Build: mainBuild, Type: hoapi.MainBundleType},
+	bundles := []fetchBundle{{Name: "main", Bundle: &hoapi.AndroidCIBundle{Build: mainBuild, Type: hoapi.MainBundleType}}}
+	if kernelBuild != nil {
+		bundles = append(bundles, fetchBundle{Name: "kernel", Bundle: &hoapi.AndroidCIBundle{Build: kernelBuild, Type: hoapi.KernelBundleType}})
+	}
+	if bootloaderBuild != nil {
+		bundles = append(bundles, fetchBundle{Name: "bootloader", Bundle: &hoapi.AndroidCIBundle{Build: bootloaderBuild, Type: hoapi.BootloaderBundleType}})
+	}
+	if systemImageBuild != nil {
+		bundles = append(bundles, fetchBundle{Name: "system", Bundle: &hoapi.AndroidCIBundle{Build: systemImageBuild, Type: hoapi.SystemImageBundleType}})
+	}
+	bundles = append(bundles, c.opts.variantBundles()...)
+	fetchMsg := stateMsgFetchMainBundle
+	if len(bundles) > 1 {
+		fetchMsg = stateMsgFetchBundles
 	}
-	c.statePrinter.Print(stateMsgFetchMainBundle)
-	fetchMainBuildRes, err := c.service.HostService(c.opts.Host).FetchArtifacts(fetchReq, c.credentialsFactory())
-	c.statePrinter.PrintDone(stateMsgFetchMainBundle, err)
+	fetched, err := c.fetch(bundles, fetchMsg)
 	if err != nil {
 		return nil, err
 	}
+	// Local artifacts complementing the build from Android CI.
+	var userBuildSource *hoapi.UserBuildSource
+	if local := c.opts.localCIComplements(); len(local) > 0 {
+		hostSrv := c.service.HostService(c.opts.Host)
+		uploadDir, err := hostSrv.CreateUploadDir()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.upload(hostSrv, uploadDir, local); err != nil {
+			return nil, err
+		}
+		userBuildSource = &hoapi.UserBuildSource{ArtifactsDir: uploadDir}
+	}
 	createReq := &hoapi.CreateCVDRequest{
 		CVD: &hoapi.CVD{
 			BuildSource: &hoapi.BuildSource{
 				AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{
-					MainBuild:        fetchMainBuildRes.AndroidCIBundle.Build,
+					MainBuild:        fetched[0].AndroidCIBundle.Build,
 					KernelBuild:      kernelBuild,
 					BootloaderBuild:  bootloaderBuild,
 					SystemImageBuild: systemImageBuild,
 				},
+				UserBuildSource: userBuildSource,
 			},
 		},
 		AdditionalInstancesNum: c.opts.AdditionalInstancesNum(),
 	}
-	c.statePrinter.Print(stateMsgStartCVD)
-	res, err := c.service.HostService(c.opts.Host).CreateCVD(createReq, c.credentialsFactory())
-	c.statePrinter.PrintDone(stateMsgStartCVD, err)
+	c.started("", stateMsgStartCVD)
+	cvds, err := c.createAndWaitForBoot(c.service.HostService(c.opts.Host), createReq)
+	c.done("", stateMsgStartCVD, err)
 	if err != nil {
 		return nil, err
 	}
-	return res.CVDs, nil
+	return cvds, nil
 }
 
 func (c *cvdCreator) createCVDFromLocalSrcs() ([]*hoapi.CVD, error) {
 	if err := c.opts.CreateCVDLocalOpts.validate(); err != nil {
 		return nil, fmt.Errorf("invalid local source: %w", err)
 	}
-	uploadDir, err := c.service.HostService(c.opts.Host).CreateUploadDir()
+	if c.opts.VerifyHostPackageContents && c.opts.LocalCVDHostPkgSrc != "" {
+		if err := verifyCVDHostPackageContents(c.opts.LocalCVDHostPkgSrc); err != nil {
+			return nil, err
+		}
+	}
+	return c.createFromLocalFiles(c.service.HostService(c.opts.Host), c.opts.CreateCVDLocalOpts.srcs())
+}
+
+const stateMsgRecordUpload = "Recording uploaded files"
+
+// Uploads the files and creates the devices from them. In incremental mode only the files that
+// changed since the last successful create in the host are uploaded, into the directory of the
+// previous upload, which contains the unchanged files already. The previous upload is only reused
+// if no device of the host may be using it, its files would be overwritten under the device
+// otherwise.
+func (c *cvdCreator) createFromLocalFiles(hostSrv client.HostOrchestratorService, names []string) ([]*hoapi.CVD, error) {
+	var hashes map[string]string
+	cache := &uploadCache{Dir: c.opts.UploadCacheDir}
+	if c.opts.Incremental {
+		if c.opts.UploadCacheDir == "" {
+			return nil, errors.New("incremental create requires an upload cache directory")
+		}
+		var err error
+		if hashes, err = hashFiles(names); err != nil {
+			return nil, err
+		}
+		entry, err := cache.Get(c.service.RootURI(), c.opts.Host)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			cvds, err := hostSrv.ListCVDs()
+			if err != nil {
+				return nil, fmt.Errorf("failed to list the devices using the previous upload: %w", err)
+			}
+			if uploadDirInUse(cvds, entry.UploadDir) {
+				c.report(CreateEvent{
+					Kind: CreateEventWarning,
+					Msg:  "the previous upload may be in use by devices of the host, uploading every file to a new directory",
+				})
+				entry = nil
+			}
+		}
+		if entry != nil {
+			cvds, err := c.uploadAndCreate(hostSrv, entry.UploadDir, changedFiles(names, hashes, entry))
+			if err == nil {
+				for k, v := range hashes {
+					entry.Files[k] = v
+				}
+				c.recordUpload(cache, entry)
+				return cvds, nil
+			}
+			if !isUploadDirNotFound(err) {
+				return nil, err
+			}
+			// The previous upload was garbage collected in the host, start over.
+			c.report(CreateEvent{
+				Kind: CreateEventWarning,
+				Msg:  "the previous upload is gone from the host, uploading every file to a new directory",
+			})
+		}
+	}
+	uploadDir, err := hostSrv.CreateUploadDir()
 	if err != nil {
 		return nil, err
 	}
-	hostSrv := c.service.HostService(c.opts.Host)
-	if err := uploadFiles(hostSrv, uploadDir, c.opts.CreateCVDLocalOpts.srcs(), c.statePrinter); err != nil {
+	cvds, err := c.uploadAndCreate(hostSrv, uploadDir, names)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.Incremental {
+		c.recordUpload(cache, &uploadCacheEntry{UploadDir: uploadDir, Files: hashes})
+	}
+	return cvds, nil
+}
+
+func (c *cvdCreator) uploadAndCreate(hostSrv client.HostOrchestratorService, uploadDir string, names []string) ([]*hoapi.CVD, error) {
+	if err := c.upload(hostSrv, uploadDir, names); err != nil {
 		return nil, err
 	}
 	req := hoapi.CreateCVDRequest{
@@ -297,62 +992,168 @@ func (c *cvdCreator) createCVDFromLocalSrcs() ([]*hoapi.CVD, error) {
 		},
 		AdditionalInstancesNum: c.opts.AdditionalInstancesNum(),
 	}
-	res, err := hostSrv.CreateCVD(&req, c.credentialsFactory())
+	return c.createAndWaitForBoot(hostSrv, &req)
+}
+
+// Failing to record the upload is not fatal, it only prevents the next create from being
+// incremental.
+func (c *cvdCreator) recordUpload(cache *uploadCache, entry *uploadCacheEntry) {
+	c.started("", stateMsgRecordUpload)
+	err := cache.Set(c.service.RootURI(), c.opts.Host, entry)
+	c.done("", stateMsgRecordUpload, err)
+}
+
+func (c *cvdCreator) upload(srv client.HostOrchestratorService, uploadDir string, names []string) error {
+	return runPhase(c.ctx, uploadPhase, c.opts.Timeouts.Upload, func(ctx context.Context) error {
+		srv := client.HostServiceWithContext(srv, ctx)
+		return uploadFiles(srv, uploadDir, names, uploadOptions(c.opts.UploadWorkers), c.report)
+	})
+}
+
+func (c *cvdCreator) createAndWaitForBoot(srv client.HostOrchestratorService, req *hoapi.CreateCVDRequest) ([]*hoapi.CVD, error) {
+	var op *hoapi.Operation
+	c.started(createPhase, "")
+	err := runPhase(c.ctx, createPhase, c.opts.Timeouts.Create, func(ctx context.Context) error {
+		creds, err := c.credentialsFactory()
+		if err != nil {
+			return err
+		}
+		options := client.CreateCVDOptions{
+			Metadata:       c.opts.Metadata,
+			URLBuildSource: c.opts.urlBuildSource(),
+			Priority:       c.opts.Priority,
+			Preemptible:    c.opts.Preemptible,
+			TTL:            c.opts.TTL,
+		}
+		op, err = client.HostServiceWithContext(srv, ctx).CreateCVDOpWithOptions(req, creds, options)
+		return err
+	})
+	c.done(createPhase, "", err)
+	if err != nil {
+		return nil, err
+	}
+	var res *hoapi.CreateCVDResponse
+	c.started(bootPhase, "")
+	err = runPhase(c.ctx, bootPhase, c.opts.Timeouts.Boot, func(ctx context.Context) error {
+		var err error
+		res, err = client.HostServiceWithContext(srv, ctx).WaitForCreateCVDOp(op.Name)
+		return err
+	})
+	c.done(bootPhase, "", err)
 	if err != nil {
 		return nil, err
 	}
 	return res.CVDs, nil
 }
 
-func credentialsFactoryFromSource(source string) (CredentialsFactory, error) {
+func credentialsFactoryFromSource(source string, store CredentialStore) (CredentialsFactory, error) {
 	switch source {
 	case NoneCredentialsSource:
-		return func() string { return "" }, nil
+		return func() (string, error) { return "", nil }, nil
 	case InjectedCredentialsSource:
-		return func() string { return client.InjectedCredentials }, nil
+		return func() (string, error) { return client.InjectedCredentials, nil }, nil
+	case ADCCredentialsSource:
+		return adcCredentialsFactory(context.Background(), store)
 	default:
 		return nil, fmt.Errorf("unknown credentials source: %s", source)
 	}
 }
 
+// The credential store, if any, takes precedence over the application default credentials, see
+// `buildAPITokenCredentialKey`.
+func adcCredentialsFactory(ctx context.Context, store CredentialStore) (CredentialsFactory, error) {
+	var creds *google.Credentials
+	if store != nil {
+		tok, err := store.Get(buildAPITokenCredentialKey)
+		if err == nil {
+			return func() (string, error) { return string(tok), nil }, nil
+		}
+		if !errors.Is(err, ErrCredentialNotFound) {
+			return nil, fmt.Errorf("failed reading build api token from credential store: %w", err)
+		}
+		b, err := store.Get(adcCredentialKey)
+		if err == nil {
+			if creds, err = google.CredentialsFromJSON(ctx, b, buildAPIScope); err != nil {
+				return nil, fmt.Errorf("invalid application default credentials in credential store: %w", err)
+			}
+		} else if !errors.Is(err, ErrCredentialNotFound) {
+			return nil, fmt.Errorf("failed reading application default credentials from credential store: %w", err)
+		}
+	}
+	if creds == nil {
+		var err error
+		if creds, err = google.FindDefaultCredentials(ctx, buildAPIScope); err != nil {
+			return nil, fmt.Errorf("application default credentials not available, run `gcloud auth application-default login`: %w", err)
+		}
+	}
+	// Caches the token, refreshing it once expired.
+	ts := oauth2.ReuseTokenSource(nil, creds.TokenSource)
+	return func() (string, error) {
+		tok, err := ts.Token()
+		if err != nil {
+			return "", fmt.Errorf("failed obtaining application default credentials token: %w", err)
+		}
+		return tok.AccessToken, nil
+	}, nil
+}
+
 type cvdListResult struct {
 	Result []*RemoteCVD
 	Error  error
 }
 
-func listCVDs(service client.Service, controlDir string) ([]*RemoteHost, error) {
+// Hosts running a host orchestrator version incompatible with this client are left out of the
+// result, a warning listing them is written to `warnOut` instead of failing the whole listing.
+func listCVDs(service client.Service, controlDir string, warnOut io.Writer) ([]*RemoteHost, error) {
 	hl, err := service.ListHosts()
 	if err != nil {
 		return nil, fmt.Errorf("error listing hosts: %w", err)
 	}
 	var hosts []string
+	capacities := make(map[string]int)
 	for _, host := range hl.Items {
 		hosts = append(hosts, host.Name)
+		if host.Capacity != nil {
+			capacities[host.Name] = int(host.Capacity.MaxInstances)
+		}
 	}
 	var chans []chan cvdListResult
 	statuses, merr := listCVDConnections(controlDir)
+	limiter := newAdaptiveLimiter(maxListConcurrency)
 	for _, host := range hosts {
 		ch := make(chan cvdListResult)
 		chans = append(chans, ch)
 		go func(name string, ch chan<- cvdListResult) {
-			cvds, err := listHostCVDsInner(service, name, statuses)
+			cvds, err := listHostCVDsLimited(service, name, statuses, limiter)
 			ch <- cvdListResult{Result: cvds, Error: err}
 		}(host, ch)
 	}
 	var result []*RemoteHost
+	var incompatible []string
 	for i, ch := range chans {
 		hostName := hosts[i]
 		listResult := <-ch
+		var verErr *client.IncompatibleVersionError
+		if errors.As(listResult.Error, &verErr) {
+			incompatible = append(incompatible, hostName)
+			continue
+		}
 		if listResult.Error != nil {
-			merr = multierror.Append(merr, fmt.Errorf("lists cvds for host %q failed: %w", hostName, err))
+			err := fmt.Errorf("lists cvds for host %q failed: %w", hostName, listResult.Error)
+			merr = multierror.Append(merr, &hostError{Host: hostName, Err: err})
 		}
 		host := &RemoteHost{
 			ServiceRootEndpoint: service.RootURI(),
 			Name:                hostName,
 			CVDs:                listResult.Result,
+			MaxInstances:        capacities[hostName],
 		}
 		result = append(result, host)
 	}
+	if len(incompatible) > 0 {
+		fmt.Fprintf(warnOut, "Warning: skipped hosts running an incompatible host orchestrator version: %s\n",
+			strings.Join(incompatible, ", "))
+	}
 	return result, merr
 }
 
@@ -380,15 +1181,35 @@ func flattenCVDs(hosts []*RemoteHost) []*RemoteCVD {
 	return result
 }
 
+// Lists the host's CVDs within the limiter's concurrency, reporting its rate limited requests to
+// the limiter as they happen.
+func listHostCVDsLimited(service client.Service, host string, statuses map[RemoteCVDLocator]ConnStatus, limiter *adaptiveLimiter) ([]*RemoteCVD, error) {
+	limiter.Acquire()
+	srv := client.HostServiceWithThrottleHook(service.HostService(host), limiter.Throttled)
+	cvds, err := listHostServiceCVDs(service.RootURI(), srv, host, statuses)
+	var throttledErr *client.ThrottledError
+	limiter.Release(errors.As(err, &throttledErr))
+	return cvds, err
+}
+
 // Calling listCVDConnectionsByHost is inefficient, this internal function avoids that for listAllCVDs.
 func listHostCVDsInner(service client.Service, host string, statuses map[RemoteCVDLocator]ConnStatus) ([]*RemoteCVD, error) {
-	cvds, err := service.HostService(host).ListCVDs()
+	return listHostServiceCVDs(service.RootURI(), service.HostService(host), host, statuses)
+}
+
+func listHostServiceCVDs(rootURI string, srv client.HostOrchestratorService, host string, statuses map[RemoteCVDLocator]ConnStatus) ([]*RemoteCVD, error) {
+	cvds, err := srv.ListCVDDetails()
 	if err != nil {
 		return nil, err
 	}
 	ret := make([]*RemoteCVD, len(cvds))
 	for i, c := range cvds {
-		ret[i] = NewRemoteCVD(service.RootURI(), host, c)
+		ret[i] = NewRemoteCVD(rootURI, host, &c.CVD)
+		// An unexpected format is taken as not expiring, like with hosts not reporting it.
+		if t, err := time.Parse(time.RFC3339, c.ExpireTime); err == nil {
+			ret[i].ExpireTime = t
+		}
+		ret[i].SerialNumber = c.SerialNumber
 		if status, ok := statuses[ret[i].RemoteCVDLocator]; ok {
 			ret[i].ConnStatus = &status
 		}
@@ -443,14 +1264,65 @@ func ListLocalImageRequiredFiles(buildTop, productOut string) ([]string, error)
 	return result, nil
 }
 
+// Images every Cuttlefish device boots from, a required images list without them is likely malformed.
+var commonRequiredImages = []string{"boot.img", "super.img", "vbmeta.img"}
+
+func missingCommonImages(names []string) []string {
+	listed := make(map[string]bool)
+	for _, n := range names {
+		listed[filepath.Base(n)] = true
+	}
+	var result []string
+	for _, img := range commonRequiredImages {
+		if !listed[img] {
+			result = append(result, img)
+		}
+	}
+	return result
+}
+
+// Checks every file exists and is not empty before uploading any, reporting all the problems at
+// once.
+func verifyLocalFiles(names []string) error {
+	var merr error
+	for _, n := range names {
+		info, err := os.Stat(n)
+		switch {
+		case err != nil:
+			merr = multierror.Append(merr, err)
+		case !info.Mode().IsRegular():
+			merr = multierror.Append(merr, fmt.Errorf("%q is not a regular file", n))
+		case info.Size() == 0:
+			merr = multierror.Append(merr, fmt.Errorf("%q is empty", n))
+		}
+	}
+	if merr != nil {
+		return fmt.Errorf("invalid local build files: %w", merr)
+	}
+	return nil
+}
+
 func getTargetArch(buildTop string) (string, error) {
-	// `$ANDROID_BUILD_TOP/out/soong_ui` can bring values of build variables, set by `lunch` command.
-	// https://cs.android.com/android/platform/superproject/main/+/main:build/soong/cmd/soong_ui/main.go;l=298
-	bin := filepath.Join(buildTop, "out/soong_ui")
-	cmdOut, err := exec.Command(bin, "--dumpvar-mode", "TARGET_ARCH").Output()
+	arch, err := getBuildVar(buildTop, "TARGET_ARCH", false)
 	if err != nil {
 		return "", fmt.Errorf("error while getting target arch: %w", err)
 	}
+	return arch, nil
+}
+
+// `$ANDROID_BUILD_TOP/out/soong_ui` can bring values of build variables, set by `lunch` command.
+// https://cs.android.com/android/platform/superproject/main/+/main:build/soong/cmd/soong_ui/main.go;l=298
+// Paths are made absolute if `abs` is true.
+func getBuildVar(buildTop, name string, abs bool) (string, error) {
+	bin := filepath.Join(buildTop, "out/soong_ui")
+	args := []string{"--dumpvar-mode"}
+	if abs {
+		args = append(args, "--abs")
+	}
+	cmdOut, err := exec.Command(bin, append(args, name)...).Output()
+	if err != nil {
+		return "", err
+	}
 	return strings.TrimSpace(string(cmdOut)), nil
 }
 
@@ -478,6 +1350,78 @@ func verifyCVDHostPackageTar(dir string) error {
 	if tarInfo.ModTime().Before(dirInfo.ModTime()) {
 		return fmt.Errorf("%q out of date. Please run `m hosttar`", CVDHostPackageName)
 	}
+	return verifySHA256Sidecar(filepath.Join(dir, CVDHostPackageName))
+}
+
+// Entries of the host package needed to launch devices.
+var cvdHostPackageRequiredEntries = []string{"bin/cvd", "bin/launch_cvd"}
+
+// Reads the entries of the gzipped host package, checking it's complete and has the required
+// entries.
+func verifyCVDHostPackageContents(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed opening %q: %w", name, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%q is not a gzipped tar: %w", name, err)
+	}
+	defer gz.Close()
+	entries := make(map[string]bool)
+	r := tar.NewReader(gz)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%q is truncated or corrupt. Please run `m hosttar`: %w", name, err)
+		}
+		entries[strings.TrimPrefix(hdr.Name, "./")] = true
+	}
+	missing := []string{}
+	for _, e := range cvdHostPackageRequiredEntries {
+		if !entries[e] {
+			missing = append(missing, e)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%q lacks %s. Please run `m hosttar`", name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+const sha256SidecarExt = ".sha256"
+
+// Verifies the digest of the given file matches the one in its `.sha256` sidecar file, if any.
+// The sidecar file content is expected to follow the `sha256sum` output format.
+func verifySHA256Sidecar(name string) error {
+	content, err := os.ReadFile(name + sha256SidecarExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading %q digest: %w", filepath.Base(name), err)
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty digest file: %q", name+sha256SidecarExt)
+	}
+	expected := strings.ToLower(fields[0])
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed computing %q digest: %w", filepath.Base(name), err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != expected {
+		return fmt.Errorf("%q is corrupted, sha256 digest mismatch: expected %s, got %s", filepath.Base(name), expected, got)
+	}
 	return nil
 }
 
@@ -505,9 +1449,134 @@ func (o *CreateCVDLocalOpts) srcs() []string {
 	for _, v := range o.LocalImagesSrcs {
 		result = append(result, v)
 	}
+	if o.LocalVendorBootSrc != "" {
+		result = replaceLocalImage(result, o.LocalVendorBootSrc)
+	}
+	if o.LocalSuperImageSrc != "" {
+		result = replaceLocalImage(result, o.LocalSuperImageSrc)
+	}
 	return result
 }
 
+// Local files uploaded along with a build from Android CI.
+func (o *CreateCVDLocalOpts) localCIComplements() []string {
+	result := []string{}
+	if o.LocalVendorBootSrc != "" {
+		result = append(result, o.LocalVendorBootSrc)
+	}
+	return result
+}
+
+// Replaces the file with the same name as `src` in the list, or appends it if there is none.
+func replaceLocalImage(names []string, src string) []string {
+	result := []string{}
+	for _, n := range names {
+		if filepath.Base(n) != filepath.Base(src) {
+			result = append(result, n)
+		}
+	}
+	return append(result, src)
+}
+
+const (
+	VendorBootImageName = "vendor_boot.img"
+	// https://android.googlesource.com/platform/system/tools/mkbootimg/+/refs/heads/main/include/bootimg/bootimg.h
+	vendorBootMagic = "VNDRBOOT"
+)
+
+func validateVendorBootImage(name string) error {
+	if filepath.Base(name) != VendorBootImageName {
+		return fmt.Errorf("file name must be %q, got: %q", VendorBootImageName, filepath.Base(name))
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, len(vendorBootMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return fmt.Errorf("failed reading %q header: %w", name, err)
+	}
+	if string(magic) != vendorBootMagic {
+		return fmt.Errorf("%q is not a vendor boot image", name)
+	}
+	return nil
+}
+
+const (
+	SuperImageName = "super.img"
+	// https://android.googlesource.com/platform/system/core/+/refs/heads/main/libsparse/sparse_format.h
+	sparseImageMagic = 0xed26ff3a
+	// https://android.googlesource.com/platform/system/core/+/refs/heads/main/fs_mgr/liblp/include/liblp/metadata_format.h
+	lpGeometryMagic = 0x616c4467
+	// Unsparsed super images start with this many reserved bytes, followed by the metadata geometry.
+	lpReservedBytes = 4096
+)
+
+// Images of the partitions inside the super image, they can't be replaced along with it.
+var dynamicPartitionImages = []string{
+	"system.img", "system_ext.img", "system_dlkm.img", "product.img",
+	"vendor.img", "vendor_dlkm.img", "odm.img", "odm_dlkm.img",
+}
+
+func (o *CreateCVDOpts) validateLocalSuperImage() error {
+	if !o.LocalImage && o.CreateCVDLocalOpts.empty() {
+		return errors.New("a local super image is only supported with a local build or local sources")
+	}
+	if o.EnvConfig != nil {
+		return errors.New("a local super image cannot be used with an environment specification")
+	}
+	if o.LocalImagesZipSrc != "" {
+		return errors.New("a local super image cannot be used with an images zip, which has the build's super image")
+	}
+	var conflicting []string
+	for _, src := range o.LocalImagesSrcs {
+		base := filepath.Base(src)
+		if base == SuperImageName {
+			conflicting = append(conflicting, base)
+		}
+		for _, img := range dynamicPartitionImages {
+			if base == img {
+				conflicting = append(conflicting, base)
+			}
+		}
+	}
+	if len(conflicting) > 0 {
+		return fmt.Errorf("a local super image cannot be used with the images of the partitions it holds: %s",
+			strings.Join(conflicting, ", "))
+	}
+	if err := validateSuperImage(o.LocalSuperImageSrc); err != nil {
+		return fmt.Errorf("invalid local super image: %w", err)
+	}
+	return nil
+}
+
+// Accepts sparse and raw super images.
+func validateSuperImage(name string) error {
+	if filepath.Base(name) != SuperImageName {
+		return fmt.Errorf("file name must be %q, got: %q", SuperImageName, filepath.Base(name))
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return fmt.Errorf("failed reading %q header: %w", name, err)
+	}
+	if binary.LittleEndian.Uint32(magic) == sparseImageMagic {
+		return nil
+	}
+	if _, err := f.ReadAt(magic, lpReservedBytes); err != nil {
+		return fmt.Errorf("failed reading %q metadata geometry: %w", name, err)
+	}
+	if binary.LittleEndian.Uint32(magic) != lpGeometryMagic {
+		return fmt.Errorf("%q is not a super image", name)
+	}
+	return nil
+}
+
 func (o *CreateCVDLocalOpts) empty() bool {
 	return o.LocalBootloaderSrc == "" && o.LocalCVDHostPkgSrc == "" &&
 		len(o.LocalImagesSrcs) == 0
@@ -519,31 +1588,33 @@ func (s MissingEnvVarErr) Error() string {
 	return fmt.Sprintf("Missing environment variable: %q", string(s))
 }
 
-func envVar(name string) (string, error) {
-	if _, ok := os.LookupEnv(name); !ok {
-		return "", MissingEnvVarErr(name)
-	}
-	return os.Getenv(name), nil
-}
+// Times a file is uploaded before giving up on it. Each attempt already retries its chunks, a new
+// attempt starts the file over after the chunk retries are exhausted. Only transient failures are
+// retried, see client.IsTransientUploadError.
+const maxFileUploadAttempts = 3
 
-func uploadFiles(srv client.HostOrchestratorService, uploadDir string, names []string, statePrinter *statePrinter) error {
+// Uploads the files one by one, a file failing doesn't abort the upload of the rest. Returns the
+// failures of all files exhausting their attempts.
+func uploadFiles(srv client.HostOrchestratorService, uploadDir string, names []string, uploadOpts client.UploadOptions, report func(CreateEvent)) error {
+	var merr error
 	extractOps := []string{}
 	for _, name := range names {
-		state := fmt.Sprintf("Uploading %q", filepath.Base(name))
-		statePrinter.Print(state)
-		err := srv.UploadFile(uploadDir, name)
-		statePrinter.PrintDone(state, err)
-		if err != nil {
-			return err
+		if err := uploadFile(srv, uploadDir, name, uploadOpts, report); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed uploading %q: %w", filepath.Base(name), err))
+			continue
 		}
 		if strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".zip") {
 			op, err := srv.ExtractFile(uploadDir, filepath.Base(name))
 			if err != nil {
-				return fmt.Errorf("failed uploading files: %w", err)
+				merr = multierror.Append(merr, fmt.Errorf("failed extracting %q: %w", filepath.Base(name), err))
+				continue
 			}
 			extractOps = append(extractOps, op.Name)
 		}
 	}
+	if merr != nil {
+		return fmt.Errorf("failed uploading files: %w", merr)
+	}
 	for _, name := range extractOps {
 		if err := srv.WaitForOperation(name, nil); err != nil {
 			return fmt.Errorf("failed uploading files: %w", err)
@@ -551,3 +1622,23 @@ func uploadFiles(srv client.HostOrchestratorService, uploadDir string, names []s
 	}
 	return nil
 }
+
+func uploadFile(srv client.HostOrchestratorService, uploadDir, name string, uploadOpts client.UploadOptions, report func(CreateEvent)) error {
+	state := fmt.Sprintf("Uploading %q", filepath.Base(name))
+	report(CreateEvent{Kind: CreateEventStarted, Phase: uploadPhase, Msg: state})
+	var err error
+	for attempt := 1; attempt <= maxFileUploadAttempts; attempt++ {
+		if err = srv.UploadFileWithOptions(uploadDir, name, uploadOpts); err == nil || !client.IsTransientUploadError(err) {
+			break
+		}
+		if attempt < maxFileUploadAttempts {
+			report(CreateEvent{
+				Kind: CreateEventWarning,
+				Msg:  fmt.Sprintf("Uploading %q failed, retrying (%d/%d): %v", filepath.Base(name), attempt, maxFileUploadAttempts-1, err),
+			})
+			time.Sleep(uploadOpts.BackOffOpts.InitialDuration)
+		}
+	}
+	report(CreateEvent{Kind: CreateEventDone, Phase: uploadPhase, Msg: state, Err: err})
+	return err
+}
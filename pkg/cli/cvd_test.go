@@ -15,12 +15,30 @@
 package cli
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
 	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
 )
 
 func TestListLocalImageRequiredFiles(t *testing.T) {
@@ -51,6 +69,33 @@ func TestListLocalImageRequiredFiles(t *testing.T) {
 	}
 }
 
+func TestVerifyLocalFilesReportsEveryProblem(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "boot.img")
+	empty := filepath.Join(dir, "super.img")
+	if err := os.WriteFile(valid, []byte("lorem"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(empty, nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	err := verifyLocalFiles([]string{valid, empty, filepath.Join(dir, "vbmeta.img")})
+
+	var merr *multierror.Error
+	if !errors.As(err, &merr) || len(merr.Errors) != 2 {
+		t.Errorf("expected 2 errors, got: %v", err)
+	}
+}
+
+func TestMissingCommonImages(t *testing.T) {
+	got := missingCommonImages([]string{"/out/boot.img", "/out/vbmeta.img"})
+
+	if diff := cmp.Diff([]string{"super.img"}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestAdditionalInstancesNum(t *testing.T) {
 	tests := []struct {
 		in int
@@ -95,3 +140,912 @@ func TestGetHostOutRelativePathFailsUnknownTargetArch(t *testing.T) {
 		t.Errorf("expected error")
 	}
 }
+
+func TestVerifySHA256Sidecar(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "foo.tar.gz")
+	content := []byte("foo")
+	if err := os.WriteFile(name, content, 0660); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	// No sidecar file.
+	if err := verifySHA256Sidecar(name); err != nil {
+		t.Errorf("expected no error without digest file, got: %v", err)
+	}
+	// Matching digest.
+	if err := os.WriteFile(name+sha256SidecarExt, []byte(digest+"  foo.tar.gz\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySHA256Sidecar(name); err != nil {
+		t.Errorf("expected no error with matching digest, got: %v", err)
+	}
+	// Corrupted file.
+	if err := os.WriteFile(name, []byte("bar"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySHA256Sidecar(name); err == nil {
+		t.Errorf("expected error with mismatching digest")
+	}
+}
+
+func TestRunPhaseTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	err := runPhase(context.Background(), bootPhase, time.Millisecond, func(context.Context) error {
+		<-done
+		return nil
+	})
+
+	var timeoutErr *PhaseTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected phase timeout error, got: %v", err)
+	}
+	if diff := cmp.Diff(bootPhase, timeoutErr.Phase); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunPhaseTimeoutCancelsContext(t *testing.T) {
+	canceled := make(chan struct{})
+
+	runPhase(context.Background(), uploadPhase, time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	})
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the phase's context to be canceled")
+	}
+}
+
+func TestValidateVendorBootImage(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, VendorBootImageName)
+	if err := os.WriteFile(valid, []byte(vendorBootMagic+"foo"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	invalidName := filepath.Join(dir, "boot.img")
+	if err := os.WriteFile(invalidName, []byte(vendorBootMagic+"foo"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	invalidMagicDir := filepath.Join(dir, "bar")
+	if err := os.Mkdir(invalidMagicDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	invalidMagic := filepath.Join(invalidMagicDir, VendorBootImageName)
+	if err := os.WriteFile(invalidMagic, []byte("ANDROID!foo"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateVendorBootImage(valid); err != nil {
+		t.Errorf("expected valid image, got: %v", err)
+	}
+	if err := validateVendorBootImage(invalidName); err == nil {
+		t.Errorf("expected error for invalid name")
+	}
+	if err := validateVendorBootImage(invalidMagic); err == nil {
+		t.Errorf("expected error for invalid magic")
+	}
+}
+
+func TestValidateSuperImage(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0660); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	sparse := []byte{0x3a, 0xff, 0x26, 0xed}
+	raw := append(make([]byte, lpReservedBytes), 0x67, 0x44, 0x6c, 0x61)
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{write("sparse/super.img", sparse), true},
+		{write("raw/super.img", raw), true},
+		{write("system.img", sparse), false},
+		{write("truncated/super.img", make([]byte, 100)), false},
+		{write("invalid/super.img", make([]byte, 2*lpReservedBytes)), false},
+	}
+	for _, tc := range tests {
+		err := validateSuperImage(tc.path)
+		if tc.valid && err != nil {
+			t.Errorf("expected %q to be valid, got: %v", tc.path, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %q to be invalid", tc.path)
+		}
+	}
+}
+
+func TestLocalVendorBootImageReplacesTheBuildOne(t *testing.T) {
+	productOut := []string{"out/boot.img", "out/vendor_boot.img", "out/super.img"}
+
+	got := replaceLocalImage(productOut, "custom/vendor_boot.img")
+
+	exp := []string{"out/boot.img", "out/super.img", "custom/vendor_boot.img"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLocalSrcsReplaceImagesOfTheSameName(t *testing.T) {
+	opts := CreateCVDLocalOpts{
+		LocalBootloaderSrc: "bootloader",
+		LocalCVDHostPkgSrc: "cvd-host_package.tar.gz",
+		LocalImagesSrcs:    []string{"out/boot.img", "out/vendor_boot.img"},
+		LocalVendorBootSrc: "custom/vendor_boot.img",
+	}
+
+	got := opts.srcs()
+
+	exp := []string{"bootloader", "cvd-host_package.tar.gz", "out/boot.img", "custom/vendor_boot.img"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("srcs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateLocalSuperImageConflicts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SuperImageName)
+	if err := os.WriteFile(path, []byte{0x3a, 0xff, 0x26, 0xed}, 0660); err != nil {
+		t.Fatal(err)
+	}
+	local := CreateCVDLocalOpts{
+		LocalBootloaderSrc: "bootloader",
+		LocalCVDHostPkgSrc: "cvd-host_package.tar.gz",
+		LocalSuperImageSrc: path,
+	}
+	tests := []struct {
+		name  string
+		opts  CreateCVDOpts
+		valid bool
+	}{
+		{"local sources", CreateCVDOpts{CreateCVDLocalOpts: local}, true},
+		{"local build", CreateCVDOpts{LocalImage: true, CreateCVDLocalOpts: CreateCVDLocalOpts{LocalSuperImageSrc: path}}, true},
+		{"android ci build", CreateCVDOpts{CreateCVDLocalOpts: CreateCVDLocalOpts{LocalSuperImageSrc: path}}, false},
+		{"partition image", func() CreateCVDOpts {
+			l := local
+			l.LocalImagesSrcs = []string{"boot.img", "out/vendor.img"}
+			return CreateCVDOpts{CreateCVDLocalOpts: l}
+		}(), false},
+		{"images zip", func() CreateCVDOpts {
+			l := local
+			l.LocalImagesZipSrc = "aosp_cf_x86_64_phone-img-12345.zip"
+			return CreateCVDOpts{CreateCVDLocalOpts: l}
+		}(), false},
+	}
+	for _, tc := range tests {
+		err := tc.opts.validateLocalSuperImage()
+		if tc.valid && err != nil {
+			t.Errorf("%s: expected no error, got: %v", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestADCCredentialsFactoryMissingCredentials(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+
+	_, err := credentialsFactoryFromSource(ADCCredentialsSource, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "gcloud auth application-default login") {
+		t.Errorf("expected error with guidance, got: %v", err)
+	}
+	if strings.Contains(err.Error(), InjectedCredentialsSource) {
+		t.Errorf("unexpected hint of the injected credentials, lookups cvdr makes can't use them: %v", err)
+	}
+}
+
+func TestADCCredentialsFactoryTakesTheStoredBuildAPIToken(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	store := mapCredentialStore{buildAPITokenCredentialKey: "foo"}
+
+	cf, err := credentialsFactoryFromSource(ADCCredentialsSource, store)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := cf(); err != nil || token != "foo" {
+		t.Errorf("expected the stored token, got: %q, %v", token, err)
+	}
+}
+
+func TestADCCredentialsFactoryTakesTheStoredCredentials(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	store := mapCredentialStore{
+		adcCredentialKey: `{"type": "authorized_user", "client_id": "foo", "client_secret": "bar", "refresh_token": "baz"}`,
+	}
+
+	if _, err := credentialsFactoryFromSource(ADCCredentialsSource, store); err != nil {
+		t.Errorf("expected the stored credentials to be used, got: %v", err)
+	}
+}
+
+func TestNewCVDCreatorHintsTheInjectedCredentials(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+
+	_, err := newCVDCreator(&fakeService{}, CreateCVDOpts{BuildAPICredentialsSource: ADCCredentialsSource}, func(CreateEvent) {})
+
+	if err == nil || !strings.Contains(err.Error(), InjectedCredentialsSource) {
+		t.Errorf("expected error hinting the injected credentials, got: %v", err)
+	}
+}
+
+func TestNewBuildAPIForwardsCredentials(t *testing.T) {
+	var got client.BuildAPICredentials
+	opts := &subCommandOpts{
+		BuildAPIBuilder: func(_, _ string, credentials client.BuildAPICredentials, _ io.Writer) (client.BuildAPI, error) {
+			got = credentials
+			return nil, nil
+		},
+	}
+
+	if _, err := newBuildAPI(&cobra.Command{}, opts, "", NoneCredentialsSource, "", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if got == nil {
+		t.Fatal("expected credentials")
+	}
+	if token, err := got(); err != nil || token != "" {
+		t.Errorf("expected an empty token, got: %q, %v", token, err)
+	}
+	if _, err := newBuildAPI(&cobra.Command{}, opts, "", InjectedCredentialsSource, "", io.Discard); err == nil {
+		t.Error("expected an error for the injected credentials")
+	}
+}
+
+type incompatibleHostService struct {
+	fakeHostService
+}
+
+func (incompatibleHostService) ListCVDDetails() ([]*client.CVDDetails, error) {
+	return nil, &client.IncompatibleVersionError{Err: errors.New("unexpected response")}
+}
+
+type mixedVersionsService struct {
+	fakeService
+}
+
+func (mixedVersionsService) HostService(host string) client.HostOrchestratorService {
+	if host == "bar" {
+		return &incompatibleHostService{}
+	}
+	return &fakeHostService{}
+}
+
+func TestListCVDsSkipsIncompatibleHosts(t *testing.T) {
+	warnOut := &bytes.Buffer{}
+
+	hosts, err := listCVDs(&mixedVersionsService{}, t.TempDir(), warnOut)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Name != "foo" {
+		t.Errorf("unexpected hosts: %+v", hosts)
+	}
+	if !strings.Contains(warnOut.String(), "bar") {
+		t.Errorf("expected warning listing host bar, got: %q", warnOut.String())
+	}
+}
+
+type throttledHostService struct {
+	fakeHostService
+	s    *throttledService
+	hook client.ThrottleHook
+}
+
+func (s *throttledHostService) WithThrottleHook(hook client.ThrottleHook) client.HostOrchestratorService {
+	return &throttledHostService{s: s.s, hook: hook}
+}
+
+// Rate limited like the http client does, reporting every response to the hook before retrying.
+func (s *throttledHostService) ListCVDDetails() ([]*client.CVDDetails, error) {
+	s.s.mtx.Lock()
+	s.s.listings++
+	s.s.mtx.Unlock()
+	for i := 0; i < s.s.throttles; i++ {
+		if s.hook != nil {
+			if resume := s.hook(); resume != nil {
+				resume()
+			}
+		}
+	}
+	if s.s.giveUp {
+		return nil, &client.ThrottledError{}
+	}
+	return s.fakeHostService.ListCVDDetails()
+}
+
+type throttledService struct {
+	fakeService
+	// Rate limited responses of every listing.
+	throttles int
+	// Whether the listings give up after being rate limited.
+	giveUp   bool
+	mtx      sync.Mutex
+	listings int
+}
+
+func (s *throttledService) HostService(host string) client.HostOrchestratorService {
+	return &throttledHostService{s: s}
+}
+
+func TestListCVDsThrottledHosts(t *testing.T) {
+	tests := []struct {
+		name      string
+		throttles int
+		giveUp    bool
+	}{
+		{name: "throttled once", throttles: 1},
+		{name: "throttled until giving up", throttles: 3, giveUp: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &throttledService{throttles: tc.throttles, giveUp: tc.giveUp}
+
+			hosts, err := listCVDs(service, t.TempDir(), io.Discard)
+
+			var throttledErr *client.ThrottledError
+			if tc.giveUp != errors.As(err, &throttledErr) {
+				t.Fatalf("expected throttled error: %t, got: %v", tc.giveUp, err)
+			}
+			if service.listings != 2 {
+				t.Errorf("expected every host listed once, got %d listings", service.listings)
+			}
+			if tc.giveUp {
+				return
+			}
+			if cvds := flattenCVDs(hosts); len(cvds) != 2 {
+				t.Errorf("expected a cvd in every host, got: %+v", cvds)
+			}
+		})
+	}
+}
+
+type noHostsService struct {
+	fakeService
+}
+
+func (noHostsService) ListHosts() (*apiv1.ListHostsResponse, error) {
+	return &apiv1.ListHostsResponse{}, nil
+}
+
+type noCVDsHostService struct {
+	fakeHostService
+}
+
+func (noCVDsHostService) ListCVDDetails() ([]*client.CVDDetails, error) {
+	return []*client.CVDDetails{}, nil
+}
+
+type noCVDsService struct {
+	fakeService
+}
+
+func (noCVDsService) HostService(host string) client.HostOrchestratorService {
+	return &noCVDsHostService{}
+}
+
+func TestListCVDsNoHosts(t *testing.T) {
+	hosts, err := listCVDs(&noHostsService{}, t.TempDir(), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+
+	writeEmptyListNotice(out, hosts)
+
+	if !strings.Contains(out.String(), "No hosts exist") {
+		t.Errorf("expected no hosts notice, got: %q", out.String())
+	}
+	if _, err := rankHostsByLoad(&noHostsService{}, 1, nil); !errors.Is(err, errNoHosts) {
+		t.Errorf("expected %v, got: %v", errNoHosts, err)
+	}
+}
+
+func TestListCVDsNoCVDs(t *testing.T) {
+	hosts, err := listCVDs(&noCVDsService{}, t.TempDir(), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+
+	writeEmptyListNotice(out, hosts)
+
+	if !strings.Contains(out.String(), "No devices exist in the 2 hosts") {
+		t.Errorf("expected no devices notice, got: %q", out.String())
+	}
+	out.Reset()
+	writeEmptyListNotice(out, []*RemoteHost{{Name: "foo", CVDs: []*RemoteCVD{{}}}})
+	if out.Len() != 0 {
+		t.Errorf("expected no notice listing devices, got: %q", out.String())
+	}
+}
+
+type urlBuildSourceHostService struct {
+	fakeHostService
+	options *client.CreateCVDOptions
+}
+
+func (s *urlBuildSourceHostService) CreateCVDOpWithOptions(req *hoapi.CreateCVDRequest, creds string, opts client.CreateCVDOptions) (*hoapi.Operation, error) {
+	s.options = &opts
+	return &hoapi.Operation{Name: "op"}, nil
+}
+
+type urlBuildSourceService struct {
+	fakeService
+	hostSrv *urlBuildSourceHostService
+}
+
+func (s *urlBuildSourceService) HostService(host string) client.HostOrchestratorService {
+	return s.hostSrv
+}
+
+func TestCreateCVDWithURLBuildSource(t *testing.T) {
+	service := &urlBuildSourceService{hostSrv: &urlBuildSourceHostService{}}
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		MainBuild:                 hoapi.AndroidCIBuild{Branch: "main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		KernelURL:                 "https://example.com/bzImage",
+		InitramfsURL:              "https://example.com/initramfs.img",
+		BuildAPICredentialsSource: NoneCredentialsSource,
+		DisplayDefaults:           defaultDisplaysByDeviceType,
+	}
+
+	if _, err := runCreateCVD(service, opts, func(CreateEvent) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &client.URLBuildSource{KernelURL: opts.KernelURL, InitramfsURL: opts.InitramfsURL}
+	if diff := cmp.Diff(exp, service.hostSrv.options.URLBuildSource); diff != "" {
+		t.Errorf("url build source mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateCVDWithPriority(t *testing.T) {
+	service := &urlBuildSourceService{hostSrv: &urlBuildSourceHostService{}}
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		MainBuild:                 hoapi.AndroidCIBuild{Branch: "main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		Priority:                  10,
+		Preemptible:               true,
+		BuildAPICredentialsSource: NoneCredentialsSource,
+	}
+
+	cvds, err := runCreateCVD(service, opts, func(CreateEvent) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := service.hostSrv.options; got.Priority != 10 || !got.Preemptible {
+		t.Errorf("expected priority 10 and preemptible forwarded, got: %+v", got)
+	}
+	for _, cvd := range cvds {
+		if !cvd.Preemptible {
+			t.Errorf("expected %q to be preemptible", cvd.Name)
+		}
+	}
+}
+
+func TestCreateCVDRejectsInvalidArtifactURLs(t *testing.T) {
+	tests := []CreateCVDOpts{
+		{KernelURL: "ftp://example.com/bzImage"},
+		{InitramfsURL: "/tmp/initramfs.img"},
+		{KernelURL: "https://example.com/bzImage", GPUMode: "gfxstream"},
+	}
+	for _, opts := range tests {
+		opts.BuildAPICredentialsSource = NoneCredentialsSource
+		service := &urlBuildSourceService{hostSrv: &urlBuildSourceHostService{}}
+
+		if _, err := runCreateCVD(service, opts, func(CreateEvent) {}); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+		if service.hostSrv.options != nil {
+			t.Errorf("unexpected create request for %+v", opts)
+		}
+	}
+}
+
+type flakyUploadHostService struct {
+	fakeHostService
+	// Failures left by file, a negative count fails the file forever.
+	failures map[string]int
+	// Returned by the failures, a server error if nil.
+	err      error
+	attempts int
+	uploaded []string
+}
+
+func (s *flakyUploadHostService) UploadFileWithOptions(uploadDir, name string, options client.UploadOptions) error {
+	s.attempts++
+	if n := s.failures[name]; n != 0 {
+		s.failures[name] = n - 1
+		if s.err != nil {
+			return s.err
+		}
+		return &client.ApiCallError{Code: http.StatusServiceUnavailable}
+	}
+	s.uploaded = append(s.uploaded, name)
+	return nil
+}
+
+func TestUploadFilesRetriesFailedFiles(t *testing.T) {
+	srv := &flakyUploadHostService{failures: map[string]int{"b.img": maxFileUploadAttempts - 1}}
+
+	err := uploadFiles(srv, "dir", []string{"a.img", "b.img", "c.img"}, client.UploadOptions{}, func(CreateEvent) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"a.img", "b.img", "c.img"}, srv.uploaded); diff != "" {
+		t.Errorf("uploaded files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUploadFilesReportsEveryExhaustedFile(t *testing.T) {
+	srv := &flakyUploadHostService{failures: map[string]int{"a.img": -1, "c.img": -1}}
+
+	err := uploadFiles(srv, "dir", []string{"a.img", "b.img", "c.img"}, client.UploadOptions{}, func(CreateEvent) {})
+
+	var merr *multierror.Error
+	if !errors.As(err, &merr) || len(merr.Errors) != 2 {
+		t.Fatalf("expected 2 upload failures, got: %v", err)
+	}
+	if diff := cmp.Diff([]string{"b.img"}, srv.uploaded); diff != "" {
+		t.Errorf("uploaded files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUploadFilesDoesNotRetryPermanentFailures(t *testing.T) {
+	for _, failure := range []error{&client.ApiCallError{Code: http.StatusNotFound}, os.ErrNotExist} {
+		srv := &flakyUploadHostService{failures: map[string]int{"a.img": -1}, err: failure}
+
+		err := uploadFiles(srv, "dir", []string{"a.img"}, client.UploadOptions{}, func(CreateEvent) {})
+
+		if err == nil {
+			t.Errorf("%v: expected an error", failure)
+		}
+		if srv.attempts != 1 {
+			t.Errorf("%v: expected a single attempt, got %d", failure, srv.attempts)
+		}
+	}
+}
+
+type fetchConcurrencyHostService struct {
+	fakeHostService
+	options client.FetchArtifactsOptions
+	// Reported as the effective bandwidth limit.
+	bandwidthLimit int64
+	// Reported as the failed downloads retried.
+	retries int
+}
+
+func (s *fetchConcurrencyHostService) FetchArtifactsOp(req *hoapi.FetchArtifactsRequest, creds string, opts client.FetchArtifactsOptions) (*hoapi.Operation, error) {
+	s.options = opts
+	return &hoapi.Operation{Name: "op"}, nil
+}
+
+func (s *fetchConcurrencyHostService) WaitForFetchArtifactsOp(name string) (*client.FetchArtifactsResult, error) {
+	res, _ := s.fakeHostService.WaitForFetchArtifactsOp(name)
+	res.FetchConcurrency = 8
+	res.FetchBandwidthLimit = s.bandwidthLimit
+	res.FetchRetries = s.retries
+	return res, nil
+}
+
+type fetchConcurrencyService struct {
+	fakeService
+	hostSrv *fetchConcurrencyHostService
+}
+
+func (s *fetchConcurrencyService) HostService(host string) client.HostOrchestratorService {
+	return s.hostSrv
+}
+
+func TestCreateCVDReportsClampedFetchConcurrency(t *testing.T) {
+	service := &fetchConcurrencyService{hostSrv: &fetchConcurrencyHostService{}}
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		MainBuild:                 hoapi.AndroidCIBuild{Branch: "main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		BuildAPICredentialsSource: NoneCredentialsSource,
+		FetchConcurrency:          16,
+	}
+	warnings := []string{}
+
+	_, err := runCreateCVD(service, opts, func(e CreateEvent) {
+		if e.Kind == CreateEventWarning {
+			warnings = append(warnings, e.Msg)
+		}
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.hostSrv.options.FetchConcurrency != 16 {
+		t.Errorf("expected a fetch concurrency of 16, got: %d", service.hostSrv.options.FetchConcurrency)
+	}
+	exp := []string{"the host fetched the main build with a concurrency of 8 instead of 16"}
+	if diff := cmp.Diff(exp, warnings); diff != "" {
+		t.Errorf("warnings mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateCVDReportsFetchBandwidthLimit(t *testing.T) {
+	service := &fetchConcurrencyService{hostSrv: &fetchConcurrencyHostService{bandwidthLimit: 20 << 20}}
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		MainBuild:                 hoapi.AndroidCIBuild{Branch: "main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		BuildAPICredentialsSource: NoneCredentialsSource,
+		FetchBandwidthLimit:       50 << 20,
+	}
+	warnings := []string{}
+
+	_, err := runCreateCVD(service, opts, func(e CreateEvent) {
+		if e.Kind == CreateEventWarning {
+			warnings = append(warnings, e.Msg)
+		}
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.hostSrv.options.FetchBandwidthLimit != 50<<20 {
+		t.Errorf("expected a fetch bandwidth limit of 50MiB/s, got: %d", service.hostSrv.options.FetchBandwidthLimit)
+	}
+	exp := []string{"the host fetched the main build limited to 20.0MiB/s instead of 50.0MiB/s"}
+	if diff := cmp.Diff(exp, warnings); diff != "" {
+		t.Errorf("warnings mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateCVDReportsFetchRetries(t *testing.T) {
+	service := &fetchConcurrencyService{hostSrv: &fetchConcurrencyHostService{retries: 2}}
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		MainBuild:                 hoapi.AndroidCIBuild{Branch: "main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		BuildAPICredentialsSource: NoneCredentialsSource,
+		FetchRetries:              5,
+	}
+	warnings := []string{}
+
+	_, err := runCreateCVD(service, opts, func(e CreateEvent) {
+		if e.Kind == CreateEventWarning {
+			warnings = append(warnings, e.Msg)
+		}
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.hostSrv.options.FetchRetries != 5 {
+		t.Errorf("expected 5 fetch retries, got: %d", service.hostSrv.options.FetchRetries)
+	}
+	exp := []string{"the host retried 2 failed artifact downloads fetching the main build"}
+	if diff := cmp.Diff(exp, warnings); diff != "" {
+		t.Errorf("warnings mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateArtifactStorage(t *testing.T) {
+	for _, dir := range []string{"", "/mnt/fast", "/"} {
+		if err := validateArtifactStorage(dir); err != nil {
+			t.Errorf("expected %q to be valid: %v", dir, err)
+		}
+	}
+	for _, dir := range []string{"mnt/fast", "/mnt/fast/", "/mnt/../fast", "/mnt/\nfast"} {
+		if err := validateArtifactStorage(dir); err == nil {
+			t.Errorf("expected %q to be invalid", dir)
+		}
+	}
+}
+
+type rejectingArtifactStorageHostService struct {
+	fakeHostService
+}
+
+func (rejectingArtifactStorageHostService) FetchArtifactsOp(*hoapi.FetchArtifactsRequest, string, client.FetchArtifactsOptions) (*hoapi.Operation, error) {
+	return nil, &client.ApiCallError{Code: http.StatusBadRequest, ErrorMsg: "no such directory"}
+}
+
+type rejectingArtifactStorageService struct {
+	fakeService
+}
+
+func (rejectingArtifactStorageService) HostService(string) client.HostOrchestratorService {
+	return rejectingArtifactStorageHostService{}
+}
+
+func TestCreateCVDArtifactStorageRejected(t *testing.T) {
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		MainBuild:                 hoapi.AndroidCIBuild{Branch: "main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		BuildAPICredentialsSource: NoneCredentialsSource,
+		ArtifactStorage:           "/mnt/fast",
+	}
+
+	_, err := runCreateCVD(rejectingArtifactStorageService{}, opts, func(CreateEvent) {})
+
+	if err == nil || !strings.Contains(err.Error(), `rejected the artifact storage "/mnt/fast"`) {
+		t.Errorf("expected artifact storage rejection, got: %v", err)
+	}
+}
+
+func writeHostPackage(t *testing.T, entries []string, truncate bool) string {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: e, Mode: 0755, Size: 4}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte("elf!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	b := buf.Bytes()
+	if truncate {
+		b = b[:len(b)/2]
+	}
+	name := filepath.Join(t.TempDir(), CVDHostPackageName)
+	if err := os.WriteFile(name, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestVerifyCVDHostPackageContents(t *testing.T) {
+	if err := verifyCVDHostPackageContents(writeHostPackage(t, []string{"./bin/cvd", "./bin/launch_cvd"}, false)); err != nil {
+		t.Error(err)
+	}
+	err := verifyCVDHostPackageContents(writeHostPackage(t, []string{"bin/cvd"}, false))
+	if err == nil || !strings.Contains(err.Error(), "lacks bin/launch_cvd") {
+		t.Errorf("expected missing entries error, got: %v", err)
+	}
+	if err := verifyCVDHostPackageContents(writeHostPackage(t, []string{"bin/cvd", "bin/launch_cvd"}, true)); err == nil {
+		t.Error("expected error verifying a truncated package")
+	}
+}
+
+type expiringHostService struct {
+	fakeHostService
+}
+
+func (expiringHostService) ListCVDDetails() ([]*client.CVDDetails, error) {
+	return []*client.CVDDetails{
+		{CVD: hoapi.CVD{Name: "cvd-1"}, ExpireTime: "2024-05-02T22:00:00Z"},
+		{CVD: hoapi.CVD{Name: "cvd-2"}},
+	}, nil
+}
+
+type expiringService struct {
+	fakeService
+}
+
+func (expiringService) HostService(host string) client.HostOrchestratorService {
+	return &expiringHostService{}
+}
+
+func TestListCVDsExpireTime(t *testing.T) {
+	hosts, err := listCVDsSingleHost(&expiringService{}, t.TempDir(), "foo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	cvds := hosts[0].CVDs
+	exp := time.Date(2024, 5, 2, 22, 0, 0, 0, time.UTC)
+	if !cvds[0].ExpireTime.Equal(exp) {
+		t.Errorf("expected expire time %s, got: %s", exp, cvds[0].ExpireTime)
+	}
+	if !cvds[1].ExpireTime.IsZero() {
+		t.Errorf("expected no expire time, got: %s", cvds[1].ExpireTime)
+	}
+	if got := cvdExpiryStr(cvds[0], exp.Add(-3*time.Hour-12*time.Minute)); got != "in 3h12m0s" {
+		t.Errorf("unexpected expiry: %q", got)
+	}
+	if got := cvdExpiryStr(cvds[0], exp.Add(time.Second)); got != "expired" {
+		t.Errorf("unexpected expiry: %q", got)
+	}
+}
+
+func TestValidateCVDTTL(t *testing.T) {
+	if err := validateCVDTTL(4 * time.Hour); err != nil {
+		t.Error(err)
+	}
+	if err := validateCVDTTL(time.Minute); err == nil {
+		t.Error("expected error")
+	}
+}
+
+type serialNumberHostService struct {
+	fakeHostService
+	created bool
+	// Reported for the created device.
+	serial   string
+	existing []*client.CVDDetails
+}
+
+func (s *serialNumberHostService) WaitForCreateCVDOp(name string) (*hoapi.CreateCVDResponse, error) {
+	s.created = true
+	return s.fakeHostService.WaitForCreateCVDOp(name)
+}
+
+func (s *serialNumberHostService) ListCVDDetails() ([]*client.CVDDetails, error) {
+	result := append([]*client.CVDDetails{}, s.existing...)
+	if s.created {
+		result = append(result, &client.CVDDetails{CVD: hoapi.CVD{Name: "cvd-1"}, SerialNumber: s.serial})
+	}
+	return result, nil
+}
+
+type serialNumberService struct {
+	fakeService
+	hostSrv *serialNumberHostService
+}
+
+func (s *serialNumberService) HostService(host string) client.HostOrchestratorService {
+	return s.hostSrv
+}
+
+func TestCreateCVDWithSerialNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		hostSrv *serialNumberHostService
+		numInst int
+		wantErr string
+	}{
+		{name: "honored", hostSrv: &serialNumberHostService{serial: "LAB-07"}},
+		{name: "not reported", hostSrv: &serialNumberHostService{}},
+		{
+			name:    "collision",
+			hostSrv: &serialNumberHostService{existing: []*client.CVDDetails{{CVD: hoapi.CVD{Name: "cvd-2"}, SerialNumber: "LAB-07"}}},
+			wantErr: "already has serial number",
+		},
+		{name: "not honored", hostSrv: &serialNumberHostService{serial: "CUTTLEFISHCVD01"}, wantErr: "didn't honor"},
+		{name: "several devices", hostSrv: &serialNumberHostService{}, numInst: 2, wantErr: "single device"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &serialNumberService{hostSrv: tc.hostSrv}
+			opts := CreateCVDOpts{
+				Host:                      "foo",
+				MainBuild:                 hoapi.AndroidCIBuild{Branch: "main", Target: "aosp_cf_x86_64_phone-userdebug"},
+				BuildAPICredentialsSource: NoneCredentialsSource,
+				NumInstances:              tc.numInst,
+				SerialNumber:              "LAB-07",
+			}
+
+			cvds, err := runCreateCVD(service, opts, func(CreateEvent) {})
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cvds) != 1 || cvds[0].SerialNumber != "LAB-07" {
+				t.Errorf("expected a device with serial number LAB-07, got: %+v", cvds)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+type CVDLogsFlags struct {
+	*CVDRemoteFlags
+	Host string
+	File string
+	// Interleaves the logs of several devices by timestamp.
+	Merge bool
+}
+
+const (
+	logFileFlag    = "file"
+	mergeFlag      = "merge"
+	defaultLogFile = "launcher.log"
+)
+
+// Log lines start with a timestamp like "2024-05-02T21:53:14.123456" or, for logcat,
+// "05-02 21:53:14.123". The timezone, if any, is ignored since all the devices run in one host.
+var (
+	logTimestampRe       = regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2})[T ](\d{2}:\d{2}:\d{2}(?:\.\d+)?)`)
+	logcatLogTimestampRe = regexp.MustCompile(`^(\d{2}-\d{2}) (\d{2}:\d{2}:\d{2}(?:\.\d+)?)`)
+)
+
+const (
+	logTimestampLayout    = "2006-01-02T15:04:05.999999999"
+	logcatTimestampLayout = "01-02T15:04:05.999999999"
+)
+
+func parseLogTimestamp(line string) (time.Time, bool) {
+	layout := logTimestampLayout
+	m := logTimestampRe.FindStringSubmatch(line)
+	if m == nil {
+		layout = logcatTimestampLayout
+		if m = logcatLogTimestampRe.FindStringSubmatch(line); m == nil {
+			return time.Time{}, false
+		}
+	}
+	ts, err := time.Parse(layout, m[1]+"T"+m[2])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+// The logs of a device, prefixed by its name when merged.
+type namedLog struct {
+	Name string
+	R    io.ReadCloser
+}
+
+// Colors of the device prefixes, cycled through when there are more devices.
+var logPrefixColors = []string{"\x1b[36m", "\x1b[33m", "\x1b[35m", "\x1b[32m", "\x1b[34m", "\x1b[31m"}
+
+const ansiColorReset = "\x1b[0m"
+
+// Allows lines as long as the kernel's longest messages, the default limit is 64KB.
+const maxLogLineSize = 1024 * 1024
+
+type logCursor struct {
+	prefix  string
+	scanner *bufio.Scanner
+	line    string
+	// Lines without a timestamp, like continuations, keep the one of the line before them.
+	ts   time.Time
+	done bool
+}
+
+func (c *logCursor) advance() {
+	if !c.scanner.Scan() {
+		c.done = true
+		return
+	}
+	c.line = c.scanner.Text()
+	if ts, ok := parseLogTimestamp(c.line); ok {
+		c.ts = ts
+	}
+}
+
+// Writes the lines of the logs ordered by timestamp, each prefixed by the name of its device. Lines
+// with the same timestamp keep the order of the logs.
+func mergeLogs(w io.Writer, logs []namedLog, color bool) error {
+	width := 0
+	for _, l := range logs {
+		if len(l.Name) > width {
+			width = len(l.Name)
+		}
+	}
+	cursors := []*logCursor{}
+	for i, l := range logs {
+		prefix := fmt.Sprintf("%-*s | ", width, l.Name)
+		if color {
+			prefix = logPrefixColors[i%len(logPrefixColors)] + prefix + ansiColorReset
+		}
+		s := bufio.NewScanner(l.R)
+		s.Buffer(make([]byte, 64*1024), maxLogLineSize)
+		c := &logCursor{prefix: prefix, scanner: s}
+		c.advance()
+		cursors = append(cursors, c)
+	}
+	for {
+		var next *logCursor
+		for _, c := range cursors {
+			if !c.done && (next == nil || c.ts.Before(next.ts)) {
+				next = c
+			}
+		}
+		if next == nil {
+			break
+		}
+		if _, err := fmt.Fprintln(w, next.prefix+next.line); err != nil {
+			return err
+		}
+		next.advance()
+	}
+	for i, c := range cursors {
+		if err := c.scanner.Err(); err != nil {
+			return fmt.Errorf("failed reading the logs of %q: %w", logs[i].Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeLogs(t *testing.T) {
+	logs := []namedLog{
+		{Name: "cvd-1", R: io.NopCloser(strings.NewReader(
+			"2024-05-02T21:53:14.100 launching\n" +
+				"2024-05-02T21:53:14.300 connected to cvd-2\n" +
+				"  continuation\n"))},
+		{Name: "cvd-10", R: io.NopCloser(strings.NewReader(
+			"2024-05-02T21:53:14.200 launching\n" +
+				"2024-05-02T21:53:14.300 accepted cvd-1\n"))},
+	}
+	out := &bytes.Buffer{}
+
+	if err := mergeLogs(out, logs, false); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "cvd-1  | 2024-05-02T21:53:14.100 launching\n" +
+		"cvd-10 | 2024-05-02T21:53:14.200 launching\n" +
+		"cvd-1  | 2024-05-02T21:53:14.300 connected to cvd-2\n" +
+		"cvd-1  |   continuation\n" +
+		"cvd-10 | 2024-05-02T21:53:14.300 accepted cvd-1\n"
+	if diff := cmp.Diff(exp, out.String()); diff != "" {
+		t.Errorf("merged logs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseLogTimestamp(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+	}{
+		{line: "2024-05-02T21:53:14.123456 I launch_cvd", ok: true},
+		{line: "[2024-05-02 21:53:14] assemble_cvd", ok: true},
+		{line: "05-02 21:53:14.123  1234  1234 I ActivityManager: start", ok: true},
+		{line: "[    1.234567] kernel message", ok: false},
+	}
+	for _, tc := range tests {
+		if _, ok := parseLogTimestamp(tc.line); ok != tc.ok {
+			t.Errorf("expected timestamp %t for %q, got: %t", tc.ok, tc.line, ok)
+		}
+	}
+}
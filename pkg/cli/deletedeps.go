@@ -0,0 +1,95 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/hashicorp/go-multierror"
+)
+
+// What is left behind, or cleaned up, when a device is deleted.
+type CVDDependencies struct {
+	Host string
+	CVD  *hoapi.CVD
+	// Connections to the device from this machine, closed before deleting it.
+	Connections []RemoteCVDLocator
+	// Upload directory the device was created from, empty if it wasn't created from local files.
+	// Left in the host for other creates, `gc` reclaims it once no device uses it.
+	UploadDir string
+}
+
+// Returns the connections to the device, sorted by device id.
+func cvdConnections(statuses map[RemoteCVDLocator]ConnStatus, rootURI, host string, cvd *hoapi.CVD) []RemoteCVDLocator {
+	result := []RemoteCVDLocator{}
+	for l := range statuses {
+		if l.ServiceRootEndpoint == rootURI && l.Host == host && l.WebRTCDeviceID == cvd.WebRTCDeviceID {
+			result = append(result, l)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].WebRTCDeviceID < result[j].WebRTCDeviceID })
+	return result
+}
+
+// Finds the device and what depends on it, among the connections in `statuses`.
+func findCVDDependencies(service client.Service, statuses map[RemoteCVDLocator]ConnStatus, host, name string) (*CVDDependencies, error) {
+	cvd, err := getCVD(service, host, name)
+	if err != nil {
+		return nil, err
+	}
+	deps := &CVDDependencies{Host: host, CVD: cvd}
+	deps.Connections = cvdConnections(statuses, service.RootURI(), host, cvd)
+	if bs := cvd.BuildSource; bs != nil && bs.UserBuildSource != nil {
+		deps.UploadDir = bs.UserBuildSource.ArtifactsDir
+	}
+	return deps, nil
+}
+
+func writeCVDDependencies(out io.Writer, deps *CVDDependencies) {
+	id := deps.Host + "/" + deps.CVD.Name
+	for _, c := range deps.Connections {
+		fmt.Fprintf(out, "Would close the connection to %s/%s\n", c.Host, c.WebRTCDeviceID)
+	}
+	fmt.Fprintf(out, "Would revoke the share links of %s, if any\n", id)
+	if deps.UploadDir != "" {
+		fmt.Fprintf(out, "Would leave upload directory %s in the host, reclaim it with gc\n", deps.UploadDir)
+	}
+	fmt.Fprintf(out, "Would delete %s\n", id)
+}
+
+// Closes the connections to the device and revokes its share links. Failures are returned but
+// don't stop the other dependencies from being cleaned up.
+func cleanUpCVDDependencies(service client.Service, controlDir string, deps *CVDDependencies, statuses map[RemoteCVDLocator]ConnStatus) error {
+	var merr error
+	for _, c := range deps.Connections {
+		if err := DisconnectCVD(controlDir, c, statuses[c]); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	err := service.RevokeShareLinks(deps.Host, deps.CVD.Name)
+	var apiErr *client.ApiCallError
+	// Not found if the device was never shared or the service doesn't share devices.
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound) {
+		merr = multierror.Append(merr, fmt.Errorf("failed revoking share links: %w", err))
+	}
+	return merr
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCVDConnections(t *testing.T) {
+	cvd := &hoapi.CVD{Name: "cvd-1", WebRTCDeviceID: "cvd-1-1"}
+	want := RemoteCVDLocator{ServiceRootEndpoint: serviceURL, Host: "foo", WebRTCDeviceID: "cvd-1-1"}
+	statuses := map[RemoteCVDLocator]ConnStatus{
+		want: {},
+		{ServiceRootEndpoint: serviceURL, Host: "foo", WebRTCDeviceID: "cvd-2-1"}:     {},
+		{ServiceRootEndpoint: serviceURL, Host: "bar", WebRTCDeviceID: "cvd-1-1"}:     {},
+		{ServiceRootEndpoint: "http://other", Host: "foo", WebRTCDeviceID: "cvd-1-1"}: {},
+	}
+
+	got := cvdConnections(statuses, serviceURL, "foo", cvd)
+
+	if diff := cmp.Diff([]RemoteCVDLocator{want}, got); diff != "" {
+		t.Errorf("connections mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteCVDDependencies(t *testing.T) {
+	deps := &CVDDependencies{
+		Host:        "foo",
+		CVD:         &hoapi.CVD{Name: "cvd-1", WebRTCDeviceID: "cvd-1-1"},
+		Connections: []RemoteCVDLocator{{Host: "foo", WebRTCDeviceID: "cvd-1-1"}},
+		UploadDir:   "dir1",
+	}
+	out := &bytes.Buffer{}
+
+	writeCVDDependencies(out, deps)
+
+	want := `Would close the connection to foo/cvd-1-1
+Would revoke the share links of foo/cvd-1, if any
+Would leave upload directory dir1 in the host, reclaim it with gc
+Would delete foo/cvd-1
+`
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+type deleteCVDHostService struct {
+	fakeHostService
+	deleted []string
+}
+
+func (s *deleteCVDHostService) DeleteCVD(id string) error {
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+type deleteCVDService struct {
+	fakeService
+	hostSrv *deleteCVDHostService
+}
+
+func (s *deleteCVDService) HostService(host string) client.HostOrchestratorService {
+	return s.hostSrv
+}
+
+func runDeleteCVD(t *testing.T, srv *deleteCVDService, args ...string) error {
+	io, _, _ := newTestIOStreams()
+	opts := &CommandOptions{
+		IOStreams:     io,
+		Args:          append([]string{"delete", "--host=foo", "--service_url=" + serviceURL}, args...),
+		InitialConfig: Config{ConnectionControlDir: t.TempDir()},
+		ServiceBuilder: func(opts *client.ServiceOptions) (client.Service, error) {
+			return srv, nil
+		},
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+	return NewCVDRemoteCommand(opts).Execute()
+}
+
+func TestDeleteCVDNotFoundInListingStillDeletes(t *testing.T) {
+	srv := &deleteCVDService{hostSrv: &deleteCVDHostService{}}
+
+	if err := runDeleteCVD(t, srv, "cvd"); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"cvd"}, srv.hostSrv.deleted); diff != "" {
+		t.Errorf("deletions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeleteCVDDryRunRequiresTheLookup(t *testing.T) {
+	srv := &deleteCVDService{hostSrv: &deleteCVDHostService{}}
+
+	if err := runDeleteCVD(t, srv, "--dry_run", "cvd"); err == nil {
+		t.Error("expected an error")
+	}
+
+	if len(srv.hostSrv.deleted) != 0 {
+		t.Errorf("unexpected deletions: %v", srv.hostSrv.deleted)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+// The subset of a device's properties relevant when comparing how two devices were configured.
+// Instance settings, like the CPUs or the GPU mode, aren't included: the host orchestrator doesn't
+// report them for its devices.
+type cvdConfig struct {
+	BuildSource *hoapi.BuildSource `json:"build_source"`
+	Displays    []string           `json:"displays"`
+}
+
+type ConfigDifference struct {
+	Path  string `json:"path"`
+	Left  any    `json:"left"`
+	Right any    `json:"right"`
+}
+
+type ConfigDiff struct {
+	Left        string             `json:"left"`
+	Right       string             `json:"right"`
+	Differences []ConfigDifference `json:"differences"`
+}
+
+// Loads the configuration of a device given as `[HOST/]DEVICE`, or from a local JSON file with the
+// device specification if `src` is the path of an existing file.
+func loadCVDConfig(service client.Service, defaultHost, src string) (*cvdConfig, error) {
+	if strings.HasSuffix(src, ".json") {
+		if _, err := os.Stat(src); err == nil {
+			return loadCVDConfigFile(src)
+		}
+	}
+	host, name := defaultHost, src
+	if i := strings.LastIndex(src, "/"); i >= 0 {
+		host, name = src[:i], src[i+1:]
+	}
+	if host == "" {
+		return nil, fmt.Errorf("missing host for device %q", src)
+	}
+	cvd, err := getCVD(service, host, name)
+	if err != nil {
+		return nil, err
+	}
+	return &cvdConfig{BuildSource: cvd.BuildSource, Displays: cvd.Displays}, nil
+}
+
+func loadCVDConfigFile(name string) (*cvdConfig, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading device specification: %w", err)
+	}
+	cvd := &hoapi.CVD{}
+	if err := json.Unmarshal(b, cvd); err != nil {
+		return nil, fmt.Errorf("invalid device specification %q: %w", name, err)
+	}
+	return &cvdConfig{BuildSource: cvd.BuildSource, Displays: cvd.Displays}, nil
+}
+
+// Finds a device in the host by its name, id or webrtc device id.
+func getCVD(service client.Service, host, name string) (*hoapi.CVD, error) {
+	cvds, err := service.HostService(host).ListCVDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing devices in host %q: %w", host, err)
+	}
+	for _, cvd := range cvds {
+		if cvd.Name == name || cvd.ID() == name || cvd.WebRTCDeviceID == name {
+			return cvd, nil
+		}
+	}
+	return nil, fmt.Errorf("device %q not found in host %q", name, host)
+}
+
+func diffCVDConfigs(left, right *cvdConfig) ([]ConfigDifference, error) {
+	l, err := flattenJSON(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := flattenJSON(right)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]struct{})
+	for k := range l {
+		paths[k] = struct{}{}
+	}
+	for k := range r {
+		paths[k] = struct{}{}
+	}
+	sorted := []string{}
+	for k := range paths {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	result := []ConfigDifference{}
+	for _, p := range sorted {
+		lv, rv := l[p], r[p]
+		if fmt.Sprint(lv) != fmt.Sprint(rv) {
+			result = append(result, ConfigDifference{Path: p, Left: lv, Right: rv})
+		}
+	}
+	return result, nil
+}
+
+// Flattens the JSON representation of `v` into a map keyed by the dotted path of each leaf value.
+func flattenJSON(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic any
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	result := make(map[string]any)
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		switch t := v.(type) {
+		case map[string]any:
+			for k, e := range t {
+				walk(joinPath(prefix, k), e)
+			}
+		case []any:
+			for i, e := range t {
+				walk(fmt.Sprintf("%s[%d]", prefix, i), e)
+			}
+		case nil:
+			// Absent values are represented by missing paths.
+		case string:
+			// Empty strings are as good as absent.
+			if t != "" {
+				result[prefix] = t
+			}
+		default:
+			result[prefix] = t
+		}
+	}
+	walk("", generic)
+	return result, nil
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func writeConfigDiff(w io.Writer, diff *ConfigDiff) {
+	if len(diff.Differences) == 0 {
+		fmt.Fprintf(w, "No differences between %s and %s\n", diff.Left, diff.Right)
+		return
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", diff.Left, diff.Right)
+	for _, d := range diff.Differences {
+		fmt.Fprintf(w, "%s:\n", d.Path)
+		fmt.Fprintf(w, "  - %s\n", diffValueStr(d.Left))
+		fmt.Fprintf(w, "  + %s\n", diffValueStr(d.Right))
+	}
+}
+
+func diffValueStr(v any) string {
+	if v == nil {
+		return "<unset>"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffCVDConfigs(t *testing.T) {
+	left := &cvdConfig{
+		BuildSource: &hoapi.BuildSource{
+			AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{
+				MainBuild: &hoapi.AndroidCIBuild{BuildID: "123", Target: "foo"},
+			},
+		},
+		Displays: []string{"720 x 1280 ( 320 )"},
+	}
+	right := &cvdConfig{
+		BuildSource: &hoapi.BuildSource{
+			AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{
+				MainBuild:   &hoapi.AndroidCIBuild{BuildID: "456", Target: "foo"},
+				KernelBuild: &hoapi.AndroidCIBuild{Branch: "bar"},
+			},
+		},
+		Displays: []string{"720 x 1280 ( 320 )"},
+	}
+
+	got, err := diffCVDConfigs(left, right)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ConfigDifference{
+		{Path: "build_source.android_ci_build_source.kernel_build.branch", Left: nil, Right: "bar"},
+		{Path: "build_source.android_ci_build_source.main_build.build_id", Left: "123", Right: "456"},
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
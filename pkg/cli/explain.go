@@ -0,0 +1,389 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+// Unlike verbose logs, the trace is organized by create step and only printed if the create fails.
+
+const explainFlag = "explain"
+
+// Longer request or response bodies are truncated in the trace.
+const maxExplainBodyLen = 1024
+
+// Only the tail of the logs of failed operations is kept in the trace, the end has the failure.
+const maxExplainOperationLogsLen = 8 * 1024
+
+// Values of object keys containing any of these are redacted from the trace.
+var explainRedactedKeys = []string{"token", "password", "secret", "credential", "authorization"}
+
+// A call made to the service while creating.
+type explainCall struct {
+	Host     string
+	Method   string
+	Request  string
+	Response string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+type explainStep struct {
+	Phase string
+	Msg   string
+	Start time.Time
+	End   time.Time
+	Done  bool
+	Err   error
+	Calls []*explainCall
+}
+
+func (s *explainStep) label() string {
+	switch {
+	case s.Phase != "" && s.Msg != "":
+		return s.Phase + ": " + s.Msg
+	case s.Phase != "":
+		return s.Phase
+	case s.Msg != "":
+		return s.Msg
+	default:
+		return "preparing"
+	}
+}
+
+// The host orchestrator's logs of an operation that failed while waiting for it.
+type explainOperationLogs struct {
+	Host      string
+	Operation string
+	Logs      string
+	Err       error
+}
+
+// Records the steps of a create and the calls made during each of them.
+type explainTrace struct {
+	mtx     sync.Mutex
+	start   time.Time
+	steps   []*explainStep
+	opsLogs []*explainOperationLogs
+}
+
+func newExplainTrace() *explainTrace {
+	t := &explainTrace{start: time.Now()}
+	// Holds the calls made before the first step, like selecting the host.
+	t.steps = append(t.steps, &explainStep{Start: t.start})
+	return t
+}
+
+func (t *explainTrace) OnEvent(e CreateEvent) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	switch e.Kind {
+	case CreateEventStarted:
+		t.steps = append(t.steps, &explainStep{Phase: e.Phase, Msg: e.Msg, Start: time.Now()})
+	case CreateEventDone:
+		for i := len(t.steps) - 1; i >= 0; i-- {
+			if s := t.steps[i]; !s.Done && s.Phase == e.Phase && s.Msg == e.Msg {
+				s.Done, s.End, s.Err = true, time.Now(), e.Err
+				break
+			}
+		}
+	}
+}
+
+func (t *explainTrace) record(call *explainCall) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	// Calls belong to the innermost step running, or the last one if none is.
+	step := t.steps[len(t.steps)-1]
+	for i := len(t.steps) - 1; i > 0; i-- {
+		if !t.steps[i].Done {
+			step = t.steps[i]
+			break
+		}
+	}
+	step.Calls = append(step.Calls, call)
+}
+
+func (t *explainTrace) recordOperationLogs(logs *explainOperationLogs) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.opsLogs = append(t.opsLogs, logs)
+}
+
+func (t *explainTrace) Write(w io.Writer, correlationID string, err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	header := "Explanation of the failed create"
+	if correlationID != "" {
+		header += ", correlation id " + correlationID
+	}
+	fmt.Fprintln(w, header+":")
+	offset := func(at time.Time) string {
+		return fmt.Sprintf("+%.2fs", at.Sub(t.start).Seconds())
+	}
+	var failed *explainStep
+	for i, s := range t.steps {
+		if i == 0 && len(s.Calls) == 0 {
+			continue
+		}
+		outcome := "not finished"
+		if s.Done {
+			outcome = fmt.Sprintf("took %v", s.End.Sub(s.Start).Round(time.Millisecond))
+			if s.Err != nil {
+				outcome += ", failed: " + s.Err.Error()
+				failed = s
+			}
+		}
+		fmt.Fprintf(w, "  %s %s (%s)\n", offset(s.Start), s.label(), outcome)
+		for _, c := range s.Calls {
+			result := "ok"
+			if c.Err != nil {
+				result = "failed: " + c.Err.Error()
+			}
+			fmt.Fprintf(w, "    %s %s %s in %v, %s\n", offset(c.Start), c.Host, c.Method, c.Duration.Round(time.Millisecond), result)
+			fmt.Fprintf(w, "      request: %s\n", c.Request)
+			if c.Err == nil {
+				fmt.Fprintf(w, "      response: %s\n", c.Response)
+			}
+		}
+	}
+	for _, l := range t.opsLogs {
+		switch {
+		case errors.Is(l.Err, client.ErrOperationNotFound):
+			fmt.Fprintf(w, "Logs of operation %s in %s: not available, the operation was garbage collected\n", l.Operation, l.Host)
+		case l.Err != nil:
+			fmt.Fprintf(w, "Logs of operation %s in %s: failed fetching: %v\n", l.Operation, l.Host, l.Err)
+		default:
+			fmt.Fprintf(w, "Logs of operation %s in %s:\n", l.Operation, l.Host)
+			for _, line := range strings.Split(strings.TrimSuffix(l.Logs, "\n"), "\n") {
+				fmt.Fprintf(w, "  %s\n", line)
+			}
+		}
+	}
+	if failed != nil {
+		fmt.Fprintf(w, "Failed at: %s\n", failed.label())
+	} else if err != nil {
+		fmt.Fprintf(w, "Failed: %v\n", err)
+	}
+}
+
+// Returns the value as JSON, with the credentials redacted and truncated to maxExplainBodyLen.
+func explainBody(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<unencodable: %v>", err)
+	}
+	var generic any
+	if err := json.Unmarshal(b, &generic); err == nil {
+		if b, err = json.Marshal(redactExplained(generic)); err != nil {
+			return fmt.Sprintf("<unencodable: %v>", err)
+		}
+	}
+	if len(b) > maxExplainBodyLen {
+		return string(b[:maxExplainBodyLen]) + "...(truncated)"
+	}
+	return string(b)
+}
+
+func redactExplained(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			lk := strings.ToLower(k)
+			redacted := false
+			for _, r := range explainRedactedKeys {
+				if strings.Contains(lk, r) {
+					redacted = true
+					break
+				}
+			}
+			if redacted {
+				v[k] = "REDACTED"
+			} else {
+				v[k] = redactExplained(e)
+			}
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = redactExplained(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func traceCall[T any](t *explainTrace, host, method string, req any, call func() (T, error)) (T, error) {
+	start := time.Now()
+	res, err := call()
+	c := &explainCall{
+		Host:     host,
+		Method:   method,
+		Request:  explainBody(req),
+		Start:    start,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if err == nil {
+		c.Response = explainBody(res)
+	}
+	t.record(c)
+	return res, err
+}
+
+// Records in the trace the calls made while creating, the other calls are forwarded untraced.
+type explainedService struct {
+	client.Service
+	trace *explainTrace
+}
+
+func (s *explainedService) CreateHost(req *apiv1.CreateHostRequest) (*apiv1.HostInstance, error) {
+	return traceCall(s.trace, "", "CreateHost", req, func() (*apiv1.HostInstance, error) {
+		return s.Service.CreateHost(req)
+	})
+}
+
+func (s *explainedService) ListHosts() (*apiv1.ListHostsResponse, error) {
+	return traceCall(s.trace, "", "ListHosts", nil, s.Service.ListHosts)
+}
+
+func (s *explainedService) HostService(host string) client.HostOrchestratorService {
+	return &explainedHostService{s.Service.HostService(host), host, s.trace}
+}
+
+type explainedHostService struct {
+	client.HostOrchestratorService
+	host  string
+	trace *explainTrace
+}
+
+func (s *explainedHostService) WithContext(ctx context.Context) client.HostOrchestratorService {
+	return &explainedHostService{client.HostServiceWithContext(s.HostOrchestratorService, ctx), s.host, s.trace}
+}
+
+func (s *explainedHostService) WithThrottleHook(hook client.ThrottleHook) client.HostOrchestratorService {
+	return &explainedHostService{client.HostServiceWithThrottleHook(s.HostOrchestratorService, hook), s.host, s.trace}
+}
+
+func (s *explainedHostService) ListCVDs() ([]*hoapi.CVD, error) {
+	return traceCall(s.trace, s.host, "ListCVDs", nil, s.HostOrchestratorService.ListCVDs)
+}
+
+func (s *explainedHostService) ListCVDDetails() ([]*client.CVDDetails, error) {
+	return traceCall(s.trace, s.host, "ListCVDDetails", nil, s.HostOrchestratorService.ListCVDDetails)
+}
+
+func (s *explainedHostService) CreateUploadDir() (string, error) {
+	return traceCall(s.trace, s.host, "CreateUploadDir", nil, s.HostOrchestratorService.CreateUploadDir)
+}
+
+func (s *explainedHostService) UploadFileWithOptions(uploadDir, filename string, options client.UploadOptions) error {
+	req := map[string]string{"upload_dir": uploadDir, "file": filename}
+	_, err := traceCall(s.trace, s.host, "UploadFile", req, func() (any, error) {
+		return nil, s.HostOrchestratorService.UploadFileWithOptions(uploadDir, filename, options)
+	})
+	return err
+}
+
+func (s *explainedHostService) ExtractFile(uploadDir, filename string) (*hoapi.Operation, error) {
+	req := map[string]string{"upload_dir": uploadDir, "file": filename}
+	return traceCall(s.trace, s.host, "ExtractFile", req, func() (*hoapi.Operation, error) {
+		return s.HostOrchestratorService.ExtractFile(uploadDir, filename)
+	})
+}
+
+func (s *explainedHostService) CreateCVDOpWithOptions(req *hoapi.CreateCVDRequest, creds string, options client.CreateCVDOptions) (*hoapi.Operation, error) {
+	body := map[string]any{"request": req, "metadata": options.Metadata, "url_build_source": options.URLBuildSource}
+	return traceCall(s.trace, s.host, "CreateCVD", body, func() (*hoapi.Operation, error) {
+		return s.HostOrchestratorService.CreateCVDOpWithOptions(req, creds, options)
+	})
+}
+
+// Traces waiting for the operation, fetching its logs from the host if it fails.
+func traceOperationWait[T any](s *explainedHostService, method, name string, call func() (T, error)) (T, error) {
+	res, err := traceCall(s.trace, s.host, method, map[string]string{"operation": name}, call)
+	if err != nil {
+		logs, logsErr := operationLogsTail(s.HostOrchestratorService, name, maxExplainOperationLogsLen)
+		s.trace.recordOperationLogs(&explainOperationLogs{Host: s.host, Operation: name, Logs: logs, Err: logsErr})
+	}
+	return res, err
+}
+
+// Returns the last `n` bytes of the operation's logs.
+func operationLogsTail(srv client.HostOrchestratorService, name string, n int) (string, error) {
+	logs, err := srv.GetOperationLogs(name)
+	if err != nil {
+		return "", err
+	}
+	defer logs.Close()
+	b, err := io.ReadAll(logs)
+	if err != nil {
+		return "", err
+	}
+	if len(b) > n {
+		return "...(truncated)" + string(b[len(b)-n:]), nil
+	}
+	return string(b), nil
+}
+
+func (s *explainedHostService) WaitForCreateCVDOp(name string) (*hoapi.CreateCVDResponse, error) {
+	return traceOperationWait(s, "WaitForCreateCVD", name, func() (*hoapi.CreateCVDResponse, error) {
+		return s.HostOrchestratorService.WaitForCreateCVDOp(name)
+	})
+}
+
+func (s *explainedHostService) FetchArtifactsOp(req *hoapi.FetchArtifactsRequest, creds string, options client.FetchArtifactsOptions) (*hoapi.Operation, error) {
+	return traceCall(s.trace, s.host, "FetchArtifacts", req, func() (*hoapi.Operation, error) {
+		return s.HostOrchestratorService.FetchArtifactsOp(req, creds, options)
+	})
+}
+
+func (s *explainedHostService) WaitForFetchArtifactsOp(name string) (*client.FetchArtifactsResult, error) {
+	return traceOperationWait(s, "WaitForFetchArtifacts", name, func() (*client.FetchArtifactsResult, error) {
+		return s.HostOrchestratorService.WaitForFetchArtifactsOp(name)
+	})
+}
+
+func (s *explainedHostService) WaitForOperation(name string, result any) error {
+	_, err := traceOperationWait(s, "WaitForOperation", name, func() (any, error) {
+		return result, s.HostOrchestratorService.WaitForOperation(name, result)
+	})
+	return err
+}
+
+// Same as createCVD, recording the steps in the trace.
+func createCVDExplained(service client.Service, createOpts CreateCVDOpts, statePrinter *statePrinter, trace *explainTrace) ([]*RemoteCVD, error) {
+	s := createCVDStream(service, createOpts)
+	for e := range s.Events {
+		trace.OnEvent(e)
+		printCreateEvent(statePrinter, e)
+	}
+	return s.Wait()
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+func TestExplainBodyRedactsCredentials(t *testing.T) {
+	body := map[string]any{
+		"build": map[string]any{"branch": "aosp-main", "AccessToken": "abc"},
+		"items": []any{map[string]any{"password": "xyz"}},
+	}
+
+	got := explainBody(body)
+
+	if strings.Contains(got, "abc") || strings.Contains(got, "xyz") {
+		t.Errorf("credentials not redacted: %s", got)
+	}
+	if !strings.Contains(got, "aosp-main") {
+		t.Errorf("expected the other values to be kept: %s", got)
+	}
+}
+
+func TestExplainTraceWrite(t *testing.T) {
+	trace := newExplainTrace()
+	srv := &explainedService{&fakeService{}, trace}
+	if _, err := srv.ListHosts(); err != nil {
+		t.Fatal(err)
+	}
+	trace.OnEvent(CreateEvent{Kind: CreateEventStarted, Phase: uploadPhase, Msg: "Uploading \"foo.zip\""})
+	uploadErr := errors.New("connection reset")
+	trace.record(&explainCall{Host: "foo", Method: "UploadFile", Request: "{}", Err: uploadErr})
+	trace.OnEvent(CreateEvent{Kind: CreateEventDone, Phase: uploadPhase, Msg: "Uploading \"foo.zip\"", Err: uploadErr})
+	sb := &strings.Builder{}
+
+	trace.Write(sb, "0123abcd", uploadErr)
+
+	got := sb.String()
+	for _, want := range []string{
+		"correlation id 0123abcd",
+		"preparing",
+		"ListHosts",
+		"foo UploadFile",
+		"failed: connection reset",
+		"Failed at: " + uploadPhase + ": Uploading \"foo.zip\"",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in:\n%s", want, got)
+		}
+	}
+}
+
+type failingOperationHostService struct {
+	fakeHostService
+	logs string
+}
+
+func (s *failingOperationHostService) WaitForCreateCVDOp(string) (*hoapi.CreateCVDResponse, error) {
+	return nil, errors.New("launcher failed")
+}
+
+func (s *failingOperationHostService) GetOperationLogs(name string) (io.ReadCloser, error) {
+	if s.logs == "" {
+		return nil, client.ErrOperationNotFound
+	}
+	return io.NopCloser(strings.NewReader(s.logs)), nil
+}
+
+func TestExplainTraceIncludesFailedOperationLogs(t *testing.T) {
+	tests := []struct {
+		logs string
+		want string
+	}{
+		{"fetching\nlaunch_cvd exited with code 1\n", "  launch_cvd exited with code 1\n"},
+		{"", "not available, the operation was garbage collected"},
+	}
+	for _, tc := range tests {
+		trace := newExplainTrace()
+		srv := &explainedHostService{&failingOperationHostService{logs: tc.logs}, "foo", trace}
+		if _, err := srv.WaitForCreateCVDOp("op1"); err == nil {
+			t.Fatal("expected error")
+		}
+		sb := &strings.Builder{}
+
+		trace.Write(sb, "", nil)
+
+		if got := sb.String(); !strings.Contains(got, "Logs of operation op1 in foo") || !strings.Contains(got, tc.want) {
+			t.Errorf("expected %q in:\n%s", tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+const fetchBandwidthLimitFlag = "fetch_bandwidth_limit"
+
+var bandwidthRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)([KMG]iB)?(?:/s)?$`)
+
+var bandwidthUnits = map[string]float64{
+	"":    1,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+}
+
+// Parses bytes per second with an optional binary unit, i.e: "50MiB/s" or "1.5GiB". A bare number
+// is in bytes per second.
+func ParseBandwidth(s string) (int64, error) {
+	m := bandwidthRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid bandwidth %q, expected bytes per second with an optional KiB, MiB or GiB unit, i.e: 50MiB/s", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q: %w", s, err)
+	}
+	result := int64(n * bandwidthUnits[m[2]])
+	if result <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q, must be at least one byte per second", s)
+	}
+	return result, nil
+}
+
+func formatBandwidth(bytesPerSec int64) string {
+	return formatBytes(bytesPerSec) + "/s"
+}
+
+type bandwidthFlagValue struct {
+	bytesPerSec *int64
+}
+
+func (v *bandwidthFlagValue) String() string {
+	if v.bytesPerSec == nil || *v.bytesPerSec == 0 {
+		return ""
+	}
+	return formatBandwidth(*v.bytesPerSec)
+}
+
+func (v *bandwidthFlagValue) Set(s string) error {
+	b, err := ParseBandwidth(s)
+	if err != nil {
+		return err
+	}
+	*v.bytesPerSec = b
+	return nil
+}
+
+func (v *bandwidthFlagValue) Type() string {
+	return "bandwidth"
+}
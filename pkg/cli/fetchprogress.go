@@ -0,0 +1,187 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+type BuildFetchProgress struct {
+	// The build being fetched, one of: main, kernel, bootloader or system. Other variants of the
+	// main build are "main" followed by the variant, i.e: "main user".
+	Build        string
+	BytesFetched int64
+	// Zero if the host doesn't report the progress of its fetches.
+	TotalBytes int64
+	Done       bool
+}
+
+var fetchProgressPollInterval = time.Second
+
+type fetchBundle struct {
+	Name   string
+	Bundle *hoapi.AndroidCIBundle
+}
+
+// Keeps the progress of every fetch, reporting the whole set whenever one of them changes.
+type fetchProgressTracker struct {
+	mu       sync.Mutex
+	progress []BuildFetchProgress
+	report   func([]BuildFetchProgress)
+	stopped  bool
+}
+
+func (t *fetchProgressTracker) update(i int, p BuildFetchProgress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped || t.progress[i] == p {
+		return
+	}
+	t.progress[i] = p
+	t.report(append([]BuildFetchProgress{}, t.progress...))
+}
+
+// No progress is reported after stopping, fetches abandoned after a timeout stop polling.
+func (t *fetchProgressTracker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+}
+
+func (t *fetchProgressTracker) isStopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopped
+}
+
+// Fetches the bundles concurrently, the responses are returned in the same order as the bundles.
+func fetchBundles(hs client.HostOrchestratorService, bundles []fetchBundle, creds string, opts client.FetchArtifactsOptions, tracker *fetchProgressTracker) ([]*client.FetchArtifactsResult, error) {
+	res := make([]*client.FetchArtifactsResult, len(bundles))
+	errs := make([]error, len(bundles))
+	var wg sync.WaitGroup
+	for i, b := range bundles {
+		wg.Add(1)
+		go func(i int, b fetchBundle) {
+			defer wg.Done()
+			res[i], errs[i] = fetchBundleWithProgress(hs, b, creds, opts, func(p BuildFetchProgress) { tracker.update(i, p) }, tracker.isStopped)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("failed fetching %s build: %w", b.Name, errs[i])
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Polls the progress of the fetch until it's done. The progress is best effort, the fetch is
+// waited for without polling if the host fails reporting it.
+func fetchBundleWithProgress(hs client.HostOrchestratorService, b fetchBundle, creds string, opts client.FetchArtifactsOptions, update func(BuildFetchProgress), stopped func() bool) (*client.FetchArtifactsResult, error) {
+	op, err := hs.FetchArtifactsOp(&hoapi.FetchArtifactsRequest{AndroidCIBundle: b.Bundle}, creds, opts)
+	if err != nil {
+		return nil, err
+	}
+	last := BuildFetchProgress{Build: b.Name}
+	for !stopped() {
+		p, err := hs.GetFetchArtifactsProgress(op.Name)
+		if err != nil {
+			break
+		}
+		last.BytesFetched, last.TotalBytes = p.BytesFetched, p.TotalBytes
+		if p.Done {
+			break
+		}
+		update(last)
+		time.Sleep(fetchProgressPollInterval)
+	}
+	res, err := hs.WaitForFetchArtifactsOp(op.Name)
+	if err != nil {
+		return nil, err
+	}
+	last.Done = true
+	if last.TotalBytes > 0 {
+		last.BytesFetched = last.TotalBytes
+	}
+	update(last)
+	return res, nil
+}
+
+// Formats the combined progress of the fetches, i.e: "45% (1.2GiB of 2.6GiB)". Fetches of unknown
+// size are left out of the percentage, empty if none reports its size.
+func fetchProgressSummary(fetches []BuildFetchProgress) string {
+	var fetched, total int64
+	for _, f := range fetches {
+		if f.TotalBytes > 0 {
+			fetched += f.BytesFetched
+			total += f.TotalBytes
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d%% (%s of %s)", fetched*100/total, formatBytes(fetched), formatBytes(total))
+}
+
+// Nil if no fetch reports its size.
+func fetchProgressPercent(fetches []BuildFetchProgress) *int64 {
+	var fetched, total int64
+	for _, f := range fetches {
+		if f.TotalBytes > 0 {
+			fetched += f.BytesFetched
+			total += f.TotalBytes
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	percent := fetched * 100 / total
+	return &percent
+}
+
+func fetchProgressLine(f BuildFetchProgress) string {
+	switch {
+	case f.Done:
+		return fmt.Sprintf("  %s: done", f.Build)
+	case f.TotalBytes > 0:
+		return fmt.Sprintf("  %s: %d%% (%s of %s)", f.Build, f.BytesFetched*100/f.TotalBytes,
+			formatBytes(f.BytesFetched), formatBytes(f.TotalBytes))
+	default:
+		return fmt.Sprintf("  %s: fetching", f.Build)
+	}
+}
+
+// Formats a size with a binary unit, i.e: 1288490188 results in "1.2GiB".
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGT"[exp])
+}
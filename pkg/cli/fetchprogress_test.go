@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Reports half of the bundle fetched on the first poll and done on the second.
+type progressHostService struct {
+	fakeHostService
+
+	mu    sync.Mutex
+	polls map[string]int
+}
+
+func (s *progressHostService) FetchArtifactsOp(req *hoapi.FetchArtifactsRequest, creds string, opts client.FetchArtifactsOptions) (*hoapi.Operation, error) {
+	return &hoapi.Operation{Name: req.AndroidCIBundle.Build.BuildID}, nil
+}
+
+func (s *progressHostService) GetFetchArtifactsProgress(name string) (*client.FetchArtifactsProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polls[name]++
+	return &client.FetchArtifactsProgress{Done: s.polls[name] > 1, BytesFetched: 50, TotalBytes: 100}, nil
+}
+
+func TestFetchBundlesReportsProgress(t *testing.T) {
+	defer func(d time.Duration) { fetchProgressPollInterval = d }(fetchProgressPollInterval)
+	fetchProgressPollInterval = 0
+	hs := &progressHostService{polls: make(map[string]int)}
+	bundles := []fetchBundle{
+		{Name: "main", Bundle: &hoapi.AndroidCIBundle{Build: &hoapi.AndroidCIBuild{BuildID: "1"}}},
+		{Name: "kernel", Bundle: &hoapi.AndroidCIBundle{Build: &hoapi.AndroidCIBuild{BuildID: "2"}}},
+	}
+	var last []BuildFetchProgress
+	tracker := &fetchProgressTracker{
+		progress: make([]BuildFetchProgress, len(bundles)),
+		report:   func(p []BuildFetchProgress) { last = p },
+	}
+
+	res, err := fetchBundles(hs, bundles, "", client.FetchArtifactsOptions{}, tracker)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Errorf("expected 2 responses, got %d", len(res))
+	}
+	exp := []BuildFetchProgress{
+		{Build: "main", BytesFetched: 100, TotalBytes: 100, Done: true},
+		{Build: "kernel", BytesFetched: 100, TotalBytes: 100, Done: true},
+	}
+	if diff := cmp.Diff(exp, last); diff != "" {
+		t.Errorf("progress mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFetchProgressSummary(t *testing.T) {
+	fetches := []BuildFetchProgress{
+		{Build: "main", BytesFetched: 512 * 1024 * 1024, TotalBytes: 2 * 1024 * 1024 * 1024},
+		{Build: "kernel", BytesFetched: 512 * 1024 * 1024, TotalBytes: 0},
+		{Build: "system", BytesFetched: 1024 * 1024 * 1024, TotalBytes: 1024 * 1024 * 1024, Done: true},
+	}
+
+	got := fetchProgressSummary(fetches)
+
+	if diff := cmp.Diff("50% (1.5GiB of 3.0GiB)", got); diff != "" {
+		t.Errorf("summary mismatch (-want +got):\n%s", diff)
+	}
+	if got := fetchProgressSummary(fetches[1:2]); got != "" {
+		t.Errorf("expected empty summary for fetches of unknown size, got %q", got)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+// Partitions of a Cuttlefish device that can be replaced without recreating it.
+var flashablePartitions = map[string]struct{}{
+	"boot":          {},
+	"init_boot":     {},
+	"vendor_boot":   {},
+	"super":         {},
+	"system":        {},
+	"system_ext":    {},
+	"product":       {},
+	"vendor":        {},
+	"odm":           {},
+	"vbmeta":        {},
+	"vbmeta_system": {},
+	"userdata":      {},
+}
+
+// Flashing reboots the device, devices still booting or already stopped are rejected.
+const flashableCVDStatus = "Running"
+
+func validatePartition(partition string) error {
+	if _, ok := flashablePartitions[partition]; ok {
+		return nil
+	}
+	names := []string{}
+	for n := range flashablePartitions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("invalid partition %q, expected one of: %s", partition, strings.Join(names, ", "))
+}
+
+func validatePartitionImage(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("invalid image: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("invalid image %q: not a regular file", path)
+	}
+	return nil
+}
+
+func validateFlashableCVD(cvd *hoapi.CVD) error {
+	if !strings.EqualFold(cvd.Status, flashableCVDStatus) {
+		return fmt.Errorf("device %q can't be flashed in status %q, it must be %s", cvd.Name, cvd.Status, flashableCVDStatus)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+func TestValidatePartition(t *testing.T) {
+	if err := validatePartition("system"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validatePartition("system.img"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestValidatePartitionImageRejectsDirectory(t *testing.T) {
+	if err := validatePartitionImage(t.TempDir()); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestValidateFlashableCVD(t *testing.T) {
+	if err := validateFlashableCVD(&hoapi.CVD{Name: "cvd-1", Status: "Running"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateFlashableCVD(&hoapi.CVD{Name: "cvd-1", Status: "Starting"}); err == nil {
+		t.Error("expected error")
+	}
+}
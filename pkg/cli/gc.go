@@ -0,0 +1,105 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/hashicorp/go-multierror"
+)
+
+type GCFlags struct {
+	*CVDRemoteFlags
+	Host   string
+	DryRun bool
+}
+
+// Returns the upload directories of the host neither used by its devices nor kept for incremental
+// creates, in `keep`.
+func orphanedUploads(uploads []*client.UploadDir, cvds []*hoapi.CVD, keep []string) []*client.UploadDir {
+	used := make(map[string]struct{})
+	for _, cvd := range cvds {
+		if bs := cvd.BuildSource; bs != nil && bs.UserBuildSource != nil {
+			used[bs.UserBuildSource.ArtifactsDir] = struct{}{}
+		}
+	}
+	for _, dir := range keep {
+		used[dir] = struct{}{}
+	}
+	result := []*client.UploadDir{}
+	for _, u := range uploads {
+		if _, ok := used[u.Name]; !ok {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// Returns the IDs of the devices whose build source is unknown, they may use any upload directory.
+// Hosts leave it empty in their listings at times, as do creates from a canonical config.
+func cvdsWithoutBuildSource(cvds []*hoapi.CVD) []string {
+	result := []string{}
+	for _, cvd := range cvds {
+		if bs := cvd.BuildSource; bs == nil || (bs.UserBuildSource == nil && bs.AndroidCIBuildSource == nil) {
+			result = append(result, cvd.ID())
+		}
+	}
+	return result
+}
+
+func formatUploadSize(u *client.UploadDir) string {
+	if u.SizeBytes == 0 {
+		return "unknown size"
+	}
+	return formatBytes(u.SizeBytes)
+}
+
+// Deletes the orphaned upload directories, or only lists them if `dryRun` is true. Directories that
+// fail to be deleted don't stop the others from being deleted.
+func collectUploads(srv client.HostOrchestratorService, orphaned []*client.UploadDir, dryRun bool, out io.Writer) error {
+	var merr error
+	var reclaimed int64
+	unknown := 0
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	for _, u := range orphaned {
+		if !dryRun {
+			if err := srv.DeleteUpload(u.Name); err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("failed to delete upload directory %q: %w", u.Name, err))
+				continue
+			}
+		}
+		fmt.Fprintf(out, "%s %s (%s)\n", verb, u.Name, formatUploadSize(u))
+		reclaimed += u.SizeBytes
+		if u.SizeBytes == 0 {
+			unknown++
+		}
+	}
+	summary := fmt.Sprintf("%s reclaimed", formatBytes(reclaimed))
+	if dryRun {
+		summary = fmt.Sprintf("%s would be reclaimed", formatBytes(reclaimed))
+	}
+	if unknown > 0 {
+		summary += fmt.Sprintf(", plus %d directories of unknown size", unknown)
+	}
+	fmt.Fprintln(out, summary)
+	return merr
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOrphanedUploads(t *testing.T) {
+	uploads := []*client.UploadDir{{Name: "used"}, {Name: "cached"}, {Name: "orphan", SizeBytes: 1024}}
+	cvds := []*hoapi.CVD{
+		{Name: "cvd-1", BuildSource: &hoapi.BuildSource{UserBuildSource: &hoapi.UserBuildSource{ArtifactsDir: "used"}}},
+		{Name: "cvd-2"},
+	}
+
+	got := orphanedUploads(uploads, cvds, []string{"cached"})
+
+	exp := []*client.UploadDir{{Name: "orphan", SizeBytes: 1024}}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("orphaned uploads mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCVDsWithoutBuildSource(t *testing.T) {
+	cvds := []*hoapi.CVD{
+		{Name: "cvd-1", BuildSource: &hoapi.BuildSource{UserBuildSource: &hoapi.UserBuildSource{ArtifactsDir: "used"}}},
+		{Name: "cvd-2", BuildSource: &hoapi.BuildSource{AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{}}},
+		{Name: "cvd-3"},
+		{Name: "cvd-4", BuildSource: &hoapi.BuildSource{}},
+	}
+
+	got := cvdsWithoutBuildSource(cvds)
+
+	if diff := cmp.Diff([]string{"/cvd-3", "/cvd-4"}, got); diff != "" {
+		t.Errorf("devices mismatch (-want +got):\n%s", diff)
+	}
+}
+
+type gcHostService struct {
+	fakeHostService
+	deleted []string
+}
+
+func (s *gcHostService) DeleteUpload(dir string) error {
+	s.deleted = append(s.deleted, dir)
+	return nil
+}
+
+func TestCollectUploads(t *testing.T) {
+	orphaned := []*client.UploadDir{{Name: "foo", SizeBytes: 2048}, {Name: "bar"}}
+
+	t.Run("dry run", func(t *testing.T) {
+		srv := &gcHostService{}
+		out := &bytes.Buffer{}
+
+		if err := collectUploads(srv, orphaned, true, out); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(srv.deleted) != 0 {
+			t.Errorf("unexpected deletions: %v", srv.deleted)
+		}
+		exp := "Would delete foo (2.0KiB)\nWould delete bar (unknown size)\n" +
+			"2.0KiB would be reclaimed, plus 1 directories of unknown size\n"
+		if diff := cmp.Diff(exp, out.String()); diff != "" {
+			t.Errorf("output mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		srv := &gcHostService{}
+		out := &bytes.Buffer{}
+
+		if err := collectUploads(srv, orphaned, false, out); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff([]string{"foo", "bar"}, srv.deleted); diff != "" {
+			t.Errorf("deletions mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestGCRefusesHostsWithDevicesWithoutBuildSource(t *testing.T) {
+	io, _, _ := newTestIOStreams()
+	opts := &CommandOptions{
+		IOStreams:     io,
+		Args:          []string{"gc", "--host=foo", "--service_url=" + serviceURL},
+		InitialConfig: Config{ConnectionControlDir: t.TempDir(), UploadCacheDir: t.TempDir()},
+		ServiceBuilder: func(opts *client.ServiceOptions) (client.Service, error) {
+			return &fakeService{}, nil
+		},
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+
+	err := NewCVDRemoteCommand(opts).Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "cvd-1") {
+		t.Errorf("expected an error listing cvd-1, got: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	healthProbeFlag         = "health_probe"
+	healthProbeTimeoutFlag  = "health_probe_timeout"
+	healthProbeIntervalFlag = "health_probe_interval"
+)
+
+const (
+	// The device answers shell commands over ADB.
+	adbHealthProbe = "adb"
+	// The device finished booting, sys.boot_completed is set.
+	bootCompletedHealthProbe = "boot_completed"
+	// Prefix of the probes requesting an HTTP endpoint of the device, as http:PORT[/PATH]. The
+	// request goes through the ADB connection and must succeed with a 2xx status.
+	httpHealthProbePrefix = "http:"
+)
+
+const (
+	defaultHealthProbeTimeout  = time.Minute
+	defaultHealthProbeInterval = 2 * time.Second
+)
+
+// Checks the connection is usable before it's reported, disabled if Probe is empty.
+type HealthProbeOpts struct {
+	Probe string
+	// Gives up after this long since the first attempt.
+	Timeout time.Duration
+	// Time between failed attempts.
+	Interval time.Duration
+}
+
+func (o HealthProbeOpts) Validate() error {
+	if o.Probe == "" {
+		return nil
+	}
+	if _, err := parseHealthProbe(o.Probe); err != nil {
+		return fmt.Errorf("invalid --%s flag value: %w", healthProbeFlag, err)
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("invalid --%s flag value: %s", healthProbeTimeoutFlag, o.Timeout)
+	}
+	if o.Interval <= 0 {
+		return fmt.Errorf("invalid --%s flag value: %s", healthProbeIntervalFlag, o.Interval)
+	}
+	return nil
+}
+
+type HealthProbeResult struct {
+	Probe    string        `json:"probe"`
+	Passed   bool          `json:"passed"`
+	Attempts int           `json:"attempts"`
+	Duration time.Duration `json:"duration"`
+	// Error of the last attempt, empty if the probe passed.
+	Error string `json:"error,omitempty"`
+}
+
+// Checks the device with the given ADB serial once.
+type healthProbeFunc func(adbSerial string, timeout time.Duration) error
+
+func parseHealthProbe(probe string) (healthProbeFunc, error) {
+	switch {
+	case probe == adbHealthProbe:
+		return func(serial string, timeout time.Duration) error {
+			out, err := runADBShell(serial, "echo ok", timeout)
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(string(out)) != "ok" {
+				return fmt.Errorf("unexpected shell output: %q", out)
+			}
+			return nil
+		}, nil
+	case probe == bootCompletedHealthProbe:
+		return func(serial string, timeout time.Duration) error {
+			out, err := runADBShell(serial, "getprop sys.boot_completed", timeout)
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(string(out)) != "1" {
+				return fmt.Errorf("device hasn't finished booting")
+			}
+			return nil
+		}, nil
+	case strings.HasPrefix(probe, httpHealthProbePrefix):
+		portStr, path, _ := strings.Cut(strings.TrimPrefix(probe, httpHealthProbePrefix), "/")
+		port, err := parsePort(portStr)
+		if err != nil {
+			return nil, err
+		}
+		return func(serial string, timeout time.Duration) error {
+			return probeDeviceHTTP(serial, port, "/"+path, timeout)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown probe %q, expected %q, %q or %sPORT[/PATH]",
+			probe, adbHealthProbe, bootCompletedHealthProbe, httpHealthProbePrefix)
+	}
+}
+
+// Requests the path from the device's port through the ADB server.
+func probeDeviceHTTP(serial string, port int, path string, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return dialADBDevicePort(serial, port)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	res, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d%s", port, path))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s answered with status %d", path, res.StatusCode)
+	}
+	return nil
+}
+
+// Retries the probe until it passes or the timeout elapses. Every attempt gets the interval as its
+// own timeout.
+func runHealthProbe(opts HealthProbeOpts, probe healthProbeFunc, serial string, now func() time.Time, sleep func(time.Duration)) *HealthProbeResult {
+	result := &HealthProbeResult{Probe: opts.Probe}
+	start := now()
+	for {
+		result.Attempts++
+		err := probe(serial, opts.Interval)
+		result.Duration = now().Sub(start)
+		if err == nil {
+			result.Passed = true
+			result.Error = ""
+			return result
+		}
+		result.Error = err.Error()
+		if result.Duration+opts.Interval > opts.Timeout {
+			return result
+		}
+		sleep(opts.Interval)
+	}
+}
+
+func addHealthProbeFlags(c *cobra.Command, opts *HealthProbeOpts) {
+	c.Flags().StringVar(&opts.Probe, healthProbeFlag, "",
+		fmt.Sprintf("Waits for the device to pass this probe before reporting the connection: %q to answer shell commands, %q to finish booting or %sPORT[/PATH] to serve HTTP",
+			adbHealthProbe, bootCompletedHealthProbe, httpHealthProbePrefix))
+	c.Flags().DurationVar(&opts.Timeout, healthProbeTimeoutFlag, defaultHealthProbeTimeout,
+		"Fails the connection if the probe doesn't pass within this long")
+	c.Flags().DurationVar(&opts.Interval, healthProbeIntervalFlag, defaultHealthProbeInterval,
+		"Time between the probe's attempts")
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthProbeOptsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    HealthProbeOpts
+		wantErr bool
+	}{
+		{name: "disabled", opts: HealthProbeOpts{}},
+		{name: "adb", opts: HealthProbeOpts{Probe: "adb", Timeout: time.Minute, Interval: time.Second}},
+		{name: "boot completed", opts: HealthProbeOpts{Probe: "boot_completed", Timeout: time.Minute, Interval: time.Second}},
+		{name: "http", opts: HealthProbeOpts{Probe: "http:8080/ready", Timeout: time.Minute, Interval: time.Second}},
+		{name: "http without path", opts: HealthProbeOpts{Probe: "http:8080", Timeout: time.Minute, Interval: time.Second}},
+		{name: "http invalid port", opts: HealthProbeOpts{Probe: "http:foo/ready", Timeout: time.Minute, Interval: time.Second}, wantErr: true},
+		{name: "unknown", opts: HealthProbeOpts{Probe: "ping", Timeout: time.Minute, Interval: time.Second}, wantErr: true},
+		{name: "no timeout", opts: HealthProbeOpts{Probe: "adb", Interval: time.Second}, wantErr: true},
+		{name: "no interval", opts: HealthProbeOpts{Probe: "adb", Timeout: time.Minute}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.opts.Validate()
+
+			if tc.wantErr != (err != nil) {
+				t.Errorf("expected error: %t, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// Advances on every sleep.
+type fakeProbeClock struct {
+	now time.Time
+}
+
+func (c *fakeProbeClock) Now() time.Time { return c.now }
+
+func (c *fakeProbeClock) Sleep(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestRunHealthProbePassesAfterRetries(t *testing.T) {
+	clock := &fakeProbeClock{now: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)}
+	calls := 0
+	probe := func(serial string, timeout time.Duration) error {
+		if serial != "127.0.0.1:6520" {
+			t.Errorf("unexpected serial %q", serial)
+		}
+		calls++
+		if calls < 3 {
+			return errors.New("device offline")
+		}
+		return nil
+	}
+	opts := HealthProbeOpts{Probe: adbHealthProbe, Timeout: time.Minute, Interval: 2 * time.Second}
+
+	got := runHealthProbe(opts, probe, "127.0.0.1:6520", clock.Now, clock.Sleep)
+
+	want := &HealthProbeResult{Probe: adbHealthProbe, Passed: true, Attempts: 3, Duration: 4 * time.Second}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunHealthProbeTimesOut(t *testing.T) {
+	clock := &fakeProbeClock{now: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)}
+	probe := func(string, time.Duration) error { return errors.New("device hasn't finished booting") }
+	opts := HealthProbeOpts{Probe: bootCompletedHealthProbe, Timeout: 5 * time.Second, Interval: 2 * time.Second}
+
+	got := runHealthProbe(opts, probe, "127.0.0.1:6520", clock.Now, clock.Sleep)
+
+	want := &HealthProbeResult{
+		Probe:    bootCompletedHealthProbe,
+		Attempts: 3,
+		Duration: 4 * time.Second,
+		Error:    "device hasn't finished booting",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
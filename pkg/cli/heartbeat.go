@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultHeartbeatInterval = 30 * time.Second
+
+type HeartbeatOpts struct {
+	// Time between heartbeats, zero disables them.
+	Interval time.Duration
+}
+
+// Periodically checks a connection is alive, reconnecting when it isn't. Gives up after a failed
+// reconnection.
+type heartbeater struct {
+	interval time.Duration
+	// Returns an error if the connection is dead.
+	check func() error
+	// Replaces a dead connection.
+	reconnect func() error
+	// Called once when reconnecting fails, the heartbeats stop afterwards.
+	onFailure func(error)
+	logger    *log.Logger
+
+	mtx  sync.Mutex
+	last time.Time
+	// Requests a check without waiting for the next heartbeat.
+	triggerCh chan struct{}
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+}
+
+func newHeartbeater(interval time.Duration, check, reconnect func() error, onFailure func(error), logger *log.Logger) *heartbeater {
+	return &heartbeater{
+		interval:  interval,
+		check:     check,
+		reconnect: reconnect,
+		onFailure: onFailure,
+		logger:    logger,
+		last:      time.Now(),
+		triggerCh: make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+func (h *heartbeater) Run() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+			err := h.check()
+			if err == nil {
+				h.beat()
+				continue
+			}
+			h.logger.Printf("Heartbeat failed: %v", err)
+		case <-h.triggerCh:
+		}
+		if h.stopped() {
+			return
+		}
+		if err := h.reconnect(); err != nil {
+			h.logger.Printf("Reconnection failed: %v", err)
+			h.onFailure(err)
+			return
+		}
+		h.logger.Printf("Reconnected")
+		h.beat()
+	}
+}
+
+// Reconnects without waiting for the next heartbeat, used when the connection is known to be dead.
+func (h *heartbeater) Trigger() {
+	select {
+	case h.triggerCh <- struct{}{}:
+	default:
+		// A reconnection is already pending.
+	}
+}
+
+func (h *heartbeater) Stop() {
+	h.stopOnce.Do(func() { close(h.stopCh) })
+}
+
+// Time of the last successful heartbeat or reconnection.
+func (h *heartbeater) Last() time.Time {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.last
+}
+
+func (h *heartbeater) beat() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.last = time.Now()
+}
+
+func (h *heartbeater) stopped() bool {
+	select {
+	case <-h.stopCh:
+		return true
+	default:
+		return false
+	}
+}
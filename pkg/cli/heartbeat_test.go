@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHeartbeaterReconnectsOnFailedCheck(t *testing.T) {
+	reconnected := make(chan struct{}, 1)
+	h := newHeartbeater(time.Millisecond,
+		func() error { return errors.New("dead") },
+		func() error {
+			select {
+			case reconnected <- struct{}{}:
+			default:
+			}
+			return nil
+		},
+		func(err error) { t.Errorf("unexpected failure: %v", err) },
+		log.New(io.Discard, "", 0))
+	start := h.Last()
+	go h.Run()
+	defer h.Stop()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reconnection")
+	}
+	// Give the loop time to record the reconnection.
+	time.Sleep(10 * time.Millisecond)
+
+	if !h.Last().After(start) {
+		t.Error("expected the reconnection to count as a heartbeat")
+	}
+}
+
+func TestHeartbeaterGivesUpOnFailedReconnection(t *testing.T) {
+	reconnectErr := errors.New("unreachable")
+	failed := make(chan error, 1)
+	h := newHeartbeater(time.Hour,
+		func() error { return nil },
+		func() error { return reconnectErr },
+		func(err error) { failed <- err },
+		log.New(io.Discard, "", 0))
+	done := make(chan struct{})
+	go func() {
+		h.Run()
+		close(done)
+	}()
+
+	h.Trigger()
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, reconnectErr) {
+			t.Errorf("expected %v, got %v", reconnectErr, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for failure")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("expected heartbeats to stop")
+	}
+}
+
+func TestConnectFlagsHeartbeatArgs(t *testing.T) {
+	flags := ConnectFlags{
+		CVDRemoteFlags: &CVDRemoteFlags{},
+		heartbeat:      HeartbeatOpts{Interval: 10 * time.Second},
+	}
+
+	got := flags.AsArgs()
+
+	exp := []string{"--heartbeat_interval", "10s"}
+	if diff := cmp.Diff(exp, got[len(got)-len(exp):]); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+const (
+	preCreateHook  = "pre_create"
+	postCreateHook = "post_create"
+)
+
+// Environment variables exposed to the hooks:
+//   - CVDR_HOOK: either "pre_create" or "post_create".
+//   - CVDR_SERVICE_ROOT_ENDPOINT: the root endpoint of the cloud orchestration service.
+//   - CVDR_HOST: the host the device is created in.
+//   - CVDR_DEVICE_NAME: the device name. Post create only.
+//   - CVDR_DEVICE_ID: the device's webrtc id. Post create only.
+//   - CVDR_ADB_ENDPOINT: the local adb endpoint, i.e: 127.0.0.1:6520. Post create only,
+//     empty if the device is not connected.
+const (
+	hookEnvVarHook                = "CVDR_HOOK"
+	hookEnvVarServiceRootEndpoint = "CVDR_SERVICE_ROOT_ENDPOINT"
+	hookEnvVarHost                = "CVDR_HOST"
+	hookEnvVarDeviceName          = "CVDR_DEVICE_NAME"
+	hookEnvVarDeviceID            = "CVDR_DEVICE_ID"
+	hookEnvVarADBEndpoint         = "CVDR_ADB_ENDPOINT"
+)
+
+func preCreateHookEnv(serviceRootEndpoint, host string) map[string]string {
+	return map[string]string{
+		hookEnvVarHook:                preCreateHook,
+		hookEnvVarServiceRootEndpoint: serviceRootEndpoint,
+		hookEnvVarHost:                host,
+	}
+}
+
+func postCreateHookEnv(cvd *RemoteCVD) map[string]string {
+	adbEndpoint := ""
+	if cvd.ConnStatus != nil && cvd.ConnStatus.ADB.Port > 0 {
+		adbEndpoint = fmt.Sprintf("127.0.0.1:%d", cvd.ConnStatus.ADB.Port)
+	}
+	return map[string]string{
+		hookEnvVarHook:                postCreateHook,
+		hookEnvVarServiceRootEndpoint: cvd.ServiceRootEndpoint,
+		hookEnvVarHost:                cvd.Host,
+		hookEnvVarDeviceName:          cvd.Name,
+		hookEnvVarDeviceID:            cvd.WebRTCDeviceID,
+		hookEnvVarADBEndpoint:         adbEndpoint,
+	}
+}
+
+// Runs the hook's shell command with the given variables added to the environment. The hook's
+// output is written to `out`.
+func runHook(name, command string, env map[string]string, out io.Writer) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = os.Environ()
+	keys := []string{}
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+env[k])
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
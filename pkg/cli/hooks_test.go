@@ -0,0 +1,47 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunHookExposesDeviceDetails(t *testing.T) {
+	cvd := &RemoteCVD{
+		RemoteCVDLocator: RemoteCVDLocator{Host: "foo", Name: "cvd-1", WebRTCDeviceID: "cvd-1-1"},
+		ConnStatus:       &ConnStatus{ADB: ForwarderState{Port: 6520}},
+	}
+	out := &bytes.Buffer{}
+
+	err := runHook(postCreateHook, `echo "$CVDR_HOST $CVDR_DEVICE_NAME $CVDR_ADB_ENDPOINT"`, postCreateHookEnv(cvd), out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("foo cvd-1 127.0.0.1:6520\n", out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunHookFails(t *testing.T) {
+	err := runHook(preCreateHook, "exit 1", preCreateHookEnv("http://foo.com/v1", "foo"), &bytes.Buffer{})
+
+	if err == nil {
+		t.Error("expected error")
+	}
+}
@@ -15,14 +15,25 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/spf13/cobra"
 )
 
+var errNoHosts = errors.New("no hosts exist, create one with `cvdr host create` or create without --host to get a new one")
+
 type CreateHostOpts struct {
 	GCP CreateGCPHostOpts
+	// Features the host must have, see the apiv1.HostFeature constants.
+	RequireFeatures []string
 }
 
 type CreateGCPHostOpts struct {
@@ -39,6 +50,7 @@ func createHost(service client.Service, opts CreateHostOpts) (*apiv1.HostInstanc
 				MinCPUPlatform: opts.GCP.MinCPUPlatform,
 			},
 		},
+		RequireFeatures: opts.RequireFeatures,
 	}
 	if len(opts.GCP.AcceleratorConfigs) != 0 {
 		s := []*apiv1.AcceleratorConfig{}
@@ -75,3 +87,272 @@ func findHost(service client.Service, name string) (*apiv1.HostInstance, error)
 	}
 	return nil, fmt.Errorf("name not found: %s", name)
 }
+
+func validateHostFeatures(features []string) error {
+	for _, f := range features {
+		switch {
+		case f == apiv1.HostFeatureNestedVirtualization:
+		case strings.HasPrefix(f, apiv1.HostFeatureGPUPrefix) && len(f) > len(apiv1.HostFeatureGPUPrefix):
+		case strings.HasPrefix(f, apiv1.HostFeatureCPUPlatformPrefix) && len(f) > len(apiv1.HostFeatureCPUPlatformPrefix):
+		default:
+			return fmt.Errorf("invalid host feature %q, expected %s, %s<type> or %s<platform>", f,
+				apiv1.HostFeatureNestedVirtualization, apiv1.HostFeatureGPUPrefix, apiv1.HostFeatureCPUPlatformPrefix)
+		}
+	}
+	return nil
+}
+
+func addRequireFeatureFlag(c *cobra.Command, features *[]string) {
+	c.Flags().StringSliceVar(features, requireFeatureFlag, nil,
+		fmt.Sprintf("Host feature required: %s, %s<type> or %s<platform>. Repeat the flag or separate with commas for multiple features",
+			apiv1.HostFeatureNestedVirtualization, apiv1.HostFeatureGPUPrefix, apiv1.HostFeatureCPUPlatformPrefix))
+}
+
+// Returns the required features the host lacks. Hosts not reporting their features lack all.
+func missingHostFeatures(host *apiv1.HostInstance, required []string) []string {
+	has := make(map[string]bool)
+	for _, f := range host.Features {
+		has[f] = true
+	}
+	var result []string
+	for _, f := range required {
+		if !has[f] {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func checkHostFeatures(host *apiv1.HostInstance, required []string) error {
+	missing := missingHostFeatures(host, required)
+	if len(missing) == 0 {
+		return nil
+	}
+	if host.Features == nil {
+		return fmt.Errorf("host %q doesn't report its features, required: %s", host.Name, strings.Join(missing, ", "))
+	}
+	return fmt.Errorf("host %q lacks the required features: %s", host.Name, strings.Join(missing, ", "))
+}
+
+// Build targets created by default for each device architecture.
+var defaultBuildTargets = map[string]string{
+	apiv1.HostArchX86_64: "aosp_cf_x86_64_phone-trunk_staging-userdebug",
+	apiv1.HostArchARM64:  "aosp_cf_arm64_only_phone-trunk_staging-userdebug",
+}
+
+// Device architectures each host architecture is able to run, riscv64 devices are emulated.
+var hostDeviceArchs = map[string][]string{
+	apiv1.HostArchX86_64: {"x86_64", "riscv64"},
+	apiv1.HostArchARM64:  {"arm64"},
+}
+
+func validateArch(arch string) error {
+	if _, ok := defaultBuildTargets[arch]; !ok {
+		return fmt.Errorf("unknown arch %q, expected %s or %s", arch, apiv1.HostArchX86_64, apiv1.HostArchARM64)
+	}
+	return nil
+}
+
+// Checks the host is able to run devices of the build target's architecture. Hosts not reporting
+// their architecture are assumed to run any device.
+func checkHostArch(host *apiv1.HostInstance, target string) error {
+	supported, ok := hostDeviceArchs[host.Arch]
+	if !ok {
+		return nil
+	}
+	if arch := deviceArchFromTarget(target); !contains(supported, arch) {
+		return fmt.Errorf("host %q is %s, it can't run the %s devices of build target %q, expected %s devices",
+			host.Name, host.Arch, arch, target, strings.Join(supported, " or "))
+	}
+	return nil
+}
+
+// Value of the `--host` flag that selects the least-loaded host automatically.
+const autoHostValue = "auto"
+
+type hostLoad struct {
+	Name string
+	// Number of instances currently running in the host.
+	Running int
+	// Maximum number of instances the host is able to run, zero if unknown.
+	MaxInstances int
+	// Required features the host lacks.
+	Missing []string
+	Err     error
+}
+
+func (l *hostLoad) Available() int {
+	if l.MaxInstances < l.Running {
+		return 0
+	}
+	return l.MaxInstances - l.Running
+}
+
+func (l *hostLoad) String() string {
+	switch {
+	case l.Err != nil:
+		return fmt.Sprintf("%s: unknown utilization: %v", l.Name, l.Err)
+	case len(l.Missing) > 0:
+		return fmt.Sprintf("%s: lacks %s", l.Name, strings.Join(l.Missing, ", "))
+	case l.MaxInstances == 0:
+		return fmt.Sprintf("%s: unknown capacity, %d running", l.Name, l.Running)
+	default:
+		return fmt.Sprintf("%s: %d of %d available", l.Name, l.Available(), l.MaxInstances)
+	}
+}
+
+// Picks the host with the most available capacity able to run `numInstances` more instances,
+// among those with the required features.
+func selectLeastLoadedHost(service client.Service, numInstances int, requireFeatures []string) (string, error) {
+	hosts, err := rankHostsByLoad(service, numInstances, requireFeatures)
+	if err != nil {
+		return "", err
+	}
+	return hosts[0], nil
+}
+
+// Returns the hosts with the required features able to run `numInstances` more instances, the
+// least loaded first.
+func rankHostsByLoad(service client.Service, numInstances int, requireFeatures []string) ([]string, error) {
+	hosts, err := service.ListHosts()
+	if err != nil {
+		return nil, fmt.Errorf("error listing hosts: %w", err)
+	}
+	if len(hosts.Items) == 0 {
+		return nil, errNoHosts
+	}
+	chans := make([]chan *hostLoad, len(hosts.Items))
+	for i, host := range hosts.Items {
+		chans[i] = make(chan *hostLoad)
+		go func(host *apiv1.HostInstance, ch chan<- *hostLoad) {
+			load := &hostLoad{Name: host.Name, Missing: missingHostFeatures(host, requireFeatures)}
+			if host.Capacity != nil {
+				load.MaxInstances = int(host.Capacity.MaxInstances)
+			}
+			cvds, err := service.HostService(host.Name).ListCVDs()
+			if err != nil {
+				load.Err = err
+			}
+			load.Running = runningInstances(cvds)
+			ch <- load
+		}(host, chans[i])
+	}
+	loads := make([]*hostLoad, len(chans))
+	for i, ch := range chans {
+		loads[i] = <-ch
+	}
+	return rankHostLoads(loads, numInstances)
+}
+
+// Hosts list every instance of a device group as its own entry, identified by the group and the
+// instance name, so a multi-instance create counts once per instance.
+func runningInstances(cvds []*hoapi.CVD) int {
+	ids := make(map[string]struct{})
+	for _, c := range cvds {
+		ids[c.ID()] = struct{}{}
+	}
+	return len(ids)
+}
+
+func leastLoadedHost(loads []*hostLoad, numInstances int) (string, error) {
+	hosts, err := rankHostLoads(loads, numInstances)
+	if err != nil {
+		return "", err
+	}
+	return hosts[0], nil
+}
+
+// Hosts not reporting their capacity are candidates too, ranked after the hosts known to have room
+// by the number of instances they run.
+func rankHostLoads(loads []*hostLoad, numInstances int) ([]string, error) {
+	candidates := filterSlice(loads, func(l *hostLoad) bool {
+		return l.Err == nil && len(l.Missing) == 0 && (l.MaxInstances == 0 || l.Available() >= numInstances)
+	})
+	if len(candidates) == 0 {
+		lines := []string{}
+		requirement := ""
+		for _, l := range loads {
+			lines = append(lines, "  "+l.String())
+			if len(l.Missing) > 0 {
+				requirement = " with the required features"
+			}
+		}
+		return nil, fmt.Errorf("no host%s has capacity for %d instance(s):\n%s", requirement, numInstances, strings.Join(lines, "\n"))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if known := candidates[i].MaxInstances != 0; known != (candidates[j].MaxInstances != 0) {
+			return known
+		}
+		if candidates[i].Available() != candidates[j].Available() {
+			return candidates[i].Available() > candidates[j].Available()
+		}
+		return candidates[i].Running < candidates[j].Running
+	})
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.Name
+	}
+	return names, nil
+}
+
+// Maximum number of hosts a create is attempted on with --failover, the first one included.
+const maxFailoverHosts = 3
+
+// Status codes of the responses of hosts lacking the resources to create more devices.
+var hostResourceExhaustedCodes = []int{http.StatusInsufficientStorage, http.StatusServiceUnavailable}
+
+// Whether the create failed because the host ran out of resources, so it may succeed on another.
+func isHostResourceExhausted(err error) bool {
+	var apiErr *client.ApiCallError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range hostResourceExhaustedCodes {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Failure creating devices in one of the hosts tried with --failover.
+type failoverError struct {
+	Host string
+	Err  error
+}
+
+func (e *failoverError) Error() string {
+	return fmt.Sprintf("host %s: %v", e.Host, e.Err)
+}
+
+func (e *failoverError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the configured number of instances for the host's machine type, zero if none. Fails if
+// the host isn't able to run that many instances.
+func defaultNumInstances(host *apiv1.HostInstance, defaults map[string]int) (int, error) {
+	if host.GCP == nil {
+		return 0, nil
+	}
+	n, ok := defaults[host.GCP.MachineType]
+	if !ok {
+		return 0, nil
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid default number of instances for machine type %q: %d", host.GCP.MachineType, n)
+	}
+	if host.Capacity != nil && host.Capacity.MaxInstances > 0 && int64(n) > host.Capacity.MaxInstances {
+		return 0, fmt.Errorf("the default of %d instances for machine type %q exceeds the capacity of host %q: %d",
+			n, host.GCP.MachineType, host.Name, host.Capacity.MaxInstances)
+	}
+	return n, nil
+}
+
+// Returns an empty string if the host's zone is unknown or has no mirror.
+func buildAPIMirror(host *apiv1.HostInstance, mirrors map[string]string) string {
+	if host.GCP == nil || host.GCP.Zone == "" {
+		return ""
+	}
+	return mirrors[host.GCP.Zone]
+}
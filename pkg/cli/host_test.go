@@ -0,0 +1,255 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLeastLoadedHost(t *testing.T) {
+	loads := []*hostLoad{
+		{Name: "foo", Running: 3, MaxInstances: 4},
+		{Name: "bar", Running: 1, MaxInstances: 4},
+		{Name: "baz", Running: 0, MaxInstances: 0},
+		{Name: "qux", Running: 0, MaxInstances: 8, Err: errors.New("unreachable")},
+	}
+
+	got, err := leastLoadedHost(loads, 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("bar", got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLeastLoadedHostNoCapacity(t *testing.T) {
+	loads := []*hostLoad{
+		{Name: "foo", Running: 3, MaxInstances: 4},
+		{Name: "bar", Running: 4, MaxInstances: 4},
+	}
+
+	_, err := leastLoadedHost(loads, 2)
+
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestLeastLoadedHostRequiredFeatures(t *testing.T) {
+	loads := []*hostLoad{
+		{Name: "foo", Running: 0, MaxInstances: 8, Missing: []string{"gpu:nvidia-tesla-t4"}},
+		{Name: "bar", Running: 3, MaxInstances: 4},
+	}
+
+	got, err := leastLoadedHost(loads, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("bar", got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRankHostLoads(t *testing.T) {
+	loads := []*hostLoad{
+		{Name: "foo", Running: 3, MaxInstances: 4},
+		{Name: "bar", Running: 0, MaxInstances: 4},
+		{Name: "baz", Running: 4, MaxInstances: 4},
+		{Name: "qux", Running: 1, MaxInstances: 4},
+	}
+
+	got, err := rankHostLoads(loads, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"bar", "qux", "foo"}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRankHostLoadsUnknownCapacity(t *testing.T) {
+	loads := []*hostLoad{
+		{Name: "foo", Running: 2},
+		{Name: "bar", Running: 4, MaxInstances: 4},
+		{Name: "baz", Running: 0},
+		{Name: "qux", Running: 3, MaxInstances: 4},
+	}
+
+	got, err := rankHostLoads(loads, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"qux", "baz", "foo"}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSelectLeastLoadedHostWithoutCapacity(t *testing.T) {
+	// The hosts of the fake service don't report their capacity, like those of the GCE instance
+	// manager.
+	got, err := selectLeastLoadedHost(&fakeService{}, 2, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "foo" {
+		t.Errorf("expected host foo, got: %q", got)
+	}
+}
+
+func TestRunningInstances(t *testing.T) {
+	cvds := []*hoapi.CVD{
+		{Group: "cvd_1", Name: "1"},
+		{Group: "cvd_1", Name: "2"},
+		{Group: "cvd_2", Name: "1"},
+	}
+
+	if got := runningInstances(cvds); got != 3 {
+		t.Errorf("expected 3 instances, got: %d", got)
+	}
+}
+
+func TestIsHostResourceExhausted(t *testing.T) {
+	tests := []struct {
+		err error
+		exp bool
+	}{
+		{&client.ApiCallError{Code: http.StatusInsufficientStorage}, true},
+		{fmt.Errorf("failed to create: %w", &client.ApiCallError{Code: http.StatusServiceUnavailable}), true},
+		{&client.ApiCallError{Code: http.StatusBadRequest}, false},
+		{errors.New("timeout"), false},
+	}
+	for _, tc := range tests {
+		if got := isHostResourceExhausted(tc.err); got != tc.exp {
+			t.Errorf("expected %t for %v, got: %t", tc.exp, tc.err, got)
+		}
+	}
+}
+
+func TestCheckHostFeatures(t *testing.T) {
+	required := []string{apiv1.HostFeatureNestedVirtualization, "gpu:nvidia-tesla-t4"}
+	tests := []struct {
+		features []string
+		valid    bool
+	}{
+		{[]string{apiv1.HostFeatureNestedVirtualization, "gpu:nvidia-tesla-t4", "cpu_platform:Intel Haswell"}, true},
+		{[]string{apiv1.HostFeatureNestedVirtualization}, false},
+		// Doesn't report them.
+		{nil, false},
+	}
+	for _, tc := range tests {
+		err := checkHostFeatures(&apiv1.HostInstance{Name: "foo", Features: tc.features}, required)
+		if tc.valid && err != nil {
+			t.Errorf("expected host with %v to be accepted, got: %v", tc.features, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected host with %v to be rejected", tc.features)
+		}
+	}
+}
+
+func TestBuildAPIMirror(t *testing.T) {
+	mirrors := map[string]string{"us-central1-a": "https://mirror.example.com"}
+	tests := []struct {
+		host *apiv1.HostInstance
+		exp  string
+	}{
+		{&apiv1.HostInstance{GCP: &apiv1.GCPInstance{Zone: "us-central1-a"}}, "https://mirror.example.com"},
+		{&apiv1.HostInstance{GCP: &apiv1.GCPInstance{Zone: "europe-west1-b"}}, ""},
+		{&apiv1.HostInstance{}, ""},
+	}
+	for _, tc := range tests {
+		if got := buildAPIMirror(tc.host, mirrors); got != tc.exp {
+			t.Errorf("expected %q, got %q", tc.exp, got)
+		}
+	}
+}
+
+func TestDefaultNumInstances(t *testing.T) {
+	defaults := map[string]int{"n1-standard-64": 4}
+	host := func(machineType string, maxInstances int64) *apiv1.HostInstance {
+		return &apiv1.HostInstance{
+			Name:     "foo",
+			GCP:      &apiv1.GCPInstance{MachineType: machineType},
+			Capacity: &apiv1.HostCapacity{MaxInstances: maxInstances},
+		}
+	}
+	tests := []struct {
+		host   *apiv1.HostInstance
+		exp    int
+		expErr bool
+	}{
+		{host("n1-standard-64", 8), 4, false},
+		{host("n1-standard-4", 8), 0, false},
+		{host("n1-standard-64", 2), 0, true},
+	}
+	for _, tc := range tests {
+		got, err := defaultNumInstances(tc.host, defaults)
+
+		if (err != nil) != tc.expErr {
+			t.Errorf("machine type %q: expected error: %t, got: %v", tc.host.GCP.MachineType, tc.expErr, err)
+		}
+		if got != tc.exp {
+			t.Errorf("machine type %q: expected %d, got %d", tc.host.GCP.MachineType, tc.exp, got)
+		}
+	}
+}
+
+func TestCheckHostArch(t *testing.T) {
+	tests := []struct {
+		arch    string
+		target  string
+		wantErr bool
+	}{
+		{"x86_64", "aosp_cf_x86_64_phone-userdebug", false},
+		{"x86_64", "aosp_cf_riscv64_phone-userdebug", false},
+		{"x86_64", "aosp_cf_arm64_only_phone-userdebug", true},
+		{"arm64", "aosp_cf_arm64_only_phone-userdebug", false},
+		{"arm64", "aosp_cf_x86_64_phone-userdebug", true},
+		// Hosts not reporting their arch run any device.
+		{"", "aosp_cf_arm64_only_phone-userdebug", false},
+	}
+	for _, tc := range tests {
+		err := checkHostArch(&apiv1.HostInstance{Name: "foo", Arch: tc.arch}, tc.target)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("checkHostArch(%q, %q) = %v, want error: %t", tc.arch, tc.target, err, tc.wantErr)
+		}
+	}
+}
+
+func TestDefaultBuildTargetsMatchArch(t *testing.T) {
+	for arch, target := range defaultBuildTargets {
+		if got := deviceArchFromTarget(target); got != arch {
+			t.Errorf("default target %q of %s is for %s devices", target, arch, got)
+		}
+	}
+	if err := validateArch("riscv64"); err == nil {
+		t.Error("expected error for an arch without a default target")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Longest time an idle connection is kept open after its idle timeout.
+const maxIdleCheckInterval = time.Minute
+
+// Time of the last data forwarded in either direction, heartbeats and statistics don't count.
+type connActivity struct {
+	// In nanoseconds since the epoch.
+	last atomic.Int64
+}
+
+func newConnActivity() *connActivity {
+	a := &connActivity{}
+	a.Touch()
+	return a
+}
+
+// Safe to call on nil, for forwarders not tracking activity.
+func (a *connActivity) Touch() {
+	if a != nil {
+		a.last.Store(time.Now().UnixNano())
+	}
+}
+
+func (a *connActivity) Last() time.Time {
+	return time.Unix(0, a.last.Load())
+}
+
+// Calls onIdle once the connection had no activity for the timeout.
+type idleMonitor struct {
+	timeout  time.Duration
+	activity *connActivity
+	onIdle   func()
+	logger   *log.Logger
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func newIdleMonitor(timeout time.Duration, activity *connActivity, onIdle func(), logger *log.Logger) *idleMonitor {
+	return &idleMonitor{
+		timeout:  timeout,
+		activity: activity,
+		onIdle:   onIdle,
+		logger:   logger,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 10
+	if interval > maxIdleCheckInterval {
+		return maxIdleCheckInterval
+	}
+	if interval < time.Millisecond {
+		return time.Millisecond
+	}
+	return interval
+}
+
+func (m *idleMonitor) Run() {
+	ticker := time.NewTicker(idleCheckInterval(m.timeout))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			if idle := time.Since(m.activity.Last()); idle >= m.timeout {
+				m.logger.Printf("Closing the connection, idle for %s", idle.Round(time.Second))
+				m.Stop()
+				m.onIdle()
+				return
+			}
+		}
+	}
+}
+
+func (m *idleMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIdleMonitorClosesIdleConnection(t *testing.T) {
+	idle := make(chan struct{})
+	m := newIdleMonitor(20*time.Millisecond, newConnActivity(), func() { close(idle) }, log.New(io.Discard, "", 0))
+	go m.Run()
+	defer m.Stop()
+
+	select {
+	case <-idle:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the idle connection to be closed")
+	}
+}
+
+func TestIdleMonitorKeepsActiveConnection(t *testing.T) {
+	activity := newConnActivity()
+	idle := make(chan struct{}, 1)
+	m := newIdleMonitor(50*time.Millisecond, activity, func() { idle <- struct{}{} }, log.New(io.Discard, "", 0))
+	go m.Run()
+	defer m.Stop()
+
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		activity.Touch()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	select {
+	case <-idle:
+		t.Error("expected the active connection to be kept open")
+	default:
+	}
+}
+
+func TestConnectFlagsIdleTimeoutArgs(t *testing.T) {
+	flags := ConnectFlags{
+		CVDRemoteFlags: &CVDRemoteFlags{},
+		idleTimeout:    30 * time.Minute,
+	}
+
+	got := flags.AsArgs()
+
+	exp := []string{"--idle_timeout", "30m0s"}
+	if diff := cmp.Diff(exp, got[len(got)-len(exp):]); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+}
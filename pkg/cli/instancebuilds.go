@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+// The build of a group of instances in a create mixing builds.
+type InstanceBuild struct {
+	Build hoapi.AndroidCIBuild
+	// Number of instances created from the build.
+	Count int
+}
+
+func (b InstanceBuild) String() string {
+	return fmt.Sprintf("%s:%d", strings.TrimPrefix(androidCIBuildRef(b.Build), "@ab/"), b.Count)
+}
+
+// Build ids are numbers, prefixed with P for presubmit builds.
+var buildIDRe = regexp.MustCompile(`^P?[0-9]+$`)
+
+// Parses an instance build like "aosp-main/aosp_cf_x86_64_phone-trunk_staging-userdebug:2", a
+// branch or build id followed by the target and, optionally, the number of instances.
+func ParseInstanceBuild(v string) (InstanceBuild, error) {
+	errInvalid := fmt.Errorf("invalid instance build %q, expected BRANCH_OR_BUILD_ID/TARGET[:COUNT], i.e: aosp-main/aosp_cf_x86_64_phone-trunk_staging-userdebug:2", v)
+	b := InstanceBuild{Count: 1}
+	ref, count, hasCount := strings.Cut(v, ":")
+	if hasCount {
+		n, err := strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			return InstanceBuild{}, errInvalid
+		}
+		b.Count = n
+	}
+	id, target, ok := strings.Cut(ref, "/")
+	if !ok || id == "" || target == "" || strings.Contains(target, "/") {
+		return InstanceBuild{}, errInvalid
+	}
+	if buildIDRe.MatchString(id) {
+		b.Build.BuildID = id
+	} else {
+		b.Build.Branch = id
+	}
+	b.Build.Target = target
+	return b, nil
+}
+
+// A single instance build is the same as a create with a main build, the instances are only
+// attributed to their builds when mixing them.
+func (o *CreateCVDOpts) normalizeInstanceBuilds() {
+	if len(o.InstanceBuilds) != 1 {
+		return
+	}
+	o.MainBuild = o.InstanceBuilds[0].Build
+	o.NumInstances = o.InstanceBuilds[0].Count
+	o.InstanceBuilds = nil
+}
+
+func (o *CreateCVDOpts) validateInstanceBuilds() error {
+	if len(o.InstanceBuilds) == 0 {
+		return nil
+	}
+	if o.EnvConfig != nil || o.LocalImage || !o.CreateCVDLocalOpts.empty() || o.urlBuildSource() != nil {
+		return errors.New("instance builds are only supported with Android CI builds, without an environment specification or kernel and initramfs URLs")
+	}
+	for _, b := range o.InstanceBuilds {
+		if b.Count <= 0 {
+			return fmt.Errorf("invalid number of instances of build %s: %d", b, b.Count)
+		}
+	}
+	return nil
+}
+
+// Returns the target of each group of instances, the main build's if not mixing builds.
+func (o *CreateCVDOpts) instanceTargets() []string {
+	if len(o.InstanceBuilds) == 0 {
+		return []string{o.MainBuild.Target}
+	}
+	result := []string{}
+	for _, b := range o.InstanceBuilds {
+		result = append(result, b.Build.Target)
+	}
+	return result
+}
+
+// Returns the build of each instance in creation order.
+func (o *CreateCVDOpts) buildPerInstance() []hoapi.AndroidCIBuild {
+	result := []hoapi.AndroidCIBuild{}
+	for _, b := range o.InstanceBuilds {
+		for i := 0; i < b.Count; i++ {
+			result = append(result, b.Build)
+		}
+	}
+	return result
+}
+
+// Sets the default displays of each instance's target in the environment configuration.
+func setInstanceDefaultDisplays(envConfig map[string]any, builds []hoapi.AndroidCIBuild, defaults map[string][]DisplayConfig) {
+	instances, _ := envConfig["instances"].([]any)
+	for i, e := range instances {
+		instance, ok := e.(map[string]any)
+		if !ok || i >= len(builds) {
+			continue
+		}
+		if displays := defaultDisplays(builds[i].Target, defaults); len(displays) > 0 {
+			setPath(instance, "graphics.displays", displaysConfig(displays))
+		}
+	}
+}
+
+// Returns the number of instances created, at least 1.
+func (o *CreateCVDOpts) instancesNum() int {
+	if len(o.InstanceBuilds) == 0 {
+		if o.NumInstances <= 0 {
+			return 1
+		}
+		return o.NumInstances
+	}
+	result := 0
+	for _, b := range o.InstanceBuilds {
+		result += b.Count
+	}
+	return result
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	"github.com/google/go-cmp/cmp"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+func TestParseInstanceBuild(t *testing.T) {
+	tests := []struct {
+		value string
+		exp   InstanceBuild
+	}{
+		{"aosp-main/aosp_cf_x86_64_phone-userdebug", InstanceBuild{hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"}, 1}},
+		{"123/aosp_cf_x86_64_wear-userdebug:3", InstanceBuild{hoapi.AndroidCIBuild{BuildID: "123", Target: "aosp_cf_x86_64_wear-userdebug"}, 3}},
+		{"P456/aosp_cf_x86_64_phone-userdebug:2", InstanceBuild{hoapi.AndroidCIBuild{BuildID: "P456", Target: "aosp_cf_x86_64_phone-userdebug"}, 2}},
+	}
+	for _, tc := range tests {
+		got, err := ParseInstanceBuild(tc.value)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tc.exp, got); diff != "" {
+			t.Errorf("%q: instance build mismatch (-want +got):\n%s", tc.value, diff)
+		}
+	}
+	for _, v := range []string{"aosp-main", "aosp-main/target:0", "aosp-main/target:x", "/target", "a/b/c"} {
+		if _, err := ParseInstanceBuild(v); err == nil {
+			t.Errorf("%q: expected error", v)
+		}
+	}
+}
+
+type instanceBuildsHostService struct {
+	fakeHostService
+	req *hoapi.CreateCVDRequest
+}
+
+func (s *instanceBuildsHostService) CreateCVDOpWithOptions(req *hoapi.CreateCVDRequest, creds string, opts client.CreateCVDOptions) (*hoapi.Operation, error) {
+	s.req = req
+	return &hoapi.Operation{Name: "op"}, nil
+}
+
+func (s *instanceBuildsHostService) WaitForCreateCVDOp(name string) (*hoapi.CreateCVDResponse, error) {
+	return &hoapi.CreateCVDResponse{CVDs: []*hoapi.CVD{{Name: "1"}, {Name: "2"}, {Name: "3"}}}, nil
+}
+
+type instanceBuildsService struct {
+	fakeService
+	hostSrv *instanceBuildsHostService
+}
+
+func (s *instanceBuildsService) HostService(host string) client.HostOrchestratorService {
+	return s.hostSrv
+}
+
+func TestCreateCVDWithInstanceBuilds(t *testing.T) {
+	service := &instanceBuildsService{hostSrv: &instanceBuildsHostService{}}
+	phone := hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"}
+	wear := hoapi.AndroidCIBuild{BuildID: "123", Target: "aosp_cf_x86_64_wear-userdebug"}
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		InstanceBuilds:            []InstanceBuild{{phone, 2}, {wear, 1}},
+		BuildAPICredentialsSource: NoneCredentialsSource,
+		DisplayDefaults:           defaultDisplaysByDeviceType,
+	}
+
+	cvds, err := runCreateCVD(service, opts, func(CreateEvent) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := func(build string, d DisplayConfig) any {
+		return map[string]any{
+			"disk":     map[string]any{"default_build": build},
+			"graphics": map[string]any{"displays": []any{map[string]any{"width": float64(d.Width), "height": float64(d.Height), "dpi": float64(d.DPI)}}},
+		}
+	}
+	phoneInstance := instance("@ab/aosp-main/aosp_cf_x86_64_phone-userdebug", defaultDisplaysByDeviceType["phone"][0])
+	wearInstance := instance("@ab/123/aosp_cf_x86_64_wear-userdebug", defaultDisplaysByDeviceType["wear"][0])
+	exp := map[string]any{"instances": []any{phoneInstance, phoneInstance, wearInstance}}
+	if diff := cmp.Diff(exp, service.hostSrv.req.EnvConfig); diff != "" {
+		t.Errorf("env config mismatch (-want +got):\n%s", diff)
+	}
+	builds := []string{}
+	for _, c := range cvds {
+		builds = append(builds, c.Build)
+	}
+	expBuilds := []string{"aosp-main/aosp_cf_x86_64_phone-userdebug", "aosp-main/aosp_cf_x86_64_phone-userdebug", "123/aosp_cf_x86_64_wear-userdebug"}
+	if diff := cmp.Diff(expBuilds, builds); diff != "" {
+		t.Errorf("builds mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNormalizeSingleInstanceBuild(t *testing.T) {
+	build := hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"}
+	opts := &CreateCVDOpts{InstanceBuilds: []InstanceBuild{{build, 2}}}
+
+	opts.normalizeInstanceBuilds()
+
+	if opts.MainBuild != build || opts.NumInstances != 2 || opts.InstanceBuilds != nil {
+		t.Errorf("expected the main build with 2 instances, got: %+v", opts)
+	}
+}
+
+type mirroredHostsService struct {
+	instanceBuildsService
+}
+
+func (s *mirroredHostsService) ListHosts() (*apiv1.ListHostsResponse, error) {
+	return &apiv1.ListHostsResponse{
+		Items: []*apiv1.HostInstance{{Name: "foo", GCP: &apiv1.GCPInstance{Zone: "us-central1-a"}}},
+	}, nil
+}
+
+// The mirror isn't forwarded with canonical configurations, the user is warned instead.
+func TestCreateCVDWithInstanceBuildsWarnsTheMirrorIsNotUsed(t *testing.T) {
+	service := &mirroredHostsService{instanceBuildsService{hostSrv: &instanceBuildsHostService{}}}
+	phone := hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"}
+	wear := hoapi.AndroidCIBuild{BuildID: "123", Target: "aosp_cf_x86_64_wear-userdebug"}
+	opts := CreateCVDOpts{
+		Host:                      "foo",
+		InstanceBuilds:            []InstanceBuild{{phone, 1}, {wear, 1}},
+		BuildAPICredentialsSource: NoneCredentialsSource,
+		BuildAPIMirrors:           map[string]string{"us-central1-a": "https://mirror.example.com"},
+	}
+	warnings := []string{}
+
+	_, err := runCreateCVD(service, opts, func(e CreateEvent) {
+		if e.Kind == CreateEventWarning {
+			warnings = append(warnings, e.Msg)
+		}
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"the host fetches the build from the default build server, not the mirror https://mirror.example.com"}
+	if diff := cmp.Diff(exp, warnings); diff != "" {
+		t.Errorf("warnings mismatch (-want +got):\n%s", diff)
+	}
+	if _, ok := service.hostSrv.req.EnvConfig["fetch"]; ok {
+		t.Errorf("unexpected fetch settings in the env config: %v", service.hostSrv.req.EnvConfig)
+	}
+}
@@ -0,0 +1,622 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+// Instance properties aren't part of the host orchestrator's create request, they are forwarded
+// in the instances section of the environment canonical configuration instead. See the
+// `EnvConfig` field of `CreateCVDOpts` for the configuration structure.
+
+// Supported gpu modes:
+//   - auto: let the device pick the best mode available in the host.
+//   - drm_virgl: virglrenderer based acceleration, widely compatible but slower than gfxstream.
+//   - gfxstream: host gpu acceleration forwarding the guest's GLES and Vulkan calls, the fastest
+//     but requires a gpu in the host.
+//   - gfxstream_guest_angle: gfxstream with ANGLE translating GLES to Vulkan in the guest, better
+//     Vulkan conformance at some performance cost.
+//   - gfxstream_guest_angle_host_swiftshader: like gfxstream_guest_angle but rendering with
+//     SwiftShader in the host, works in hosts without a gpu.
+//   - guest_swiftshader: software rendering in the guest, works everywhere but it's the slowest.
+//   - none: no graphics, for headless devices.
+var gpuModes = []string{
+	"auto",
+	"drm_virgl",
+	"gfxstream",
+	"gfxstream_guest_angle",
+	"gfxstream_guest_angle_host_swiftshader",
+	"guest_swiftshader",
+	"none",
+}
+
+// Gpu modes supported by device architectures other than the default x86_64.
+var archGPUModes = map[string][]string{
+	"riscv64": {"auto", "guest_swiftshader", "none"},
+}
+
+func validateGPUMode(mode, arch string) error {
+	if !contains(gpuModes, mode) {
+		return fmt.Errorf("unknown gpu mode %q, expected one of: %s", mode, strings.Join(gpuModes, ", "))
+	}
+	if supported, ok := archGPUModes[arch]; ok && !contains(supported, mode) {
+		return fmt.Errorf("gpu mode %q not supported by %s devices, expected one of: %s",
+			mode, arch, strings.Join(supported, ", "))
+	}
+	return nil
+}
+
+// Returns the device architecture from the build target, i.e: "aosp_cf_riscv64_phone-userdebug"
+// results in "riscv64". Defaults to "x86_64".
+func deviceArchFromTarget(target string) string {
+	for _, arch := range []string{"arm64", "riscv64", "x86_64"} {
+		if strings.Contains(target, "_"+arch) {
+			return arch
+		}
+	}
+	return "x86_64"
+}
+
+// Returns the device type from the build target, i.e: "aosp_cf_x86_64_wear-userdebug" results in
+// "wear". Defaults to "phone".
+func deviceTypeFromTarget(target string) string {
+	for _, t := range []string{"auto", "tablet", "tv", "wear"} {
+		if strings.Contains(target, "_"+t) {
+			return t
+		}
+	}
+	return "phone"
+}
+
+type DisplayConfig struct {
+	Width  int
+	Height int
+	DPI    int
+}
+
+func (d DisplayConfig) String() string {
+	return fmt.Sprintf("%dx%d@%d", d.Width, d.Height, d.DPI)
+}
+
+const defaultDisplayDPI = 320
+
+// Parses a display configuration like "1080x2400@420", the DPI defaults to `defaultDisplayDPI`.
+func ParseDisplayConfig(v string) (DisplayConfig, error) {
+	d := DisplayConfig{DPI: defaultDisplayDPI}
+	res, dpi := v, ""
+	i := strings.Index(v, "@")
+	if i >= 0 {
+		res, dpi = v[:i], v[i+1:]
+	}
+	n, err := fmt.Sscanf(res, "%dx%d", &d.Width, &d.Height)
+	if err != nil || n != 2 || fmt.Sprintf("%dx%d", d.Width, d.Height) != res || d.Width <= 0 || d.Height <= 0 {
+		return DisplayConfig{}, fmt.Errorf("invalid display %q, expected WIDTHxHEIGHT[@DPI], i.e: 1080x2400@420", v)
+	}
+	if i >= 0 {
+		if d.DPI, err = strconv.Atoi(dpi); err != nil || d.DPI <= 0 {
+			return DisplayConfig{}, fmt.Errorf("invalid display %q, the DPI must be a positive number", v)
+		}
+	}
+	return d, nil
+}
+
+// Displays of the devices created without explicit displays, by device type. Matches the
+// defaults of the Cuttlefish configuration of each device type. Extended, or overridden, by the
+// `DisplayDefaults` configuration section.
+var defaultDisplaysByDeviceType = map[string][]DisplayConfig{
+	"auto":   {{Width: 1080, Height: 600, DPI: 120}},
+	"phone":  {{Width: 720, Height: 1280, DPI: 320}},
+	"tablet": {{Width: 2560, Height: 1800, DPI: 320}},
+	"tv":     {{Width: 1920, Height: 1080, DPI: 213}},
+	"wear":   {{Width: 450, Height: 450, DPI: 320}},
+}
+
+// Merges the configured display defaults into the built-in ones, the configured ones win.
+func displayDefaults(configured map[string][]string) (map[string][]DisplayConfig, error) {
+	result := make(map[string][]DisplayConfig)
+	for t, d := range defaultDisplaysByDeviceType {
+		result[t] = d
+	}
+	for t, values := range configured {
+		displays := []DisplayConfig{}
+		for _, v := range values {
+			d, err := ParseDisplayConfig(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default displays of %q devices: %w", t, err)
+			}
+			displays = append(displays, d)
+		}
+		result[t] = displays
+	}
+	return result, nil
+}
+
+// Returns the default displays for the build target. Device types added in the configuration are
+// matched like the built-in ones, i.e: "foldable" matches "aosp_cf_x86_64_foldable-userdebug".
+func defaultDisplays(target string, defaults map[string][]DisplayConfig) []DisplayConfig {
+	extra := []string{}
+	for t := range defaults {
+		if _, ok := defaultDisplaysByDeviceType[t]; !ok {
+			extra = append(extra, t)
+		}
+	}
+	sort.Strings(extra)
+	for _, t := range extra {
+		if strings.Contains(target, "_"+t) {
+			return defaults[t]
+		}
+	}
+	return defaults[deviceTypeFromTarget(target)]
+}
+
+// Devices don't boot with a smaller userdata disk. The server also rejects sizes smaller than
+// what the device images require, and disks can't shrink below that.
+const minUserdataSizeMB = 2048
+
+// Parses a human-readable size like "8G", "512MB" or "1.5GiB" into MB. Units are binary, a number
+// without unit is taken as MB.
+func ParseSizeMB(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	multiplier := 1.0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'M':
+			s = s[:n-1]
+		case 'G':
+			multiplier, s = 1024, s[:n-1]
+		case 'T':
+			multiplier, s = 1024*1024, s[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a positive number with an optional M, G or T unit, i.e: 16G", v)
+	}
+	return int64(f * multiplier), nil
+}
+
+func validateUserdataSize(userdataMB int64) error {
+	if userdataMB != 0 && userdataMB < minUserdataSizeMB {
+		return fmt.Errorf("userdata size of %dMB is below the %dMB minimum", userdataMB, minUserdataSizeMB)
+	}
+	return nil
+}
+
+// Checks the userdata disks of the requested instances fit in the host, only if the host reports
+// its free disk space.
+func validateHostDiskCapacity(host *apiv1.HostInstance, userdataMB int64, numInstances int) error {
+	if host.Capacity == nil || host.Capacity.FreeDiskMB == 0 {
+		return nil
+	}
+	if numInstances <= 0 {
+		numInstances = 1
+	}
+	required := userdataMB * int64(numInstances)
+	if required > host.Capacity.FreeDiskMB {
+		return fmt.Errorf("requested userdata disks need %dMB but host %q has %dMB free", required, host.Name, host.Capacity.FreeDiskMB)
+	}
+	return nil
+}
+
+// SELinux modes of the device. Permissive devices log the denials instead of enforcing them, for
+// debugging policies only, they don't behave like the devices users have.
+const (
+	enforcingSELinuxMode  = "enforcing"
+	permissiveSELinuxMode = "permissive"
+)
+
+var selinuxModes = []string{enforcingSELinuxMode, permissiveSELinuxMode}
+
+func validateSELinuxMode(mode string) error {
+	if mode != "" && !contains(selinuxModes, mode) {
+		return fmt.Errorf("unknown selinux mode %q, expected one of: %s", mode, strings.Join(selinuxModes, ", "))
+	}
+	return nil
+}
+
+// Only the ro.boot properties can be set at boot without rebuilding the images: init turns the
+// androidboot arguments of the kernel command line into them, i.e: "androidboot.foo=bar" sets
+// "ro.boot.foo". The other properties come from the images' build.prop files.
+const bootPropertyPrefix = "ro.boot."
+
+// Property names are dot separated words, values go unquoted in the kernel command line.
+var (
+	bootPropertyKeyRe   = regexp.MustCompile(`^ro\.boot(\.[A-Za-z0-9_\-@:]+)+$`)
+	bootPropertyValueRe = regexp.MustCompile(`^[^\s"']*$`)
+)
+
+// Properties the boot depends on, set by the device's launcher. Overriding them may keep the device
+// from booting.
+var reservedBootProperties = []string{
+	"ro.boot.boot_devices",
+	"ro.boot.dynamic_partitions",
+	"ro.boot.hardware",
+	"ro.boot.serialno",
+	"ro.boot.slot_suffix",
+	"ro.boot.vbmeta.digest",
+	"ro.boot.verifiedbootstate",
+}
+
+type BootProperty struct {
+	Key   string
+	Value string
+}
+
+func (p BootProperty) String() string {
+	return p.Key + "=" + p.Value
+}
+
+// Parses a property like "ro.boot.foo=bar", the value may be empty.
+func ParseBootProperty(v string) (BootProperty, error) {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok {
+		return BootProperty{}, fmt.Errorf("invalid property %q, expected KEY=VALUE, i.e: ro.boot.foo=bar", v)
+	}
+	if !bootPropertyKeyRe.MatchString(key) {
+		if !strings.HasPrefix(key, bootPropertyPrefix) {
+			return BootProperty{}, fmt.Errorf("invalid property %q, only %s* properties can be set at boot", key, bootPropertyPrefix)
+		}
+		return BootProperty{}, fmt.Errorf("invalid property name %q", key)
+	}
+	if !bootPropertyValueRe.MatchString(value) {
+		return BootProperty{}, fmt.Errorf("invalid value of property %q, it can't have spaces or quotes", key)
+	}
+	return BootProperty{Key: key, Value: value}, nil
+}
+
+func validateBootProperties(props []BootProperty, selinuxMode string) error {
+	seen := make(map[string]bool)
+	for _, p := range props {
+		if seen[p.Key] {
+			return fmt.Errorf("property %q set more than once", p.Key)
+		}
+		seen[p.Key] = true
+	}
+	if selinuxMode != "" && seen[bootPropertyPrefix+"selinux"] {
+		return fmt.Errorf("property %q conflicts with the selinux mode", bootPropertyPrefix+"selinux")
+	}
+	return nil
+}
+
+// Returns the reserved properties being overridden, see `reservedBootProperties`.
+func reservedBootPropertiesIn(props []BootProperty) []string {
+	result := []string{}
+	for _, p := range props {
+		if contains(reservedBootProperties, p.Key) || strings.HasPrefix(p.Key, "ro.boot.vbmeta.") {
+			result = append(result, p.Key)
+		}
+	}
+	return result
+}
+
+// Serial number the device reports, its ro.serialno. Colons are left out, ADB takes serials with
+// them for network addresses.
+var serialNumberRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+const serialNumberBootProperty = bootPropertyPrefix + "serialno"
+
+func validateSerialNumber(serial string, props []BootProperty) error {
+	if serial == "" {
+		return nil
+	}
+	if !serialNumberRe.MatchString(serial) {
+		return fmt.Errorf("invalid serial number %q, expected up to 64 letters, digits, '.', '_' or '-', starting with a letter or digit", serial)
+	}
+	for _, p := range props {
+		if p.Key == serialNumberBootProperty {
+			return fmt.Errorf("property %q conflicts with the serial number", serialNumberBootProperty)
+		}
+	}
+	return nil
+}
+
+// Instance fields of the canonical configuration cvdr sets, all read by the cvd parser at the
+// revision the `EnvConfig` field of `CreateCVDOpts` refers to:
+// https://android.googlesource.com/device/google/cuttlefish/+/8bbd3b9cd815f756f332791d45c4f492b663e493/host/commands/cvd/parser/
+var canonicalInstanceFields = []string{
+	"boot.bootloader.build",
+	"boot.enable_bootanimation",
+	"boot.extra_bootconfig_args",
+	"boot.kernel.build",
+	"disk.blank_data_image_mb",
+	"disk.default_build",
+	"disk.super.system",
+	"graphics.displays",
+	"graphics.gpu_mode",
+	"security.guest_enforce_security",
+	"security.serial_number",
+}
+
+// Returns the instance properties set in the options, keyed by their dotted path in the instance
+// canonical configuration, see `canonicalInstanceFields`.
+func (o *CreateCVDOpts) instanceOverrides() map[string]any {
+	result := make(map[string]any)
+	if len(o.Displays) > 0 {
+		result["graphics.displays"] = displaysConfig(o.Displays)
+	}
+	if o.GPUMode != "" {
+		result["graphics.gpu_mode"] = o.GPUMode
+	}
+	if o.UserdataSizeMB != 0 {
+		result["disk.blank_data_image_mb"] = o.UserdataSizeMB
+	}
+	if o.NoBootAnimation {
+		result["boot.enable_bootanimation"] = false
+	}
+	if o.SerialNumber != "" {
+		result["security.serial_number"] = o.SerialNumber
+	}
+	if o.SELinuxMode != "" {
+		// launch_cvd boots the device with androidboot.selinux=permissive when not enforcing.
+		result["security.guest_enforce_security"] = o.SELinuxMode == enforcingSELinuxMode
+	}
+	if len(o.BootProperties) > 0 {
+		result["boot.extra_bootconfig_args"] = bootconfigArgs(o.BootProperties)
+	}
+	return result
+}
+
+// Returns the androidboot arguments setting the properties, i.e: "androidboot.foo=bar" for
+// "ro.boot.foo=bar".
+func bootconfigArgs(props []BootProperty) string {
+	args := []string{}
+	for _, p := range props {
+		args = append(args, "androidboot."+strings.TrimPrefix(p.Key, bootPropertyPrefix)+"="+p.Value)
+	}
+	return strings.Join(args, " ")
+}
+
+func displaysConfig(displays []DisplayConfig) []any {
+	result := []any{}
+	for _, d := range displays {
+		result = append(result, map[string]any{"width": d.Width, "height": d.Height, "dpi": d.DPI})
+	}
+	return result
+}
+
+func (o *CreateCVDOpts) validateInstanceOverrides() error {
+	// The device architecture is unknown when given an environment specification.
+	archs := []string{""}
+	if o.EnvConfig == nil {
+		archs = nil
+		for _, t := range o.instanceTargets() {
+			archs = append(archs, deviceArchFromTarget(t))
+		}
+	}
+	for _, arch := range archs {
+		if o.GPUMode != "" {
+			if err := validateGPUMode(o.GPUMode, arch); err != nil {
+				return err
+			}
+		}
+	}
+	if err := validateUserdataSize(o.UserdataSizeMB); err != nil {
+		return err
+	}
+	if err := validateSELinuxMode(o.SELinuxMode); err != nil {
+		return err
+	}
+	if err := validateBootProperties(o.BootProperties, o.SELinuxMode); err != nil {
+		return err
+	}
+	if err := validateSerialNumber(o.SerialNumber, o.BootProperties); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Builds the environment canonical configuration equivalent to the Android CI builds in the
+// options.
+func envConfigFromBuilds(o *CreateCVDOpts) map[string]any {
+	instance := make(map[string]any)
+	if o.KernelBuild != (hoapi.AndroidCIBuild{}) {
+		setPath(instance, "boot.kernel.build", androidCIBuildRef(o.KernelBuild))
+	}
+	if o.BootloaderBuild != (hoapi.AndroidCIBuild{}) {
+		setPath(instance, "boot.bootloader.build", androidCIBuildRef(o.BootloaderBuild))
+	}
+	if o.SystemImgBuild != (hoapi.AndroidCIBuild{}) {
+		setPath(instance, "disk.super.system", androidCIBuildRef(o.SystemImgBuild))
+	}
+	builds := o.buildPerInstance()
+	if len(builds) == 0 {
+		for i := 0; i < o.instancesNum(); i++ {
+			builds = append(builds, o.MainBuild)
+		}
+	}
+	instances := []any{}
+	for _, b := range builds {
+		i := copyMap(instance)
+		setPath(i, "disk.default_build", androidCIBuildRef(b))
+		instances = append(instances, i)
+	}
+	return map[string]any{"instances": instances}
+}
+
+// Formats the build as an Android CI reference in the canonical configuration,
+// i.e: "@ab/aosp-main/aosp_cf_x86_64_phone-trunk_staging-userdebug".
+func androidCIBuildRef(b hoapi.AndroidCIBuild) string {
+	id := b.BuildID
+	if id == "" {
+		id = b.Branch
+	}
+	ref := "@ab/" + id
+	if b.Target != "" {
+		ref += "/" + b.Target
+	}
+	return ref
+}
+
+// Returns a copy of the environment configuration with the overrides applied to every instance.
+func applyInstanceOverrides(envConfig map[string]any, overrides map[string]any) (map[string]any, error) {
+	result := copyMap(envConfig)
+	instances, ok := result["instances"].([]any)
+	if !ok || len(instances) == 0 {
+		return nil, errors.New("environment specification has no instances")
+	}
+	paths := []string{}
+	for p := range overrides {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for i, e := range instances {
+		instance, ok := e.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid instance at index %d in environment specification", i)
+		}
+		for _, p := range paths {
+			setPath(instance, p, overrides[p])
+		}
+	}
+	return result, nil
+}
+
+// Reads a config overlay, a JSON object with the properties of an instance in the canonical
+// configuration, i.e: {"vm": {"memory_mb": 8192}}.
+func readConfigOverlay(name string) (map[string]any, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	overlay := make(map[string]any)
+	if err := json.Unmarshal(b, &overlay); err != nil {
+		return nil, fmt.Errorf("%q is not a JSON object: %w", name, err)
+	}
+	if _, ok := overlay["instances"]; ok {
+		return nil, fmt.Errorf("%q has an instances list, expected the configuration of a single instance."+
+			" Pass environment specifications as the create argument instead", name)
+	}
+	return overlay, nil
+}
+
+// Applies the instance overrides and the config overlay to every instance of the environment
+// configuration. The ones applied last win, the overrides unless `ConfigOverlayWins` is set.
+func (o *CreateCVDOpts) applyInstanceConfig(envConfig map[string]any, overrides map[string]any) (map[string]any, error) {
+	if o.ConfigOverlayWins {
+		result, err := applyInstanceOverrides(envConfig, overrides)
+		if err != nil {
+			return nil, err
+		}
+		return applyConfigOverlay(result, o.ConfigOverlay)
+	}
+	result, err := applyConfigOverlay(envConfig, o.ConfigOverlay)
+	if err != nil {
+		return nil, err
+	}
+	return applyInstanceOverrides(result, overrides)
+}
+
+// Returns a copy of the environment configuration with the overlay merged into every instance.
+func applyConfigOverlay(envConfig map[string]any, overlay map[string]any) (map[string]any, error) {
+	result := copyMap(envConfig)
+	instances, ok := result["instances"].([]any)
+	if !ok || len(instances) == 0 {
+		return nil, errors.New("environment specification has no instances")
+	}
+	for i, e := range instances {
+		instance, ok := e.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid instance at index %d in environment specification", i)
+		}
+		mergeConfig(instance, copyMap(overlay))
+	}
+	return result, nil
+}
+
+// The overlay's values replace the configuration's, except for objects which are merged.
+func mergeConfig(config, overlay map[string]any) {
+	for k, v := range overlay {
+		if vm, ok := v.(map[string]any); ok {
+			if cm, ok := config[k].(map[string]any); ok {
+				mergeConfig(cm, vm)
+				continue
+			}
+		}
+		config[k] = v
+	}
+}
+
+// Returns the sorted dotted paths of the overrides also set by the overlay.
+func configOverlayConflicts(overlay map[string]any, overrides map[string]any) []string {
+	result := []string{}
+	for p := range overrides {
+		if hasPath(overlay, p) {
+			result = append(result, p)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func hasPath(m map[string]any, path string) bool {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]any)
+		if !ok {
+			return false
+		}
+		m = next
+	}
+	_, ok := m[keys[len(keys)-1]]
+	return ok
+}
+
+// Sets the value at the dotted path, creating the intermediate objects as needed.
+func setPath(m map[string]any, path string, value any) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			m[k] = next
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = value
+}
+
+func copyMap(m map[string]any) map[string]any {
+	b, err := json.Marshal(m)
+	if err != nil {
+		panic(fmt.Sprintf("failed to copy json map: %v", err))
+	}
+	result := make(map[string]any)
+	if err := json.Unmarshal(b, &result); err != nil {
+		panic(fmt.Sprintf("failed to copy json map: %v", err))
+	}
+	return result
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,387 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+
+	"github.com/google/go-cmp/cmp"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+func TestValidateGPUMode(t *testing.T) {
+	tests := []struct {
+		mode   string
+		target string
+		valid  bool
+	}{
+		{"gfxstream", "aosp_cf_x86_64_phone-trunk_staging-userdebug", true},
+		{"guest_swiftshader", "aosp_cf_riscv64_phone-trunk_staging-userdebug", true},
+		{"gfxstream", "aosp_cf_riscv64_phone-trunk_staging-userdebug", false},
+		{"foo", "aosp_cf_x86_64_phone-trunk_staging-userdebug", false},
+	}
+	for _, tc := range tests {
+		err := validateGPUMode(tc.mode, deviceArchFromTarget(tc.target))
+
+		if (err == nil) != tc.valid {
+			t.Errorf("mode %q, target %q: expected valid: %t, got error: %v", tc.mode, tc.target, tc.valid, err)
+		}
+	}
+}
+
+func TestEnvConfigFromBuildsWithOverrides(t *testing.T) {
+	opts := &CreateCVDOpts{
+		MainBuild:    hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		KernelBuild:  hoapi.AndroidCIBuild{BuildID: "123", Target: "kernel"},
+		NumInstances: 2,
+		SerialNumber: "foo",
+	}
+
+	got, err := applyInstanceOverrides(envConfigFromBuilds(opts), opts.instanceOverrides())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := map[string]any{
+		"disk":     map[string]any{"default_build": "@ab/aosp-main/aosp_cf_x86_64_phone-userdebug"},
+		"boot":     map[string]any{"kernel": map[string]any{"build": "@ab/123/kernel"}},
+		"security": map[string]any{"serial_number": "foo"},
+	}
+	exp := map[string]any{"instances": []any{instance, instance}}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("env config mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyInstanceOverridesNoInstances(t *testing.T) {
+	_, err := applyInstanceOverrides(map[string]any{}, map[string]any{"graphics.gpu_mode": "gfxstream"})
+
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+// Every field cvdr sets must be one the canonical configuration documents.
+func TestInstanceConfigFieldsAreDocumented(t *testing.T) {
+	opts := &CreateCVDOpts{
+		MainBuild:       hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		KernelBuild:     hoapi.AndroidCIBuild{BuildID: "1", Target: "kernel"},
+		BootloaderBuild: hoapi.AndroidCIBuild{BuildID: "2", Target: "bootloader"},
+		SystemImgBuild:  hoapi.AndroidCIBuild{BuildID: "3", Target: "system"},
+		Displays:        []DisplayConfig{{Width: 1080, Height: 2400, DPI: 420}},
+		GPUMode:         "gfxstream",
+		UserdataSizeMB:  8192,
+		NoBootAnimation: true,
+		SerialNumber:    "foo",
+		SELinuxMode:     "permissive",
+		BootProperties:  []BootProperty{{Key: "ro.boot.foo", Value: "bar"}},
+	}
+
+	envConfig, err := applyInstanceOverrides(envConfigFromBuilds(opts), opts.instanceOverrides())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := envConfig["instances"].([]any)[0].(map[string]any)
+	for _, p := range leafPaths(instance, "") {
+		if !contains(canonicalInstanceFields, p) {
+			t.Errorf("field %q isn't documented by the canonical configuration", p)
+		}
+	}
+}
+
+func leafPaths(m map[string]any, prefix string) []string {
+	result := []string{}
+	for k, v := range m {
+		if next, ok := v.(map[string]any); ok {
+			result = append(result, leafPaths(next, prefix+k+".")...)
+			continue
+		}
+		result = append(result, prefix+k)
+	}
+	return result
+}
+
+func TestInstanceOverridesGPUMode(t *testing.T) {
+	opts := &CreateCVDOpts{GPUMode: "gfxstream"}
+
+	got := opts.instanceOverrides()
+
+	exp := map[string]any{"graphics.gpu_mode": "gfxstream"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("overrides mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestInstanceOverridesSELinuxMode(t *testing.T) {
+	for mode, enforce := range map[string]bool{"permissive": false, "enforcing": true} {
+		opts := &CreateCVDOpts{SELinuxMode: mode}
+
+		got := opts.instanceOverrides()
+
+		exp := map[string]any{"security.guest_enforce_security": enforce}
+		if diff := cmp.Diff(exp, got); diff != "" {
+			t.Errorf("%s: overrides mismatch (-want +got):\n%s", mode, diff)
+		}
+	}
+}
+
+func TestValidateSELinuxMode(t *testing.T) {
+	opts := &CreateCVDOpts{SELinuxMode: "disabled"}
+
+	err := opts.validateInstanceOverrides()
+
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseBootProperty(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    BootProperty
+		wantErr bool
+	}{
+		{value: "ro.boot.foo=bar", want: BootProperty{Key: "ro.boot.foo", Value: "bar"}},
+		{value: "ro.boot.foo=a=b", want: BootProperty{Key: "ro.boot.foo", Value: "a=b"}},
+		{value: "ro.boot.foo=", want: BootProperty{Key: "ro.boot.foo"}},
+		{value: "ro.boot.foo", wantErr: true},
+		{value: "ro.product.board=foo", wantErr: true},
+		{value: "ro.boot.=foo", wantErr: true},
+		{value: "ro.boot.foo=a b", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := ParseBootProperty(tc.value)
+
+		if tc.wantErr != (err != nil) {
+			t.Errorf("%q: expected error: %t, got: %v", tc.value, tc.wantErr, err)
+		}
+		if got != tc.want {
+			t.Errorf("%q: expected %+v, got %+v", tc.value, tc.want, got)
+		}
+	}
+}
+
+func TestInstanceOverridesBootProperties(t *testing.T) {
+	opts := &CreateCVDOpts{BootProperties: []BootProperty{{Key: "ro.boot.foo", Value: "bar"}, {Key: "ro.boot.a.b", Value: ""}}}
+
+	got := opts.instanceOverrides()
+
+	exp := map[string]any{"boot.extra_bootconfig_args": "androidboot.foo=bar androidboot.a.b="}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("overrides mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateBootProperties(t *testing.T) {
+	dup := []BootProperty{{Key: "ro.boot.foo", Value: "a"}, {Key: "ro.boot.foo", Value: "b"}}
+	if err := validateBootProperties(dup, ""); err == nil {
+		t.Error("duplicated property: expected error")
+	}
+	selinux := []BootProperty{{Key: "ro.boot.selinux", Value: "permissive"}}
+	if err := validateBootProperties(selinux, "enforcing"); err == nil {
+		t.Error("selinux property with selinux mode: expected error")
+	}
+}
+
+func TestReservedBootPropertiesIn(t *testing.T) {
+	props := []BootProperty{{Key: "ro.boot.foo"}, {Key: "ro.boot.hardware"}, {Key: "ro.boot.vbmeta.size"}}
+
+	got := reservedBootPropertiesIn(props)
+
+	if diff := cmp.Diff([]string{"ro.boot.hardware", "ro.boot.vbmeta.size"}, got); diff != "" {
+		t.Errorf("reserved properties mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestInstanceOverridesSerialNumber(t *testing.T) {
+	opts := &CreateCVDOpts{SerialNumber: "LAB-07"}
+
+	got := opts.instanceOverrides()
+
+	exp := map[string]any{"security.serial_number": "LAB-07"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("overrides mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateSerialNumber(t *testing.T) {
+	tests := []struct {
+		serial  string
+		props   []BootProperty
+		wantErr bool
+	}{
+		{serial: ""},
+		{serial: "LAB-07"},
+		{serial: "cf.lab_07"},
+		{serial: "127.0.0.1:6520", wantErr: true},
+		{serial: "-lab", wantErr: true},
+		{serial: "lab 07", wantErr: true},
+		{serial: strings.Repeat("a", 65), wantErr: true},
+		{serial: "LAB-07", props: []BootProperty{{Key: "ro.boot.serialno", Value: "foo"}}, wantErr: true},
+	}
+	for _, tc := range tests {
+		err := validateSerialNumber(tc.serial, tc.props)
+
+		if tc.wantErr != (err != nil) {
+			t.Errorf("%q: expected error: %t, got: %v", tc.serial, tc.wantErr, err)
+		}
+	}
+}
+
+func TestParseDisplayConfig(t *testing.T) {
+	for _, v := range []string{"1080x2400@", "1080x2400@-1", "1080@420"} {
+		if _, err := ParseDisplayConfig(v); err == nil {
+			t.Errorf("expected error parsing %q", v)
+		}
+	}
+
+	got, err := ParseDisplayConfig("1080x2400@420")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(DisplayConfig{1080, 2400, 420}, got); diff != "" {
+		t.Errorf("display config mismatch (-want +got):\n%s", diff)
+	}
+	if got, _ := ParseDisplayConfig("1080x2400"); got.DPI != defaultDisplayDPI {
+		t.Errorf("expected default dpi, got %d", got.DPI)
+	}
+}
+
+func TestDefaultDisplays(t *testing.T) {
+	defaults, err := displayDefaults(map[string][]string{
+		"tablet":   {"2560x1600@320"},
+		"foldable": {"1768x2208@386", "1080x2092@386"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target string
+		exp    []DisplayConfig
+	}{
+		{target: "aosp_cf_x86_64_phone-userdebug", exp: []DisplayConfig{{720, 1280, 320}}},
+		{target: "aosp_cf_x86_64_tv-userdebug", exp: []DisplayConfig{{1920, 1080, 213}}},
+		{target: "aosp_cf_x86_64_tablet-userdebug", exp: []DisplayConfig{{2560, 1600, 320}}},
+		{target: "aosp_cf_x86_64_foldable-userdebug", exp: []DisplayConfig{{1768, 2208, 386}, {1080, 2092, 386}}},
+	}
+	for _, tc := range tests {
+		if diff := cmp.Diff(tc.exp, defaultDisplays(tc.target, defaults)); diff != "" {
+			t.Errorf("%s displays mismatch (-want +got):\n%s", tc.target, diff)
+		}
+	}
+}
+
+func TestParseSizeMB(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp int64
+	}{
+		{in: "512", exp: 512},
+		{in: "512M", exp: 512},
+		{in: "16G", exp: 16384},
+		{in: "1.5GiB", exp: 1536},
+		{in: "1tb", exp: 1024 * 1024},
+	}
+	for _, tc := range tests {
+		got, err := ParseSizeMB(tc.in)
+		if err != nil {
+			t.Errorf("ParseSizeMB(%q) failed: %v", tc.in, err)
+		} else if got != tc.exp {
+			t.Errorf("ParseSizeMB(%q) = %d, expected %d", tc.in, got, tc.exp)
+		}
+	}
+	for _, in := range []string{"", "G", "-1G", "16X"} {
+		if _, err := ParseSizeMB(in); err == nil {
+			t.Errorf("ParseSizeMB(%q) expected error", in)
+		}
+	}
+}
+
+func TestValidateHostDiskCapacity(t *testing.T) {
+	host := &apiv1.HostInstance{Name: "foo", Capacity: &apiv1.HostCapacity{FreeDiskMB: 10000}}
+
+	if err := validateHostDiskCapacity(host, 4096, 2); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateHostDiskCapacity(host, 8192, 2); err == nil {
+		t.Error("expected error")
+	}
+	if err := validateHostDiskCapacity(&apiv1.HostInstance{Name: "bar"}, 8192, 2); err != nil {
+		t.Errorf("unreported capacity should not fail: %v", err)
+	}
+}
+
+func TestApplyInstanceConfigOverlay(t *testing.T) {
+	envConfig := map[string]any{"instances": []any{map[string]any{"vm": map[string]any{"cpus": 2}}}}
+	overlay := map[string]any{
+		"vm":       map[string]any{"memory_mb": 8192},
+		"graphics": map[string]any{"gpu_mode": "drm_virgl"},
+	}
+	overrides := map[string]any{"graphics.gpu_mode": "gfxstream"}
+	tests := []struct {
+		overlayWins bool
+		gpuMode     string
+	}{
+		{false, "gfxstream"},
+		{true, "drm_virgl"},
+	}
+	for _, tc := range tests {
+		opts := &CreateCVDOpts{ConfigOverlay: overlay, ConfigOverlayWins: tc.overlayWins}
+
+		got, err := opts.applyInstanceConfig(envConfig, overrides)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance := map[string]any{
+			"vm":       map[string]any{"cpus": float64(2), "memory_mb": float64(8192)},
+			"graphics": map[string]any{"gpu_mode": tc.gpuMode},
+		}
+		exp := map[string]any{"instances": []any{instance}}
+		if diff := cmp.Diff(exp, got); diff != "" {
+			t.Errorf("overlay wins: %t, env config mismatch (-want +got):\n%s", tc.overlayWins, diff)
+		}
+	}
+}
+
+func TestConfigOverlayConflicts(t *testing.T) {
+	overlay := map[string]any{"graphics": map[string]any{"gpu_mode": "drm_virgl"}, "vm": map[string]any{"cpus": 4}}
+	overrides := map[string]any{"graphics.gpu_mode": "gfxstream", "localization.locale": "en-US"}
+
+	got := configOverlayConflicts(overlay, overrides)
+
+	if diff := cmp.Diff([]string{"graphics.gpu_mode"}, got); diff != "" {
+		t.Errorf("conflicts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadConfigOverlayRejectsEnvironmentSpecification(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "overlay.json")
+	if err := os.WriteFile(name, []byte(`{"instances": [{}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readConfigOverlay(name); err == nil {
+		t.Error("expected error")
+	}
+}
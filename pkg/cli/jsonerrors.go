@@ -0,0 +1,98 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+	"github.com/hashicorp/go-multierror"
+)
+
+const jsonErrorsFlag = "json_errors"
+
+// Structured form of an error, written to stderr instead of the error text with --json_errors.
+type JSONError struct {
+	// Status code of the failed api call, zero for errors not coming from an api call.
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message"`
+	// The command that failed, e.g: "cvdr create".
+	Operation string `json:"operation"`
+	Host      string `json:"host,omitempty"`
+	// Sent along with every request of the invocation, allows finding the matching server logs.
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// Associates an error with the host it happened in.
+type hostError struct {
+	Host string
+	Err  error
+}
+
+func (e *hostError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *hostError) Unwrap() error {
+	return e.Err
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Never fails on supported platforms, the id is best effort anyways.
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func newJSONError(err error, operation, correlationID string) *JSONError {
+	res := &JSONError{
+		Message:       err.Error(),
+		Operation:     operation,
+		CorrelationID: correlationID,
+	}
+	var apiErr *client.ApiCallError
+	if errors.As(err, &apiErr) {
+		res.Code = apiErr.Code
+	}
+	var hErr *hostError
+	if errors.As(err, &hErr) {
+		res.Host = hErr.Host
+	}
+	return res
+}
+
+// Writes a single error object, or an array of them for the errors of a batch operation. Every
+// item of a batch is an error of its own, even if others items succeeded.
+func writeJSONErrors(w io.Writer, err error, operation, correlationID string) error {
+	var res any
+	if merr, ok := err.(*multierror.Error); ok {
+		errs := []*JSONError{}
+		for _, e := range merr.Errors {
+			errs = append(errs, newJSONError(e, operation, correlationID))
+		}
+		res = errs
+	} else {
+		res = newJSONError(err, operation, correlationID)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(res)
+}
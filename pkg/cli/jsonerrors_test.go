@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/go-multierror"
+)
+
+func TestWriteJSONErrorsSingle(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := fmt.Errorf("failed creating host: %w", &client.ApiCallError{Code: 403, ErrorMsg: "denied"})
+
+	if err := writeJSONErrors(out, err, "cvdr host create", "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := JSONError{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	exp := JSONError{
+		Code:          403,
+		Message:       err.Error(),
+		Operation:     "cvdr host create",
+		CorrelationID: "abc",
+	}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("json error mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteJSONErrorsBatch(t *testing.T) {
+	out := &bytes.Buffer{}
+	var merr error
+	merr = multierror.Append(merr, &hostError{Host: "foo", Err: errors.New("foo failed")})
+	merr = multierror.Append(merr, errors.New("other failure"))
+
+	if err := writeJSONErrors(out, merr, "cvdr list", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []JSONError{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	exp := []JSONError{
+		{Message: "foo failed", Operation: "cvdr list", Host: "foo"},
+		{Message: "other failure", Operation: "cvdr list"},
+	}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("json errors mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestJSONErrorsFlag(t *testing.T) {
+	io, _, _ := newTestIOStreams()
+	errOut := &bytes.Buffer{}
+	io.ErrOut = errOut
+	opts := &CommandOptions{
+		IOStreams:     io,
+		Args:          []string{"list", "--service_url=" + serviceURL, "--json_errors"},
+		InitialConfig: Config{ConnectionControlDir: t.TempDir()},
+		ServiceBuilder: func(opts *client.ServiceOptions) (client.Service, error) {
+			if opts.CorrelationID == "" {
+				t.Error("expected correlation id")
+			}
+			return nil, errors.New("unreachable")
+		},
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+
+	err := NewCVDRemoteCommand(opts).Execute()
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	got := JSONError{}
+	if err := json.Unmarshal(errOut.Bytes(), &got); err != nil {
+		t.Fatalf("stderr is not a json error: %v, %q", err, errOut.String())
+	}
+	if got.Message != "unreachable" || got.Operation != "cvdr list" || got.CorrelationID == "" {
+		t.Errorf("unexpected json error: %+v", got)
+	}
+}
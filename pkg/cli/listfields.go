@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+)
+
+const outputFieldsFlag = "output_fields"
+
+// Columns printed by `--output_fields` without a value.
+var defaultOutputFields = []string{"name", "host", "status", "build"}
+
+// Fields of the devices `list` can print as columns, in the order they are documented.
+var listOutputFieldNames = []string{
+	"name", "id", "host", "status", "build", "adb", "adb_serial", "serial", "webrtc_device_id", "displays", "preemptible", "expires", "logs",
+}
+
+var listOutputFields = map[string]func(c *RemoteCVD) string{
+	"name":             func(c *RemoteCVD) string { return c.Name },
+	"id":               func(c *RemoteCVD) string { return c.ID },
+	"host":             func(c *RemoteCVD) string { return c.Host },
+	"status":           func(c *RemoteCVD) string { return c.Status },
+	"build":            cvdBuildStr,
+	"adb":              adbStateStr,
+	"adb_serial":       func(c *RemoteCVD) string { return c.ADBSerial },
+	"serial":           func(c *RemoteCVD) string { return c.SerialNumber },
+	"webrtc_device_id": func(c *RemoteCVD) string { return c.WebRTCDeviceID },
+	"displays":         func(c *RemoteCVD) string { return strings.Join(c.Displays, ",") },
+	"preemptible":      func(c *RemoteCVD) string { return strconv.FormatBool(c.Preemptible) },
+	"expires":          func(c *RemoteCVD) string { return cvdExpiryStr(c, time.Now()) },
+	"logs": func(c *RemoteCVD) string {
+		return client.BuildCVDLogsURL(c.ServiceRootEndpoint, c.Host, c.Name)
+	},
+}
+
+// Returns the build the device was created from, or the one its host reports, as
+// "BRANCH_OR_BUILD_ID/TARGET". Empty if neither is known.
+func cvdBuildStr(c *RemoteCVD) string {
+	if c.Build != "" {
+		return c.Build
+	}
+	if c.MainBuild == nil {
+		return ""
+	}
+	id := c.MainBuild.BuildID
+	if id == "" {
+		id = c.MainBuild.Branch
+	}
+	return id + "/" + c.MainBuild.Target
+}
+
+func validateOutputFields(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("no fields given, expected any of: %s", strings.Join(listOutputFieldNames, ", "))
+	}
+	for _, f := range fields {
+		if _, ok := listOutputFields[f]; !ok {
+			return fmt.Errorf("unknown field %q, expected any of: %s", f, strings.Join(listOutputFieldNames, ", "))
+		}
+	}
+	return nil
+}
+
+// Writes a table with a row per device and the given fields as columns, in their order. Empty
+// values are printed as "-" to keep the columns aligned.
+func writeListCVDsTable(w io.Writer, hosts []*RemoteHost, fields []string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{}
+	for _, f := range fields {
+		header = append(header, strings.ToUpper(f))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, h := range hosts {
+		for _, c := range h.CVDs {
+			row := []string{}
+			for _, f := range fields {
+				v := listOutputFields[f](c)
+				if v == "" {
+					v = "-"
+				}
+				row = append(row, v)
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+	}
+	tw.Flush()
+}
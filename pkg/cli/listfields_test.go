@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+func TestValidateOutputFields(t *testing.T) {
+	if err := validateOutputFields(defaultOutputFields); err != nil {
+		t.Errorf("expected default fields valid, got: %v", err)
+	}
+
+	err := validateOutputFields([]string{"name", "memory"})
+
+	if err == nil || !strings.Contains(err.Error(), "adb_serial") {
+		t.Errorf("expected error listing the valid fields, got: %v", err)
+	}
+}
+
+func TestWriteListCVDsTable(t *testing.T) {
+	hosts := []*RemoteHost{
+		{
+			Name: "foo",
+			CVDs: []*RemoteCVD{
+				{
+					RemoteCVDLocator: RemoteCVDLocator{Host: "foo", Name: "cvd-1"},
+					Status:           "Running",
+					MainBuild:        &hoapi.AndroidCIBuild{BuildID: "1234", Target: "aosp_cf_x86_64_phone-userdebug"},
+				},
+				{RemoteCVDLocator: RemoteCVDLocator{Host: "foo", Name: "cvd-10"}, Status: "Stopped"},
+			},
+		},
+	}
+	sb := &strings.Builder{}
+
+	writeListCVDsTable(sb, hosts, []string{"status", "name", "build"})
+
+	exp := "STATUS   NAME    BUILD\n" +
+		"Running  cvd-1   1234/aosp_cf_x86_64_phone-userdebug\n" +
+		"Stopped  cvd-10  -\n"
+	if sb.String() != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, sb.String())
+	}
+}
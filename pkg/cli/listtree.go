@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const treeFlag = "tree"
+
+// Writes the devices as children of their host, i.e:
+//
+//	foo (zone: us-central1-a, 2 of 4 instances)
+//	├── cvd-1/1 Running, ADB: 127.0.0.1:6520
+//	└── cvd-1/2 Running, ADB: not connected
+//
+// The zone is left out if empty.
+func WriteListCVDsTree(w io.Writer, hosts []*RemoteHost, zone string) {
+	for _, h := range hosts {
+		fmt.Fprintln(w, hostNodeOutput(h, zone))
+		if len(h.CVDs) == 0 {
+			fmt.Fprintln(w, "└── (no devices)")
+			continue
+		}
+		for i, c := range h.CVDs {
+			branch := "├── "
+			if i == len(h.CVDs)-1 {
+				branch = "└── "
+			}
+			fmt.Fprintf(w, "%s%s %s, ADB: %s\n", branch, c.ID, c.Status, adbStateStr(c))
+		}
+	}
+}
+
+func hostNodeOutput(h *RemoteHost, zone string) string {
+	details := []string{}
+	if zone != "" {
+		details = append(details, "zone: "+zone)
+	}
+	if h.MaxInstances > 0 {
+		details = append(details, fmt.Sprintf("%d of %d instances", len(h.CVDs), h.MaxInstances))
+	} else {
+		details = append(details, fmt.Sprintf("%d instances", len(h.CVDs)))
+	}
+	return fmt.Sprintf("%s (%s)", h.Name, strings.Join(details, ", "))
+}
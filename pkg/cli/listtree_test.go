@@ -0,0 +1,48 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteListCVDsTree(t *testing.T) {
+	connected := &RemoteCVD{
+		RemoteCVDLocator: RemoteCVDLocator{ID: "cvd-1/1"},
+		Status:           "Running",
+		ConnStatus:       &ConnStatus{ADB: ForwarderState{Port: 6520}},
+	}
+	disconnected := &RemoteCVD{RemoteCVDLocator: RemoteCVDLocator{ID: "cvd-1/2"}, Status: "Running"}
+	hosts := []*RemoteHost{
+		{Name: "foo", CVDs: []*RemoteCVD{connected, disconnected}, MaxInstances: 4},
+		{Name: "bar"},
+	}
+	out := &bytes.Buffer{}
+
+	WriteListCVDsTree(out, hosts, "us-central1-a")
+
+	exp := `foo (zone: us-central1-a, 2 of 4 instances)
+├── cvd-1/1 Running, ADB: 127.0.0.1:6520
+└── cvd-1/2 Running, ADB: not connected
+bar (zone: us-central1-a, 0 instances)
+└── (no devices)
+`
+	if diff := cmp.Diff(exp, out.String()); diff != "" {
+		t.Errorf("tree mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Relative to the root of an Android source tree.
+const buildTopMarker = "build/envsetup.sh"
+
+// Locations of a local Android build.
+type androidBuildEnv struct {
+	BuildTop   string
+	ProductOut string
+	// Whether the values were detected instead of read from the environment variables set by
+	// `lunch`.
+	BuildTopDetected   bool
+	ProductOutDetected bool
+}
+
+func (e *androidBuildEnv) String() string {
+	source := func(detected bool, varName string) string {
+		if detected {
+			return "detected"
+		}
+		return "from " + varName
+	}
+	return fmt.Sprintf("Using build tree %s (%s) and product out %s (%s)",
+		e.BuildTop, source(e.BuildTopDetected, AndroidBuildTopVarName),
+		e.ProductOut, source(e.ProductOutDetected, AndroidProductOutVarName))
+}
+
+// Reads the locations of the local build from the environment variables set by `lunch`. If
+// `detect` is true, the missing ones are detected instead: the build tree is the first directory
+// containing a source tree starting from the current one, and the product out is queried from the
+// build system.
+func getAndroidBuildEnv(detect bool) (*androidBuildEnv, error) {
+	env := &androidBuildEnv{
+		BuildTop:   os.Getenv(AndroidBuildTopVarName),
+		ProductOut: os.Getenv(AndroidProductOutVarName),
+	}
+	if !detect {
+		if env.BuildTop == "" {
+			return nil, MissingEnvVarErr(AndroidBuildTopVarName)
+		}
+		if env.ProductOut == "" {
+			return nil, MissingEnvVarErr(AndroidProductOutVarName)
+		}
+		return env, nil
+	}
+	if env.BuildTop == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect %s: %w", AndroidBuildTopVarName, err)
+		}
+		if env.BuildTop, err = findBuildTop(wd); err != nil {
+			return nil, fmt.Errorf("%s not set and failed to detect it: %w", AndroidBuildTopVarName, err)
+		}
+		env.BuildTopDetected = true
+	}
+	if env.ProductOut == "" {
+		productOut, err := getBuildVar(env.BuildTop, "PRODUCT_OUT", true)
+		if err != nil {
+			return nil, fmt.Errorf("%s not set and failed to detect it, was the tree built?: %w", AndroidProductOutVarName, err)
+		}
+		env.ProductOut = productOut
+		env.ProductOutDetected = true
+	}
+	return env, nil
+}
+
+// Returns the closest directory to `dir`, itself included, that is the root of an Android source
+// tree.
+func findBuildTop(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for start := dir; ; {
+		if _, err := os.Stat(filepath.Join(dir, buildTopMarker)); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no Android source tree found in %q or its parents", start)
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Creates a source tree whose build system reports the given product out.
+func createFakeBuildTop(t *testing.T, productOut string) string {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, buildTopMarker), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho " + productOut + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "out/soong_ui"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestFindBuildTop(t *testing.T) {
+	buildTop := createFakeBuildTop(t, "")
+	nested := filepath.Join(buildTop, "device/google/cuttlefish")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := findBuildTop(nested)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != buildTop {
+		t.Errorf("expected %q, got %q", buildTop, got)
+	}
+	if _, err := findBuildTop(t.TempDir()); err == nil {
+		t.Error("expected error outside of a source tree")
+	}
+}
+
+func TestGetAndroidBuildEnvFromEnvVars(t *testing.T) {
+	t.Setenv(AndroidBuildTopVarName, "/src")
+	t.Setenv(AndroidProductOutVarName, "/src/out/target/product/vsoc_x86_64")
+
+	got, err := getAndroidBuildEnv(true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &androidBuildEnv{BuildTop: "/src", ProductOut: "/src/out/target/product/vsoc_x86_64"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("build env mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetAndroidBuildEnvDetected(t *testing.T) {
+	buildTop := createFakeBuildTop(t, "/src/out/target/product/vsoc_x86_64")
+	chdir(t, filepath.Join(buildTop, "build"))
+	t.Setenv(AndroidBuildTopVarName, "")
+	t.Setenv(AndroidProductOutVarName, "")
+
+	got, err := getAndroidBuildEnv(true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &androidBuildEnv{
+		BuildTop:           buildTop,
+		ProductOut:         "/src/out/target/product/vsoc_x86_64",
+		BuildTopDetected:   true,
+		ProductOutDetected: true,
+	}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("build env mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetAndroidBuildEnvDetectionDisabled(t *testing.T) {
+	buildTop := createFakeBuildTop(t, "/src/out/target/product/vsoc_x86_64")
+	chdir(t, buildTop)
+	t.Setenv(AndroidBuildTopVarName, "")
+	t.Setenv(AndroidProductOutVarName, "")
+
+	_, err := getAndroidBuildEnv(false)
+
+	var missingErr MissingEnvVarErr
+	if !errors.As(err, &missingErr) {
+		t.Errorf("expected MissingEnvVarErr, got %v", err)
+	}
+}
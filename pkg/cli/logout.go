@@ -0,0 +1,96 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const cancelOperationsFlag = "cancel_operations"
+
+type LogoutFlags struct {
+	*CVDRemoteFlags
+	CancelOperations bool
+}
+
+func runLogoutCommand(c *cobra.Command, flags *LogoutFlags, opts *subCommandOpts) error {
+	if flags.CancelOperations {
+		service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+		if err != nil {
+			return fmt.Errorf("failed to build service instance: %w", err)
+		}
+		res, err := service.CancelUserOperations()
+		if err != nil {
+			return fmt.Errorf("failed to cancel your operations: %w", err)
+		}
+		c.Printf("Cancelled %d operations\n", len(res.Cancelled))
+		if len(res.Failed) > 0 {
+			for _, op := range res.Failed {
+				c.PrintErrf("Failed to cancel operation %q of host %q\n", op.Name, op.Host)
+			}
+			// The credentials are kept to retry the cancellation.
+			return fmt.Errorf("failed to cancel %d operations, still logged in", len(res.Failed))
+		}
+	}
+	authn := opts.InitialConfig.DefaultService().Authn
+	if clientAuthnMode(authn) != oidcTokenAuthnMode {
+		c.PrintErrln("No stored credentials to remove")
+		return nil
+	}
+	if err := forgetOIDCToken(authn.OIDCToken.TokenFile, &opts.InitialConfig, c); err != nil {
+		return fmt.Errorf("failed removing the oidc token: %w", err)
+	}
+	c.PrintErrln("Removed the OIDC token")
+	return nil
+}
+
+// Removes the OIDC token from the credential store, if configured, and the plaintext token file.
+// Tokens the "env" backend reads from the environment are left in place.
+func forgetOIDCToken(tokenFile string, config *Config, c *cobra.Command) error {
+	store, err := openCredentialStore(config, c)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		err := store.Delete(oidcTokenCredentialKeyOf(tokenFile))
+		if err != nil && !errors.Is(err, ErrCredentialNotFound) && !errors.Is(err, ErrReadOnlyCredentialStore) {
+			return err
+		}
+	}
+	if err := os.Remove(tokenFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func logoutCommand(opts *subCommandOpts) *cobra.Command {
+	flags := &LogoutFlags{CVDRemoteFlags: opts.RootFlags}
+	logout := &cobra.Command{
+		Use:   "logout [--cancel_operations]",
+		Short: "Removes the stored OIDC token, optionally cancelling your in-progress operations first",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runLogoutCommand(c, flags, opts)
+		},
+	}
+	logout.Flags().BoolVar(&flags.CancelOperations, cancelOperationsFlag, false,
+		"Cancels the device creations you started that are still in progress, deleting their devices once created")
+	return logout
+}
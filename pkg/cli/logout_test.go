@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+)
+
+type cancelOperationsService struct {
+	fakeService
+	res   *apiv1.CancelUserOperationsResponse
+	calls int
+}
+
+func (s *cancelOperationsService) CancelUserOperations() (*apiv1.CancelUserOperationsResponse, error) {
+	s.calls++
+	return s.res, nil
+}
+
+func runLogout(t *testing.T, srv *cancelOperationsService, tokenFile string, args ...string) (string, error) {
+	io, _, out := newTestIOStreams()
+	opts := &CommandOptions{
+		IOStreams: io,
+		Args:      append([]string{"logout"}, args...),
+		InitialConfig: Config{
+			ConnectionControlDir: t.TempDir(),
+			SystemDefaultService: "foo",
+			Services: map[string]*Service{"foo": {
+				ServiceURL: "https://foo.com",
+				Authn:      &AuthnConfig{OIDCToken: &OIDCTokenConfig{TokenFile: tokenFile}},
+				Host:       &HostConfig{},
+			}},
+		},
+		ServiceBuilder: func(*client.ServiceOptions) (client.Service, error) { return srv, nil },
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+	err := NewCVDRemoteCommand(opts).Execute()
+	return out.String(), err
+}
+
+func writeTokenFile(t *testing.T) string {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tokenFile
+}
+
+func TestLogoutCancelsOperations(t *testing.T) {
+	tokenFile := writeTokenFile(t)
+	srv := &cancelOperationsService{res: &apiv1.CancelUserOperationsResponse{
+		Cancelled: []*apiv1.UserOperation{{Host: "foo", Name: "op1"}, {Host: "bar", Name: "op2"}},
+	}}
+
+	out, err := runLogout(t, srv, tokenFile, "--cancel_operations")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Cancelled 2 operations") {
+		t.Errorf("expected the cancelled operations to be reported, got: %q", out)
+	}
+	if _, err := os.Stat(tokenFile); !os.IsNotExist(err) {
+		t.Errorf("expected the token file to be removed, got: %v", err)
+	}
+}
+
+func TestLogoutKeepsCredentialsIfOperationsFailToBeCancelled(t *testing.T) {
+	tokenFile := writeTokenFile(t)
+	srv := &cancelOperationsService{res: &apiv1.CancelUserOperationsResponse{
+		Failed: []*apiv1.UserOperation{{Host: "foo", Name: "op1"}},
+	}}
+
+	_, err := runLogout(t, srv, tokenFile, "--cancel_operations")
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(tokenFile); err != nil {
+		t.Errorf("expected the token file to be kept, got: %v", err)
+	}
+}
+
+func TestLogoutLeavesOperationsRunningByDefault(t *testing.T) {
+	tokenFile := writeTokenFile(t)
+	srv := &cancelOperationsService{res: &apiv1.CancelUserOperationsResponse{}}
+
+	if _, err := runLogout(t, srv, tokenFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.calls != 0 {
+		t.Errorf("expected no operations to be cancelled, got %d calls", srv.calls)
+	}
+	if _, err := os.Stat(tokenFile); !os.IsNotExist(err) {
+		t.Errorf("expected the token file to be removed, got: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	client "github.com/google/cloud-android-orchestration/pkg/client"
+	wclient "github.com/google/cloud-android-orchestration/pkg/webrtcclient"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// A shared agent serves the connections to several devices of a host from a single process and
+// control socket, instead of an agent per device. Nothing is multiplexed over the network: every
+// device still has its own webrtc connection and ADB port, the ADB server identifies devices by
+// their address.
+
+// Controls the connections to several devices of the same host.
+type MuxConnController struct {
+	mtx sync.Mutex
+	// By webrtc device id.
+	controllers map[string]*ConnController
+	control     *net.UnixListener
+	socketName  string
+	logger      *log.Logger
+	// Called after the connection to a device is stopped, nil if not needed.
+	OnDeviceStopped func(tc *ConnController)
+}
+
+// Connects to the devices in parallel. Devices failing to connect are left out and reported in the
+// returned error, the controller is nil only if none connected.
+func NewMuxConnController(
+	controlDir string,
+	service client.Service,
+	cvds []RemoteCVDLocator,
+	localICEConfig *wclient.ICEConfig,
+	connOpts ConnOpts) (*MuxConnController, error) {
+	if len(cvds) == 0 {
+		return nil, errors.New("no devices to connect to")
+	}
+	tcs := make([]*ConnController, len(cvds))
+	errs := make([]error, len(cvds))
+	var wg sync.WaitGroup
+	for i, cvd := range cvds {
+		wg.Add(1)
+		go func(i int, cvd RemoteCVDLocator) {
+			defer wg.Done()
+			tcs[i], errs[i] = connectController(controlDir, service, cvd, localICEConfig, connOpts)
+		}(i, cvd)
+	}
+	wg.Wait()
+	var merr error
+	mux := &MuxConnController{controllers: make(map[string]*ConnController)}
+	for i, tc := range tcs {
+		if errs[i] != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to connect to %q: %w", cvds[i].WebRTCDeviceID, errs[i]))
+			continue
+		}
+		if mux.logger == nil {
+			mux.logger = tc.logger
+			// Named after the first ADB port, like the sockets of single device agents.
+			mux.socketName = fmt.Sprintf("mux-%d.sock", tc.ADBPort())
+		}
+		mux.controllers[tc.cvd.WebRTCDeviceID] = tc
+	}
+	if len(mux.controllers) == 0 {
+		return nil, merr
+	}
+	control, err := createControlSocket(controlDir, mux.socketName)
+	if err != nil {
+		for _, tc := range mux.controllers {
+			tc.stopTURNRotation()
+			tc.stopConsole()
+			tc.stopStats()
+			tc.adbForwarder.StopForwarding(FwdFailed)
+			tc.connection().Close()
+		}
+		return nil, fmt.Errorf("control socket creation failed for host %q: %w", cvds[0].Host, err)
+	}
+	mux.control = control
+	for device, tc := range mux.controllers {
+		if tc.heartbeat != nil {
+			go tc.heartbeat.Run()
+		}
+		if tc.idle != nil {
+			// Only the idle device is disconnected, the agent stays for the others.
+			device := device
+			tc.onIdle = func() {
+				if err := mux.Stop(device); err != nil {
+					mux.logger.Printf("Failed to stop idle connection: %v", err)
+				}
+			}
+			go tc.idle.Run()
+		}
+	}
+	return mux, merr
+}
+
+// Returns the status of every connected device, sorted by device id.
+func (m *MuxConnController) Statuses() []StatusCmdRes {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	result := []StatusCmdRes{}
+	for _, tc := range m.controllers {
+		status := tc.Status()
+		status.ControlSocket = m.socketName
+		result = append(result, StatusCmdRes{CVD: tc.cvd, Status: status})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CVD.WebRTCDeviceID < result[j].CVD.WebRTCDeviceID
+	})
+	return result
+}
+
+// Stops the connection to the device, or to every device if empty. The control loop finishes once
+// no devices are left.
+func (m *MuxConnController) Stop(device string) error {
+	m.mtx.Lock()
+	stopped := []*ConnController{}
+	if device == "" {
+		for _, tc := range m.controllers {
+			stopped = append(stopped, tc)
+		}
+		m.controllers = make(map[string]*ConnController)
+	} else {
+		tc, ok := m.controllers[device]
+		if !ok {
+			m.mtx.Unlock()
+			return fmt.Errorf("not connected to %q", device)
+		}
+		stopped = append(stopped, tc)
+		delete(m.controllers, device)
+	}
+	empty := len(m.controllers) == 0
+	m.mtx.Unlock()
+	for _, tc := range stopped {
+		tc.Stop()
+		if m.OnDeviceStopped != nil {
+			m.OnDeviceStopped(tc)
+		}
+	}
+	if empty {
+		m.control.Close()
+	}
+	return nil
+}
+
+func (m *MuxConnController) controller(device string) (*ConnController, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	tc, ok := m.controllers[device]
+	if !ok {
+		return nil, fmt.Errorf("not connected to %q", device)
+	}
+	return tc, nil
+}
+
+func (m *MuxConnController) Run() {
+	for {
+		conn, err := m.control.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				// control socket closed, exit normally
+				return
+			}
+			m.logger.Printf("Error accepting connection on control socket: %v", err)
+			continue
+		}
+		m.handleControlCommand(conn)
+		conn.Close()
+	}
+}
+
+// Accepts the same commands as the single device agents, the stop commands followed by the device
+// they apply to.
+func (m *MuxConnController) handleControlCommand(conn net.Conn) {
+	buff := make([]byte, 100)
+	n, err := conn.Read(buff)
+	if err != nil {
+		m.logger.Printf("Error reading from control socket connection: %v", err)
+		return
+	}
+	cmd, device, _ := strings.Cut(string(buff[:n]), " ")
+	cmd, arg, _ := strings.Cut(cmd, "=")
+	switch cmd {
+	case versionCmd:
+		if _, err := conn.Write([]byte(fmt.Sprintf("%d", controlSocketCommsVersion))); err != nil {
+			m.logger.Printf("Error writing to control socket connection: %v", err)
+		}
+	case statusCmd:
+		msg, err := json.Marshal(StatusCmdRes{Multiplexed: m.Statuses()})
+		if err != nil {
+			m.logger.Printf("Couldn't marshal status map: %v", err)
+			return
+		}
+		if _, err := conn.Write(msg); err != nil {
+			m.logger.Printf("Error writing to control socket connection: %v", err)
+		}
+	case stopCmd:
+		if err := m.Stop(device); err != nil {
+			m.logger.Printf("Failed to stop connection: %v", err)
+		}
+	case stopRecordingCmd:
+		tc, err := m.controller(device)
+		if err != nil {
+			m.logger.Printf("Failed to stop recording: %v", err)
+			return
+		}
+		tc.stopRecording()
+	case forwardCmd, unforwardCmd:
+		tc, err := m.controller(device)
+		if err == nil {
+			err = handleForwardCmd(tc.forwards, cmd, arg)
+		}
+		writeControlCmdRes(conn, err, m.logger)
+	default:
+		m.logger.Printf("Unknown command on control socket: %q", cmd)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func newTestMuxConnController(t *testing.T, controlDir string, devices ...string) *MuxConnController {
+	logger := log.New(io.Discard, "", 0)
+	mux := &MuxConnController{controllers: make(map[string]*ConnController), logger: logger}
+	for _, d := range devices {
+		f, err := NewForwarder(logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cvd := RemoteCVDLocator{ServiceRootEndpoint: "http://foo.com/v1", Host: "foo", WebRTCDeviceID: d}
+		mux.controllers[d] = &ConnController{cvd: cvd, adbForwarder: f, logger: logger}
+	}
+	mux.socketName = "mux-test.sock"
+	control, err := createControlSocket(controlDir, mux.socketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.control = control
+	return mux
+}
+
+func TestListSharedAgentConnections(t *testing.T) {
+	controlDir := t.TempDir()
+	mux := newTestMuxConnController(t, controlDir, "cvd-1_1", "cvd-2_1")
+	go mux.Run()
+	defer mux.Stop("")
+
+	statuses, err := listCVDConnectionsByHost(controlDir, "foo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 connections, got: %v", statuses)
+	}
+	ports := make(map[int]struct{})
+	for cvd, s := range statuses {
+		if s.ControlSocket != mux.socketName {
+			t.Errorf("expected %s to be served by %q, got: %q", cvd.WebRTCDeviceID, mux.socketName, s.ControlSocket)
+		}
+		if want := mux.controllers[cvd.WebRTCDeviceID].ADBPort(); s.ADB.Port != want {
+			t.Errorf("expected %s at port %d, got: %d", cvd.WebRTCDeviceID, want, s.ADB.Port)
+		}
+		ports[s.ADB.Port] = struct{}{}
+	}
+	if len(ports) != 2 {
+		t.Errorf("expected a port per device, got: %v", statuses)
+	}
+}
+
+func TestDisconnectSharedAgentCVD(t *testing.T) {
+	controlDir := t.TempDir()
+	mux := newTestMuxConnController(t, controlDir, "cvd-1_1", "cvd-2_1")
+	done := make(chan struct{})
+	go func() {
+		mux.Run()
+		close(done)
+	}()
+	statuses, err := listCVDConnectionsByHost(controlDir, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disconnect := func(device string) {
+		for cvd, s := range statuses {
+			if cvd.WebRTCDeviceID == device {
+				if err := DisconnectCVD(controlDir, cvd, s); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+		}
+		t.Fatalf("%s not listed", device)
+	}
+
+	disconnect("cvd-2_1")
+
+	// The command is handled asynchronously.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left := mux.Statuses()
+		if len(left) == 1 && left[0].CVD.WebRTCDeviceID == "cvd-1_1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected only cvd-1_1 to be left, got: %v", left)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	disconnect("cvd-1_1")
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the agent to finish once no devices are left")
+	}
+}
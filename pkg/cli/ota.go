@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+type OTAFlags struct {
+	*CVDRemoteFlags
+	Host    string
+	ToBuild string
+	Full    bool
+	// Authorizes the Build API calls checking the builds before the update.
+	BuildAPICredentialsSource string
+}
+
+// Updating reboots the device, devices still booting or already stopped are rejected.
+const otaCVDStatus = "Running"
+
+// Returns the build the device is running, after checking it can be updated to `toBuild`.
+// Incremental packages are generated from the device's current build, which therefore must be known
+// and older than the target.
+func validateOTACVD(cvd *hoapi.CVD, toBuild string, full bool) (*hoapi.AndroidCIBuild, error) {
+	if !strings.EqualFold(cvd.Status, otaCVDStatus) {
+		return nil, fmt.Errorf("device %q can't be updated in status %q, it must be %s", cvd.Name, cvd.Status, otaCVDStatus)
+	}
+	bs := cvd.BuildSource
+	if bs == nil || bs.AndroidCIBuildSource == nil || bs.AndroidCIBuildSource.MainBuild == nil {
+		return nil, fmt.Errorf("device %q wasn't created from an Android CI build, its OTA compatibility can't be checked", cvd.Name)
+	}
+	current := bs.AndroidCIBuildSource.MainBuild
+	if current.Target == "" {
+		return nil, fmt.Errorf("unknown build target of device %q", cvd.Name)
+	}
+	if current.BuildID == toBuild {
+		return nil, fmt.Errorf("device %q is already running build %s", cvd.Name, toBuild)
+	}
+	if full {
+		return current, nil
+	}
+	if current.BuildID == "" {
+		return nil, fmt.Errorf("unknown current build of device %q, use --%s to apply the full package", cvd.Name, fullOTAFlag)
+	}
+	from, fromErr := strconv.ParseInt(current.BuildID, 10, 64)
+	to, toErr := strconv.ParseInt(toBuild, 10, 64)
+	// Only submitted builds have numeric ids with a meaningful order.
+	if fromErr == nil && toErr == nil && to < from {
+		return nil, fmt.Errorf("incremental packages can't downgrade device %q from build %s to %s, use --%s",
+			cvd.Name, current.BuildID, toBuild, fullOTAFlag)
+	}
+	return current, nil
+}
+
+// Checks the build server has the artifacts the update needs: the full package of the target
+// build, or the target files of both builds to generate the incremental package from.
+func validateOTAArtifacts(api client.BuildAPI, current *hoapi.AndroidCIBuild, toBuild string, full bool) error {
+	hasArtifact := func(buildID, kind string) (bool, error) {
+		artifacts, err := api.ListArtifacts(buildID, current.Target)
+		if errors.Is(err, client.ErrBuildNotFound) {
+			return false, fmt.Errorf("build %s has no %s target", buildID, current.Target)
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed listing the artifacts of build %s: %w", buildID, err)
+		}
+		for _, a := range artifacts {
+			if strings.Contains(a.Name, "-"+kind+"-") && strings.HasSuffix(a.Name, ".zip") {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if full {
+		ok, err := hasArtifact(toBuild, "ota")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("build %s of %s has no full OTA package", toBuild, current.Target)
+		}
+		return nil
+	}
+	for _, id := range []string{current.BuildID, toBuild} {
+		ok, err := hasArtifact(id, "target_files")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("build %s of %s has no target files to generate the incremental package from", id, current.Target)
+		}
+	}
+	return nil
+}
+
+// Reports each stage of the update as a step of the state printer.
+type otaProgressPrinter struct {
+	p     *statePrinter
+	stage string
+	// Of the step running.
+	msg string
+}
+
+// Starts a step with the given message, running until the first stage is reported.
+func newOTAProgressPrinter(p *statePrinter, msg string) *otaProgressPrinter {
+	p.Print(msg)
+	return &otaProgressPrinter{p: p, msg: msg}
+}
+
+func otaStageMsg(stage string) string {
+	switch stage {
+	case apiv1.OTAStageFetching:
+		return "Fetching the OTA package"
+	case apiv1.OTAStageApplying:
+		return "Applying the OTA package"
+	case apiv1.OTAStageRebooting:
+		return "Rebooting into the new build"
+	default:
+		return "Updating: " + stage
+	}
+}
+
+func (o *otaProgressPrinter) OnProgress(progress *apiv1.OTAProgress) {
+	if progress.Stage != o.stage {
+		o.p.PrintDone(o.msg, nil)
+		o.stage = progress.Stage
+		o.msg = otaStageMsg(o.stage)
+		o.p.Print(o.msg)
+	}
+	if progress.Percent > 0 {
+		o.p.PrintProgress(o.msg, fmt.Sprintf("%d%%", progress.Percent), nil)
+	}
+}
+
+// Finishes the step running when the update ended.
+func (o *otaProgressPrinter) Done(err error) {
+	o.p.PrintDone(o.msg, err)
+}
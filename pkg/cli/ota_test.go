@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+func otaTestCVD(status, buildID string) *hoapi.CVD {
+	return &hoapi.CVD{
+		Name:   "cvd-1",
+		Status: status,
+		BuildSource: &hoapi.BuildSource{
+			AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{
+				MainBuild: &hoapi.AndroidCIBuild{BuildID: buildID, Target: "aosp_cf_x86_64_phone"},
+			},
+		},
+	}
+}
+
+func TestValidateOTACVD(t *testing.T) {
+	tests := []struct {
+		name    string
+		cvd     *hoapi.CVD
+		toBuild string
+		full    bool
+		valid   bool
+	}{
+		{"upgrade", otaTestCVD("Running", "123"), "124", false, true},
+		{"not running", otaTestCVD("Starting", "123"), "124", false, false},
+		{"same build", otaTestCVD("Running", "123"), "123", true, false},
+		{"incremental downgrade", otaTestCVD("Running", "124"), "123", false, false},
+		{"full downgrade", otaTestCVD("Running", "124"), "123", true, true},
+		{"unknown current build", otaTestCVD("Running", ""), "124", false, false},
+		{"unknown current build full", otaTestCVD("Running", ""), "124", true, true},
+		{"user build", &hoapi.CVD{Name: "cvd-1", Status: "Running", BuildSource: &hoapi.BuildSource{
+			UserBuildSource: &hoapi.UserBuildSource{ArtifactsDir: "foo"}}}, "124", true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := validateOTACVD(tc.cvd, tc.toBuild, tc.full)
+
+			if (err == nil) != tc.valid {
+				t.Errorf("expected valid: %t, got error: %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestValidateOTAArtifacts(t *testing.T) {
+	current := &hoapi.AndroidCIBuild{BuildID: "123", Target: "aosp_cf_x86_64_phone"}
+	api := &fakeBuildAPI{artifacts: map[string][]client.BuildArtifact{
+		"123": {{Name: "aosp_cf_x86_64_phone-target_files-123.zip"}},
+		"124": {{Name: "aosp_cf_x86_64_phone-target_files-124.zip"}},
+		"125": {{Name: "aosp_cf_x86_64_phone-ota-125.zip"}},
+	}}
+	tests := []struct {
+		toBuild string
+		full    bool
+		valid   bool
+	}{
+		{"124", false, true},
+		{"124", true, false},
+		{"125", true, true},
+		{"125", false, false},
+		{"126", true, false},
+	}
+	for _, tc := range tests {
+		err := validateOTAArtifacts(api, current, tc.toBuild, tc.full)
+
+		if (err == nil) != tc.valid {
+			t.Errorf("validateOTAArtifacts(%q, %t): expected valid: %t, got error: %v", tc.toBuild, tc.full, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+)
+
+// Networks the connections reach the hosts through.
+const (
+	// Through the service, the default.
+	publicNetwork = "public"
+	// Directly, at the host orchestrator's address in an overlay network like Tailscale or WireGuard.
+	overlayNetwork = "overlay"
+)
+
+const networkFlag = "network"
+
+const defaultOverlayHostOrchestratorPort = 2080
+
+// Hosts not accepting connections at their overlay address within this time are reached through
+// the service instead.
+const overlayDialTimeout = 3 * time.Second
+
+func validateNetwork(network string) error {
+	switch network {
+	case "", publicNetwork, overlayNetwork:
+		return nil
+	default:
+		return fmt.Errorf("unknown network %q, expected %s or %s", network, publicNetwork, overlayNetwork)
+	}
+}
+
+// Reaches the host orchestrators directly at the hosts' overlay addresses. Hosts without an
+// overlay address, or unreachable at it, are reached through the service.
+type overlayService struct {
+	client.Service
+	port int
+	// Checks the address accepts connections.
+	dial func(addr string) error
+	warn func(format string, args ...any)
+
+	mtx sync.Mutex
+	// Host orchestrator endpoints by host, empty for hosts reached through the service.
+	endpoints map[string]string
+}
+
+func newOverlayService(service client.Service, config *NetworkConfig, warn func(format string, args ...any)) *overlayService {
+	port := defaultOverlayHostOrchestratorPort
+	if config != nil && config.OverlayHostOrchestratorPort != 0 {
+		port = config.OverlayHostOrchestratorPort
+	}
+	return &overlayService{
+		Service: service,
+		port:    port,
+		dial: func(addr string) error {
+			conn, err := net.DialTimeout("tcp", addr, overlayDialTimeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+		warn:      warn,
+		endpoints: make(map[string]string),
+	}
+}
+
+func (s *overlayService) HostService(host string) client.HostOrchestratorService {
+	if endpoint := s.endpoint(host); endpoint != "" {
+		return client.NewHostOrchestratorService(endpoint)
+	}
+	return s.Service.HostService(host)
+}
+
+// The host is looked up once, later connections to it, like reconnections, use the same network.
+func (s *overlayService) endpoint(host string) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if endpoint, ok := s.endpoints[host]; ok {
+		return endpoint
+	}
+	endpoint := s.resolve(host)
+	s.endpoints[host] = endpoint
+	return endpoint
+}
+
+func (s *overlayService) resolve(host string) string {
+	ins, err := findHost(s.Service, host)
+	if err != nil {
+		s.warn("Failed looking up the overlay address of host %q, using its public address: %v\n", host, err)
+		return ""
+	}
+	if ins.OverlayAddress == "" {
+		s.warn("Host %q has no overlay address, using its public address\n", host)
+		return ""
+	}
+	addr := net.JoinHostPort(ins.OverlayAddress, strconv.Itoa(s.port))
+	if err := s.dial(addr); err != nil {
+		s.warn("Host %q unreachable at its overlay address %s, using its public address: %v\n", host, addr, err)
+		return ""
+	}
+	return "http://" + addr
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type overlayHostsService struct {
+	fakeService
+}
+
+func (overlayHostsService) ListHosts() (*apiv1.ListHostsResponse, error) {
+	return &apiv1.ListHostsResponse{
+		Items: []*apiv1.HostInstance{
+			{Name: "foo", OverlayAddress: "100.64.0.1"},
+			{Name: "bar", OverlayAddress: "100.64.0.2"},
+			{Name: "baz"},
+		},
+	}, nil
+}
+
+func TestOverlayServiceEndpoints(t *testing.T) {
+	dialed := []string{}
+	warnings := 0
+	s := newOverlayService(&overlayHostsService{}, &NetworkConfig{OverlayHostOrchestratorPort: 1080}, func(string, ...any) { warnings++ })
+	s.dial = func(addr string) error {
+		dialed = append(dialed, addr)
+		if addr == "100.64.0.2:1080" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	got := []string{s.endpoint("foo"), s.endpoint("bar"), s.endpoint("baz"), s.endpoint("foo")}
+
+	exp := []string{"http://100.64.0.1:1080", "", "", "http://100.64.0.1:1080"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("endpoints mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"100.64.0.1:1080", "100.64.0.2:1080"}, dialed); diff != "" {
+		t.Errorf("dialed addresses mismatch (-want +got):\n%s", diff)
+	}
+	if warnings != 2 {
+		t.Errorf("expected a warning for each host reached through the service, got %d", warnings)
+	}
+	if _, ok := s.HostService("foo").(*client.HostOrchestratorServiceImpl); !ok {
+		t.Errorf("expected a direct host orchestrator client for the host reachable in the overlay")
+	}
+}
+
+func TestConnectFlagsNetworkArgs(t *testing.T) {
+	flags := ConnectFlags{CVDRemoteFlags: &CVDRemoteFlags{}, network: overlayNetwork}
+
+	got := flags.AsArgs()
+
+	exp := []string{"--network", "overlay"}
+	if diff := cmp.Diff(exp, got[len(got)-len(exp):]); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+}
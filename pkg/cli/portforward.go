@@ -0,0 +1,268 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Ports of the device are reached through the ADB connection, the agent listens in the local port
+// and opens a stream to the device port over ADB for each local connection. Forwards live as long
+// as the connection, and survive its reconnections.
+
+type ForwardFlags struct {
+	*CVDRemoteFlags
+	Host string
+	// Lists the forwards instead of adding one.
+	List bool
+	// Local port of the forward to remove, zero if not removing one.
+	Remove int
+}
+
+type PortForward struct {
+	LocalPort  int `json:"local_port"`
+	DevicePort int `json:"device_port"`
+}
+
+func (f PortForward) String() string {
+	return fmt.Sprintf("%d:%d", f.LocalPort, f.DevicePort)
+}
+
+// Parses a forward like "8080:80", the local port followed by the device port.
+func ParsePortForward(v string) (PortForward, error) {
+	local, device, ok := strings.Cut(v, ":")
+	if !ok {
+		return PortForward{}, fmt.Errorf("invalid forward %q, expected LOCAL_PORT:DEVICE_PORT, i.e: 8080:80", v)
+	}
+	f := PortForward{}
+	var err error
+	if f.LocalPort, err = parsePort(local); err != nil {
+		return PortForward{}, fmt.Errorf("invalid local port in forward %q: %w", v, err)
+	}
+	if f.DevicePort, err = parsePort(device); err != nil {
+		return PortForward{}, fmt.Errorf("invalid device port in forward %q: %w", v, err)
+	}
+	return f, nil
+}
+
+func parsePort(v string) (int, error) {
+	p, err := strconv.Atoi(v)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, fmt.Errorf("%q is not a port number", v)
+	}
+	return p, nil
+}
+
+const (
+	// Followed by "=LOCAL_PORT:DEVICE_PORT".
+	forwardCmd = "forward"
+	// Followed by "=LOCAL_PORT".
+	unforwardCmd = "unforward"
+)
+
+// Replied to the commands that can fail.
+type controlCmdRes struct {
+	Error string `json:",omitempty"`
+}
+
+func writeControlCmdRes(conn net.Conn, err error, logger *log.Logger) {
+	res := controlCmdRes{}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	msg, _ := json.Marshal(res)
+	if _, err := conn.Write(msg); err != nil {
+		logger.Printf("Error writing to control socket connection: %v", err)
+	}
+}
+
+// Sends the command to the device's agent, returning the error it replied with.
+func sendControlCmd(controlDir string, cvd RemoteCVDLocator, status ConnStatus, cmd string) error {
+	conn, err := net.Dial("unixpacket", fmt.Sprintf("%s/%s", controlDir, ControlSocketName(cvd, status)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s/%s's agent: %w", cvd.Host, cvd.WebRTCDeviceID, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(deviceCmd(cmd, cvd, status))); err != nil {
+		return fmt.Errorf("failed to send command to %s/%s's agent: %w", cvd.Host, cvd.WebRTCDeviceID, err)
+	}
+	buff := make([]byte, 4096)
+	n, err := conn.Read(buff)
+	if err != nil {
+		return fmt.Errorf("failed to read the reply of %s/%s's agent: %w", cvd.Host, cvd.WebRTCDeviceID, err)
+	}
+	res := controlCmdRes{}
+	if err := json.Unmarshal(buff[:n], &res); err != nil {
+		return fmt.Errorf("invalid reply of %s/%s's agent, it may predate port forwards: %w", cvd.Host, cvd.WebRTCDeviceID, err)
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func AddPortForward(controlDir string, cvd RemoteCVDLocator, status ConnStatus, f PortForward) error {
+	return sendControlCmd(controlDir, cvd, status, forwardCmd+"="+f.String())
+}
+
+func RemovePortForward(controlDir string, cvd RemoteCVDLocator, status ConnStatus, localPort int) error {
+	return sendControlCmd(controlDir, cvd, status, fmt.Sprintf("%s=%d", unforwardCmd, localPort))
+}
+
+// Handles the forward and unforward commands, the argument follows the command after "=".
+func handleForwardCmd(forwards *portForwards, cmd, arg string) error {
+	switch cmd {
+	case forwardCmd:
+		f, err := ParsePortForward(arg)
+		if err != nil {
+			return err
+		}
+		return forwards.Add(f)
+	case unforwardCmd:
+		port, err := parsePort(arg)
+		if err != nil {
+			return err
+		}
+		return forwards.Remove(port)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+type portForward struct {
+	PortForward
+	listener net.Listener
+}
+
+// The port forwards of a connection.
+type portForwards struct {
+	// Opens a stream to the device port.
+	dial   func(devicePort int) (net.Conn, error)
+	logger *log.Logger
+
+	mtx      sync.Mutex
+	forwards map[int]*portForward
+}
+
+func newPortForwards(dial func(devicePort int) (net.Conn, error), logger *log.Logger) *portForwards {
+	return &portForwards{dial: dial, logger: logger, forwards: make(map[int]*portForward)}
+}
+
+func (p *portForwards) Add(f PortForward) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if existing, ok := p.forwards[f.LocalPort]; ok {
+		return fmt.Errorf("local port %d is already forwarded to device port %d", f.LocalPort, existing.DevicePort)
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", f.LocalPort))
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf("local port %d is already in use, choose another one", f.LocalPort)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to listen in local port %d: %w", f.LocalPort, err)
+	}
+	fwd := &portForward{PortForward: f, listener: l}
+	p.forwards[f.LocalPort] = fwd
+	go p.serve(fwd)
+	p.logger.Printf("Forwarding local port %d to device port %d", f.LocalPort, f.DevicePort)
+	return nil
+}
+
+func (p *portForwards) Remove(localPort int) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	fwd, ok := p.forwards[localPort]
+	if !ok {
+		return fmt.Errorf("local port %d is not forwarded", localPort)
+	}
+	delete(p.forwards, localPort)
+	return fwd.listener.Close()
+}
+
+// Sorted by local port.
+func (p *portForwards) List() []PortForward {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	result := []PortForward{}
+	for _, f := range p.forwards {
+		result = append(result, f.PortForward)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LocalPort < result[j].LocalPort })
+	return result
+}
+
+func (p *portForwards) Close() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for port, f := range p.forwards {
+		f.listener.Close()
+		delete(p.forwards, port)
+	}
+}
+
+func (p *portForwards) serve(f *portForward) {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			// The forward was removed.
+			return
+		}
+		go func() {
+			defer conn.Close()
+			device, err := p.dial(f.DevicePort)
+			if err != nil {
+				p.logger.Printf("Failed to reach device port %d: %v", f.DevicePort, err)
+				return
+			}
+			defer device.Close()
+			done := make(chan struct{}, 2)
+			go func() { io.Copy(device, conn); done <- struct{}{} }()
+			go func() { io.Copy(conn, device); done <- struct{}{} }()
+			// Either side closing ends the stream.
+			<-done
+		}()
+	}
+}
+
+// Prints a line per forward, sorted by device.
+func writePortForwards(w io.Writer, statuses map[RemoteCVDLocator]ConnStatus) {
+	cvds := []RemoteCVDLocator{}
+	for cvd, s := range statuses {
+		if len(s.Forwards) > 0 {
+			cvds = append(cvds, cvd)
+		}
+	}
+	sort.Slice(cvds, func(i, j int) bool {
+		if cvds[i].Host != cvds[j].Host {
+			return cvds[i].Host < cvds[j].Host
+		}
+		return cvds[i].WebRTCDeviceID < cvds[j].WebRTCDeviceID
+	})
+	for _, cvd := range cvds {
+		for _, f := range statuses[cvd].Forwards {
+			fmt.Fprintf(w, "%s/%s: 127.0.0.1:%d -> %d\n", cvd.Host, cvd.WebRTCDeviceID, f.LocalPort, f.DevicePort)
+		}
+	}
+}
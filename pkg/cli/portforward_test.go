@@ -0,0 +1,108 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParsePortForward(t *testing.T) {
+	got, err := ParsePortForward("8080:80")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(PortForward{LocalPort: 8080, DevicePort: 80}, got); diff != "" {
+		t.Errorf("forward mismatch (-want +got):\n%s", diff)
+	}
+	for _, v := range []string{"8080", "0:80", "8080:70000", "a:80"} {
+		if _, err := ParsePortForward(v); err == nil {
+			t.Errorf("%q: expected error", v)
+		}
+	}
+}
+
+func freeLocalPort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestPortForwardsForwardToDevicePort(t *testing.T) {
+	dialed := make(chan int, 1)
+	forwards := newPortForwards(func(devicePort int) (net.Conn, error) {
+		dialed <- devicePort
+		local, device := net.Pipe()
+		// Echoes what it receives, like a server in the device.
+		go func() { io.Copy(device, device) }()
+		return local, nil
+	}, log.New(io.Discard, "", 0))
+	defer forwards.Close()
+	port := freeLocalPort(t)
+
+	if err := forwards.Add(PortForward{LocalPort: port, DevicePort: 80}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buff := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buff); err != nil {
+		t.Fatal(err)
+	}
+	if string(buff) != "ping" || <-dialed != 80 {
+		t.Errorf("expected the data echoed by device port 80, got: %q", buff)
+	}
+	if diff := cmp.Diff([]PortForward{{port, 80}}, forwards.List()); diff != "" {
+		t.Errorf("forwards mismatch (-want +got):\n%s", diff)
+	}
+	if err := forwards.Remove(port); err != nil {
+		t.Fatal(err)
+	}
+	if len(forwards.List()) != 0 {
+		t.Error("expected the forward to be removed")
+	}
+}
+
+func TestPortForwardsLocalPortInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	forwards := newPortForwards(nil, log.New(io.Discard, "", 0))
+
+	err = forwards.Add(PortForward{LocalPort: l.Addr().(*net.TCPAddr).Port, DevicePort: 80})
+
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("expected a port in use error, got: %v", err)
+	}
+}
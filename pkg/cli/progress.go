@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How the state changes are printed.
+const (
+	// Updated in place with visuals if linked to an interactive terminal, the default.
+	progressBar = "bar"
+	// Single lines without ANSI escapes, the progress of a step printed periodically.
+	progressPlain = "plain"
+	// Newline delimited JSON events, see `progressEvent`.
+	progressJSON = "json"
+)
+
+const progressFlag = "progress"
+
+var progressModes = []string{progressBar, progressPlain, progressJSON}
+
+func validateProgressMode(mode string) error {
+	if !contains(progressModes, mode) {
+		return fmt.Errorf("unknown progress mode %q, expected one of: %s", mode, strings.Join(progressModes, ", "))
+	}
+	return nil
+}
+
+// In plain mode the progress of a step is printed at most once per this interval.
+var plainProgressInterval = 10 * time.Second
+
+const (
+	progressEventStarted  = "started"
+	progressEventDone     = "done"
+	progressEventWarning  = "warning"
+	progressEventProgress = "progress"
+)
+
+// A state change printed in JSON mode.
+type progressEvent struct {
+	// One of the progressEvent* constants.
+	Event string `json:"event"`
+	// The create phase the step belongs to, see CreateEvent.Phase.
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message"`
+	// Set in progress events reporting it.
+	Percent *int64 `json:"percent,omitempty"`
+	// Set in done events of failed steps.
+	Error string `json:"error,omitempty"`
+}
+
+func newProgressEvent(e CreateEvent) progressEvent {
+	result := progressEvent{Phase: e.Phase, Message: e.Msg}
+	switch e.Kind {
+	case CreateEventStarted:
+		result.Event = progressEventStarted
+	case CreateEventDone:
+		result.Event = progressEventDone
+		if e.Err != nil {
+			result.Error = e.Err.Error()
+		}
+	case CreateEventWarning:
+		result.Event = progressEventWarning
+	case CreateEventProgress:
+		result.Event = progressEventProgress
+		result.Percent = fetchProgressPercent(e.Fetches)
+	}
+	return result
+}
+
+func (p *statePrinter) printJSON(e progressEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode progress event: %v", err))
+	}
+	fmt.Fprintln(p.Out, string(b))
+}
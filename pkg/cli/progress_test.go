@@ -0,0 +1,63 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrintCreateEventJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := newStatePrinterWithMode(out, false, progressJSON)
+	fetches := []BuildFetchProgress{{Build: "main", BytesFetched: 25, TotalBytes: 100}}
+
+	printCreateEvent(p, CreateEvent{Kind: CreateEventStarted, Phase: fetchPhase, Msg: "Fetching"})
+	printCreateEvent(p, CreateEvent{Kind: CreateEventProgress, Phase: fetchPhase, Msg: "Fetching", Fetches: fetches})
+	printCreateEvent(p, CreateEvent{Kind: CreateEventDone, Phase: fetchPhase, Msg: "Fetching", Err: errors.New("boom")})
+	p.Print("Connecting")
+
+	exp := `{"event":"started","phase":"fetch","message":"Fetching"}
+{"event":"progress","phase":"fetch","message":"Fetching","percent":25}
+{"event":"done","phase":"fetch","message":"Fetching","error":"boom"}
+{"event":"started","message":"Connecting"}
+`
+	if diff := cmp.Diff(exp, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStatePrinterPlainProgress(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := newStatePrinterWithMode(out, false, progressPlain)
+
+	p.Print("Fetching")
+	p.PrintProgress("Fetching", "10%", []string{"  main: 10%"})
+	p.PrintProgress("Fetching", "20%", []string{"  main: 20%"})
+	p.PrintDone("Fetching", nil)
+
+	got := out.String()
+	if strings.Contains(got, "\033") {
+		t.Errorf("expected no ANSI escapes, got: %q", got)
+	}
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[1], " 10%") || !strings.HasSuffix(lines[2], " OK") {
+		t.Errorf("expected the start, a single progress and the done lines, got: %q", got)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+type PruneFlags struct {
+	*CVDRemoteFlags
+	// Only prunes the devices of this host, all the hosts if empty.
+	Host string
+	// Only prunes the devices created at least this long ago, any device if zero.
+	OlderThan time.Duration
+	DryRun    bool
+	Yes       bool
+}
+
+const olderThanFlag = "older_than"
+
+// A device left behind by a create, i.e: one that failed to boot, still holding its host's
+// resources.
+type pruneCandidate struct {
+	Host string
+	CVD  *client.CVDDetails
+	// Class of the device's status, see `classifyCVDStatus`.
+	Class string
+	// Zero if the host doesn't report when the device was created.
+	CreateTime time.Time
+}
+
+func (c *pruneCandidate) String() string {
+	s := fmt.Sprintf("%s/%s (%s", c.Host, c.CVD.Name, c.Class)
+	if !c.CreateTime.IsZero() {
+		s += ", created " + c.CreateTime.Format(time.RFC3339)
+	}
+	return s + ")"
+}
+
+// Returns the failed and stopped devices of the hosts, in the order of the hosts. With `olderThan`
+// set the devices created later, or whose host doesn't report when, are left out and counted in
+// `unknownAge` for the latter.
+func pruneCandidates(hosts []string, cvds map[string][]*client.CVDDetails, olderThan time.Duration, now time.Time) (result []*pruneCandidate, unknownAge int) {
+	result = []*pruneCandidate{}
+	for _, h := range hosts {
+		for _, cvd := range cvds[h] {
+			class := classifyCVDStatus(cvd.Status)
+			if class != failedCVDStatus && class != stoppedCVDStatus {
+				continue
+			}
+			c := &pruneCandidate{Host: h, CVD: cvd, Class: class}
+			if t, err := time.Parse(time.RFC3339, cvd.CreateTime); err == nil {
+				c.CreateTime = t
+			}
+			if olderThan > 0 {
+				if c.CreateTime.IsZero() {
+					unknownAge++
+					continue
+				}
+				if now.Sub(c.CreateTime) < olderThan {
+					continue
+				}
+			}
+			result = append(result, c)
+		}
+	}
+	return result, unknownAge
+}
+
+// Deletes the candidates, or only lists them if `dryRun` is true. Devices that fail to be deleted
+// don't stop the others from being deleted.
+func pruneCVDs(service client.Service, candidates []*pruneCandidate, dryRun bool, out io.Writer) error {
+	var merr error
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	for _, c := range candidates {
+		if !dryRun {
+			if err := service.HostService(c.Host).DeleteCVD(c.CVD.ID()); err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("failed to delete %s/%s: %w", c.Host, c.CVD.Name, err))
+				continue
+			}
+		}
+		fmt.Fprintf(out, "%s %s\n", verb, c)
+	}
+	return merr
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func cvdDetails(name, status, createTime string) *client.CVDDetails {
+	return &client.CVDDetails{CVD: hoapi.CVD{Group: "cvd", Name: name, Status: status}, CreateTime: createTime}
+}
+
+func TestPruneCandidates(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	cvds := map[string][]*client.CVDDetails{
+		"foo": {
+			cvdDetails("1", "Running", "2024-05-01T12:00:00Z"),
+			cvdDetails("2", "Failed", "2024-05-01T12:00:00Z"),
+			cvdDetails("3", "STOPPED", "2024-05-02T11:30:00Z"),
+		},
+		"bar": {
+			cvdDetails("4", "error", ""),
+			cvdDetails("5", "booting", "2024-05-01T12:00:00Z"),
+		},
+	}
+	names := func(candidates []*pruneCandidate) []string {
+		result := []string{}
+		for _, c := range candidates {
+			result = append(result, c.Host+"/"+c.CVD.Name)
+		}
+		return result
+	}
+
+	all, unknown := pruneCandidates([]string{"foo", "bar"}, cvds, 0, now)
+
+	if diff := cmp.Diff([]string{"foo/2", "foo/3", "bar/4"}, names(all)); diff != "" {
+		t.Errorf("candidates mismatch (-want +got):\n%s", diff)
+	}
+	if unknown != 0 {
+		t.Errorf("expected no devices of unknown age, got: %d", unknown)
+	}
+
+	old, unknown := pruneCandidates([]string{"foo", "bar"}, cvds, time.Hour, now)
+
+	if diff := cmp.Diff([]string{"foo/2"}, names(old)); diff != "" {
+		t.Errorf("candidates older than an hour mismatch (-want +got):\n%s", diff)
+	}
+	if unknown != 1 {
+		t.Errorf("expected 1 device of unknown age, got: %d", unknown)
+	}
+}
+
+type pruneHostService struct {
+	fakeHostService
+	deleted *[]string
+}
+
+func (s pruneHostService) DeleteCVD(id string) error {
+	*s.deleted = append(*s.deleted, id)
+	return nil
+}
+
+type pruneService struct {
+	fakeService
+	deleted []string
+}
+
+func (s *pruneService) HostService(host string) client.HostOrchestratorService {
+	return pruneHostService{deleted: &s.deleted}
+}
+
+func TestPruneCVDs(t *testing.T) {
+	service := &pruneService{}
+	candidates := []*pruneCandidate{{Host: "foo", CVD: cvdDetails("2", "Failed", ""), Class: failedCVDStatus}}
+	out := &bytes.Buffer{}
+
+	if err := pruneCVDs(service, candidates, true, out); err != nil {
+		t.Fatal(err)
+	}
+	if len(service.deleted) != 0 {
+		t.Errorf("expected nothing deleted in a dry run, got: %v", service.deleted)
+	}
+	if err := pruneCVDs(service, candidates, false, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"cvd/2"}, service.deleted); diff != "" {
+		t.Errorf("deleted mismatch (-want +got):\n%s", diff)
+	}
+	exp := "Would delete foo/2 (failed)\nDeleted foo/2 (failed)\n"
+	if diff := cmp.Diff(exp, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+type PsFlags struct {
+	*CVDRemoteFlags
+	Host string
+	// Lists the devices in any status, not only the running ones.
+	All    bool
+	Format string
+}
+
+// Classes of the device statuses reported by the host orchestrators.
+const (
+	runningCVDStatus  = "running"
+	startingCVDStatus = "starting"
+	stoppedCVDStatus  = "stopped"
+	failedCVDStatus   = "failed"
+	unknownCVDStatus  = "unknown"
+)
+
+// Status variants reported by the different host orchestrator versions, normalized by
+// `classifyCVDStatus`.
+var cvdStatusClasses = map[string]string{
+	"running":    runningCVDStatus,
+	"started":    runningCVDStatus,
+	"active":     runningCVDStatus,
+	"starting":   startingCVDStatus,
+	"booting":    startingCVDStatus,
+	"pending":    startingCVDStatus,
+	"stopping":   stoppedCVDStatus,
+	"stopped":    stoppedCVDStatus,
+	"notrunning": stoppedCVDStatus,
+	"failed":     failedCVDStatus,
+	"error":      failedCVDStatus,
+	"crashed":    failedCVDStatus,
+}
+
+// Returns the class of the device status regardless of its case and separators, i.e: "Running",
+// "RUNNING" and "running" are all `runningCVDStatus`.
+func classifyCVDStatus(status string) string {
+	key := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(status)))
+	if class, ok := cvdStatusClasses[key]; ok {
+		return class
+	}
+	return unknownCVDStatus
+}
+
+// Leaves out the devices that aren't running, and the hosts left without devices.
+func runningCVDs(hosts []*RemoteHost) []*RemoteHost {
+	result := []*RemoteHost{}
+	for _, h := range hosts {
+		cvds := []*RemoteCVD{}
+		for _, c := range h.CVDs {
+			if classifyCVDStatus(c.Status) == runningCVDStatus {
+				cvds = append(cvds, c)
+			}
+		}
+		if len(cvds) == 0 {
+			continue
+		}
+		filtered := *h
+		filtered.CVDs = cvds
+		result = append(result, &filtered)
+	}
+	return result
+}
+
+type psEntry struct {
+	RemoteCVDLocator
+	Status string `json:"status"`
+	// Omitted if the host doesn't report the device's build source.
+	Build *hoapi.AndroidCIBuild `json:"build,omitempty"`
+}
+
+func writePsJSON(w io.Writer, hosts []*RemoteHost) error {
+	entries := []psEntry{}
+	for _, h := range hosts {
+		for _, c := range h.CVDs {
+			entries = append(entries, psEntry{RemoteCVDLocator: c.RemoteCVDLocator, Status: c.Status, Build: c.MainBuild})
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
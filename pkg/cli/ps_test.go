@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClassifyCVDStatus(t *testing.T) {
+	tests := map[string]string{
+		"Running":     runningCVDStatus,
+		"RUNNING":     runningCVDStatus,
+		" running ":   runningCVDStatus,
+		"Starting":    startingCVDStatus,
+		"Not Running": stoppedCVDStatus,
+		"NOT_RUNNING": stoppedCVDStatus,
+		"Failed":      failedCVDStatus,
+		"Mystery":     unknownCVDStatus,
+	}
+	for status, exp := range tests {
+		if got := classifyCVDStatus(status); got != exp {
+			t.Errorf("%q: expected %q, got %q", status, exp, got)
+		}
+	}
+}
+
+func TestRunningCVDs(t *testing.T) {
+	running := &RemoteCVD{RemoteCVDLocator: RemoteCVDLocator{Name: "cvd-1"}, Status: "Running"}
+	hosts := []*RemoteHost{
+		{Name: "foo", CVDs: []*RemoteCVD{running, {RemoteCVDLocator: RemoteCVDLocator{Name: "cvd-2"}, Status: "Stopped"}}},
+		{Name: "bar", CVDs: []*RemoteCVD{{RemoteCVDLocator: RemoteCVDLocator{Name: "cvd-1"}, Status: "Starting"}}},
+	}
+
+	got := runningCVDs(hosts)
+
+	exp := []*RemoteHost{{Name: "foo", CVDs: []*RemoteCVD{running}}}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+	if len(hosts[0].CVDs) != 2 {
+		t.Error("expected the listed hosts to be left untouched")
+	}
+}
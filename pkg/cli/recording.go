@@ -0,0 +1,224 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+)
+
+// The display is recorded in the container matching the stream's codec, without transcoding: IVF
+// for VP8 and raw Annex B for H.264. Tools like ffmpeg convert them to other formats, i.e:
+// `ffmpeg -i out.ivf out.mp4`.
+//
+// The host orchestrator doesn't offer server side recording, so the stream is captured by the
+// connection agent.
+
+// Key frames are requested periodically so a stream recovers from lost packets.
+const recordingKeyFrameInterval = 3 * time.Second
+
+// File extensions of the containers the display is recorded in, by codec.
+var recordingExts = map[string][]string{
+	webrtc.MimeTypeVP8:  {".ivf"},
+	webrtc.MimeTypeH264: {".h264", ".264"},
+}
+
+// Rejects output files with the extension of a container recordings aren't written in, like .mp4.
+// Files without extension are accepted.
+func validateRecordingPath(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return nil
+	}
+	for _, exts := range recordingExts {
+		for _, e := range exts {
+			if ext == e {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("can't record to %q: the display is written as IVF (.ivf) for VP8 streams or raw H.264 (.h264) otherwise,"+
+		" convert it afterwards, i.e: `ffmpeg -i out.ivf out.mp4`", path)
+}
+
+// Whether the file extension, if any, matches the container of the codec.
+func checkRecordingExt(path, mimeType string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return nil
+	}
+	for codec, exts := range recordingExts {
+		if !strings.EqualFold(codec, mimeType) {
+			continue
+		}
+		for _, e := range exts {
+			if ext == e {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s video is recorded in a %s file, not %s", mimeType, exts[0], ext)
+	}
+	return nil
+}
+
+type RecordingOpts struct {
+	// Path of the output file, recording is disabled if empty.
+	Path string
+	// Path of the replayable session file, see `SessionRecorder`. Disabled if empty, it can't be
+	// combined with Path.
+	SessionPath string
+	// Recording stops after this long, or when the connection closes if zero.
+	Duration time.Duration
+}
+
+func (o RecordingOpts) Enabled() bool {
+	return o.Path != "" || o.SessionPath != ""
+}
+
+type rtpWriter interface {
+	WriteRTP(*rtp.Packet) error
+	Close() error
+}
+
+func newRTPWriter(path, mimeType string) (rtpWriter, error) {
+	switch {
+	case strings.EqualFold(mimeType, webrtc.MimeTypeVP8):
+		return ivfwriter.New(path)
+	case strings.EqualFold(mimeType, webrtc.MimeTypeH264):
+		return h264writer.New(path)
+	default:
+		return nil, fmt.Errorf("recording %s video is not supported", mimeType)
+	}
+}
+
+// Records the first video track of the connection, the main display, to a file.
+type Recorder struct {
+	opts   RecordingOpts
+	logger *log.Logger
+
+	mtx     sync.Mutex
+	started bool
+	active  bool
+	stopCh  chan struct{}
+}
+
+func NewRecorder(opts RecordingOpts, logger *log.Logger) *Recorder {
+	return &Recorder{
+		opts:   opts,
+		logger: logger,
+		active: true,
+		stopCh: make(chan struct{}),
+	}
+}
+
+func (r *Recorder) OnVideoTrack(track *webrtc.TrackRemote, requestKeyFrame func() error) {
+	r.mtx.Lock()
+	if r.started || !r.active {
+		r.mtx.Unlock()
+		return
+	}
+	r.started = true
+	r.mtx.Unlock()
+	w, err := newRTPWriter(r.opts.Path, track.Codec().MimeType)
+	if err != nil {
+		r.logger.Printf("Failed to start recording: %v", err)
+		r.Stop()
+		return
+	}
+	if err := checkRecordingExt(r.opts.Path, track.Codec().MimeType); err != nil {
+		// The codec is only known once connected, the recording goes on rather than being lost.
+		r.logger.Printf("Warning: %v", err)
+	}
+	r.logger.Printf("Recording %s video to %q", track.Codec().MimeType, r.opts.Path)
+	if r.opts.Duration > 0 {
+		time.AfterFunc(r.opts.Duration, r.Stop)
+	}
+	go requestKeyFrames(requestKeyFrame, r.stopCh, r.logger)
+	go r.recordLoop(track, w)
+}
+
+// Whether the recording is in progress or waiting for the video track.
+func (r *Recorder) Active() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.active
+}
+
+// Stops the recording, the output file is complete once the recording loop exits.
+func (r *Recorder) Stop() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.active {
+		return
+	}
+	r.active = false
+	close(r.stopCh)
+}
+
+func (r *Recorder) stopped() bool {
+	select {
+	case <-r.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Recorder) recordLoop(track *webrtc.TrackRemote, w rtpWriter) {
+	defer func() {
+		if err := w.Close(); err != nil {
+			r.logger.Printf("Error closing recording: %v", err)
+		}
+		r.logger.Printf("Recording to %q finished", r.opts.Path)
+	}()
+	for !r.stopped() {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			r.logger.Printf("Recording stopped, failed reading video: %v", err)
+			r.Stop()
+			return
+		}
+		if err := w.WriteRTP(pkt); err != nil {
+			r.logger.Printf("Recording stopped, failed writing video: %v", err)
+			r.Stop()
+			return
+		}
+	}
+}
+
+// Requests key frames periodically until the channel is closed.
+func requestKeyFrames(requestKeyFrame func() error, stopCh <-chan struct{}, logger *log.Logger) {
+	ticker := time.NewTicker(recordingKeyFrameInterval)
+	defer ticker.Stop()
+	for {
+		if err := requestKeyFrame(); err != nil {
+			logger.Printf("Error requesting key frame: %v", err)
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
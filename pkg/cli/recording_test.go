@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestNewRTPWriterUnsupportedCodec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.ivf")
+
+	if _, err := newRTPWriter(path, webrtc.MimeTypeVP9); err == nil {
+		t.Error("expected error")
+	}
+	w, err := newRTPWriter(path, "video/vp8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+}
+
+func TestValidateRecordingPath(t *testing.T) {
+	for _, path := range []string{"out.ivf", "out.h264", "out.264", "out"} {
+		if err := validateRecordingPath(path); err != nil {
+			t.Errorf("%q: unexpected error: %v", path, err)
+		}
+	}
+	for _, path := range []string{"out.mp4", "out.webm"} {
+		if err := validateRecordingPath(path); err == nil {
+			t.Errorf("%q: expected error", path)
+		}
+	}
+}
+
+func TestCheckRecordingExt(t *testing.T) {
+	if err := checkRecordingExt("out.ivf", webrtc.MimeTypeVP8); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkRecordingExt("out", webrtc.MimeTypeH264); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkRecordingExt("out.ivf", webrtc.MimeTypeH264); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestRecorderStop(t *testing.T) {
+	r := NewRecorder(RecordingOpts{Path: "out.ivf"}, log.New(io.Discard, "", 0))
+
+	if !r.Active() {
+		t.Error("expected recorder waiting for the video track to be active")
+	}
+	r.Stop()
+	// Stopping twice is harmless.
+	r.Stop()
+
+	if r.Active() {
+		t.Error("expected recorder to be stopped")
+	}
+}
+
+func TestConnectFlagsRecordingArgs(t *testing.T) {
+	flags := ConnectFlags{
+		CVDRemoteFlags: &CVDRemoteFlags{},
+		recording:      RecordingOpts{Path: "/tmp/out.ivf", Duration: 5 * time.Minute},
+	}
+
+	got := flags.AsArgs()
+
+	exp := []string{"--record", "/tmp/out.ivf", "--record_duration", "5m0s"}
+	if diff := cmp.Diff(exp, got[len(got)-len(exp):]); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+}
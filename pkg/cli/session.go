@@ -0,0 +1,477 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// A recorded session holds the display frames and the input events sent to the device over ADB,
+// so the input can be replayed against another device. Session files start with the magic line
+// and a JSON encoded sessionHeader line, followed by the records in the order they happened:
+//
+//	kind (1 byte) | time since the start in microseconds (8 bytes) | length (4 bytes) | payload
+//
+// Integers are big endian. The display is stored as the RTP packets of its stream, as received.
+const sessionMagic = "CVDSESSION"
+
+const sessionVersion = 1
+
+const (
+	// The payload is the mime type of the video records that follow.
+	sessionVideoCodecRecord byte = 1
+	// The payload is a marshaled RTP packet of the main display.
+	sessionVideoRecord byte = 2
+	// The payload is a command run by the device's shell, i.e: "input tap 100 200".
+	sessionInputRecord byte = 3
+)
+
+// Bounds the records read from a session file, RTP packets are much smaller.
+const maxSessionRecordLen = 1 << 20
+
+const (
+	recordSessionFlag = "record_session"
+	speedFlag         = "speed"
+	extractVideoFlag  = "extract_video"
+)
+
+type ReplayFlags struct {
+	*CVDRemoteFlags
+	Host string
+	// Divides the time between the replayed input events.
+	Speed float64
+	// Writes the recorded display to this file instead of replaying the session.
+	ExtractVideo string
+}
+
+type sessionHeader struct {
+	Version        int       `json:"version"`
+	Host           string    `json:"host"`
+	WebRTCDeviceID string    `json:"webrtc_device_id"`
+	Started        time.Time `json:"started"`
+}
+
+type sessionRecord struct {
+	Kind    byte
+	Offset  time.Duration
+	Payload []byte
+}
+
+type sessionWriter struct {
+	w *bufio.Writer
+}
+
+func newSessionWriter(w io.Writer, header sessionHeader) (*sessionWriter, error) {
+	bw := bufio.NewWriter(w)
+	header.Version = sessionVersion
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding the session header: %w", err)
+	}
+	if _, err := fmt.Fprintf(bw, "%s\n%s\n", sessionMagic, encoded); err != nil {
+		return nil, fmt.Errorf("failed writing the session header: %w", err)
+	}
+	return &sessionWriter{w: bw}, nil
+}
+
+func (s *sessionWriter) Write(r sessionRecord) error {
+	var prefix [13]byte
+	prefix[0] = r.Kind
+	binary.BigEndian.PutUint64(prefix[1:9], uint64(r.Offset.Microseconds()))
+	binary.BigEndian.PutUint32(prefix[9:13], uint32(len(r.Payload)))
+	if _, err := s.w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := s.w.Write(r.Payload)
+	return err
+}
+
+func (s *sessionWriter) Flush() error {
+	return s.w.Flush()
+}
+
+type sessionReader struct {
+	r      *bufio.Reader
+	Header sessionHeader
+}
+
+func newSessionReader(r io.Reader) (*sessionReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.ReadString('\n')
+	if err != nil || magic != sessionMagic+"\n" {
+		return nil, fmt.Errorf("not a session file")
+	}
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the session header: %w", err)
+	}
+	result := &sessionReader{r: br}
+	if err := json.Unmarshal(line, &result.Header); err != nil {
+		return nil, fmt.Errorf("failed parsing the session header: %w", err)
+	}
+	if result.Header.Version != sessionVersion {
+		return nil, fmt.Errorf("unsupported session version %d, expected %d", result.Header.Version, sessionVersion)
+	}
+	return result, nil
+}
+
+// Returns io.EOF after the last record. Sessions cut short, i.e: by the agent crashing, end with
+// io.ErrUnexpectedEOF.
+func (s *sessionReader) Next() (*sessionRecord, error) {
+	var prefix [13]byte
+	if _, err := io.ReadFull(s.r, prefix[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix[9:13])
+	if n > maxSessionRecordLen {
+		return nil, fmt.Errorf("session record of %d bytes is too large", n)
+	}
+	result := &sessionRecord{
+		Kind:    prefix[0],
+		Offset:  time.Duration(binary.BigEndian.Uint64(prefix[1:9])) * time.Microsecond,
+		Payload: make([]byte, n),
+	}
+	if _, err := io.ReadFull(s.r, result.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+var errSessionStopped = errors.New("session recording stopped")
+
+// Records the first video track of the connection and the input commands the local ADB server
+// sends to the device.
+type SessionRecorder struct {
+	path   string
+	logger *log.Logger
+	start  time.Time
+
+	mtx          sync.Mutex
+	f            *os.File
+	w            *sessionWriter
+	videoStarted bool
+	active       bool
+	inputs       int
+	stopCh       chan struct{}
+}
+
+func NewSessionRecorder(opts RecordingOpts, cvd RemoteCVDLocator, logger *log.Logger) (*SessionRecorder, error) {
+	f, err := os.Create(opts.SessionPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating the session file: %w", err)
+	}
+	start := time.Now()
+	w, err := newSessionWriter(f, sessionHeader{Host: cvd.Host, WebRTCDeviceID: cvd.WebRTCDeviceID, Started: start})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &SessionRecorder{
+		path:   opts.SessionPath,
+		logger: logger,
+		start:  start,
+		f:      f,
+		w:      w,
+		active: true,
+		stopCh: make(chan struct{}),
+	}
+	if opts.Duration > 0 {
+		time.AfterFunc(opts.Duration, r.Stop)
+	}
+	logger.Printf("Recording session to %q", r.path)
+	return r, nil
+}
+
+func (r *SessionRecorder) Path() string {
+	return r.path
+}
+
+func (r *SessionRecorder) write(kind byte, payload []byte) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.active {
+		return errSessionStopped
+	}
+	return r.w.Write(sessionRecord{Kind: kind, Offset: time.Since(r.start), Payload: payload})
+}
+
+func (r *SessionRecorder) OnVideoTrack(track *webrtc.TrackRemote, requestKeyFrame func() error) {
+	r.mtx.Lock()
+	if r.videoStarted || !r.active {
+		r.mtx.Unlock()
+		return
+	}
+	r.videoStarted = true
+	r.mtx.Unlock()
+	if err := r.write(sessionVideoCodecRecord, []byte(track.Codec().MimeType)); err != nil {
+		return
+	}
+	go requestKeyFrames(requestKeyFrame, r.stopCh, r.logger)
+	go r.recordVideo(track)
+}
+
+func (r *SessionRecorder) recordVideo(track *webrtc.TrackRemote) {
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			r.logger.Printf("Session recording stopped, failed reading video: %v", err)
+			r.Stop()
+			return
+		}
+		data, err := pkt.Marshal()
+		if err != nil {
+			r.logger.Printf("Dropping video packet: %v", err)
+			continue
+		}
+		if err := r.write(sessionVideoRecord, data); err != nil {
+			if !errors.Is(err, errSessionStopped) {
+				r.logger.Printf("Session recording stopped, failed writing video: %v", err)
+				r.Stop()
+			}
+			return
+		}
+	}
+}
+
+// Returns the writer receiving the data the local ADB server sends through one of its
+// connections to the device.
+func (r *SessionRecorder) ADBTap() io.Writer {
+	return &adbInputParser{onInput: r.recordInput}
+}
+
+func (r *SessionRecorder) recordInput(cmd string) {
+	if err := r.write(sessionInputRecord, []byte(cmd)); err != nil {
+		if !errors.Is(err, errSessionStopped) {
+			r.logger.Printf("Failed recording input %q: %v", cmd, err)
+		}
+		return
+	}
+	r.mtx.Lock()
+	r.inputs++
+	r.mtx.Unlock()
+}
+
+// Whether the session is being recorded.
+func (r *SessionRecorder) Active() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.active
+}
+
+// Stops the recording and closes the session file.
+func (r *SessionRecorder) Stop() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.active {
+		return
+	}
+	r.active = false
+	close(r.stopCh)
+	if err := r.w.Flush(); err != nil {
+		r.logger.Printf("Error writing session: %v", err)
+	}
+	if err := r.f.Close(); err != nil {
+		r.logger.Printf("Error closing session: %v", err)
+	}
+	r.logger.Printf("Session recording to %q finished with %d input events", r.path, r.inputs)
+}
+
+// ADB packets start with a header of six little endian 32 bits words: the command, two arguments,
+// the payload's length and checksum, and the command's complement.
+const adbHeaderLen = 24
+
+const (
+	adbOpenCmd = 0x4e45504f // "OPEN"
+	adbTLSCmd  = 0x534c5453 // "STLS"
+)
+
+// Services are short strings, longer OPEN payloads are skipped.
+const maxADBServiceLen = 64 * 1024
+
+// Finds the input commands in the ADB packets the local ADB server sends to the device, i.e:
+// the ones `adb shell input tap 10 20` opens. The commands typed in interactive shells and the
+// input of other services aren't found. Parsing stops if the connection switches to TLS or the
+// data isn't ADB packets.
+type adbInputParser struct {
+	onInput func(cmd string)
+
+	header  []byte
+	service []byte
+	// Length of the service being read.
+	serviceLen int
+	reading    bool
+	// Bytes of payload left to skip.
+	skip     int
+	disabled bool
+}
+
+func (p *adbInputParser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 && !p.disabled {
+		switch {
+		case p.skip > 0:
+			l := min(p.skip, len(b))
+			p.skip -= l
+			b = b[l:]
+		case p.reading:
+			l := min(p.serviceLen-len(p.service), len(b))
+			p.service = append(p.service, b[:l]...)
+			b = b[l:]
+			if len(p.service) == p.serviceLen {
+				p.reading = false
+				if cmd, ok := inputCommandOfService(string(p.service)); ok {
+					p.onInput(cmd)
+				}
+				p.service = nil
+			}
+		default:
+			l := min(adbHeaderLen-len(p.header), len(b))
+			p.header = append(p.header, b[:l]...)
+			b = b[l:]
+			if len(p.header) == adbHeaderLen {
+				p.onHeader()
+			}
+		}
+	}
+	return n, nil
+}
+
+func (p *adbInputParser) onHeader() {
+	cmd := binary.LittleEndian.Uint32(p.header[0:4])
+	length := int(binary.LittleEndian.Uint32(p.header[12:16]))
+	magic := binary.LittleEndian.Uint32(p.header[20:24])
+	p.header = p.header[:0]
+	switch {
+	case magic != cmd^0xffffffff, cmd == adbTLSCmd:
+		p.disabled = true
+	case cmd == adbOpenCmd && length > 0 && length <= maxADBServiceLen:
+		p.reading = true
+		p.serviceLen = length
+	default:
+		p.skip = length
+	}
+}
+
+// Returns the input command run by a shell service, i.e: "input tap 10 20" of
+// "shell,v2,raw:input tap 10 20".
+func inputCommandOfService(service string) (string, bool) {
+	kind, cmd, ok := strings.Cut(strings.TrimRight(service, "\x00"), ":")
+	if !ok || (kind != "shell" && kind != "exec" && !strings.HasPrefix(kind, "shell,")) {
+		return "", false
+	}
+	cmd = strings.TrimSpace(cmd)
+	if !strings.HasPrefix(cmd, "input ") {
+		return "", false
+	}
+	return cmd, true
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Runs the recorded input commands in order, waiting between them the recorded time divided by
+// the speed. Returns how many commands were run.
+func replaySession(s *sessionReader, speed float64, run func(cmd string) error, sleep func(time.Duration)) (int, error) {
+	count := 0
+	var last time.Duration
+	for {
+		r, err := s.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed reading the session: %w", err)
+		}
+		if r.Kind != sessionInputRecord {
+			continue
+		}
+		if wait := time.Duration(float64(r.Offset-last) / speed); wait > 0 {
+			sleep(wait)
+		}
+		last = r.Offset
+		if err := run(string(r.Payload)); err != nil {
+			return count, fmt.Errorf("failed running %q: %w", r.Payload, err)
+		}
+		count++
+	}
+}
+
+// Writes the recorded display to the file, in the container matching its codec like `--record`
+// does. Returns how many packets were written.
+func extractSessionVideo(s *sessionReader, path string) (int, error) {
+	var w rtpWriter
+	count := 0
+	for {
+		r, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if w != nil {
+				w.Close()
+			}
+			return count, fmt.Errorf("failed reading the session: %w", err)
+		}
+		switch r.Kind {
+		case sessionVideoCodecRecord:
+			if w != nil {
+				continue
+			}
+			if err := checkRecordingExt(path, string(r.Payload)); err != nil {
+				return 0, err
+			}
+			if w, err = newRTPWriter(path, string(r.Payload)); err != nil {
+				return 0, err
+			}
+		case sessionVideoRecord:
+			if w == nil {
+				continue
+			}
+			pkt := &rtp.Packet{}
+			if err := pkt.Unmarshal(r.Payload); err != nil {
+				continue
+			}
+			if err := w.WriteRTP(pkt); err != nil {
+				w.Close()
+				return count, fmt.Errorf("failed writing the video: %w", err)
+			}
+			count++
+		}
+	}
+	if w == nil {
+		return 0, fmt.Errorf("the session has no display frames")
+	}
+	return count, w.Close()
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func adbPacket(cmd uint32, payload string) []byte {
+	header := make([]byte, adbHeaderLen)
+	binary.LittleEndian.PutUint32(header[0:4], cmd)
+	binary.LittleEndian.PutUint32(header[12:16], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[20:24], cmd^0xffffffff)
+	return append(header, payload...)
+}
+
+func TestADBInputParser(t *testing.T) {
+	const adbWriteCmd = 0x45545257 // "WRTE"
+	stream := [][]byte{
+		adbPacket(0x4e584e43, "host::\x00"), // "CNXN"
+		adbPacket(adbOpenCmd, "shell:getprop ro.product.name\x00"),
+		// Data written to a shell isn't parsed, even if it looks like a packet.
+		adbPacket(adbWriteCmd, string(adbPacket(adbOpenCmd, "shell:input tap 0 0\x00"))),
+		adbPacket(adbOpenCmd, "shell,v2,raw:input tap 10 20\x00"),
+		adbPacket(adbOpenCmd, "exec:input text hello\x00"),
+	}
+	data := bytes.Join(stream, nil)
+
+	for _, chunk := range []int{1, 7, len(data)} {
+		got := []string{}
+		p := &adbInputParser{onInput: func(cmd string) { got = append(got, cmd) }}
+		for b := data; len(b) > 0; {
+			n := min(chunk, len(b))
+			p.Write(b[:n])
+			b = b[n:]
+		}
+
+		want := []string{"input tap 10 20", "input text hello"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("chunks of %d bytes: input mismatch (-want +got):\n%s", chunk, diff)
+		}
+	}
+}
+
+func TestADBInputParserStopsOnTLS(t *testing.T) {
+	got := []string{}
+	p := &adbInputParser{onInput: func(cmd string) { got = append(got, cmd) }}
+
+	p.Write(adbPacket(adbTLSCmd, ""))
+	p.Write(adbPacket(adbOpenCmd, "shell:input tap 10 20\x00"))
+
+	if len(got) != 0 {
+		t.Errorf("expected no input after STLS, got: %v", got)
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	header := sessionHeader{Host: "foo", WebRTCDeviceID: "cvd-1", Started: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)}
+	w, err := newSessionWriter(buf, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []sessionRecord{
+		{Kind: sessionVideoCodecRecord, Offset: time.Millisecond, Payload: []byte("video/VP8")},
+		{Kind: sessionInputRecord, Offset: 2 * time.Second, Payload: []byte("input tap 10 20")},
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newSessionReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Version = sessionVersion
+	if diff := cmp.Diff(header, r.Header); diff != "" {
+		t.Errorf("header mismatch (-want +got):\n%s", diff)
+	}
+	for _, want := range records {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, *got); diff != "" {
+			t.Errorf("record mismatch (-want +got):\n%s", diff)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got: %v", err)
+	}
+}
+
+func TestReplaySession(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := newSessionWriter(buf, sessionHeader{})
+	w.Write(sessionRecord{Kind: sessionInputRecord, Offset: time.Second, Payload: []byte("input tap 1 1")})
+	w.Write(sessionRecord{Kind: sessionVideoRecord, Offset: 2 * time.Second, Payload: []byte{0}})
+	w.Write(sessionRecord{Kind: sessionInputRecord, Offset: 5 * time.Second, Payload: []byte("input tap 2 2")})
+	w.Flush()
+	r, err := newSessionReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := []string{}
+	waits := []time.Duration{}
+
+	n, err := replaySession(r, 2, func(cmd string) error {
+		ran = append(ran, cmd)
+		return nil
+	}, func(d time.Duration) { waits = append(waits, d) })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 replayed events, got %d", n)
+	}
+	if diff := cmp.Diff([]string{"input tap 1 1", "input tap 2 2"}, ran); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]time.Duration{500 * time.Millisecond, 2 * time.Second}, waits); diff != "" {
+		t.Errorf("waits mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewSessionReaderRejectsOtherFiles(t *testing.T) {
+	if _, err := newSessionReader(bytes.NewBufferString("not a session\n")); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+type SSHConfig struct {
+	// [OPTIONAL] User to log in as, defaults to the local user.
+	User string `json:"user,omitempty"`
+	// [OPTIONAL] Private key used to authenticate.
+	IdentityFile string `json:"identity_file,omitempty"`
+	// [OPTIONAL] Bastions to go through to reach the hosts, in order, as [user@]host[:port]. The
+	// user's SSH config and agent authenticate against them.
+	JumpHosts []string `json:"jump_hosts,omitempty"`
+}
+
+var jumpHostRe = regexp.MustCompile(`^([^@\s,]+@)?[^@\s,:]+(:[0-9]+)?$`)
+
+func validateJumpHosts(hosts []string) error {
+	for _, h := range hosts {
+		if !jumpHostRe.MatchString(h) {
+			return fmt.Errorf("invalid jump host %q, expected [user@]host[:port]", h)
+		}
+	}
+	return nil
+}
+
+// Returns the command line opening an SSH session to the host, running `cmd` instead of a shell if
+// not empty. GCP hosts are reached with gcloud, which handles the instance's keys and address.
+func sshCommandLine(host *apiv1.HostInstance, cfg *SSHConfig, cmd []string) ([]string, error) {
+	if cfg == nil {
+		cfg = &SSHConfig{}
+	}
+	target := func(addr string) string {
+		if cfg.User != "" {
+			return cfg.User + "@" + addr
+		}
+		return addr
+	}
+	switch {
+	case host.GCP != nil:
+		if host.GCP.Zone == "" {
+			return nil, fmt.Errorf("unknown zone of host %q", host.Name)
+		}
+		args := []string{"gcloud", "compute", "ssh", target(host.Name), "--zone=" + host.GCP.Zone}
+		if cfg.IdentityFile != "" {
+			args = append(args, "--ssh-key-file="+cfg.IdentityFile)
+		}
+		sshArgs := []string{}
+		if len(cfg.JumpHosts) > 0 {
+			// Bastions reach the instance by its internal address.
+			args = append(args, "--internal-ip")
+			sshArgs = append(sshArgs, "-J", strings.Join(cfg.JumpHosts, ","))
+		}
+		// Arguments after the separator are passed to ssh.
+		if len(sshArgs) > 0 || len(cmd) > 0 {
+			args = append(append(append(args, "--"), sshArgs...), cmd...)
+		}
+		return args, nil
+	case host.Docker != nil:
+		if host.Docker.IPAddress == "" {
+			return nil, fmt.Errorf("unknown address of host %q", host.Name)
+		}
+		args := []string{"ssh"}
+		if cfg.IdentityFile != "" {
+			args = append(args, "-i", cfg.IdentityFile)
+		}
+		if len(cfg.JumpHosts) > 0 {
+			args = append(args, "-J", strings.Join(cfg.JumpHosts, ","))
+		}
+		args = append(args, target(host.Docker.IPAddress))
+		if len(cmd) > 0 {
+			args = append(append(args, "--"), cmd...)
+		}
+		return args, nil
+	default:
+		return nil, fmt.Errorf("unable to resolve the address of host %q", host.Name)
+	}
+}
+
+// Returns the command line forwarding its standard IO to `addr` from the last of the jump hosts,
+// reached through the others.
+func jumpTunnelCommandLine(jumpHosts []string, addr string) []string {
+	last := len(jumpHosts) - 1
+	args := []string{"ssh", "-W", addr}
+	if last > 0 {
+		args = append(args, "-J", strings.Join(jumpHosts[:last], ","))
+	}
+	return append(args, jumpHosts[last])
+}
+
+// Dials through an SSH tunnel across the jump hosts. Implements proxy.Dialer.
+type sshJumpDialer struct {
+	JumpHosts []string
+}
+
+func (d *sshJumpDialer) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("unsupported network %q through jump hosts", network)
+	}
+	args := jumpTunnelCommandLine(d.JumpHosts, addr)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ssh tunnel: %w", err)
+	}
+	return &sshTunnelConn{Reader: out, in: in, cmd: cmd, addr: addr}, nil
+}
+
+// The standard IO of the ssh process forwarding the connection.
+type sshTunnelConn struct {
+	io.Reader
+	in   io.WriteCloser
+	cmd  *exec.Cmd
+	addr string
+}
+
+func (c *sshTunnelConn) Write(b []byte) (int, error) {
+	return c.in.Write(b)
+}
+
+func (c *sshTunnelConn) Close() error {
+	c.in.Close()
+	if err := c.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	c.cmd.Wait()
+	return nil
+}
+
+type sshTunnelAddr string
+
+func (a sshTunnelAddr) Network() string { return "ssh" }
+func (a sshTunnelAddr) String() string  { return string(a) }
+
+func (c *sshTunnelConn) LocalAddr() net.Addr  { return sshTunnelAddr("localhost") }
+func (c *sshTunnelConn) RemoteAddr() net.Addr { return sshTunnelAddr(c.addr) }
+
+// Deadlines aren't supported by the process pipes.
+func (c *sshTunnelConn) SetDeadline(time.Time) error      { return nil }
+func (c *sshTunnelConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *sshTunnelConn) SetWriteDeadline(time.Time) error { return nil }
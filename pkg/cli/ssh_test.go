@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSSHCommandLine(t *testing.T) {
+	cfg := &SSHConfig{User: "vsoc-01", IdentityFile: "/path/to/key"}
+	tests := []struct {
+		host *apiv1.HostInstance
+		cmd  []string
+		exp  []string
+	}{
+		{
+			host: &apiv1.HostInstance{Name: "foo", GCP: &apiv1.GCPInstance{Zone: "us-central1-a"}},
+			cmd:  []string{"uptime"},
+			exp: []string{"gcloud", "compute", "ssh", "vsoc-01@foo", "--zone=us-central1-a",
+				"--ssh-key-file=/path/to/key", "--", "uptime"},
+		},
+		{
+			host: &apiv1.HostInstance{Name: "bar", Docker: &apiv1.DockerInstance{IPAddress: "172.17.0.2"}},
+			exp:  []string{"ssh", "-i", "/path/to/key", "vsoc-01@172.17.0.2"},
+		},
+	}
+	for _, tc := range tests {
+		got, err := sshCommandLine(tc.host, cfg, tc.cmd)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tc.exp, got); diff != "" {
+			t.Errorf("command line mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestSSHCommandLineUnknownAddress(t *testing.T) {
+	if _, err := sshCommandLine(&apiv1.HostInstance{Name: "foo"}, nil, nil); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSSHCommandLineWithJumpHosts(t *testing.T) {
+	cfg := &SSHConfig{JumpHosts: []string{"alice@bastion", "inner:2222"}}
+	tests := []struct {
+		host *apiv1.HostInstance
+		cmd  []string
+		exp  []string
+	}{
+		{
+			host: &apiv1.HostInstance{Name: "foo", GCP: &apiv1.GCPInstance{Zone: "us-central1-a"}},
+			cmd:  []string{"uptime"},
+			exp: []string{"gcloud", "compute", "ssh", "foo", "--zone=us-central1-a", "--internal-ip",
+				"--", "-J", "alice@bastion,inner:2222", "uptime"},
+		},
+		{
+			host: &apiv1.HostInstance{Name: "bar", Docker: &apiv1.DockerInstance{IPAddress: "172.17.0.2"}},
+			exp:  []string{"ssh", "-J", "alice@bastion,inner:2222", "172.17.0.2"},
+		},
+	}
+	for _, tc := range tests {
+		got, err := sshCommandLine(tc.host, cfg, tc.cmd)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tc.exp, got); diff != "" {
+			t.Errorf("command line mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestJumpTunnelCommandLine(t *testing.T) {
+	got := jumpTunnelCommandLine([]string{"alice@bastion", "inner:2222", "last"}, "172.17.0.2:6520")
+
+	exp := []string{"ssh", "-W", "172.17.0.2:6520", "-J", "alice@bastion,inner:2222", "last"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("command line mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateJumpHosts(t *testing.T) {
+	if err := validateJumpHosts([]string{"bastion", "alice@bastion.example.com:2222"}); err != nil {
+		t.Errorf("expected valid jump hosts, got error: %v", err)
+	}
+	for _, h := range []string{"", "two words", "a@b@c", "bastion:port"} {
+		if err := validateJumpHosts([]string{h}); err == nil {
+			t.Errorf("expected error for jump host %q", h)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+type StatsFlags struct {
+	*CVDRemoteFlags
+	Format string
+}
+
+// Order the status classes are printed in.
+var cvdStatusClassOrder = []string{
+	runningCVDStatus, startingCVDStatus, stoppedCVDStatus, failedCVDStatus, unknownCVDStatus,
+}
+
+type hostStats struct {
+	Name string `json:"name"`
+	CVDs int    `json:"cvds"`
+	// Zero if the host doesn't report its capacity.
+	MaxInstances int `json:"max_instances,omitempty"`
+	// Ratio of the host's capacity in use, omitted if the capacity is unknown.
+	Utilization *float64 `json:"utilization,omitempty"`
+}
+
+type fleetStats struct {
+	Hosts int `json:"hosts"`
+	CVDs  int `json:"cvds"`
+	// Number of devices by status class, see `classifyCVDStatus`.
+	ByStatus map[string]int `json:"by_status"`
+	// Instances in use and available in the hosts reporting their capacity.
+	UsedInstances int `json:"used_instances"`
+	MaxInstances  int `json:"max_instances"`
+	// Omitted if no host reports its capacity.
+	Utilization *float64     `json:"utilization,omitempty"`
+	PerHost     []*hostStats `json:"per_host"`
+}
+
+// Summarizes the hosts and their devices. The utilization only accounts for the hosts reporting
+// their capacity.
+func computeFleetStats(hosts []*RemoteHost) *fleetStats {
+	result := &fleetStats{Hosts: len(hosts), ByStatus: map[string]int{}, PerHost: []*hostStats{}}
+	for _, class := range cvdStatusClassOrder {
+		result.ByStatus[class] = 0
+	}
+	for _, h := range hosts {
+		hs := &hostStats{Name: h.Name, CVDs: len(h.CVDs), MaxInstances: h.MaxInstances}
+		if h.MaxInstances > 0 {
+			u := float64(hs.CVDs) / float64(h.MaxInstances)
+			hs.Utilization = &u
+			result.UsedInstances += hs.CVDs
+			result.MaxInstances += h.MaxInstances
+		}
+		result.CVDs += hs.CVDs
+		for _, c := range h.CVDs {
+			result.ByStatus[classifyCVDStatus(c.Status)]++
+		}
+		result.PerHost = append(result.PerHost, hs)
+	}
+	if result.MaxInstances > 0 {
+		u := float64(result.UsedInstances) / float64(result.MaxInstances)
+		result.Utilization = &u
+	}
+	return result
+}
+
+func writeFleetStats(w io.Writer, s *fleetStats) {
+	fmt.Fprintf(w, "Hosts: %d\n", s.Hosts)
+	fmt.Fprintf(w, "CVDs:  %d", s.CVDs)
+	sep := " ("
+	for _, class := range cvdStatusClassOrder {
+		if n := s.ByStatus[class]; n > 0 {
+			fmt.Fprintf(w, "%s%d %s", sep, n, class)
+			sep = ", "
+		}
+	}
+	if sep != " (" {
+		fmt.Fprint(w, ")")
+	}
+	fmt.Fprintln(w)
+	if s.Utilization != nil {
+		fmt.Fprintf(w, "Utilization: %d of %d instances (%.0f%%)\n", s.UsedInstances, s.MaxInstances, *s.Utilization*100)
+	} else {
+		fmt.Fprintln(w, "Utilization: unknown")
+	}
+	if len(s.PerHost) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tCVDS\tUTILIZATION")
+	for _, h := range s.PerHost {
+		utilization := "unknown"
+		if h.Utilization != nil {
+			utilization = fmt.Sprintf("%d/%d (%.0f%%)", h.CVDs, h.MaxInstances, *h.Utilization*100)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", h.Name, h.CVDs, utilization)
+	}
+	tw.Flush()
+}
+
+func writeFleetStatsJSON(w io.Writer, s *fleetStats) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestComputeFleetStats(t *testing.T) {
+	hosts := []*RemoteHost{
+		{
+			Name:         "foo",
+			MaxInstances: 4,
+			CVDs:         []*RemoteCVD{{Status: "Running"}, {Status: "failed"}},
+		},
+		{
+			Name: "bar",
+			CVDs: []*RemoteCVD{{Status: "running"}},
+		},
+	}
+
+	s := computeFleetStats(hosts)
+
+	if s.Hosts != 2 || s.CVDs != 3 {
+		t.Errorf("expected 2 hosts and 3 cvds, got: %d and %d", s.Hosts, s.CVDs)
+	}
+	if s.ByStatus[runningCVDStatus] != 2 || s.ByStatus[failedCVDStatus] != 1 {
+		t.Errorf("unexpected status counts: %v", s.ByStatus)
+	}
+	// The host with an unknown capacity doesn't count towards the utilization.
+	if s.UsedInstances != 2 || s.MaxInstances != 4 || s.Utilization == nil || *s.Utilization != 0.5 {
+		t.Errorf("unexpected utilization: %d of %d", s.UsedInstances, s.MaxInstances)
+	}
+	if s.PerHost[1].Utilization != nil {
+		t.Errorf("expected unknown utilization for host without capacity, got: %f", *s.PerHost[1].Utilization)
+	}
+}
+
+func TestWriteFleetStats(t *testing.T) {
+	hosts := []*RemoteHost{
+		{Name: "foo", MaxInstances: 4, CVDs: []*RemoteCVD{{Status: "running"}, {Status: "stopped"}}},
+	}
+	sb := &strings.Builder{}
+
+	writeFleetStats(sb, computeFleetStats(hosts))
+
+	for _, exp := range []string{"Hosts: 1\n", "CVDs:  2 (1 running, 1 stopped)\n", "2 of 4 instances (50%)", "foo   2     2/4 (50%)"} {
+		if !strings.Contains(sb.String(), exp) {
+			t.Errorf("expected %q in output:\n%s", exp, sb.String())
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+)
+
+type ListTargetsFlags struct {
+	*CVDRemoteFlags
+	Branch                    string
+	BuildAPIURL               string
+	BuildAPICredentialsSource string
+	Format                    string
+	// Ignores the cached targets.
+	Refresh bool
+}
+
+// The targets of a branch rarely change, listing them again within this time reuses the last result.
+const targetCacheTTL = time.Hour
+
+type targetCacheEntry struct {
+	BuildAPIURL string    `json:"build_api_url"`
+	Branch      string    `json:"branch"`
+	Targets     []string  `json:"targets"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// Keeps the targets of the branches listed lately.
+type targetCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+func (c *targetCache) entryPath(buildAPIURL, branch string) string {
+	sum := sha256.Sum256([]byte(buildAPIURL + "/" + branch))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Returns nil if the branch's targets weren't cached or the entry expired.
+func (c *targetCache) Get(buildAPIURL, branch string, now time.Time) (*targetCacheEntry, error) {
+	b, err := os.ReadFile(c.entryPath(buildAPIURL, branch))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading target cache: %w", err)
+	}
+	entry := &targetCacheEntry{}
+	if err := json.Unmarshal(b, entry); err != nil {
+		return nil, fmt.Errorf("invalid target cache entry: %w", err)
+	}
+	if entry.BuildAPIURL != buildAPIURL || entry.Branch != branch || now.Sub(entry.FetchedAt) > c.TTL {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+func (c *targetCache) Set(entry *targetCacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return fmt.Errorf("failed creating target cache directory: %w", err)
+	}
+	if err := os.WriteFile(c.entryPath(entry.BuildAPIURL, entry.Branch), b, 0600); err != nil {
+		return fmt.Errorf("failed writing target cache: %w", err)
+	}
+	return nil
+}
+
+// Returns the sorted targets of the branch, from the cache unless `refresh` is set. Failing to
+// use the cache isn't fatal, the targets are fetched from the build server then.
+func listBuildTargets(api client.BuildAPI, buildAPIURL string, cache *targetCache, branch string, refresh bool, now time.Time) ([]string, error) {
+	if !refresh {
+		if entry, err := cache.Get(buildAPIURL, branch, now); err == nil && entry != nil {
+			return entry.Targets, nil
+		}
+	}
+	targets, err := api.ListTargets(branch)
+	if errors.Is(err, client.ErrBranchNotFound) {
+		return nil, fmt.Errorf("branch %q not found in the build server", branch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed listing the targets of branch %q: %w", branch, err)
+	}
+	sort.Strings(targets)
+	// Only a cache, the next listing fetches them again if this fails.
+	cache.Set(&targetCacheEntry{BuildAPIURL: buildAPIURL, Branch: branch, Targets: targets, FetchedAt: now})
+	return targets, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListBuildTargetsCached(t *testing.T) {
+	api := &fakeBuildAPI{targets: map[string][]string{"aosp-main": {"foo-userdebug", "bar-userdebug"}}}
+	cache := &targetCache{Dir: t.TempDir(), TTL: time.Hour}
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	const url = "https://build.example.com"
+
+	got, err := listBuildTargets(api, url, cache, "aosp-main", false, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"bar-userdebug", "foo-userdebug"}, got); diff != "" {
+		t.Errorf("targets mismatch (-want +got):\n%s", diff)
+	}
+	for _, tc := range []struct {
+		at       time.Time
+		refresh  bool
+		listings int
+	}{
+		{now.Add(30 * time.Minute), false, 1},
+		{now.Add(30 * time.Minute), true, 2},
+		// Expired.
+		{now.Add(3 * time.Hour), false, 3},
+	} {
+		got, err := listBuildTargets(api, url, cache, "aosp-main", tc.refresh, tc.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"bar-userdebug", "foo-userdebug"}, got); diff != "" {
+			t.Errorf("targets mismatch (-want +got):\n%s", diff)
+		}
+		if api.targetListings != tc.listings {
+			t.Errorf("expected %d listings at %s with refresh %t, got: %d", tc.listings, tc.at, tc.refresh, api.targetListings)
+		}
+	}
+	if _, err := listBuildTargets(api, url, cache, "unknown", false, now); err == nil {
+		t.Error("expected error for an unknown branch")
+	}
+}
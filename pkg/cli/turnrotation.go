@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// Time before the TURN credentials expire the connection is replaced, a tenth of their remaining
+	// lifetime if shorter.
+	turnRotationMargin = 5 * time.Minute
+	// Rotations kept for the connection's status, older ones are dropped.
+	maxTURNRotations = 10
+)
+
+type TURNRotation struct {
+	Time time.Time `json:"time"`
+	// When the credentials of the replaced connection were going to expire.
+	ExpireTime time.Time `json:"expire_time"`
+	// Empty if the connection was replaced successfully.
+	Error string `json:"error,omitempty"`
+}
+
+// Returns how long to wait before replacing a connection whose TURN credentials expire at `expire`.
+func turnRotationDelay(expire, now time.Time) time.Duration {
+	remaining := expire.Sub(now)
+	margin := turnRotationMargin
+	if remaining/10 < margin {
+		margin = remaining / 10
+	}
+	if remaining-margin < 0 {
+		return 0
+	}
+	return remaining - margin
+}
+
+// Replaces connections before the credentials of their TURN servers expire. The pion version in
+// use doesn't apply new ICE servers on ICE restarts, so rotating the credentials takes a new
+// connection, which obtains fresh credentials from the service.
+type turnRotator struct {
+	// Replaces the connection, scheduling the rotation of the new one.
+	rotate func() error
+	// Called when replacing the connection fails, no rotation is scheduled afterwards.
+	onFailure func(error)
+	logger    *log.Logger
+
+	mtx sync.Mutex
+	// Expiry of the current connection's credentials, zero if they don't expire.
+	expire    time.Time
+	timer     *time.Timer
+	rotations []TURNRotation
+	stopped   bool
+}
+
+func newTURNRotator(rotate func() error, onFailure func(error), logger *log.Logger) *turnRotator {
+	return &turnRotator{rotate: rotate, onFailure: onFailure, logger: logger}
+}
+
+// Schedules the rotation of a new connection, replacing the one scheduled for the previous
+// connection. A zero `expire` schedules nothing.
+func (r *turnRotator) Schedule(expire time.Time) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.stopped {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.expire = expire
+	if expire.IsZero() {
+		return
+	}
+	delay := turnRotationDelay(expire, time.Now())
+	r.logger.Printf("TURN credentials expire at %s, replacing the connection in %s", expire.Format(time.RFC3339), delay)
+	r.timer = time.AfterFunc(delay, func() { r.fire(expire) })
+}
+
+func (r *turnRotator) fire(expire time.Time) {
+	r.mtx.Lock()
+	// Stale if the connection was replaced meanwhile, i.e: by a heartbeat.
+	stale := r.stopped || !r.expire.Equal(expire)
+	r.mtx.Unlock()
+	if stale {
+		return
+	}
+	err := r.rotate()
+	rotation := TURNRotation{Time: time.Now(), ExpireTime: expire}
+	if err != nil {
+		rotation.Error = err.Error()
+	}
+	r.mtx.Lock()
+	r.rotations = append(r.rotations, rotation)
+	if len(r.rotations) > maxTURNRotations {
+		r.rotations = r.rotations[len(r.rotations)-maxTURNRotations:]
+	}
+	r.mtx.Unlock()
+	if err != nil {
+		r.logger.Printf("Replacing the connection for its TURN credentials to expire failed: %v", err)
+		r.onFailure(err)
+		return
+	}
+	r.logger.Printf("Replaced the connection with fresh TURN credentials")
+}
+
+func (r *turnRotator) Stop() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.stopped = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+}
+
+// Expiry of the current connection's credentials, zero if they don't expire.
+func (r *turnRotator) ExpireTime() time.Time {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.expire
+}
+
+// The latest rotations, oldest first.
+func (r *turnRotator) Rotations() []TURNRotation {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]TURNRotation{}, r.rotations...)
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestTURNRotationDelay(t *testing.T) {
+	now := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		expire time.Time
+		want   time.Duration
+	}{
+		{name: "long lived", expire: now.Add(24 * time.Hour), want: 24*time.Hour - turnRotationMargin},
+		{name: "short lived", expire: now.Add(10 * time.Minute), want: 9 * time.Minute},
+		{name: "expired", expire: now.Add(-time.Minute), want: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := turnRotationDelay(tc.expire, now); got != tc.want {
+				t.Errorf("expected %s, got: %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTURNRotatorReplacesConnectionBeforeExpiry(t *testing.T) {
+	rotated := make(chan struct{}, 2)
+	var r *turnRotator
+	r = newTURNRotator(
+		func() error {
+			rotated <- struct{}{}
+			// The new connection's credentials don't expire.
+			r.Schedule(time.Time{})
+			return nil
+		},
+		func(err error) { t.Errorf("unexpected failure: %v", err) },
+		log.New(io.Discard, "", 0))
+	defer r.Stop()
+	expire := time.Now().Add(50 * time.Millisecond)
+
+	r.Schedule(expire)
+
+	select {
+	case <-rotated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for rotation")
+	}
+	// Give the rotator time to record the rotation.
+	time.Sleep(10 * time.Millisecond)
+	rotations := r.Rotations()
+	if len(rotations) != 1 || !rotations[0].ExpireTime.Equal(expire) || rotations[0].Error != "" {
+		t.Errorf("unexpected rotations: %+v", rotations)
+	}
+	if !r.ExpireTime().IsZero() {
+		t.Errorf("expected the new connection's credentials not to expire, got: %v", r.ExpireTime())
+	}
+}
+
+func TestTURNRotatorReportsFailure(t *testing.T) {
+	rotateErr := errors.New("unreachable")
+	failed := make(chan error, 1)
+	r := newTURNRotator(
+		func() error { return rotateErr },
+		func(err error) { failed <- err },
+		log.New(io.Discard, "", 0))
+	defer r.Stop()
+
+	r.Schedule(time.Now())
+
+	select {
+	case err := <-failed:
+		if err != rotateErr {
+			t.Errorf("expected %v, got: %v", rotateErr, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for failure")
+	}
+	if rotations := r.Rotations(); len(rotations) != 1 || rotations[0].Error != rotateErr.Error() {
+		t.Errorf("unexpected rotations: %+v", rotations)
+	}
+}
+
+func TestTURNRotatorSkipsReplacedConnections(t *testing.T) {
+	r := newTURNRotator(
+		func() error {
+			t.Error("unexpected rotation")
+			return nil
+		},
+		func(err error) {},
+		log.New(io.Discard, "", 0))
+	defer r.Stop()
+
+	r.Schedule(time.Now().Add(20 * time.Millisecond))
+	// Replaced, i.e: by a heartbeat, with a connection lasting longer.
+	r.Schedule(time.Now().Add(time.Hour))
+
+	time.Sleep(50 * time.Millisecond)
+}
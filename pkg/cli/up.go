@@ -0,0 +1,76 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+type UpFlags struct {
+	*CVDRemoteFlags
+	Host                      string
+	BuildAPICredentialsSource string
+	// Leaves the devices around when connecting to them fails, for debugging.
+	KeepOnFailure bool
+	// Returns once the devices booted, without connecting to them.
+	Detach bool
+}
+
+const (
+	keepOnFailureFlag = "keep_on_failure"
+	detachFlag        = "detach"
+)
+
+// Creates the devices and, unless `detach` is set, connects to them. The created devices are
+// deleted if connecting to any of them fails, unless `keepOnFailure` is set.
+func upCVDs(service client.Service, opts CreateCVDOpts, connect func(*RemoteCVD) error, flags *UpFlags,
+	controlDir string, statePrinter *statePrinter) ([]*RemoteCVD, error) {
+	cvds, err := createCVD(service, opts, statePrinter)
+	if err != nil {
+		return nil, err
+	}
+	if flags.Detach {
+		return cvds, nil
+	}
+	for _, cvd := range cvds {
+		if err = connect(cvd); err != nil {
+			err = fmt.Errorf("failed to connect to device %q: %w", cvd.WebRTCDeviceID, err)
+			break
+		}
+	}
+	if err == nil || flags.KeepOnFailure {
+		return cvds, err
+	}
+	var merr error = err
+	for _, cvd := range cvds {
+		if cvd.ConnStatus != nil {
+			if err := DisconnectCVD(controlDir, cvd.RemoteCVDLocator, *cvd.ConnStatus); err != nil {
+				merr = multierror.Append(merr, err)
+			}
+		}
+		state := fmt.Sprintf("Deleting %s/%s", cvd.Host, cvd.Name)
+		statePrinter.Print(state)
+		err := service.HostService(cvd.Host).DeleteCVD(cvd.ID)
+		statePrinter.PrintDone(state, err)
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to delete device %q: %w", cvd.WebRTCDeviceID, err))
+		}
+	}
+	return nil, merr
+}
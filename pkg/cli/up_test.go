@@ -0,0 +1,96 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+type upHostService struct {
+	fakeHostService
+	deleted []string
+}
+
+func (s *upHostService) DeleteCVD(id string) error {
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+type upService struct {
+	fakeService
+	hostSrv *upHostService
+}
+
+func (s *upService) HostService(host string) client.HostOrchestratorService {
+	return s.hostSrv
+}
+
+func upTestOpts() CreateCVDOpts {
+	return CreateCVDOpts{
+		Host:                      "foo",
+		MainBuild:                 hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-userdebug"},
+		BuildAPICredentialsSource: NoneCredentialsSource,
+	}
+}
+
+func TestUpCVDsDeletesDevicesFailingToConnect(t *testing.T) {
+	service := &upService{hostSrv: &upHostService{}}
+	connect := func(*RemoteCVD) error { return errors.New("connection refused") }
+
+	_, err := upCVDs(service, upTestOpts(), connect, &UpFlags{}, t.TempDir(), newStatePrinter(io.Discard, false))
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if diff := cmp.Diff([]string{"/cvd-1"}, service.hostSrv.deleted); diff != "" {
+		t.Errorf("deleted devices mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUpCVDsKeepOnFailure(t *testing.T) {
+	service := &upService{hostSrv: &upHostService{}}
+	connect := func(*RemoteCVD) error { return errors.New("connection refused") }
+
+	cvds, err := upCVDs(service, upTestOpts(), connect, &UpFlags{KeepOnFailure: true}, t.TempDir(), newStatePrinter(io.Discard, false))
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(cvds) != 1 || len(service.hostSrv.deleted) != 0 {
+		t.Errorf("expected the device to be kept, got: %v, deleted: %v", cvds, service.hostSrv.deleted)
+	}
+}
+
+func TestUpCVDsDetach(t *testing.T) {
+	service := &upService{hostSrv: &upHostService{}}
+	connected := false
+	connect := func(*RemoteCVD) error { connected = true; return nil }
+
+	cvds, err := upCVDs(service, upTestOpts(), connect, &UpFlags{Detach: true}, t.TempDir(), newStatePrinter(io.Discard, false))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cvds) != 1 || connected {
+		t.Errorf("expected the device to be created without connecting, got: %v, connected: %t", cvds, connected)
+	}
+}
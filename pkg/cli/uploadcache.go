@@ -0,0 +1,132 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+// The files uploaded to a host by the last successful create from local files.
+type uploadCacheEntry struct {
+	UploadDir string `json:"upload_dir"`
+	// SHA-256 of each uploaded file keyed by its base name.
+	Files map[string]string `json:"files"`
+}
+
+// Keeps track of the last successful upload to each host, allowing following creates to upload
+// only the files that changed since then.
+type uploadCache struct {
+	Dir string
+}
+
+func (c *uploadCache) entryPath(serviceRootEndpoint, host string) string {
+	sum := sha256.Sum256([]byte(serviceRootEndpoint + "/" + host))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Returns nil if there is no entry for the host.
+func (c *uploadCache) Get(serviceRootEndpoint, host string) (*uploadCacheEntry, error) {
+	b, err := os.ReadFile(c.entryPath(serviceRootEndpoint, host))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading upload cache: %w", err)
+	}
+	entry := &uploadCacheEntry{}
+	if err := json.Unmarshal(b, entry); err != nil {
+		return nil, fmt.Errorf("invalid upload cache entry: %w", err)
+	}
+	if entry.Files == nil {
+		entry.Files = make(map[string]string)
+	}
+	return entry, nil
+}
+
+func (c *uploadCache) Set(serviceRootEndpoint, host string, entry *uploadCacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return fmt.Errorf("failed creating upload cache directory: %w", err)
+	}
+	if err := os.WriteFile(c.entryPath(serviceRootEndpoint, host), b, 0600); err != nil {
+		return fmt.Errorf("failed writing upload cache: %w", err)
+	}
+	return nil
+}
+
+func hashFiles(names []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed hashing %q: %w", name, err)
+		}
+		result[filepath.Base(name)] = hex.EncodeToString(h.Sum(nil))
+	}
+	return result, nil
+}
+
+// Returns the files whose content differs from the cached upload.
+func changedFiles(names []string, hashes map[string]string, entry *uploadCacheEntry) []string {
+	result := []string{}
+	for _, name := range names {
+		base := filepath.Base(name)
+		if prev, ok := entry.Files[base]; !ok || prev != hashes[base] {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// Whether any of the devices uses the upload directory, devices not reporting their build source
+// may use it.
+func uploadDirInUse(cvds []*hoapi.CVD, dir string) bool {
+	if len(cvdsWithoutBuildSource(cvds)) > 0 {
+		return true
+	}
+	for _, cvd := range cvds {
+		if ubs := cvd.BuildSource.UserBuildSource; ubs != nil && ubs.ArtifactsDir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the error indicates the uploaded artifacts are gone, likely garbage collected by the host.
+func isUploadDirNotFound(err error) bool {
+	var apiErr *client.ApiCallError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUploadCacheChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	foo := filepath.Join(dir, "foo.img")
+	bar := filepath.Join(dir, "bar.img")
+	for _, name := range []string{foo, bar} {
+		if err := os.WriteFile(name, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := []string{foo, bar}
+	hashes, err := hashFiles(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &uploadCache{Dir: filepath.Join(dir, "cache")}
+	if err := cache.Set("http://foo.com/v1", "host", &uploadCacheEntry{UploadDir: "up1", Files: hashes}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bar, []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := cache.Get("http://foo.com/v1", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newHashes, err := hashFiles(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := changedFiles(names, newHashes, entry)
+
+	if diff := cmp.Diff([]string{bar}, got); diff != "" {
+		t.Errorf("changed files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUploadCacheGetMissingEntry(t *testing.T) {
+	cache := &uploadCache{Dir: t.TempDir()}
+
+	entry, err := cache.Get("http://foo.com/v1", "host")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Errorf("expected nil entry, got: %+v", entry)
+	}
+}
+
+func TestUploadCacheGetNullFiles(t *testing.T) {
+	cache := &uploadCache{Dir: t.TempDir()}
+	if err := os.WriteFile(cache.entryPath("http://foo.com/v1", "host"), []byte(`{"upload_dir":"up1","files":null}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := cache.Get("http://foo.com/v1", "host")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recording the files of the next upload must not panic.
+	entry.Files["foo.img"] = "abc"
+}
+
+func TestUploadDirInUse(t *testing.T) {
+	userBuild := func(dir string) *hoapi.BuildSource {
+		return &hoapi.BuildSource{UserBuildSource: &hoapi.UserBuildSource{ArtifactsDir: dir}}
+	}
+	ciBuild := &hoapi.BuildSource{AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{}}
+	tests := []struct {
+		name string
+		cvds []*hoapi.CVD
+		exp  bool
+	}{
+		{name: "no devices", exp: false},
+		{name: "other dirs", cvds: []*hoapi.CVD{{BuildSource: userBuild("up2")}, {BuildSource: ciBuild}}, exp: false},
+		{name: "used", cvds: []*hoapi.CVD{{BuildSource: userBuild("up1")}}, exp: true},
+		{name: "unknown build source", cvds: []*hoapi.CVD{{BuildSource: userBuild("up2")}, {}}, exp: true},
+	}
+	for _, tc := range tests {
+		if got := uploadDirInUse(tc.cvds, "up1"); got != tc.exp {
+			t.Errorf("%s: expected %t, got: %t", tc.name, tc.exp, got)
+		}
+	}
+}
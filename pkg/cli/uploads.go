@@ -0,0 +1,252 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/spf13/cobra"
+)
+
+type ListUploadsFlags struct {
+	*CVDRemoteFlags
+	Format string
+}
+
+type DeleteUploadFlags struct {
+	*CVDRemoteFlags
+	Host string
+}
+
+// Upload directory of the user as tracked by the service, along with what its host reports of it.
+type UserUploadInfo struct {
+	Host       string    `json:"host"`
+	Name       string    `json:"name"`
+	CreateTime time.Time `json:"create_time"`
+	// Zero if the host doesn't report it.
+	SizeBytes int64 `json:"size_bytes,omitempty"`
+	// Devices of the host created from the directory's artifacts.
+	CVDs []string `json:"cvds"`
+	// The host no longer has the directory, likely garbage collected or the host was deleted.
+	Missing bool `json:"missing,omitempty"`
+}
+
+type UserUploadsList struct {
+	Uploads    []*UserUploadInfo `json:"uploads"`
+	TotalBytes int64             `json:"total_bytes"`
+}
+
+// What a host reports of its upload directories and devices.
+type hostUploadsState struct {
+	Uploads []*client.UploadDir
+	CVDs    []*hoapi.CVD
+}
+
+// Joins the uploads tracked by the service with the hosts' states. Uploads of hosts missing from
+// `hosts`, which failed to report their state, are of unknown size.
+func userUploadInfos(uploads []*apiv1.UserUpload, hosts map[string]*hostUploadsState) *UserUploadsList {
+	result := &UserUploadsList{Uploads: []*UserUploadInfo{}}
+	for _, u := range uploads {
+		info := &UserUploadInfo{Host: u.Host, Name: u.Name, CreateTime: u.CreateTime, CVDs: []string{}}
+		state, ok := hosts[u.Host]
+		if !ok {
+			result.Uploads = append(result.Uploads, info)
+			continue
+		}
+		info.Missing = true
+		for _, d := range state.Uploads {
+			if d.Name == u.Name {
+				info.Missing = false
+				info.SizeBytes = d.SizeBytes
+			}
+		}
+		info.CVDs = cvdsUsingUpload(state.CVDs, u.Name)
+		result.TotalBytes += info.SizeBytes
+		result.Uploads = append(result.Uploads, info)
+	}
+	sort.SliceStable(result.Uploads, func(i, j int) bool {
+		a, b := result.Uploads[i], result.Uploads[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.CreateTime.Before(b.CreateTime)
+	})
+	return result
+}
+
+// Returns the names of the devices created from the upload directory's artifacts.
+func cvdsUsingUpload(cvds []*hoapi.CVD, dir string) []string {
+	result := []string{}
+	for _, cvd := range cvds {
+		if bs := cvd.BuildSource; bs != nil && bs.UserBuildSource != nil && bs.UserBuildSource.ArtifactsDir == dir {
+			result = append(result, cvd.Name)
+		}
+	}
+	return result
+}
+
+func runListUploadsCommand(c *cobra.Command, flags *ListUploadsFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return fmt.Errorf("failed to build service instance: %w", err)
+	}
+	uploads, err := service.ListUserUploads()
+	if err != nil {
+		return fmt.Errorf("failed to list your uploads: %w", err)
+	}
+	hosts := make(map[string]*hostUploadsState)
+	queried := make(map[string]bool)
+	for _, u := range uploads {
+		if queried[u.Host] {
+			continue
+		}
+		queried[u.Host] = true
+		srv := service.HostService(u.Host)
+		dirs, err := srv.ListUploads()
+		if err != nil {
+			c.PrintErrf("Failed to list the uploads of host %q: %v\n", u.Host, err)
+			continue
+		}
+		cvds, err := srv.ListCVDs()
+		if err != nil {
+			return fmt.Errorf("failed to list the devices of host %q: %w", u.Host, err)
+		}
+		hosts[u.Host] = &hostUploadsState{Uploads: dirs, CVDs: cvds}
+	}
+	list := userUploadInfos(uploads, hosts)
+	if flags.Format == jsonOutputFormat {
+		encoder := json.NewEncoder(c.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(list)
+	}
+	writeUserUploads(c.OutOrStdout(), list)
+	return nil
+}
+
+func writeUserUploads(out io.Writer, list *UserUploadsList) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tNAME\tCREATED\tSIZE\tUSED BY")
+	for _, u := range list.Uploads {
+		size := formatUploadSize(&client.UploadDir{SizeBytes: u.SizeBytes})
+		usedBy := strings.Join(u.CVDs, ", ")
+		if u.Missing {
+			size = "missing"
+		}
+		if usedBy == "" {
+			usedBy = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.Host, u.Name, u.CreateTime.Format(time.RFC3339), size, usedBy)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "Total: %s\n", formatBytes(list.TotalBytes))
+}
+
+// Returns the user's upload with the given name, in the host if not empty.
+func findUserUpload(uploads []*apiv1.UserUpload, name, host string) (*apiv1.UserUpload, error) {
+	matches := []*apiv1.UserUpload{}
+	for _, u := range uploads {
+		if u.Name == name && (host == "" || u.Host == host) {
+			matches = append(matches, u)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("you have no upload directory %q", name)
+	case 1:
+		return matches[0], nil
+	default:
+		hosts := []string{}
+		for _, u := range matches {
+			hosts = append(hosts, u.Host)
+		}
+		return nil, fmt.Errorf("upload directory %q exists in hosts %s, choose one with --%s",
+			name, strings.Join(hosts, ", "), hostFlag)
+	}
+}
+
+func runDeleteUploadCommand(c *cobra.Command, name string, flags *DeleteUploadFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return fmt.Errorf("failed to build service instance: %w", err)
+	}
+	uploads, err := service.ListUserUploads()
+	if err != nil {
+		return fmt.Errorf("failed to list your uploads: %w", err)
+	}
+	upload, err := findUserUpload(uploads, name, flags.Host)
+	if err != nil {
+		return err
+	}
+	srv := service.HostService(upload.Host)
+	cvds, err := srv.ListCVDs()
+	if err != nil {
+		return fmt.Errorf("failed to list the devices of host %q: %w", upload.Host, err)
+	}
+	if used := cvdsUsingUpload(cvds, upload.Name); len(used) > 0 {
+		return fmt.Errorf("upload directory %q is used by %s, delete them first", upload.Name, strings.Join(used, ", "))
+	}
+	if unknown := cvdsWithoutBuildSource(cvds); len(unknown) > 0 {
+		return fmt.Errorf("refusing to delete upload directory %q, devices %s of host %q don't report their build source and may use it",
+			upload.Name, strings.Join(unknown, ", "), upload.Host)
+	}
+	// The service forgets the directory even if the host no longer has it.
+	if err := srv.DeleteUpload(upload.Name); err != nil && !isUploadDirNotFound(err) {
+		return fmt.Errorf("failed to delete upload directory %q: %w", upload.Name, err)
+	}
+	c.Printf("Deleted %s from host %s\n", upload.Name, upload.Host)
+	return nil
+}
+
+func uploadsCommand(opts *subCommandOpts) *cobra.Command {
+	uploads := &cobra.Command{
+		Use:   "uploads",
+		Short: "Work with the upload directories you created across hosts",
+	}
+	listFlags := &ListUploadsFlags{CVDRemoteFlags: opts.RootFlags}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Lists your upload directories with their sizes and the devices using them",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runListUploadsCommand(c, listFlags, opts)
+		},
+	}
+	list.Flags().StringVar(&listFlags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	rmFlags := &DeleteUploadFlags{CVDRemoteFlags: opts.RootFlags}
+	rm := &cobra.Command{
+		Use:   "rm <dir>",
+		Short: "Deletes one of your upload directories no device uses",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runDeleteUploadCommand(c, args[0], rmFlags, opts)
+		},
+	}
+	rm.Flags().StringVar(&rmFlags.Host, hostFlag, "", "Host of the directory, required if several hosts have one of the name")
+	uploads.AddCommand(list, rm)
+	return uploads
+}
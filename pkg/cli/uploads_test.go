@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUserUploadInfos(t *testing.T) {
+	created := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	uploads := []*apiv1.UserUpload{
+		{Host: "foo", Name: "dir2", CreateTime: created.Add(time.Hour)},
+		{Host: "foo", Name: "dir1", CreateTime: created},
+		{Host: "foo", Name: "gone", CreateTime: created},
+		{Host: "bar", Name: "dir3", CreateTime: created},
+	}
+	hosts := map[string]*hostUploadsState{
+		"foo": {
+			Uploads: []*client.UploadDir{{Name: "dir1", SizeBytes: 100}, {Name: "dir2", SizeBytes: 20}, {Name: "other"}},
+			CVDs: []*hoapi.CVD{
+				{Name: "cvd-1", BuildSource: &hoapi.BuildSource{UserBuildSource: &hoapi.UserBuildSource{ArtifactsDir: "dir1"}}},
+				{Name: "cvd-2", BuildSource: &hoapi.BuildSource{AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{}}},
+			},
+		},
+	}
+
+	got := userUploadInfos(uploads, hosts)
+
+	want := &UserUploadsList{
+		Uploads: []*UserUploadInfo{
+			{Host: "bar", Name: "dir3", CreateTime: created, CVDs: []string{}},
+			{Host: "foo", Name: "dir1", CreateTime: created, SizeBytes: 100, CVDs: []string{"cvd-1"}},
+			{Host: "foo", Name: "gone", CreateTime: created, CVDs: []string{}, Missing: true},
+			{Host: "foo", Name: "dir2", CreateTime: created.Add(time.Hour), SizeBytes: 20, CVDs: []string{}},
+		},
+		TotalBytes: 120,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("uploads mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFindUserUpload(t *testing.T) {
+	uploads := []*apiv1.UserUpload{
+		{Host: "foo", Name: "dir1"},
+		{Host: "bar", Name: "dir1"},
+		{Host: "foo", Name: "dir2"},
+	}
+	tests := []struct {
+		name    string
+		dir     string
+		host    string
+		want    *apiv1.UserUpload
+		wantErr bool
+	}{
+		{name: "unique", dir: "dir2", want: uploads[2]},
+		{name: "in several hosts", dir: "dir1", wantErr: true},
+		{name: "with host", dir: "dir1", host: "bar", want: uploads[1]},
+		{name: "unknown", dir: "dir3", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := findUserUpload(uploads, tc.dir, tc.host)
+
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("expected error: %t, got: %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+type deleteUploadService struct {
+	fakeService
+	hostSrv *gcHostService
+}
+
+func (s *deleteUploadService) ListUserUploads() ([]*apiv1.UserUpload, error) {
+	return []*apiv1.UserUpload{{Host: "foo", Name: "dir1"}}, nil
+}
+
+func (s *deleteUploadService) HostService(host string) client.HostOrchestratorService {
+	return s.hostSrv
+}
+
+func TestDeleteUploadRefusesHostsWithDevicesWithoutBuildSource(t *testing.T) {
+	io, _, _ := newTestIOStreams()
+	srv := &deleteUploadService{hostSrv: &gcHostService{}}
+	opts := &CommandOptions{
+		IOStreams:     io,
+		Args:          []string{"uploads", "rm", "dir1", "--service_url=" + serviceURL},
+		InitialConfig: Config{ConnectionControlDir: t.TempDir()},
+		ServiceBuilder: func(opts *client.ServiceOptions) (client.Service, error) {
+			return srv, nil
+		},
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+
+	err := NewCVDRemoteCommand(opts).Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "cvd-1") {
+		t.Errorf("expected an error listing cvd-1, got: %v", err)
+	}
+	if len(srv.hostSrv.deleted) != 0 {
+		t.Errorf("unexpected deletions: %v", srv.hostSrv.deleted)
+	}
+}
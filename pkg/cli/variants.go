@@ -0,0 +1,99 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+)
+
+const variantFlag = "variant"
+
+// Splits the build target into its product and variant, i.e:
+// "aosp_cf_x86_64_phone-trunk_staging-userdebug" results in "aosp_cf_x86_64_phone-trunk_staging"
+// and "userdebug". The variant is empty for targets without one.
+func splitTargetVariant(target string) (product, variant string) {
+	i := strings.LastIndex(target, "-")
+	if i < 0 {
+		return target, ""
+	}
+	return target[:i], target[i+1:]
+}
+
+// Returns the target of the same product with the given variant.
+func targetWithVariant(target, variant string) string {
+	product, _ := splitTargetVariant(target)
+	return product + "-" + variant
+}
+
+func validateVariantsUnique(variants []string) error {
+	seen := make(map[string]bool)
+	for _, v := range variants {
+		if v == "" {
+			return fmt.Errorf("empty variant")
+		}
+		if seen[v] {
+			return fmt.Errorf("variant %q given more than once", v)
+		}
+		seen[v] = true
+	}
+	return nil
+}
+
+// Checks the branch has a target of the product for every variant. Returns the error listing the
+// variants the branch has otherwise.
+func validateVariants(target string, variants []string, branchTargets []string) error {
+	if err := validateVariantsUnique(variants); err != nil {
+		return err
+	}
+	product, _ := splitTargetVariant(target)
+	known := make(map[string]bool)
+	for _, t := range branchTargets {
+		if p, v := splitTargetVariant(t); p == product && v != "" {
+			known[v] = true
+		}
+	}
+	for _, v := range variants {
+		if !known[v] {
+			names := []string{}
+			for k := range known {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			if len(names) == 0 {
+				return fmt.Errorf("the build server has no targets of %q", product)
+			}
+			return fmt.Errorf("unknown variant %q of %q, the build server has: %s", v, product, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// Bundles of the variants of the main build besides the one the devices run. Fetching them along
+// with it saves later creates of those variants waiting for their fetch.
+func (o *CreateCVDOpts) variantBundles() []fetchBundle {
+	result := []fetchBundle{}
+	for _, v := range o.Variants {
+		build := o.MainBuild
+		if build.Target = targetWithVariant(o.MainBuild.Target, v); build.Target == o.MainBuild.Target {
+			continue
+		}
+		result = append(result, fetchBundle{Name: "main " + v, Bundle: &hoapi.AndroidCIBundle{Build: &build, Type: hoapi.MainBundleType}})
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTargetWithVariant(t *testing.T) {
+	got := targetWithVariant("aosp_cf_x86_64_phone-trunk_staging-userdebug", "user")
+
+	if want := "aosp_cf_x86_64_phone-trunk_staging-user"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestValidateVariants(t *testing.T) {
+	target := "aosp_cf_x86_64_phone-trunk_staging-userdebug"
+	branchTargets := []string{
+		"aosp_cf_x86_64_phone-trunk_staging-userdebug",
+		"aosp_cf_x86_64_phone-trunk_staging-user",
+		"aosp_cf_arm64_phone-trunk_staging-eng",
+	}
+	tests := []struct {
+		name     string
+		variants []string
+		wantErr  bool
+	}{
+		{name: "known", variants: []string{"userdebug", "user"}},
+		{name: "unknown", variants: []string{"eng"}, wantErr: true},
+		{name: "duplicated", variants: []string{"user", "user"}, wantErr: true},
+		{name: "empty", variants: []string{""}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateVariants(target, tc.variants, branchTargets)
+
+			if tc.wantErr != (err != nil) {
+				t.Errorf("expected error: %t, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVariantBundles(t *testing.T) {
+	opts := &CreateCVDOpts{
+		MainBuild: hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-trunk_staging-userdebug"},
+		Variants:  []string{"userdebug", "user"},
+	}
+
+	got := opts.variantBundles()
+
+	want := []fetchBundle{{
+		Name: "main user",
+		Bundle: &hoapi.AndroidCIBundle{
+			Build: &hoapi.AndroidCIBuild{Branch: "aosp-main", Target: "aosp_cf_x86_64_phone-trunk_staging-user"},
+			Type:  hoapi.MainBundleType,
+		},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("bundles mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/buildinfo"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+const checkFlag = "check"
+
+type VersionFlags struct {
+	*CVDRemoteFlags
+	Format string
+	// Fails if the service can't be reached or doesn't serve this client.
+	Check bool
+}
+
+// Whether the client and the service can work together.
+const (
+	compatibleVerdict    = "compatible"
+	upgradeClientVerdict = "upgrade-client"
+	upgradeServerVerdict = "upgrade-server"
+)
+
+// API version of services predating the info endpoint.
+const legacyServerAPIVersion = 1
+
+type ClientVersion struct {
+	buildinfo.Info
+	APIVersion int `json:"api_version"`
+}
+
+type ServerVersion struct {
+	URL string `json:"url"`
+	apiv1.ServerInfoResponse
+	// Whether the service predates the info endpoint, its version is unknown.
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+type VersionInfo struct {
+	Client ClientVersion `json:"client"`
+	// Nil if no service is configured or it couldn't be reached.
+	Server *ServerVersion `json:"server,omitempty"`
+	// Empty along with the server.
+	Verdict string `json:"verdict,omitempty"`
+}
+
+func compatibilityVerdict(clientAPIVersion int, server *apiv1.ServerInfoResponse) string {
+	switch {
+	case clientAPIVersion < server.MinClientAPIVersion:
+		return upgradeClientVerdict
+	case clientAPIVersion > server.APIVersion:
+		return upgradeServerVerdict
+	default:
+		return compatibleVerdict
+	}
+}
+
+// Returns the service's version, or that of a legacy service if it predates the info endpoint.
+func getServerVersion(service client.Service) (*ServerVersion, error) {
+	res, err := service.GetServerInfo()
+	var apiErr *client.ApiCallError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return &ServerVersion{
+			URL: service.RootURI(),
+			ServerInfoResponse: apiv1.ServerInfoResponse{
+				APIVersion:          legacyServerAPIVersion,
+				MinClientAPIVersion: legacyServerAPIVersion,
+			},
+			Legacy: true,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ServerVersion{URL: service.RootURI(), ServerInfoResponse: *res}, nil
+}
+
+func runVersionCommand(c *cobra.Command, flags *VersionFlags, opts *subCommandOpts) error {
+	if flags.Format != textOutputFormat && flags.Format != jsonOutputFormat {
+		return fmt.Errorf("invalid --%s flag value: %q", formatFlag, flags.Format)
+	}
+	v := &VersionInfo{Client: ClientVersion{Info: buildinfo.Get(), APIVersion: client.APIVersion}}
+	if flags.ServiceURL == "" {
+		if flags.Check {
+			return errors.New("no service configured to check the compatibility with")
+		}
+	} else {
+		service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+		if err != nil {
+			return fmt.Errorf("failed to build service instance: %w", err)
+		}
+		server, err := getServerVersion(service)
+		if err != nil {
+			if flags.Check {
+				return fmt.Errorf("failed to get the service's version: %w", err)
+			}
+			c.PrintErrf("Failed to get the service's version: %v\n", err)
+		} else {
+			v.Server = server
+			v.Verdict = compatibilityVerdict(v.Client.APIVersion, &server.ServerInfoResponse)
+		}
+	}
+	if flags.Format == jsonOutputFormat {
+		encoder := json.NewEncoder(c.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(v); err != nil {
+			return err
+		}
+	} else {
+		writeVersion(c.OutOrStdout(), v)
+	}
+	if flags.Check && v.Verdict != compatibleVerdict {
+		return fmt.Errorf("client API v%d is incompatible with the service, serving v%d down to v%d: %s",
+			v.Client.APIVersion, v.Server.APIVersion, v.Server.MinClientAPIVersion, v.Verdict)
+	}
+	return nil
+}
+
+func writeVersion(w io.Writer, v *VersionInfo) {
+	fmt.Fprintf(w, "Client:       %s\n", v.Client.Version)
+	if v.Client.Revision != "" {
+		revision := v.Client.Revision
+		if v.Client.Modified {
+			revision += " (modified)"
+		}
+		fmt.Fprintf(w, "Revision:     %s\n", revision)
+	}
+	if v.Client.RevisionTime != "" {
+		fmt.Fprintf(w, "Built from:   %s\n", v.Client.RevisionTime)
+	}
+	fmt.Fprintf(w, "Go:           %s\n", v.Client.GoVersion)
+	fmt.Fprintf(w, "API:          v%d\n", v.Client.APIVersion)
+	if v.Server == nil {
+		return
+	}
+	version := v.Server.Version
+	if v.Server.Legacy {
+		version = "unknown, predates version reporting"
+	}
+	fmt.Fprintf(w, "Server:       %s at %s\n", version, v.Server.URL)
+	if v.Server.MinClientAPIVersion == v.Server.APIVersion {
+		fmt.Fprintf(w, "Server API:   v%d\n", v.Server.APIVersion)
+	} else {
+		fmt.Fprintf(w, "Server API:   v%d, serves clients down to v%d\n", v.Server.APIVersion, v.Server.MinClientAPIVersion)
+	}
+	fmt.Fprintf(w, "Verdict:      %s\n", v.Verdict)
+}
+
+func versionCommand(opts *subCommandOpts) *cobra.Command {
+	flags := &VersionFlags{CVDRemoteFlags: opts.RootFlags}
+	version := &cobra.Command{
+		Use:   "version",
+		Short: "Prints the client's version and, if a service is configured, whether the service serves it",
+		Args:  cobra.NoArgs,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			// The client's version is printed even if no service is configured.
+			return c.Flags().SetAnnotation(serviceURLFlag, cobra.BashCompOneRequiredFlag, []string{"false"})
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			return runVersionCommand(c, flags, opts)
+		},
+	}
+	version.Flags().StringVar(&flags.Format, formatFlag, textOutputFormat, "Output format, either text or json")
+	version.Flags().BoolVar(&flags.Check, checkFlag, false,
+		"Fails if the service can't be reached or the client and the service are incompatible")
+	return version
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/buildinfo"
+	"github.com/google/cloud-android-orchestration/pkg/client"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompatibilityVerdict(t *testing.T) {
+	server := &apiv1.ServerInfoResponse{APIVersion: 3, MinClientAPIVersion: 2}
+	tests := []struct {
+		clientAPIVersion int
+		want             string
+	}{
+		{clientAPIVersion: 1, want: upgradeClientVerdict},
+		{clientAPIVersion: 2, want: compatibleVerdict},
+		{clientAPIVersion: 3, want: compatibleVerdict},
+		{clientAPIVersion: 4, want: upgradeServerVerdict},
+	}
+	for _, tc := range tests {
+		if got := compatibilityVerdict(tc.clientAPIVersion, server); got != tc.want {
+			t.Errorf("client API v%d: expected %q, got: %q", tc.clientAPIVersion, tc.want, got)
+		}
+	}
+}
+
+type legacyInfoService struct {
+	fakeService
+}
+
+func (legacyInfoService) GetServerInfo() (*apiv1.ServerInfoResponse, error) {
+	return nil, &client.ApiCallError{Code: http.StatusNotFound}
+}
+
+func TestGetServerVersionOfLegacyService(t *testing.T) {
+	got, err := getServerVersion(&legacyInfoService{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ServerVersion{
+		URL:                (&fakeService{}).RootURI(),
+		ServerInfoResponse: apiv1.ServerInfoResponse{APIVersion: 1, MinClientAPIVersion: 1},
+		Legacy:             true,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("server version mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteVersion(t *testing.T) {
+	v := &VersionInfo{
+		Client: ClientVersion{
+			Info:       buildinfo.Info{Version: "0.2.0", Revision: "abc123", Modified: true, GoVersion: "go1.19"},
+			APIVersion: 1,
+		},
+		Server: &ServerVersion{
+			URL:                "https://foo.com/v1",
+			ServerInfoResponse: apiv1.ServerInfoResponse{Version: "0.3.0", APIVersion: 2, MinClientAPIVersion: 2},
+		},
+		Verdict: upgradeClientVerdict,
+	}
+	sb := &strings.Builder{}
+
+	writeVersion(sb, v)
+
+	want := `Client:       0.2.0
+Revision:     abc123 (modified)
+Go:           go1.19
+API:          v1
+Server:       0.3.0 at https://foo.com/v1
+Server API:   v2
+Verdict:      upgrade-client
+`
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestVersionWithoutService(t *testing.T) {
+	io, _, out := newTestIOStreams()
+	opts := &CommandOptions{
+		IOStreams:     io,
+		Args:          []string{"version"},
+		InitialConfig: Config{ConnectionControlDir: t.TempDir()},
+		ServiceBuilder: func(opts *client.ServiceOptions) (client.Service, error) {
+			t.Error("unexpected service built")
+			return &fakeService{}, nil
+		},
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+
+	err := NewCVDRemoteCommand(opts).Execute()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(out)
+	if !strings.Contains(string(b), "API:          v1\n") || strings.Contains(string(b), "Server:") {
+		t.Errorf("unexpected output: %q", string(b))
+	}
+}
+
+func TestVersionCheck(t *testing.T) {
+	io, _, _ := newTestIOStreams()
+	opts := &CommandOptions{
+		IOStreams:     io,
+		Args:          []string{"version", "--check", "--service_url=" + serviceURL},
+		InitialConfig: Config{ConnectionControlDir: t.TempDir()},
+		ServiceBuilder: func(opts *client.ServiceOptions) (client.Service, error) {
+			return &fakeService{}, nil
+		},
+		CommandRunner:  &fakeCommandRunner{},
+		ADBServerProxy: &fakeADBServerProxy{},
+	}
+
+	if err := NewCVDRemoteCommand(opts).Execute(); err != nil {
+		t.Errorf("expected the fake service to be compatible, got: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const (
+	watchFlag         = "watch"
+	watchIntervalFlag = "interval"
+	watchCountFlag    = "count"
+)
+
+const defaultWatchInterval = 2 * time.Second
+
+// Moves the cursor to the top left corner and clears the screen.
+const clearScreenSeq = "\033[H\033[2J"
+
+// Re-runs a read-only command periodically, like watch(1).
+type WatchOpts struct {
+	Enabled  bool
+	Interval time.Duration
+	// Number of runs, zero runs until interrupted.
+	Count int
+}
+
+func addWatchFlags(c *cobra.Command, opts *WatchOpts) {
+	c.Flags().BoolVar(&opts.Enabled, watchFlag, false, "Re-run the command periodically until interrupted")
+	c.Flags().DurationVar(&opts.Interval, watchIntervalFlag, defaultWatchInterval,
+		fmt.Sprintf("Time between runs, requires --%s", watchFlag))
+	c.Flags().IntVar(&opts.Count, watchCountFlag, 0,
+		fmt.Sprintf("Exit after this many runs, requires --%s. Zero runs until interrupted", watchFlag))
+}
+
+// Wraps the RunE function of a read-only command to honor the flags added by addWatchFlags.
+func withWatch(opts *WatchOpts, runE func(c *cobra.Command, args []string) error) func(c *cobra.Command, args []string) error {
+	return func(c *cobra.Command, args []string) error {
+		if !opts.Enabled {
+			if c.Flags().Changed(watchIntervalFlag) || c.Flags().Changed(watchCountFlag) {
+				return fmt.Errorf("--%s and --%s require --%s", watchIntervalFlag, watchCountFlag, watchFlag)
+			}
+			return runE(c, args)
+		}
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+		return watch(c, opts, sigCh, func() error { return runE(c, args) })
+	}
+}
+
+// Errors of a run are printed and don't stop the following runs, the error of the last run is
+// returned when the count is reached. Interrupting returns no error.
+func watch(c *cobra.Command, opts *WatchOpts, stopCh <-chan os.Signal, run func() error) error {
+	if opts.Interval <= 0 {
+		return errors.New("the watch interval must be positive")
+	}
+	if opts.Count < 0 {
+		return errors.New("the watch count can't be negative")
+	}
+	clear := false
+	if f, ok := c.OutOrStdout().(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		clear = true
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	var err error
+	for i := 0; opts.Count == 0 || i < opts.Count; i++ {
+		if i > 0 {
+			select {
+			case <-stopCh:
+				return nil
+			case <-ticker.C:
+			}
+		}
+		out := c.OutOrStdout()
+		if clear {
+			fmt.Fprint(out, clearScreenSeq)
+		}
+		fmt.Fprintf(out, "Every %v: %s    %s\n\n", opts.Interval, watchedCommandLine(c), time.Now().Format(time.RFC1123))
+		err = run()
+		// The error of the last run is printed by the caller.
+		if last := opts.Count != 0 && i == opts.Count-1; err != nil && !last {
+			c.PrintErrln(err)
+		}
+	}
+	return err
+}
+
+func watchedCommandLine(c *cobra.Command) string {
+	return strings.TrimSpace(c.CommandPath() + " " + strings.Join(c.Flags().Args(), " "))
+}
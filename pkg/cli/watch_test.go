@@ -0,0 +1,73 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestWatchRunsCountTimes(t *testing.T) {
+	c := &cobra.Command{Use: "list"}
+	out := &bytes.Buffer{}
+	c.SetOut(out)
+	c.SetErr(&bytes.Buffer{})
+	runs := 0
+
+	err := watch(c, &WatchOpts{Enabled: true, Interval: time.Millisecond, Count: 3}, nil, func() error {
+		runs++
+		if runs == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if runs != 3 {
+		t.Errorf("expected 3 runs, got %d", runs)
+	}
+	if got := strings.Count(out.String(), "Every 1ms: list"); got != 3 {
+		t.Errorf("expected 3 headers, got %d: %q", got, out.String())
+	}
+}
+
+func TestWatchStopsWhenInterrupted(t *testing.T) {
+	c := &cobra.Command{Use: "list"}
+	c.SetOut(&bytes.Buffer{})
+	stopCh := make(chan os.Signal, 1)
+	stopCh <- syscall.SIGINT
+	runs := 0
+
+	err := watch(c, &WatchOpts{Enabled: true, Interval: time.Hour}, stopCh, func() error {
+		runs++
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if runs != 1 {
+		t.Errorf("expected 1 run, got %d", runs)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+type WhoAmIFlags struct {
+	*CVDRemoteFlags
+	Format string
+}
+
+// Authentication methods of the requests sent to the service.
+const (
+	oidcTokenAuthnMode = "oidc_token"
+	httpBasicAuthnMode = "http_basic"
+	noAuthnMode        = "none"
+)
+
+// The identity the service resolved for the user along with how the requests were authenticated.
+type WhoAmI struct {
+	apiv1.WhoAmIResponse
+	// One of the authentication modes, as configured.
+	ClientAuthn string `json:"client_authn"`
+	// Expiry of the OIDC token sent to the service, nil if no token is sent or it doesn't expire.
+	OIDCTokenExpiry *time.Time `json:"oidc_token_expiry,omitempty"`
+}
+
+func clientAuthnMode(authn *AuthnConfig) string {
+	switch {
+	case authn == nil:
+		return noAuthnMode
+	case authn.OIDCToken != nil:
+		return oidcTokenAuthnMode
+	case authn.HTTPBasicAuthn != nil:
+		return httpBasicAuthnMode
+	default:
+		return noAuthnMode
+	}
+}
+
+// Reads the expiry from the claims of the token, which is not verified. Returns nil if the token
+// has no expiry.
+func oidcTokenExpiry(token string) (*time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a JWT, it has %d part(s)", len(parts))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT payload encoding: %w", err)
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	if claims.Exp == 0 {
+		return nil, nil
+	}
+	exp := time.Unix(claims.Exp, 0)
+	return &exp, nil
+}
+
+func writeWhoAmI(w io.Writer, v *WhoAmI, now time.Time) {
+	expiry := func(t *time.Time) string {
+		if now.After(*t) {
+			return fmt.Sprintf("expired at %s", t.Format(time.RFC3339))
+		}
+		return fmt.Sprintf("expires at %s, in %s", t.Format(time.RFC3339), t.Sub(now).Round(time.Second))
+	}
+	fmt.Fprintf(w, "Username:        %s\n", v.Username)
+	if v.Email != "" {
+		fmt.Fprintf(w, "Email:           %s\n", v.Email)
+	}
+	fmt.Fprintf(w, "Account manager: %s\n", v.AccountManagerType)
+	authn := v.ClientAuthn
+	if v.OIDCTokenExpiry != nil {
+		authn += ", " + expiry(v.OIDCTokenExpiry)
+	}
+	fmt.Fprintf(w, "Client authn:    %s\n", authn)
+	buildAPI := "not authorized"
+	if v.BuildAPIAuthorized {
+		buildAPI = "authorized"
+		if v.BuildAPITokenExpiry != nil {
+			buildAPI += ", " + expiry(v.BuildAPITokenExpiry)
+		}
+	}
+	fmt.Fprintf(w, "Build API:       %s\n", buildAPI)
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+func TestOIDCTokenExpiry(t *testing.T) {
+	jwt := func(claims string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+	}
+
+	got, err := oidcTokenExpiry(jwt(`{"sub":"johndoe","exp":1700000000}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("expected expiry at 1700000000, got: %v", got)
+	}
+	if got, err := oidcTokenExpiry(jwt(`{"sub":"johndoe"}`)); err != nil || got != nil {
+		t.Errorf("expected no expiry, got: %v, %v", got, err)
+	}
+	if _, err := oidcTokenExpiry("opaque-token"); err == nil {
+		t.Error("expected error for a token other than a JWT")
+	}
+}
+
+func TestWriteWhoAmI(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tokenExpiry := now.Add(30 * time.Minute)
+	v := &WhoAmI{
+		WhoAmIResponse: apiv1.WhoAmIResponse{
+			Username:            "johndoe",
+			AccountManagerType:  "username-only",
+			BuildAPIAuthorized:  true,
+			BuildAPITokenExpiry: &tokenExpiry,
+		},
+		ClientAuthn: httpBasicAuthnMode,
+	}
+	sb := &strings.Builder{}
+
+	writeWhoAmI(sb, v, now)
+
+	for _, want := range []string{"johndoe", "username-only", "http_basic", "authorized, expires at 2024-05-01T12:30:00Z, in 30m0s"} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, sb.String())
+		}
+	}
+	if strings.Contains(sb.String(), "Email") {
+		t.Errorf("expected no email in output:\n%s", sb.String())
+	}
+}
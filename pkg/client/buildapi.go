@@ -0,0 +1,273 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const DefaultBuildAPIRootEndpoint = "https://androidbuildinternal.googleapis.com/android/internal/build/v3"
+
+var ErrBuildNotFound = errors.New("build not found")
+
+var ErrBranchNotFound = errors.New("branch not found")
+
+type BuildArtifact struct {
+	Name string `json:"name"`
+	// Size in bytes, the build api encodes int64 values as strings.
+	Size string `json:"size"`
+}
+
+type Build struct {
+	BuildID string
+	// Zero if the build server doesn't report it.
+	CreationTime time.Time
+}
+
+// Returns the OAuth2 access token authorizing the Build API calls, requests are sent without
+// authorization if empty.
+type BuildAPICredentials func() (string, error)
+
+// A client to the Android Build API, with the access granted by its credentials.
+type BuildAPI interface {
+	// Returns the latest successful build of the target in the branch. Returns ErrBuildNotFound if
+	// there is none.
+	LatestGreenBuild(branch, target string) (*Build, error)
+
+	// Lists the artifacts of a build. Returns ErrBuildNotFound if the build doesn't exist.
+	ListArtifacts(buildID, target string) ([]BuildArtifact, error)
+
+	// Returns the latest successful presubmit build of the target in the branch including the
+	// patchset of the change. Returns ErrBuildNotFound if there is none.
+	LatestChangeBuild(change, patchset int, branch, target string) (*Build, error)
+
+	// Lists the names of the build targets of the branch. Returns ErrBranchNotFound if the branch
+	// doesn't exist.
+	ListTargets(branch string) ([]string, error)
+}
+
+// Number of the latest presubmit builds of a branch searched for the builds of a change.
+const maxChangeBuildsSearched = 500
+
+type buildResource struct {
+	BuildID string `json:"buildId"`
+	// Milliseconds since the epoch, the build api encodes int64 values as strings.
+	CreationTimestamp string `json:"creationTimestamp"`
+	// Changes built by presubmit builds.
+	Changes []struct {
+		ChangeNumber json.Number `json:"changeNumber"`
+		Patchset     struct {
+			Number json.Number `json:"number"`
+		} `json:"patchset"`
+	} `json:"changes"`
+}
+
+func (r *buildResource) toBuild() (*Build, error) {
+	result := &Build{BuildID: r.BuildID}
+	if ts := r.CreationTimestamp; ts != "" {
+		ms, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build creation timestamp %q: %w", ts, err)
+		}
+		result.CreationTime = time.UnixMilli(ms)
+	}
+	return result, nil
+}
+
+func (r *buildResource) hasChange(change, patchset int) bool {
+	for _, c := range r.Changes {
+		if c.ChangeNumber.String() == strconv.Itoa(change) && c.Patchset.Number.String() == strconv.Itoa(patchset) {
+			return true
+		}
+	}
+	return false
+}
+
+// The credentials are optional, requests are sent without authorization if nil.
+func NewBuildAPI(rootEndpoint, proxyURL string, credentials BuildAPICredentials, dumpOut io.Writer) (BuildAPI, error) {
+	helper := HTTPHelper{
+		Client:       &http.Client{},
+		RootEndpoint: rootEndpoint,
+		Dumpster:     dumpOut,
+	}
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		helper.Client.Transport = &http.Transport{Proxy: http.ProxyURL(u)}
+	}
+	return &buildAPIImpl{httpHelper: helper, credentials: credentials}, nil
+}
+
+type buildAPIImpl struct {
+	httpHelper  HTTPHelper
+	credentials BuildAPICredentials
+}
+
+func (c *buildAPIImpl) LatestGreenBuild(branch, target string) (*Build, error) {
+	q := url.Values{}
+	q.Set("branch", branch)
+	q.Set("target", target)
+	q.Set("buildAttemptStatus", "complete")
+	q.Set("buildType", "submitted")
+	q.Set("successful", "true")
+	q.Set("maxResults", "1")
+	res := struct {
+		Builds []buildResource `json:"builds"`
+	}{}
+	if err := c.get("/builds?"+q.Encode(), &res); err != nil {
+		return nil, err
+	}
+	if len(res.Builds) == 0 {
+		return nil, ErrBuildNotFound
+	}
+	return res.Builds[0].toBuild()
+}
+
+func (c *buildAPIImpl) LatestChangeBuild(change, patchset int, branch, target string) (*Build, error) {
+	pageToken := ""
+	for searched := 0; searched < maxChangeBuildsSearched; {
+		q := url.Values{}
+		q.Set("branch", branch)
+		q.Set("target", target)
+		q.Set("buildAttemptStatus", "complete")
+		q.Set("buildType", "pending")
+		q.Set("successful", "true")
+		q.Set("maxResults", "100")
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		res := struct {
+			Builds        []buildResource `json:"builds"`
+			NextPageToken string          `json:"nextPageToken"`
+		}{}
+		if err := c.get("/builds?"+q.Encode(), &res); err != nil {
+			return nil, err
+		}
+		// The latest builds are listed first.
+		for _, b := range res.Builds {
+			if b.hasChange(change, patchset) {
+				return b.toBuild()
+			}
+		}
+		searched += len(res.Builds)
+		if res.NextPageToken == "" || len(res.Builds) == 0 {
+			break
+		}
+		pageToken = res.NextPageToken
+	}
+	return nil, ErrBuildNotFound
+}
+
+func (c *buildAPIImpl) ListArtifacts(buildID, target string) ([]BuildArtifact, error) {
+	result := []BuildArtifact{}
+	pageToken := ""
+	for {
+		q := url.Values{}
+		q.Set("maxResults", "100")
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		path := fmt.Sprintf("/builds/%s/%s/attempts/latest/artifacts?%s",
+			url.PathEscape(buildID), url.PathEscape(target), q.Encode())
+		res := struct {
+			Artifacts     []BuildArtifact `json:"artifacts"`
+			NextPageToken string          `json:"nextPageToken"`
+		}{}
+		if err := c.get(path, &res); err != nil {
+			return nil, err
+		}
+		result = append(result, res.Artifacts...)
+		if res.NextPageToken == "" {
+			return result, nil
+		}
+		pageToken = res.NextPageToken
+	}
+}
+
+func (c *buildAPIImpl) ListTargets(branch string) ([]string, error) {
+	result := []string{}
+	pageToken := ""
+	for {
+		q := url.Values{}
+		q.Set("maxResults", "100")
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		res := struct {
+			Targets []struct {
+				Name string `json:"name"`
+			} `json:"targets"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		err := c.get(fmt.Sprintf("/branches/%s/targets?%s", url.PathEscape(branch), q.Encode()), &res)
+		if errors.Is(err, ErrBuildNotFound) {
+			return nil, ErrBranchNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range res.Targets {
+			result = append(result, t.Name)
+		}
+		if res.NextPageToken == "" {
+			return result, nil
+		}
+		pageToken = res.NextPageToken
+	}
+}
+
+// The build api errors don't follow the ApiCallError format, handle the response here.
+func (c *buildAPIImpl) get(path string, ret any) error {
+	rb := c.httpHelper.NewGetRequest(path)
+	if c.credentials != nil {
+		token, err := c.credentials()
+		if err != nil {
+			return fmt.Errorf("failed obtaining the build api credentials: %w", err)
+		}
+		if token != "" {
+			rb.AddHeader("Authorization", "Bearer "+token)
+		}
+	}
+	res, err := rb.Do()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return ErrBuildNotFound
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("build api call not authorized(%d), check the build api credentials: %s", res.StatusCode, string(b))
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return fmt.Errorf("build api call failed(%d): %s", res.StatusCode, string(b))
+	}
+	if err := json.Unmarshal(b, ret); err != nil {
+		return fmt.Errorf("failed decoding build api response, body: %s, error: %w", string(b), err)
+	}
+	return nil
+}
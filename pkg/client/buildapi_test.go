@@ -0,0 +1,160 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListArtifacts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/builds/123/foo/attempts/latest/artifacts" && r.URL.Query().Get("pageToken") == "":
+			w.Write([]byte(`{"artifacts": [{"name": "a.zip", "size": "1"}], "nextPageToken": "next"}`))
+		case r.URL.Path == "/builds/123/foo/attempts/latest/artifacts" && r.URL.Query().Get("pageToken") == "next":
+			w.Write([]byte(`{"artifacts": [{"name": "b.zip", "size": "2"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+		}
+	}))
+	defer ts.Close()
+	api, _ := NewBuildAPI(ts.URL, "", nil, io.Discard)
+
+	got, err := api.ListArtifacts("123", "foo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []BuildArtifact{{Name: "a.zip", Size: "1"}, {Name: "b.zip", Size: "2"}}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("artifacts mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := api.ListArtifacts("456", "foo"); !errors.Is(err, ErrBuildNotFound) {
+		t.Errorf("expected ErrBuildNotFound, got: %v", err)
+	}
+}
+
+func TestLatestGreenBuild(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/builds" || r.URL.Query().Get("branch") != "aosp-main" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"builds": [{"buildId": "123", "creationTimestamp": "1700000000000"}]}`))
+	}))
+	defer ts.Close()
+	api, _ := NewBuildAPI(ts.URL, "", nil, io.Discard)
+
+	got, err := api.LatestGreenBuild("aosp-main", "foo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &Build{BuildID: "123", CreationTime: time.UnixMilli(1700000000000)}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("build mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLatestChangeBuild(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/builds" || r.URL.Query().Get("buildType") != "pending" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("pageToken") {
+		case "":
+			w.Write([]byte(`{"builds": [{"buildId": "P3", "changes": [{"changeNumber": "12345", "patchset": {"number": 3}}]}],
+				"nextPageToken": "next"}`))
+		case "next":
+			w.Write([]byte(`{"builds": [{"buildId": "P2", "changes": [{"changeNumber": "12345", "patchset": {"number": 2}}]}]}`))
+		}
+	}))
+	defer ts.Close()
+	api, _ := NewBuildAPI(ts.URL, "", nil, io.Discard)
+
+	got, err := api.LatestChangeBuild(12345, 2, "aosp-main", "foo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BuildID != "P2" {
+		t.Errorf("expected build P2, got %q", got.BuildID)
+	}
+	if _, err := api.LatestChangeBuild(12345, 1, "aosp-main", "foo"); !errors.Is(err, ErrBuildNotFound) {
+		t.Errorf("expected ErrBuildNotFound, got: %v", err)
+	}
+}
+
+func TestListTargets(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/branches/aosp-main/targets" && r.URL.Query().Get("pageToken") == "":
+			w.Write([]byte(`{"targets": [{"name": "aosp_cf_x86_64_phone-trunk_staging-userdebug"}], "nextPageToken": "next"}`))
+		case r.URL.Path == "/branches/aosp-main/targets" && r.URL.Query().Get("pageToken") == "next":
+			w.Write([]byte(`{"targets": [{"name": "aosp_cf_arm64_phone-trunk_staging-userdebug"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	api, _ := NewBuildAPI(ts.URL, "", nil, io.Discard)
+
+	got, err := api.ListTargets("aosp-main")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"aosp_cf_x86_64_phone-trunk_staging-userdebug", "aosp_cf_arm64_phone-trunk_staging-userdebug"}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("targets mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := api.ListTargets("foo"); !errors.Is(err, ErrBranchNotFound) {
+		t.Errorf("expected ErrBranchNotFound, got: %v", err)
+	}
+}
+
+func TestBuildAPISendsCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer foo" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"targets": [{"name": "bar"}]}`))
+	}))
+	defer ts.Close()
+	api, _ := NewBuildAPI(ts.URL, "", func() (string, error) { return "foo", nil }, io.Discard)
+
+	got, err := api.ListTargets("aosp-main")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"bar"}, got); diff != "" {
+		t.Errorf("targets mismatch (-want +got):\n%s", diff)
+	}
+	unauthorized, _ := NewBuildAPI(ts.URL, "", nil, io.Discard)
+	if _, err := unauthorized.ListTargets("aosp-main"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -20,11 +20,13 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"sync"
 	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
 	"github.com/hashicorp/go-multierror"
 )
 
@@ -32,6 +34,7 @@ const (
 	// Value to pass as credentials to the Host Orchestrator service endpoints. Any non-empty value is enough.
 	InjectedCredentials             = "inject"
 	headerNameCOInjectBuildAPICreds = "X-Cutf-Cloud-Orchestrator-Inject-BuildAPI-Creds"
+	headerNameCorrelationID         = "X-Correlation-Id"
 )
 
 type ApiCallError struct {
@@ -73,6 +76,14 @@ type ServiceOptions struct {
 	ErrOut         io.Writer
 	ChunkSizeBytes int64
 	Authn          *AuthnOpts
+	// If not empty, sent in every request to correlate them with the server logs.
+	CorrelationID string
+	// Path the hosts' signaling servers are mounted at, relative to their endpoint. Defaults to
+	// DefaultSignalingPath.
+	SignalingPath string
+	// [OPTIONAL] Retries the failures of any request it returns true for, i.e:
+	// RetryTransientNetworkErrors. It must only retry failures safe to retry, creates included.
+	RetryPredicate RetryPredicate
 }
 
 type Service interface {
@@ -84,9 +95,61 @@ type Service interface {
 
 	HostService(host string) HostOrchestratorService
 
+	// Creates a link to the device's display expiring after the given time. Requires a server
+	// supporting share-token issuance.
+	CreateShareLink(host, name string, ttl time.Duration) (string, error)
+
+	// Revokes every link created for the device.
+	RevokeShareLinks(host, name string) error
+
+	// Uploads the image to the host and flashes it onto the device's partition, returns after the
+	// device rebooted. Requires a server supporting partition flashing, the upload directory is
+	// deleted if the flash fails.
+	FlashPartition(host, name, partition, imagePath string) error
+
+	// Updates the device to another build of its target with an incremental OTA package, returns
+	// after the device rebooted into it.
+	ApplyOTA(host, name, otaBuildID string) error
+
+	ApplyOTAWithOptions(host, name, otaBuildID string, opts ApplyOTAOptions) error
+
+	// Returns the versions of the service and of the API it speaks. Services predating it reply 404.
+	GetServerInfo() (*apiv1.ServerInfoResponse, error)
+
+	// Returns the user the service authenticated the requests as.
+	WhoAmI() (*apiv1.WhoAmIResponse, error)
+
+	// Returns the authenticated user's default build sources, empty if the user has none.
+	GetDefaults() (*apiv1.BuildDefaults, error)
+
+	// Replaces the authenticated user's default build sources.
+	SetDefaults(defaults *apiv1.BuildDefaults) error
+
+	// Returns the upload directories the authenticated user created across hosts.
+	ListUserUploads() ([]*apiv1.UserUpload, error)
+
+	// Cancels the in-progress host operations the authenticated user started.
+	CancelUserOperations() (*apiv1.CancelUserOperationsResponse, error)
+
 	RootURI() string
 }
 
+type ApplyOTAOptions struct {
+	// Build target of the device. Required by the server to fetch the package.
+	Target string
+	// Applies the full OTA package instead of the incremental one.
+	Full bool
+	// Called with the progress of the update while it runs, nil if not needed.
+	OnProgress func(*apiv1.OTAProgress)
+	// How often the progress is polled, defaults to defaultOTAPollInterval.
+	PollInterval time.Duration
+}
+
+const defaultOTAPollInterval = 2 * time.Second
+
+// Version of the service API this client speaks, see apiv1.ServerInfoResponse.
+const APIVersion = 1
+
 type serviceImpl struct {
 	*ServiceOptions
 	httpHelper HTTPHelper
@@ -96,9 +159,11 @@ type ServiceBuilder func(opts *ServiceOptions) (Service, error)
 
 func NewService(opts *ServiceOptions) (Service, error) {
 	helper := HTTPHelper{
-		Client:       &http.Client{},
-		RootEndpoint: opts.RootEndpoint,
-		Dumpster:     opts.DumpOut,
+		Client:         &http.Client{},
+		RootEndpoint:   opts.RootEndpoint,
+		Dumpster:       opts.DumpOut,
+		CorrelationID:  opts.CorrelationID,
+		RetryPredicate: opts.RetryPredicate,
 	}
 	if opts.ProxyURL != "" {
 		proxyUrl, err := url.Parse(opts.ProxyURL)
@@ -181,6 +246,133 @@ func (c *serviceImpl) waitForOperation(op *apiv1.Operation, res any) error {
 	return c.httpHelper.NewPostRequest(path, nil).JSONResDoWithRetries(res, retryOpts)
 }
 
+func (c *serviceImpl) CreateShareLink(host, name string, ttl time.Duration) (string, error) {
+	req := &apiv1.CreateShareLinkRequest{TTLSeconds: int64(ttl.Seconds())}
+	res := &apiv1.ShareLink{}
+	if err := c.httpHelper.NewPostRequest(shareLinksPath(host, name), req).JSONResDo(res); err != nil {
+		return "", err
+	}
+	return res.URL, nil
+}
+
+func (c *serviceImpl) RevokeShareLinks(host, name string) error {
+	return c.httpHelper.NewDeleteRequest(shareLinksPath(host, name)).JSONResDo(nil)
+}
+
+func (c *serviceImpl) FlashPartition(host, name, partition, imagePath string) error {
+	hs := c.HostService(host)
+	uploadDir, err := hs.CreateUploadDir()
+	if err != nil {
+		return fmt.Errorf("failed creating upload directory: %w", err)
+	}
+	if err := c.flashPartition(hs, host, name, partition, imagePath, uploadDir); err != nil {
+		if delErr := hs.DeleteUpload(uploadDir); delErr != nil {
+			return multierror.Append(err, fmt.Errorf("failed deleting upload directory %q: %w", uploadDir, delErr))
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *serviceImpl) flashPartition(hs HostOrchestratorService, host, name, partition, imagePath, uploadDir string) error {
+	if err := hs.UploadFile(uploadDir, imagePath); err != nil {
+		return fmt.Errorf("failed uploading %q: %w", imagePath, err)
+	}
+	req := &apiv1.FlashPartitionRequest{
+		Partition:        partition,
+		UserArtifactsDir: uploadDir,
+		ImageName:        filepath.Base(imagePath),
+	}
+	path := fmt.Sprintf("/hosts/%s/cvds/%s/:flash", url.PathEscape(host), url.PathEscape(name))
+	var op hoapi.Operation
+	if err := c.httpHelper.NewPostRequest(path, req).JSONResDo(&op); err != nil {
+		return err
+	}
+	return hs.WaitForOperation(op.Name, nil)
+}
+
+func (c *serviceImpl) ApplyOTA(host, name, otaBuildID string) error {
+	return c.ApplyOTAWithOptions(host, name, otaBuildID, ApplyOTAOptions{})
+}
+
+type otaOperation struct {
+	hoapi.Operation
+	Progress *apiv1.OTAProgress `json:"progress,omitempty"`
+}
+
+func (c *serviceImpl) ApplyOTAWithOptions(host, name, otaBuildID string, opts ApplyOTAOptions) error {
+	req := &apiv1.ApplyOTARequest{BuildID: otaBuildID, Target: opts.Target, Full: opts.Full}
+	path := fmt.Sprintf("/hosts/%s/cvds/%s/:ota", url.PathEscape(host), url.PathEscape(name))
+	var op otaOperation
+	if err := c.httpHelper.NewPostRequest(path, req).JSONResDo(&op); err != nil {
+		return err
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = defaultOTAPollInterval
+	}
+	opPath := fmt.Sprintf("/hosts/%s/operations/%s", url.PathEscape(host), url.PathEscape(op.Name))
+	for !op.Done {
+		if op.Progress != nil && opts.OnProgress != nil {
+			opts.OnProgress(op.Progress)
+		}
+		time.Sleep(interval)
+		if err := c.httpHelper.NewGetRequest(opPath).JSONResDo(&op); err != nil {
+			return fmt.Errorf("failed polling the update progress: %w", err)
+		}
+	}
+	// Returns the error of the operation, if it failed.
+	return c.HostService(host).WaitForOperation(op.Name, nil)
+}
+
+func shareLinksPath(host, name string) string {
+	return fmt.Sprintf("/hosts/%s/cvds/%s/share_links", url.PathEscape(host), url.PathEscape(name))
+}
+
+func (c *serviceImpl) GetServerInfo() (*apiv1.ServerInfoResponse, error) {
+	res := &apiv1.ServerInfoResponse{}
+	if err := c.httpHelper.NewGetRequest("/info").JSONResDo(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *serviceImpl) WhoAmI() (*apiv1.WhoAmIResponse, error) {
+	res := &apiv1.WhoAmIResponse{}
+	if err := c.httpHelper.NewGetRequest("/whoami").JSONResDo(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *serviceImpl) GetDefaults() (*apiv1.BuildDefaults, error) {
+	res := &apiv1.BuildDefaults{}
+	if err := c.httpHelper.NewGetRequest("/defaults").JSONResDo(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *serviceImpl) SetDefaults(defaults *apiv1.BuildDefaults) error {
+	return c.httpHelper.NewPostRequest("/defaults", defaults).JSONResDo(nil)
+}
+
+func (c *serviceImpl) ListUserUploads() ([]*apiv1.UserUpload, error) {
+	res := &apiv1.ListUserUploadsResponse{}
+	if err := c.httpHelper.NewGetRequest("/uploads").JSONResDo(res); err != nil {
+		return nil, err
+	}
+	return res.Items, nil
+}
+
+func (c *serviceImpl) CancelUserOperations() (*apiv1.CancelUserOperationsResponse, error) {
+	res := &apiv1.CancelUserOperationsResponse{}
+	if err := c.httpHelper.NewPostRequest("/operations/:cancel", nil).JSONResDo(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (s *serviceImpl) RootURI() string {
 	return s.RootEndpoint
 }
@@ -190,6 +382,7 @@ func (s *serviceImpl) HostService(host string) HostOrchestratorService {
 		HTTPHelper: s.httpHelper,
 		// Make the cloud orchestrator inject the credentials instead
 		BuildAPICredentialsHeader: headerNameCOInjectBuildAPICreds,
+		SignalingPath:             s.SignalingPath,
 	}
 	hs.HTTPHelper.RootEndpoint = s.httpHelper.RootEndpoint + "/hosts/" + host
 	return hs
@@ -16,14 +16,17 @@ package client
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"testing"
+	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
 	"github.com/hashicorp/go-multierror"
 )
 
@@ -60,6 +63,170 @@ func TestDeleteHosts(t *testing.T) {
 	}
 }
 
+func TestCreateShareLink(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/hosts/foo/cvds/cvd-1/share_links" {
+			panic("unexpected request: " + r.Method + " " + r.URL.Path)
+		}
+		req := &apiv1.CreateShareLinkRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			panic(err)
+		}
+		if req.TTLSeconds != 3600 {
+			panic("unexpected ttl")
+		}
+		writeOK(w, &apiv1.ShareLink{URL: "https://waldo.com/share/token"})
+	}))
+	defer ts.Close()
+	srv, _ := NewService(&ServiceOptions{RootEndpoint: ts.URL, DumpOut: io.Discard})
+
+	link, err := srv.CreateShareLink("foo", "cvd-1", time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "https://waldo.com/share/token" {
+		t.Errorf("unexpected link: %q", link)
+	}
+}
+
+func TestFlashPartition(t *testing.T) {
+	image := createTempFile(t, t.TempDir(), "system.img", []byte("lorem"))
+	flashed := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "POST /hosts/foo/userartifacts":
+			writeOK(w, &hoapi.UploadDirectory{Name: "bar"})
+		case "PUT /hosts/foo/userartifacts/bar":
+			writeOK(w, nil)
+		case "POST /hosts/foo/cvds/cvd-1/:flash":
+			req := &apiv1.FlashPartitionRequest{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				panic(err)
+			}
+			exp := apiv1.FlashPartitionRequest{Partition: "system", UserArtifactsDir: "bar", ImageName: "system.img"}
+			if *req != exp {
+				panic(fmt.Sprintf("unexpected request: %+v", req))
+			}
+			flashed = true
+			writeOK(w, &hoapi.Operation{Name: "op-1"})
+		case "POST /hosts/foo/operations/op-1/:wait":
+			writeOK(w, nil)
+		default:
+			panic("unexpected request: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv, _ := NewService(&ServiceOptions{RootEndpoint: ts.URL, DumpOut: io.Discard})
+
+	err := srv.FlashPartition("foo", "cvd-1", "system", image)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flashed {
+		t.Error("partition not flashed")
+	}
+}
+
+func TestFlashPartitionFailureDeletesTheUploadDir(t *testing.T) {
+	image := createTempFile(t, t.TempDir(), "system.img", []byte("lorem"))
+	deleted := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "POST /hosts/foo/userartifacts":
+			writeOK(w, &hoapi.UploadDirectory{Name: "bar"})
+		case "PUT /hosts/foo/userartifacts/bar":
+			writeOK(w, nil)
+		case "POST /hosts/foo/cvds/cvd-1/:flash":
+			writeErr(w, http.StatusNotFound)
+		case "DELETE /hosts/foo/userartifacts/bar":
+			deleted = true
+			writeOK(w, nil)
+		default:
+			panic("unexpected request: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv, _ := NewService(&ServiceOptions{RootEndpoint: ts.URL, DumpOut: io.Discard})
+
+	err := srv.FlashPartition("foo", "cvd-1", "system", image)
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !deleted {
+		t.Error("upload directory not deleted")
+	}
+}
+
+func TestApplyOTA(t *testing.T) {
+	polls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "POST /hosts/foo/cvds/cvd-1/:ota":
+			req := &apiv1.ApplyOTARequest{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				panic(err)
+			}
+			exp := apiv1.ApplyOTARequest{BuildID: "124", Target: "aosp_cf_x86_64_phone"}
+			if *req != exp {
+				panic(fmt.Sprintf("unexpected request: %+v", req))
+			}
+			writeOK(w, &otaOperation{Operation: hoapi.Operation{Name: "op-1"}, Progress: &apiv1.OTAProgress{Stage: apiv1.OTAStageFetching}})
+		case "GET /hosts/foo/operations/op-1":
+			polls++
+			op := &otaOperation{Operation: hoapi.Operation{Name: "op-1", Done: polls > 1}, Progress: &apiv1.OTAProgress{Stage: apiv1.OTAStageRebooting}}
+			writeOK(w, op)
+		case "POST /hosts/foo/operations/op-1/:wait":
+			writeOK(w, nil)
+		default:
+			panic("unexpected request: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv, _ := NewService(&ServiceOptions{RootEndpoint: ts.URL, DumpOut: io.Discard})
+	stages := []string{}
+	opts := ApplyOTAOptions{
+		Target:       "aosp_cf_x86_64_phone",
+		OnProgress:   func(p *apiv1.OTAProgress) { stages = append(stages, p.Stage) },
+		PollInterval: time.Millisecond,
+	}
+
+	err := srv.ApplyOTAWithOptions("foo", "cvd-1", "124", opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{apiv1.OTAStageFetching, apiv1.OTAStageRebooting}
+	if fmt.Sprint(stages) != fmt.Sprint(exp) {
+		t.Errorf("expected stages %v, got: %v", exp, stages)
+	}
+}
+
+func TestCancelUserOperations(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/operations/:cancel" {
+			panic("unexpected request: " + r.Method + " " + r.URL.Path)
+		}
+		writeOK(w, &apiv1.CancelUserOperationsResponse{
+			Cancelled: []*apiv1.UserOperation{{Host: "foo", Name: "op1"}},
+			Failed:    []*apiv1.UserOperation{},
+		})
+	}))
+	defer ts.Close()
+	srv, _ := NewService(&ServiceOptions{RootEndpoint: ts.URL, DumpOut: io.Discard})
+
+	res, err := srv.CancelUserOperations()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Cancelled) != 1 || res.Cancelled[0].Name != "op1" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
 func writeErr(w http.ResponseWriter, statusCode int) {
 	write(w, &apiv1.Error{Code: statusCode}, statusCode)
 }
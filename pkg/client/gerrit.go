@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const DefaultGerritURL = "https://android-review.googlesource.com"
+
+var ErrChangeNotFound = errors.New("change not found")
+
+// Prepended by Gerrit to its JSON responses to prevent XSSI.
+var gerritMagicPrefix = []byte(")]}'")
+
+type GerritChange struct {
+	Number  int
+	Project string
+	Branch  string
+	// Number of the latest patchset.
+	CurrentPatchset int
+}
+
+// A client to the Gerrit REST API, only changes visible anonymously are accessible.
+type GerritAPI interface {
+	// Gets the change by number or Change-Id. Returns ErrChangeNotFound if it doesn't exist.
+	GetChange(id string) (*GerritChange, error)
+}
+
+func NewGerritAPI(rootEndpoint, proxyURL string, dumpOut io.Writer) (GerritAPI, error) {
+	helper := HTTPHelper{
+		Client:       &http.Client{},
+		RootEndpoint: rootEndpoint,
+		Dumpster:     dumpOut,
+	}
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		helper.Client.Transport = &http.Transport{Proxy: http.ProxyURL(u)}
+	}
+	return &gerritAPIImpl{httpHelper: helper}, nil
+}
+
+type gerritAPIImpl struct {
+	httpHelper HTTPHelper
+}
+
+func (c *gerritAPIImpl) GetChange(id string) (*GerritChange, error) {
+	res, err := c.httpHelper.NewGetRequest("/changes/" + url.PathEscape(id) + "?o=CURRENT_REVISION").Do()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrChangeNotFound
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return nil, fmt.Errorf("gerrit api call failed(%d): %s", res.StatusCode, string(b))
+	}
+	change := struct {
+		Number          int    `json:"_number"`
+		Project         string `json:"project"`
+		Branch          string `json:"branch"`
+		CurrentRevision string `json:"current_revision"`
+		Revisions       map[string]struct {
+			Number int `json:"_number"`
+		} `json:"revisions"`
+	}{}
+	if err := json.Unmarshal(bytes.TrimPrefix(b, gerritMagicPrefix), &change); err != nil {
+		return nil, fmt.Errorf("failed decoding gerrit api response, body: %s, error: %w", string(b), err)
+	}
+	rev, ok := change.Revisions[change.CurrentRevision]
+	if !ok {
+		return nil, fmt.Errorf("gerrit didn't report the current patchset of change %d", change.Number)
+	}
+	return &GerritChange{
+		Number:          change.Number,
+		Project:         change.Project,
+		Branch:          change.Branch,
+		CurrentPatchset: rev.Number,
+	}, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetChange(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/changes/12345" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Not found: 67890"))
+			return
+		}
+		w.Write([]byte(")]}'\n" + `{"_number": 12345, "project": "platform/build", "branch": "main",
+			"current_revision": "abc", "revisions": {"abc": {"_number": 3}}}`))
+	}))
+	defer ts.Close()
+	api, _ := NewGerritAPI(ts.URL, "", io.Discard)
+
+	got, err := api.GetChange("12345")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &GerritChange{Number: 12345, Project: "platform/build", Branch: "main", CurrentPatchset: 3}
+	if diff := cmp.Diff(exp, got); diff != "" {
+		t.Errorf("change mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := api.GetChange("67890"); !errors.Is(err, ErrChangeNotFound) {
+		t.Errorf("expected ErrChangeNotFound, got: %v", err)
+	}
+}
@@ -14,28 +14,60 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	wclient "github.com/google/cloud-android-orchestration/pkg/webrtcclient"
 
 	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
 	"github.com/pion/webrtc/v3"
 )
 
+// Returned when the host orchestrator runs a version this client doesn't know how to talk to.
+type IncompatibleVersionError struct {
+	Err error
+}
+
+func (e *IncompatibleVersionError) Error() string {
+	return fmt.Sprintf("incompatible host orchestrator version: %v", e.Err)
+}
+
+func (e *IncompatibleVersionError) Unwrap() error {
+	return e.Err
+}
+
 type ConnectWebRTCOpts struct {
 	LocalICEConfig *wclient.ICEConfig
+	// Whether to open a data channel to synchronize the clipboard with the device.
+	ClipboardSync bool
+	// Whether to receive the device's video, the observer must implement wclient.VideoObserver.
+	Video bool
+	// Whether to open a data channel to the device's serial console, the observer must implement
+	// wclient.ConsoleObserver.
+	Console bool
 }
 
 // A client to the host orchestrator service running in a remote host.
 type HostOrchestratorService interface {
 	// Lists currently running devices.
 	ListCVDs() ([]*hoapi.CVD, error)
+	// Like ListCVDs, with the details only some host orchestrator versions report.
+	ListCVDDetails() ([]*CVDDetails, error)
 
 	// Creates a directory in the host where user artifacts can be uploaded to.
 	CreateUploadDir() (string, error)
+	// Lists the directories created with CreateUploadDir, requires a host orchestrator exposing them.
+	ListUploads() ([]*UploadDir, error)
+	// Deletes a directory created with CreateUploadDir and its content.
+	DeleteUpload(dir string) error
 
 	// Uploads file into the given directory.
 	UploadFile(uploadDir string, filename string) error
@@ -47,13 +79,28 @@ type HostOrchestratorService interface {
 	// Create a new device with artifacts from the build server or previously uploaded by the user.
 	// If not empty, the provided credentials will be used to download necessary artifacts from the build api.
 	CreateCVD(req *hoapi.CreateCVDRequest, buildAPICredentials string) (*hoapi.CreateCVDResponse, error)
+	// Same as CreateCVD but returns right after the creation operation started instead of waiting for it.
+	CreateCVDOp(req *hoapi.CreateCVDRequest, buildAPICredentials string) (*hoapi.Operation, error)
+	CreateCVDOpWithOptions(req *hoapi.CreateCVDRequest, buildAPICredentials string, options CreateCVDOptions) (*hoapi.Operation, error)
+	// Waits for an operation returned by CreateCVDOp, that is for the devices to boot.
+	WaitForCreateCVDOp(name string) (*hoapi.CreateCVDResponse, error)
 
 	// Deletes an existing cvd instance.
 	DeleteCVD(id string) error
+	// Makes the host delete the device `ttl` from now instead of when it was going to, returns the
+	// device with its new expire time. Requires a host orchestrator enforcing TTLs.
+	ExtendCVD(id string, ttl time.Duration) (*CVDDetails, error)
 
 	// Calls cvd fetch in the remote host, the downloaded artifacts can be used to create a CVD later.
 	// If not empty, the provided credentials will be used by the host orchestrator to access the build api.
 	FetchArtifacts(req *hoapi.FetchArtifactsRequest, buildAPICredentials string) (*hoapi.FetchArtifactsResponse, error)
+	FetchArtifactsWithOptions(req *hoapi.FetchArtifactsRequest, buildAPICredentials string, options FetchArtifactsOptions) (*hoapi.FetchArtifactsResponse, error)
+	// Same as FetchArtifactsWithOptions but returns right after the fetch operation started instead of waiting for it.
+	FetchArtifactsOp(req *hoapi.FetchArtifactsRequest, buildAPICredentials string, options FetchArtifactsOptions) (*hoapi.Operation, error)
+	// Returns the progress of an operation returned by FetchArtifactsOp without waiting for it.
+	GetFetchArtifactsProgress(name string) (*FetchArtifactsProgress, error)
+	// Waits for an operation returned by FetchArtifactsOp.
+	WaitForFetchArtifactsOp(name string) (*FetchArtifactsResult, error)
 
 	// Downloads runtime artifacts tar file into `dst`.
 	DownloadRuntimeArtifacts(dst io.Writer) error
@@ -63,10 +110,43 @@ type HostOrchestratorService interface {
 
 	// Wait for an operation, `result` will be populated with the relevant operation's result object.
 	WaitForOperation(name string, result any) error
+
+	// Returns the logs the host orchestrator kept of the operation, the caller closes them. Returns
+	// ErrOperationNotFound if the operation doesn't exist or was garbage collected.
+	GetOperationLogs(name string) (io.ReadCloser, error)
+
+	// Returns the contents of one of the device's log files, i.e: "launcher.log", the caller closes
+	// them. Returns ErrLogNotFound if the device or the file don't exist.
+	GetCVDLogs(name, file string) (io.ReadCloser, error)
 }
 
+var ErrOperationNotFound = errors.New("operation not found")
+
+var ErrLogNotFound = errors.New("log not found")
+
 const defaultHostOrchestratorCredentialsHeader = "X-Cutf-Host-Orchestrator-BuildAPI-Creds"
 
+// Path the host orchestrators serve the WebRTC signaling at, relative to their endpoint.
+const DefaultSignalingPath = "/polled_connections"
+
+// Checks the path is absolute and has no trailing slash, query or fragment.
+func ValidateSignalingPath(p string) error {
+	if !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("signaling path must start with \"/\", got: %q", p)
+	}
+	if len(p) > 1 && strings.HasSuffix(p, "/") {
+		return fmt.Errorf("signaling path must not end with \"/\", got: %q", p)
+	}
+	u, err := url.Parse(p)
+	if err != nil {
+		return fmt.Errorf("invalid signaling path %q: %w", p, err)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.Host != "" || u.Path != p {
+		return fmt.Errorf("signaling path must be a plain path with no query or fragment, got: %q", p)
+	}
+	return nil
+}
+
 func NewHostOrchestratorService(url string) HostOrchestratorService {
 	return &HostOrchestratorServiceImpl{
 		HTTPHelper: HTTPHelper{
@@ -77,13 +157,49 @@ func NewHostOrchestratorService(url string) HostOrchestratorService {
 	}
 }
 
+// Returns the service with its requests canceled along with `ctx`, or the service as is if it's
+// unable to cancel them.
+func HostServiceWithContext(srv HostOrchestratorService, ctx context.Context) HostOrchestratorService {
+	if s, ok := srv.(interface {
+		WithContext(ctx context.Context) HostOrchestratorService
+	}); ok {
+		return s.WithContext(ctx)
+	}
+	return srv
+}
+
 type HostOrchestratorServiceImpl struct {
 	HTTPHelper                HTTPHelper
 	BuildAPICredentialsHeader string
+	// Path the signaling server is mounted at relative to `HTTPHelper.RootEndpoint`, i.e: by
+	// reverse proxies rewriting paths. Defaults to DefaultSignalingPath.
+	SignalingPath string
+}
+
+// Returns a copy of the service whose requests are canceled along with `ctx`.
+func (c *HostOrchestratorServiceImpl) WithContext(ctx context.Context) HostOrchestratorService {
+	result := *c
+	result.HTTPHelper.Context = ctx
+	return &result
 }
 
-func (c *HostOrchestratorServiceImpl) getInfraConfig() (*hoapi.InfraConfig, error) {
-	var res hoapi.InfraConfig
+// Returns a copy of the service whose rate limited requests are reported to `hook`.
+func (c *HostOrchestratorServiceImpl) WithThrottleHook(hook ThrottleHook) HostOrchestratorService {
+	result := *c
+	result.HTTPHelper.OnThrottled = hook
+	return &result
+}
+
+func (c *HostOrchestratorServiceImpl) signalingPath() string {
+	if c.SignalingPath != "" {
+		return c.SignalingPath
+	}
+	return DefaultSignalingPath
+}
+
+// Decoded as the service's infra config, hoapi.InfraConfig has no room for TURN credentials.
+func (c *HostOrchestratorServiceImpl) getInfraConfig() (*apiv1.InfraConfig, error) {
+	var res apiv1.InfraConfig
 	if err := c.HTTPHelper.NewGetRequest("/infra_config").JSONResDo(&res); err != nil {
 		return nil, err
 	}
@@ -105,7 +221,8 @@ func (c *HostOrchestratorServiceImpl) ConnectWebRTC(device string, observer wcli
 	}
 	iceServers = append(iceServers, asWebRTCICEServers(infraConfig.IceServers)...)
 	signaling := c.initHandling(polledConn.ConnId, iceServers, logger)
-	conn, err := wclient.NewConnectionWithLogger(&signaling, observer, logger)
+	signaling.ICECredentialsExpireTime = iceCredentialsExpireTime(infraConfig.IceServers)
+	conn, err := wclient.NewConnectionWithOpts(&signaling, observer, logger, wclient.ConnectionOpts{Clipboard: opts.ClipboardSync, Video: opts.Video, Console: opts.Console})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to device over webrtc: %w", err)
 	}
@@ -142,7 +259,7 @@ func (c *HostOrchestratorServiceImpl) webRTCPoll(sinkCh chan map[string]any, con
 	pollInterval := initialPollInterval
 	errCount := 0
 	for {
-		path := fmt.Sprintf("/polled_connections/%s/messages?start=%d", connID, start)
+		path := fmt.Sprintf("%s/%s/messages?start=%d", c.signalingPath(), connID, start)
 		var messages []map[string]any
 		if err := c.HTTPHelper.NewGetRequest(path).JSONResDo(&messages); err != nil {
 			fmt.Fprintf(logger, "Error polling messages: %v\n", err)
@@ -191,7 +308,7 @@ func (c *HostOrchestratorServiceImpl) webRTCForward(srcCh chan any, connID strin
 			break
 		}
 		forwardMsg := hoapi.ForwardMsg{Payload: msg}
-		path := fmt.Sprintf("/polled_connections/%s/:forward", connID)
+		path := fmt.Sprintf("%s/%s/:forward", c.signalingPath(), connID)
 		i := 0
 		for ; i < maxConsecutiveErrors; i++ {
 			rb := c.HTTPHelper.NewPostRequest(path, &forwardMsg)
@@ -211,7 +328,7 @@ func (c *HostOrchestratorServiceImpl) webRTCForward(srcCh chan any, connID strin
 
 func (c *HostOrchestratorServiceImpl) createPolledConnection(device string) (*hoapi.NewConnReply, error) {
 	var res hoapi.NewConnReply
-	rb := c.HTTPHelper.NewPostRequest("/polled_connections", &hoapi.NewConnMsg{DeviceId: device})
+	rb := c.HTTPHelper.NewPostRequest(c.signalingPath(), &hoapi.NewConnMsg{DeviceId: device})
 	if err := rb.JSONResDo(&res); err != nil {
 		return nil, err
 	}
@@ -232,39 +349,242 @@ func (c *HostOrchestratorServiceImpl) waitForOperation(name string, res any, ret
 	return c.HTTPHelper.NewPostRequest(path, nil).JSONResDoWithRetries(res, retryOpts)
 }
 
+func (c *HostOrchestratorServiceImpl) GetOperationLogs(name string) (io.ReadCloser, error) {
+	return c.getStream("/operations/"+name+"/logs", ErrOperationNotFound)
+}
+
+func (c *HostOrchestratorServiceImpl) GetCVDLogs(name, file string) (io.ReadCloser, error) {
+	return c.getStream("/cvds/"+url.PathEscape(name)+"/logs/"+url.PathEscape(file), ErrLogNotFound)
+}
+
+// Returns the body of successful responses, `notFound` if the resource doesn't exist.
+func (c *HostOrchestratorServiceImpl) getStream(path string, notFound error) (io.ReadCloser, error) {
+	res, err := c.HTTPHelper.NewGetRequest(path).Do()
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return res.Body, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, notFound
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	apiErr := &ApiCallError{}
+	if err := json.Unmarshal(b, apiErr); err != nil {
+		return nil, fmt.Errorf("failed decoding unsuccessful response(%d), body: %s, error: %w", res.StatusCode, string(b), err)
+	}
+	return nil, apiErr
+}
+
+type FetchArtifactsOptions struct {
+	// Root endpoint of the build server mirror to fetch from, the host's default build server is
+	// used if empty.
+	BuildAPIBaseURL string
+	// Hint of how many artifacts the host downloads in parallel from the build server, the host
+	// may clamp it. The host's default is used if zero.
+	FetchConcurrency int
+	// Absolute directory of the host the artifacts are stored in, i.e: in a faster or larger disk.
+	// The host rejects directories that don't exist. The host's default is used if empty.
+	ArtifactStorage string
+	// Hint of the bytes per second the host downloads from the build server at most, hosts not
+	// supporting it ignore it. Unlimited if zero.
+	FetchBandwidthLimit int64
+	// How many times the host retries a failed artifact download before failing the fetch,
+	// independent of the retries of the requests to the host. Hosts not supporting it ignore it.
+	// The host's default is used if zero.
+	FetchRetries int
+}
+
+// The host orchestrator's request extended with the fetch options.
+type fetchArtifactsRequest struct {
+	*hoapi.FetchArtifactsRequest
+	BuildAPIBaseURL  string `json:"build_api_base_url,omitempty"`
+	FetchConcurrency int    `json:"fetch_concurrency,omitempty"`
+	ArtifactStorage  string `json:"artifact_storage,omitempty"`
+	// In bytes per second.
+	FetchBandwidthLimit int64 `json:"fetch_bandwidth_limit,omitempty"`
+	FetchRetries        int   `json:"fetch_retries,omitempty"`
+}
+
+// The host orchestrator's response extended with the effective fetch options.
+type FetchArtifactsResult struct {
+	hoapi.FetchArtifactsResponse
+	// Number of artifacts the host downloaded in parallel, zero if it doesn't report it.
+	FetchConcurrency int `json:"fetch_concurrency,omitempty"`
+	// Bytes per second the host limited the download to, zero if it doesn't report it or didn't
+	// limit it.
+	FetchBandwidthLimit int64 `json:"fetch_bandwidth_limit,omitempty"`
+	// Number of failed artifact downloads the host retried, zero if it doesn't report it or none
+	// failed.
+	FetchRetries int `json:"fetch_retries,omitempty"`
+}
+
 func (c *HostOrchestratorServiceImpl) FetchArtifacts(req *hoapi.FetchArtifactsRequest, creds string) (*hoapi.FetchArtifactsResponse, error) {
+	return c.FetchArtifactsWithOptions(req, creds, FetchArtifactsOptions{})
+}
+
+func (c *HostOrchestratorServiceImpl) FetchArtifactsWithOptions(req *hoapi.FetchArtifactsRequest, creds string, options FetchArtifactsOptions) (*hoapi.FetchArtifactsResponse, error) {
+	op, err := c.FetchArtifactsOp(req, creds, options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.WaitForFetchArtifactsOp(op.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &res.FetchArtifactsResponse, nil
+}
+
+func (c *HostOrchestratorServiceImpl) FetchArtifactsOp(req *hoapi.FetchArtifactsRequest, creds string, options FetchArtifactsOptions) (*hoapi.Operation, error) {
 	var op hoapi.Operation
-	rb := c.HTTPHelper.NewPostRequest("/artifacts", req)
+	body := &fetchArtifactsRequest{
+		FetchArtifactsRequest: req,
+		BuildAPIBaseURL:       options.BuildAPIBaseURL,
+		FetchConcurrency:      options.FetchConcurrency,
+		ArtifactStorage:       options.ArtifactStorage,
+		FetchBandwidthLimit:   options.FetchBandwidthLimit,
+		FetchRetries:          options.FetchRetries,
+	}
+	rb := c.HTTPHelper.NewPostRequest("/artifacts", body)
 	if creds != "" {
 		rb.AddHeader(c.BuildAPICredentialsHeader, creds)
 	}
 	if err := rb.JSONResDo(&op); err != nil {
 		return nil, err
 	}
+	return &op, nil
+}
 
-	res := &hoapi.FetchArtifactsResponse{}
-	if err := c.WaitForOperation(op.Name, &res); err != nil {
+// Progress of a fetch operation. The total is zero for hosts that don't report the progress of
+// their fetches.
+type FetchArtifactsProgress struct {
+	Done         bool
+	BytesFetched int64
+	TotalBytes   int64
+}
+
+// The host orchestrator's operation extended with the fetch progress.
+type fetchArtifactsOperation struct {
+	hoapi.Operation
+	Progress *struct {
+		BytesFetched int64 `json:"bytes_fetched"`
+		TotalBytes   int64 `json:"total_bytes"`
+	} `json:"progress,omitempty"`
+}
+
+func (c *HostOrchestratorServiceImpl) GetFetchArtifactsProgress(name string) (*FetchArtifactsProgress, error) {
+	var op fetchArtifactsOperation
+	if err := c.HTTPHelper.NewGetRequest("/operations/" + name).JSONResDo(&op); err != nil {
+		return nil, err
+	}
+	res := &FetchArtifactsProgress{Done: op.Done}
+	if op.Progress != nil {
+		res.BytesFetched = op.Progress.BytesFetched
+		res.TotalBytes = op.Progress.TotalBytes
+	}
+	return res, nil
+}
+
+func (c *HostOrchestratorServiceImpl) WaitForFetchArtifactsOp(name string) (*FetchArtifactsResult, error) {
+	res := &FetchArtifactsResult{}
+	if err := c.WaitForOperation(name, &res); err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 
 func (c *HostOrchestratorServiceImpl) CreateCVD(req *hoapi.CreateCVDRequest, creds string) (*hoapi.CreateCVDResponse, error) {
+	op, err := c.CreateCVDOp(req, creds)
+	if err != nil {
+		return nil, err
+	}
+	return c.WaitForCreateCVDOp(op.Name)
+}
+
+type CreateCVDOptions struct {
+	// Forwarded as is to the host orchestrator for site specific extensions, i.e: a test run id.
+	Metadata map[string]string
+	// Added to the build source of the request's CVD, ignored if the request has no CVD.
+	URLBuildSource *URLBuildSource
+	// Hints for the schedulers of shared fleets, higher priorities are served first. Preemptible
+	// devices are cheaper but may be reclaimed by the fleet.
+	Priority    int
+	Preemptible bool
+	// The host deletes the device this long after it's created, never if zero. Sent in seconds,
+	// hosts not enforcing TTLs ignore it.
+	TTL time.Duration
+}
+
+// Artifacts the host orchestrator downloads from arbitrary http or https URLs, replacing those of
+// the other build sources.
+type URLBuildSource struct {
+	KernelURL    string `json:"kernel_url,omitempty"`
+	InitramfsURL string `json:"initramfs_url,omitempty"`
+}
+
+// The host orchestrator's request extended with the create options.
+type createCVDRequest struct {
+	*hoapi.CreateCVDRequest
+	// Shadows the request's CVD, which is never encoded.
+	CVD         *cvdWithURLBuildSource `json:"cvd,omitempty"`
+	Metadata    map[string]string      `json:"metadata,omitempty"`
+	Priority    int                    `json:"priority,omitempty"`
+	Preemptible bool                   `json:"preemptible,omitempty"`
+	TTLSeconds  int64                  `json:"ttl_seconds,omitempty"`
+}
+
+type cvdWithURLBuildSource struct {
+	*hoapi.CVD
+	BuildSource *buildSourceWithURL `json:"build_source"`
+}
+
+type buildSourceWithURL struct {
+	*hoapi.BuildSource
+	URLBuildSource *URLBuildSource `json:"url_build_source,omitempty"`
+}
+
+func (c *HostOrchestratorServiceImpl) CreateCVDOp(req *hoapi.CreateCVDRequest, creds string) (*hoapi.Operation, error) {
+	return c.CreateCVDOpWithOptions(req, creds, CreateCVDOptions{})
+}
+
+func (c *HostOrchestratorServiceImpl) CreateCVDOpWithOptions(req *hoapi.CreateCVDRequest, creds string, options CreateCVDOptions) (*hoapi.Operation, error) {
 	var op hoapi.Operation
-	rb := c.HTTPHelper.NewPostRequest("/cvds", req)
+	body := &createCVDRequest{
+		CreateCVDRequest: req,
+		Metadata:         options.Metadata,
+		Priority:         options.Priority,
+		Preemptible:      options.Preemptible,
+		TTLSeconds:       int64(options.TTL / time.Second),
+	}
+	if req.CVD != nil {
+		body.CVD = &cvdWithURLBuildSource{CVD: req.CVD}
+		if req.CVD.BuildSource != nil || options.URLBuildSource != nil {
+			body.CVD.BuildSource = &buildSourceWithURL{BuildSource: req.CVD.BuildSource, URLBuildSource: options.URLBuildSource}
+		}
+	}
+	rb := c.HTTPHelper.NewPostRequest("/cvds", body)
 	if creds != "" {
 		rb.AddHeader(c.BuildAPICredentialsHeader, creds)
 	}
 	if err := rb.JSONResDo(&op); err != nil {
 		return nil, err
 	}
+	return &op, nil
+}
+
+func (c *HostOrchestratorServiceImpl) WaitForCreateCVDOp(name string) (*hoapi.CreateCVDResponse, error) {
 	res := &hoapi.CreateCVDResponse{}
 	retryOpts := RetryOptions{
 		StatusCodes: []int{http.StatusServiceUnavailable, http.StatusGatewayTimeout},
 		RetryDelay:  30 * time.Second,
 		MaxWait:     10 * time.Minute,
 	}
-	if err := c.waitForOperation(op.Name, &res, retryOpts); err != nil {
+	if err := c.waitForOperation(name, &res, retryOpts); err != nil {
 		return nil, err
 	}
 	return res, nil
@@ -283,16 +603,66 @@ func (c *HostOrchestratorServiceImpl) DeleteCVD(id string) error {
 	return nil
 }
 
+type CVDDetails struct {
+	hoapi.CVD
+	// Time the device was created in RFC 3339 format, empty if the host doesn't report it. Kept as
+	// reported, an unexpected format doesn't fail the whole listing.
+	CreateTime string `json:"create_time,omitempty"`
+	// Time the host deletes the device in RFC 3339 format, empty if it doesn't expire or the host
+	// doesn't enforce TTLs.
+	ExpireTime string `json:"expire_time,omitempty"`
+	// Serial number the device reports, its ro.serialno. Empty if the host doesn't report it.
+	SerialNumber string `json:"serial_number,omitempty"`
+}
+
+func (c *HostOrchestratorServiceImpl) ExtendCVD(id string, ttl time.Duration) (*CVDDetails, error) {
+	req := struct {
+		TTLSeconds int64 `json:"ttl_seconds"`
+	}{TTLSeconds: int64(ttl / time.Second)}
+	res := &CVDDetails{}
+	if err := c.HTTPHelper.NewPostRequest("/cvds/"+id+"/:extend", &req).JSONResDo(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (c *HostOrchestratorServiceImpl) ListCVDs() ([]*hoapi.CVD, error) {
-	var res hoapi.ListCVDsResponse
-	if err := c.HTTPHelper.NewGetRequest("/cvds").JSONResDo(&res); err != nil {
+	details, err := c.ListCVDDetails()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*hoapi.CVD, len(details))
+	for i, d := range details {
+		result[i] = &d.CVD
+	}
+	return result, nil
+}
+
+// Hosts running a different host orchestrator version may return fields with a different shape,
+// those fields are left unset instead of failing the whole listing. An IncompatibleVersionError is
+// returned if the list itself can't be understood.
+func (c *HostOrchestratorServiceImpl) ListCVDDetails() ([]*CVDDetails, error) {
+	res := struct {
+		CVDs []*CVDDetails `json:"cvds"`
+	}{}
+	err := c.HTTPHelper.NewGetRequest("/cvds").JSONResDo(&res)
+	var typeErr *json.UnmarshalTypeError
+	var apiErr *ApiCallError
+	switch {
+	case err == nil:
+		return res.CVDs, nil
+	case errors.As(err, &typeErr) && typeErr.Field != "" && typeErr.Field != "cvds":
+		// The decoder skips the mismatching field and keeps decoding the rest of the response.
+		return res.CVDs, nil
+	case errors.As(err, &typeErr), errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound:
+		return nil, &IncompatibleVersionError{Err: err}
+	default:
 		return nil, err
 	}
-	return res.CVDs, nil
 }
 
 func (c *HostOrchestratorServiceImpl) DownloadRuntimeArtifacts(dst io.Writer) error {
-	req, err := http.NewRequest("POST", c.HTTPHelper.RootEndpoint+"/runtimeartifacts/:pull", nil)
+	req, err := http.NewRequestWithContext(c.HTTPHelper.ctx(), "POST", c.HTTPHelper.RootEndpoint+"/runtimeartifacts/:pull", nil)
 	if err != nil {
 		return err
 	}
@@ -318,6 +688,27 @@ func (c *HostOrchestratorServiceImpl) CreateUploadDir() (string, error) {
 	return uploadDir.Name, nil
 }
 
+// The host orchestrator's upload directory extended with its size.
+type UploadDir struct {
+	Name string `json:"name"`
+	// Total size of the uploaded files, zero if the host doesn't report it.
+	SizeBytes int64 `json:"size_bytes,omitempty"`
+}
+
+func (c *HostOrchestratorServiceImpl) ListUploads() ([]*UploadDir, error) {
+	res := struct {
+		Items []*UploadDir `json:"items"`
+	}{}
+	if err := c.HTTPHelper.NewGetRequest("/userartifacts").JSONResDo(&res); err != nil {
+		return nil, err
+	}
+	return res.Items, nil
+}
+
+func (c *HostOrchestratorServiceImpl) DeleteUpload(dir string) error {
+	return c.HTTPHelper.NewDeleteRequest("/userartifacts/" + dir).JSONResDo(nil)
+}
+
 func (c *HostOrchestratorServiceImpl) UploadFile(uploadDir string, filename string) error {
 	return c.UploadFileWithOptions(uploadDir, filename, DefaultUploadOptions())
 }
@@ -363,12 +754,27 @@ func (c *HostOrchestratorServiceImpl) ExtractFile(uploadDir string, filename str
 	return result, nil
 }
 
-func asWebRTCICEServers(in []hoapi.IceServer) []webrtc.ICEServer {
+func asWebRTCICEServers(in []apiv1.IceServer) []webrtc.ICEServer {
 	out := []webrtc.ICEServer{}
 	for _, s := range in {
-		out = append(out, webrtc.ICEServer{
-			URLs: s.URLs,
-		})
+		server := webrtc.ICEServer{URLs: s.URLs}
+		if s.Username != "" {
+			server.Username = s.Username
+			server.Credential = s.Credential
+			server.CredentialType = webrtc.ICECredentialTypePassword
+		}
+		out = append(out, server)
 	}
 	return out
 }
+
+// Returns the earliest expiry of the servers' credentials, zero if none expires.
+func iceCredentialsExpireTime(servers []apiv1.IceServer) time.Time {
+	result := time.Time{}
+	for _, s := range servers {
+		if s.CredentialExpireTime != nil && (result.IsZero() || s.CredentialExpireTime.Before(result)) {
+			result = *s.CredentialExpireTime
+		}
+	}
+	return result
+}
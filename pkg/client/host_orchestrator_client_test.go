@@ -15,8 +15,13 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -25,9 +30,11 @@ import (
 	"testing"
 	"time"
 
-	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 
+	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
 	"github.com/google/go-cmp/cmp"
+	"github.com/pion/webrtc/v3"
 )
 
 func TestUploadFileChunkSizeBytesIsZeroPanic(t *testing.T) {
@@ -126,6 +133,59 @@ func TestUploadFileSucceeds(t *testing.T) {
 	}
 }
 
+func TestUploadFileTunesWorkersWithTheFirstChunk(t *testing.T) {
+	host := "foo"
+	uploadDir := "bar"
+	tempDir := createTempDir(t)
+	defer os.RemoveAll(tempDir)
+	quxFile := createTempFile(t, tempDir, "qux", []byte("lorem"))
+	mu := sync.Mutex{}
+	uploaded := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "PUT /hosts/" + host + "/userartifacts/" + uploadDir:
+			uploaded = append(uploaded, r.PostFormValue("chunk_number"))
+			writeOK(w, struct{}{})
+		default:
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv, _ := NewService(&ServiceOptions{RootEndpoint: ts.URL, DumpOut: io.Discard, ChunkSizeBytes: 2})
+	probes := 0
+
+	err := srv.HostService(host).UploadFileWithOptions(uploadDir, quxFile,
+		UploadOptions{
+			BackOffOpts:    ExpBackOffOptions{InitialDuration: 100 * time.Millisecond, Multiplier: 2, MaxElapsedTime: time.Second},
+			ChunkSizeBytes: 2,
+			NumWorkers:     10,
+			TuneWorkers: func(bytesPerSec float64) int {
+				mu.Lock()
+				defer mu.Unlock()
+				probes++
+				if bytesPerSec <= 0 {
+					t.Errorf("expected a positive throughput, got %f", bytesPerSec)
+				}
+				if diff := cmp.Diff([]string{"1"}, uploaded); diff != "" {
+					t.Errorf("expected only the first chunk to be uploaded (-want +got):\n%s", diff)
+				}
+				return 1
+			},
+		})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probes != 1 {
+		t.Errorf("expected a single probe, got %d", probes)
+	}
+	if diff := cmp.Diff([]string{"1", "2", "3"}, uploaded); diff != "" {
+		t.Errorf("uploaded chunks mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestUploadFileExponentialBackoff(t *testing.T) {
 	tempDir := createTempDir(t)
 	defer os.RemoveAll(tempDir)
@@ -204,6 +264,60 @@ func TestUploadFileExponentialBackoffReachedElapsedTime(t *testing.T) {
 	}
 }
 
+func TestUploadFileRejectedChunkIsNotRetried(t *testing.T) {
+	tempDir := createTempDir(t)
+	defer os.RemoveAll(tempDir)
+	waldoFile := createTempFile(t, tempDir, "waldo", []byte("l"))
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = attempts + 1
+		writeErr(w, 400)
+	}))
+	defer ts.Close()
+	opts := &ServiceOptions{
+		RootEndpoint:   ts.URL,
+		DumpOut:        io.Discard,
+		ChunkSizeBytes: 2,
+	}
+	srv, _ := NewService(opts)
+
+	err := srv.HostService("foo").UploadFileWithOptions("dir", waldoFile, UploadOptions{
+		BackOffOpts: ExpBackOffOptions{
+			InitialDuration: 100 * time.Millisecond,
+			Multiplier:      2,
+			MaxElapsedTime:  time.Minute,
+		},
+		ChunkSizeBytes: 2,
+		NumWorkers:     1,
+	})
+
+	if IsTransientUploadError(err) {
+		t.Fatalf("expected a permanent error, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a single attempt, got %d", attempts)
+	}
+}
+
+func TestIsTransientUploadError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&ApiCallError{Code: 503}, true},
+		{&ApiCallError{Code: 429}, true},
+		{&ApiCallError{Code: 404}, false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("error sending request: %w", context.Canceled), false},
+		{&fs.PathError{Op: "open", Path: "foo", Err: fs.ErrNotExist}, false},
+	}
+	for _, tc := range tests {
+		if got := IsTransientUploadError(tc.err); got != tc.want {
+			t.Errorf("IsTransientUploadError(%v) = %t, expected %t", tc.err, got, tc.want)
+		}
+	}
+}
+
 func TestCreateCVD(t *testing.T) {
 	fakeRes := &hoapi.CreateCVDResponse{CVDs: []*hoapi.CVD{{Name: "1"}}}
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -230,6 +344,247 @@ func TestCreateCVD(t *testing.T) {
 	}
 }
 
+func TestCreateCVDOpWithMetadata(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ep := r.Method + " " + r.URL.Path; ep != "POST /cvds" {
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+		req := struct {
+			EnvConfig map[string]any    `json:"env_config"`
+			Metadata  map[string]string `json:"metadata"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.EnvConfig == nil || req.Metadata["test_run"] != "1234" {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+		writeOK(w, hoapi.Operation{Name: "foo"})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.CreateCVDRequest{EnvConfig: map[string]interface{}{}}
+
+	op, err := srv.CreateCVDOpWithOptions(req, "", CreateCVDOptions{Metadata: map[string]string{"test_run": "1234"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Name != "foo" {
+		t.Errorf("unexpected operation: %q", op.Name)
+	}
+}
+
+func TestCreateCVDOpWithPriority(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req["priority"] != float64(10) || req["preemptible"] != true {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+		writeOK(w, hoapi.Operation{Name: "foo"})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.CreateCVDRequest{EnvConfig: map[string]interface{}{}}
+
+	_, err := srv.CreateCVDOpWithOptions(req, "", CreateCVDOptions{Priority: 10, Preemptible: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchArtifactsWithMirror(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "POST /artifacts":
+			req := map[string]any{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			if req["build_api_base_url"] != "https://mirror.example.com" || req["android_ci_bundle"] == nil {
+				t.Fatalf("unexpected request: %v", req)
+			}
+			writeOK(w, hoapi.Operation{Name: "foo"})
+		case "POST /operations/foo/:wait":
+			writeOK(w, &hoapi.FetchArtifactsResponse{})
+		default:
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.FetchArtifactsRequest{AndroidCIBundle: &hoapi.AndroidCIBundle{}}
+
+	_, err := srv.FetchArtifactsWithOptions(req, "", FetchArtifactsOptions{BuildAPIBaseURL: "https://mirror.example.com"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateCVDOpWithTTL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req["ttl_seconds"] != float64(4*3600) {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+		writeOK(w, hoapi.Operation{Name: "foo"})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.CreateCVDRequest{EnvConfig: map[string]interface{}{}}
+
+	_, err := srv.CreateCVDOpWithOptions(req, "", CreateCVDOptions{TTL: 4 * time.Hour})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtendCVD(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ep := r.Method + " " + r.URL.Path; ep != "POST /cvds/cvd-1/:extend" {
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+		req := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req["ttl_seconds"] != float64(3600) {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+		writeOK(w, map[string]any{"name": "cvd-1", "expire_time": "2024-05-02T22:00:00Z"})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+
+	cvd, err := srv.ExtendCVD("cvd-1", time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cvd.ExpireTime != "2024-05-02T22:00:00Z" {
+		t.Errorf("unexpected expire time: %q", cvd.ExpireTime)
+	}
+}
+
+func TestListCVDsToleratesMismatchingFields(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeOK(w, map[string]any{
+			"cvds": []any{map[string]any{"name": "1", "status": map[string]any{"state": "running"}}},
+		})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+
+	cvds, err := srv.ListCVDs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cvds) != 1 || cvds[0].Name != "1" {
+		t.Errorf("unexpected cvds: %+v", cvds)
+	}
+}
+
+func TestListCVDsIncompatibleVersion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeOK(w, map[string]any{"cvds": map[string]any{"1": map[string]any{}}})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+
+	_, err := srv.ListCVDs()
+
+	var verErr *IncompatibleVersionError
+	if !errors.As(err, &verErr) {
+		t.Errorf("expected incompatible version error, got: %v", err)
+	}
+}
+
+func TestGetFetchArtifactsProgress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ep := r.Method + " " + r.URL.Path; ep != "GET /operations/foo" {
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+		writeOK(w, map[string]any{"name": "foo", "progress": map[string]any{"bytes_fetched": 5, "total_bytes": 10}})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+
+	got, err := srv.GetFetchArtifactsProgress("foo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&FetchArtifactsProgress{BytesFetched: 5, TotalBytes: 10}, got); diff != "" {
+		t.Errorf("progress mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetOperationLogs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "GET /operations/foo/logs":
+			w.Write([]byte("fetch failed\n"))
+		case "GET /operations/bar/logs":
+			http.NotFound(w, r)
+		default:
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+
+	logs, err := srv.GetOperationLogs("foo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	b, _ := io.ReadAll(logs)
+	if diff := cmp.Diff("fetch failed\n", string(b)); diff != "" {
+		t.Errorf("logs mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := srv.GetOperationLogs("bar"); !errors.Is(err, ErrOperationNotFound) {
+		t.Errorf("expected operation not found error, got: %v", err)
+	}
+}
+
+func TestGetCVDLogs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "GET /cvds/cvd-1/logs/launcher.log":
+			w.Write([]byte("launched\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+
+	logs, err := srv.GetCVDLogs("cvd-1", "launcher.log")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	b, _ := io.ReadAll(logs)
+	if diff := cmp.Diff("launched\n", string(b)); diff != "" {
+		t.Errorf("logs mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := srv.GetCVDLogs("cvd-1", "kernel.log"); !errors.Is(err, ErrLogNotFound) {
+		t.Errorf("expected log not found error, got: %v", err)
+	}
+}
+
 func createTempDir(t *testing.T) string {
 	dir, err := os.MkdirTemp("", "cvdrTest")
 	if err != nil {
@@ -245,3 +600,353 @@ func createTempFile(t *testing.T, dir, name string, content []byte) string {
 	}
 	return file
 }
+
+func TestCreateCVDOpWithURLBuildSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			CVD struct {
+				BuildSource struct {
+					AndroidCIBuildSource *hoapi.AndroidCIBuildSource `json:"android_ci_build_source"`
+					URLBuildSource       *URLBuildSource             `json:"url_build_source"`
+				} `json:"build_source"`
+			} `json:"cvd"`
+			AdditionalInstancesNum uint32 `json:"additional_instances_num"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		bs := req.CVD.BuildSource
+		if bs.AndroidCIBuildSource == nil || bs.AndroidCIBuildSource.MainBuild.Branch != "main" {
+			t.Errorf("unexpected android ci build source: %+v", bs.AndroidCIBuildSource)
+		}
+		exp := &URLBuildSource{KernelURL: "https://example.com/bzImage"}
+		if diff := cmp.Diff(exp, bs.URLBuildSource); diff != "" {
+			t.Errorf("url build source mismatch (-want +got):\n%s", diff)
+		}
+		if req.AdditionalInstancesNum != 1 {
+			t.Errorf("unexpected additional instances: %d", req.AdditionalInstancesNum)
+		}
+		writeOK(w, hoapi.Operation{Name: "foo"})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.CreateCVDRequest{
+		CVD: &hoapi.CVD{
+			BuildSource: &hoapi.BuildSource{
+				AndroidCIBuildSource: &hoapi.AndroidCIBuildSource{MainBuild: &hoapi.AndroidCIBuild{Branch: "main"}},
+			},
+		},
+		AdditionalInstancesNum: 1,
+	}
+	opts := CreateCVDOptions{URLBuildSource: &URLBuildSource{KernelURL: "https://example.com/bzImage"}}
+
+	if _, err := srv.CreateCVDOpWithOptions(req, "", opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListAndDeleteUploads(t *testing.T) {
+	deleted := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "GET /userartifacts":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"items": [{"name": "foo", "size_bytes": 1024}, {"name": "bar"}]}`))
+		case "DELETE /userartifacts/foo":
+			deleted = "foo"
+			writeOK(w, struct{}{})
+		default:
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+
+	uploads, err := srv.ListUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []*UploadDir{{Name: "foo", SizeBytes: 1024}, {Name: "bar"}}
+	if diff := cmp.Diff(exp, uploads); diff != "" {
+		t.Errorf("uploads mismatch (-want +got):\n%s", diff)
+	}
+	if err := srv.DeleteUpload("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != "foo" {
+		t.Error("expected foo to be deleted")
+	}
+}
+
+func TestCreatePolledConnectionAtSignalingPath(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ep := r.Method + " " + r.URL.Path; ep != "POST /proxy/signaling" {
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+		writeOK(w, hoapi.NewConnReply{ConnId: "conn-1"})
+	}))
+	defer ts.Close()
+	srv := &HostOrchestratorServiceImpl{
+		HTTPHelper:    HTTPHelper{Client: http.DefaultClient, RootEndpoint: ts.URL},
+		SignalingPath: "/proxy/signaling",
+	}
+
+	res, err := srv.createPolledConnection("cvd-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ConnId != "conn-1" {
+		t.Errorf("unexpected connection id: %q", res.ConnId)
+	}
+}
+
+func TestValidateSignalingPath(t *testing.T) {
+	if err := ValidateSignalingPath("/proxy/signaling"); err != nil {
+		t.Error(err)
+	}
+	for _, p := range []string{"", "signaling", "/signaling/", "/signaling?x=1", "/signaling#x", "//host/signaling"} {
+		if err := ValidateSignalingPath(p); err == nil {
+			t.Errorf("%q: expected error", p)
+		}
+	}
+}
+
+func TestFetchArtifactsWithConcurrency(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "POST /artifacts":
+			req := map[string]any{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			if req["fetch_concurrency"] != float64(16) {
+				t.Fatalf("unexpected request: %v", req)
+			}
+			writeOK(w, hoapi.Operation{Name: "foo"})
+		case "POST /operations/foo/:wait":
+			// Clamped by the host.
+			writeOK(w, map[string]any{"android_ci_bundle": map[string]any{}, "fetch_concurrency": 8})
+		default:
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.FetchArtifactsRequest{AndroidCIBundle: &hoapi.AndroidCIBundle{}}
+
+	op, err := srv.FetchArtifactsOp(req, "", FetchArtifactsOptions{FetchConcurrency: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.WaitForFetchArtifactsOp(op.Name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FetchConcurrency != 8 || res.AndroidCIBundle == nil {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestFetchArtifactsWithBandwidthLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "POST /artifacts":
+			req := map[string]any{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			if req["fetch_bandwidth_limit"] != float64(50<<20) {
+				t.Fatalf("unexpected request: %v", req)
+			}
+			writeOK(w, hoapi.Operation{Name: "foo"})
+		case "POST /operations/foo/:wait":
+			writeOK(w, map[string]any{"android_ci_bundle": map[string]any{}, "fetch_bandwidth_limit": 20 << 20})
+		default:
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.FetchArtifactsRequest{AndroidCIBundle: &hoapi.AndroidCIBundle{}}
+
+	op, err := srv.FetchArtifactsOp(req, "", FetchArtifactsOptions{FetchBandwidthLimit: 50 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.WaitForFetchArtifactsOp(op.Name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FetchBandwidthLimit != 20<<20 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestFetchArtifactsWithRetries(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch ep := r.Method + " " + r.URL.Path; ep {
+		case "POST /artifacts":
+			req := map[string]any{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			if req["fetch_retries"] != float64(5) {
+				t.Fatalf("unexpected request: %v", req)
+			}
+			writeOK(w, hoapi.Operation{Name: "foo"})
+		case "POST /operations/foo/:wait":
+			writeOK(w, map[string]any{"android_ci_bundle": map[string]any{}, "fetch_retries": 2})
+		default:
+			t.Fatal("unexpected endpoint: " + ep)
+		}
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.FetchArtifactsRequest{AndroidCIBundle: &hoapi.AndroidCIBundle{}}
+
+	op, err := srv.FetchArtifactsOp(req, "", FetchArtifactsOptions{FetchRetries: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.WaitForFetchArtifactsOp(op.Name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FetchRetries != 2 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestFetchArtifactsOpWithArtifactStorage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req["artifact_storage"] != "/mnt/fast" {
+			t.Fatalf("unexpected request: %v", req)
+		}
+		writeOK(w, hoapi.Operation{Name: "foo"})
+	}))
+	defer ts.Close()
+	srv := NewHostOrchestratorService(ts.URL)
+	req := &hoapi.FetchArtifactsRequest{AndroidCIBundle: &hoapi.AndroidCIBundle{}}
+
+	_, err := srv.FetchArtifactsOp(req, "", FetchArtifactsOptions{ArtifactStorage: "/mnt/fast"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestICEServersKeepTURNCredentials(t *testing.T) {
+	early := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+	servers := []apiv1.IceServer{
+		{URLs: []string{"stun:foo.com"}},
+		{URLs: []string{"turn:bar.com"}, Username: "1714647600:johndoe", Credential: "secret", CredentialExpireTime: &late},
+		{URLs: []string{"turn:baz.com"}, Username: "1714644000:johndoe", Credential: "secret", CredentialExpireTime: &early},
+	}
+
+	got := asWebRTCICEServers(servers)
+
+	want := []webrtc.ICEServer{
+		{URLs: []string{"stun:foo.com"}},
+		{URLs: []string{"turn:bar.com"}, Username: "1714647600:johndoe", Credential: "secret", CredentialType: webrtc.ICECredentialTypePassword},
+		{URLs: []string{"turn:baz.com"}, Username: "1714644000:johndoe", Credential: "secret", CredentialType: webrtc.ICECredentialTypePassword},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ICE servers mismatch (-want +got):\n%s", diff)
+	}
+	if exp := iceCredentialsExpireTime(servers); !exp.Equal(early) {
+		t.Errorf("expected credentials expiring at %v, got: %v", early, exp)
+	}
+}
+
+func TestHostServiceWithContextCancelsRequests(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	srv := HostServiceWithContext(NewHostOrchestratorService(ts.URL), ctx)
+
+	_, err := srv.WaitForCreateCVDOp("foo")
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to be canceled, got: %v", err)
+	}
+}
+
+// Cancels the context once the response to a request is received.
+type cancelingTransport struct {
+	cancel context.CancelFunc
+}
+
+func (t *cancelingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := http.DefaultTransport.RoundTrip(r.WithContext(context.Background()))
+	t.cancel()
+	return res, err
+}
+
+func TestUploadFileCanceledAfterTheLastChunk(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeOK(w, nil)
+	}))
+	defer ts.Close()
+	file := filepath.Join(t.TempDir(), "foo.img")
+	if err := os.WriteFile(file, []byte("foo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := &HostOrchestratorServiceImpl{
+		HTTPHelper: HTTPHelper{
+			Client:       &http.Client{Transport: &cancelingTransport{cancel: cancel}},
+			RootEndpoint: ts.URL,
+			Context:      ctx,
+		},
+	}
+
+	err := srv.UploadFileWithOptions("dir", file, DefaultUploadOptions())
+
+	if err != nil {
+		t.Errorf("expected the completed upload to succeed, got: %v", err)
+	}
+}
+
+func TestUploadFileWithCanceledContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected request")
+	}))
+	defer ts.Close()
+	file := filepath.Join(t.TempDir(), "foo.img")
+	if err := os.WriteFile(file, []byte("foo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	srv := HostServiceWithContext(NewHostOrchestratorService(ts.URL), ctx)
+	opts := DefaultUploadOptions()
+	opts.BackOffOpts.MaxElapsedTime = time.Minute
+
+	start := time.Now()
+	err := srv.UploadFileWithOptions("dir", file, opts)
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("expected the canceled upload not to back off, took: %s", time.Since(start))
+	}
+}
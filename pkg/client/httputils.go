@@ -18,10 +18,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cenkalti/backoff/v4"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/http/httputil"
@@ -39,10 +41,27 @@ type HTTPHelper struct {
 	Dumpster          io.Writer
 	AccessToken       string
 	HTTPBasicUsername string
+	// If not empty, sent in the correlation id header of every request.
+	CorrelationID string
+	// [OPTIONAL] Retries the failures of every request it returns true for, on top of the ones
+	// retried by the request's RetryOptions, see doWithRetries.
+	RetryPredicate RetryPredicate
+	// [OPTIONAL] Cancels the requests, and their retries, once done. Requests aren't canceled if
+	// nil.
+	Context context.Context
+	// [OPTIONAL] Told about every rate limited request before waiting to retry it.
+	OnThrottled ThrottleHook
+}
+
+func (h *HTTPHelper) ctx() context.Context {
+	if h.Context == nil {
+		return context.Background()
+	}
+	return h.Context
 }
 
 func (h *HTTPHelper) NewGetRequest(path string) *HTTPRequestBuilder {
-	req, err := http.NewRequest(http.MethodGet, h.RootEndpoint+path, nil)
+	req, err := http.NewRequestWithContext(h.ctx(), http.MethodGet, h.RootEndpoint+path, nil)
 	return &HTTPRequestBuilder{
 		helper:  h,
 		request: req,
@@ -51,7 +70,7 @@ func (h *HTTPHelper) NewGetRequest(path string) *HTTPRequestBuilder {
 }
 
 func (h *HTTPHelper) NewDeleteRequest(path string) *HTTPRequestBuilder {
-	req, err := http.NewRequest(http.MethodDelete, h.RootEndpoint+path, nil)
+	req, err := http.NewRequestWithContext(h.ctx(), http.MethodDelete, h.RootEndpoint+path, nil)
 	return &HTTPRequestBuilder{
 		helper:  h,
 		request: req,
@@ -68,7 +87,7 @@ func (h *HTTPHelper) NewPostRequest(path string, jsonBody any) *HTTPRequestBuild
 		}
 	}
 	var req *http.Request
-	if req, err = http.NewRequest(http.MethodPost, h.RootEndpoint+path, bytes.NewBuffer(body)); err != nil {
+	if req, err = http.NewRequestWithContext(h.ctx(), http.MethodPost, h.RootEndpoint+path, bytes.NewBuffer(body)); err != nil {
 		return &HTTPRequestBuilder{helper: h, request: nil, err: err}
 	}
 	req.Header.Set("Content-Type", "application/json")
@@ -116,6 +135,13 @@ func (h *HTTPHelper) dumpResponse(r *http.Response) error {
 	return nil
 }
 
+func (h *HTTPHelper) logf(format string, args ...any) {
+	if h.Dumpster == nil || h.Dumpster == io.Discard {
+		return
+	}
+	fmt.Fprintf(h.Dumpster, format, args...)
+}
+
 type HTTPRequestBuilder struct {
 	helper  *HTTPHelper
 	request *http.Request
@@ -145,7 +171,9 @@ func (rb *HTTPRequestBuilder) SetBasicAuth() {
 
 type RetryOptions struct {
 	StatusCodes []int
-	RetryDelay  time.Duration
+	// [OPTIONAL] Retries the failures it returns true for, in addition to the status codes.
+	Retriable  RetryPredicate
+	RetryDelay time.Duration
 	// Keep retrying until the MaxWait threshold is reached out
 	MaxWait time.Duration
 }
@@ -196,27 +224,76 @@ func (rb *HTTPRequestBuilder) doWithRetries(retryOpts RetryOptions) (*http.Respo
 	} else if rb.helper.HTTPBasicUsername != "" {
 		rb.SetBasicAuth()
 	}
+	if rb.helper.CorrelationID != "" {
+		rb.AddHeader(headerNameCorrelationID, rb.helper.CorrelationID)
+	}
 	if rb.err != nil {
 		return nil, rb.err
 	}
 	if err := rb.helper.dumpRequest(rb.request); err != nil {
 		return nil, err
 	}
-	res, err := rb.helper.Client.Do(rb.request)
-	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
+	// The predicates share the request's retry budget, requests without one get the default budget
+	// when the helper has a predicate. Requests whose body can't be sent again are only retried on
+	// their status codes, as before.
+	retriable := retryOpts.Retriable
+	if p := rb.helper.RetryPredicate; p != nil && rb.rewindable() {
+		retriable = AnyRetryPredicate(retriable, p)
+		if retryOpts.MaxWait == 0 {
+			retryOpts.RetryDelay = DefaultPredicateRetryDelay
+			retryOpts.MaxWait = DefaultPredicateRetryMaxWait
+		}
 	}
+	shouldRetry := func(res *http.Response, err error) bool {
+		if err == nil && isIn(res.StatusCode, retryOpts.StatusCodes) {
+			return true
+		}
+		return retriable != nil && retriable(res, err)
+	}
+	// Rate limited requests are retried after the delay the service asks for, as long as it fits
+	// in the request's budget or the default throttle budget if it has none.
+	throttleMaxWait := retryOpts.MaxWait
+	if throttleMaxWait == 0 {
+		throttleMaxWait = DefaultThrottleRetryMaxWait
+	}
+	res, err := rb.helper.Client.Do(rb.request)
 	start := time.Now()
-	for elapsed := 0 * time.Second; elapsed < retryOpts.MaxWait && isIn(res.StatusCode, retryOpts.StatusCodes); elapsed = time.Now().Sub(start) {
-		err = rb.helper.dumpResponse(res)
-		res.Body.Close()
-		if err != nil {
+	for {
+		delay := retryOpts.RetryDelay
+		throttled := isThrottled(res, err) && rb.rewindable()
+		if throttled {
+			delay = rb.throttleDelay(res, retryOpts)
+			if waited := time.Since(start); waited+delay > throttleMaxWait {
+				err = rb.helper.dumpResponse(res)
+				res.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				rb.helper.logf("Rate limited by the service: %s %s, giving up after retrying for %s\n",
+					rb.request.Method, rb.request.URL, waited.Round(time.Millisecond))
+				return nil, &ThrottledError{RetryAfter: delay, Waited: waited}
+			}
+			rb.helper.logf("Rate limited by the service: %s %s, retrying in %s\n", rb.request.Method, rb.request.URL, delay)
+		} else if time.Since(start) >= retryOpts.MaxWait || !shouldRetry(res, err) {
+			break
+		}
+		if res != nil {
+			err = rb.helper.dumpResponse(res)
+			res.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := rb.wait(delay, throttled); err != nil {
 			return nil, err
 		}
-		time.Sleep(retryOpts.RetryDelay)
-		if res, err = rb.helper.Client.Do(rb.request); err != nil {
-			return nil, fmt.Errorf("error sending request: %w", err)
+		if err := rb.rewind(); err != nil {
+			return nil, err
 		}
+		res, err = rb.helper.Client.Do(rb.request)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
 	}
 	if err := rb.helper.dumpResponse(res); err != nil {
 		return nil, err
@@ -227,6 +304,53 @@ func (rb *HTTPRequestBuilder) doWithRetries(retryOpts RetryOptions) (*http.Respo
 	return res, nil
 }
 
+// Waits to retry the request, telling the helper's throttle hook about it if it was rate limited.
+func (rb *HTTPRequestBuilder) wait(delay time.Duration, throttled bool) error {
+	var resume func()
+	if h := rb.helper.OnThrottled; throttled && h != nil {
+		resume = h()
+	}
+	var err error
+	select {
+	case <-rb.request.Context().Done():
+		err = fmt.Errorf("error sending request: %w", rb.request.Context().Err())
+	case <-time.After(delay):
+	}
+	if resume != nil {
+		resume()
+	}
+	return err
+}
+
+// Returns how long to wait before retrying a rate limited request.
+func (rb *HTTPRequestBuilder) throttleDelay(res *http.Response, retryOpts RetryOptions) time.Duration {
+	if d, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+		return d
+	}
+	if retryOpts.RetryDelay > 0 {
+		return retryOpts.RetryDelay
+	}
+	return DefaultThrottleRetryDelay
+}
+
+// Whether the request's body can be sent again.
+func (rb *HTTPRequestBuilder) rewindable() bool {
+	r := rb.request
+	return r == nil || r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+func (rb *HTTPRequestBuilder) rewind() error {
+	if rb.request.GetBody == nil {
+		return nil
+	}
+	body, err := rb.request.GetBody()
+	if err != nil {
+		return fmt.Errorf("error rewinding request body: %w", err)
+	}
+	rb.request.Body = body
+	return nil
+}
+
 // Ideally this would use slices.Contains, but it needs to build with an older go version.
 func isIn(code int, codes []int) bool {
 	for _, c := range codes {
@@ -253,6 +377,10 @@ type UploadOptions struct {
 	BackOffOpts    ExpBackOffOptions
 	ChunkSizeBytes int64
 	NumWorkers     int
+	// If set, the first chunk is uploaded alone and the rest by the number of workers it returns
+	// given the throughput of that chunk in bytes per second. Uploads of a single chunk use
+	// `NumWorkers`.
+	TuneWorkers func(bytesPerSec float64) int
 }
 
 type FilesUploader struct {
@@ -267,23 +395,44 @@ func (u *FilesUploader) Upload(files []string) error {
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(u.HTTPHelper.ctx())
 	// cancel shouldn't be called twice, safeCancel wraps it so that it's safe to do so
 	safeCancel := func() {
 		cancel()
 		cancel = func() {}
 	}
 	defer safeCancel()
+	numWorkers := u.NumWorkers
+	probed := false
+	if u.TuneWorkers != nil && len(infos) > 0 && infos[0].TotalChunks > 1 {
+		throughput, err := u.probeThroughput(ctx, infos[0])
+		if err != nil {
+			fmt.Fprintf(u.DumpOut, "Error uploading file chunk: %v\n", err)
+			return err
+		}
+		numWorkers = u.TuneWorkers(throughput)
+		probed = true
+	}
+	pending := 0
+	for _, info := range infos {
+		pending += info.TotalChunks
+	}
+	if probed {
+		pending--
+	}
 	jobsChan := make(chan uploadChunkJob)
-	resultsChan := u.startWorkers(ctx, jobsChan)
+	resultsChan := u.startWorkers(ctx, jobsChan, numWorkers)
 	go func() {
 		defer close(jobsChan)
-		u.sendJobs(ctx, jobsChan, infos)
+		u.sendJobs(ctx, jobsChan, infos, probed)
 	}()
 	// Only first error will be returned.
 	var returnErr error
+	uploaded := 0
 	for err := range resultsChan {
-		if err != nil {
+		if err == nil {
+			uploaded++
+		} else {
 			fmt.Fprintf(u.DumpOut, "Error uploading file chunk: %v\n", err)
 			if returnErr == nil {
 				returnErr = err
@@ -293,6 +442,10 @@ func (u *FilesUploader) Upload(files []string) error {
 			}
 		}
 	}
+	if returnErr == nil && uploaded < pending {
+		// Canceled along with the helper's context before every chunk was sent.
+		returnErr = ctx.Err()
+	}
 	return returnErr
 }
 
@@ -312,9 +465,33 @@ func (u *FilesUploader) getFilesInfos(files []string) ([]fileInfo, error) {
 	return infos, nil
 }
 
-func (u *FilesUploader) sendJobs(ctx context.Context, jobsChan chan<- uploadChunkJob, infos []fileInfo) {
-	for _, info := range infos {
+// Uploads the first chunk of the file alone, returning the bytes per second it was uploaded at.
+// The chunk is full, the file has more.
+func (u *FilesUploader) probeThroughput(ctx context.Context, info fileInfo) (float64, error) {
+	jobsChan := make(chan uploadChunkJob, 1)
+	jobsChan <- uploadChunkJob{
+		Filename:       info.Name,
+		ChunkNumber:    1,
+		TotalChunks:    info.TotalChunks,
+		ChunkSizeBytes: u.ChunkSizeBytes,
+	}
+	close(jobsChan)
+	start := time.Now()
+	for err := range u.startWorkers(ctx, jobsChan, 1) {
+		if err != nil {
+			return 0, err
+		}
+	}
+	return float64(u.ChunkSizeBytes) / time.Since(start).Seconds(), nil
+}
+
+// Sends the chunks of the files, but the first one if `skipFirst` is true.
+func (u *FilesUploader) sendJobs(ctx context.Context, jobsChan chan<- uploadChunkJob, infos []fileInfo, skipFirst bool) {
+	for f, info := range infos {
 		for i := 0; i < info.TotalChunks; i++ {
+			if skipFirst && f == 0 && i == 0 {
+				continue
+			}
 			job := uploadChunkJob{
 				Filename:       info.Name,
 				ChunkNumber:    i + 1,
@@ -331,10 +508,10 @@ func (u *FilesUploader) sendJobs(ctx context.Context, jobsChan chan<- uploadChun
 	}
 }
 
-func (u *FilesUploader) startWorkers(ctx context.Context, jobsChan <-chan uploadChunkJob) <-chan error {
+func (u *FilesUploader) startWorkers(ctx context.Context, jobsChan <-chan uploadChunkJob, numWorkers int) <-chan error {
 	agg := make(chan error)
 	wg := sync.WaitGroup{}
-	for i := 0; i < u.NumWorkers; i++ {
+	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		w := uploadChunkWorker{
 			Context:       ctx,
@@ -396,8 +573,11 @@ func (w *uploadChunkWorker) Start() <-chan error {
 					b.Reset()
 					break
 				}
+				if !IsTransientUploadError(err) {
+					break
+				}
 				duration := b.NextBackOff()
-				if duration == backoff.Stop {
+				if duration == backoff.Stop || w.Context.Err() != nil {
 					break
 				} else {
 					time.Sleep(duration)
@@ -413,11 +593,14 @@ func (w *uploadChunkWorker) upload(job uploadChunkJob) error {
 	ctx, cancel := context.WithCancel(w.Context)
 	pipeReader, pipeWriter := io.Pipe()
 	writer := multipart.NewWriter(pipeWriter)
+	// Tells failures reading the local file apart from the request's, the request is canceled then.
+	writeErrCh := make(chan error, 1)
 	go func() {
 		defer pipeWriter.Close()
 		defer writer.Close()
 		if err := writeMultipartRequest(writer, job); err != nil {
 			fmt.Fprintf(w.DumpOut, "Error writing multipart request %v", err)
+			writeErrCh <- err
 			cancel()
 		}
 	}()
@@ -440,17 +623,39 @@ func (w *uploadChunkWorker) upload(job uploadChunkJob) error {
 		writer.FormDataContentType()).
 		Do()
 	if err != nil {
-		return err
+		select {
+		case writeErr := <-writeErrCh:
+			return writeErr
+		default:
+			return err
+		}
 	}
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		const msg = "failed uploading file chunk with status code %q. " +
 			"File %q, chunk number: %d, chunk total: %d"
-		return fmt.Errorf(msg, res.Status, filepath.Base(job.Filename), job.ChunkNumber, job.TotalChunks)
+		return &ApiCallError{
+			Code:     res.StatusCode,
+			ErrorMsg: fmt.Sprintf(msg, res.Status, filepath.Base(job.Filename), job.ChunkNumber, job.TotalChunks),
+		}
 	}
 	return nil
 }
 
+// Whether uploading again may succeed where the upload failed with `err`: network errors and
+// server errors may be, unlike missing local files or requests the host rejects.
+func IsTransientUploadError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *ApiCallError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500 || apiErr.Code == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
 func writeMultipartRequest(writer *multipart.Writer, job uploadChunkJob) error {
 	file, err := os.Open(job.Filename)
 	if err != nil {
@@ -85,3 +85,40 @@ func TestRetryLogicMaxWaitElapsed(t *testing.T) {
 		t.Fatal("expected max wait elapsed error")
 	}
 }
+
+func TestRetryLogicHelperPredicate(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		// Retried requests must send the body again.
+		if b, _ := io.ReadAll(r.Body); string(b) != `{"name":"foo"}` {
+			t.Errorf("unexpected body in attempt %d: %q", attempts, string(b))
+		}
+		if attempts < 3 {
+			writeErr(w, http.StatusInternalServerError)
+			return
+		}
+		writeOK(w, &apiv1.HostInstance{Name: "foo"})
+	}))
+	defer ts.Close()
+	helper := HTTPHelper{
+		Client:         &http.Client{},
+		RootEndpoint:   ts.URL,
+		Dumpster:       io.Discard,
+		RetryPredicate: RetryStatusCodes(http.StatusInternalServerError),
+	}
+	retryOpts := RetryOptions{
+		StatusCodes: []int{http.StatusServiceUnavailable},
+		RetryDelay:  1 * time.Millisecond,
+		MaxWait:     1 * time.Minute,
+	}
+
+	err := helper.NewPostRequest("", &apiv1.HostInstance{Name: "foo"}).JSONResDoWithRetries(nil, retryOpts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got: %d", attempts)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry budget of the requests the service rate limits, the requests with a retry budget of their
+// own keep theirs. The delay is used when the service doesn't say how long to wait.
+const (
+	DefaultThrottleRetryDelay   = 1 * time.Second
+	DefaultThrottleRetryMaxWait = 30 * time.Second
+)
+
+// Told about every rate limited response before its request waits to be retried, i.e: to slow
+// down the other requests sent along with it. The returned function, if not nil, is called once
+// the wait is over, before the request is sent again.
+type ThrottleHook func() (resume func())
+
+// Returns the service with its rate limited requests reported to `hook`, or the service as is if
+// it's unable to report them.
+func HostServiceWithThrottleHook(srv HostOrchestratorService, hook ThrottleHook) HostOrchestratorService {
+	if s, ok := srv.(interface {
+		WithThrottleHook(hook ThrottleHook) HostOrchestratorService
+	}); ok {
+		return s.WithThrottleHook(hook)
+	}
+	return srv
+}
+
+// Returned when the service kept rate limiting a request until its retry budget ran out.
+type ThrottledError struct {
+	// How long the service last asked to wait before retrying.
+	RetryAfter time.Duration
+	// How long the request was retried for.
+	Waited time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("the service is rate limiting requests, gave up after retrying for %s, it asked to retry in %s",
+		e.Waited.Round(time.Second), e.RetryAfter.Round(time.Second))
+}
+
+// Parses the Retry-After header, either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+func isThrottled(res *http.Response, err error) bool {
+	return err == nil && res.StatusCode == http.StatusTooManyRequests
+}
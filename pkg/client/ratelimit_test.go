@@ -0,0 +1,145 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{value: "120", want: 2 * time.Minute, wantOk: true},
+		{value: " 0 ", want: 0, wantOk: true},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second, wantOk: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, wantOk: true},
+		{value: ""},
+		{value: "-1"},
+		{value: "soon"},
+	}
+	for _, tc := range tests {
+		got, ok := parseRetryAfter(tc.value, now)
+
+		if got != tc.want || ok != tc.wantOk {
+			t.Errorf("%q: expected (%s, %t), got: (%s, %t)", tc.value, tc.want, tc.wantOk, got, ok)
+		}
+	}
+}
+
+func TestThrottledRequestIsRetried(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Header().Set("Retry-After", "0")
+			writeErr(w, http.StatusTooManyRequests)
+			return
+		}
+		writeOK(w, &apiv1.HostInstance{Name: "foo"})
+	}))
+	defer ts.Close()
+	log := &strings.Builder{}
+	helper := HTTPHelper{
+		Client:       &http.Client{},
+		RootEndpoint: ts.URL,
+		Dumpster:     log,
+	}
+	res := &apiv1.HostInstance{}
+
+	err := helper.NewGetRequest("/hosts/foo").JSONResDo(res)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || res.Name != "foo" {
+		t.Errorf("expected host foo after 3 attempts, got: %+v after %d", res, attempts)
+	}
+	if n := strings.Count(log.String(), "Rate limited by the service"); n != 2 {
+		t.Errorf("expected 2 throttling events logged, got %d in: %q", n, log.String())
+	}
+}
+
+func TestThrottledRequestIsReportedToHook(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.Header().Set("Retry-After", "0")
+			writeErr(w, http.StatusTooManyRequests)
+			return
+		}
+		writeOK(w, &apiv1.HostInstance{Name: "foo"})
+	}))
+	defer ts.Close()
+	throttles, resumes := 0, 0
+	helper := HTTPHelper{
+		Client:       &http.Client{},
+		RootEndpoint: ts.URL,
+		OnThrottled: func() func() {
+			throttles++
+			return func() { resumes++ }
+		},
+	}
+
+	err := helper.NewGetRequest("/hosts/foo").JSONResDo(&apiv1.HostInstance{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if throttles != 2 || resumes != 2 {
+		t.Errorf("expected 2 throttles reported and resumed, got %d and %d", throttles, resumes)
+	}
+}
+
+func TestThrottledRequestExhaustsBudget(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "120")
+		writeErr(w, http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	helper := HTTPHelper{
+		Client:       &http.Client{},
+		RootEndpoint: ts.URL,
+		Dumpster:     io.Discard,
+	}
+
+	err := helper.NewGetRequest("/hosts").JSONResDo(nil)
+
+	var throttledErr *ThrottledError
+	if !errors.As(err, &throttledErr) {
+		t.Fatalf("expected a throttled error, got: %v", err)
+	}
+	if throttledErr.RetryAfter != 2*time.Minute {
+		t.Errorf("expected to be asked to retry in 2m, got: %s", throttledErr.RetryAfter)
+	}
+	// Waiting longer than the budget isn't attempted.
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got: %d", attempts)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Decides whether a failed request is retried. `err` is the error sending the request, `res` is
+// nil then. Otherwise `res` is the response, its body must not be read.
+//
+// Predicates add to the status codes in a request's RetryOptions and share its budget: retries
+// stop once MaxWait elapses, returning the last response or error. Requests without a budget of
+// their own get the default predicate budget.
+type RetryPredicate func(res *http.Response, err error) bool
+
+// Retry budget of the requests retried only because of a RetryPredicate given to the service, the
+// requests with a retry budget of their own keep theirs.
+const (
+	DefaultPredicateRetryDelay   = 2 * time.Second
+	DefaultPredicateRetryMaxWait = 30 * time.Second
+)
+
+// Retries the errors sending the requests that are usually transient: timeouts, refused and reset
+// connections, and connections closed before the response was complete.
+func RetryTransientNetworkErrors(res *http.Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// Retries the responses with any of the status codes, i.e: of a gateway returning 500 for a known
+// transient condition.
+func RetryStatusCodes(codes ...int) RetryPredicate {
+	return func(res *http.Response, err error) bool {
+		return err == nil && isIn(res.StatusCode, codes)
+	}
+}
+
+// Retries the failures any of the predicates retries, nil predicates are skipped.
+func AnyRetryPredicate(preds ...RetryPredicate) RetryPredicate {
+	return func(res *http.Response, err error) bool {
+		for _, p := range preds {
+			if p != nil && p(res, err) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+)
+
+func TestRetryTransientNetworkErrors(t *testing.T) {
+	tests := []struct {
+		err error
+		exp bool
+	}{
+		{err: nil, exp: false},
+		{err: fmt.Errorf("dial: %w", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), exp: true},
+		{err: &net.DNSError{IsTimeout: true}, exp: true},
+		{err: errors.New("certificate signed by unknown authority"), exp: false},
+	}
+	for _, tc := range tests {
+		if got := RetryTransientNetworkErrors(&http.Response{}, tc.err); got != tc.exp {
+			t.Errorf("expected %t for %v, got: %t", tc.exp, tc.err, got)
+		}
+	}
+}
+
+func TestAnyRetryPredicate(t *testing.T) {
+	p := AnyRetryPredicate(nil, RetryStatusCodes(http.StatusBadGateway), RetryTransientNetworkErrors)
+
+	if !p(&http.Response{StatusCode: http.StatusBadGateway}, nil) {
+		t.Error("expected bad gateway responses to be retried")
+	}
+	if p(&http.Response{StatusCode: http.StatusInternalServerError}, nil) {
+		t.Error("expected internal server errors not to be retried")
+	}
+}
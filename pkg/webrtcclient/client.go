@@ -19,8 +19,10 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	wlog "github.com/pion/logging"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -32,6 +34,8 @@ type Signaling struct {
 	// The servers that were created client side and need to be sent to the device.
 	// This is typically a subset of Servers. Ignored if empty.
 	ClientICEServers []webrtc.ICEServer
+	// When the credentials of the ICE servers expire, zero if they don't.
+	ICECredentialsExpireTime time.Time
 }
 
 type Controller struct {
@@ -204,10 +208,48 @@ type Observer interface {
 	OnClose()
 }
 
+// Optionally implemented by observers of connections that request the clipboard data channel.
+type ClipboardObserver interface {
+	// Called when clipboard data channel is added to the peer connection
+	OnClipboardDataChannel(*webrtc.DataChannel)
+}
+
+// Optionally implemented by observers of connections that request the console data channel.
+type ConsoleObserver interface {
+	// Called when console data channel is added to the peer connection
+	OnConsoleDataChannel(*webrtc.DataChannel)
+}
+
+// Optionally implemented by observers of connections that receive the device's video.
+type VideoObserver interface {
+	// Called when a display's video track is received. The `requestKeyFrame` function asks the
+	// device to send a full frame, useful to start decoding the stream.
+	OnVideoTrack(track *webrtc.TrackRemote, requestKeyFrame func() error)
+}
+
+type ConnectionOpts struct {
+	// Whether to create a data channel to synchronize the clipboard with the device. Requires the
+	// observer to implement ClipboardObserver.
+	Clipboard bool
+	// Whether to receive the device's video tracks. Requires the observer to implement
+	// VideoObserver.
+	Video bool
+	// Whether to create a data channel to the device's serial console. Requires the observer to
+	// implement ConsoleObserver.
+	Console bool
+}
+
 type Connection struct {
 	controller Controller
 }
 
+// When the credentials of the connection's ICE servers expire, zero if they don't. Traffic relayed
+// by TURN servers may stop after it, the connection needs to be replaced before with one using
+// fresh credentials.
+func (c *Connection) ICECredentialsExpireTime() time.Time {
+	return c.controller.signaling.ICECredentialsExpireTime
+}
+
 // Connects to a device. Blocks until the connection is established successfully
 // or fails. If the returned error is not nil the Connection should be ignored.
 func NewConnection(signaling *Signaling, observer Observer) (*Connection, error) {
@@ -216,11 +258,45 @@ func NewConnection(signaling *Signaling, observer Observer) (*Connection, error)
 }
 
 func NewConnectionWithLogger(signaling *Signaling, observer Observer, logger io.Writer) (*Connection, error) {
+	return NewConnectionWithOpts(signaling, observer, logger, ConnectionOpts{})
+}
+
+func NewConnectionWithOpts(signaling *Signaling, observer Observer, logger io.Writer, opts ConnectionOpts) (*Connection, error) {
+	var clipboardObserver ClipboardObserver
+	if opts.Clipboard {
+		var ok bool
+		if clipboardObserver, ok = observer.(ClipboardObserver); !ok {
+			return nil, fmt.Errorf("observer does not support the clipboard data channel")
+		}
+	}
+	var videoObserver VideoObserver
+	if opts.Video {
+		var ok bool
+		if videoObserver, ok = observer.(VideoObserver); !ok {
+			return nil, fmt.Errorf("observer does not support video tracks")
+		}
+	}
+	var consoleObserver ConsoleObserver
+	if opts.Console {
+		var ok bool
+		if consoleObserver, ok = observer.(ConsoleObserver); !ok {
+			return nil, fmt.Errorf("observer does not support the console data channel")
+		}
+	}
 	lf := wlog.NewDefaultLoggerFactory()
 	lf.Writer = logger
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(webrtc.SettingEngine{
-		LoggerFactory: lf,
-	}))
+	apiOpts := []func(*webrtc.API){
+		webrtc.WithSettingEngine(webrtc.SettingEngine{LoggerFactory: lf}),
+	}
+	if opts.Video {
+		// Without codecs the device's media tracks are rejected.
+		m := &webrtc.MediaEngine{}
+		if err := m.RegisterDefaultCodecs(); err != nil {
+			return nil, fmt.Errorf("failed to register video codecs: %w", err)
+		}
+		apiOpts = append(apiOpts, webrtc.WithMediaEngine(m))
+	}
+	api := webrtc.NewAPI(apiOpts...)
 	cfg := webrtc.Configuration{
 		SDPSemantics: webrtc.SDPSemanticsUnifiedPlanWithFallback,
 		ICEServers:   signaling.ICEServers,
@@ -233,15 +309,41 @@ func NewConnectionWithLogger(signaling *Signaling, observer Observer, logger io.
 	if err != nil {
 		return nil, fmt.Errorf("failed to create adb data channel: %w", err)
 	}
+	var clipboardChannel *webrtc.DataChannel
+	if opts.Clipboard {
+		clipboardChannel, err = pc.CreateDataChannel("clipboard-channel", nil /*options*/)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create clipboard data channel: %w", err)
+		}
+	}
+	var consoleChannel *webrtc.DataChannel
+	if opts.Console {
+		consoleChannel, err = pc.CreateDataChannel("console-channel", nil /*options*/)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create console data channel: %w", err)
+		}
+	}
 	pc.OnNegotiationNeeded(func() {
 		// TODO(jemoreira): This needs to be handled when unnecessary tracks and
 		// channels are removed from the peer connection.
 	})
 	pc.OnTrack(func(tr *webrtc.TrackRemote, rtpRec *webrtc.RTPReceiver) {
+		if videoObserver != nil && tr.Kind() == webrtc.RTPCodecTypeVideo {
+			videoObserver.OnVideoTrack(tr, func() error {
+				return pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(tr.SSRC())}})
+			})
+			return
+		}
 		// TODO(jemoreira): Remove from the peer connection to save bandwidth.
 	})
 
 	observer.OnADBDataChannel(adbChannel)
+	if clipboardChannel != nil {
+		clipboardObserver.OnClipboardDataChannel(clipboardChannel)
+	}
+	if consoleChannel != nil {
+		consoleObserver.OnConsoleDataChannel(consoleChannel)
+	}
 
 	ret := &Connection{
 		controller: Controller{
@@ -267,3 +369,13 @@ func NewConnectionWithLogger(signaling *Signaling, observer Observer, logger io.
 func (dc *Connection) Close() {
 	dc.controller.peerConnection.Close()
 }
+
+// Returns the statistics of the peer connection, as collected by the webrtc library.
+func (dc *Connection) Stats() webrtc.StatsReport {
+	return dc.controller.peerConnection.GetStats()
+}
+
+// Whether the peer connection is currently established.
+func (dc *Connection) Connected() bool {
+	return dc.controller.peerConnection.ConnectionState() == webrtc.PeerConnectionStateConnected
+}